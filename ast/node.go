@@ -5,7 +5,10 @@ package ast
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/harrisonzhao/soy/data"
 )
@@ -84,15 +87,47 @@ func (t *RawTextNode) String() string {
 	return string(t.Text)
 }
 
+// DelPackageNode is a {delpackage} declaration, which places the delegate
+// templates of a file in a package that may be activated at render time.
+type DelPackageNode struct {
+	Pos
+	Name string
+}
+
+func (c *DelPackageNode) String() string {
+	return "{delpackage " + c.Name + "}"
+}
+
 // NamespaceNode registers the namespace of the soy file.
 type NamespaceNode struct {
 	Pos
 	Name       string
 	Autoescape AutoescapeType
+	Attrs      Attrs // unrecognized attributes, preserved for forward compatibility
+	Defaulted  bool  // Autoescape was not specified, but set to the bundle's default
 }
 
 func (c *NamespaceNode) String() string {
-	return "{namespace " + c.Name + "}"
+	return "{namespace " + c.Name + c.Attrs.String() + "}"
+}
+
+// Attrs holds the attributes of a tag that the parser did not recognize, by
+// name.  They are only retained if the parser is configured to preserve them.
+type Attrs map[string]string
+
+// String returns the attributes in soy source form, sorted by name and each
+// preceded by a space, e.g. ` a="1" b="2"`.
+func (a Attrs) String() string {
+	var names []string
+	for name := range a {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, " %s=%s", name, strconv.Quote(a[name]))
+	}
+	return buf.String()
 }
 
 type AutoescapeType int
@@ -102,6 +137,7 @@ const (
 	AutoescapeOn
 	AutoescapeOff
 	AutoescapeContextual
+	AutoescapeStrict
 )
 
 // TemplateNode holds a template body.
@@ -111,10 +147,46 @@ type TemplateNode struct {
 	Body       *ListNode
 	Autoescape AutoescapeType
 	Private    bool
+	Cacheable  bool             // output may be cached by params (cacheable="true")
+	TTL        time.Duration    // how long cached output is valid, or 0 for unbounded
+	Delegate   bool             // declared by {deltemplate}
+	Variant    string           // variant of a delegate template, or "" for the default
+	Priority   int              // priority of a delegate template over others of its name and variant
+	Package    string           // delegate package of a delegate template, or "" if none
+	Kind       data.ContentKind // kind of the template's content (kind="..."), or "" if unspecified
+	Attrs      Attrs            // unrecognized attributes, preserved for forward compatibility
+}
+
+// ID returns a name that uniquely identifies the template: its name, or for
+// delegate templates (which share names), its name, variant, priority, and
+// delegate package, if any.
+func (n *TemplateNode) ID() string {
+	if !n.Delegate {
+		return n.Name
+	}
+	if n.Package != "" {
+		return fmt.Sprintf("%s:%s:%d:%s", n.Name, n.Variant, n.Priority, n.Package)
+	}
+	return fmt.Sprintf("%s:%s:%d", n.Name, n.Variant, n.Priority)
 }
 
 func (n *TemplateNode) String() string {
-	return fmt.Sprintf("{template %s}\n%s\n{/template}\n", n.Name, n.Body)
+	if n.Delegate {
+		var variant string
+		if n.Variant != "" {
+			variant = fmt.Sprintf(" variant=\"'%s'\"", n.Variant)
+		}
+		return fmt.Sprintf("{deltemplate %s%s%s%s}\n%s\n{/deltemplate}\n", n.Name, variant, n.kindAttr(), n.Attrs, n.Body)
+	}
+	return fmt.Sprintf("{template %s%s%s}\n%s\n{/template}\n", n.Name, n.kindAttr(), n.Attrs, n.Body)
+}
+
+// kindAttr returns the kind attribute of the template, if it has a kind.
+func (n *TemplateNode) kindAttr() string {
+	if n.Kind == "" {
+		return ""
+	}
+	return fmt.Sprintf(" kind=%q", n.Kind)
 }
 
 func (n *TemplateNode) Children() []Node {
@@ -123,14 +195,20 @@ func (n *TemplateNode) Children() []Node {
 
 type SoyDocNode struct {
 	Pos
-	Params []*SoyDocParamNode
+	Params          []*SoyDocParamNode
+	Desc            string // the text preceding the params, if any
+	Deprecated      bool   // the template is marked @deprecated
+	DeprecationNote string // the text following @deprecated, if any
 }
 
 func (n *SoyDocNode) String() string {
-	if len(n.Params) == 0 {
+	if len(n.Params) == 0 && !n.Deprecated {
 		return "\n/** */\n"
 	}
 	var expr = "\n/**"
+	if n.Deprecated {
+		expr += strings.TrimRight("\n * @deprecated "+n.DeprecationNote, " ")
+	}
 	for _, param := range n.Params {
 		expr += "\n * " + param.String()
 	}
@@ -155,6 +233,7 @@ type SoyDocParamNode struct {
 	Pos
 	Name     string // e.g. "name"
 	Optional bool
+	Desc     string // e.g. "The name of the person to say hello to."
 }
 
 func (n *SoyDocParamNode) String() string {
@@ -279,9 +358,13 @@ type LetContentNode struct {
 	Pos
 	Name string
 	Body Node
+	Kind data.ContentKind // kind of the content, or "" if unspecified
 }
 
 func (n *LetContentNode) String() string {
+	if n.Kind != "" {
+		return fmt.Sprintf("{let $%s kind=%q}%s{/let}", n.Name, string(n.Kind), n.Body)
+	}
 	return fmt.Sprintf("{let $%s}%s{/let}", n.Name, n.Body)
 }
 
@@ -300,28 +383,156 @@ func (i *IdentNode) String() string {
 
 type MsgNode struct {
 	Pos
-	Desc string
-	Body Node
+	Desc    string
+	Body    Node
+	Meaning string
+	ID      uint64 // fingerprint of the message content and meaning
+	Attrs   Attrs  // unrecognized attributes, preserved for forward compatibility
 }
 
 func (n *MsgNode) String() string {
-	return fmt.Sprintf("{msg desc=%q}", n.Desc)
+	return fmt.Sprintf("{msg desc=%q%s}", n.Desc, n.Attrs)
 }
 
 func (n *MsgNode) Children() []Node {
 	return []Node{n.Body}
 }
 
+// MsgPlaceholderNode holds a node within a message that is represented by a
+// named placeholder in the extracted message, e.g. a {call} or {print}.
+type MsgPlaceholderNode struct {
+	Pos
+	Name string
+	Body Node
+}
+
+func (n *MsgPlaceholderNode) String() string {
+	return n.Body.String()
+}
+
+func (n *MsgPlaceholderNode) Children() []Node {
+	return []Node{n.Body}
+}
+
+// PluralNode is a {plural} command within a message, which selects the case
+// for the given count, e.g. to render "1 item" or "2 items".
+type PluralNode struct {
+	Pos
+	VarName string // placeholder name of the count, e.g. NUM for $num
+	Value   Node   // the count
+	Cases   []*PluralCaseNode
+	Default Node // body of the {default} case, for any other count
+}
+
+func (n *PluralNode) String() string {
+	var expr = "{plural " + n.Value.String() + "}"
+	for _, pluralCase := range n.Cases {
+		expr += pluralCase.String()
+	}
+	return expr + "{default}" + n.Default.String() + "{/plural}"
+}
+
+func (n *PluralNode) Children() []Node {
+	var nodes = []Node{n.Value}
+	for _, child := range n.Cases {
+		nodes = append(nodes, child)
+	}
+	return append(nodes, n.Default)
+}
+
+// PluralCaseNode is a {case} of a {plural} command, for an exact count.
+type PluralCaseNode struct {
+	Pos
+	Value int64 // the count that selects this case
+	Body  Node
+}
+
+func (n *PluralCaseNode) String() string {
+	return fmt.Sprintf("{case %d}", n.Value) + n.Body.String()
+}
+
+func (n *PluralCaseNode) Children() []Node {
+	return []Node{n.Body}
+}
+
+// SelectNode is a {select} command within a message, which selects the case
+// for the given string value, e.g. to render text according to a gender.
+type SelectNode struct {
+	Pos
+	VarName string // placeholder name of the value, e.g. GENDER for $gender
+	Value   Node
+	Cases   []*SelectCaseNode
+	Default Node // body of the {default} case, for any other value
+}
+
+func (n *SelectNode) String() string {
+	var expr = "{select " + n.Value.String() + "}"
+	for _, selectCase := range n.Cases {
+		expr += selectCase.String()
+	}
+	return expr + "{default}" + n.Default.String() + "{/select}"
+}
+
+func (n *SelectNode) Children() []Node {
+	var nodes = []Node{n.Value}
+	for _, child := range n.Cases {
+		nodes = append(nodes, child)
+	}
+	return append(nodes, n.Default)
+}
+
+// SelectCaseNode is a {case} of a {select} command, for a string value.
+type SelectCaseNode struct {
+	Pos
+	Value string // the value that selects this case
+	Body  Node
+}
+
+func (n *SelectCaseNode) String() string {
+	return fmt.Sprintf("{case '%s'}", n.Value) + n.Body.String()
+}
+
+func (n *SelectCaseNode) Children() []Node {
+	return []Node{n.Body}
+}
+
 type CallNode struct {
 	Pos
-	Name    string
-	AllData bool
-	Data    Node
-	Params  []Node
+	Name              string
+	AllData           bool
+	Data              Node
+	Params            []Node
+	Delegate          bool // a {delcall} to the delegate templates of the given name
+	Variant           Node // expression selecting the delegate's variant, or nil
+	AllowEmptyDefault bool // if no delegate is found, render nothing instead of failing
+	PhName            string // placeholder name of the call within a {msg}, or ""
+	Key               Node   // expression identifying the call's output among its siblings, or nil
+	ErrorFallback     string // how to handle a failure of the callee (e.g. "skip"), or ""
+	Attrs             Attrs  // unrecognized attributes, preserved for forward compatibility
 }
 
 func (n *CallNode) String() string {
-	var expr = fmt.Sprintf("{call %s", n.Name)
+	var cmd = "call"
+	if n.Delegate {
+		cmd = "delcall"
+	}
+	var expr = fmt.Sprintf("{%s %s", cmd, n.Name)
+	if n.Variant != nil {
+		expr += fmt.Sprintf(` variant="%s"`, n.Variant.String())
+	}
+	if n.AllowEmptyDefault {
+		expr += ` allowemptydefault="true"`
+	}
+	if n.PhName != "" {
+		expr += fmt.Sprintf(` phname="%s"`, n.PhName)
+	}
+	if n.Key != nil {
+		expr += fmt.Sprintf(` key="%s"`, n.Key.String())
+	}
+	if n.ErrorFallback != "" {
+		expr += fmt.Sprintf(` errorfallback="%s"`, n.ErrorFallback)
+	}
+	expr += n.Attrs.String()
 	if n.AllData {
 		expr += ` data="all"`
 	} else if n.Data != nil {
@@ -334,12 +545,18 @@ func (n *CallNode) String() string {
 	for _, param := range n.Params {
 		expr += param.String()
 	}
-	return expr + "{/call}"
+	return expr + "{/" + cmd + "}"
 }
 
 func (n *CallNode) Children() []Node {
 	var nodes []Node
 	nodes = append(nodes, n.Data)
+	if n.Variant != nil {
+		nodes = append(nodes, n.Variant)
+	}
+	if n.Key != nil {
+		nodes = append(nodes, n.Key)
+	}
 	for _, child := range n.Params {
 		nodes = append(nodes, child)
 	}
@@ -364,9 +581,13 @@ type CallParamContentNode struct {
 	Pos
 	Key     string
 	Content Node
+	Kind    data.ContentKind // kind of the content, or "" if unspecified
 }
 
 func (n *CallParamContentNode) String() string {
+	if n.Kind != "" {
+		return fmt.Sprintf("{param %s kind=%q}%s{/param}", n.Key, string(n.Kind), n.Content.String())
+	}
 	return fmt.Sprintf("{param %s}%s{/param}", n.Key, n.Content.String())
 }
 
@@ -470,31 +691,41 @@ func (n *SwitchCaseNode) Children() []Node {
 }
 
 // Note:
-// - "For" node is required to have a range() call as the List
-// - "Foreach" node is required to have a DataRefNode as the List
+// - "For" node with a range() call as the List iterates over numbers
+// - otherwise, the List is an expression resolving to a list
 type ForNode struct {
 	Pos
-	Var     string // without the leading $
-	List    Node
-	Body    Node
-	IfEmpty Node
+	Var      string // without the leading $
+	List     Node
+	Body     Node
+	IfEmpty  Node
+	IndexVar string // optional positional index variable, without the leading $
 }
 
 func (n *ForNode) String() string {
-	var _, isForeach = n.List.(*DataRefNode)
 	var name = "for"
-	if isForeach {
+	if !n.IsRange() {
 		name = "foreach"
 	}
 
 	var expr = "{" + name + " "
-	expr += "$" + n.Var + " in " + n.List.String() + "}" + n.Body.String()
+	expr += "$" + n.Var
+	if n.IndexVar != "" {
+		expr += ", $" + n.IndexVar
+	}
+	expr += " in " + n.List.String() + "}" + n.Body.String()
 	if n.IfEmpty != nil {
 		expr += "{ifempty}" + n.IfEmpty.String()
 	}
 	return expr + "{/" + name + "}"
 }
 
+// IsRange returns true if this node iterates over a call to range().
+func (n *ForNode) IsRange() bool {
+	var f, ok = n.List.(*FunctionNode)
+	return ok && f.Name == "range"
+}
+
 func (n *ForNode) Children() []Node {
 	var children = make([]Node, 2, 3)
 	children[0] = n.List
@@ -616,25 +847,34 @@ func (n *MapLiteralNode) String() string {
 		return "[:]"
 	}
 	var expr = "["
-	var first = true
-	for k, v := range n.Items {
-		if !first {
+	for i, k := range n.Keys() {
+		if i > 0 {
 			expr += ", "
 		}
-		expr += fmt.Sprintf("'%s': %s", k, v.String())
-		first = false
+		expr += fmt.Sprintf("'%s': %s", k, n.Items[k].String())
 	}
 	return expr + "]"
 }
 
 func (n *MapLiteralNode) Children() []Node {
 	var nodes []Node
-	for _, v := range n.Items {
-		nodes = append(nodes, v)
+	for _, k := range n.Keys() {
+		nodes = append(nodes, n.Items[k])
 	}
 	return nodes
 }
 
+// Keys returns the keys of the map literal in sorted order, so that output
+// generated from it does not depend on map iteration order.
+func (n *MapLiteralNode) Keys() []string {
+	var keys = make([]string, 0, len(n.Items))
+	for k := range n.Items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // Data References ----------
 
 type DataRefNode struct {
@@ -0,0 +1,276 @@
+package ast
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Walk calls fn for each node of the tree rooted at node, in depth-first
+// order, parents before their children.  If fn returns false, the children of
+// that node are skipped.
+func Walk(node Node, fn func(Node) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+	if parent, ok := node.(ParentNode); ok {
+		for _, child := range parent.Children() {
+			Walk(child, fn)
+		}
+	}
+}
+
+// Rewrite rewrites the tree rooted at node from the bottom up: the children of
+// each node are rewritten first, and then fn is called with the node and
+// returns its replacement, which is one of:
+//
+//   - the node itself, to keep it (it may have been modified in place)
+//   - another node, to replace it, e.g. a folded constant
+//   - a *ListNode, to replace a node within a list by a sequence of nodes,
+//     e.g. a {call} by the body of the called template
+//   - nil, to remove the node
+//
+// Within a list (e.g. the body of a command, the params of a call, or the
+// items of a list literal), the nodes of a replacement *ListNode are spliced
+// into the list in place of the node.  A removed body (e.g. of an {if}
+// condition) is replaced by an empty list.  Rewrite returns an error if a
+// node may not be removed (e.g. an operand of an operator), or if it is
+// replaced by a node of a type that its parent does not allow (e.g. a {case}
+// of a {switch} by a print command).  The tree is left partially rewritten
+// in that case.
+//
+// Rewrite returns the replacement of the root node.
+func Rewrite(node Node, fn func(Node) Node) (Node, error) {
+	var r = rewriter{fn: fn}
+	var result = r.rewrite(node)
+	return result, r.err
+}
+
+// Splice replaces the n nodes of the list starting at index i by the given
+// nodes.  A new slice is always allocated, so that the old slice (e.g. the
+// result of an earlier call to Children, being iterated over) is unchanged.
+func (l *ListNode) Splice(i, n int, nodes ...Node) {
+	var result = make([]Node, 0, len(l.Nodes)-n+len(nodes))
+	result = append(result, l.Nodes[:i]...)
+	result = append(result, nodes...)
+	l.Nodes = append(result, l.Nodes[i+n:]...)
+}
+
+// binaryOp is implemented by the binary operator nodes, which embed a
+// BinaryOpNode.
+type binaryOp interface {
+	binaryOp() *BinaryOpNode
+}
+
+func (n *BinaryOpNode) binaryOp() *BinaryOpNode {
+	return n
+}
+
+// rewriter holds the state of a call to Rewrite.
+type rewriter struct {
+	fn  func(Node) Node
+	err error // the first error encountered
+}
+
+// rewrite rewrites the children of node, and then node itself.
+func (r *rewriter) rewrite(node Node) Node {
+	if node == nil || r.err != nil {
+		return node
+	}
+	switch n := node.(type) {
+	case *SoyFileNode:
+		n.Body = r.nodes(n.Body)
+	case *ListNode:
+		n.Nodes = r.nodes(n.Nodes)
+	case *TemplateNode:
+		if n.Body == nil {
+			break
+		}
+		var body = r.body(n.Body)
+		if list, ok := body.(*ListNode); ok {
+			n.Body = list
+		} else {
+			n.Body = &ListNode{body.Position(), []Node{body}}
+		}
+	case *PrintNode:
+		n.Arg = r.required(n, n.Arg)
+		var directives []*PrintDirectiveNode
+		for _, child := range r.nodes(directiveNodes(n.Directives)) {
+			if d, ok := r.expect(n, child, (*PrintDirectiveNode)(nil)).(*PrintDirectiveNode); ok {
+				directives = append(directives, d)
+			}
+		}
+		n.Directives = directives
+	case *PrintDirectiveNode:
+		n.Args = r.nodes(n.Args)
+	case *CssNode:
+		n.Expr = r.rewrite(n.Expr)
+	case *LogNode:
+		n.Body = r.body(n.Body)
+	case *LetValueNode:
+		n.Expr = r.required(n, n.Expr)
+	case *LetContentNode:
+		n.Body = r.body(n.Body)
+	case *MsgNode:
+		n.Body = r.body(n.Body)
+	case *MsgPlaceholderNode:
+		n.Body = r.required(n, n.Body)
+	case *PluralNode:
+		n.Value = r.required(n, n.Value)
+		var cases []*PluralCaseNode
+		for _, child := range n.Cases {
+			if c, ok := r.expect(n, r.rewrite(child), child).(*PluralCaseNode); ok {
+				cases = append(cases, c)
+			}
+		}
+		n.Cases = cases
+		n.Default = r.body(n.Default)
+	case *PluralCaseNode:
+		n.Body = r.body(n.Body)
+	case *SelectNode:
+		n.Value = r.required(n, n.Value)
+		var cases []*SelectCaseNode
+		for _, child := range n.Cases {
+			if c, ok := r.expect(n, r.rewrite(child), child).(*SelectCaseNode); ok {
+				cases = append(cases, c)
+			}
+		}
+		n.Cases = cases
+		n.Default = r.body(n.Default)
+	case *SelectCaseNode:
+		n.Body = r.body(n.Body)
+	case *CallNode:
+		n.Data = r.rewrite(n.Data)
+		n.Variant = r.rewrite(n.Variant)
+		n.Key = r.rewrite(n.Key)
+		n.Params = r.nodes(n.Params)
+	case *CallParamValueNode:
+		n.Value = r.required(n, n.Value)
+	case *CallParamContentNode:
+		n.Content = r.body(n.Content)
+	case *IfNode:
+		var conds []*IfCondNode
+		for _, child := range n.Conds {
+			if c, ok := r.expect(n, r.rewrite(child), child).(*IfCondNode); ok {
+				conds = append(conds, c)
+			}
+		}
+		n.Conds = conds
+	case *IfCondNode:
+		n.Cond = r.rewrite(n.Cond)
+		n.Body = r.body(n.Body)
+	case *SwitchNode:
+		n.Value = r.required(n, n.Value)
+		var cases []*SwitchCaseNode
+		for _, child := range n.Cases {
+			if c, ok := r.expect(n, r.rewrite(child), child).(*SwitchCaseNode); ok {
+				cases = append(cases, c)
+			}
+		}
+		n.Cases = cases
+	case *SwitchCaseNode:
+		n.Values = r.nodes(n.Values)
+		n.Body = r.body(n.Body)
+	case *ForNode:
+		n.List = r.required(n, n.List)
+		n.Body = r.body(n.Body)
+		n.IfEmpty = r.rewrite(n.IfEmpty)
+	case *FunctionNode:
+		n.Args = r.nodes(n.Args)
+	case *ListLiteralNode:
+		n.Items = r.nodes(n.Items)
+	case *MapLiteralNode:
+		for key, item := range n.Items {
+			if item = r.rewrite(item); item != nil {
+				n.Items[key] = item
+			} else {
+				delete(n.Items, key)
+			}
+		}
+	case *DataRefNode:
+		n.Access = r.nodes(n.Access)
+	case *DataRefExprNode:
+		n.Arg = r.required(n, n.Arg)
+	case *NotNode:
+		n.Arg = r.required(n, n.Arg)
+	case *NegateNode:
+		n.Arg = r.required(n, n.Arg)
+	case *TernNode:
+		n.Arg1 = r.required(n, n.Arg1)
+		n.Arg2 = r.required(n, n.Arg2)
+		n.Arg3 = r.required(n, n.Arg3)
+	case binaryOp:
+		var op = n.binaryOp()
+		op.Arg1 = r.required(node, op.Arg1)
+		op.Arg2 = r.required(node, op.Arg2)
+	}
+	if r.err != nil {
+		return node
+	}
+	return r.fn(node)
+}
+
+// nodes rewrites the given list of nodes, splicing in the nodes of
+// replacement lists and dropping removed nodes.
+func (r *rewriter) nodes(nodes []Node) []Node {
+	var result = make([]Node, 0, len(nodes))
+	for _, child := range nodes {
+		switch replacement := r.rewrite(child).(type) {
+		case nil:
+		case *ListNode:
+			if _, isList := child.(*ListNode); isList {
+				result = append(result, replacement)
+			} else {
+				result = append(result, replacement.Nodes...)
+			}
+		default:
+			result = append(result, replacement)
+		}
+	}
+	return result
+}
+
+// required rewrites the given child of parent, which may not be removed.
+func (r *rewriter) required(parent, child Node) Node {
+	var result = r.rewrite(child)
+	if result == nil && child != nil && r.err == nil {
+		r.err = fmt.Errorf("%v: can not remove %v", parent, child)
+	}
+	if result == nil {
+		return child
+	}
+	return result
+}
+
+// body rewrites the given body of a command, replacing it by an empty list if
+// it is removed.
+func (r *rewriter) body(child Node) Node {
+	if child == nil {
+		return nil
+	}
+	var result = r.rewrite(child)
+	if result == nil {
+		return &ListNode{child.Position(), nil}
+	}
+	return result
+}
+
+// expect returns the given replacement of a child of parent, which must be
+// of the same type as example (or nil, to remove it).
+func (r *rewriter) expect(parent, replacement, example Node) Node {
+	if replacement == nil || r.err != nil {
+		return replacement
+	}
+	if reflect.TypeOf(replacement) != reflect.TypeOf(example) {
+		r.err = fmt.Errorf("%v: can not replace a %T by a %T", parent, example, replacement)
+		return nil
+	}
+	return replacement
+}
+
+func directiveNodes(directives []*PrintDirectiveNode) []Node {
+	var nodes = make([]Node, len(directives))
+	for i, d := range directives {
+		nodes[i] = d
+	}
+	return nodes
+}
@@ -0,0 +1,132 @@
+package ast_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/parse"
+)
+
+func mustParse(t *testing.T, body string) *ast.TemplateNode {
+	var file, err = parse.SoyFile("", "{namespace test}{template .t}"+body+"{/template}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Body[1].(*ast.TemplateNode)
+}
+
+func TestWalk(t *testing.T) {
+	var tmpl = mustParse(t, `{$a}{if $b}{$c}{else}{msg desc=""}{$d}{/msg}{/if}`)
+	var refs []string
+	ast.Walk(tmpl, func(node ast.Node) bool {
+		if ref, ok := node.(*ast.DataRefNode); ok {
+			refs = append(refs, ref.Key)
+		}
+		_, isMsg := node.(*ast.MsgNode)
+		return !isMsg
+	})
+	if strings.Join(refs, ",") != "a,b,c" {
+		t.Errorf("expected refs a,b,c outside of messages, got %v", refs)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	var tests = []struct {
+		body     string
+		fn       func(ast.Node) ast.Node
+		expected string
+		err      string
+	}{
+		{
+			// Constant folding, from the bottom up.
+			`{1 + 2 * 3}{$a + 1}`,
+			func(node ast.Node) ast.Node {
+				if add, ok := node.(*ast.AddNode); ok {
+					var x, ok1 = add.Arg1.(*ast.IntNode)
+					var y, ok2 = add.Arg2.(*ast.IntNode)
+					if ok1 && ok2 {
+						return &ast.IntNode{add.Pos, x.Value + y.Value}
+					}
+				}
+				if mul, ok := node.(*ast.MulNode); ok {
+					return &ast.IntNode{mul.Pos, mul.Arg1.(*ast.IntNode).Value * mul.Arg2.(*ast.IntNode).Value}
+				}
+				return node
+			},
+			`{7}{$a+1}`, "",
+		},
+		{
+			// Inlining, splicing the nodes of a list.
+			`a{call .b /}c{if true}{call .b /}{/if}`,
+			func(node ast.Node) ast.Node {
+				if _, ok := node.(*ast.CallNode); ok {
+					return parse.NewList(parse.NewText("b1"), parse.NewText("b2"))
+				}
+				return node
+			},
+			`ab1b2c{if true}b1b2{/if}`, "",
+		},
+		{
+			// Removal.
+			`a{log}x{/log}{$x|escapeUri|noAutoescape}{if true}{log}y{/log}{/if}`,
+			func(node ast.Node) ast.Node {
+				if _, ok := node.(*ast.LogNode); ok {
+					return nil
+				}
+				if d, ok := node.(*ast.PrintDirectiveNode); ok && d.Name == "noAutoescape" {
+					return nil
+				}
+				return node
+			},
+			`a{$x|escapeUri}{if true}{/if}`, "",
+		},
+		{
+			`{$a + $b}`,
+			func(node ast.Node) ast.Node {
+				if ref, ok := node.(*ast.DataRefNode); ok && ref.Key == "b" {
+					return nil
+				}
+				return node
+			},
+			"", "can not remove $b",
+		},
+		{
+			`{switch $a}{case 1}x{/switch}`,
+			func(node ast.Node) ast.Node {
+				if _, ok := node.(*ast.SwitchCaseNode); ok {
+					return parse.NewText("x")
+				}
+				return node
+			},
+			"", "can not replace a *ast.SwitchCaseNode by a *ast.RawTextNode",
+		},
+	}
+
+	for _, test := range tests {
+		var tmpl = mustParse(t, test.body)
+		var _, err = ast.Rewrite(tmpl, test.fn)
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error %q, got %v", test.body, test.err, err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", test.body, err)
+		case tmpl.Body.String() != test.expected:
+			t.Errorf("%s: expected %s, got %s", test.body, test.expected, tmpl.Body.String())
+		}
+	}
+}
+
+func TestSplice(t *testing.T) {
+	var list = parse.NewList(parse.NewText("a"), parse.NewText("b"), parse.NewText("c"))
+	var old = list.Children()
+	list.Splice(1, 1, parse.NewText("x"), parse.NewText("y"))
+	if list.String() != "axyc" {
+		t.Errorf("expected axyc, got %s", list.String())
+	}
+	if (&ast.ListNode{0, old}).String() != "abc" {
+		t.Errorf("expected the old slice to be unchanged, got %v", old)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/harrisonzhao/soy/ast"
 	"github.com/harrisonzhao/soy/data"
 	"github.com/harrisonzhao/soy/parse"
 	"github.com/harrisonzhao/soy/parsepasses"
@@ -24,9 +25,17 @@ type soyFile struct{ name, content string }
 // Bundle is a collection of soy content (templates and globals).  It acts as
 // input for the soy compiler.
 type Bundle struct {
-	files   []soyFile
-	globals data.Map
-	err     error
+	files      []soyFile
+	globals    data.Map
+	parseOpts  parse.Options
+	autoescape ast.AutoescapeType
+	mapFiles   bool
+	lazy       bool
+	prefetch   bool
+	typeCheck  bool
+	fold       bool
+	bases      []string
+	err        error
 }
 
 // NewBundle returns an empty bundle.
@@ -59,6 +68,13 @@ func (b *Bundle) AddTemplateDir(root string) *Bundle {
 // AddTemplateFile adds the given soy template file text to this bundle.
 // If WatchFiles is on, it will be subsequently watched for updates.
 func (b *Bundle) AddTemplateFile(filename string) *Bundle {
+	if b.mapFiles {
+		var content, err = mapFile(filename)
+		if err != nil {
+			b.err = err
+		}
+		return b.AddTemplateString(filename, content)
+	}
 	content, err := ioutil.ReadFile(filename)
 	if err != nil {
 		b.err = err
@@ -101,17 +117,156 @@ func (b *Bundle) AddGlobalsMap(globals data.Map) *Bundle {
 	return b
 }
 
+// ParseOptions sets the options used to parse the soy files in this bundle.
+// If an Arena is given, it is reset each time the bundle is compiled.
+func (b *Bundle) ParseOptions(opts parse.Options) *Bundle {
+	b.parseOpts = opts
+	return b
+}
+
+// DefaultAutoescape sets the autoescape mode of the namespaces in this bundle
+// that do not specify one, in place of autoescape="true".  It allows a bundle
+// to be migrated to a stricter mode a file at a time: set the default to the
+// new mode, mark the files not yet migrated with the old mode explicitly, and
+// use parsepasses.DefaultAutoescapeFiles to list the files relying on the
+// default.
+func (b *Bundle) DefaultAutoescape(mode ast.AutoescapeType) *Bundle {
+	b.autoescape = mode
+	return b
+}
+
+// MapFiles sets whether the files subsequently added by AddTemplateFile and
+// AddTemplateDir are memory-mapped, rather than read onto the heap.  Mapped
+// files are paged in by the kernel as they are parsed, and may be paged out
+// again under memory pressure.  Combined with LazyParse, this reduces the
+// startup time and memory of bundles with hundreds of megabytes of templates.
+//
+// Mappings are kept for the life of the process; a file added again by a
+// later bundle shares its mapping if it is unchanged, and is read onto the
+// heap if it has been replaced.  Files must not be truncated or rewritten in
+// place while mapped: touching the vanished pages crashes the process with
+// SIGBUS.  On platforms that do not support memory-mapping, the files are
+// read as usual.
+func (b *Bundle) MapFiles(enabled bool) *Bundle {
+	b.mapFiles = enabled
+	return b
+}
+
+// LazyParse defers parsing each soy file of the bundle until one of its
+// templates is first rendered (see template.Registry.AddLazy).  If prefetch is
+// set, the files are parsed in the background after Compile returns, so that
+// few renders wait for them.
+//
+// The templates of each file are checked, and their constants folded, as it
+// is parsed, except for the checks that span files (data references, which
+// depend on the params of the templates called, and inheritance), since they
+// require parsing them all; compile the bundle without LazyParse in tests to
+// check them.  CompileOverlay parses every file, of the bundle and the base.
+func (b *Bundle) LazyParse(prefetch bool) *Bundle {
+	b.lazy, b.prefetch = true, prefetch
+	return b
+}
+
+// TypeCheck sets whether Compile also checks the types of the expressions
+// within the templates, reporting operations on values of the wrong type,
+// e.g. adding a list to a number (see parsepasses.CheckTypes).
+func (b *Bundle) TypeCheck(enabled bool) *Bundle {
+	b.typeCheck = enabled
+	return b
+}
+
+// CheckInheritance sets Compile to check the overrides of the given base
+// templates, i.e. default delegates whose blocks are overridden together,
+// reporting overrides that do not provide all of the blocks of their base
+// (see parsepasses.CheckInheritance).
+func (b *Bundle) CheckInheritance(bases ...string) *Bundle {
+	b.bases = append(b.bases, bases...)
+	return b
+}
+
+// FoldConstants sets whether Compile also evaluates the expressions within
+// the templates that do not depend on the render, e.g. calls of pure
+// functions on constants, replacing them by their values (see
+// parsepasses.FoldConstants), and renders the print commands whose output is
+// then constant to raw text (see parsepasses.PrerenderConstants).
+func (b *Bundle) FoldConstants(enabled bool) *Bundle {
+	b.fold = enabled
+	return b
+}
+
 // Compile parses all of the soy files in this bundle, verifies a number of
-// rules about data references, and returns the completed template registry.
+// rules about data references, and returns the completed template registry,
+// with identical raw text deduplicated (see template.Registry.InternText).
+// Problems found in the soy files are returned as diagnostics (see
+// diag.Errors).
 func (b *Bundle) Compile() (*template.Registry, error) {
+	var registry, err = b.parse()
+	if err != nil {
+		return nil, err
+	}
+	if b.lazy {
+		if b.prefetch {
+			registry.Prefetch()
+		}
+		return registry, nil
+	}
+	if registry, err = b.check(registry); err != nil {
+		return nil, err
+	}
+	if err = b.foldConstants(registry); err != nil {
+		return nil, err
+	}
+	registry.InternText()
+	return registry, nil
+}
+
+// CompileOverlay is like Compile, but compiles the bundle as a layer of
+// templates with the given name over the base registry (see
+// template.Overlay): its templates replace those of base with the same name,
+// and may call the others.  The base registry is not modified, so it may be
+// shared by the overlays of many layers, e.g. one per tenant.
+func (b *Bundle) CompileOverlay(base *template.Registry, layer string) (*template.Registry, error) {
+	var registry, err = b.parse()
+	if err != nil {
+		return nil, err
+	}
+	if err = base.LoadAll(); err != nil {
+		return nil, err
+	}
+	if err = registry.LoadAll(); err != nil {
+		return nil, err
+	}
+	var overlay *template.Registry
+	if overlay, err = b.check(template.Overlay(base, layer, registry)); err != nil {
+		return nil, err
+	}
+	// Only the layer's templates are folded, since base may be shared.
+	if err = b.foldConstants(registry); err != nil {
+		return nil, err
+	}
+	registry.InternText()
+	return overlay, nil
+}
+
+// parse parses all of the soy files in this bundle into a registry.
+func (b *Bundle) parse() (*template.Registry, error) {
 	if b.err != nil {
 		return nil, b.err
 	}
-
-	// Compile all the soy (globals are already parsed)
+	if b.parseOpts.Arena != nil {
+		// The registry is being rebuilt, so its previous trees may be freed.
+		b.parseOpts.Arena.Reset()
+	}
 	var registry = template.Registry{}
 	for _, soyfile := range b.files {
-		var tree, err = parse.SoyFile(soyfile.name, soyfile.content, b.globals)
+		if b.lazy {
+			var soyfile = soyfile
+			registry.AddLazy(soyfile.content, func() (*ast.SoyFileNode, error) {
+				return b.parseLazyFile(soyfile)
+			})
+			continue
+		}
+		var tree, err = b.parseFile(soyfile)
 		if err != nil {
 			return nil, err
 		}
@@ -119,14 +274,97 @@ func (b *Bundle) Compile() (*template.Registry, error) {
 			return nil, err
 		}
 	}
+	return &registry, nil
+}
 
-	// Apply the post-parse processing
-	var err = parsepasses.CheckDataRefs(registry)
+// parseFile parses the given soy file of the bundle.
+func (b *Bundle) parseFile(soyfile soyFile) (*ast.SoyFileNode, error) {
+	var tree, err = parse.SoyFileWith(b.parseOpts, soyfile.name, soyfile.content, b.globals)
 	if err != nil {
 		return nil, err
 	}
+	if b.autoescape != ast.AutoescapeUnspecified {
+		defaultAutoescape(tree, b.autoescape)
+	}
+	return tree, nil
+}
 
-	return &registry, nil
+// parseLazyFile parses the given soy file of a lazily parsed bundle, applying
+// the post-parse processing that is local to the file.
+func (b *Bundle) parseLazyFile(soyfile soyFile) (*ast.SoyFileNode, error) {
+	var tree, err = b.parseFile(soyfile)
+	if err != nil {
+		return nil, err
+	}
+	var registry template.Registry
+	if err = registry.Add(tree); err != nil {
+		return nil, err
+	}
+	if err = b.checkFile(&registry); err != nil {
+		return nil, err
+	}
+	if err = b.foldConstants(&registry); err != nil {
+		return nil, err
+	}
+	registry.InternText()
+	return tree, nil
+}
+
+// defaultAutoescape sets the autoescape mode of the given file's namespace to
+// mode, if it does not specify one.
+func defaultAutoescape(tree *ast.SoyFileNode, mode ast.AutoescapeType) {
+	for _, node := range tree.Body {
+		if ns, ok := node.(*ast.NamespaceNode); ok && ns.Autoescape == ast.AutoescapeUnspecified {
+			ns.Autoescape = mode
+			ns.Defaulted = true
+		}
+	}
+}
+
+// check applies the post-parse processing to the given registry.
+func (b *Bundle) check(registry *template.Registry) (*template.Registry, error) {
+	var err = parsepasses.CheckDataRefs(*registry)
+	if err != nil {
+		return nil, err
+	}
+	if err = b.checkFile(registry); err != nil {
+		return nil, err
+	}
+	if len(b.bases) > 0 {
+		if err = parsepasses.CheckInheritance(*registry, b.bases...); err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// checkFile applies the post-parse processing that checks each template on
+// its own to the given registry.
+func (b *Bundle) checkFile(registry *template.Registry) error {
+	if err := parsepasses.CheckPrintDirectives(*registry); err != nil {
+		return err
+	}
+	if err := parsepasses.CheckContexts(*registry); err != nil {
+		return err
+	}
+	if b.typeCheck {
+		if err := parsepasses.CheckTypes(*registry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// foldConstants folds the constants of the given registry, if enabled.
+func (b *Bundle) foldConstants(registry *template.Registry) error {
+	if !b.fold {
+		return nil
+	}
+	if err := parsepasses.FoldConstants(*registry); err != nil {
+		return err
+	}
+	parsepasses.PrerenderConstants(*registry)
+	return nil
 }
 
 // CompileToTofu returns a soyhtml.Tofu object that allows you to render soy
@@ -136,3 +374,24 @@ func (b *Bundle) CompileToTofu() (*soyhtml.Tofu, error) {
 	// TODO: Verify all used funcs exist and have the right # args.
 	return soyhtml.NewTofu(registry), err
 }
+
+// Rename renames the template or namespace named from to in the bundle's soy
+// files, updating its declaration and all references to it (see parse.Rename).
+// It returns the new content of the files that changed, by filename.  The
+// bundle itself is not modified.
+func (b *Bundle) Rename(from, to string) (map[string]string, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	var result = make(map[string]string)
+	for _, soyfile := range b.files {
+		var renamed, err = parse.Rename(b.parseOpts, soyfile.name, soyfile.content, b.globals, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if renamed != soyfile.content {
+			result[soyfile.name] = renamed
+		}
+	}
+	return result, nil
+}
@@ -33,8 +33,26 @@ func NewWith(convert StructOptions, value interface{}) Value {
 		return mar.MarshalValue()
 	}
 
-	// drill through pointers and interfaces to the underlying type
+	// functions computing values are resolved when first used (see Lazy)
+	switch fn := value.(type) {
+	case func() Value:
+		return NewLazyValue(fn)
+	case func() (Value, error):
+		return NewLazy(fn)
+	}
+
+	// nil pointers are null, whatever they point to
 	var v = reflect.ValueOf(value)
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return Null{}
+	}
+
+	// see if value is safe content from the safehtml package
+	if content, ok := safeTypeContent(value); ok {
+		return content
+	}
+
+	// drill through pointers and interfaces to the underlying type
 	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
 		v = v.Elem()
 	}
@@ -87,10 +105,22 @@ var DefaultStructOptions = StructOptions{
 // StructOptions provides flexibility in conversion of structs to soy's
 // data.Map format.
 type StructOptions struct {
-	LowerCamel bool   // if true, convert field names to lowerCamel.
-	TimeFormat string // format string for time.Time. (if empty, use ISO-8601)
+	LowerCamel bool      // if true, convert field names to lowerCamel.
+	TimeFormat string    // format string for time.Time. (if empty, use ISO-8601)
+	Keys       KeyNaming // naming of map keys; if unspecified, per LowerCamel.
 }
 
+// KeyNaming is the convention by which struct field names are converted to
+// map keys.
+type KeyNaming int
+
+const (
+	KeysUnspecified KeyNaming = iota // lowerCamel if LowerCamel, else verbatim
+	KeysLowerCamel                   // e.g. FirstName => firstName, UserID => userID
+	KeysSnakeCase                    // e.g. FirstName => first_name, UserID => user_id
+	KeysVerbatim                     // e.g. FirstName => FirstName
+)
+
 func (c StructOptions) Data(obj interface{}) Map {
 	var m = make(map[string]Value)
 	var v = reflect.ValueOf(obj)
@@ -99,14 +129,47 @@ func (c StructOptions) Data(obj interface{}) Map {
 		if !v.Field(i).CanInterface() {
 			continue
 		}
-		var key = valType.Field(i).Name
+		m[c.Key(valType.Field(i).Name)] = NewWith(c, v.Field(i).Interface())
+	}
+	return Map(m)
+}
+
+// Key returns the map key that the struct field of the given name is converted
+// to.
+func (c StructOptions) Key(fieldName string) string {
+	var naming = c.Keys
+	if naming == KeysUnspecified {
+		naming = KeysVerbatim
 		if c.LowerCamel {
-			var firstRune, size = utf8.DecodeRuneInString(key)
-			key = string(unicode.ToLower(firstRune)) + key[size:]
+			naming = KeysLowerCamel
 		}
-		m[key] = NewWith(c, v.Field(i).Interface())
 	}
-	return Map(m)
+	switch naming {
+	case KeysLowerCamel:
+		var firstRune, size = utf8.DecodeRuneInString(fieldName)
+		return string(unicode.ToLower(firstRune)) + fieldName[size:]
+	case KeysSnakeCase:
+		return snakeCase(fieldName)
+	}
+	return fieldName
+}
+
+// snakeCase converts the given field name to snake_case, treating a run of
+// capitals as a single word (an initialism), e.g. HTMLBody => html_body.
+func snakeCase(name string) string {
+	var runes = []rune(name)
+	var buf = make([]rune, 0, len(runes)+4)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			var prev = runes[i-1]
+			var nextLower = i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || unicode.IsUpper(prev) && nextLower {
+				buf = append(buf, '_')
+			}
+		}
+		buf = append(buf, unicode.ToLower(r))
+	}
+	return string(buf)
 }
 
 // Marshaler is the interface implemented by entities that can marshal
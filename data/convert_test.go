@@ -135,6 +135,7 @@ func TestStructOptions(t *testing.T) {
 				Map{
 					"lowerCamel": Bool(true),
 					"timeFormat": String(time.RFC3339),
+					"keys":       Int(0),
 				},
 				Bool(true),
 				Null{},
@@ -152,10 +153,11 @@ func TestStructOptions(t *testing.T) {
 				"Struct": Map{
 					"lowerCamel": Bool(true),
 					"timeFormat": String(time.RFC3339),
+					"keys":       Int(0),
 				}},
 		}},
 
-		{testStruct, StructOptions{false, time.Stamp}, Map{
+		{testStruct, StructOptions{false, time.Stamp, KeysUnspecified}, Map{
 			"CaseFormat": Int(5),
 			"Time":       String(jan1.Format(time.Stamp)),
 			"Nested": Map{
@@ -168,6 +170,7 @@ func TestStructOptions(t *testing.T) {
 				Map{
 					"LowerCamel": Bool(true),
 					"TimeFormat": String(time.RFC3339),
+					"Keys":       Int(0),
 				},
 				Bool(true),
 				Null{},
@@ -185,6 +188,7 @@ func TestStructOptions(t *testing.T) {
 				"Struct": Map{
 					"LowerCamel": Bool(true),
 					"TimeFormat": String(time.RFC3339),
+					"Keys":       Int(0),
 				}},
 		}},
 	}
@@ -197,6 +201,37 @@ func TestStructOptions(t *testing.T) {
 	}
 }
 
+func TestKeyNaming(t *testing.T) {
+	var fields = []string{"Name", "FirstName", "UserID", "HTMLBody", "Line2", "A"}
+	var tests = []struct {
+		opts     StructOptions
+		expected []string
+	}{
+		{StructOptions{}, fields},
+		{StructOptions{LowerCamel: true}, []string{"name", "firstName", "userID", "hTMLBody", "line2", "a"}},
+		{StructOptions{Keys: KeysLowerCamel}, []string{"name", "firstName", "userID", "hTMLBody", "line2", "a"}},
+		{StructOptions{Keys: KeysSnakeCase}, []string{"name", "first_name", "user_id", "html_body", "line2", "a"}},
+		{StructOptions{LowerCamel: true, Keys: KeysSnakeCase}, []string{"name", "first_name", "user_id", "html_body", "line2", "a"}},
+		{StructOptions{LowerCamel: true, Keys: KeysVerbatim}, fields},
+	}
+	for _, test := range tests {
+		for i, field := range fields {
+			if actual := test.opts.Key(field); actual != test.expected[i] {
+				t.Errorf("%+v: %v => %v, expected %v", test.opts, field, actual, test.expected[i])
+			}
+		}
+	}
+
+	var user = struct {
+		FirstName string
+		UserID    int
+	}{"Rob", 5}
+	var expected = Map{"first_name": String("Rob"), "user_id": Int(5)}
+	if actual := NewWith(StructOptions{Keys: KeysSnakeCase}, user); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
 func BenchmarkStructOptions(b *testing.B) {
 	var testStruct = struct {
 		CaseFormat int
@@ -249,3 +284,30 @@ func BenchmarkStructOptions(b *testing.B) {
 func pInt(i int) *int {
 	return &i
 }
+
+type safeURL struct{ url string }
+
+func (u safeURL) String() string { return u.url }
+
+func TestNewSafeTypes(t *testing.T) {
+	safeTypeKinds[reflect.TypeOf(safeURL{}).PkgPath()+".safeURL"] = KindURI
+	defer delete(safeTypeKinds, reflect.TypeOf(safeURL{}).PkgPath()+".safeURL")
+	defer safeTypes.Delete(reflect.TypeOf(safeURL{}))
+	defer safeTypes.Delete(reflect.TypeOf(&safeURL{}))
+
+	var tests = []struct {
+		input    interface{}
+		expected Value
+	}{
+		{safeURL{"tel:555"}, SanitizedContent{KindURI, "tel:555"}},
+		{&safeURL{"tel:555"}, SanitizedContent{KindURI, "tel:555"}},
+		{(*safeURL)(nil), Null{}},
+		{SafeURL("tel:555"), SanitizedContent{KindURI, "tel:555"}},
+		{TrustedResourceURL("/a.js"), SanitizedContent{KindTrustedResourceURI, "/a.js"}},
+	}
+	for _, test := range tests {
+		if actual := New(test.input); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%#v: expected %#v, got %#v", test.input, test.expected, actual)
+		}
+	}
+}
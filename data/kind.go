@@ -0,0 +1,17 @@
+package data
+
+// ContentKind identifies a kind of content, for the purposes of escaping.
+// Content of a given kind is safe to include in the corresponding context
+// without further escaping.
+type ContentKind string
+
+const (
+	KindText               ContentKind = "text"                 // plain text, requiring escaping in any context
+	KindHTML               ContentKind = "html"                 // HTML markup
+	KindAttributes         ContentKind = "attributes"           // attribute name/value pairs within an HTML tag
+	KindURI                ContentKind = "uri"                  // a URI or URI component
+	KindTrustedResourceURI ContentKind = "trusted_resource_uri" // a URI of a resource that may be loaded as code, e.g. a script
+	KindJS                 ContentKind = "js"                   // javascript code, e.g. an expression
+	KindJSStrChars         ContentKind = "js_str_chars"         // characters escaped for use within a javascript string literal
+	KindCSS                ContentKind = "css"                  // CSS rules or property values
+)
@@ -0,0 +1,79 @@
+package data
+
+import "sync"
+
+// Lazy is a value that is computed when it is first used, for example by
+// fetching it from a backend.  Resolution may be started ahead of use (see
+// Start), so that fetching the data overlaps with rendering earlier output.
+//
+// Renderers resolve Lazy values as they are looked up, so templates see the
+// resolved value rather than the Lazy itself.
+//
+// New converts functions of type func() Value and func() (Value, error) to
+// Lazy values, so that e.g. the fields of a struct passed as template data
+// may be expensive lookups that are skipped unless a template uses them.
+type Lazy struct {
+	fn   func() (Value, error)
+	once sync.Once
+	val  Value
+	err  error
+}
+
+// NewLazy returns a value that is resolved by calling fn at most once.
+func NewLazy(fn func() (Value, error)) *Lazy {
+	return &Lazy{fn: fn}
+}
+
+// NewLazyValue returns a value that is resolved by calling fn at most once,
+// for computations that can not fail.
+func NewLazyValue(fn func() Value) *Lazy {
+	return NewLazy(func() (Value, error) { return fn(), nil })
+}
+
+// Start begins resolving the value in a new goroutine, if resolution has not
+// already begun.
+func (v *Lazy) Start() {
+	go v.once.Do(v.resolve)
+}
+
+// Resolve returns the value, computing it if necessary.  If resolution is in
+// progress in another goroutine, it waits for that to complete.
+func (v *Lazy) Resolve() (Value, error) {
+	v.once.Do(v.resolve)
+	return v.val, v.err
+}
+
+func (v *Lazy) resolve() {
+	v.val, v.err = v.fn()
+	if v.val == nil {
+		v.val = Undefined{}
+	}
+}
+
+// resolved returns the value, or Undefined if it failed to resolve.
+func (v *Lazy) resolved() Value {
+	var val, _ = v.Resolve()
+	return val
+}
+
+func (v *Lazy) Truthy() bool            { return v.resolved().Truthy() }
+func (v *Lazy) String() string          { return v.resolved().String() }
+func (v *Lazy) Equals(other Value) bool { return v.resolved().Equals(other) }
+
+// StartAll starts the resolution of every Lazy value found within the given
+// value, descending into lists and maps.  The contents of Lazy values are not
+// examined, since that would require waiting for them to resolve.
+func StartAll(val Value) {
+	switch val := val.(type) {
+	case *Lazy:
+		val.Start()
+	case List:
+		for _, item := range val {
+			StartAll(item)
+		}
+	case Map:
+		for _, item := range val {
+			StartAll(item)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// SanitizedContent is content that is known to be safe to include, without
+// further escaping, in a context of the given kind.  For example, the result
+// of a {let kind="html"} block.
+type SanitizedContent struct {
+	Kind    ContentKind
+	Content string
+}
+
+func (v SanitizedContent) Truthy() bool   { return v.Content != "" }
+func (v SanitizedContent) String() string { return v.Content }
+
+func (v SanitizedContent) Equals(other Value) bool {
+	if o, ok := other.(SanitizedContent); ok {
+		return v == o
+	}
+	return false
+}
+
+// MarshalJSON encodes the content as a JSON string.
+func (v SanitizedContent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.Content)
+}
+
+// SafeURL returns the given URL as sanitized content, asserting that it is safe
+// to navigate to (e.g. in an href) without filtering its scheme.
+func SafeURL(url string) SanitizedContent {
+	return SanitizedContent{KindURI, url}
+}
+
+// TrustedResourceURL returns the given URL as sanitized content, asserting that
+// the resource it refers to is under the application's control and may be
+// loaded as code (e.g. in a script src).
+func TrustedResourceURL(url string) SanitizedContent {
+	return SanitizedContent{KindTrustedResourceURI, url}
+}
+
+// safeTypeKinds maps the names of types from Google's safehtml package to the
+// kind of content they represent.
+var safeTypeKinds = map[string]ContentKind{
+	"github.com/google/safehtml.HTML":               KindHTML,
+	"github.com/google/safehtml.URL":                KindURI,
+	"github.com/google/safehtml.TrustedResourceURL": KindTrustedResourceURI,
+	"github.com/google/safehtml.Script":             KindJS,
+	"github.com/google/safehtml.Style":              KindCSS,
+	"github.com/google/safehtml.StyleSheet":         KindCSS,
+}
+
+// safeTypes caches the kind of content represented by each type converted,
+// or "" if it is not one of the safehtml package's types, by reflect.Type.
+var safeTypes sync.Map
+
+// safeTypeContent returns the sanitized content represented by the given
+// non-nil value, if it is one of the safehtml package's types.  Those are
+// recognized by name, so that this package does not depend on safehtml.
+func safeTypeContent(value interface{}) (SanitizedContent, bool) {
+	var typ = reflect.TypeOf(value)
+	var cached, ok = safeTypes.Load(typ)
+	if !ok {
+		var named = typ
+		if named.Kind() == reflect.Ptr {
+			named = named.Elem()
+		}
+		cached = safeTypeKinds[named.PkgPath()+"."+named.Name()]
+		safeTypes.Store(typ, cached)
+	}
+	var kind = cached.(ContentKind)
+	if kind == "" {
+		return SanitizedContent{}, false
+	}
+	var str, isStringer = value.(fmt.Stringer)
+	if !isStringer {
+		return SanitizedContent{}, false
+	}
+	return SanitizedContent{kind, str.String()}, true
+}
@@ -157,3 +157,11 @@ func (v Float) Equals(other Value) bool {
 	}
 	return false
 }
+
+// JSON ----------
+
+// MarshalJSON encodes undefined as null, as JSON has no undefined.
+func (v Undefined) MarshalJSON() ([]byte, error) { return []byte("null"), nil }
+
+// MarshalJSON encodes null.
+func (v Null) MarshalJSON() ([]byte, error) { return []byte("null"), nil }
@@ -15,6 +15,7 @@ var (
 	_ Value = String("")
 	_ Value = List{}
 	_ Value = Map{}
+	_ Value = SanitizedContent{}
 )
 
 func TestKey(t *testing.T) {
@@ -0,0 +1,117 @@
+// Package diag defines the diagnostics reported by the parser, the checking
+// passes, and compilation, so that editor and CI integrations may consume them
+// in a consistent, machine-readable form (e.g. SARIF, see WriteSARIF).
+//
+// Diagnostics implement error, so the functions returning them continue to
+// return error; use Errors to recover the diagnostics from such an error.
+package diag
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Severity is the severity of a diagnostic.
+type Severity int
+
+const (
+	Error   Severity = iota // the input is invalid, and compilation fails
+	Warning                 // the input is valid, but likely to be a mistake
+	Note                    // informational, e.g. a style suggestion
+)
+
+var severityNames = []string{"error", "warning", "note"}
+
+func (s Severity) String() string {
+	if int(s) < len(severityNames) {
+		return severityNames[s]
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Position is a position within a soy file.  Lines and columns are numbered
+// from 1; zero means unknown.
+type Position struct {
+	Line int
+	Col  int
+}
+
+// Range is a range of a soy file.  End is zero if only the start is known.
+type Range struct {
+	Start Position
+	End   Position
+}
+
+// Fix is a suggested fix for a diagnostic, replacing a range of its file.
+type Fix struct {
+	Description string
+	Range       Range
+	Replacement string
+}
+
+// Diagnostic is a problem found in a soy file.
+type Diagnostic struct {
+	Severity Severity
+	Code     string // identifies the kind of problem, e.g. "parse" or "dataref"
+	Message  string
+	File     string // name of the soy file, or "" if unknown
+	Template string // fully-qualified name of the template, or "" if unknown
+	Range    Range
+	Fixes    []Fix // suggested fixes, best first
+}
+
+// Error returns the diagnostic in the format of the parser's errors, e.g.
+//
+//	template test.soy:12:5: unexpected "}" in print command
+//
+// If the position is unknown, the template's name is given in its place.
+func (d *Diagnostic) Error() string {
+	var start = d.Range.Start
+	switch {
+	case d.File != "" || d.Template == "" && start.Line > 0:
+		var loc = d.File
+		if start.Line > 0 {
+			loc += fmt.Sprintf(":%d", start.Line)
+			if start.Col > 0 {
+				loc += fmt.Sprintf(":%d", start.Col)
+			}
+		}
+		return "template " + loc + ": " + d.Message
+	case d.Template != "":
+		return "template " + d.Template + ": " + d.Message
+	}
+	return d.Message
+}
+
+// List is a list of diagnostics, returned as an error by the passes that
+// report every problem found rather than only the first.
+type List []*Diagnostic
+
+// Error returns the diagnostics' errors, one per line.
+func (l List) Error() string {
+	var msgs = make([]string, len(l))
+	for i, d := range l {
+		msgs[i] = d.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Errors returns the diagnostics of the given error, which is either a
+// *Diagnostic or a List, possibly wrapped.  Any other error (e.g. a failure to
+// read a file) is returned as a diagnostic with the code "error" and the
+// error's text as its message.  It returns nil if err is nil.
+func Errors(err error) []*Diagnostic {
+	if err == nil {
+		return nil
+	}
+	var list List
+	if errors.As(err, &list) {
+		return list
+	}
+	var d *Diagnostic
+	if errors.As(err, &d) {
+		return []*Diagnostic{d}
+	}
+	return []*Diagnostic{{Severity: Error, Code: "error", Message: err.Error()}}
+}
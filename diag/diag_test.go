@@ -0,0 +1,124 @@
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	var tests = []struct {
+		diag     Diagnostic
+		expected string
+	}{
+		{Diagnostic{Message: "oops"}, "oops"},
+		{Diagnostic{Message: "oops", File: "a.soy"}, "template a.soy: oops"},
+		{Diagnostic{Message: "oops", File: "a.soy", Range: Range{Start: Position{3, 0}}}, "template a.soy:3: oops"},
+		{Diagnostic{Message: "oops", File: "a.soy", Template: "a.b", Range: Range{Start: Position{3, 5}}}, "template a.soy:3:5: oops"},
+		{Diagnostic{Message: "oops", Template: "a.b", Range: Range{Start: Position{3, 5}}}, "template a.b: oops"},
+		{Diagnostic{Message: "oops", Range: Range{Start: Position{3, 5}}}, "template :3:5: oops"},
+	}
+	for _, test := range tests {
+		if actual := test.diag.Error(); actual != test.expected {
+			t.Errorf("%+v: expected %q, got %q", test.diag, test.expected, actual)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var d1 = &Diagnostic{Code: "a", Message: "one"}
+	var d2 = &Diagnostic{Code: "b", Message: "two"}
+	var tests = []struct {
+		err      error
+		expected []*Diagnostic
+	}{
+		{nil, nil},
+		{d1, []*Diagnostic{d1}},
+		{fmt.Errorf("wrapped: %w", d1), []*Diagnostic{d1}},
+		{List{d1, d2}, []*Diagnostic{d1, d2}},
+		{errors.New("plain"), []*Diagnostic{{Severity: Error, Code: "error", Message: "plain"}}},
+	}
+	for _, test := range tests {
+		if actual := Errors(test.err); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.err, test.expected, actual)
+		}
+	}
+	if actual := (List{d1, d2}).Error(); actual != "one\ntwo" {
+		t.Errorf("unexpected list error: %q", actual)
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	var diags = []*Diagnostic{
+		{Error, "parse", "unexpected }", "a.soy", "", Range{Start: Position{3, 5}}, nil},
+		{Warning, "deprecated", "call to deprecated template a.old", "a.soy", "a.page",
+			Range{Start: Position{Line: 7}},
+			[]Fix{{"call a.new instead", Range{Position{7, 9}, Position{7, 15}}, "a.new"}}},
+		{Error, "error", "no such file", "", "", Range{}, nil},
+	}
+	var buf bytes.Buffer
+	if err := WriteSARIF(&buf, diags); err != nil {
+		t.Fatal(err)
+	}
+
+	var actual, expected interface{}
+	if err := json.Unmarshal(buf.Bytes(), &actual); err != nil {
+		t.Fatal(err)
+	}
+	var err = json.Unmarshal([]byte(`{
+  "version": "2.1.0",
+  "$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+  "runs": [{
+    "tool": {"driver": {"name": "soy", "rules": [{"id": "deprecated"}, {"id": "error"}, {"id": "parse"}]}},
+    "results": [{
+      "ruleId": "parse", "level": "error", "message": {"text": "unexpected }"},
+      "locations": [{"physicalLocation": {"artifactLocation": {"uri": "a.soy"}, "region": {"startLine": 3, "startColumn": 5}}}]
+    }, {
+      "ruleId": "deprecated", "level": "warning", "message": {"text": "call to deprecated template a.old"},
+      "locations": [{"physicalLocation": {"artifactLocation": {"uri": "a.soy"}, "region": {"startLine": 7}}}],
+      "fixes": [{
+        "description": {"text": "call a.new instead"},
+        "artifactChanges": [{
+          "artifactLocation": {"uri": "a.soy"},
+          "replacements": [{
+            "deletedRegion": {"startLine": 7, "startColumn": 9, "endLine": 7, "endColumn": 15},
+            "insertedContent": {"text": "a.new"}
+          }]
+        }]
+      }]
+    }, {
+      "ruleId": "error", "level": "error", "message": {"text": "no such file"}
+    }]
+  }]
+}`), &expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("unexpected SARIF:\n%s", buf.String())
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	var candidates = []string{"name", "names", "title", "nam", "n", "userName"}
+	var tests = []struct {
+		name     string
+		expected []string
+	}{
+		{"nmae", []string{"name"}},
+		{"nme", []string{"name"}},
+		{"name", []string{"nam", "names"}},
+		{"titel", []string{"title"}},
+		{"username", []string{"userName"}},
+		{"m", []string{"n"}},
+		{"xyz", nil},
+	}
+	for _, test := range tests {
+		if actual := Suggest(test.name, candidates); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package diag
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// The subset of the SARIF 2.1.0 format written by WriteSARIF.
+type (
+	sarifLog struct {
+		Version string     `json:"version"`
+		Schema  string     `json:"$schema"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name  string      `json:"name"`
+		Rules []sarifRule `json:"rules"`
+	}
+	sarifRule struct {
+		ID string `json:"id"`
+	}
+	sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		Level     string          `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations,omitempty"`
+		Fixes     []sarifFix      `json:"fixes,omitempty"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifLocation struct {
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Region           *sarifRegion          `json:"region,omitempty"`
+	}
+	sarifArtifactLocation struct {
+		URI string `json:"uri"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine,omitempty"`
+		StartColumn int `json:"startColumn,omitempty"`
+		EndLine     int `json:"endLine,omitempty"`
+		EndColumn   int `json:"endColumn,omitempty"`
+	}
+	sarifFix struct {
+		Description     sarifMessage          `json:"description"`
+		ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+	}
+	sarifArtifactChange struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Replacements     []sarifReplacement    `json:"replacements"`
+	}
+	sarifReplacement struct {
+		DeletedRegion   *sarifRegion `json:"deletedRegion"`
+		InsertedContent sarifMessage `json:"insertedContent"`
+	}
+)
+
+// WriteSARIF writes the given diagnostics to w as a SARIF 2.1.0 log of a
+// single run of the tool named "soy", for consumption by code scanning
+// services and editors.  The rules of the run are the diagnostics' codes.
+func WriteSARIF(w io.Writer, diags []*Diagnostic) error {
+	var run = sarifRun{
+		Tool:    sarifTool{sarifDriver{Name: "soy", Rules: []sarifRule{}}},
+		Results: []sarifResult{},
+	}
+	var codes = make(map[string]bool)
+	for _, d := range diags {
+		if !codes[d.Code] {
+			codes[d.Code] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{d.Code})
+		}
+		run.Results = append(run.Results, sarifResultOf(d))
+	}
+	sort.Slice(run.Tool.Driver.Rules, func(i, j int) bool {
+		return run.Tool.Driver.Rules[i].ID < run.Tool.Driver.Rules[j].ID
+	})
+
+	var enc = json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	})
+}
+
+func sarifResultOf(d *Diagnostic) sarifResult {
+	var result = sarifResult{
+		RuleID:  d.Code,
+		Level:   d.Severity.String(),
+		Message: sarifMessage{d.Message},
+	}
+	if d.Template != "" && d.Range.Start.Line == 0 {
+		result.Message.Text = d.Template + ": " + d.Message
+	}
+	if d.File == "" {
+		return result
+	}
+	var artifact = sarifArtifactLocation{d.File}
+	result.Locations = []sarifLocation{{sarifPhysicalLocation{artifact, sarifRegionOf(d.Range)}}}
+	for _, fix := range d.Fixes {
+		result.Fixes = append(result.Fixes, sarifFix{
+			Description: sarifMessage{fix.Description},
+			ArtifactChanges: []sarifArtifactChange{{
+				ArtifactLocation: artifact,
+				Replacements: []sarifReplacement{{
+					DeletedRegion:   sarifRegionOf(fix.Range),
+					InsertedContent: sarifMessage{fix.Replacement},
+				}},
+			}},
+		})
+	}
+	return result
+}
+
+// sarifRegionOf returns the region of the given range, or nil if its position
+// is unknown.
+func sarifRegionOf(r Range) *sarifRegion {
+	if r.Start.Line == 0 {
+		return nil
+	}
+	return &sarifRegion{r.Start.Line, r.Start.Col, r.End.Line, r.End.Col}
+}
@@ -0,0 +1,76 @@
+package diag
+
+import "sort"
+
+// maxSuggestions is the maximum number of candidates returned by Suggest.
+const maxSuggestions = 3
+
+// Suggest returns the candidates that the given name may be a misspelling of,
+// closest first: those within an edit distance of a third of the name's
+// length (at least 1), counting insertions, deletions, substitutions, and
+// transpositions of adjacent characters.  At most three are returned.
+func Suggest(name string, candidates []string) []string {
+	var limit = len(name) / 3
+	if limit < 1 {
+		limit = 1
+	}
+	type suggestion struct {
+		name     string
+		distance int
+	}
+	var suggestions []suggestion
+	var seen = make(map[string]bool)
+	for _, candidate := range candidates {
+		if candidate == name || seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		if d := editDistance(name, candidate); d <= limit {
+			suggestions = append(suggestions, suggestion{candidate, d})
+		}
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].distance != suggestions[j].distance {
+			return suggestions[i].distance < suggestions[j].distance
+		}
+		return suggestions[i].name < suggestions[j].name
+	})
+	var result []string
+	for i := 0; i < len(suggestions) && i < maxSuggestions; i++ {
+		result = append(result, suggestions[i].name)
+	}
+	return result
+}
+
+// editDistance returns the optimal string alignment distance between a and
+// b.
+func editDistance(a, b string) int {
+	var s, t = []rune(a), []rune(b)
+	var d = make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			var cost = 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			d[i][j] = d[i-1][j-1] + cost
+			if d[i-1][j]+1 < d[i][j] {
+				d[i][j] = d[i-1][j] + 1
+			}
+			if d[i][j-1]+1 < d[i][j] {
+				d[i][j] = d[i][j-1] + 1
+			}
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(s)][len(t)]
+}
@@ -10,10 +10,17 @@ import (
 	"math/rand"
 	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/robertkrimen/otto"
+	"github.com/harrisonzhao/soy/ast"
 	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/diag"
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/parsepasses"
+	"github.com/harrisonzhao/soy/soyhtml"
 	"github.com/harrisonzhao/soy/soyjs"
 )
 
@@ -409,7 +416,7 @@ func initJs(t *testing.T) *otto.Otto {
 	var i = 1
 	for scanner.Scan() {
 		switch i {
-		case 2565, 2579, 2586:
+		case 2644, 2658, 2665:
 			// skip these regexes
 			// soy.esc.$$FILTER_FOR_FILTER_CSS_VALUE_
 			// soy.esc.$$FILTER_FOR_FILTER_HTML_ATTRIBUTES_
@@ -429,9 +436,21 @@ func initJs(t *testing.T) *otto.Otto {
 	return otto
 }
 
+// TestFeaturesClosureLineJoining verifies that the Closure line joining
+// algorithm also reproduces the output of the Java program.
+func TestFeaturesClosureLineJoining(t *testing.T) {
+	rand.Seed(1)
+	runFeatureTestsWith(t, parse.Options{LineJoining: parse.LineJoinClosure}, featureTests)
+}
+
 func runFeatureTests(t *testing.T, tests []featureTest) {
+	runFeatureTestsWith(t, parse.Options{}, tests)
+}
+
+func runFeatureTestsWith(t *testing.T, opts parse.Options, tests []featureTest) {
 	var features = mustReadFile("testdata/features.soy")
 	var tofu, err = NewBundle().
+		ParseOptions(opts).
 		AddGlobalsFile("testdata/FeaturesUsage_globals.txt").
 		AddTemplateString("", features).
 		AddTemplateFile("testdata/simple.soy").
@@ -466,3 +485,548 @@ func mustReadFile(filename string) string {
 	}
 	return string(content)
 }
+
+func TestCustomDelims(t *testing.T) {
+	var registry, err = NewBundle().
+		ParseOptions(parse.Options{LeftDelim: "[%", RightDelim: "%]"}).
+		AddTemplateString("", `
+[%namespace test%]
+
+/** @param names */
+[%template .hello%]
+function f() { return [%foreach $name in $names%]'[%$name%]'[%if not isLast($name)%] + [%/if%][%/foreach%]; }
+[%/template%]`).
+		Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = "function f() { return 'a' + 'b'; }"
+
+	var b bytes.Buffer
+	var names = data.Map{"names": data.List{data.String("a"), data.String("b")}}
+	if err = soyhtml.NewTofu(registry).Render(&b, "test.hello", names); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != expected {
+		t.Errorf("tofu: expected %q, got %q", expected, b.String())
+	}
+
+	var otto = initJs(t)
+	b.Reset()
+	if err = soyjs.Write(&b, registry.SoyFiles[0], soyjs.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = otto.Run(b.String()); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := otto.Run(`test.hello({names: ["a", "b"]});`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.String() != expected {
+		t.Errorf("js: expected %q, got %q", expected, actual.String())
+	}
+}
+
+func TestDelegates(t *testing.T) {
+	var registry, err = NewBundle().
+		AddTemplateString("page.soy", `
+{namespace test.page}
+
+/** @param brand */
+{template .page}
+  [{delcall test.logo variant="$brand"}{param size: 2 /}{/delcall}]
+  [{delcall test.missing allowemptydefault="true"/}]
+{/template}`).
+		AddTemplateString("logos.soy", `
+{namespace test.logos}
+
+/** @param size */
+{deltemplate test.logo}
+  default logo {$size}
+{/deltemplate}
+
+/** @param size */
+{deltemplate test.logo variant="'acme'"}
+  acme logo {$size}
+{/deltemplate}`).
+		Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var otto = initJs(t)
+	for _, soyfile := range registry.SoyFiles {
+		var b bytes.Buffer
+		if err = soyjs.Write(&b, soyfile, soyjs.Options{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = otto.Run(b.String()); err != nil {
+			t.Fatalf("%v\n%s", err, b.String())
+		}
+	}
+
+	var tofu = soyhtml.NewTofu(registry)
+	for brand, expected := range map[string]string{
+		"acme":  "[acme logo 2] []",
+		"other": "[default logo 2] []",
+		"":      "[default logo 2] []",
+	} {
+		var b bytes.Buffer
+		if err = tofu.Render(&b, "test.page.page", d{"brand": brand}); err != nil {
+			t.Error(err)
+		} else if b.String() != expected {
+			t.Errorf("tofu %q: expected %q, got %q", brand, expected, b.String())
+		}
+
+		actual, err := otto.Run(fmt.Sprintf(`test.page.page({brand: %q});`, brand))
+		if err != nil {
+			t.Error(err)
+		} else if actual.String() != expected {
+			t.Errorf("js %q: expected %q, got %q", brand, expected, actual.String())
+		}
+	}
+}
+
+func TestCompileOverlay(t *testing.T) {
+	var base, err = NewBundle().
+		AddTemplateString("page.soy", `
+{namespace test.page}
+
+/** @param name */
+{template .page}
+  [{call .greeting data="all"/}] [{call .footer/}]
+{/template}
+
+/** @param name */
+{template .greeting}
+  Hello {$name}
+{/template}
+
+{template .footer}
+  Bye
+{/template}`).
+		Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acme, err := NewBundle().
+		AddTemplateString("acme.soy", `
+{namespace test.page}
+
+/** @param name */
+{template .greeting}
+  Welcome to ACME, {$name}{call .footer/}
+{/template}`).
+		CompileOverlay(base, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for tofu, expected := range map[*soyhtml.Tofu]string{
+		soyhtml.NewTofu(base): "[Hello Rob] [Bye]",
+		soyhtml.NewTofu(acme): "[Welcome to ACME, RobBye] [Bye]",
+	} {
+		var b bytes.Buffer
+		if err = tofu.Render(&b, "test.page.page", d{"name": "Rob"}); err != nil {
+			t.Error(err)
+		} else if b.String() != expected {
+			t.Errorf("expected %q, got %q", expected, b.String())
+		}
+	}
+	if layer := acme.Layer("test.page.greeting"); layer != "acme" {
+		t.Errorf("expected greeting from layer acme, got %q", layer)
+	}
+	if layer := acme.Layer("test.page.page"); layer != "" {
+		t.Errorf("expected page from the base, got %q", layer)
+	}
+
+	_, err = NewBundle().
+		AddTemplateString("bad.soy", `
+{namespace test.page}
+
+{template .greeting}
+  {call .missing/}
+{/template}`).
+		CompileOverlay(base, "bad")
+	if err == nil {
+		t.Errorf("expected the overlay to be checked")
+	}
+}
+
+func TestDelPackages(t *testing.T) {
+	var registry, err = NewBundle().
+		AddTemplateString("page.soy", `
+{namespace test.page}
+
+{template .page}
+  [{delcall test.logo/}] [{delcall test.logo variant="'small'"/}]
+{/template}
+
+{deltemplate test.logo}
+  default
+{/deltemplate}
+
+{deltemplate test.logo variant="'small'"}
+  small
+{/deltemplate}`).
+		AddTemplateString("acme.soy", `
+{delpackage acme}
+{namespace test.acme}
+
+{deltemplate test.logo}
+  acme
+{/deltemplate}`).
+		AddTemplateString("globex.soy", `
+{delpackage globex}
+{namespace test.globex}
+
+{deltemplate test.logo}
+  globex
+{/deltemplate}
+
+{deltemplate test.logo variant="'small'"}
+  globex small
+{/deltemplate}`).
+		Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tofu = soyhtml.NewTofu(registry)
+	var tests = []struct {
+		packages []string
+		expected string
+	}{
+		{nil, "[default] [small]"},
+		{[]string{"acme"}, "[acme] [small]"},
+		{[]string{"globex"}, "[globex] [globex small]"},
+		{[]string{"other"}, "[default] [small]"},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		if err = tofu.NewRenderer("test.page.page").DelPackages(test.packages...).Execute(&b, nil); err != nil {
+			t.Error(err)
+		} else if b.String() != test.expected {
+			t.Errorf("%v: expected %q, got %q", test.packages, test.expected, b.String())
+		}
+	}
+
+	var b bytes.Buffer
+	err = tofu.NewRenderer("test.page.page").DelPackages("acme", "globex").Execute(&b, nil)
+	if err == nil || !strings.Contains(err.Error(), "several active packages: acme, globex") {
+		t.Errorf("expected an error for delegates of two active packages, got %v", err)
+	}
+}
+
+func TestDefaultAutoescape(t *testing.T) {
+	var registry, err = NewBundle().
+		AddTemplateString("new.soy", `
+{namespace test.new}
+
+/** @param url */
+{template .link}
+  <a href="{$url}">new</a>
+{/template}`).
+		AddTemplateString("old.soy", `
+{namespace test.old autoescape="true"}
+
+/** @param url */
+{template .link}
+  <a href="{$url}">old</a>
+{/template}`).
+		DefaultAutoescape(ast.AutoescapeContextual).
+		Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tofu = soyhtml.NewTofu(registry)
+	for name, expected := range map[string]string{
+		"test.new.link": `<a href="about:invalid#zSoyz">new</a>`,
+		"test.old.link": `<a href="javascript:alert(1)">old</a>`,
+	} {
+		var b bytes.Buffer
+		if err = tofu.Render(&b, name, d{"url": "javascript:alert(1)"}); err != nil {
+			t.Error(err)
+		} else if b.String() != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, b.String())
+		}
+	}
+
+	if files := parsepasses.DefaultAutoescapeFiles(*registry); !reflect.DeepEqual(files, []string{"new.soy"}) {
+		t.Errorf("expected new.soy to rely on the default, got %v", files)
+	}
+}
+
+func TestLazyParse(t *testing.T) {
+	var registry, err = NewBundle().
+		AddGlobalsFile("testdata/FeaturesUsage_globals.txt").
+		MapFiles(true).
+		AddTemplateFile("testdata/simple.soy").
+		AddTemplateFile("testdata/features.soy").
+		LazyParse(false).
+		Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registry.Templates) != 0 {
+		t.Fatalf("expected no templates to be parsed, got %d", len(registry.Templates))
+	}
+
+	var tofu = soyhtml.NewTofu(registry)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var b bytes.Buffer
+			if err := tofu.Render(&b, "soy.examples.simple.helloWorld", nil); err != nil {
+				t.Error(err)
+			} else if b.String() != "Hello world!" {
+				t.Errorf("expected %q, got %q", "Hello world!", b.String())
+			}
+		}()
+	}
+	wg.Wait()
+	for _, tmpl := range registry.Templates {
+		if tmpl.Namespace.Name != "soy.examples.simple" {
+			t.Errorf("expected only soy.examples.simple to be parsed, found %s", tmpl.Node.Name)
+		}
+	}
+
+	if err = registry.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	eager, err := NewBundle().
+		AddGlobalsFile("testdata/FeaturesUsage_globals.txt").
+		AddTemplateFile("testdata/simple.soy").
+		AddTemplateFile("testdata/features.soy").
+		Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registry.Templates) != len(eager.Templates) {
+		t.Errorf("expected %d templates once loaded, got %d", len(eager.Templates), len(registry.Templates))
+	}
+}
+
+func TestLazyParseChecks(t *testing.T) {
+	var registry, err = NewBundle().
+		AddTemplateString("count.soy", `{namespace test.count}
+{template .count}
+  {let $items: ['a', 'b'] /}
+  {$items - 1}
+{/template}`).
+		AddTemplateString("fold.soy", `{namespace test.fold}
+{template .fold}
+  {1 + 2}
+{/template}`).
+		LazyParse(false).
+		TypeCheck(true).
+		FoldConstants(true).
+		Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = registry.LoadAll(); err == nil || !strings.Contains(err.Error(), "cannot subtract list and int") {
+		t.Errorf("expected a type error on load, got %v", err)
+	}
+	var fold, _ = registry.Template("test.fold.fold")
+	if _, ok := fold.Node.Body.Children()[0].(*ast.RawTextNode); !ok {
+		t.Errorf("expected the constant print to be folded on load, got %v", fold.Node.Body)
+	}
+
+	// An overlay over a lazily parsed base has all of its templates.
+	base, err := NewBundle().
+		AddTemplateString("fold.soy", `{namespace test.fold}
+{template .fold}
+  {1 + 2}
+{/template}`).
+		AddTemplateString("other.soy", `{namespace test.other}
+{template .other}
+  other
+{/template}`).
+		LazyParse(false).
+		Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay, err := NewBundle().
+		AddTemplateString("acme.soy", `{namespace test.fold}
+{template .fold}
+  acme
+{/template}`).
+		CompileOverlay(base, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := overlay.Template("test.other.other"); !ok {
+		t.Errorf("expected the overlay to include the base's unloaded templates")
+	}
+}
+
+func TestTypeCheck(t *testing.T) {
+	var src = `{namespace test}
+{template .count}
+  {let $items: ['a', 'b'] /}
+  {$items - 1}
+{/template}`
+	if _, err := NewBundle().AddTemplateString("count.soy", src).Compile(); err != nil {
+		t.Errorf("expected types to be unchecked by default, got %v", err)
+	}
+	var _, err = NewBundle().AddTemplateString("count.soy", src).TypeCheck(true).Compile()
+	if err == nil || !strings.Contains(err.Error(), "cannot subtract list and int") {
+		t.Errorf("expected a type error, got %v", err)
+	}
+}
+
+func TestCompileDiagnostics(t *testing.T) {
+	var tests = []struct {
+		src      string
+		expected diag.Diagnostic
+	}{
+		{"{namespace test}\n{template .a}\n  {if}\n{/template}",
+			diag.Diagnostic{diag.Error, "parse", "", "a.soy", "", diag.Range{Start: diag.Position{3, 7}}, nil}},
+		{"{namespace test}\n\n{template .a}\n  {$x}\n{/template}",
+			diag.Diagnostic{diag.Error, "dataref", "", "a.soy", "test.a", diag.Range{diag.Position{4, 4}, diag.Position{4, 6}}, nil}},
+		{"{namespace test}\n{template .a}\n\n  {'a'|noAutoescape|escapeHtml}\n{/template}",
+			diag.Diagnostic{diag.Error, "directive", "", "a.soy", "test.a", diag.Range{Start: diag.Position{Line: 4}}, nil}},
+	}
+	for _, test := range tests {
+		var _, err = NewBundle().AddTemplateString("a.soy", test.src).Compile()
+		var diags = diag.Errors(err)
+		if len(diags) != 1 {
+			t.Errorf("%s: expected one diagnostic, got %v", test.src, diags)
+			continue
+		}
+		var actual = *diags[0]
+		if actual.Message == "" || !strings.HasSuffix(err.Error(), actual.Message) {
+			t.Errorf("%s: expected the message to end the error %q, got %q", test.src, err, actual.Message)
+		}
+		actual.Message = ""
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.src, test.expected, actual)
+		}
+	}
+}
+
+func TestQuickFixes(t *testing.T) {
+	var tests = []struct {
+		src      string
+		expected []diag.Fix
+	}{
+		{"{namespace test}\n/** @param name */\n{template .a}\n  {$nmae}\n{/template}",
+			[]diag.Fix{{"did you mean $name?", diag.Range{diag.Position{4, 4}, diag.Position{4, 9}}, "$name"}}},
+		{"{namespace test}\n{template .a}\n  {call .hello /}\n{/template}\n" +
+			"{template .helo}{/template}\n{template .hell}{/template}\n{template .b}{/template}",
+			[]diag.Fix{
+				{"did you mean .hell?", diag.Range{diag.Position{3, 9}, diag.Position{3, 15}}, ".hell"},
+				{"did you mean .helo?", diag.Range{diag.Position{3, 9}, diag.Position{3, 15}}, ".helo"},
+			}},
+		{"{namespace test}\n{template .a}\n  {call other.helo /}\n{/template}\n" +
+			"{namespace other}\n{template .hello}{/template}",
+			[]diag.Fix{{"did you mean other.hello?", diag.Range{diag.Position{3, 9}, diag.Position{3, 19}}, "other.hello"}}},
+		{"{namespace test}\n{template .a}\n  {call .b}{param nmae: 1 /}{/call}\n{/template}\n" +
+			"/** @param name */\n{template .b}{$name}{/template}",
+			[]diag.Fix{{"did you mean name?", diag.Range{diag.Position{3, 19}, diag.Position{3, 23}}, "name"}}},
+		{"{namespace test}\n{template .a}\n  {msg}hi{/msg}\n{/template}",
+			[]diag.Fix{{"add a desc attribute", diag.Range{diag.Position{3, 7}, diag.Position{3, 7}}, ` desc=""`}}},
+	}
+	for _, test := range tests {
+		var files = strings.SplitAfter(test.src, "{/template}\n{namespace")
+		var bundle = NewBundle()
+		for i, file := range files {
+			if i > 0 {
+				file = "{namespace" + file
+			}
+			bundle.AddTemplateString(fmt.Sprintf("%d.soy", i), strings.TrimSuffix(file, "\n{namespace"))
+		}
+		var _, err = bundle.Compile()
+		var diags = diag.Errors(err)
+		if len(diags) != 1 {
+			t.Errorf("%s: expected one diagnostic, got %v", test.src, diags)
+		} else if !reflect.DeepEqual(diags[0].Fixes, test.expected) {
+			t.Errorf("%s: expected fixes %+v, got %+v", test.src, test.expected, diags[0].Fixes)
+		}
+	}
+}
+
+// TestDeterministicOutput checks that compiling the same input produces
+// byte-identical output from each backend, regardless of map iteration order.
+func TestDeterministicOutput(t *testing.T) {
+	var build = func() string {
+		var registry, err = NewBundle().
+			AddGlobalsFile("testdata/FeaturesUsage_globals.txt").
+			AddTemplateFile("testdata/simple.soy").
+			AddTemplateFile("testdata/features.soy").
+			AddTemplateString("maps.soy", `{namespace test.maps}
+/** @param x */
+{template .m}
+  {let $m: ['a': 1, 'b': $x, 'c': ['d': 2, 'e': 3, 'f': 4], 'g': 5, 'h': 6, 'i': 7] /}
+  {$m.c.d}
+{/template}`).
+			Compile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		var gen = soyjs.NewGenerator(registry)
+		for _, soyfile := range registry.SoyFiles {
+			buf.WriteString(soyfile.String())
+			if err = gen.WriteFile(&buf, soyfile.Name); err != nil {
+				t.Fatal(err)
+			}
+			if err = gen.WriteTypeScriptFile(&buf, soyfile.Name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		j, err := registry.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(j)
+		return buf.String()
+	}
+
+	var first = build()
+	for i := 0; i < 5; i++ {
+		if build() != first {
+			t.Fatal("expected identical output from identical input")
+		}
+	}
+}
+
+func TestMapFilesReload(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "soy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var filename = dir + "/page.soy"
+	var compile = func(text string) string {
+		if err := ioutil.WriteFile(filename+".tmp", []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(filename+".tmp", filename); err != nil {
+			t.Fatal(err)
+		}
+		var registry, err = NewBundle().MapFiles(true).AddTemplateFile(filename).Compile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		if err = soyhtml.NewTofu(registry).Render(&b, "test.page", nil); err != nil {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+
+	// Files replaced between compilations are read again.
+	for _, text := range []string{"one", "two", "three"} {
+		if actual := compile("{namespace test}\n{template .page}" + text + "{/template}\n"); actual != text {
+			t.Errorf("expected %q, got %q", text, actual)
+		}
+	}
+}
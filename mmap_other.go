@@ -0,0 +1,12 @@
+//go:build !unix
+
+package soy
+
+import "io/ioutil"
+
+// mapFile reads the given file, since memory-mapping is not supported on this
+// platform.
+func mapFile(filename string) (string, error) {
+	var content, err = ioutil.ReadFile(filename)
+	return string(content), err
+}
@@ -0,0 +1,64 @@
+//go:build unix
+
+package soy
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// mappedFiles are the files mapped so far, by name.  Mappings are never
+// released, since the parse trees of a file refer to its content, and trees of
+// a previous compilation may still be in use.  So that rebuilding a bundle
+// (e.g. on each reload) does not map its files again, an unchanged file
+// shares its mapping, and a file that has changed since it was mapped is read
+// instead.
+var mappedFiles = struct {
+	sync.Mutex
+	byName map[string]mappedFile
+}{byName: make(map[string]mappedFile)}
+
+type mappedFile struct {
+	info    os.FileInfo
+	content string
+}
+
+// mapFile maps the given file into memory, returning its content.  The file
+// must not be truncated or rewritten in place while the process runs: the
+// kernel raises SIGBUS, crashing the process, on access to the pages of a
+// mapping beyond the end of its file.
+func mapFile(filename string) (string, error) {
+	var f, err = os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.Size() == 0 {
+		return "", nil
+	}
+
+	mappedFiles.Lock()
+	defer mappedFiles.Unlock()
+	if prev, ok := mappedFiles.byName[filename]; ok {
+		if os.SameFile(prev.info, info) && prev.info.Size() == info.Size() &&
+			prev.info.ModTime().Equal(info.ModTime()) {
+			return prev.content, nil
+		}
+		var content, err = ioutil.ReadAll(f)
+		return string(content), err
+	}
+	content, err := syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_PRIVATE)
+	if err != nil {
+		return "", err
+	}
+	var str = unsafe.String(&content[0], len(content))
+	mappedFiles.byName[filename] = mappedFile{info, str}
+	return str, nil
+}
@@ -0,0 +1,218 @@
+package parse
+
+import (
+	"sync"
+
+	"github.com/harrisonzhao/soy/ast"
+)
+
+// arenaBlockSize is the number of nodes of each type allocated at once.
+const arenaBlockSize = 256
+
+// Arena allocates the most numerous nodes of parse trees (raw text, prints,
+// data refs, literals, etc) in blocks, rather than one at a time, which
+// greatly reduces the number of allocations, and so the work of the garbage
+// collector, when parsing many files, e.g. on each reload during development
+// or in large CI compiles.  Set it as Options.Arena.
+//
+// A block is freed once none of its nodes are referenced, so an arena suits
+// trees that are discarded together, e.g. those of a registry that is rebuilt
+// on each reload.  Call Reset when rebuilding, so that the partially used
+// blocks of the old trees are not kept alive by the new ones.
+//
+// It is safe for concurrent use.
+type Arena struct {
+	mu       sync.Mutex
+	lists    []ast.ListNode
+	texts    []ast.RawTextNode
+	prints   []ast.PrintNode
+	refs     []ast.DataRefNode
+	keys     []ast.DataRefKeyNode
+	indexes  []ast.DataRefIndexNode
+	exprs    []ast.DataRefExprNode
+	strings  []ast.StringNode
+	ints     []ast.IntNode
+	funcs    []ast.FunctionNode
+	children []ast.Node // backing arrays of data ref accesses
+}
+
+// Reset discards the blocks allocated so far, so that subsequent trees are
+// allocated in new blocks.
+func (a *Arena) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lists, a.texts, a.prints = nil, nil, nil
+	a.refs, a.keys, a.indexes, a.exprs = nil, nil, nil, nil
+	a.strings, a.ints, a.funcs, a.children = nil, nil, nil, nil
+}
+
+// The allocators below return the given node, copied into the arena, or
+// allocated individually if the arena is nil.
+
+func (a *Arena) listNode(n ast.ListNode) *ast.ListNode {
+	if a == nil {
+		return &n
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.lists) == 0 {
+		a.lists = make([]ast.ListNode, arenaBlockSize)
+	}
+	var p = &a.lists[0]
+	a.lists = a.lists[1:]
+	*p = n
+	return p
+}
+
+func (a *Arena) rawTextNode(n ast.RawTextNode) *ast.RawTextNode {
+	if a == nil {
+		return &n
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.texts) == 0 {
+		a.texts = make([]ast.RawTextNode, arenaBlockSize)
+	}
+	var p = &a.texts[0]
+	a.texts = a.texts[1:]
+	*p = n
+	return p
+}
+
+func (a *Arena) printNode(n ast.PrintNode) *ast.PrintNode {
+	if a == nil {
+		return &n
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.prints) == 0 {
+		a.prints = make([]ast.PrintNode, arenaBlockSize)
+	}
+	var p = &a.prints[0]
+	a.prints = a.prints[1:]
+	*p = n
+	return p
+}
+
+func (a *Arena) dataRefNode(n ast.DataRefNode) *ast.DataRefNode {
+	if a == nil {
+		return &n
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.refs) == 0 {
+		a.refs = make([]ast.DataRefNode, arenaBlockSize)
+	}
+	var p = &a.refs[0]
+	a.refs = a.refs[1:]
+	*p = n
+	return p
+}
+
+func (a *Arena) dataRefKeyNode(n ast.DataRefKeyNode) *ast.DataRefKeyNode {
+	if a == nil {
+		return &n
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.keys) == 0 {
+		a.keys = make([]ast.DataRefKeyNode, arenaBlockSize)
+	}
+	var p = &a.keys[0]
+	a.keys = a.keys[1:]
+	*p = n
+	return p
+}
+
+func (a *Arena) dataRefIndexNode(n ast.DataRefIndexNode) *ast.DataRefIndexNode {
+	if a == nil {
+		return &n
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.indexes) == 0 {
+		a.indexes = make([]ast.DataRefIndexNode, arenaBlockSize)
+	}
+	var p = &a.indexes[0]
+	a.indexes = a.indexes[1:]
+	*p = n
+	return p
+}
+
+func (a *Arena) dataRefExprNode(n ast.DataRefExprNode) *ast.DataRefExprNode {
+	if a == nil {
+		return &n
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.exprs) == 0 {
+		a.exprs = make([]ast.DataRefExprNode, arenaBlockSize)
+	}
+	var p = &a.exprs[0]
+	a.exprs = a.exprs[1:]
+	*p = n
+	return p
+}
+
+func (a *Arena) stringNode(n ast.StringNode) *ast.StringNode {
+	if a == nil {
+		return &n
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.strings) == 0 {
+		a.strings = make([]ast.StringNode, arenaBlockSize)
+	}
+	var p = &a.strings[0]
+	a.strings = a.strings[1:]
+	*p = n
+	return p
+}
+
+func (a *Arena) intNode(n ast.IntNode) *ast.IntNode {
+	if a == nil {
+		return &n
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.ints) == 0 {
+		a.ints = make([]ast.IntNode, arenaBlockSize)
+	}
+	var p = &a.ints[0]
+	a.ints = a.ints[1:]
+	*p = n
+	return p
+}
+
+func (a *Arena) functionNode(n ast.FunctionNode) *ast.FunctionNode {
+	if a == nil {
+		return &n
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.funcs) == 0 {
+		a.funcs = make([]ast.FunctionNode, arenaBlockSize)
+	}
+	var p = &a.funcs[0]
+	a.funcs = a.funcs[1:]
+	*p = n
+	return p
+}
+
+// appendNode appends node to the given slice of nodes, which grows from one
+// element within the arena, the common case for the accesses of a data ref.
+// A slice that outgrows it is reallocated individually by append.
+func (a *Arena) appendNode(nodes []ast.Node, node ast.Node) []ast.Node {
+	if a == nil || nodes != nil {
+		return append(nodes, node)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.children) == 0 {
+		a.children = make([]ast.Node, arenaBlockSize)
+	}
+	nodes = a.children[:1:1]
+	a.children = a.children[1:]
+	nodes[0] = node
+	return nodes
+}
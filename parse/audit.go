@@ -0,0 +1,170 @@
+package parse
+
+import (
+	"fmt"
+
+	"github.com/harrisonzhao/soy/ast"
+)
+
+// PrecedenceIssue describes an expression that this parser groups differently
+// than the official Closure Templates grammar would.
+type PrecedenceIssue struct {
+	Name       string // name of the input containing the expression
+	Line       int    // line number of the operator whose grouping differs
+	Expr       string // the expression, as written
+	Suggestion string // the expression, parenthesized to evaluate the same under both grammars
+}
+
+func (i PrecedenceIssue) String() string {
+	return fmt.Sprintf("%s:%d: %q is grouped differently by the official grammar; rewrite as %q",
+		i.Name, i.Line, i.Expr, i.Suggestion)
+}
+
+// officialPrecedence is the binary operator precedence table of the official
+// Closure Templates grammar.  Unlike ours, it binds "and" tighter than "or",
+// and relational operators tighter than equality operators.
+var officialPrecedence = map[itemType]int{
+	itemMul:   6,
+	itemDiv:   6,
+	itemMod:   6,
+	itemAdd:   5,
+	itemSub:   5,
+	itemGt:    4,
+	itemGte:   4,
+	itemLt:    4,
+	itemLte:   4,
+	itemEq:    3,
+	itemNotEq: 3,
+	itemAnd:   2,
+	itemOr:    1,
+	itemElvis: 0,
+}
+
+var operatorText = map[itemType]string{
+	itemMul:   "*",
+	itemDiv:   "/",
+	itemMod:   "%",
+	itemAdd:   "+",
+	itemSub:   "-",
+	itemGt:    ">",
+	itemGte:   ">=",
+	itemLt:    "<",
+	itemLte:   "<=",
+	itemEq:    "==",
+	itemNotEq: "!=",
+	itemAnd:   "and",
+	itemOr:    "or",
+	itemElvis: "?:",
+}
+
+// binaryOpType returns the operator of the given node, if it is a binary
+// operator node.
+func binaryOpType(n ast.Node) (itemType, *ast.BinaryOpNode, bool) {
+	switch n := n.(type) {
+	case *ast.MulNode:
+		return itemMul, &n.BinaryOpNode, true
+	case *ast.DivNode:
+		return itemDiv, &n.BinaryOpNode, true
+	case *ast.ModNode:
+		return itemMod, &n.BinaryOpNode, true
+	case *ast.AddNode:
+		return itemAdd, &n.BinaryOpNode, true
+	case *ast.SubNode:
+		return itemSub, &n.BinaryOpNode, true
+	case *ast.GtNode:
+		return itemGt, &n.BinaryOpNode, true
+	case *ast.GteNode:
+		return itemGte, &n.BinaryOpNode, true
+	case *ast.LtNode:
+		return itemLt, &n.BinaryOpNode, true
+	case *ast.LteNode:
+		return itemLte, &n.BinaryOpNode, true
+	case *ast.EqNode:
+		return itemEq, &n.BinaryOpNode, true
+	case *ast.NotEqNode:
+		return itemNotEq, &n.BinaryOpNode, true
+	case *ast.AndNode:
+		return itemAnd, &n.BinaryOpNode, true
+	case *ast.OrNode:
+		return itemOr, &n.BinaryOpNode, true
+	case *ast.ElvisNode:
+		return itemElvis, &n.BinaryOpNode, true
+	}
+	return 0, nil, false
+}
+
+// needsParens returns true if the official grammar requires the given operand
+// of an expression with operator op to be parenthesized in order to group it
+// the way this parser did.
+func (t *tree) needsParens(op itemType, operand ast.Node, right bool) bool {
+	if t.parens[operand] {
+		return false
+	}
+	var childOp, _, ok = binaryOpType(operand)
+	if !ok {
+		return false
+	}
+	var parentPrec, childPrec = officialPrecedence[op], officialPrecedence[childOp]
+	if !right {
+		return childPrec < parentPrec
+	}
+	// and, or, and ?: evaluate the same regardless of grouping.
+	if childOp == op && (op == itemAnd || op == itemOr || op == itemElvis) {
+		return false
+	}
+	return childPrec <= parentPrec
+}
+
+// auditPrecedence reports the given binary operator node, just created from
+// the operator token tok, if the official grammar would group its operands
+// differently.
+func (t *tree) auditPrecedence(tok item, n ast.Node) {
+	var _, bin, _ = binaryOpType(n)
+	if !t.needsParens(tok.typ, bin.Arg1, false) && !t.needsParens(tok.typ, bin.Arg2, true) {
+		return
+	}
+	var line = t.exprLine
+	if line == 0 {
+		line = t.lex.lineNumber(tok.pos)
+	}
+	t.opts.PrecedenceAudit(PrecedenceIssue{
+		Name:       t.name,
+		Line:       line,
+		Expr:       t.formatExpr(n, false),
+		Suggestion: t.formatExpr(n, true),
+	})
+}
+
+// formatExpr renders the given expression, retaining the parentheses that were
+// written.  If explicit is true, parentheses are added wherever the official
+// grammar would otherwise group operands differently.
+func (t *tree) formatExpr(n ast.Node, explicit bool) string {
+	var str string
+	switch node := n.(type) {
+	case *ast.NotNode:
+		str = "not " + t.formatExpr(node.Arg, explicit)
+	case *ast.NegateNode:
+		str = "-" + t.formatExpr(node.Arg, explicit)
+	case *ast.TernNode:
+		str = t.formatExpr(node.Arg1, explicit) + " ? " +
+			t.formatExpr(node.Arg2, explicit) + " : " + t.formatExpr(node.Arg3, explicit)
+	default:
+		var op, bin, ok = binaryOpType(n)
+		if !ok {
+			str = n.String()
+			break
+		}
+		var left, right = t.formatExpr(bin.Arg1, explicit), t.formatExpr(bin.Arg2, explicit)
+		if explicit && t.needsParens(op, bin.Arg1, false) {
+			left = "(" + left + ")"
+		}
+		if explicit && t.needsParens(op, bin.Arg2, true) {
+			right = "(" + right + ")"
+		}
+		str = left + " " + operatorText[op] + " " + right
+	}
+	if t.parens[n] {
+		return "(" + str + ")"
+	}
+	return str
+}
@@ -0,0 +1,183 @@
+package parse
+
+import (
+	"strconv"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/soymsg"
+)
+
+// The constructors below build parse trees programmatically, for code
+// generators and refactoring tools, filling in the fields that the parser
+// would otherwise derive (e.g. the quoted form of strings and the IDs of
+// messages).  The nodes they return have no position within any source
+// (their Pos is 0), so problems found within them are reported at the start of
+// their file.
+//
+// Expressions are most easily built from source with Expr or MustExpr, e.g.
+//
+//	NewPrint(MustExpr("$user.name"), NewPrintDirective("truncate", NewInt(10)))
+
+// MustExpr is like Expr, but panics if the expression can not be parsed.  It
+// is intended for expressions written in the source of generators.
+func MustExpr(str string) ast.Node {
+	var node, err = Expr(str)
+	if err != nil {
+		panic(err)
+	}
+	return node
+}
+
+// NewSoyFile returns a soy file with the given name, comprising the given
+// namespace declaration followed by the body, which is typically a sequence
+// of SoyDoc and template nodes.
+func NewSoyFile(name string, namespace *ast.NamespaceNode, body ...ast.Node) *ast.SoyFileNode {
+	return &ast.SoyFileNode{Name: name, Body: append([]ast.Node{namespace}, body...)}
+}
+
+// NewNamespace returns a namespace declaration with the given autoescape
+// mode, or ast.AutoescapeUnspecified for the default.
+func NewNamespace(name string, autoescape ast.AutoescapeType) *ast.NamespaceNode {
+	return &ast.NamespaceNode{0, name, autoescape, nil, false}
+}
+
+// NewSoyDoc returns a SoyDoc comment declaring the given params, to precede a
+// template within a file.
+func NewSoyDoc(desc string, params ...*ast.SoyDocParamNode) *ast.SoyDocNode {
+	return &ast.SoyDocNode{0, params, desc, false, ""}
+}
+
+// NewSoyDocParam returns a SoyDoc @param (or @param?, if optional)
+// declaration.
+func NewSoyDocParam(name string, optional bool, desc string) *ast.SoyDocParamNode {
+	return &ast.SoyDocParamNode{0, name, optional, desc}
+}
+
+// NewTemplate returns a template of the given fully-qualified name, with the
+// given body.
+func NewTemplate(name string, body ...ast.Node) *ast.TemplateNode {
+	return &ast.TemplateNode{Name: name, Body: NewList(body...)}
+}
+
+// NewDelTemplate returns a delegate template of the given name and variant
+// ("" for the default), with the given body.
+func NewDelTemplate(name, variant string, body ...ast.Node) *ast.TemplateNode {
+	return &ast.TemplateNode{Name: name, Body: NewList(body...), Delegate: true, Variant: variant}
+}
+
+// NewList returns a list of the given nodes, e.g. the body of a command.
+func NewList(nodes ...ast.Node) *ast.ListNode {
+	return &ast.ListNode{0, nodes}
+}
+
+// NewText returns a node of raw text, which is written to the output as is.
+func NewText(text string) *ast.RawTextNode {
+	return &ast.RawTextNode{0, []byte(text)}
+}
+
+// NewPrint returns a print command of the given expression, passed through
+// the given directives in order.
+func NewPrint(expr ast.Node, directives ...*ast.PrintDirectiveNode) *ast.PrintNode {
+	return &ast.PrintNode{0, expr, directives}
+}
+
+// NewPrintDirective returns a print directive with the given arguments.
+func NewPrintDirective(name string, args ...ast.Node) *ast.PrintDirectiveNode {
+	return &ast.PrintDirectiveNode{0, name, args}
+}
+
+// NewCall returns a call of the template of the given fully-qualified name,
+// passing the given params (see NewParam and NewParamContent).
+func NewCall(name string, params ...ast.Node) *ast.CallNode {
+	return &ast.CallNode{Name: name, Params: params}
+}
+
+// NewDelCall returns a call of the delegate templates of the given name,
+// passing the given params.
+func NewDelCall(name string, params ...ast.Node) *ast.CallNode {
+	return &ast.CallNode{Name: name, Params: params, Delegate: true}
+}
+
+// NewParam returns a param of a call, with the value of the given expression.
+func NewParam(key string, value ast.Node) *ast.CallParamValueNode {
+	return &ast.CallParamValueNode{0, key, value}
+}
+
+// NewParamContent returns a param of a call, with the rendered content of
+// the given body, of the given kind (or "" if unspecified).
+func NewParamContent(key string, kind data.ContentKind, body ...ast.Node) *ast.CallParamContentNode {
+	return &ast.CallParamContentNode{0, key, NewList(body...), kind}
+}
+
+// NewIf returns an if command with the given conditions, e.g.
+//
+//	NewIf(NewIfCond(MustExpr("$a"), NewText("a")), NewIfCond(nil, NewText("else")))
+func NewIf(conds ...*ast.IfCondNode) *ast.IfNode {
+	return &ast.IfNode{0, conds}
+}
+
+// NewIfCond returns a condition of an if command, whose body is rendered if
+// the condition is true.  A nil condition is the else clause.
+func NewIfCond(cond ast.Node, body ...ast.Node) *ast.IfCondNode {
+	return &ast.IfCondNode{0, cond, NewList(body...)}
+}
+
+// NewFor returns a loop binding the variable of the given name (without the
+// leading $) to each item of the given list, or each number of a call to
+// range().
+func NewFor(varName string, list ast.Node, body ...ast.Node) *ast.ForNode {
+	return &ast.ForNode{0, varName, list, NewList(body...), nil, ""}
+}
+
+// NewLet returns a let command binding the variable of the given name
+// (without the leading $) to the value of the given expression.
+func NewLet(name string, expr ast.Node) *ast.LetValueNode {
+	return &ast.LetValueNode{0, name, expr}
+}
+
+// NewLetContent returns a let command binding the variable of the given name
+// to the rendered content of the given body, of the given kind (or "" if
+// unspecified).
+func NewLetContent(name string, kind data.ContentKind, body ...ast.Node) *ast.LetContentNode {
+	return &ast.LetContentNode{0, name, NewList(body...), kind}
+}
+
+// NewMsg returns a message for translation with the given description and
+// body.  The content of the body other than text is placed in placeholders,
+// and the message ID is computed, as by the parser.
+func NewMsg(desc string, body ...ast.Node) *ast.MsgNode {
+	var node = &ast.MsgNode{0, desc, NewList(body...), "", 0, nil}
+	soymsg.SetPlaceholdersAndID(node)
+	return node
+}
+
+// NewString returns a string literal of the given value.
+func NewString(value string) *ast.StringNode {
+	return &ast.StringNode{0, quoteString(value), value}
+}
+
+// NewInt returns an integer literal.
+func NewInt(value int64) *ast.IntNode {
+	return &ast.IntNode{0, value}
+}
+
+// NewBool returns a boolean literal.
+func NewBool(value bool) *ast.BoolNode {
+	return &ast.BoolNode{0, value}
+}
+
+// NewDataRef returns a reference to the variable of the given name (without
+// the leading $), accessing the given keys in turn, e.g. NewDataRef("user",
+// "name") for $user.name.  Keys that are numbers access list indices.
+func NewDataRef(name string, keys ...string) *ast.DataRefNode {
+	var access []ast.Node
+	for _, key := range keys {
+		if index, err := strconv.Atoi(key); err == nil {
+			access = append(access, &ast.DataRefIndexNode{0, false, index})
+		} else {
+			access = append(access, &ast.DataRefKeyNode{0, false, key})
+		}
+	}
+	return &ast.DataRefNode{0, name, access}
+}
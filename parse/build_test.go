@@ -0,0 +1,55 @@
+package parse
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/soyhtml"
+	"github.com/harrisonzhao/soy/template"
+)
+
+func TestBuild(t *testing.T) {
+	var file = NewSoyFile("built.soy", NewNamespace("test", ast.AutoescapeUnspecified),
+		NewSoyDoc("Greets users.", NewSoyDocParam("users", false, "")),
+		NewTemplate("test.greet",
+			NewFor("user", NewDataRef("users"),
+				NewIf(
+					NewIfCond(MustExpr("$user.admin"),
+						NewCall("test.badge", NewParam("label", NewString("it's <me>")))),
+					NewIfCond(nil, NewText("- "))),
+				NewMsg("greeting", NewText("Hello "), NewPrint(NewDataRef("user", "name"))),
+				NewPrint(NewDataRef("user", "tags", "0"), NewPrintDirective("truncate", NewInt(3))),
+				NewText("\n"))),
+		NewSoyDoc("", NewSoyDocParam("label", false, "")),
+		NewTemplate("test.badge", NewText("["), NewPrint(MustExpr("$label")), NewText("] ")))
+
+	var reg template.Registry
+	if err := reg.Add(file); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	var err = soyhtml.NewTofu(&reg).Render(&buf, "test.greet", data.Map{"users": data.List{
+		data.Map{"name": data.String("Ann"), "admin": data.Bool(true), "tags": data.List{data.String("alpha")}},
+		data.Map{"name": data.String("Bob"), "admin": data.Bool(false), "tags": data.List{data.String("b")}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = "[it&#39;s &lt;me&gt;] Hello Annalp\n- Hello Bobb\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+
+	// The built message has the same ID as the parsed equivalent.
+	var parsed, _ = SoyFile("", `{namespace test}{template .t}{msg desc="greeting"}Hello {$user.name}{/msg}{/template}`, nil)
+	var parsedMsg = parsed.Body[1].(*ast.TemplateNode).Body.Nodes[0].(*ast.MsgNode)
+	var builtMsg = NewMsg("greeting", NewText("Hello "), NewPrint(NewDataRef("user", "name")))
+	if builtMsg.ID != parsedMsg.ID {
+		t.Errorf("expected message ID %d, got %d", parsedMsg.ID, builtMsg.ID)
+	}
+	if str := NewString("it's").String(); str != `'it\'s'` {
+		t.Errorf("expected the string to be quoted, got %s", str)
+	}
+}
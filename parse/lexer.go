@@ -122,7 +122,9 @@ const (
 	itemMsg         // {msg ...}
 	itemNamespace   // {namespace}
 	itemParam       // {param ...}
+	itemPlural      // {plural ...}
 	itemPrint       // {print ...}
+	itemSelect      // {select ...}
 	itemSwitch      // {switch ...}
 	itemTemplate    // {template ...}
 	itemLog         // {log}
@@ -148,15 +150,11 @@ const (
 	itemLiteralEnd     // {/literal}
 	itemMsgEnd         // {/msg}
 	itemParamEnd       // {/param}
+	itemPluralEnd      // {/plural}
+	itemSelectEnd      // {/select}
 	itemSwitchEnd      // {/switch}
 	itemTemplateEnd    // {/template}
 	itemLogEnd         // {/log}
-
-	// These commands are defined in TemplateParser.jj but not in the docs.
-	// Apparently they are not available in the open source version of Soy.
-	// See http://goo.gl/V0wsd
-	// itemPlural               // {plural}{/plural}
-	// itemSelect               // {select}{/select}
 )
 
 // isOp returns true if the item is an expression operation
@@ -165,27 +163,32 @@ func (t itemType) isOp() bool {
 }
 
 var builtinIdents = map[string]itemType{
-	"alias":     itemAlias,
-	"call":      itemCall,
-	"case":      itemCase,
-	"css":       itemCss,
-	"debugger":  itemDebugger,
-	"default":   itemDefault,
-	"else":      itemElse,
-	"elseif":    itemElseif,
-	"for":       itemFor,
-	"foreach":   itemForeach,
-	"if":        itemIf,
-	"ifempty":   itemIfempty,
-	"let":       itemLet,
-	"literal":   itemLiteral,
-	"log":       itemLog,
-	"msg":       itemMsg,
-	"namespace": itemNamespace,
-	"param":     itemParam,
-	"print":     itemPrint,
-	"switch":    itemSwitch,
-	"template":  itemTemplate,
+	"alias":       itemAlias,
+	"call":        itemCall,
+	"case":        itemCase,
+	"css":         itemCss,
+	"debugger":    itemDebugger,
+	"default":     itemDefault,
+	"delcall":     itemDelcall,
+	"delpackage":  itemDelpackage,
+	"deltemplate": itemDeltemplate,
+	"else":        itemElse,
+	"elseif":      itemElseif,
+	"for":         itemFor,
+	"foreach":     itemForeach,
+	"if":          itemIf,
+	"ifempty":     itemIfempty,
+	"let":         itemLet,
+	"literal":     itemLiteral,
+	"log":         itemLog,
+	"msg":         itemMsg,
+	"namespace":   itemNamespace,
+	"param":       itemParam,
+	"plural":      itemPlural,
+	"print":       itemPrint,
+	"select":      itemSelect,
+	"switch":      itemSwitch,
+	"template":    itemTemplate,
 
 	"/call":        itemCallEnd,
 	"/delcall":     itemDelcallEnd,
@@ -198,6 +201,8 @@ var builtinIdents = map[string]itemType{
 	"/log":         itemLogEnd,
 	"/msg":         itemMsgEnd,
 	"/param":       itemParamEnd,
+	"/plural":      itemPluralEnd,
+	"/select":      itemSelectEnd,
 	"/switch":      itemSwitchEnd,
 	"/template":    itemTemplateEnd,
 
@@ -309,6 +314,8 @@ type lexer struct {
 	items       chan item // channel of scanned items.
 	doubleDelim bool      // flag for tags starting with double braces.
 	lastEmit    item      // type of most recent item emitted
+	leftDelim   string    // custom opening tag delimiter, or "" for braces
+	rightDelim  string    // custom closing tag delimiter, or "" for braces
 }
 
 // nextItem returns the next item from the input.
@@ -318,11 +325,19 @@ func (l *lexer) nextItem() item {
 
 // lex creates a new scanner for the input string.
 func lex(name, input string) *lexer {
+	return lexDelims(name, input, "", "")
+}
+
+// lexDelims creates a new scanner for the input string, using the given tag
+// delimiters in place of braces.  Empty delimiters select the braces.
+func lexDelims(name, input, left, right string) *lexer {
 	l := &lexer{
-		name:  name,
-		input: input,
-		items: make(chan item),
-		state: lexText,
+		name:       name,
+		input:      input,
+		items:      make(chan item),
+		state:      lexText,
+		leftDelim:  left,
+		rightDelim: right,
 	}
 	go l.run()
 	return l
@@ -426,6 +441,52 @@ func (l *lexer) errorf(format string, args ...interface{}) stateFn {
 	return nil
 }
 
+// isLeftDelim returns true if the given rune, just read, begins an opening tag
+// delimiter.
+func (l *lexer) isLeftDelim(r rune) bool {
+	if l.leftDelim == "" {
+		return r == '{'
+	}
+	return r != eof && strings.HasPrefix(l.input[l.pos-ast.Pos(l.width):], l.leftDelim)
+}
+
+// isRightDelim returns true if the given rune, just read, begins a closing tag
+// delimiter.
+func (l *lexer) isRightDelim(r rune) bool {
+	if l.rightDelim == "" {
+		return r == '}'
+	}
+	return r != eof && strings.HasPrefix(l.input[l.pos-ast.Pos(l.width):], l.rightDelim)
+}
+
+// peekRightDelim returns true if the input continues with a closing tag
+// delimiter.
+func (l *lexer) peekRightDelim() bool {
+	if l.rightDelim == "" {
+		return l.peek() == '}'
+	}
+	return strings.HasPrefix(l.input[l.pos:], l.rightDelim)
+}
+
+// acceptRightDelim consumes the remainder of a closing tag delimiter whose
+// first rune has just been read.  It returns false if a double closing brace
+// was required but not found.
+func (l *lexer) acceptRightDelim() bool {
+	if l.rightDelim == "" {
+		return !l.doubleDelim || l.next() == '}'
+	}
+	l.pos += ast.Pos(len(l.rightDelim) - l.width)
+	return true
+}
+
+// closingDelim returns the closing tag delimiter, for error messages.
+func (l *lexer) closingDelim() string {
+	if l.rightDelim == "" {
+		return rightDelim
+	}
+	return l.rightDelim
+}
+
 // State functions ------------------------------------------------------------
 
 func maybeEmitText(l *lexer, backup int) {
@@ -477,14 +538,14 @@ func lexText(l *lexer) stateFn {
 		}
 
 		// eof or entering a tag?
-		switch r {
-		case '{':
+		switch {
+		case l.isLeftDelim(r):
 			l.backup()
 			maybeEmitText(l, 0)
 			return lexLeftDelim
-		case '}':
-			return l.errorf("unexpected closing delimiter } found in input.")
-		case eof:
+		case l.isRightDelim(r):
+			return l.errorf("unexpected closing delimiter %s found in input.", l.closingDelim())
+		case r == eof:
 			l.backup()
 			maybeEmitText(l, 0)
 			l.emit(itemEOF)
@@ -499,6 +560,12 @@ func lexText(l *lexer) stateFn {
 // be used, so we differentiate them to match double closing braces later.
 // Double braces are also optional for other cases.
 func lexLeftDelim(l *lexer) stateFn {
+	if l.leftDelim != "" {
+		l.pos += ast.Pos(len(l.leftDelim))
+		l.doubleDelim = false
+		l.emit(itemLeftDelim)
+		return lexBeginTag
+	}
 	l.next() // read the first {
 	// check the next character to see if it's a double delimiter
 	if r := l.next(); r == '{' {
@@ -514,7 +581,7 @@ func lexLeftDelim(l *lexer) stateFn {
 // lexRightDelim scans the right template tag delimiter
 // } has already been read.
 func lexRightDelim(l *lexer) stateFn {
-	if l.doubleDelim && l.next() != '}' {
+	if !l.acceptRightDelim() {
 		return l.errorf("expected double closing braces in tag")
 	}
 	l.emit(itemRightDelim)
@@ -525,7 +592,7 @@ func lexRightDelim(l *lexer) stateFn {
 // / has already been read.
 func lexRightDelimEnd(l *lexer) stateFn {
 	l.next()
-	if l.doubleDelim && l.next() != '}' {
+	if !l.acceptRightDelim() {
 		return l.errorf("expected double closing braces in tag")
 	}
 	l.emit(itemRightDelimEnd)
@@ -548,7 +615,9 @@ func lexInsideTag(l *lexer) stateFn {
 	switch r := l.next(); {
 	case isSpaceEOL(r):
 		l.ignore()
-	case r == '/' && l.peek() == '}':
+	case l.isRightDelim(r):
+		return lexRightDelim
+	case r == '/' && l.peekRightDelim():
 		return lexRightDelimEnd
 	case r == '$', r == '.':
 		l.backup()
@@ -572,8 +641,6 @@ func lexInsideTag(l *lexer) stateFn {
 		}
 	case r == '-':
 		return lexNegative(l)
-	case r == '}':
-		return lexRightDelim
 	case r >= '0' && r <= '9':
 		l.backup()
 		return lexNumber
@@ -836,12 +903,15 @@ func lexIdent(l *lexer) stateFn {
 func lexCss(l *lexer) stateFn {
 	l.next()
 	l.ignore()
-	for l.next() != '}' {
+	for r := l.next(); !l.isRightDelim(r); r = l.next() {
+		if r == eof {
+			return l.errorf("unclosed tag")
+		}
 	}
 	l.backup()
 	l.emit(itemText)
 	l.next()
-	if l.doubleDelim && l.next() != '}' {
+	if !l.acceptRightDelim() {
 		return l.errorf("expected double closing braces in tag")
 	}
 	l.emit(itemRightDelim)
@@ -859,18 +929,22 @@ func lexLiteral(l *lexer) stateFn {
 	for isSpace(ch) {
 		ch = l.next()
 	}
-	if ch != '}' {
+	if !l.isRightDelim(ch) {
 		return l.errorf("expected closing tag after {literal..")
 	}
-	if l.doubleDelim && l.next() != '}' {
+	if !l.acceptRightDelim() {
 		return l.errorf("expected double closing braces in tag")
 	}
 	l.emit(itemRightDelim)
 
 	// Fast forward through the literal section.
-	var expectClose, delimLen = "{/literal}", 1
-	if l.doubleDelim {
-		expectClose, delimLen = "{{/literal}}", 2
+	var expectClose, leftLen, rightLen = "{/literal}", 1, 1
+	switch {
+	case l.leftDelim != "":
+		expectClose = l.leftDelim + "/literal" + l.rightDelim
+		leftLen, rightLen = len(l.leftDelim), len(l.rightDelim)
+	case l.doubleDelim:
+		expectClose, leftLen, rightLen = "{{/literal}}", 2, 2
 	}
 	var i = strings.Index(l.input[l.pos:], expectClose)
 	if i == -1 {
@@ -883,11 +957,11 @@ func lexLiteral(l *lexer) stateFn {
 	if i > 0 {
 		l.emit(itemText)
 	}
-	l.pos += ast.Pos(delimLen)
+	l.pos += ast.Pos(leftLen)
 	l.emit(itemLeftDelim)
 	l.pos += ast.Pos(len("/literal"))
 	l.emit(itemLiteralEnd)
-	l.pos += ast.Pos(delimLen)
+	l.pos += ast.Pos(rightLen)
 	l.emit(itemRightDelim)
 	return lexText
 }
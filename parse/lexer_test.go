@@ -566,9 +566,58 @@ Hello world.
 	}},
 }
 
+var customDelimLexTests = []lexTest{
+	{"custom delimiters", "[%namespace a%]{text}", []item{
+		{itemLeftDelim, 0, "[%"},
+		{itemNamespace, 0, "namespace"},
+		{itemIdent, 0, "a"},
+		{itemRightDelim, 0, "%]"},
+		{itemText, 0, "{text}"},
+		tEOF,
+	}},
+	{"custom self-closing", "[%call .foo/%]", []item{
+		{itemLeftDelim, 0, "[%"},
+		{itemCall, 0, "call"},
+		{itemDotIdent, 0, ".foo"},
+		{itemRightDelimEnd, 0, "/%]"},
+		tEOF,
+	}},
+	{"custom arithmetic", "[%$a % 2%]", []item{
+		{itemLeftDelim, 0, "[%"},
+		{itemDollarIdent, 0, "$a"},
+		{itemMod, 0, "%"},
+		{itemInteger, 0, "2"},
+		{itemRightDelim, 0, "%]"},
+		tEOF,
+	}},
+	{"custom literal", "[%literal%]a[%b%]{c}[%/literal%]", []item{
+		{itemLeftDelim, 0, "[%"},
+		{itemLiteral, 0, "literal"},
+		{itemRightDelim, 0, "%]"},
+		{itemText, 0, "a[%b%]{c}"},
+		{itemLeftDelim, 0, "[%"},
+		{itemLiteralEnd, 0, "/literal"},
+		{itemRightDelim, 0, "%]"},
+		tEOF,
+	}},
+	{"custom css", "[%css a-b%]", []item{
+		{itemLeftDelim, 0, "[%"},
+		{itemCss, 0, "css"},
+		{itemText, 0, "a-b"},
+		{itemRightDelim, 0, "%]"},
+		tEOF,
+	}},
+	{"custom stray closing", "a %] b", []item{
+		{itemError, 0, "unexpected closing delimiter %] found in input."},
+	}},
+}
+
 // collect gathers the emitted items into a slice.
 func collect(t *lexTest) (items []item) {
-	l := lex(t.name, t.input)
+	return collectFrom(lex(t.name, t.input))
+}
+
+func collectFrom(l *lexer) (items []item) {
 	for {
 		item := l.nextItem()
 		items = append(items, item)
@@ -606,6 +655,15 @@ func TestLex(t *testing.T) {
 	}
 }
 
+func TestLexCustomDelims(t *testing.T) {
+	for _, test := range customDelimLexTests {
+		items := collectFrom(lexDelims(test.name, test.input, "[%", "%]"))
+		if !equal(items, test.items, false) {
+			t.Errorf("%s: got\n\t%+v\nexpected\n\t%v", test.name, items, test.items)
+		}
+	}
+}
+
 func TestScanNumber(t *testing.T) {
 	validIntegers := []string{
 		// Decimal.
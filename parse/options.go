@@ -0,0 +1,80 @@
+package parse
+
+import "fmt"
+
+// Options configure the parser.  The zero value provides the default
+// behavior.
+type Options struct {
+	// LineJoining selects the algorithm used to join lines of raw text.
+	LineJoining LineJoining
+
+	// LeftDelim and RightDelim, if set, replace the braces that delimit template
+	// tags, e.g. "{{" and "}}" or "[%" and "%]".  Braces are then treated as raw
+	// text.  Both or neither must be set.
+	LeftDelim, RightDelim string
+
+	// PrecedenceAudit, if set, is called for every expression that the official
+	// Closure Templates grammar would evaluate in a different order than this
+	// parser, e.g. "$a or $b and $c".  Parsing is otherwise unaffected.
+	PrecedenceAudit func(PrecedenceIssue)
+
+	// UnknownAttrs selects how attributes that a tag does not recognize are
+	// handled, e.g. those introduced by newer versions of the official compiler.
+	// By default they are an error.
+	UnknownAttrs AttrPolicy
+
+	// UnknownAttrWarning, if set, is called for every unrecognized attribute
+	// when UnknownAttrs is AttrWarn.
+	UnknownAttrWarning func(UnknownAttr)
+
+	// Arena, if set, allocates the most numerous nodes of the parse tree in
+	// blocks, to reduce the garbage collection of large compiles (see Arena).
+	Arena *Arena
+}
+
+// AttrPolicy identifies how the parser handles unrecognized tag attributes.
+// Under the policies that keep them, the unrecognized attributes of namespace,
+// template, call, and msg tags are preserved on the node's Attrs; those of
+// other tags are dropped.
+type AttrPolicy int
+
+const (
+	// AttrError fails the parse on an unrecognized attribute.
+	AttrError AttrPolicy = iota
+
+	// AttrWarn reports unrecognized attributes to UnknownAttrWarning and
+	// otherwise keeps them.
+	AttrWarn
+
+	// AttrPreserve silently keeps unrecognized attributes.
+	AttrPreserve
+)
+
+// UnknownAttr describes an attribute that the parser did not recognize.
+type UnknownAttr struct {
+	Name  string // name of the input containing the attribute
+	Line  int    // line number of the attribute
+	Tag   string // the tag on which it appears, e.g. "call"
+	Attr  string // name of the attribute
+	Value string // value of the attribute
+}
+
+func (a UnknownAttr) String() string {
+	return fmt.Sprintf("%s:%d: unrecognized attribute %s=%q on {%s}", a.Name, a.Line, a.Attr, a.Value, a.Tag)
+}
+
+// LineJoining identifies an algorithm for joining the lines of raw text within
+// a template.  In all cases, leading and trailing whitespace on each line is
+// removed, and lines are joined with either a single space or no space.
+type LineJoining int
+
+const (
+	// LineJoinDefault joins lines with no space if the character on either side
+	// of the join location is '<' or '>', else with a single space.
+	LineJoinDefault LineJoining = iota
+
+	// LineJoinClosure matches the official Closure Templates compiler: lines are
+	// joined with no space if the first line ends with '>' or the second line
+	// begins with '<', else with a single space.
+	LineJoinClosure
+)
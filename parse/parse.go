@@ -7,10 +7,13 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/harrisonzhao/soy/ast"
 	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/diag"
+	"github.com/harrisonzhao/soy/soymsg"
 )
 
 // tree is the parsed representation of a single soy file.
@@ -22,19 +25,30 @@ type tree struct {
 	token     [2]item               // two-token lookahead
 	peekCount int                   // how many tokens have we backed up?
 	namespace string                // the current namespace, for fully-qualifying template.
+	delpkg    string                // the delegate package of the file, if any
 	aliases   map[string]string     // map from alias to namespace e.g. {"c": "a.b.c"}
 	globals   map[string]data.Value // global (compile-time constants) values by name
+	opts      Options               // parser configuration
+	parens    map[ast.Node]bool     // parenthesized expressions, when auditing precedence
+	exprLine  int                   // line of a quoted expression within its file
+	refs      *[]nameRef            // template, namespace, and alias names, when renaming
+	attrItems map[string]item       // attribute value tokens of the last tag, when renaming
+	inMsg     bool                  // true while parsing the body of a {msg}
 }
 
 // SoyFile parses the input into a SoyFileNode (the AST).
 // The result may be used as input to a soy backend to generate HTML or JS.
+// Syntax errors are returned as a *diag.Diagnostic.
 func SoyFile(name, text string, globals data.Map) (node *ast.SoyFileNode, err error) {
-	var t = &tree{
-		name:    name,
-		text:    text,
-		aliases: make(map[string]string),
-		globals: globals,
-		lex:     lex(name, text),
+	return SoyFileWith(Options{}, name, text, globals)
+}
+
+// SoyFileWith parses the input into a SoyFileNode (the AST), using the given
+// parser options.
+func SoyFileWith(opts Options, name, text string, globals data.Map) (node *ast.SoyFileNode, err error) {
+	t, err := newTree(opts, name, text, globals)
+	if err != nil {
+		return nil, err
 	}
 	defer t.recover(&err)
 	t.root = t.itemList(itemEOF)
@@ -46,6 +60,25 @@ func SoyFile(name, text string, globals data.Map) (node *ast.SoyFileNode, err er
 	}, nil
 }
 
+// newTree returns a tree ready to parse the given input.
+func newTree(opts Options, name, text string, globals data.Map) (*tree, error) {
+	if (opts.LeftDelim == "") != (opts.RightDelim == "") {
+		return nil, fmt.Errorf("template %s: both or neither of the left and right delimiters must be set", name)
+	}
+	var t = &tree{
+		name:    name,
+		text:    text,
+		aliases: make(map[string]string),
+		globals: globals,
+		opts:    opts,
+		lex:     lexDelims(name, text, opts.LeftDelim, opts.RightDelim),
+	}
+	if opts.PrecedenceAudit != nil {
+		t.parens = make(map[ast.Node]bool)
+	}
+	return t, nil
+}
+
 // itemList:
 //	textOrTag*
 // Terminates when it comes across the given end tag.
@@ -54,7 +87,7 @@ func (t *tree) itemList(until ...itemType) *ast.ListNode {
 	for {
 		var token = t.next()
 		if list == nil {
-			list = &ast.ListNode{token.pos, nil}
+			list = t.opts.Arena.listNode(ast.ListNode{token.pos, nil})
 		}
 		var node, halt = t.textOrTag(token, until)
 		if halt {
@@ -98,11 +131,11 @@ func (t *tree) textOrTag(token item, until []itemType) (node ast.Node, halt bool
 			text += next.val
 		}
 		t.backup()
-		var textvalue = rawtext(text, seenComment, next.typ == itemComment)
+		var textvalue = rawtextWith(t.opts.LineJoining, text, seenComment, next.typ == itemComment)
 		if len(textvalue) == 0 {
 			return nil, false
 		}
-		return &ast.RawTextNode{token.pos, textvalue}, false
+		return t.opts.Arena.rawTextNode(ast.RawTextNode{token.pos, textvalue}), false
 	case itemLeftDelim:
 		return t.beginTag(), false
 	case itemSoyDocStart:
@@ -130,7 +163,9 @@ func (t *tree) beginTag() ast.Node {
 	switch token := t.next(); token.typ {
 	case itemNamespace:
 		return t.parseNamespace(token)
-	case itemTemplate:
+	case itemDelpackage:
+		return t.parseDelPackage(token)
+	case itemTemplate, itemDeltemplate:
 		return t.parseTemplate(token)
 	case itemIf:
 		return t.parseIf(token)
@@ -140,12 +175,16 @@ func (t *tree) beginTag() ast.Node {
 		return t.parseFor(token)
 	case itemSwitch:
 		return t.parseSwitch(token)
-	case itemCall:
+	case itemPlural:
+		return t.parsePlural(token)
+	case itemSelect:
+		return t.parseSelect(token)
+	case itemCall, itemDelcall:
 		return t.parseCall(token)
 	case itemLiteral:
 		t.expect(itemRightDelim, "literal")
 		literalText := t.expect(itemText, "literal")
-		n := &ast.RawTextNode{literalText.pos, []byte(literalText.val)}
+		n := t.opts.Arena.rawTextNode(ast.RawTextNode{literalText.pos, []byte(literalText.val)})
 		t.expect(itemLeftDelim, "literal")
 		t.expect(itemLiteralEnd, "literal")
 		t.expect(itemRightDelim, "literal")
@@ -167,7 +206,7 @@ func (t *tree) beginTag() ast.Node {
 		return nil
 	case itemNil, itemSpace, itemTab, itemNewline, itemCarriageReturn, itemLeftBrace, itemRightBrace:
 		t.expect(itemRightDelim, "special char")
-		return &ast.RawTextNode{token.pos, []byte(specialChars[token.typ])}
+		return t.opts.Arena.rawTextNode(ast.RawTextNode{token.pos, []byte(specialChars[token.typ])})
 	case itemIdent, itemDollarIdent, itemNull, itemBool, itemFloat, itemInteger, itemString, itemNegate, itemNot, itemLeftBracket:
 		// print is implicit, so the tag may also begin with any value type or unary op.
 		t.backup()
@@ -187,7 +226,7 @@ func (t *tree) parsePrint(token item) ast.Node {
 	for {
 		switch tok := t.next(); tok.typ {
 		case itemRightDelim:
-			return &ast.PrintNode{token.pos, expr, directives}
+			return t.opts.Arena.printNode(ast.PrintNode{token.pos, expr, directives})
 		case itemPipe:
 			// read the directive name and see if there are arguments
 			var id = t.expect(itemIdent, "print directive")
@@ -213,14 +252,16 @@ func (t *tree) parsePrint(token item) ast.Node {
 // Aliases are applied at immediately (at parse time) to new nodes.
 // "alias" has just been read.
 func (t *tree) parseAlias(token item) {
-	var name = t.expect(itemIdent, "alias").val
-	var lastSegment = name
+	var first = t.expect(itemIdent, "alias")
+	var name, lastSegment, last = first.val, first.val, first
 	for {
 		switch next := t.next(); next.typ {
 		case itemDotIdent:
 			name += next.val
 			lastSegment = next.val[1:]
+			last = next
 		case itemRightDelim:
+			t.addRef(first, last, name, refAliasDecl, "")
 			t.aliases[lastSegment] = name
 			return
 		default:
@@ -238,7 +279,15 @@ func (t *tree) parseLet(token item) ast.Node {
 		t.expect(itemRightDelimEnd, "let")
 		return node
 	case itemRightDelim:
-		var node = &ast.LetContentNode{token.pos, name.val[1:], t.itemList(itemLetEnd)}
+		var node = &ast.LetContentNode{token.pos, name.val[1:], t.itemList(itemLetEnd), ""}
+		t.expect(itemRightDelim, "let")
+		return node
+	case itemIdent:
+		t.backup()
+		var attrs, _ = t.parseAttrs("let", "kind")
+		var kind = t.parseKind(attrs)
+		t.expect(itemRightDelim, "let")
+		var node = &ast.LetContentNode{token.pos, name.val[1:], t.itemList(itemLetEnd), kind}
 		t.expect(itemRightDelim, "let")
 		return node
 	default:
@@ -263,19 +312,27 @@ func (t *tree) parseCss(token item) ast.Node {
 	}
 }
 
-// "call" has just been read.
+// "call" or "delcall" has just been read.
 func (t *tree) parseCall(token item) ast.Node {
+	var delegate = token.typ == itemDelcall
 	var templateName string
+	var first, last item // the tokens spanning the template name, if given positionally
 	switch tok := t.next(); tok.typ {
 	case itemDotIdent:
+		if delegate {
+			t.errorf("delcall: delegate template name must be fully qualified, got %q", tok.val)
+		}
 		templateName = tok.val
+		first, last = tok, tok
 	case itemIdent:
 		// this ident could either be {call fully.qualified.name} or attributes.
 		switch tok2 := t.next(); tok2.typ {
 		case itemDotIdent:
 			templateName = tok.val + tok2.val
+			first, last = tok, tok2
 			for tokn := t.next(); tokn.typ == itemDotIdent; tokn = t.next() {
 				templateName += tokn.val
+				last = tokn
 			}
 			t.backup()
 		default:
@@ -284,23 +341,41 @@ func (t *tree) parseCall(token item) ast.Node {
 	default:
 		t.backup()
 	}
-	attrs := t.parseAttrs("name", "data")
+	var attrs map[string]string
+	var unknown ast.Attrs
+	if delegate {
+		attrs, unknown = t.parseAttrs(token.val, "name", "data", "variant", "allowemptydefault", "phname", "key", "errorfallback")
+	} else {
+		attrs, unknown = t.parseAttrs(token.val, "name", "data", "phname", "key", "errorfallback")
+	}
 
 	if templateName == "" {
 		templateName = attrs["name"]
+		if tok, ok := t.attrItems["name"]; ok {
+			// the name lies within the quotes.
+			first = item{tok.typ, tok.pos - 1, tok.val[1 : len(tok.val)-1]}
+			last = first
+		}
 	}
 	if templateName == "" {
 		t.errorf("call: template name not found")
 	}
 
 	// If it's not a fully qualified template name, apply the namespace or aliases
+	var kind, alias = refQualified, ""
 	if templateName[0] == '.' {
+		if delegate {
+			t.errorf("delcall: delegate template name must be fully qualified, got %q", templateName)
+		}
 		templateName = t.namespace + templateName
+		kind = refRelative
 	} else if dot := strings.Index(templateName, "."); dot != -1 {
-		if alias, ok := t.aliases[templateName[:dot]]; ok {
-			templateName = alias + templateName[dot:]
+		if aliased, ok := t.aliases[templateName[:dot]]; ok {
+			templateName = aliased + templateName[dot:]
+			kind, alias = refAliased, aliased
 		}
 	}
+	t.addRef(first, last, templateName, kind, alias)
 
 	var allData = false
 	var dataNode ast.Node = nil
@@ -312,15 +387,39 @@ func (t *tree) parseCall(token item) ast.Node {
 		}
 	}
 
+	var variant ast.Node
+	if str, ok := attrs["variant"]; ok {
+		variant = t.parseQuotedExpr(str)
+	}
+	var allowEmptyDefault = t.boolAttr(attrs, "allowemptydefault", false)
+	var phname = attrs["phname"]
+	if phname != "" && !isIdentifier(phname) {
+		t.errorf("call: phname must be an identifier, got %q", phname)
+	}
+	var key ast.Node
+	if str, ok := attrs["key"]; ok {
+		key = t.parseQuotedExpr(str)
+	}
+	var errorFallback, ok = attrs["errorfallback"]
+	if ok && errorFallback != "skip" {
+		t.errorf(`call: expected "skip" for errorfallback, got %q`, errorFallback)
+	}
+
+	var end = itemCallEnd
+	if delegate {
+		end = itemDelcallEnd
+	}
 	switch tok := t.next(); tok.typ {
 	case itemRightDelimEnd:
-		return &ast.CallNode{token.pos, templateName, allData, dataNode, nil}
+		return &ast.CallNode{token.pos, templateName, allData, dataNode, nil,
+			delegate, variant, allowEmptyDefault, phname, key, errorFallback, unknown}
 	case itemRightDelim:
-		body := t.parseCallParams()
+		body := t.parseCallParams(end)
 		t.expect(itemLeftDelim, "call")
-		t.expect(itemCallEnd, "call")
+		t.expect(end, "call")
 		t.expect(itemRightDelim, "call")
-		return &ast.CallNode{token.pos, templateName, allData, dataNode, body}
+		return &ast.CallNode{token.pos, templateName, allData, dataNode, body,
+			delegate, variant, allowEmptyDefault, phname, key, errorFallback, unknown}
 	default:
 		t.unexpected(tok, "error scanning {call}")
 	}
@@ -333,9 +432,13 @@ func (t *tree) parseCall(token item) ast.Node {
 // {param a}expr{/param}
 // {param key="a" value="'expr'"/}
 // {param key="a"}expr{/param}
-// The closing delimiter of the {call} has just been read.
-func (t *tree) parseCallParams() []ast.Node {
+// Any other content of the {call} is passed as the "children" param, of kind
+// HTML, e.g. to a layout template that wraps it.
+// The closing delimiter of the {call} has just been read, and end is the type
+// of the tag that closes it.
+func (t *tree) parseCallParams(end itemType) []ast.Node {
 	var params []ast.Node
+	var children *ast.ListNode
 	for {
 		var (
 			key   string
@@ -343,26 +446,39 @@ func (t *tree) parseCallParams() []ast.Node {
 		)
 
 		var initial = t.nextNonComment()
-		for initial.typ == itemText {
-			// content is not allowed outside a param, but it's ok if it's a comment.
-			// see if anything is left after running it through rawtext()
-			var text = rawtext(initial.val, true, true)
-			if len(text) != 0 {
-				t.unexpected(initial, "{call}, in between {param}'s (orphan content)")
+		if initial.typ == itemText {
+			// content outside of a param is a child, unless it's only
+			// whitespace around comments.
+			if len(rawtext(initial.val, true, true)) == 0 {
+				continue
+			}
+			if node, _ := t.textOrTag(initial, nil); node != nil {
+				children = appendChild(children, initial, node)
 			}
-			initial = t.nextNonComment()
+			continue
 		}
 		if initial.typ != itemLeftDelim {
 			t.unexpected(initial, "param list (expected '{')")
 		}
 
 		var cmd = t.next()
-		if cmd.typ == itemCallEnd {
+		if cmd.typ == end {
 			t.backup2(initial)
+			if children != nil {
+				for _, param := range params {
+					if paramKey(param) == "children" {
+						t.errorf("call: children given both by {param children} and by content")
+					}
+				}
+				params = append(params, &ast.CallParamContentNode{children.Pos, "children", children, data.KindHTML})
+			}
 			return params
 		}
 		if cmd.typ != itemParam {
-			t.errorf("expected param declaration")
+			t.backup()
+			var node, _ = t.textOrTag(initial, nil)
+			children = appendChild(children, initial, node)
+			continue
 		}
 
 		var firstIdent = t.expect(itemIdent, "param")
@@ -377,7 +493,7 @@ func (t *tree) parseCallParams() []ast.Node {
 			key = firstIdent.val
 			value = t.itemList(itemParamEnd)
 			t.expect(itemRightDelim, "param")
-			params = append(params, &ast.CallParamContentNode{initial.pos, key, value})
+			params = append(params, &ast.CallParamContentNode{initial.pos, key, value, ""})
 			continue
 		case itemIdent:
 			key = firstIdent.val
@@ -388,7 +504,7 @@ func (t *tree) parseCallParams() []ast.Node {
 			t.unexpected(tok, "param. (expected ':', '}', or '=')")
 		}
 
-		attrs := t.parseAttrs("key", "value", "kind")
+		attrs, _ := t.parseAttrs("param", "key", "value", "kind")
 		var ok bool
 		if key == "" {
 			if key, ok = attrs["key"]; !ok {
@@ -397,11 +513,15 @@ func (t *tree) parseCallParams() []ast.Node {
 		}
 		var valueStr string
 		if valueStr, ok = attrs["value"]; !ok {
+			var kind = t.parseKind(attrs)
 			t.expect(itemRightDelim, "param")
 			value = t.itemList(itemParamEnd)
 			t.expect(itemRightDelim, "param")
-			params = append(params, &ast.CallParamContentNode{initial.pos, key, value})
+			params = append(params, &ast.CallParamContentNode{initial.pos, key, value, kind})
 		} else {
+			if _, ok = attrs["kind"]; ok {
+				t.errorf("param %s: kind is only allowed on params with content", key)
+			}
 			value = t.parseQuotedExpr(valueStr)
 			t.expect(itemRightDelimEnd, "param")
 			params = append(params, &ast.CallParamValueNode{initial.pos, key, value})
@@ -409,6 +529,27 @@ func (t *tree) parseCallParams() []ast.Node {
 	}
 }
 
+// appendChild adds a node of the content of a {call} to its children,
+// beginning at the given token.
+func appendChild(children *ast.ListNode, first item, node ast.Node) *ast.ListNode {
+	if children == nil {
+		children = &ast.ListNode{first.pos, nil}
+	}
+	children.Nodes = append(children.Nodes, node)
+	return children
+}
+
+// paramKey returns the key of a call param.
+func paramKey(param ast.Node) string {
+	switch param := param.(type) {
+	case *ast.CallParamValueNode:
+		return param.Key
+	case *ast.CallParamContentNode:
+		return param.Key
+	}
+	return ""
+}
+
 // "switch" has just been read.
 func (t *tree) parseSwitch(token item) ast.Node {
 	const ctx = "switch"
@@ -456,10 +597,18 @@ func (t *tree) parseCase(token item) *ast.SwitchCaseNode {
 // "for" or "foreach" has just been read.
 func (t *tree) parseFor(token item) ast.Node {
 	var ctx = token.val
-	// for and foreach have the same syntax, differing only in the requirement they impose:
-	// - for requires the collection to be a function call to "range"
-	// - foreach requires the collection to be a variable reference.
+	// for and foreach have the same syntax.  The collection is either a call to
+	// "range" or an expression resolving to a list; both may bind a positional
+	// index variable: {for $x, $i in $list}
 	var vartoken = t.expect(itemDollarIdent, ctx)
+	var indexVar string
+	if t.peek().typ == itemComma {
+		t.next()
+		indexVar = t.expect(itemDollarIdent, ctx).val[1:]
+		if indexVar == vartoken.val[1:] {
+			t.errorf("%s: index variable must differ from the loop variable", ctx)
+		}
+	}
 	var intoken = t.expect(itemIdent, ctx)
 	if intoken.val != "in" {
 		t.unexpected(intoken, "for loop (expected 'in')")
@@ -468,12 +617,6 @@ func (t *tree) parseFor(token item) ast.Node {
 	// get the collection to iterate through and enforce the requirements
 	var collection = t.parseExpr(0)
 	t.expect(itemRightDelim, "foreach")
-	if token.typ == itemFor {
-		f, ok := collection.(*ast.FunctionNode)
-		if !ok || f.Name != "range" {
-			t.errorf("for: expected to iterate through range()")
-		}
-	}
 
 	var body = t.itemList(itemIfempty, itemForeachEnd, itemForEnd)
 	t.backup()
@@ -483,7 +626,7 @@ func (t *tree) parseFor(token item) ast.Node {
 		ifempty = t.itemList(itemForeachEnd, itemForEnd)
 	}
 	t.expect(itemRightDelim, "/foreach")
-	return &ast.ForNode{token.pos, vartoken.val[1:], collection, body, ifempty}
+	return &ast.ForNode{token.pos, vartoken.val[1:], collection, body, ifempty, indexVar}
 }
 
 // "if" has just been read.
@@ -513,19 +656,38 @@ func (t *tree) parseIf(token item) ast.Node {
 
 func (t *tree) parseSoyDoc(token item) ast.Node {
 	var params []*ast.SoyDocParamNode
+	var desc []string
+	var deprecated, inDeprecation bool
+	var deprecationNote string
 	for {
 		var optional = false
 		switch next := t.next(); next.typ {
 		case itemText:
-			// ignore
+			// text describes the preceding param or @deprecated, or the template
+			// if none.
+			var text = strings.TrimSpace(next.val)
+			switch {
+			case text == "":
+			case text == "@deprecated" || strings.HasPrefix(text, "@deprecated "):
+				deprecated, inDeprecation = true, true
+				deprecationNote = strings.TrimSpace(text[len("@deprecated"):])
+			case inDeprecation:
+				deprecationNote = strings.TrimSpace(deprecationNote + " " + text)
+			case len(params) > 0:
+				var param = params[len(params)-1]
+				param.Desc = strings.TrimSpace(param.Desc + " " + text)
+			default:
+				desc = append(desc, text)
+			}
 		case itemSoyDocOptionalParam:
 			optional = true
 			fallthrough
 		case itemSoyDocParam:
 			var ident = t.expect(itemIdent, "soydoc param")
-			params = append(params, &ast.SoyDocParamNode{next.pos, ident.val, optional})
+			params = append(params, &ast.SoyDocParamNode{next.pos, ident.val, optional, ""})
+			inDeprecation = false
 		case itemSoyDocEnd:
-			return &ast.SoyDocNode{token.pos, params}
+			return &ast.SoyDocNode{token.pos, params, strings.Join(desc, "\n"), deprecated, deprecationNote}
 		default:
 			t.unexpected(next, "soydoc")
 		}
@@ -541,24 +703,45 @@ func inStringSlice(item string, group []string) bool {
 	return false
 }
 
-func (t *tree) parseAttrs(allowedNames ...string) map[string]string {
+// parseAttrs parses the attributes of the given tag, returning those with the
+// allowed names.  Any others are handled according to the UnknownAttrs policy
+// and, if kept, returned separately.
+func (t *tree) parseAttrs(tag string, allowedNames ...string) (map[string]string, ast.Attrs) {
 	var result = make(map[string]string)
+	var unknown ast.Attrs
+	if t.refs != nil {
+		t.attrItems = make(map[string]item)
+	}
 	for {
 		switch tok := t.next(); tok.typ {
 		case itemIdent:
-			if !inStringSlice(tok.val, allowedNames) {
+			var allowed = inStringSlice(tok.val, allowedNames)
+			if !allowed && t.opts.UnknownAttrs == AttrError {
 				t.unexpected(tok, fmt.Sprintf("attributes. allowed: %v", allowedNames))
 			}
 			t.expect(itemEquals, "attribute")
 			var attrval = t.expect(itemString, "attribute")
-			var err error
-			result[tok.val], err = strconv.Unquote(attrval.val)
+			var val, err = strconv.Unquote(attrval.val)
 			if err != nil {
 				t.error(err)
 			}
+			if t.refs != nil {
+				t.attrItems[tok.val] = attrval
+			}
+			if allowed {
+				result[tok.val] = val
+				continue
+			}
+			if t.opts.UnknownAttrs == AttrWarn && t.opts.UnknownAttrWarning != nil {
+				t.opts.UnknownAttrWarning(UnknownAttr{t.name, t.lex.lineNumber(tok.pos), tag, tok.val, val})
+			}
+			if unknown == nil {
+				unknown = make(ast.Attrs)
+			}
+			unknown[tok.val] = val
 		case itemRightDelim, itemRightDelimEnd:
 			t.backup()
-			return result
+			return result, unknown
 		default:
 			t.unexpected(tok, "attributes")
 		}
@@ -568,32 +751,216 @@ func (t *tree) parseAttrs(allowedNames ...string) map[string]string {
 // "msg" has just been read.
 func (t *tree) parseMsg(token item) ast.Node {
 	const ctx = "msg"
-	var attrs = t.parseAttrs("desc", "meaning", "hidden")
+	var attrs, unknown = t.parseAttrs(ctx, "desc", "meaning", "hidden")
 	if _, ok := attrs["desc"]; !ok {
-		t.errorf("Tag 'msg' must have a 'desc' attribute")
+		var at = t.position(token.pos) // the end of the "msg" keyword
+		t.errorfFix([]diag.Fix{{"add a desc attribute", diag.Range{at, at}, ` desc=""`}},
+			"Tag 'msg' must have a 'desc' attribute")
 	}
 	t.expect(itemRightDelim, ctx)
-	var node = &ast.MsgNode{token.pos, attrs["desc"], t.itemList(itemMsgEnd)}
+	t.inMsg = true
+	var body = t.itemList(itemMsgEnd)
+	t.inMsg = false
+	t.checkMsgCommands(body)
+	var node = &ast.MsgNode{token.pos, attrs["desc"], body, attrs["meaning"], 0, unknown}
 	t.expect(itemRightDelim, ctx)
+	soymsg.SetPlaceholdersAndID(node)
 	return node
 }
 
+// checkMsgCommands fails if a {plural} or {select} within the given message
+// body is nested within another command, since it would be hidden from
+// translators within a placeholder.
+func (t *tree) checkMsgCommands(body *ast.ListNode) {
+	for _, child := range body.Nodes {
+		switch child := child.(type) {
+		case *ast.PluralNode:
+			for _, pluralCase := range child.Cases {
+				t.checkMsgCommands(pluralCase.Body.(*ast.ListNode))
+			}
+			t.checkMsgCommands(child.Default.(*ast.ListNode))
+		case *ast.SelectNode:
+			for _, selectCase := range child.Cases {
+				t.checkMsgCommands(selectCase.Body.(*ast.ListNode))
+			}
+			t.checkMsgCommands(child.Default.(*ast.ListNode))
+		case *ast.RawTextNode:
+		default:
+			if cmd := findMsgCommand(child); cmd != "" {
+				t.errorf("{%s} must be directly within {msg}, not within %v", cmd, child)
+			}
+		}
+	}
+}
+
+// findMsgCommand returns the name of a {plural} or {select} command within the
+// given node, or "" if there is none.
+func findMsgCommand(node ast.Node) string {
+	switch node.(type) {
+	case *ast.PluralNode:
+		return "plural"
+	case *ast.SelectNode:
+		return "select"
+	}
+	if parent, ok := node.(ast.ParentNode); ok {
+		for _, child := range parent.Children() {
+			if child == nil {
+				continue
+			}
+			if cmd := findMsgCommand(child); cmd != "" {
+				return cmd
+			}
+		}
+	}
+	return ""
+}
+
+// "plural" has just been read.
+func (t *tree) parsePlural(token item) ast.Node {
+	const ctx = "plural"
+	if !t.inMsg {
+		t.errorf("{plural} is only allowed within {msg}")
+	}
+	var value = t.parseExpr(0)
+	t.expect(itemRightDelim, ctx)
+	var node = &ast.PluralNode{token.pos, "", value, nil, nil}
+	for {
+		switch tok := t.next(); tok.typ {
+		case itemLeftDelim:
+		case itemText: // ignore spaces between tags. text is an error though.
+			if allSpace(tok.val) {
+				continue
+			}
+			t.unexpected(tok, "between plural cases")
+		case itemCase:
+			if node.Default != nil {
+				t.errorf("{case} must precede {default} in {plural}")
+			}
+			var count, ok = t.parseExpr(0).(*ast.IntNode)
+			if !ok {
+				t.errorf("{case} in {plural} requires an integer count")
+			}
+			for _, prev := range node.Cases {
+				if prev.Value == count.Value {
+					t.errorf("duplicate {case %d} in {plural}", count.Value)
+				}
+			}
+			t.expect(itemRightDelim, ctx)
+			var body = t.itemList(itemCase, itemDefault, itemPluralEnd)
+			t.backup()
+			node.Cases = append(node.Cases, &ast.PluralCaseNode{tok.pos, count.Value, body})
+		case itemDefault:
+			if node.Default != nil {
+				t.errorf("{plural} may have only one {default}")
+			}
+			t.expect(itemRightDelim, ctx)
+			node.Default = t.itemList(itemCase, itemDefault, itemPluralEnd)
+			t.backup()
+		case itemPluralEnd:
+			if node.Default == nil {
+				t.errorf("{plural} requires a {default} case")
+			}
+			t.expect(itemRightDelim, ctx)
+			return node
+		default:
+			t.unexpected(tok, ctx)
+		}
+	}
+}
+
+// "select" has just been read.
+func (t *tree) parseSelect(token item) ast.Node {
+	const ctx = "select"
+	if !t.inMsg {
+		t.errorf("{select} is only allowed within {msg}")
+	}
+	var value = t.parseExpr(0)
+	t.expect(itemRightDelim, ctx)
+	var node = &ast.SelectNode{token.pos, "", value, nil, nil}
+	for {
+		switch tok := t.next(); tok.typ {
+		case itemLeftDelim:
+		case itemText: // ignore spaces between tags. text is an error though.
+			if allSpace(tok.val) {
+				continue
+			}
+			t.unexpected(tok, "between select cases")
+		case itemCase:
+			if node.Default != nil {
+				t.errorf("{case} must precede {default} in {select}")
+			}
+			var str, ok = t.parseExpr(0).(*ast.StringNode)
+			if !ok {
+				t.errorf("{case} in {select} requires a string value")
+			}
+			for _, prev := range node.Cases {
+				if prev.Value == str.Value {
+					t.errorf("duplicate {case %v} in {select}", str)
+				}
+			}
+			t.expect(itemRightDelim, ctx)
+			var body = t.itemList(itemCase, itemDefault, itemSelectEnd)
+			t.backup()
+			node.Cases = append(node.Cases, &ast.SelectCaseNode{tok.pos, str.Value, body})
+		case itemDefault:
+			if node.Default != nil {
+				t.errorf("{select} may have only one {default}")
+			}
+			t.expect(itemRightDelim, ctx)
+			node.Default = t.itemList(itemCase, itemDefault, itemSelectEnd)
+			t.backup()
+		case itemSelectEnd:
+			if node.Default == nil {
+				t.errorf("{select} requires a {default} case")
+			}
+			t.expect(itemRightDelim, ctx)
+			return node
+		default:
+			t.unexpected(tok, ctx)
+		}
+	}
+}
+
+// "delpackage" has just been read.
+func (t *tree) parseDelPackage(token item) ast.Node {
+	const ctx = "delpackage"
+	if t.delpkg != "" {
+		t.errorf("file may have only one delpackage declaration")
+	}
+	if t.namespace != "" {
+		t.errorf("delpackage must precede the namespace declaration")
+	}
+	var first = t.expect(itemIdent, ctx)
+	var name = first.val
+	for tok := t.next(); tok.typ == itemDotIdent; tok = t.next() {
+		name += tok.val
+	}
+	t.backup()
+	t.expect(itemRightDelim, ctx)
+	t.delpkg = name
+	return &ast.DelPackageNode{token.pos, name}
+}
+
 func (t *tree) parseNamespace(token item) ast.Node {
 	if t.namespace != "" {
 		t.errorf("file may have only one namespace declaration")
 	}
 	const ctx = "namespace"
-	var name = t.expect(itemIdent, ctx).val
+	var first = t.expect(itemIdent, ctx)
+	var name, last = first.val, first
 	for {
 		switch part := t.next(); part.typ {
 		case itemDotIdent:
 			name += part.val
+			last = part
 		default:
 			t.backup()
-			var autoescape = t.parseAutoescape(t.parseAttrs("autoescape"))
+			t.addRef(first, last, name, refNamespace, "")
+			var attrs, unknown = t.parseAttrs(ctx, "autoescape")
+			var autoescape = t.parseAutoescape(attrs)
 			t.expect(itemRightDelim, ctx)
 			t.namespace = name
-			return &ast.NamespaceNode{token.pos, name, autoescape}
+			return &ast.NamespaceNode{token.pos, name, autoescape, unknown, false}
 		}
 	}
 }
@@ -612,30 +979,129 @@ func (t *tree) parseAutoescape(attrs map[string]string) ast.AutoescapeType {
 		return ast.AutoescapeOn
 	case "false":
 		return ast.AutoescapeOff
+	case "strict":
+		return ast.AutoescapeStrict
 	default:
-		t.errorf(`expected "true", "false", or "contextual" for autoescape, got %q`, val)
+		t.errorf(`expected "true", "false", "contextual", or "strict" for autoescape, got %q`, val)
 	}
 	panic("unreachable")
 }
 
+// parseKind returns the content kind specified in the attributes, or "" if
+// none was specified.
+func (t *tree) parseKind(attrs map[string]string) data.ContentKind {
+	var kind, ok = attrs["kind"]
+	if !ok {
+		return ""
+	}
+	switch kind := data.ContentKind(kind); kind {
+	case data.KindText, data.KindHTML, data.KindAttributes, data.KindURI, data.KindJS, data.KindCSS:
+		return kind
+	}
+	t.errorf(`expected one of "text", "html", "attributes", "uri", "js", or "css" for kind, got %q`, kind)
+	panic("unreachable")
+}
+
+// "template" or "deltemplate" has just been read.
 func (t *tree) parseTemplate(token item) ast.Node {
 	const ctx = "template tag"
-	var id = t.expect(itemDotIdent, ctx)
-	var attrs = t.parseAttrs("autoescape", "private")
+	var delegate = token.typ == itemDeltemplate
+	var name, variant string
+	var attrs map[string]string
+	var unknown ast.Attrs
+	var end = itemTemplateEnd
+	var priority int
+	var delpackage string
+	if delegate {
+		// Delegates in a package take priority over the defaults.
+		if t.delpkg != "" {
+			priority, delpackage = 1, t.delpkg
+		}
+		name = t.parseDelTemplateName()
+		attrs, unknown = t.parseAttrs(token.val, "autoescape", "variant", "kind")
+		variant = t.parseVariant(attrs)
+		end = itemDeltemplateEnd
+	} else {
+		var tok = t.expect(itemDotIdent, ctx)
+		name = t.namespace + tok.val
+		t.addRef(tok, tok, name, refTemplate, "")
+		attrs, unknown = t.parseAttrs(token.val, "autoescape", "private", "cacheable", "ttl", "kind")
+	}
 	var autoescape = t.parseAutoescape(attrs)
 	var private = t.boolAttr(attrs, "private", false)
+	var cacheable = t.boolAttr(attrs, "cacheable", false)
+	var ttl = t.parseTTL(attrs, cacheable)
+	var kind = t.parseKind(attrs)
 	t.expect(itemRightDelim, ctx)
 	tmpl := &ast.TemplateNode{
 		token.pos,
-		t.namespace + id.val,
-		t.itemList(itemTemplateEnd),
+		name,
+		t.itemList(end),
 		autoescape,
 		private,
+		cacheable,
+		ttl,
+		delegate,
+		variant,
+		priority,
+		delpackage,
+		kind,
+		unknown,
 	}
 	t.expect(itemRightDelim, ctx)
 	return tmpl
 }
 
+// parseDelTemplateName returns the fully-qualified name of a delegate
+// template.  "deltemplate" has just been read.
+func (t *tree) parseDelTemplateName() string {
+	var first = t.expect(itemIdent, "deltemplate name")
+	var name, last = first.val, first
+	for tok := t.next(); tok.typ == itemDotIdent; tok = t.next() {
+		name += tok.val
+		last = tok
+	}
+	t.backup()
+	t.addRef(first, last, name, refQualified, "")
+	return name
+}
+
+// parseVariant returns the variant given by the "variant" attribute of a
+// delegate template, which must be a string, integer, or global constant.
+func (t *tree) parseVariant(attrs map[string]string) string {
+	var str, ok = attrs["variant"]
+	if !ok {
+		return ""
+	}
+	switch node := t.parseQuotedExpr(str).(type) {
+	case *ast.StringNode:
+		return node.Value
+	case *ast.IntNode:
+		return strconv.FormatInt(node.Value, 10)
+	case *ast.GlobalNode:
+		return node.Value.String()
+	}
+	t.errorf("deltemplate variant must be a string, integer, or global constant, got %q", str)
+	panic("unreachable")
+}
+
+// parseTTL returns the duration given by the "ttl" attribute, which is only
+// allowed on cacheable templates.
+func (t *tree) parseTTL(attrs map[string]string, cacheable bool) time.Duration {
+	var str, ok = attrs["ttl"]
+	if !ok {
+		return 0
+	}
+	if !cacheable {
+		t.errorf("ttl requires cacheable=\"true\"")
+	}
+	var ttl, err = time.ParseDuration(str)
+	if err != nil || ttl <= 0 {
+		t.errorf("expected a positive duration for ttl, got %q", str)
+	}
+	return ttl
+}
+
 // Expressions ----------
 
 // Expr returns the parsed representation of the given soy expression.
@@ -665,9 +1131,16 @@ func (t *tree) boolAttr(attrs map[string]string, key string, defaultValue bool)
 // parseQuotedExpr ignores the current lex/parse state and parses the given
 // string as a standalone expression.
 func (t *tree) parseQuotedExpr(str string) ast.Node {
-	return (&tree{
-		lex: lexExpr("", str),
-	}).parseExpr(0)
+	var sub = &tree{
+		name:   t.name,
+		lex:    lexExpr("", str),
+		opts:   t.opts,
+		parens: t.parens,
+	}
+	if t.parens != nil {
+		sub.exprLine = t.lex.lineNumber(t.token[0].pos)
+	}
+	return sub.parseExpr(0)
 }
 
 var precedence = map[itemType]int{
@@ -705,6 +1178,9 @@ func (t *tree) parseExpr(prec int) ast.Node {
 		}
 		q++
 		n = newBinaryOpNode(tok, n, t.parseExpr(q))
+		if t.parens != nil {
+			t.auditPrecedence(tok, n)
+		}
 	}
 	if prec == 0 && tok.typ == itemTernIf {
 		return t.parseTernary(n)
@@ -723,6 +1199,9 @@ func (t *tree) parseExprFirstTerm() ast.Node {
 	case tok.typ == itemLeftParen:
 		n := t.parseExpr(0)
 		t.expect(itemRightParen, "soy expression")
+		if t.parens != nil {
+			t.parens[n] = true
+		}
 		return n
 	case isValue(tok):
 		return t.newValueNode(tok)
@@ -737,7 +1216,7 @@ func (t *tree) parseExprFirstTerm() ast.Node {
 //               | "[" Expr "]" | "?[" Expr "]" )*
 // TODO: Injected data
 func (t *tree) parseDataRef(tok item) ast.Node {
-	var ref = &ast.DataRefNode{tok.pos, tok.val[1:], nil}
+	var ref = t.opts.Arena.dataRefNode(ast.DataRefNode{tok.pos, tok.val[1:], nil})
 	for {
 		var accessNode ast.Node
 		var nullsafe = 0
@@ -746,7 +1225,7 @@ func (t *tree) parseDataRef(tok item) ast.Node {
 			nullsafe = 1
 			fallthrough
 		case itemDotIdent:
-			accessNode = &ast.DataRefKeyNode{tok.pos, nullsafe == 1, tok.val[nullsafe+1:]}
+			accessNode = t.opts.Arena.dataRefKeyNode(ast.DataRefKeyNode{tok.pos, nullsafe == 1, tok.val[nullsafe+1:]})
 		case itemQuestionDotIndex:
 			nullsafe = 1
 			fallthrough
@@ -755,18 +1234,18 @@ func (t *tree) parseDataRef(tok item) ast.Node {
 			if err != nil {
 				t.error(err)
 			}
-			accessNode = &ast.DataRefIndexNode{tok.pos, nullsafe == 1, int(index)}
+			accessNode = t.opts.Arena.dataRefIndexNode(ast.DataRefIndexNode{tok.pos, nullsafe == 1, int(index)})
 		case itemQuestionKey:
 			nullsafe = 1
 			fallthrough
 		case itemLeftBracket:
-			accessNode = &ast.DataRefExprNode{tok.pos, nullsafe == 1, t.parseExpr(0)}
+			accessNode = t.opts.Arena.dataRefExprNode(ast.DataRefExprNode{tok.pos, nullsafe == 1, t.parseExpr(0)})
 			t.expect(itemRightBracket, "dataref")
 		default:
 			t.backup()
 			return ref
 		}
-		ref.Access = append(ref.Access, accessNode)
+		ref.Access = t.opts.Arena.appendNode(ref.Access, accessNode)
 	}
 }
 
@@ -955,7 +1434,7 @@ func (t *tree) newValueNode(tok item) ast.Node {
 		if err != nil {
 			t.error(err)
 		}
-		return &ast.IntNode{tok.pos, value}
+		return t.opts.Arena.intNode(ast.IntNode{tok.pos, value})
 	case itemFloat:
 		// TODO: support scientific notation e.g. 6.02e23
 		value, err := strconv.ParseFloat(tok.val, 64)
@@ -968,7 +1447,7 @@ func (t *tree) newValueNode(tok item) ast.Node {
 		if err != nil {
 			t.errorf("error unquoting %s: %s", tok.val, err)
 		}
-		return &ast.StringNode{tok.pos, tok.val, s}
+		return t.opts.Arena.stringNode(ast.StringNode{tok.pos, tok.val, s})
 	case itemLeftBracket:
 		return t.parseListOrMap(tok)
 	case itemDollarIdent:
@@ -998,7 +1477,7 @@ func (t *tree) newGlobalNode(tok, next item) ast.Node {
 }
 
 func (t *tree) newFunctionNode(tok item) ast.Node {
-	node := &ast.FunctionNode{tok.pos, tok.val, nil}
+	node := t.opts.Arena.functionNode(ast.FunctionNode{tok.pos, tok.val, nil})
 	if t.peek().typ == itemRightParen {
 		t.next()
 		return node
@@ -1096,15 +1575,29 @@ func (t *tree) unexpected(token item, context string) {
 
 // errorf formats the error and terminates processing.
 func (t *tree) errorf(format string, args ...interface{}) {
+	t.errorfFix(nil, format, args...)
+}
+
+// errorfFix is like errorf, suggesting the given fixes for the error.
+func (t *tree) errorfFix(fixes []diag.Fix, format string, args ...interface{}) {
 	// get current token (taking account of backups)
 	var tok = t.token[0]
 	if t.peekCount > 0 {
 		tok = t.token[t.peekCount-1]
 	}
 	t.root = nil
-	format = fmt.Sprintf("template %s:%d:%d: %s", t.name,
-		t.lex.lineNumber(tok.pos), t.lex.columnNumber(tok.pos), format)
-	panic(fmt.Errorf(format, args...))
+	panic(&diag.Diagnostic{
+		Code:    "parse",
+		File:    t.name,
+		Range:   diag.Range{Start: t.position(tok.pos)},
+		Message: fmt.Sprintf(format, args...),
+		Fixes:   fixes,
+	})
+}
+
+// position returns the line and column of the given offset within the file.
+func (t *tree) position(pos ast.Pos) diag.Position {
+	return diag.Position{t.lex.lineNumber(pos), t.lex.columnNumber(pos)}
 }
 
 // error terminates processing.
@@ -1129,3 +1622,14 @@ func allSpace(str string) bool {
 	}
 	return true
 }
+
+// isIdentifier reports whether str is a valid identifier, e.g. a placeholder
+// name.
+func isIdentifier(str string) bool {
+	for i, ch := range str {
+		if !isAlphaNumeric(ch) || i == 0 && unicode.IsDigit(ch) {
+			return false
+		}
+	}
+	return str != ""
+}
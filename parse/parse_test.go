@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/harrisonzhao/soy/ast"
 	"github.com/harrisonzhao/soy/data"
@@ -36,7 +37,7 @@ func tFile(nodes ...ast.Node) ast.Node {
 }
 
 func tTemplate(name string, nodes ...ast.Node) ast.Node {
-	n := &ast.TemplateNode{0, name, nil, ast.AutoescapeOn, false}
+	n := &ast.TemplateNode{0, name, nil, ast.AutoescapeOn, false, false, 0, false, "", 0, "", "", nil}
 	n.Body = newList(0)
 	n.Body.Nodes = nodes
 	return n
@@ -56,7 +57,9 @@ func str(value string) *ast.StringNode {
 
 var parseTests = []parseTest{
 	{"empty", "", tFile()},
-	{"namespace", "{namespace soy.example}", tFile(&ast.NamespaceNode{0, "soy.example", 0})},
+	{"namespace", "{namespace soy.example}", tFile(&ast.NamespaceNode{0, "soy.example", 0, nil, false})},
+	{"strict namespace", `{namespace soy.example autoescape="strict"}`,
+		tFile(&ast.NamespaceNode{0, "soy.example", ast.AutoescapeStrict, nil, false})},
 	{"empty template", "{template .name}{/template}", tFile(tTemplate(".name"))},
 	{"text template", "{template .name}\nHello world!\n{/template}",
 		tFile(tTemplate(".name", newText(0, "Hello world!")))},
@@ -85,13 +88,26 @@ var parseTests = []parseTest{
  * Text
  * @param boo scary description
  * @param? goo slimy
+ *     and slippery
  */`, tFile(&ast.SoyDocNode{0, []*ast.SoyDocParamNode{
-		{0, "boo", false},
-		{0, "goo", true},
-	}})},
+		{0, "boo", false, "scary description"},
+		{0, "goo", true, "slimy and slippery"},
+	}, "Text", false, ""})},
 	{"soydoc - one line", "/** @param name */", tFile(&ast.SoyDocNode{0, []*ast.SoyDocParamNode{
-		{0, "name", false},
-	}})},
+		{0, "name", false, ""},
+	}, "", false, ""})},
+	{"soydoc - description", `/**
+ * Says hello.
+ * Politely.
+ */`, tFile(&ast.SoyDocNode{0, nil, "Says hello.\nPolitely.", false, ""})},
+	{"soydoc - deprecated", `/**
+ * Says hello.
+ * @deprecated Use .greet
+ *     instead.
+ * @param name
+ */`, tFile(&ast.SoyDocNode{0, []*ast.SoyDocParamNode{
+		{0, "name", false, ""},
+	}, "Says hello.", true, "Use .greet instead."})},
 
 	{"rawtext (linejoin)", "\n  a \n\tb\r\n  c  \n\n", tFile(newText(0, "a b c"))},
 	{"rawtext+html", "\n  a <br>\n\tb\r\n\n  c\n\n<br> ", tFile(newText(0, "a <br>b c<br> "))},
@@ -286,7 +302,7 @@ var parseTests = []parseTest{
 			&ast.PrintNode{0, &ast.DataRefNode{0, "goose", []ast.Node{&ast.DataRefKeyNode{0, false, "numKids"}}}, nil},
 			newText(0, " goslings."),
 			newText(0, "\n"),
-		), nil},
+		), nil, ""},
 		&ast.ForNode{0, "boo", &ast.DataRefNode{0, "foo", []ast.Node{&ast.DataRefKeyNode{0, false, "booze"}}},
 			tList(
 				newText(0, "Scary drink "),
@@ -297,7 +313,7 @@ var parseTests = []parseTest{
 						&ast.NotNode{0, &ast.FunctionNode{0, "isLast", []ast.Node{&ast.DataRefNode{0, "boo", nil}}}},
 						tList(newText(0, "\n"))}}}),
 			tList(
-				newText(0, "Sorry, no booze."))},
+				newText(0, "Sorry, no booze.")), ""},
 	)},
 
 	{"for", `
@@ -314,17 +330,25 @@ var parseTests = []parseTest{
 					&ast.IntNode{0, 1})}}},
 			tList(
 				&ast.MsgNode{0, "Numbered item.", tList(
-					&ast.PrintNode{0, &ast.DataRefNode{0, "i", nil}, nil},
+					&ast.MsgPlaceholderNode{0, "I", &ast.PrintNode{0, &ast.DataRefNode{0, "i", nil}, nil}},
 					newText(0, ": "),
-					&ast.PrintNode{0, &ast.DataRefNode{0, "items", []ast.Node{
+					&ast.MsgPlaceholderNode{0, "XXX", &ast.PrintNode{0, &ast.DataRefNode{0, "items", []ast.Node{
 						&ast.DataRefExprNode{0, false,
 							&ast.SubNode{bin(
 								&ast.DataRefNode{0, "i", nil},
-								&ast.IntNode{0, 1})}}}}, nil},
+								&ast.IntNode{0, 1})}}}}, nil}},
 
 					newText(0, "\n"), // {\n}
-				)}),
-			nil},
+				), "", 0, nil}),
+			nil, ""},
+	)},
+
+	{"foreach with index", `{foreach $x, $i in $list}{$i}: {$x}{/foreach}`, tFile(
+		&ast.ForNode{0, "x", &ast.DataRefNode{0, "list", nil}, tList(
+			&ast.PrintNode{0, &ast.DataRefNode{0, "i", nil}, nil},
+			newText(0, ": "),
+			&ast.PrintNode{0, &ast.DataRefNode{0, "x", nil}, nil},
+		), nil, "i"},
 	)},
 
 	{"data ref", "{$boo.0['foo'+'bar'][5]?.goo}", tFile(&ast.PrintNode{0, &ast.DataRefNode{0, "boo", []ast.Node{
@@ -355,26 +379,28 @@ var parseTests = []parseTest{
   {param zoo: 0 /}
   {param doo kind="html"}doopoo{/param}
 {/call}`, tFile(
-		&ast.CallNode{0, ".booTemplate_", false, nil, nil},
-		&ast.CallNode{0, "foo.goo.mooTemplate", true, nil, nil},
+		&ast.CallNode{0, ".booTemplate_", false, nil, nil, false, nil, false, "", nil, "", nil},
+		&ast.CallNode{0, "foo.goo.mooTemplate", true, nil, nil, false, nil, false, "", nil, "", nil},
 		&ast.CallNode{0, ".zooTemplate", false, &ast.DataRefNode{0, "animals", nil}, []ast.Node{
 			&ast.CallParamValueNode{0, "yoo", &ast.FunctionNode{0, "round", []ast.Node{&ast.DataRefNode{0, "too", nil}}}},
-			&ast.CallParamContentNode{0, "woo", tList(newText(0, "poo"))},
-			&ast.CallParamContentNode{0, "doo", tList(newText(0, "doopoo"))}}},
-		&ast.CallNode{0, "a.long.template.booTemplate_", false, nil, nil},
+			&ast.CallParamContentNode{0, "woo", tList(newText(0, "poo")), ""},
+			&ast.CallParamContentNode{0, "doo", tList(newText(0, "doopoo")), data.KindHTML}}, false, nil, false, "", nil, "", nil},
+		&ast.CallNode{0, "a.long.template.booTemplate_", false, nil, nil, false, nil, false, "", nil, "", nil},
 		&ast.CallNode{0, ".zooTemplate", false, &ast.DataRefNode{0, "animals", nil}, []ast.Node{
 			&ast.CallParamValueNode{0, "yoo", &ast.FunctionNode{0, "round", []ast.Node{&ast.DataRefNode{0, "too", nil}}}},
-			&ast.CallParamContentNode{0, "woo", tList(newText(0, "poo"))},
+			&ast.CallParamContentNode{0, "woo", tList(newText(0, "poo")), ""},
 			&ast.CallParamValueNode{0, "zoo", &ast.IntNode{0, 0}},
-			&ast.CallParamContentNode{0, "doo", tList(newText(0, "doopoo"))}}},
+			&ast.CallParamContentNode{0, "doo", tList(newText(0, "doopoo")), data.KindHTML}}, false, nil, false, "", nil, "", nil},
 	)},
 
 	{"let", `
 {let $alpha: $boo.foo /}
 {let $beta}Boo!{/let}
-`, /*{let $delta kind="html"}Boo!{/let}*/ tFile(
+{let $delta kind="html"}Boo!{/let}
+`, tFile(
 		&ast.LetValueNode{0, "alpha", &ast.DataRefNode{0, "boo", []ast.Node{&ast.DataRefKeyNode{0, false, "foo"}}}},
-		&ast.LetContentNode{0, "beta", tList(newText(0, "Boo!"))},
+		&ast.LetContentNode{0, "beta", tList(newText(0, "Boo!")), ""},
+		&ast.LetContentNode{0, "delta", tList(newText(0, "Boo!")), data.KindHTML},
 	)},
 
 	{"comments", `
@@ -392,7 +418,33 @@ var parseTests = []parseTest{
 	)},
 
 	{"alias", `{alias a.b.c}{call c.d/}`, tFile(
-		&ast.CallNode{0, "a.b.c.d", false, nil, nil},
+		&ast.CallNode{0, "a.b.c.d", false, nil, nil, false, nil, false, "", nil, "", nil},
+	)},
+
+	{"delegates", `
+{deltemplate a.b variant="'x'"}{/deltemplate}
+{delcall a.b variant="$v" allowemptydefault="true"/}
+{delcall a.b}{param c: 1 /}{/delcall}`, tFile(
+		&ast.TemplateNode{0, "a.b", newList(0), ast.AutoescapeOn, false, false, 0, true, "x", 0, "", "", nil},
+		&ast.CallNode{0, "a.b", false, nil, nil, true, &ast.DataRefNode{0, "v", nil}, true, "", nil, "", nil},
+		&ast.CallNode{0, "a.b", false, nil, []ast.Node{
+			&ast.CallParamValueNode{0, "c", &ast.IntNode{0, 1}}}, true, nil, false, "", nil, "", nil},
+	)},
+
+	{"delpackage", `{delpackage acme.brand}
+{namespace a}
+{deltemplate a.b}{/deltemplate}`, tFile(
+		&ast.DelPackageNode{0, "acme.brand"},
+		&ast.NamespaceNode{0, "a", 0, nil, false},
+		&ast.TemplateNode{0, "a.b", newList(0), ast.AutoescapeOn, false, false, 0, true, "", 1, "acme.brand", "", nil},
+	)},
+
+	{"template kind", `{namespace a}
+{template .b kind="uri"}{/template}
+{deltemplate a.c kind="text"}{/deltemplate}`, tFile(
+		&ast.NamespaceNode{0, "a", 0, nil, false},
+		&ast.TemplateNode{0, "a.b", newList(0), ast.AutoescapeOn, false, false, 0, false, "", 0, "", data.KindURI, nil},
+		&ast.TemplateNode{0, "a.c", newList(0), ast.AutoescapeOn, false, false, 0, true, "", 0, "", data.KindText, nil},
 	)},
 }
 
@@ -446,12 +498,22 @@ func eqTree(t *testing.T, expected, actual ast.Node) bool {
 	case *ast.ListNode:
 		return eqNodes(t, expected.(*ast.ListNode).Nodes, actual.(*ast.ListNode).Nodes)
 	case *ast.NamespaceNode:
+		if expected.(*ast.NamespaceNode).Autoescape != actual.(*ast.NamespaceNode).Autoescape {
+			t.Errorf("namespace autoescape: expected %v, got %v",
+				expected.(*ast.NamespaceNode).Autoescape, actual.(*ast.NamespaceNode).Autoescape)
+			return false
+		}
 		return eqstr(t, "namespace", expected.(*ast.NamespaceNode).Name, actual.(*ast.NamespaceNode).Name)
 	case *ast.TemplateNode:
-		if expected.(*ast.TemplateNode).Name != actual.(*ast.TemplateNode).Name {
+		if expected.(*ast.TemplateNode).Name != actual.(*ast.TemplateNode).Name ||
+			expected.(*ast.TemplateNode).Variant != actual.(*ast.TemplateNode).Variant ||
+			expected.(*ast.TemplateNode).Priority != actual.(*ast.TemplateNode).Priority ||
+			expected.(*ast.TemplateNode).Package != actual.(*ast.TemplateNode).Package {
 			return false
 		}
 		return eqTree(t, expected.(*ast.TemplateNode).Body, actual.(*ast.TemplateNode).Body)
+	case *ast.DelPackageNode:
+		return eqstr(t, "delpackage", expected.(*ast.DelPackageNode).Name, actual.(*ast.DelPackageNode).Name)
 	case *ast.RawTextNode:
 		return eqstr(t, "text", string(expected.(*ast.RawTextNode).Text), string(actual.(*ast.RawTextNode).Text))
 	case *ast.CssNode:
@@ -466,6 +528,7 @@ func eqTree(t *testing.T, expected, actual ast.Node) bool {
 			eqTree(t, expected.(*ast.LetValueNode).Expr, actual.(*ast.LetValueNode).Expr)
 	case *ast.LetContentNode:
 		return eqstr(t, "let", expected.(*ast.LetContentNode).Name, actual.(*ast.LetContentNode).Name) &&
+			eqstr(t, "let kind", string(expected.(*ast.LetContentNode).Kind), string(actual.(*ast.LetContentNode).Kind)) &&
 			eqTree(t, expected.(*ast.LetContentNode).Body, actual.(*ast.LetContentNode).Body)
 
 	case *ast.NullNode:
@@ -526,19 +589,32 @@ func eqTree(t *testing.T, expected, actual ast.Node) bool {
 			eqNodes(t, expected.(*ast.FunctionNode).Args, actual.(*ast.FunctionNode).Args)
 
 	case *ast.SoyDocNode:
-		return eqNodes(t, expected.(*ast.SoyDocNode).Params, actual.(*ast.SoyDocNode).Params)
+		return eqNodes(t, expected.(*ast.SoyDocNode).Params, actual.(*ast.SoyDocNode).Params) &&
+			eqstr(t, "soydoc", expected.(*ast.SoyDocNode).Desc, actual.(*ast.SoyDocNode).Desc) &&
+			eqbool(t, "deprecated", expected.(*ast.SoyDocNode).Deprecated, actual.(*ast.SoyDocNode).Deprecated) &&
+			eqstr(t, "deprecation", expected.(*ast.SoyDocNode).DeprecationNote, actual.(*ast.SoyDocNode).DeprecationNote)
 	case *ast.SoyDocParamNode:
 		return eqstr(t, "soydocparam", expected.(*ast.SoyDocParamNode).Name, actual.(*ast.SoyDocParamNode).Name) &&
-			eqbool(t, "soydocparam", expected.(*ast.SoyDocParamNode).Optional, actual.(*ast.SoyDocParamNode).Optional)
+			eqbool(t, "soydocparam", expected.(*ast.SoyDocParamNode).Optional, actual.(*ast.SoyDocParamNode).Optional) &&
+			eqstr(t, "soydocparam", expected.(*ast.SoyDocParamNode).Desc, actual.(*ast.SoyDocParamNode).Desc)
 	case *ast.PrintNode:
 		return eqTree(t, expected.(*ast.PrintNode).Arg, actual.(*ast.PrintNode).Arg)
 	case *ast.MsgNode:
 		return eqstr(t, "msg", expected.(*ast.MsgNode).Desc, actual.(*ast.MsgNode).Desc) &&
 			eqTree(t, expected.(*ast.MsgNode).Body, actual.(*ast.MsgNode).Body)
+	case *ast.MsgPlaceholderNode:
+		return eqstr(t, "placeholder", expected.(*ast.MsgPlaceholderNode).Name, actual.(*ast.MsgPlaceholderNode).Name) &&
+			eqTree(t, expected.(*ast.MsgPlaceholderNode).Body, actual.(*ast.MsgPlaceholderNode).Body)
 	case *ast.CallNode:
 		return eqstr(t, "call", expected.(*ast.CallNode).Name, actual.(*ast.CallNode).Name) &&
 			eqTree(t, expected.(*ast.CallNode).Data, actual.(*ast.CallNode).Data) &&
-			eqNodes(t, expected.(*ast.CallNode).Params, actual.(*ast.CallNode).Params)
+			eqNodes(t, expected.(*ast.CallNode).Params, actual.(*ast.CallNode).Params) &&
+			eqbool(t, "delegate", expected.(*ast.CallNode).Delegate, actual.(*ast.CallNode).Delegate) &&
+			eqTree(t, expected.(*ast.CallNode).Variant, actual.(*ast.CallNode).Variant) &&
+			eqbool(t, "allowemptydefault", expected.(*ast.CallNode).AllowEmptyDefault, actual.(*ast.CallNode).AllowEmptyDefault) &&
+			eqstr(t, "phname", expected.(*ast.CallNode).PhName, actual.(*ast.CallNode).PhName) &&
+			eqTree(t, expected.(*ast.CallNode).Key, actual.(*ast.CallNode).Key) &&
+			eqstr(t, "errorfallback", expected.(*ast.CallNode).ErrorFallback, actual.(*ast.CallNode).ErrorFallback)
 	case *ast.CallParamValueNode:
 		return eqstr(t, "param", expected.(*ast.CallParamValueNode).Key, actual.(*ast.CallParamValueNode).Key) &&
 			eqTree(t, expected.(*ast.CallParamValueNode).Value, actual.(*ast.CallParamValueNode).Value)
@@ -555,7 +631,8 @@ func eqTree(t *testing.T, expected, actual ast.Node) bool {
 		return eqstr(t, "for", expected.(*ast.ForNode).Var, actual.(*ast.ForNode).Var) &&
 			eqTree(t, expected.(*ast.ForNode).List, actual.(*ast.ForNode).List) &&
 			eqTree(t, expected.(*ast.ForNode).Body, actual.(*ast.ForNode).Body) &&
-			eqTree(t, expected.(*ast.ForNode).IfEmpty, actual.(*ast.ForNode).IfEmpty)
+			eqTree(t, expected.(*ast.ForNode).IfEmpty, actual.(*ast.ForNode).IfEmpty) &&
+			eqstr(t, "for index", expected.(*ast.ForNode).IndexVar, actual.(*ast.ForNode).IndexVar)
 	case *ast.SwitchNode:
 		return eqTree(t, expected.(*ast.SwitchNode).Value, actual.(*ast.SwitchNode).Value) &&
 			eqNodes(t, expected.(*ast.SwitchNode).Cases, actual.(*ast.SwitchNode).Cases)
@@ -700,6 +777,9 @@ func TestRecognizeCommands(t *testing.T) {
 		"    {default} bluh bluh\n"+
 		"  {/switch}\n")
 	works(t, "{foreach $item in $items}{index($item)}. {$item.name}<br>{/foreach}")
+	works(t, "{for $item, $i in $items}{$i}. {$item.name}<br>{/for}")
+	fails(t, "{for $item, $item in $items}{$item}{/for}")
+	fails(t, "{for $item, in $items}{$item}{/for}")
 	works(t, ""+
 		"{for $i in range($boo + 1,\n"+
 		"                 88, 11)}\n"+
@@ -724,13 +804,14 @@ func TestRecognizeCommands(t *testing.T) {
 		"  {param key=\"foo\"}blah blah{/param}\n"+
 		"{/call}")
 
-	// TODO: implement delcall
-	// works(t, "{delcall aaa.bbb.ccc data=\"all\" /}")
-	// works(t, ""+
-	// 	"{delcall name=\"ddd.eee\"}\n"+
-	// 	"  {{param key=\"boo\" value=\"$boo\" /}}\n"+
-	// 	"  {param key=\"foo\"}blah blah{/param}\n"+
-	// 	"{/delcall}")
+	works(t, "{delcall aaa.bbb.ccc data=\"all\" /}")
+	works(t, ""+
+		"{delcall name=\"ddd.eee\"}\n"+
+		"  {{param key=\"boo\" value=\"$boo\" /}}\n"+
+		"  {param key=\"foo\"}blah blah{/param}\n"+
+		"{/delcall}")
+	works(t, "{delcall aaa.bbb variant=\"$brand\" allowemptydefault=\"true\" /}")
+	works(t, "{call .aaa phname=\"AaaBbb\" key=\"$id\" errorfallback=\"skip\" data=\"all\" /}")
 
 	// TODO: implement phname
 	// works(t, ""+
@@ -749,8 +830,13 @@ func TestRecognizeCommands(t *testing.T) {
 	works(t, "{let $foo : '\"'/}\n")
 	works(t, "{let $foo}Hello{/let}\n")
 
-	// TODO: implement kind
-	// works(t, "{let $foo kind=\"html\"}Hello{/let}\n")
+	works(t, "{let $foo kind=\"html\"}Hello{/let}\n")
+	fails(t, "{let $foo kind=\"xml\"}Hello{/let}\n")
+	works(t, "{call .foo}{param bar kind=\"text\"}Hello{/param}{/call}\n")
+	fails(t, "{call .foo}{param bar kind=\"xml\"}Hello{/param}{/call}\n")
+	works(t, "{call .foo}{param bar: 1 /}<p>{$x}</p>{/call}\n")
+	fails(t, "{call .foo}{param children}a{/param}b{/call}\n")
+	fails(t, "{call .foo}{param key=\"bar\" value=\"1\" kind=\"html\" /}{/call}\n")
 
 	fails(t, "{msg}blah{/msg}")
 	fails(t, "{/msg}")
@@ -762,8 +848,32 @@ func TestRecognizeCommands(t *testing.T) {
 	// fails(t, "{msg desc=\"\"}blah{msg desc=\"\"}bleh{/msg}bluh{/msg}")
 
 	fails(t, "{msg desc=\"\"}blah{/msg blah}")
+
+	works(t, "{msg desc=\"\"}{plural $n}{case 1}one{default}{$n} many{/plural}{/msg}")
+	works(t, "{msg desc=\"\"}You have {plural length($items)}{case 0}none{case 1}one{default}some{/plural}.{/msg}")
+	fails(t, "{plural $n}{case 1}one{default}many{/plural}")
+	fails(t, "{msg desc=\"\"}{plural $n}{case 1}one{/plural}{/msg}")
+	fails(t, "{msg desc=\"\"}{plural $n}{case 1}one{case 1}uno{default}many{/plural}{/msg}")
+	fails(t, "{msg desc=\"\"}{plural $n}{case 'a'}one{default}many{/plural}{/msg}")
+	fails(t, "{msg desc=\"\"}{plural $n}{default}many{case 1}one{/plural}{/msg}")
+	fails(t, "{msg desc=\"\"}{if $a}{plural $n}{case 1}one{default}many{/plural}{/if}{/msg}")
+
+	works(t, "{msg desc=\"\"}{select $gender}{case 'female'}her{case 'male'}his{default}their{/select} book{/msg}")
+	works(t, "{msg desc=\"\"}{select $g}{case 'f'}{plural $n}{case 1}one{default}many{/plural}{default}x{/select}{/msg}")
+	fails(t, "{select $gender}{case 'female'}her{default}their{/select}")
+	fails(t, "{msg desc=\"\"}{select $gender}{case 'female'}her{/select}{/msg}")
+	fails(t, "{msg desc=\"\"}{select $gender}{case 1}her{default}their{/select}{/msg}")
+	fails(t, "{msg desc=\"\"}{select $g}{case 'f'}her{case 'f'}hers{default}their{/select}{/msg}")
+	fails(t, "{msg desc=\"\"}{let $x}{select $g}{case 'f'}her{default}their{/select}{/let}{/msg}")
 	fails(t, "{namespace}")
+	fails(t, "{namespace a autoescape=\"strictly\"}")
+	fails(t, "{delpackage}")
+	fails(t, "{namespace a}{delpackage b}")
+	fails(t, "{delpackage a}{delpackage b}{namespace c}")
 	fails(t, "{template}\n"+"blah\n"+"{/template}\n")
+	fails(t, "{template .foo ttl=\"60s\"}blah{/template}\n")
+	fails(t, "{template .foo cacheable=\"true\" ttl=\"soon\"}blah{/template}\n")
+	fails(t, "{template .foo cacheable=\"yes\"}blah{/template}\n")
 	fails(t, "{msg}<blah<blah>{/msg}")
 	fails(t, "{msg}blah>blah{/msg}")
 	fails(t, "{msg}<blah>blah>{/msg}")
@@ -772,6 +882,13 @@ func TestRecognizeCommands(t *testing.T) {
 	fails(t, "{call .aaa.bbb /}")
 	fails(t, "{delcall name=\"ddd.eee\"}{param foo: 0}{/call}")
 	fails(t, "{delcall .dddEee /}")
+	fails(t, "{delcall name=\".dddEee\" /}")
+	fails(t, "{call .aaa phname=\"boo.foo\" /}")
+	fails(t, "{call .aaa errorfallback=\"x\" /}")
+	fails(t, "{call .aaa key=\"1 +\" /}")
+	fails(t, "{call aaa.bbb variant=\"'x'\" /}")
+	fails(t, "{deltemplate aaa.bbb variant=\"$x\"}{/deltemplate}")
+	fails(t, "{deltemplate aaa.bbb private=\"true\"}{/deltemplate}")
 
 	// TODO: implement phname
 	// fails(t, "{msg desc=\"\"}{$boo phname=\"boo.foo\"}{/msg}")
@@ -823,3 +940,177 @@ func fails(t *testing.T, body string) {
 		t.Errorf("should fail: %s", body)
 	}
 }
+
+func TestPrecedenceAudit(t *testing.T) {
+	var tests = []struct {
+		input  string
+		issues []string
+	}{
+		{"{$a and $b or $c}", []string{"$a and $b or $c => $a and ($b or $c)"}},
+		{"{$a or $b and $c}", []string{"$a or $b and $c => ($a or $b) and $c"}},
+		{"{$a or ($b and $c)}", nil},
+		{"{print ($a or $b) and $c}", nil},
+		{"{$a == $b < $c}", []string{"$a == $b < $c => ($a == $b) < $c"}},
+		{"{$a < $b == $c}", nil},
+		{"{$a + $b * $c ?: $d or $e}", nil},
+		{"{not $a or $b and $c ? 1 : 2}", []string{"not $a or $b and $c => (not $a or $b) and $c"}},
+		{"{call .foo}{param x: $a or $b and $c /}{/call}",
+			[]string{"$a or $b and $c => ($a or $b) and $c"}},
+		{"{if $x}\n{$a or $b and $c == $d < $e}{/if}",
+			[]string{"$c == $d < $e => ($c == $d) < $e",
+				"$a or $b and $c == $d < $e => ($a or $b) and ($c == $d) < $e"}},
+	}
+	for _, test := range tests {
+		var issues []string
+		var opts = Options{PrecedenceAudit: func(issue PrecedenceIssue) {
+			issues = append(issues, issue.Expr+" => "+issue.Suggestion)
+		}}
+		if _, err := SoyFileWith(opts, "", test.input, nil); err != nil {
+			t.Error(err)
+			continue
+		}
+		if !reflect.DeepEqual(issues, test.issues) {
+			t.Errorf("%s: expected %q, got %q", test.input, test.issues, issues)
+		}
+	}
+}
+
+func TestTemplateCacheAttrs(t *testing.T) {
+	var tests = []struct {
+		input     string
+		cacheable bool
+		ttl       time.Duration
+	}{
+		{`{template .foo}{/template}`, false, 0},
+		{`{template .foo cacheable="true"}{/template}`, true, 0},
+		{`{template .foo cacheable="true" ttl="90s"}{/template}`, true, 90 * time.Second},
+	}
+	for _, test := range tests {
+		var f, err = SoyFile("", test.input, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		var tmpl = f.Body[0].(*ast.TemplateNode)
+		if tmpl.Cacheable != test.cacheable || tmpl.TTL != test.ttl {
+			t.Errorf("%s: expected cacheable=%v ttl=%v, got cacheable=%v ttl=%v",
+				test.input, test.cacheable, test.ttl, tmpl.Cacheable, tmpl.TTL)
+		}
+	}
+}
+
+func TestUnknownAttrs(t *testing.T) {
+	const input = `{namespace test requirecss="a.b"}
+{template .foo visibility="private"}
+{call .bar phname="x" future="1"/}
+{let $x kind="html" other="y"}{/let}
+{/template}`
+
+	if _, err := SoyFile("", input, nil); err == nil {
+		t.Errorf("expected unknown attributes to fail by default")
+	}
+
+	var warnings []string
+	var opts = Options{UnknownAttrs: AttrWarn, UnknownAttrWarning: func(attr UnknownAttr) {
+		warnings = append(warnings, attr.String())
+	}}
+	var f, err = SoyFileWith(opts, "test.soy", input, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = []string{
+		`test.soy:1: unrecognized attribute requirecss="a.b" on {namespace}`,
+		`test.soy:2: unrecognized attribute visibility="private" on {template}`,
+		`test.soy:3: unrecognized attribute future="1" on {call}`,
+		`test.soy:4: unrecognized attribute other="y" on {let}`,
+	}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("expected %q, got %q", expected, warnings)
+	}
+
+	var ns = f.Body[0].(*ast.NamespaceNode)
+	var tmpl = f.Body[1].(*ast.TemplateNode)
+	var call = tmpl.Body.Nodes[0].(*ast.CallNode)
+	if ns.Attrs["requirecss"] != "a.b" || tmpl.Attrs["visibility"] != "private" || call.Attrs["future"] != "1" {
+		t.Errorf("expected unknown attributes to be preserved, got %v, %v, %v", ns.Attrs, tmpl.Attrs, call.Attrs)
+	}
+	if call.PhName != "x" || len(call.Attrs) != 1 {
+		t.Errorf("expected known attributes to be handled as usual, got %v", call)
+	}
+	if actual := call.String(); actual != `{call test.bar phname="x" future="1"/}` {
+		t.Errorf("unexpected source: %s", actual)
+	}
+
+	warnings = nil
+	opts.UnknownAttrs = AttrPreserve
+	if f, err = SoyFileWith(opts, "test.soy", input, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) > 0 {
+		t.Errorf("expected no warnings, got %q", warnings)
+	}
+	if f.Body[0].(*ast.NamespaceNode).Attrs["requirecss"] != "a.b" {
+		t.Errorf("expected unknown attributes to be preserved")
+	}
+}
+
+func TestCallChildren(t *testing.T) {
+	var tree, err = SoyFile("", `{namespace test}
+{template .page}
+  {call .layout}
+    // the title
+    {param title: 'Hello' /}
+    <p>Hi {$name}</p>
+    {if $x}!{/if}
+  {/call}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var call = tree.Body[1].(*ast.TemplateNode).Body.Nodes[0].(*ast.CallNode)
+	if len(call.Params) != 2 {
+		t.Fatalf("expected 2 params, got %v", call.Params)
+	}
+	var children, ok = call.Params[1].(*ast.CallParamContentNode)
+	if !ok || children.Key != "children" || children.Kind != data.KindHTML {
+		t.Fatalf("expected html children, got %v", call.Params[1])
+	}
+	if actual := children.Content.String(); actual != "<p>Hi {$name}</p>{if $x}!{/if}" {
+		t.Errorf("unexpected children: %q", actual)
+	}
+}
+
+func TestArena(t *testing.T) {
+	var arena Arena
+	for _, test := range parseTests {
+		tmpl, err := SoyFileWith(Options{Arena: &arena}, test.name, test.input, globals)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !eqTree(t, test.tree, tmpl) {
+			t.Errorf("%s=(%q): got\n\t%v\nexpected\n\t%v", test.name, test.input, tmpl, test.tree)
+		}
+	}
+
+	var input = `{namespace test}
+/** @param items */
+{template .list}
+  {foreach $item in $items}
+    <li class="{$item.kind}">{$item.name} ({$item.tags[0]}, {length($item.tags)})</li>
+  {/foreach}
+{/template}
+`
+	var allocs = func(opts Options) float64 {
+		return testing.AllocsPerRun(50, func() {
+			if _, err := SoyFileWith(opts, "", input, nil); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	var without, with = allocs(Options{}), allocs(Options{Arena: &arena})
+	if with >= without {
+		t.Errorf("expected fewer allocations with an arena, got %v (vs %v without)", with, without)
+	}
+	arena.Reset()
+}
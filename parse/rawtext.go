@@ -25,6 +25,11 @@ func (l *rawtextlexer) next() rune {
 // - trim leading and trailing whitespace on each internal line
 // - join lines with no space if '<' or '>' are on either side, else with 1 space.
 func rawtext(s string, trimBefore, trimAfter bool) []byte {
+	return rawtextWith(LineJoinDefault, s, trimBefore, trimAfter)
+}
+
+// rawtextWith processes the raw text using the given line joining algorithm.
+func rawtextWith(joining LineJoining, s string, trimBefore, trimAfter bool) []byte {
 	var lex = rawtextlexer{s, 0, 0}
 	var (
 		spaces         = 0
@@ -74,7 +79,7 @@ func rawtext(s string, trimBefore, trimAfter bool) []byte {
 					result[resultLen] = s[i]
 					resultLen++
 				}
-			case seenNewline && !isTightJoiner(charBeforeTrim) && !isTightJoiner(r):
+			case seenNewline && !isTightJoin(joining, charBeforeTrim, r):
 				result[resultLen] = ' '
 				resultLen++
 			default:
@@ -101,6 +106,15 @@ func rawtext(s string, trimBefore, trimAfter bool) []byte {
 	}
 }
 
+// isTightJoin returns true if lines ending and starting with the given
+// characters should be joined without a space.
+func isTightJoin(joining LineJoining, before, after rune) bool {
+	if joining == LineJoinClosure {
+		return before == 0 || before == '>' || after == '<'
+	}
+	return isTightJoiner(before) || isTightJoiner(after)
+}
+
 func isTightJoiner(r rune) bool {
 	switch r {
 	case 0, '<', '>':
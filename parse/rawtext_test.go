@@ -69,3 +69,30 @@ func TestRawTextNoTrim(t *testing.T) {
 		}
 	}
 }
+
+// TestRawTextLineJoining verifies the line joining algorithms against the
+// cases where they differ, as well as ones where they agree.
+func TestRawTextLineJoining(t *testing.T) {
+	type test struct{ input, def, closure string }
+	var tests = []test{
+		{"a\nb", "a b", "a b"},
+		{"<a>\nb", "<a>b", "<a>b"},
+		{"a\n<b>", "a<b>", "a<b>"},
+		{"<a>\n<b>", "<a><b>", "<a><b>"},
+		{"a <\nb", "a <b", "a < b"},
+		{"a\n> b", "a> b", "a > b"},
+		{"1 <\n2 >\n3", "1 <2 >3", "1 < 2 >3"},
+		{"x >\n< y", "x >< y", "x >< y"},
+		{"∢\n∢", "∢ ∢", "∢ ∢"},
+	}
+	for _, test := range tests {
+		var actual = string(rawtextWith(LineJoinDefault, test.input, false, false))
+		if test.def != actual {
+			t.Errorf("default: input: %q, expected %q, got %q", test.input, test.def, actual)
+		}
+		actual = string(rawtextWith(LineJoinClosure, test.input, false, false))
+		if test.closure != actual {
+			t.Errorf("closure: input: %q, expected %q, got %q", test.input, test.closure, actual)
+		}
+	}
+}
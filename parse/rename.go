@@ -0,0 +1,112 @@
+package parse
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/harrisonzhao/soy/data"
+)
+
+// refKind identifies how a name is written in the source.
+type refKind int
+
+const (
+	refQualified refKind = iota // fully-qualified, e.g. {call a.b.c}
+	refRelative                 // relative to the namespace, e.g. {call .c}
+	refAliased                  // relative to an alias, e.g. {call b.c}
+	refTemplate                 // a template declaration, e.g. {template .c}
+	refNamespace                // a namespace declaration
+	refAliasDecl                // an alias declaration
+)
+
+// nameRef is an occurrence of a template, namespace, or alias name in the
+// source.
+type nameRef struct {
+	start, end int     // offsets of the name as written
+	name       string  // the fully-qualified name referred to
+	kind       refKind // how the name is written
+	alias      string  // the alias it is written relative to, for refAliased
+}
+
+// addRef records the name spanning the given tokens, if renaming.  (Token
+// positions are those of their ends.)
+func (t *tree) addRef(first, last item, name string, kind refKind, alias string) {
+	if t.refs == nil {
+		return
+	}
+	var start = int(first.pos) - len(first.val)
+	*t.refs = append(*t.refs, nameRef{start, int(last.pos), name, kind, alias})
+}
+
+// Rename returns the given soy file text with the template or namespace named
+// from renamed to.  The declaration and every reference to the name are
+// updated, along with names beginning with from + ".", so that renaming a
+// namespace updates the templates within it.  This covers {namespace},
+// {alias}, {template}, {deltemplate}, {call}, and {delcall} tags.
+//
+// References keep the form in which they were written (relative to the
+// namespace, relative to an alias, or fully-qualified) where possible.  The
+// rest of the text is left untouched, preserving its formatting.
+//
+// A template may not be renamed into a different namespace, since that would
+// require moving its declaration to another file.
+func Rename(opts Options, name, text string, globals data.Map, from, to string) (result string, err error) {
+	for _, segment := range strings.Split(to, ".") {
+		if !isIdentifier(segment) {
+			return "", fmt.Errorf("rename: invalid name %q", to)
+		}
+	}
+	t, err := newTree(opts, name, text, globals)
+	if err != nil {
+		return "", err
+	}
+	var refs []nameRef
+	t.refs = &refs
+	defer t.recover(&err)
+	t.itemList(itemEOF)
+
+	var rename = func(n string) string {
+		if n == from || strings.HasPrefix(n, from+".") {
+			return to + n[len(from):]
+		}
+		return n
+	}
+	var namespace string
+	for _, ref := range refs {
+		if ref.kind == refNamespace {
+			namespace = rename(ref.name)
+		}
+	}
+
+	var buf bytes.Buffer
+	var prev int
+	for _, ref := range refs {
+		var renamed = rename(ref.name)
+		if renamed == ref.name {
+			continue
+		}
+		var written = renamed
+		switch ref.kind {
+		case refRelative, refTemplate:
+			var rest = strings.TrimPrefix(renamed, namespace+".")
+			switch {
+			case rest != renamed && !strings.Contains(rest, "."):
+				written = "." + rest
+			case ref.kind == refTemplate:
+				return "", fmt.Errorf("%s: rename: template %s may not be moved to another namespace (as %s)",
+					name, ref.name, renamed)
+			}
+		case refAliased:
+			var alias = rename(ref.alias)
+			if strings.HasPrefix(renamed, alias+".") {
+				written = alias[strings.LastIndex(alias, ".")+1:] + renamed[len(alias):]
+			}
+		}
+		buf.WriteString(text[prev:ref.start])
+		buf.WriteString(written)
+		prev = ref.end
+	}
+	buf.WriteString(text[prev:])
+	return buf.String(), nil
+}
@@ -0,0 +1,103 @@
+package parse
+
+import "testing"
+
+func TestRename(t *testing.T) {
+	const input = `{namespace a.b autoescape="true"}
+{alias x.y}
+
+/** Foo. */
+{template .foo}
+  {call .bar/}
+  {call   a.b.bar data="all" /}
+  {call name=".bar"}{param p: 1 /}{/call}
+  {call y.baz /}
+{/template}
+
+{template .bar}{/template}
+
+{deltemplate a.b.del}{delcall a.b.del/}{/deltemplate}
+`
+	var tests = []struct {
+		from, to string
+		expected string
+	}{
+		{"a.b.bar", "a.b.qux", `{namespace a.b autoescape="true"}
+{alias x.y}
+
+/** Foo. */
+{template .foo}
+  {call .qux/}
+  {call   a.b.qux data="all" /}
+  {call name=".qux"}{param p: 1 /}{/call}
+  {call y.baz /}
+{/template}
+
+{template .qux}{/template}
+
+{deltemplate a.b.del}{delcall a.b.del/}{/deltemplate}
+`},
+		{"a.b", "c", `{namespace c autoescape="true"}
+{alias x.y}
+
+/** Foo. */
+{template .foo}
+  {call .bar/}
+  {call   c.bar data="all" /}
+  {call name=".bar"}{param p: 1 /}{/call}
+  {call y.baz /}
+{/template}
+
+{template .bar}{/template}
+
+{deltemplate c.del}{delcall c.del/}{/deltemplate}
+`},
+		{"x.y", "z.w", `{namespace a.b autoescape="true"}
+{alias z.w}
+
+/** Foo. */
+{template .foo}
+  {call .bar/}
+  {call   a.b.bar data="all" /}
+  {call name=".bar"}{param p: 1 /}{/call}
+  {call w.baz /}
+{/template}
+
+{template .bar}{/template}
+
+{deltemplate a.b.del}{delcall a.b.del/}{/deltemplate}
+`},
+		{"x.y.baz", "x.q.baz", `{namespace a.b autoescape="true"}
+{alias x.y}
+
+/** Foo. */
+{template .foo}
+  {call .bar/}
+  {call   a.b.bar data="all" /}
+  {call name=".bar"}{param p: 1 /}{/call}
+  {call x.q.baz /}
+{/template}
+
+{template .bar}{/template}
+
+{deltemplate a.b.del}{delcall a.b.del/}{/deltemplate}
+`},
+		{"a.b.nope", "a.b.other", input},
+	}
+	for _, test := range tests {
+		var actual, err = Rename(Options{}, "", input, nil, test.from, test.to)
+		if err != nil {
+			t.Errorf("%s => %s: %v", test.from, test.to, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("%s => %s: expected:\n%s\ngot:\n%s", test.from, test.to, test.expected, actual)
+		}
+	}
+
+	for _, to := range []string{"c.d.bar", "a.b.", "a.b.1x"} {
+		if _, err := Rename(Options{}, "", input, nil, "a.b.bar", to); err == nil {
+			t.Errorf("a.b.bar => %s: expected an error", to)
+		}
+	}
+}
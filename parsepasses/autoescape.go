@@ -0,0 +1,49 @@
+package parsepasses
+
+import (
+	"sort"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/soyhtml"
+	"github.com/harrisonzhao/soy/template"
+)
+
+// CheckContexts checks that the contexts of the prints within each template
+// escaped by contextual autoescaping may be inferred, i.e. that the branches
+// of each conditional end in the same context, and each loop ends in the
+// context in which it began (see soyhtml.CheckContexts).  Otherwise, the
+// value printed after them could be escaped for the wrong context.  Errors
+// are returned as a *diag.Diagnostic with the code "context".
+func CheckContexts(reg template.Registry) error {
+	for _, t := range reg.Templates {
+		var autoescape = t.Node.Autoescape
+		if autoescape == ast.AutoescapeUnspecified {
+			autoescape = t.Namespace.Autoescape
+		}
+		if autoescape != ast.AutoescapeContextual {
+			continue
+		}
+		if node, err := soyhtml.CheckContexts(t.Node); err != nil {
+			return templateError(reg, t, node, "context", err)
+		}
+	}
+	return nil
+}
+
+// DefaultAutoescapeFiles returns the names of the files whose namespaces do
+// not specify an autoescape mode, and so rely on the default (see
+// soy.Bundle.DefaultAutoescape), sorted.  It is intended to track the files
+// remaining in a migration from one autoescape mode to another.
+func DefaultAutoescapeFiles(reg template.Registry) []string {
+	var files []string
+	for _, soyfile := range reg.SoyFiles {
+		for _, node := range soyfile.Body {
+			if ns, ok := node.(*ast.NamespaceNode); ok &&
+				(ns.Autoescape == ast.AutoescapeUnspecified || ns.Defaulted) {
+				files = append(files, soyfile.Name)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files
+}
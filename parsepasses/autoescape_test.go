@@ -0,0 +1,51 @@
+package parsepasses
+
+import (
+	"testing"
+
+	"github.com/harrisonzhao/soy/diag"
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/template"
+)
+
+func TestCheckContexts(t *testing.T) {
+	var tests = []struct {
+		autoescape, body string
+		success          bool
+	}{
+		{"contextual", `<a href="{$x}">{if $x}<b>{else}<i>{/if}{$x}`, true},
+		{"contextual", `<a href="{if $x}/a{else}/b{/if}?q={$x}">`, true},
+		{"contextual", `{foreach $y in $x}<li>{$y}</li>{ifempty}<li>none</li>{/foreach}`, true},
+		{"contextual", `{switch $x}{case 1}<a href="/a?q={default}<a href="/b?q={/switch}{$x}">`, true},
+		{"contextual", `<a {if $x}title{else}href{/if}="{$x}">`, false},
+		{"contextual", `<a href="{if $x}/a?q={/if}{$x}">`, false},
+		{"contextual", `{switch $x}{case 1}<script>{/switch}{$x}`, false},
+		{"contextual", `{foreach $y in $x}<a href="{/foreach}{$x}">`, false},
+		{"contextual", `{foreach $y in $x}{ifempty}<script>{/foreach}{$x}`, false},
+		{"strict", `<a {if $x}title{else}href{/if}="{$x}">`, true},
+	}
+
+	for _, test := range tests {
+		var reg template.Registry
+		var tree, err = parse.SoyFile("", `{namespace test autoescape="`+test.autoescape+`"}
+/** @param x */
+{template .test}`+test.body+"{/template}", nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if err = reg.Add(tree); err != nil {
+			t.Error(err)
+			continue
+		}
+
+		err = CheckContexts(reg)
+		if test.success && err != nil {
+			t.Errorf("%s: %v", test.body, err)
+		} else if !test.success && err == nil {
+			t.Errorf("%s: expected to fail validation, but no error was raised.", test.body)
+		} else if err != nil && err.(*diag.Diagnostic).Code != "context" {
+			t.Errorf("%s: expected a context diagnostic, got %v", test.body, err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package parsepasses
+
+import (
+	"fmt"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/template"
+)
+
+// ComplexityLimits configures CheckComplexity.  Limits that are zero are not
+// checked.
+type ComplexityLimits struct {
+	ExprDepth    int // maximum depth of an expression's tree, e.g. 2 for "$a + 1"
+	TernaryChain int // maximum number of nested ?: operators in an expression
+	BodyDepth    int // maximum nesting of blocks ({if}, {for}, {let}, ...) in a template
+}
+
+// ComplexityIssue is a part of a template that exceeds a complexity limit.
+type ComplexityIssue struct {
+	Template string // fully-qualified name of the template
+	Line     int    // line number of the command containing the issue
+	Message  string
+}
+
+func (i ComplexityIssue) String() string {
+	return fmt.Sprintf("%s:%d: %s", i.Template, i.Line, i.Message)
+}
+
+// CheckComplexity returns the expressions and blocks within the registry's
+// templates that exceed the given limits, so that overly complex templates
+// may be simplified.
+func CheckComplexity(reg template.Registry, limits ComplexityLimits) []ComplexityIssue {
+	var result []ComplexityIssue
+	for _, t := range reg.Templates {
+		var c = complexityChecker{limits, t.Node.Name, reg, t.Node.ID(), nil}
+		c.check(t.Node.Body, t.Node, 0)
+		result = append(result, c.issues...)
+	}
+	return result
+}
+
+type complexityChecker struct {
+	limits   ComplexityLimits
+	template string
+	registry template.Registry
+	id       string
+	issues   []ComplexityIssue
+}
+
+// check checks the given command node, nested within the given number of
+// blocks, and its descendents.
+func (c *complexityChecker) check(node, command ast.Node, depth int) {
+	if !isCommand(node) {
+		c.checkExpr(node, command)
+		return
+	}
+	command = node
+	if isBlock(node) {
+		depth++
+		if c.limits.BodyDepth > 0 && depth == c.limits.BodyDepth+1 {
+			c.report(node, "blocks are nested %d deep (limit %d)", depth, c.limits.BodyDepth)
+		}
+	}
+	if parent, ok := node.(ast.ParentNode); ok {
+		for _, child := range parent.Children() {
+			if child != nil {
+				c.check(child, command, depth)
+			}
+		}
+	}
+}
+
+// checkExpr checks the given expression, found within the given command.
+func (c *complexityChecker) checkExpr(expr, command ast.Node) {
+	var depth, ternaries = exprComplexity(expr)
+	if c.limits.ExprDepth > 0 && depth > c.limits.ExprDepth {
+		c.report(command, "expression is nested %d deep (limit %d)", depth, c.limits.ExprDepth)
+	}
+	if c.limits.TernaryChain > 0 && ternaries > c.limits.TernaryChain {
+		c.report(command, "expression chains %d ternaries (limit %d)", ternaries, c.limits.TernaryChain)
+	}
+}
+
+func (c *complexityChecker) report(node ast.Node, format string, args ...interface{}) {
+	c.issues = append(c.issues, ComplexityIssue{
+		Template: c.template,
+		Line:     c.registry.LineNumber(c.id, node),
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// exprComplexity returns the depth of the given expression's tree and the
+// greatest number of ternaries along any path within it.
+func exprComplexity(expr ast.Node) (depth, ternaries int) {
+	if parent, ok := expr.(ast.ParentNode); ok {
+		for _, child := range parent.Children() {
+			if child == nil {
+				continue
+			}
+			var childDepth, childTernaries = exprComplexity(child)
+			if childDepth > depth {
+				depth = childDepth
+			}
+			if childTernaries > ternaries {
+				ternaries = childTernaries
+			}
+		}
+	}
+	if _, ok := expr.(*ast.TernNode); ok {
+		ternaries++
+	}
+	return depth + 1, ternaries
+}
+
+// isCommand returns true if the given node is part of a template body, as
+// opposed to an expression.
+func isCommand(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.ListNode, *ast.RawTextNode, *ast.TemplateNode, *ast.PrintNode,
+		*ast.PrintDirectiveNode, *ast.LiteralNode, *ast.CssNode, *ast.LogNode,
+		*ast.DebuggerNode, *ast.LetValueNode, *ast.LetContentNode, *ast.MsgNode,
+		*ast.MsgPlaceholderNode, *ast.CallNode, *ast.CallParamValueNode,
+		*ast.CallParamContentNode, *ast.IfNode, *ast.IfCondNode, *ast.SwitchNode,
+		*ast.SwitchCaseNode, *ast.ForNode, *ast.PluralNode, *ast.PluralCaseNode,
+		*ast.SelectNode, *ast.SelectCaseNode:
+		return true
+	}
+	return false
+}
+
+// isBlock returns true if the given command contains a template body.
+func isBlock(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.IfNode, *ast.SwitchNode, *ast.ForNode, *ast.LetContentNode,
+		*ast.CallParamContentNode, *ast.MsgNode, *ast.LogNode, *ast.PluralNode,
+		*ast.SelectNode:
+		return true
+	}
+	return false
+}
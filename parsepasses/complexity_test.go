@@ -0,0 +1,50 @@
+package parsepasses
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/template"
+)
+
+func TestCheckComplexity(t *testing.T) {
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param x */
+{template .foo}
+  {$x + 1}
+  {print ($x + 1) * ($x - 1) / 2}
+  {if $x}
+    {for $i in range(3)}
+      {if $i}{$i}{/if}
+    {/for}
+  {/if}
+  {call .foo}{param x: $x ? 1 : $x == 2 ? 2 : $x == 3 ? 3 : 4 /}{/call}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reg template.Registry
+	if err = reg.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+
+	var actual []string
+	for _, issue := range CheckComplexity(reg, ComplexityLimits{ExprDepth: 3, TernaryChain: 2, BodyDepth: 2}) {
+		actual = append(actual, issue.String())
+	}
+	var expected = []string{
+		"test.foo:6: expression is nested 4 deep (limit 3)",
+		"test.foo:9: blocks are nested 3 deep (limit 2)",
+		"test.foo:12: expression is nested 5 deep (limit 3)",
+		"test.foo:12: expression chains 3 ternaries (limit 2)",
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected:\n%q\ngot:\n%q", expected, actual)
+	}
+
+	if issues := CheckComplexity(reg, ComplexityLimits{}); len(issues) > 0 {
+		t.Errorf("expected no issues without limits, got %v", issues)
+	}
+}
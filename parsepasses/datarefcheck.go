@@ -3,8 +3,10 @@ package parsepasses
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/diag"
 	"github.com/harrisonzhao/soy/template"
 )
 
@@ -13,19 +15,24 @@ import (
 //  2. any data declared as a @param is used by the template (or passed via {call})
 //  3. all {call} params are declared as @params in the called template soydoc.
 //  4. a {call}'ed template is passed all required @params, or a data="$var"
-//  5. {call}'d templates actually exist in the registry.
+//  5. {call}'d templates actually exist in the registry.  ({delcall}s are
+//     checked against the default delegate, if present.)
 //  6. any variable created by {let} is used somewhere
 //  7. {let} variable names are valid.  ('ij' is not allowed.)
+//  8. index(), isFirst() and isLast() are called on a variable bound by an
+//     enclosing loop.
+//
+// Errors are returned as a *diag.Diagnostic with the code "dataref".
 func CheckDataRefs(reg template.Registry) (err error) {
-	var currentTemplate string
+	var current template.Template
 	defer func() {
 		if err2 := recover(); err2 != nil {
-			err = fmt.Errorf("template %v: %v", currentTemplate, err2)
+			err = templateError(reg, current, current.Node, "dataref", err2)
 		}
 	}()
 
 	for _, t := range reg.Templates {
-		currentTemplate = t.Node.Name
+		current = t
 		tc := newTemplateChecker(reg, t.Doc.Params)
 		tc.checkTemplate(t.Node.Body)
 
@@ -44,6 +51,7 @@ type templateChecker struct {
 	params   []string
 	letVars  []string
 	forVars  []string
+	loopVars []string
 	usedKeys []string
 }
 
@@ -52,7 +60,7 @@ func newTemplateChecker(reg template.Registry, params []*ast.SoyDocParamNode) *t
 	for _, param := range params {
 		paramNames = append(paramNames, param.Name)
 	}
-	return &templateChecker{reg, paramNames, nil, nil, nil}
+	return &templateChecker{reg, paramNames, nil, nil, nil, nil}
 }
 
 func (tc *templateChecker) checkTemplate(node ast.Node) {
@@ -66,9 +74,12 @@ func (tc *templateChecker) checkTemplate(node ast.Node) {
 	case *ast.CallNode:
 		tc.checkCall(node)
 	case *ast.ForNode:
-		tc.forVars = append(tc.forVars, node.Var)
+		tc.checkForVars(node)
+		return
+	case *ast.FunctionNode:
+		tc.checkLoopFunc(node)
 	case *ast.DataRefNode:
-		tc.visitKey(node.Key)
+		tc.visitKey(node)
 	}
 	if parent, ok := node.(ast.ParentNode); ok {
 		tc.recurse(parent)
@@ -82,10 +93,53 @@ func (tc *templateChecker) checkLet(varName string) {
 	}
 }
 
+// checkForVars checks the loop's collection outside of the loop's scope and its
+// body and ifempty blocks with the loop variables bound.
+func (tc *templateChecker) checkForVars(node *ast.ForNode) {
+	tc.checkTemplate(node.List)
+	if node.IfEmpty != nil {
+		tc.checkTemplate(node.IfEmpty)
+	}
+	var initialForVars = len(tc.forVars)
+	var initialLoopVars = len(tc.loopVars)
+	tc.forVars = append(tc.forVars, node.Var)
+	if node.IndexVar != "" {
+		tc.forVars = append(tc.forVars, node.IndexVar)
+	}
+	tc.loopVars = append(tc.loopVars, node.Var)
+	tc.checkTemplate(node.Body)
+	tc.forVars = tc.forVars[:initialForVars]
+	tc.loopVars = tc.loopVars[:initialLoopVars]
+}
+
+// checkLoopFunc ensures that loop functions are passed a loop variable.
+func (tc *templateChecker) checkLoopFunc(node *ast.FunctionNode) {
+	switch node.Name {
+	case "index", "isFirst", "isLast":
+	default:
+		return
+	}
+	if len(node.Args) == 1 {
+		if ref, ok := node.Args[0].(*ast.DataRefNode); ok && len(ref.Access) == 0 &&
+			contains(tc.loopVars, ref.Key) {
+			return
+		}
+	}
+	panic(fmt.Errorf("%v: %s() must be called on the variable of an enclosing loop",
+		node, node.Name))
+}
+
 func (tc *templateChecker) checkCall(node *ast.CallNode) {
 	var callee, ok = tc.registry.Template(node.Name)
-	if !ok {
-		panic(fmt.Errorf("{call}: template %q not found", node.Name))
+	if node.Delegate {
+		// Delegates may be provided by other bundles, so a missing one is not an
+		// error.  Calls are checked against the default delegate.
+		if callee, ok = tc.registry.DelTemplate(node.Name, ""); !ok {
+			return
+		}
+	} else if !ok {
+		panic(misspelling{fmt.Errorf("{call}: template %q not found", node.Name),
+			node, node.Name, tc.suggestTemplates(node.Name)})
 	}
 
 	// collect callee's list of required/allowed params
@@ -110,12 +164,15 @@ func (tc *templateChecker) checkCall(node *ast.CallNode) {
 		}
 	}
 	// add the {param}'s
+	var callParamNodes = make(map[string]ast.Node)
 	for _, callParam := range node.Params {
 		switch callParam := callParam.(type) {
 		case *ast.CallParamValueNode:
 			callerParamNames = append(callerParamNames, callParam.Key)
+			callParamNodes[callParam.Key] = callParam
 		case *ast.CallParamContentNode:
 			callerParamNames = append(callerParamNames, callParam.Key)
+			callParamNodes[callParam.Key] = callParam
 		default:
 			panic("unexpected call param type")
 		}
@@ -125,7 +182,12 @@ func (tc *templateChecker) checkCall(node *ast.CallNode) {
 	// check: all {call} params are declared as @params in the called template soydoc.
 	for _, callParamName := range callerParamNames {
 		if !contains(allCalleeParamNames, callParamName) {
-			panic(fmt.Errorf("Param %q is not declared by the callee.", callParamName))
+			var err = fmt.Errorf("Param %q is not declared by the callee.", callParamName)
+			if paramNode, ok := callParamNodes[callParamName]; ok {
+				panic(misspelling{err, paramNode, callParamName,
+					diag.Suggest(callParamName, allCalleeParamNames)})
+			}
+			panic(err)
 		}
 	}
 
@@ -178,17 +240,59 @@ func (tc *templateChecker) recurse(parent ast.ParentNode) {
 	tc.letVars = tc.letVars[:initialLetVars]
 }
 
-func (tc *templateChecker) visitKey(key string) {
+func (tc *templateChecker) visitKey(node *ast.DataRefNode) {
 	// record that this key was used in the template.
+	var key = node.Key
 	tc.usedKeys = append(tc.usedKeys, key)
 
 	// check that the key was provided by a @param or {let}
 	if !tc.checkKey(key) {
-		panic(fmt.Errorf("data ref %q not found. params: %v, let variables: %v",
-			key, tc.params, tc.letVars))
+		var candidates []string
+		for _, name := range diag.Suggest(key, tc.inScope()) {
+			candidates = append(candidates, "$"+name)
+		}
+		panic(misspelling{fmt.Errorf("data ref %q not found. params: %v, let variables: %v",
+			key, tc.params, tc.letVars), node, "$" + key, candidates})
 	}
 }
 
+// suggestTemplates returns the names of the templates that the given
+// template name may be a misspelling of.  Names within the same namespace are
+// compared by their last segment, and suggested first.
+func (tc *templateChecker) suggestTemplates(name string) []string {
+	var namespace, local = "", name
+	if dot := strings.LastIndex(name, "."); dot != -1 {
+		namespace, local = name[:dot+1], name[dot+1:]
+	}
+	var locals, others []string
+	for _, t := range tc.registry.Templates {
+		switch {
+		case t.Node.Delegate:
+		case strings.HasPrefix(t.Node.Name, namespace) && !strings.Contains(t.Node.Name[len(namespace):], "."):
+			locals = append(locals, t.Node.Name[len(namespace):])
+		default:
+			others = append(others, t.Node.Name)
+		}
+	}
+	var result []string
+	for _, candidate := range diag.Suggest(local, locals) {
+		result = append(result, namespace+candidate)
+	}
+	result = append(result, diag.Suggest(name, others)...)
+	if len(result) > 3 {
+		result = result[:3]
+	}
+	return result
+}
+
+// inScope returns the names of the params and variables in scope.
+func (tc *templateChecker) inScope() []string {
+	var names []string
+	names = append(names, tc.params...)
+	names = append(names, tc.letVars...)
+	return append(names, tc.forVars...)
+}
+
 // checkKey returns true if the given key exists as a param or {let} variable.
 func (tc *templateChecker) checkKey(key string) bool {
 	if key == "ij" {
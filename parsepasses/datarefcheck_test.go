@@ -369,6 +369,59 @@ func TestIJVarsAllowed(t *testing.T) {
 	})
 }
 
+// Test: loop functions are called on the variable of an enclosing loop
+func TestLoopFuncsOnLoopVars(t *testing.T) {
+	runSimpleCheckerTests(t, []simpleCheckerTest{
+		{`
+/** @param list */
+{template .loopVar}
+{foreach $x in $list}{index($x)}{if isFirst($x)}first{/if}{if isLast($x)}last{/if}{/foreach}
+{/template}`, true},
+
+		{`
+/** @param list */
+{template .outerLoopVar}
+{foreach $x in $list}{foreach $y in $x}{index($x)}.{index($y)}{/foreach}{/foreach}
+{/template}`, true},
+
+		{`
+/** @param list */
+{template .notLoopVar}
+{foreach $x in $list}{index($list)}{/foreach}
+{/template}`, false},
+
+		{`
+/** @param list */
+{template .outsideLoop}
+{foreach $x in $list}{$x}{/foreach}{isLast($x)}
+{/template}`, false},
+
+		{`
+/** @param list */
+{template .inIfEmpty}
+{foreach $x in $list}{$x}{ifempty}{index($x)}{/foreach}
+{/template}`, false},
+
+		{`
+/** @param list */
+{template .indexVar}
+{for $x, $i in $list}{$i}: {$x}{/for}
+{/template}`, true},
+
+		{`
+/** @param list */
+{template .loopFuncOnIndexVar}
+{for $x, $i in $list}{index($i)}{/for}
+{/template}`, false},
+
+		{`
+/** @param list */
+{template .indexVarOutsideLoop}
+{for $x, $i in $list}{$x}{/for}{$i}
+{/template}`, false},
+	})
+}
+
 func runSimpleCheckerTests(t *testing.T, tests []simpleCheckerTest) {
 	var result []checkerTest
 	for _, simpleTest := range tests {
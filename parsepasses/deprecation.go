@@ -0,0 +1,61 @@
+package parsepasses
+
+import (
+	"fmt"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/template"
+)
+
+// DeprecatedCall is a call from a template that is not deprecated to one that
+// is.
+type DeprecatedCall struct {
+	Caller string // fully-qualified name of the calling template
+	Line   int    // line number of the call
+	Callee string // fully-qualified name of the deprecated template
+	Note   string // the callee's deprecation note, if any
+}
+
+func (c DeprecatedCall) String() string {
+	var msg = fmt.Sprintf("%s:%d: call to deprecated template %s", c.Caller, c.Line, c.Callee)
+	if c.Note != "" {
+		msg += ": " + c.Note
+	}
+	return msg
+}
+
+// CheckDeprecatedCalls returns the calls from templates that are not marked
+// @deprecated to templates that are, to help migrate callers off of them.
+// Calls between deprecated templates are not reported.  {delcall}s are checked
+// against the default delegate.
+func CheckDeprecatedCalls(reg template.Registry) []DeprecatedCall {
+	var result []DeprecatedCall
+	for _, t := range reg.Templates {
+		if deprecated, _ := t.Deprecated(); deprecated {
+			continue
+		}
+		ast.Walk(t.Node, func(node ast.Node) bool {
+			var call, ok = node.(*ast.CallNode)
+			if !ok {
+				return true
+			}
+			callee, ok := reg.Template(call.Name)
+			if call.Delegate {
+				callee, ok = reg.DelTemplate(call.Name, "")
+			}
+			if !ok {
+				return true
+			}
+			if deprecated, note := callee.Deprecated(); deprecated {
+				result = append(result, DeprecatedCall{
+					Caller: t.Node.Name,
+					Line:   reg.LineNumber(t.Node.ID(), call),
+					Callee: call.Name,
+					Note:   note,
+				})
+			}
+			return true
+		})
+	}
+	return result
+}
@@ -0,0 +1,64 @@
+package parsepasses
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/harrisonzhao/soy/diag"
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/template"
+)
+
+func TestCheckDeprecatedCalls(t *testing.T) {
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/**
+ * Renders the old header.
+ * @deprecated Use .header
+ *     instead.
+ */
+{template .oldHeader}
+{/template}
+
+/** @deprecated */
+{template .oldFooter}
+  {call .oldHeader/}
+{/template}
+
+/** @param x */
+{template .page}
+  {call .oldHeader/}
+  {if $x}{call .oldFooter/}{/if}
+  {call .page data="all"/}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reg template.Registry
+	if err = reg.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+
+	var header, _ = reg.Template("test.oldHeader")
+	if deprecated, note := header.Deprecated(); !deprecated || note != "Use .header instead." {
+		t.Errorf("expected deprecated with note, got %v %q", deprecated, note)
+	}
+	if header.Doc.Desc != "Renders the old header." {
+		t.Errorf("unexpected description: %q", header.Doc.Desc)
+	}
+
+	var expected = []DeprecatedCall{
+		{"test.page", 18, "test.oldHeader", "Use .header instead."},
+		{"test.page", 19, "test.oldFooter", ""},
+	}
+	var actual = CheckDeprecatedCalls(reg)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, actual)
+	}
+
+	var d = actual[0].Diagnostic(reg)
+	if d.Severity != diag.Warning || d.Code != "deprecated" || d.Range.Start.Line != 18 ||
+		d.Message != "call to deprecated template test.oldHeader: Use .header instead." {
+		t.Errorf("unexpected diagnostic: %+v", d)
+	}
+}
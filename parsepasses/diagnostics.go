@@ -0,0 +1,136 @@
+package parsepasses
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/diag"
+	"github.com/harrisonzhao/soy/template"
+)
+
+// misspelling is raised for a name that was not found, along with the names
+// that it may be a misspelling of, to be suggested as fixes.
+type misspelling struct {
+	error
+	node       ast.Node // the node naming it
+	name       string   // the name, as it appears within the node's tag
+	candidates []string // the names to suggest, best first
+}
+
+// templateError returns an error diagnostic of the given code for the given
+// error, raised while checking the given node of template t.
+func templateError(reg template.Registry, t template.Template, node ast.Node, code string, err interface{}) *diag.Diagnostic {
+	var m, isMisspelling = err.(misspelling)
+	if isMisspelling {
+		node, err = m.node, m.error
+	}
+	var d = &diag.Diagnostic{
+		Severity: diag.Error,
+		Code:     code,
+		Message:  fmt.Sprint(err),
+		File:     reg.FileName(t.Node),
+		Template: t.Node.Name,
+		Range:    diag.Range{Start: diag.Position{Line: reg.LineNumber(t.Node.ID(), node)}},
+	}
+	if isMisspelling {
+		suggestFixes(d, fileText(reg, t.Node), m)
+	}
+	return d
+}
+
+// suggestFixes locates the misspelled name within the tag of its node in the
+// given source (nodes are positioned at the end of their first token, which
+// may be the name), and sets the range of the diagnostic to it, along with fixes
+// replacing it by each candidate.  A fully-qualified template name may appear
+// in its relative form (e.g. ".foo"), in which case candidates in the same
+// namespace are suggested in that form too.
+func suggestFixes(d *diag.Diagnostic, src string, m misspelling) {
+	var pos = int(m.node.Position())
+	if src == "" || pos >= len(src) {
+		return
+	}
+	var start, end = pos - len(m.name), len(src)
+	if start < 0 {
+		start = 0
+	}
+	if i := strings.Index(src[pos:], "}"); i != -1 {
+		end = pos + i
+	}
+	var tag = src[start:end]
+
+	var name, prefix = m.name, ""
+	var i = strings.Index(tag, name)
+	if dot := strings.LastIndex(name, "."); i == -1 && dot > 0 {
+		name, prefix = name[dot:], name[:dot]
+		i = strings.Index(tag, name)
+	}
+	if i == -1 {
+		return
+	}
+	start += i
+	d.Range = diag.Range{offsetPosition(src, start), offsetPosition(src, start+len(name))}
+	for _, candidate := range m.candidates {
+		if prefix != "" && strings.HasPrefix(candidate, prefix+".") {
+			candidate = candidate[len(prefix):]
+		}
+		d.Fixes = append(d.Fixes, diag.Fix{
+			Description: fmt.Sprintf("did you mean %s?", candidate),
+			Range:       d.Range,
+			Replacement: candidate,
+		})
+	}
+}
+
+// fileText returns the source of the file declaring the given template, or ""
+// if it is not available (e.g. it was stripped).
+func fileText(reg template.Registry, tn *ast.TemplateNode) string {
+	for _, soyfile := range reg.SoyFiles {
+		for _, node := range soyfile.Body {
+			if node == tn {
+				return soyfile.Text
+			}
+		}
+	}
+	return ""
+}
+
+// offsetPosition returns the line and column of the given byte offset within
+// the source.
+func offsetPosition(src string, offset int) diag.Position {
+	var lineStart = strings.LastIndex(src[:offset], "\n") + 1
+	return diag.Position{1 + strings.Count(src[:lineStart], "\n"), 1 + offset - lineStart}
+}
+
+// Diagnostic returns the deprecated call as a warning with the code
+// "deprecated", located within the registry's files.
+func (c DeprecatedCall) Diagnostic(reg template.Registry) *diag.Diagnostic {
+	var msg = "call to deprecated template " + c.Callee
+	if c.Note != "" {
+		msg += ": " + c.Note
+	}
+	return warning(reg, c.Caller, c.Line, "deprecated", msg)
+}
+
+// Diagnostic returns the complexity issue as a warning with the code
+// "complexity", located within the registry's files.
+func (i ComplexityIssue) Diagnostic(reg template.Registry) *diag.Diagnostic {
+	return warning(reg, i.Template, i.Line, "complexity", i.Message)
+}
+
+func warning(reg template.Registry, templateName string, line int, code, msg string) *diag.Diagnostic {
+	var d = &diag.Diagnostic{
+		Severity: diag.Warning,
+		Code:     code,
+		Message:  msg,
+		Template: templateName,
+		Range:    diag.Range{Start: diag.Position{Line: line}},
+	}
+	for _, t := range reg.Templates {
+		if t.Node.Name == templateName {
+			d.File = reg.FileName(t.Node)
+			break
+		}
+	}
+	return d
+}
@@ -0,0 +1,67 @@
+package parsepasses
+
+import (
+	"fmt"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/soyhtml"
+	"github.com/harrisonzhao/soy/template"
+)
+
+// CheckPrintDirectives validates that the print directive chains within each
+// template are ordered sensibly.  Specifically, a directive may not escape a
+// value for a context that an earlier directive in the chain has already
+// produced sanitized content for, e.g. {$x|noAutoescape|escapeHtml} or
+// {$x|changeNewlineToBr|escapeHtml}, since that double-escapes the value.
+//
+// Directives are looked up in soyhtml.PrintDirectives.  Unknown directives
+// are ignored here; they are reported when rendered.  Errors are returned as a
+// *diag.Diagnostic with the code "directive".
+func CheckPrintDirectives(reg template.Registry) error {
+	for _, t := range reg.Templates {
+		if node, err := checkDirectives(t.Node); err != nil {
+			return templateError(reg, t, node, "directive", err)
+		}
+	}
+	return nil
+}
+
+// checkDirectives checks the print commands within the given node, returning
+// the first that fails, along with its error.
+func checkDirectives(node ast.Node) (ast.Node, error) {
+	if node, ok := node.(*ast.PrintNode); ok {
+		if err := checkDirectiveChain(node); err != nil {
+			return node, err
+		}
+	}
+	if parent, ok := node.(ast.ParentNode); ok {
+		for _, child := range parent.Children() {
+			if child == nil {
+				continue
+			}
+			if node, err := checkDirectives(child); err != nil {
+				return node, err
+			}
+		}
+	}
+	return nil, nil
+}
+
+// checkDirectiveChain checks that no directive in the chain produces content
+// of a kind already produced by an earlier directive.
+func checkDirectiveChain(node *ast.PrintNode) error {
+	var producedBy = make(map[data.ContentKind]string)
+	for _, directiveNode := range node.Directives {
+		var directive, ok = soyhtml.PrintDirectives[directiveNode.Name]
+		if !ok || directive.Produces == "" {
+			continue
+		}
+		if earlier, ok := producedBy[directive.Produces]; ok {
+			return fmt.Errorf("%v: |%v re-escapes the %v content produced by |%v",
+				node, directiveNode.Name, directive.Produces, earlier)
+		}
+		producedBy[directive.Produces] = directiveNode.Name
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package parsepasses
+
+import (
+	"testing"
+
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/template"
+)
+
+func TestPrintDirectiveOrdering(t *testing.T) {
+	var tests = []struct {
+		body    string
+		success bool
+	}{
+		{`{'<a>'}`, true},
+		{`{'<a>'|noAutoescape}`, true},
+		{`{'<a>'|escapeHtml}`, true},
+		{`{'a b'|escapeUri|escapeHtml}`, true},
+		{`{'<a>'|noAutoescape|truncate:5}`, true},
+		{`{'<a>'|json|escapeHtml}`, true},
+		{`{'<a>'|noAutoescape|escapeHtml}`, false},
+		{`{'<a>'|id|escapeHtml}`, false},
+		{`{'<a>'|escapeHtml|escapeHtml}`, false},
+		{`{'<a>'|changeNewlineToBr|truncate:5|escapeHtml}`, false},
+		{`{if true}{'a'|escapeUri|escapeUri}{/if}`, false},
+	}
+
+	for _, test := range tests {
+		var reg template.Registry
+		var tree, err = parse.SoyFile("", "{namespace test}{template .test}"+test.body+"{/template}", nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if err = reg.Add(tree); err != nil {
+			t.Error(err)
+			continue
+		}
+
+		err = CheckPrintDirectives(reg)
+		if test.success && err != nil {
+			t.Error(err)
+		} else if !test.success && err == nil {
+			t.Errorf("%s: expected to fail validation, but no error was raised.", test.body)
+		}
+	}
+}
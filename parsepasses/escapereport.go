@@ -0,0 +1,93 @@
+package parsepasses
+
+import (
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/soyhtml"
+	"github.com/harrisonzhao/soy/template"
+)
+
+// UnescapedPrint is a print statement whose value may reach the output without
+// passing through a sanitizing directive.
+type UnescapedPrint struct {
+	Line   int    // line number of the print statement
+	Print  string // the print statement, e.g. "{$x|noAutoescape}"
+	Reason string // why the value is not sanitized
+}
+
+// trustingDirectives are the directives that mark their input as safe HTML
+// without sanitizing it.
+var trustingDirectives = map[string]bool{
+	"noAutoescape": true,
+	"id":           true,
+}
+
+// EscapeReport lists the print statements whose values reach the output
+// without being sanitized, grouped by the ID of the template containing them
+// (see ast.TemplateNode.ID), which distinguishes delegates that share a name.
+// It is intended to focus security reviews of template changes on the risky
+// sinks.
+//
+// A print statement is reported if it has a directive that cancels
+// autoescaping without sanitizing the value (e.g. |noAutoescape or |json), or
+// if autoescaping is off for its template and no directive sanitizes the
+// value.  Directives are looked up in soyhtml.PrintDirectives.
+//
+// Templates escaped by context (autoescape="strict" or "contextual") are
+// always listed, since their other prints are handled by the autoescaper: a
+// contextual template with no prints reported escapes every value by context.
+// Directives that cancel autoescaping bypass it there too, so their prints are
+// reported as in other templates.
+func EscapeReport(reg template.Registry) map[string][]UnescapedPrint {
+	var report = make(map[string][]UnescapedPrint)
+	for _, t := range reg.Templates {
+		var autoescape = t.Node.Autoescape
+		if autoescape == ast.AutoescapeUnspecified {
+			autoescape = t.Namespace.Autoescape
+		}
+		var prints []UnescapedPrint
+		if autoescape == ast.AutoescapeStrict || autoescape == ast.AutoescapeContextual {
+			prints = []UnescapedPrint{}
+		}
+		ast.Walk(t.Node, func(node ast.Node) bool {
+			var print, ok = node.(*ast.PrintNode)
+			if !ok {
+				return true
+			}
+			if reason := unescapedReason(print, autoescape); reason != "" {
+				prints = append(prints, UnescapedPrint{
+					Line:   reg.LineNumber(t.Node.ID(), print),
+					Print:  print.String(),
+					Reason: reason,
+				})
+			}
+			return true
+		})
+		if prints != nil {
+			report[t.Node.ID()] = prints
+		}
+	}
+	return report
+}
+
+// unescapedReason returns why the given print statement's value reaches the
+// output unsanitized, or "" if it does not.
+func unescapedReason(node *ast.PrintNode, autoescape ast.AutoescapeType) string {
+	var sanitized bool
+	for _, directiveNode := range node.Directives {
+		var directive, ok = soyhtml.PrintDirectives[directiveNode.Name]
+		switch {
+		case !ok:
+			continue
+		case trustingDirectives[directiveNode.Name]:
+			return "|" + directiveNode.Name + " marks the value as safe without sanitizing it"
+		case directive.CancelAutoescape && directive.Produces == "":
+			return "|" + directiveNode.Name + " cancels autoescaping without sanitizing the value"
+		case directive.Produces != "":
+			sanitized = true
+		}
+	}
+	if autoescape == ast.AutoescapeOff && !sanitized {
+		return "autoescaping is off and no directive sanitizes the value"
+	}
+	return ""
+}
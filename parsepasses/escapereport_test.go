@@ -0,0 +1,74 @@
+package parsepasses
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/template"
+)
+
+func TestEscapeReport(t *testing.T) {
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param x */
+{template .safe}
+  {$x} {$x|escapeUri} {$x|truncate:5}
+{/template}
+
+/** @param x */
+{template .trusting}
+  {$x}
+  {$x|noAutoescape}
+  {if $x}{$x|json}{/if}
+{/template}
+
+/** @param x */
+{template .off autoescape="false"}
+  {$x|escapeHtml}
+  {$x|truncate:5}
+{/template}
+
+/** @param x */
+{template .strict autoescape="strict"}
+  {$x|noAutoescape}
+{/template}
+
+/** @param x */
+{template .contextual autoescape="contextual"}
+  <a href="{$x}">{$x}</a>
+{/template}
+
+/** @param x */
+{deltemplate test.del variant="'a'"}
+  {$x|noAutoescape}
+{/deltemplate}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reg template.Registry
+	if err = reg.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+
+	var expected = map[string][]UnescapedPrint{
+		"test.trusting": {
+			{11, "{$x|noAutoescape}", "|noAutoescape marks the value as safe without sanitizing it"},
+			{12, "{$x|json}", "|json cancels autoescaping without sanitizing the value"},
+		},
+		"test.off": {
+			{18, "{$x|truncate:5}", "autoescaping is off and no directive sanitizes the value"},
+		},
+		"test.strict": {
+			{23, "{$x|noAutoescape}", "|noAutoescape marks the value as safe without sanitizing it"},
+		},
+		"test.contextual": {},
+		"test.del:a:0": {
+			{33, "{$x|noAutoescape}", "|noAutoescape marks the value as safe without sanitizing it"},
+		},
+	}
+	var actual = EscapeReport(reg)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, actual)
+	}
+}
@@ -0,0 +1,205 @@
+package parsepasses
+
+import (
+	"math"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/soyhtml"
+	"github.com/harrisonzhao/soy/template"
+)
+
+// FoldConstants evaluates the expressions within each template whose values
+// do not depend on the render, replacing them by literals of their values, so
+// that they are not evaluated on every render.  For example, {1 + 2} becomes
+// {3} and {floor($PI * 10)}, given the global $PI, becomes {31}.
+//
+// Operators and calls of pure functions (see soyhtml.PureFuncs) are folded if
+// their operands are literals, globals, or themselves folded.  Pure print
+// directives (see soyhtml.PureDirectives) at the start of a print command's
+// chain are applied to a constant value, e.g. {'abcdef'|truncate:4} becomes
+// {'a...'}, as long as they do not cancel autoescaping.  Only values of
+// primitive types are folded; expressions whose value is a list or map (or
+// that fail to evaluate) are left to be evaluated on render.  Messages are
+// left untouched, since their placeholders are derived from their content.
+//
+// The values are computed by soyhtml, so the templates of a folded registry
+// should not be compiled to JavaScript if the functions differ between them.
+func FoldConstants(reg template.Registry) error {
+	for _, t := range reg.Templates {
+		if _, err := ast.Rewrite(t.Node.Body, foldNode(t.Node.Body)); err != nil {
+			return templateError(reg, t, t.Node, "fold", err)
+		}
+	}
+	return nil
+}
+
+// foldNode returns the function with which the given body is rewritten.
+func foldNode(body ast.Node) func(ast.Node) ast.Node {
+	var inMsg = make(map[ast.Node]bool)
+	ast.Walk(body, func(node ast.Node) bool {
+		if msg, ok := node.(*ast.MsgNode); ok {
+			ast.Walk(msg.Body, func(node ast.Node) bool {
+				inMsg[node] = true
+				return true
+			})
+			return false
+		}
+		return true
+	})
+	return func(node ast.Node) ast.Node {
+		if inMsg[node] {
+			return node
+		}
+		if print, ok := node.(*ast.PrintNode); ok {
+			foldDirectives(print)
+			return node
+		}
+		if _, ok := node.(*ast.GlobalNode); ok || isLiteral(node) || !isConstant(node) {
+			return node // globals are left named, for readability of the output
+		}
+		var value, err = soyhtml.EvalExpr(node)
+		if err != nil {
+			return node
+		}
+		if literal := literalOf(node.Position(), value); literal != nil {
+			return literal
+		}
+		return node
+	}
+}
+
+// foldDirectives applies the pure directives at the start of the given print
+// command's chain, if its value is constant.
+func foldDirectives(node *ast.PrintNode) {
+	for len(node.Directives) > 0 && isLiteral(node.Arg) {
+		var directiveNode = node.Directives[0]
+		var directive, ok = soyhtml.PrintDirectives[directiveNode.Name]
+		if !ok || !soyhtml.PureDirectives[directiveNode.Name] || directive.Apply == nil ||
+			directive.CancelAutoescape ||
+			!checkNumArgs(directive.ValidArgLengths, len(directiveNode.Args)) {
+			return
+		}
+		var value, err = soyhtml.EvalExpr(node.Arg)
+		if err != nil {
+			return
+		}
+		var args = make([]data.Value, len(directiveNode.Args))
+		for i, arg := range directiveNode.Args {
+			if !isLiteral(arg) {
+				return
+			}
+			if args[i], err = soyhtml.EvalExpr(arg); err != nil {
+				return
+			}
+		}
+		var literal = literalOf(node.Arg.Position(), applyDirective(directive, value, args))
+		if literal == nil {
+			return
+		}
+		node.Arg = literal
+		node.Directives = node.Directives[1:]
+	}
+}
+
+// applyDirective returns the result of the given directive, or nil if it
+// panics.
+func applyDirective(directive soyhtml.PrintDirective, value data.Value, args []data.Value) (result data.Value) {
+	defer func() {
+		if recover() != nil {
+			result = nil
+		}
+	}()
+	return directive.Apply(value, args)
+}
+
+// isLiteral returns true if the given node is a literal of a primitive value.
+func isLiteral(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.NullNode, *ast.BoolNode, *ast.IntNode, *ast.FloatNode, *ast.StringNode:
+		return true
+	}
+	return false
+}
+
+// isConstant returns true if the value of the given expression does not
+// depend on the render.
+func isConstant(node ast.Node) bool {
+	switch node := node.(type) {
+	case *ast.NullNode, *ast.BoolNode, *ast.IntNode, *ast.FloatNode, *ast.StringNode, *ast.GlobalNode:
+		return true
+	case *ast.ListLiteralNode:
+		for _, item := range node.Items {
+			if !isConstant(item) {
+				return false
+			}
+		}
+		return true
+	case *ast.MapLiteralNode:
+		for _, item := range node.Items {
+			if !isConstant(item) {
+				return false
+			}
+		}
+		return true
+	case *ast.FunctionNode:
+		if !soyhtml.PureFuncs[node.Name] {
+			return false
+		}
+		for _, arg := range node.Args {
+			if !isConstant(arg) {
+				return false
+			}
+		}
+		return true
+	case *ast.NotNode:
+		return isConstant(node.Arg)
+	case *ast.NegateNode:
+		return isConstant(node.Arg)
+	case *ast.TernNode:
+		return isConstant(node.Arg1) && isConstant(node.Arg2) && isConstant(node.Arg3)
+	case *ast.MulNode, *ast.DivNode, *ast.ModNode, *ast.AddNode, *ast.SubNode,
+		*ast.EqNode, *ast.NotEqNode, *ast.GtNode, *ast.GteNode, *ast.LtNode, *ast.LteNode,
+		*ast.OrNode, *ast.AndNode, *ast.ElvisNode:
+		for _, arg := range node.(ast.ParentNode).Children() {
+			if !isConstant(arg) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// literalOf returns a literal of the given value, at the given position, or
+// nil if it is not of a primitive type.
+func literalOf(pos ast.Pos, value data.Value) ast.Node {
+	switch value := value.(type) {
+	case data.Null:
+		return &ast.NullNode{pos}
+	case data.Bool:
+		return &ast.BoolNode{pos, bool(value)}
+	case data.Int:
+		return &ast.IntNode{pos, int64(value)}
+	case data.Float:
+		if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+			return nil
+		}
+		return &ast.FloatNode{pos, float64(value)}
+	case data.String:
+		var node = parse.NewString(string(value))
+		node.Pos = pos
+		return node
+	}
+	return nil
+}
+
+func checkNumArgs(allowedNumArgs []int, numArgs int) bool {
+	for _, length := range allowedNumArgs {
+		if numArgs == length {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package parsepasses
+
+import (
+	"testing"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/template"
+)
+
+func TestFoldConstants(t *testing.T) {
+	var tests = []struct {
+		body, folded string
+	}{
+		{`{1 + 2}`, `{3}`},
+		{`{floor(PI * 10)}`, `{31}`},
+		{`{PI}`, `{PI}`},
+		{`{length(range(2, 5))}`, `{3}`},
+		{`{max(1, 2.5) > 2 ? 'big' : 'small'}`, `{'big'}`},
+		{`{'a' + 1}{not isNonnull(null)}`, `{'a1'}{true}`},
+		{`{$x + (1 + 2)}`, `{$x+3}`},
+		{`{range(3)}{randomInt(3)}{1 / 0}{1 % 0}`, `{range(3)}{randomInt(3)}{1/0}{1%0}`},
+		{`{'abcdef'|truncate:2 + 2|escapeHtml}`, `{'a...'|escapeHtml}`},
+		{`{'<b>'|escapeHtml|truncate:2}`, `{'<b>'|escapeHtml|truncate:2}`},
+		{`{$x|truncate:1 + 1}`, `{$x|truncate:2}`},
+		{`{if 1 < 2}a{/if}{foreach $i in $x}{$i[0 + 1]}{/foreach}`,
+			`{if true}a{/if}{foreach $i in $x}{$i[1]}{/foreach}`},
+	}
+
+	var globals = data.Map{"PI": data.Float(3.14159)}
+	for _, test := range tests {
+		var reg template.Registry
+		var tree, err = parse.SoyFile("", "{namespace test}\n/** @param? x */\n{template .test}"+test.body+"{/template}", globals)
+		if err != nil {
+			t.Errorf("%s: %v", test.body, err)
+			continue
+		}
+		if err = reg.Add(tree); err != nil {
+			t.Error(err)
+			continue
+		}
+		if err = FoldConstants(reg); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.body, err)
+			continue
+		}
+		if actual := reg.Templates[0].Node.Body.String(); actual != test.folded {
+			t.Errorf("%s: expected %s, got %s", test.body, test.folded, actual)
+		}
+	}
+
+	// Messages are left untouched.
+	var reg template.Registry
+	var tree, _ = parse.SoyFile("", `{namespace test}
+{template .msg}{msg desc=""}{1 + 2}{/msg}{/template}`, nil)
+	reg.Add(tree)
+	if err := FoldConstants(reg); err != nil {
+		t.Fatal(err)
+	}
+	var folded bool
+	ast.Walk(reg.Templates[0].Node, func(node ast.Node) bool {
+		if n, ok := node.(*ast.IntNode); ok && n.Value == 3 {
+			folded = true
+		}
+		return true
+	})
+	if folded {
+		t.Errorf("expected the placeholder of a message not to be folded")
+	}
+}
@@ -0,0 +1,103 @@
+package parsepasses
+
+import (
+	"sort"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/template"
+)
+
+// UnusedGlobals returns the names of the given globals that are not referenced
+// by any template in the registry, sorted, so that stale constants may be
+// cleaned up.
+//
+// Globals used only as {deltemplate} variants are resolved while parsing and
+// can not be seen, so they are reported as unused.
+func UnusedGlobals(reg template.Registry, globals data.Map) []string {
+	var used = make(map[string]bool)
+	for _, t := range reg.Templates {
+		ast.Walk(t.Node, func(node ast.Node) bool {
+			if global, ok := node.(*ast.GlobalNode); ok {
+				used[global.Name] = true
+			}
+			return true
+		})
+	}
+	var result []string
+	for name := range globals {
+		if !used[name] {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// GlobalMisuse is a reference to a global whose value is of a type that does
+// not fit the way it is used, e.g. a string global used in a subtraction.
+type GlobalMisuse struct {
+	Template string // fully-qualified name of the template
+	Line     int    // line number of the reference
+	Global   string // name of the global
+	Expr     string // the expression using the global
+}
+
+// CheckGlobalUsage returns the references to globals with non-numeric values
+// that are used as operands of arithmetic or ordering operators.  (+ is
+// allowed, since it also concatenates strings.)
+func CheckGlobalUsage(reg template.Registry) []GlobalMisuse {
+	var result []GlobalMisuse
+	for _, t := range reg.Templates {
+		ast.Walk(t.Node, func(node ast.Node) bool {
+			for _, operand := range numericOperands(node) {
+				var global, ok = operand.(*ast.GlobalNode)
+				if !ok || isNumber(global.Value) {
+					continue
+				}
+				result = append(result, GlobalMisuse{
+					Template: t.Node.Name,
+					Line:     reg.LineNumber(t.Node.ID(), global),
+					Global:   global.Name,
+					Expr:     node.String(),
+				})
+			}
+			return true
+		})
+	}
+	return result
+}
+
+// numericOperands returns the operands of the given node that are required
+// to be numbers.
+func numericOperands(node ast.Node) []ast.Node {
+	switch node := node.(type) {
+	case *ast.NegateNode:
+		return []ast.Node{node.Arg}
+	case *ast.SubNode:
+		return node.Children()
+	case *ast.MulNode:
+		return node.Children()
+	case *ast.DivNode:
+		return node.Children()
+	case *ast.ModNode:
+		return node.Children()
+	case *ast.LtNode:
+		return node.Children()
+	case *ast.LteNode:
+		return node.Children()
+	case *ast.GtNode:
+		return node.Children()
+	case *ast.GteNode:
+		return node.Children()
+	}
+	return nil
+}
+
+func isNumber(value data.Value) bool {
+	switch value.(type) {
+	case data.Int, data.Float:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package parsepasses
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/template"
+)
+
+func TestGlobals(t *testing.T) {
+	var globals = data.Map{
+		"app.MAX":   data.Int(10),
+		"app.NAME":  data.String("soy"),
+		"app.STALE": data.Bool(true),
+		"app.RATIO": data.Float(0.5),
+	}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param x */
+{template .foo}
+  {app.NAME + $x}
+  {if $x > app.MAX * app.RATIO}{$x - app.NAME}{/if}
+  {call .bar}{param y: (-app.NAME) /}{/call}
+{/template}
+
+/** @param y */
+{template .bar}
+  {$y}
+{/template}`, globals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reg template.Registry
+	if err = reg.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+
+	if unused := UnusedGlobals(reg, globals); !reflect.DeepEqual(unused, []string{"app.STALE"}) {
+		t.Errorf("expected [app.STALE], got %v", unused)
+	}
+
+	var expected = []GlobalMisuse{
+		{"test.foo", 6, "app.NAME", "$x-app.NAME"},
+		{"test.foo", 7, "app.NAME", "-app.NAME"},
+	}
+	if actual := CheckGlobalUsage(reg); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, actual)
+	}
+}
@@ -0,0 +1,125 @@
+package parsepasses
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/diag"
+	"github.com/harrisonzhao/soy/template"
+)
+
+// CheckInheritance validates the "base template with overridable blocks"
+// pattern built on delegates, for the given bases.  A base is a {deltemplate}
+// declared outside of any {delpackage}, without a variant, and its blocks are
+// the delegates named beneath it that it renders with {delcall}:
+//
+//	{deltemplate layout.page}
+//	  <header>{delcall layout.page.header /}</header>
+//	  <main>{delcall layout.page.body /}</main>
+//	{/deltemplate}
+//
+// An override of the base is the set of delegates of its blocks declared in a
+// {delpackage} or with a variant.  Each override must provide every block
+// that the base declares, so that an override never renders some of its
+// blocks from the default or another override by omission:
+//
+//	{delpackage checkout}
+//	{deltemplate layout.page.header}...{/deltemplate}
+//	{deltemplate layout.page.body}...{/deltemplate}
+//
+// Errors are returned as a diag.List of the overrides missing blocks, with
+// the code "inheritance", located at their bases, and of the given bases that
+// are not default delegates.
+func CheckInheritance(reg template.Registry, baseNames ...string) error {
+	var errs diag.List
+	var blocksByBase = make(map[string][]string)
+	var baseByBlock = make(map[string]string)
+	var bases = make(map[string]*ast.TemplateNode)
+	for _, base := range baseNames {
+		var t, ok = reg.DelTemplate(base, "")
+		if !ok {
+			errs = append(errs, &diag.Diagnostic{
+				Severity: diag.Error,
+				Code:     "inheritance",
+				Message:  fmt.Sprintf("base %s is not a default {deltemplate}", base),
+			})
+			continue
+		}
+		bases[base] = t.Node
+		ast.Walk(t.Node, func(node ast.Node) bool {
+			var call, ok = node.(*ast.CallNode)
+			if !ok || !call.Delegate || !strings.HasPrefix(call.Name, base+".") {
+				return true
+			}
+			if _, ok := baseByBlock[call.Name]; !ok {
+				baseByBlock[call.Name] = base
+				blocksByBase[base] = append(blocksByBase[base], call.Name)
+			}
+			return true
+		})
+	}
+
+	// the blocks provided by each override of each base
+	var provided = make(map[string]map[override]map[string]bool)
+	for _, t := range reg.Templates {
+		var base, ok = baseByBlock[t.Node.Name]
+		if !ok || !t.Node.Delegate || t.Node.Package == "" && t.Node.Variant == "" {
+			continue
+		}
+		var o = override{t.Node.Package, t.Node.Variant}
+		if provided[base] == nil {
+			provided[base] = make(map[override]map[string]bool)
+		}
+		if provided[base][o] == nil {
+			provided[base][o] = make(map[string]bool)
+		}
+		provided[base][o][t.Node.Name] = true
+	}
+
+	for base, overrides := range provided {
+		for o, blocks := range overrides {
+			var missing []string
+			for _, block := range blocksByBase[base] {
+				if !blocks[block] {
+					missing = append(missing, block)
+				}
+			}
+			if len(missing) > 0 {
+				sort.Strings(missing)
+				var d = &diag.Diagnostic{
+					Severity: diag.Error,
+					Code:     "inheritance",
+					Message: fmt.Sprintf("%v of %s does not provide blocks: %s",
+						o, base, strings.Join(missing, ", ")),
+				}
+				if file := reg.FileName(bases[base]); file != "" {
+					d.File = file
+					d.Range.Start.Line = reg.LineNumber(bases[base].ID(), bases[base])
+				}
+				errs = append(errs, d)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Message < errs[j].Message })
+		return errs
+	}
+	return nil
+}
+
+// override identifies an override of a base template.
+type override struct {
+	pkg, variant string
+}
+
+func (o override) String() string {
+	switch {
+	case o.variant == "":
+		return fmt.Sprintf("override in package %q", o.pkg)
+	case o.pkg == "":
+		return fmt.Sprintf("override of variant %q", o.variant)
+	}
+	return fmt.Sprintf("override of variant %q in package %q", o.variant, o.pkg)
+}
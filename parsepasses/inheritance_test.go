@@ -0,0 +1,88 @@
+package parsepasses
+
+import (
+	"testing"
+
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/template"
+)
+
+const inheritanceBase = `{namespace layout}
+
+{deltemplate layout.page}
+  <header>{delcall layout.page.header /}</header>
+  <main>{delcall layout.page.body /}</main>
+  {delcall other.footer /}
+{/deltemplate}
+
+{deltemplate layout.page.header}
+  Default header
+{/deltemplate}
+
+{deltemplate layout.page.body}
+  Default body
+{/deltemplate}
+`
+
+func TestCheckInheritance(t *testing.T) {
+	type test struct {
+		name  string
+		bases []string
+		files []string
+		err   string
+	}
+	var page = []string{"layout.page"}
+	var tests = []test{
+		{"base only", page, nil, ""},
+		{"complete package", page, []string{`{delpackage checkout}
+{namespace checkout}
+{deltemplate layout.page.header}Checkout{/deltemplate}
+{deltemplate layout.page.body}Cart{/deltemplate}
+`}, ""},
+		{"complete variant", page, []string{`{namespace promo}
+{deltemplate layout.page.header variant="'promo'"}Sale{/deltemplate}
+{deltemplate layout.page.body variant="'promo'"}Deals{/deltemplate}
+`}, ""},
+		{"unrelated delegates", page, []string{`{delpackage other}
+{namespace other}
+{deltemplate other.footer}Footer{/deltemplate}
+{deltemplate layout.page.sidebar}Sidebar{/deltemplate}
+`}, ""},
+		{"incomplete package", page, []string{`{delpackage checkout}
+{namespace checkout}
+{deltemplate layout.page.body}Cart{/deltemplate}
+`}, `override in package "checkout" of layout.page does not provide blocks: layout.page.header`},
+		{"incomplete variant", page, []string{`{namespace promo}
+{deltemplate layout.page.header variant="'promo'"}Sale{/deltemplate}
+`}, `override of variant "promo" of layout.page does not provide blocks: layout.page.body`},
+		{"not checked", nil, []string{`{delpackage checkout}
+{namespace checkout}
+{deltemplate layout.page.body}Cart{/deltemplate}
+`}, ""},
+		{"missing base", []string{"layout.missing"}, nil, `base layout.missing is not a default {deltemplate}`},
+		{"base in package", []string{"checkout.page"}, []string{`{delpackage checkout}
+{namespace checkout}
+{deltemplate checkout.page}{delcall checkout.page.body /}{/deltemplate}
+`}, `base checkout.page is not a default {deltemplate}`},
+	}
+
+	for _, test := range tests {
+		var reg template.Registry
+		for _, src := range append([]string{inheritanceBase}, test.files...) {
+			var tree, err = parse.SoyFile("", src, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = reg.Add(tree); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var err = CheckInheritance(reg, test.bases...)
+		switch {
+		case err == nil && test.err != "":
+			t.Errorf("%s: expected error %q", test.name, test.err)
+		case err != nil && err.Error() != test.err:
+			t.Errorf("%s: expected error %q, got %q", test.name, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package parsepasses
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/soymsg"
+	"github.com/harrisonzhao/soy/template"
+)
+
+// DuplicateMsg is a group of messages, in one or more templates, whose text
+// is the same or nearly so, which may be consolidated into one message.
+type DuplicateMsg struct {
+	Text     string       // text of the first message, with placeholders in braces
+	Messages int          // number of distinct messages, i.e. of distinct IDs
+	Conflict bool         // uses of the same message have different descriptions
+	Uses     []MsgLocator // the messages, ordered by template and line
+}
+
+// MsgLocator is a message within a template.
+type MsgLocator struct {
+	Template string // fully-qualified name of the template containing it
+	Line     int    // line number of the {msg} command
+	ID       uint64 // ID of the message
+	Desc     string // its description for translators
+	Text     string // its text, with placeholders in braces
+}
+
+// DuplicateMessages lists the groups of messages whose text is identical or
+// nearly identical, but which are not the same message, i.e. which differ in
+// their ID, and so are each translated.  It also lists the groups of uses of
+// the same message whose descriptions conflict, since the ID of a message
+// depends only on its text and meaning, so only one of the descriptions is
+// seen by translators.  It is intended to consolidate the message catalog
+// before it is sent for translation.
+//
+// The text of messages is compared with placeholders in braces, ignoring
+// case, runs of whitespace, and trailing punctuation, e.g. "Save {NAME}." and
+// "save  {NAME}" are near-identical.  Messages with different meanings are
+// intended to be translated separately, and are never grouped.
+func DuplicateMessages(reg template.Registry) []DuplicateMsg {
+	type key struct{ meaning, text string }
+	var groups = make(map[key][]MsgLocator)
+	var keys []key
+	for _, t := range reg.Templates {
+		ast.Walk(t.Node, func(node ast.Node) bool {
+			var msg, ok = node.(*ast.MsgNode)
+			if !ok {
+				return true
+			}
+			var text = soymsg.Extract(msg).PlaceholderString()
+			var k = key{msg.Meaning, normalizeMsgText(text)}
+			if _, ok := groups[k]; !ok {
+				keys = append(keys, k)
+			}
+			groups[k] = append(groups[k], MsgLocator{
+				Template: t.Node.Name,
+				Line:     reg.LineNumber(t.Node.ID(), msg),
+				ID:       msg.ID,
+				Desc:     msg.Desc,
+				Text:     text,
+			})
+			return true
+		})
+	}
+
+	var report []DuplicateMsg
+	for _, k := range keys {
+		var uses = groups[k]
+		var messages, conflict = distinctMsgs(uses)
+		if messages < 2 && !conflict {
+			continue
+		}
+		sort.Slice(uses, func(i, j int) bool {
+			if uses[i].Template != uses[j].Template {
+				return uses[i].Template < uses[j].Template
+			}
+			return uses[i].Line < uses[j].Line
+		})
+		report = append(report, DuplicateMsg{uses[0].Text, messages, conflict, uses})
+	}
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Uses[0].Template < report[j].Uses[0].Template ||
+			report[i].Uses[0].Template == report[j].Uses[0].Template &&
+				report[i].Uses[0].Line < report[j].Uses[0].Line
+	})
+	return report
+}
+
+// distinctMsgs returns the number of distinct messages among the given uses,
+// and true if uses of the same message have different descriptions.
+func distinctMsgs(uses []MsgLocator) (int, bool) {
+	var descs = make(map[uint64]string)
+	var conflict bool
+	for _, use := range uses {
+		if desc, ok := descs[use.ID]; !ok {
+			descs[use.ID] = use.Desc
+		} else if desc != use.Desc {
+			conflict = true
+		}
+	}
+	return len(descs), conflict
+}
+
+// normalizeMsgText returns the given message text in lower case, with runs of
+// whitespace collapsed and trailing punctuation removed.
+func normalizeMsgText(text string) string {
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+	return strings.TrimRightFunc(text, func(r rune) bool {
+		return r != '}' && (unicode.IsPunct(r) || unicode.IsSpace(r))
+	})
+}
@@ -0,0 +1,91 @@
+package parsepasses
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/template"
+)
+
+func TestDuplicateMessages(t *testing.T) {
+	var files = []string{`{namespace a}
+
+/** @param name */
+{template .foo}
+  {msg desc="Button to save"}Save {$name}{/msg}
+  {msg desc="Title"}Welcome{/msg}
+  {msg desc="Verb" meaning="verb"}Open{/msg}
+  {msg desc="Label"}Cancel{/msg}
+{/template}`, `{namespace b}
+
+/** @param name */
+{template .bar}
+  {msg desc="Link to save"}save  {$name}.{/msg}
+  {msg desc="Title"}Welcome{/msg}
+  {msg desc="Adjective" meaning="adjective"}Open{/msg}
+  {msg desc="Label"}Cancel!{/msg}
+  {msg desc="Label"}Cancel{/msg}
+{/template}`}
+	var reg template.Registry
+	for _, file := range files {
+		var tree, err = parse.SoyFile("", file, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = reg.Add(tree); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var expected = []DuplicateMsg{
+		{"Save {NAME}", 2, false, []MsgLocator{
+			{"a.foo", 5, 0, "Button to save", "Save {NAME}"},
+			{"b.bar", 5, 0, "Link to save", "save  {NAME}."},
+		}},
+		{"Cancel", 2, false, []MsgLocator{
+			{"a.foo", 8, 0, "Label", "Cancel"},
+			{"b.bar", 8, 0, "Label", "Cancel!"},
+			{"b.bar", 9, 0, "Label", "Cancel"},
+		}},
+	}
+	var actual = DuplicateMessages(reg)
+	for _, group := range actual {
+		for i := range group.Uses {
+			group.Uses[i].ID = 0
+		}
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, actual)
+	}
+}
+
+func TestDuplicateMessagesConflict(t *testing.T) {
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+{template .foo}
+  {msg desc="Greeting"}Hello{/msg}
+  {msg desc="Greeting on the home page"}Hello{/msg}
+  {msg desc="Farewell"}Goodbye{/msg}
+  {msg desc="Farewell"}Goodbye.{/msg}
+  {msg desc="Farewell at checkout"}Goodbye{/msg}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reg template.Registry
+	if err = reg.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+	var actual = DuplicateMessages(reg)
+	if len(actual) != 2 {
+		t.Fatalf("expected two groups, got %v", actual)
+	}
+	if actual[0].Messages != 1 || !actual[0].Conflict || len(actual[0].Uses) != 2 ||
+		actual[0].Uses[0].ID != actual[0].Uses[1].ID {
+		t.Errorf("expected conflicting uses of one message, got %v", actual[0])
+	}
+	if actual[1].Messages != 2 || !actual[1].Conflict || len(actual[1].Uses) != 3 {
+		t.Errorf("expected two messages, one with conflicting uses, got %v", actual[1])
+	}
+}
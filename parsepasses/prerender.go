@@ -0,0 +1,98 @@
+package parsepasses
+
+import (
+	"strings"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/soyhtml"
+	"github.com/harrisonzhao/soy/template"
+)
+
+// PrerenderConstants replaces the print commands within each template whose
+// output does not depend on the render by raw text of their output, merged
+// with any adjacent raw text, so that they are not evaluated on every render.
+// For example, {'a&b'} becomes the text "a&amp;b" in a template autoescaped
+// in the simple mode.  It is most effective after FoldConstants.
+//
+// A print command is rendered if its value is a constant of a primitive type
+// (see FoldConstants) and it has no print directives but pure ones (see
+// soyhtml.PureDirectives) with constant arguments.  Templates escaped by
+// strict or contextual autoescaping are left untouched, since the output of
+// their print commands depends on the context in which they appear, as are
+// messages, and print commands whose output contains tags, which raw text
+// would be subject to the nonce of the render (see soyhtml.CSPNonceKey).
+func PrerenderConstants(reg template.Registry) {
+	for _, t := range reg.Templates {
+		var autoescape = t.Node.Autoescape
+		if autoescape == ast.AutoescapeUnspecified {
+			autoescape = t.Namespace.Autoescape
+		}
+		switch autoescape {
+		case ast.AutoescapeContextual, ast.AutoescapeStrict:
+			continue
+		case ast.AutoescapeUnspecified:
+			autoescape = ast.AutoescapeOn
+		}
+		ast.Walk(t.Node.Body, func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.MsgNode:
+				return false
+			case *ast.ListNode:
+				node.Nodes = prerenderList(node.Nodes, autoescape)
+			}
+			return true
+		})
+	}
+}
+
+// prerenderList returns the given nodes of a list with its constant print
+// commands rendered to raw text.
+func prerenderList(nodes []ast.Node, autoescape ast.AutoescapeType) []ast.Node {
+	var result = make([]ast.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if print, ok := node.(*ast.PrintNode); ok {
+			if text, ok := prerenderPrint(print, autoescape); ok {
+				node = &ast.RawTextNode{print.Pos, []byte(text)}
+			}
+		}
+		if text, ok := node.(*ast.RawTextNode); ok && len(result) > 0 {
+			// The text of the nodes may be shared (see template.Registry.InternText),
+			// so it is copied rather than appended to.
+			if prev, ok := result[len(result)-1].(*ast.RawTextNode); ok {
+				var merged = make([]byte, 0, len(prev.Text)+len(text.Text))
+				merged = append(append(merged, prev.Text...), text.Text...)
+				result[len(result)-1] = &ast.RawTextNode{prev.Pos, merged}
+				continue
+			}
+		}
+		result = append(result, node)
+	}
+	return result
+}
+
+// prerenderPrint returns the output of the given print command within a
+// template of the given autoescape mode, if it is constant.
+func prerenderPrint(node *ast.PrintNode, autoescape ast.AutoescapeType) (string, bool) {
+	if !isConstant(node.Arg) {
+		return "", false
+	}
+	if value, err := soyhtml.EvalExpr(node.Arg); err != nil || literalOf(node.Arg.Position(), value) == nil {
+		return "", false
+	}
+	for _, directiveNode := range node.Directives {
+		var directive, ok = soyhtml.PrintDirectives[directiveNode.Name]
+		if !ok || !soyhtml.PureDirectives[directiveNode.Name] || directive.Apply == nil {
+			return "", false
+		}
+		for _, arg := range directiveNode.Args {
+			if !isConstant(arg) {
+				return "", false
+			}
+		}
+	}
+	var text, err = soyhtml.RenderPrint(node, autoescape)
+	if err != nil || strings.Contains(text, "<") {
+		return "", false
+	}
+	return text, true
+}
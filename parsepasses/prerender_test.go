@@ -0,0 +1,79 @@
+package parsepasses
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/soyhtml"
+	"github.com/harrisonzhao/soy/template"
+)
+
+func TestPrerenderConstants(t *testing.T) {
+	var tests = []struct {
+		autoescape, body, rendered string
+	}{
+		{"true", `a{1 + 2}b`, `a3b`},
+		{"true", `{'a&b'} {PI}`, `a&amp;b 3.14159`},
+		{"false", `{'a&b'}`, `a&b`},
+		{"true", `{'abcdef'|truncate:4}{'a b'|encodeUriComponent}`, `a...a%20b`},
+		{"true", `{'a&b'|noAutoescape}`, `{'a&b'|noAutoescape}`},
+		{"false", `{'<script>'}`, `{'<script>'}`},
+		{"true", `{range(3)}{isNonnull($x)}`, `{range(3)}{isNonnull($x)}`},
+		{"true", `{if $x}{'x'}{else}{$x}{2}{/if}`, `{if $x}x{else}{$x}2{/if}`},
+		{"true", `{msg desc=""}{1 + 2}{/msg}`, `{msg desc=""}`},
+		{"strict", `{'a'}`, `{'a'}`},
+		{"contextual", `{'a'}`, `{'a'}`},
+	}
+
+	var globals = data.Map{"PI": data.Float(3.14159)}
+	for _, test := range tests {
+		var src = "{namespace test autoescape=\"" + test.autoescape + "\"}\n" +
+			"/** @param? x */\n{template .test}" + test.body + "{/template}"
+		var expected, err = renderTest(src, globals, false)
+		if err != nil {
+			t.Errorf("%s: %v", test.body, err)
+			continue
+		}
+		var reg template.Registry
+		var tree, _ = parse.SoyFile("", src, globals)
+		reg.Add(tree)
+		FoldConstants(reg)
+		PrerenderConstants(reg)
+		if actual := reg.Templates[0].Node.Body.String(); actual != test.rendered {
+			t.Errorf("%s: expected %s, got %s", test.body, test.rendered, actual)
+		}
+
+		// The output is the same as that of the original.
+		var actual, _ = renderTest(src, globals, true)
+		if actual != expected {
+			t.Errorf("%s: expected output %q, got %q", test.body, expected, actual)
+		}
+	}
+}
+
+// renderTest renders the template test.test of the given source, with its
+// constants pre-rendered if prerender is set.  Render errors are returned as
+// the output, so that they may be compared.
+func renderTest(src string, globals data.Map, prerender bool) (string, error) {
+	var tree, err = parse.SoyFile("", src, globals)
+	if err != nil {
+		return "", err
+	}
+	var reg template.Registry
+	if err = reg.Add(tree); err != nil {
+		return "", err
+	}
+	if prerender {
+		if err = FoldConstants(reg); err != nil {
+			return "", err
+		}
+		PrerenderConstants(reg)
+	}
+	var buf bytes.Buffer
+	if err = soyhtml.NewTofu(&reg).NewRenderer("test.test").Execute(&buf, data.Map{"x": data.Int(1)}); err != nil {
+		return err.Error(), nil
+	}
+	return buf.String(), nil
+}
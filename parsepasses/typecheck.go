@@ -0,0 +1,386 @@
+package parsepasses
+
+import (
+	"fmt"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/template"
+)
+
+// CheckTypes infers the types of the expressions within each template and
+// reports operations that would fail at render time because of the types of
+// their operands, e.g. subtracting a string, adding a list to a number,
+// accessing a key of a list, or iterating over a number.
+//
+// Types are inferred from literals, globals, operators, {let} variables, loop
+// variables of range(), and the results of the builtin functions.  Params
+// and injected data are untyped, so expressions depending on them are only
+// checked as far as their type is implied by the operators applied to them.
+// Errors are returned as a *diag.Diagnostic with the code "type".
+func CheckTypes(reg template.Registry) (err error) {
+	var current template.Template
+	defer func() {
+		if err2 := recover(); err2 != nil {
+			if typeErr, ok := err2.(typeError); ok {
+				err = templateError(reg, current, typeErr.node, "type", typeErr.error)
+				return
+			}
+			panic(err2)
+		}
+	}()
+
+	for _, t := range reg.Templates {
+		current = t
+		var tc = typeChecker{make(map[string]exprType)}
+		tc.check(t.Node.Body)
+	}
+	return nil
+}
+
+// exprType is the type of an expression, as far as it is known.
+type exprType int
+
+const (
+	typeUnknown exprType = iota
+	typeNull
+	typeBool
+	typeInt
+	typeFloat
+	typeNumber // an int or a float
+	typeString
+	typeList
+	typeMap
+)
+
+var typeNames = []string{"unknown", "null", "bool", "int", "float", "number", "string", "list", "map"}
+
+func (t exprType) String() string {
+	return typeNames[t]
+}
+
+// isNumber returns true if values of the type are numbers.
+func (t exprType) isNumber() bool {
+	return t == typeInt || t == typeFloat || t == typeNumber
+}
+
+// mayBeNumber returns true if values of the type may be numbers.
+func (t exprType) mayBeNumber() bool {
+	return t == typeUnknown || t.isNumber()
+}
+
+// funcType is the type of a builtin function: the types of its arguments
+// (typeUnknown where any type is accepted) and of its result.
+type funcType struct {
+	args   []exprType
+	result exprType
+}
+
+var funcTypes = map[string]funcType{
+	"isNonnull":   {nil, typeBool},
+	"length":      {[]exprType{typeList}, typeInt},
+	"keys":        {[]exprType{typeMap}, typeList},
+	"augmentMap":  {[]exprType{typeMap, typeMap}, typeMap},
+	"round":       {[]exprType{typeNumber, typeInt}, typeNumber},
+	"floor":       {[]exprType{typeNumber}, typeInt},
+	"ceiling":     {[]exprType{typeNumber}, typeInt},
+	"min":         {[]exprType{typeNumber, typeNumber}, typeNumber},
+	"max":         {[]exprType{typeNumber, typeNumber}, typeNumber},
+	"randomInt":   {[]exprType{typeInt}, typeInt},
+	"strContains": {[]exprType{typeString, typeString}, typeBool},
+	"range":       {[]exprType{typeInt, typeInt, typeInt}, typeList},
+	"hasData":     {nil, typeBool},
+	"flagEnabled": {[]exprType{typeString}, typeBool},
+	"localizeUri": {[]exprType{typeString, typeString}, typeString},
+	"sort":        {[]exprType{typeList, typeString}, typeList},
+	"sortDesc":    {[]exprType{typeList, typeString}, typeList},
+	"toJson":      {nil, typeString},
+	"attributes":  {[]exprType{typeMap}, typeString},
+	"index":       {nil, typeInt},
+	"isFirst":     {nil, typeBool},
+	"isLast":      {nil, typeBool},
+}
+
+// typeError is raised by the type checker, to be recovered by CheckTypes.
+type typeError struct {
+	node ast.Node // the node that failed to check
+	error
+}
+
+type typeChecker struct {
+	vars map[string]exprType // types of the variables in scope, by name
+}
+
+func (tc *typeChecker) errorf(node ast.Node, format string, args ...interface{}) {
+	panic(typeError{node, fmt.Errorf("%v: "+format, append([]interface{}{node}, args...)...)})
+}
+
+// check checks the given node and returns the type of its value, if it is an
+// expression.
+func (tc *typeChecker) check(node ast.Node) exprType {
+	switch node := node.(type) {
+	case nil:
+		return typeUnknown
+	case *ast.ListNode:
+		// {let} variables are in scope for the rest of the block.
+		var outer = tc.vars
+		tc.vars = copyTypes(outer)
+		for _, child := range node.Nodes {
+			tc.check(child)
+		}
+		tc.vars = outer
+		return typeUnknown
+	case *ast.LetValueNode:
+		tc.vars[node.Name] = tc.check(node.Expr)
+		return typeUnknown
+	case *ast.LetContentNode:
+		tc.check(node.Body)
+		tc.vars[node.Name] = typeString
+		return typeUnknown
+	case *ast.ForNode:
+		var list = tc.check(node.List)
+		if list != typeUnknown && list != typeList {
+			tc.errorf(node.List, "cannot iterate over %v", list)
+		}
+		var outer = tc.vars
+		tc.vars = copyTypes(outer)
+		tc.vars[node.Var] = typeUnknown
+		if node.IsRange() {
+			tc.vars[node.Var] = typeInt
+		}
+		if node.IndexVar != "" {
+			tc.vars[node.IndexVar] = typeInt
+		}
+		tc.check(node.Body)
+		tc.vars = outer
+		tc.check(node.IfEmpty)
+		return typeUnknown
+
+	case *ast.NullNode:
+		return typeNull
+	case *ast.BoolNode:
+		return typeBool
+	case *ast.IntNode:
+		return typeInt
+	case *ast.FloatNode:
+		return typeFloat
+	case *ast.StringNode:
+		return typeString
+	case *ast.ListLiteralNode:
+		tc.checkChildren(node)
+		return typeList
+	case *ast.MapLiteralNode:
+		tc.checkChildren(node)
+		return typeMap
+	case *ast.GlobalNode:
+		return valueType(node.Value)
+	case *ast.FunctionNode:
+		return tc.checkFunc(node)
+	case *ast.DataRefNode:
+		return tc.checkDataRef(node)
+
+	case *ast.NegateNode:
+		var arg = tc.check(node.Arg)
+		if !arg.mayBeNumber() {
+			tc.errorf(node, "cannot negate %v", arg)
+		}
+		return numberOr(arg)
+	case *ast.AddNode:
+		var arg1, arg2 = tc.check(node.Arg1), tc.check(node.Arg2)
+		switch {
+		case arg1 == typeString || arg2 == typeString:
+			return typeString
+		case arg1 == typeUnknown || arg2 == typeUnknown:
+			return typeUnknown // strings concatenate with any type
+		case !arg1.isNumber() || !arg2.isNumber():
+			tc.errorf(node, "cannot add %v and %v", arg1, arg2)
+		}
+		return arithmetic(arg1, arg2)
+	case *ast.SubNode:
+		return arithmetic(tc.checkNumbers(node.BinaryOpNode, "subtract"))
+	case *ast.MulNode:
+		return arithmetic(tc.checkNumbers(node.BinaryOpNode, "multiply"))
+	case *ast.DivNode:
+		tc.checkNumbers(node.BinaryOpNode, "divide")
+		return typeFloat
+	case *ast.ModNode:
+		var arg1, arg2 = tc.checkNumbers(node.BinaryOpNode, "take the modulus of")
+		if arg1 == typeFloat || arg2 == typeFloat {
+			tc.errorf(node, "cannot take the modulus of %v and %v", arg1, arg2)
+		}
+		return typeInt
+	case *ast.LtNode:
+		tc.checkNumbers(node.BinaryOpNode, "compare")
+		return typeBool
+	case *ast.LteNode:
+		tc.checkNumbers(node.BinaryOpNode, "compare")
+		return typeBool
+	case *ast.GtNode:
+		tc.checkNumbers(node.BinaryOpNode, "compare")
+		return typeBool
+	case *ast.GteNode:
+		tc.checkNumbers(node.BinaryOpNode, "compare")
+		return typeBool
+	case *ast.EqNode, *ast.NotEqNode, *ast.NotNode, *ast.AndNode, *ast.OrNode:
+		tc.checkChildren(node.(ast.ParentNode))
+		return typeBool
+	case *ast.ElvisNode:
+		var arg1, arg2 = tc.check(node.Arg1), tc.check(node.Arg2)
+		if arg1 == typeNull {
+			return arg2
+		}
+		return unify(arg1, arg2)
+	case *ast.TernNode:
+		tc.check(node.Arg1)
+		return unify(tc.check(node.Arg2), tc.check(node.Arg3))
+	}
+
+	if parent, ok := node.(ast.ParentNode); ok {
+		tc.checkChildren(parent)
+	}
+	return typeUnknown
+}
+
+func (tc *typeChecker) checkChildren(node ast.ParentNode) {
+	for _, child := range node.Children() {
+		tc.check(child)
+	}
+}
+
+// checkNumbers checks that both operands of the given operator may be numbers,
+// and returns their types.
+func (tc *typeChecker) checkNumbers(node ast.BinaryOpNode, verb string) (exprType, exprType) {
+	var arg1, arg2 = tc.check(node.Arg1), tc.check(node.Arg2)
+	if !arg1.mayBeNumber() || !arg2.mayBeNumber() {
+		tc.errorf(&node, "cannot %v %v and %v", verb, arg1, arg2)
+	}
+	return arg1, arg2
+}
+
+// checkFunc checks the arguments of a call to a builtin function, and returns
+// the type of its result.  Other functions are unknown.
+func (tc *typeChecker) checkFunc(node *ast.FunctionNode) exprType {
+	var fn, ok = funcTypes[node.Name]
+	for i, arg := range node.Args {
+		var argType = tc.check(arg)
+		if ok && i < len(fn.args) && !assignable(argType, fn.args[i]) {
+			tc.errorf(node, "argument %d of %v() must be %v, not %v", i+1, node.Name, fn.args[i], argType)
+		}
+	}
+	return fn.result
+}
+
+// checkDataRef checks the accesses of the given data reference, and returns
+// its type.  The types of the values within lists and maps are not tracked.
+func (tc *typeChecker) checkDataRef(node *ast.DataRefNode) exprType {
+	var ref = tc.vars[node.Key]
+	for i, access := range node.Access {
+		if ref == typeNull && nullSafe(access) {
+			return typeNull
+		}
+		var prefix = &ast.DataRefNode{node.Pos, node.Key, node.Access[:i]}
+		switch access := access.(type) {
+		case *ast.DataRefKeyNode:
+			if ref != typeUnknown && ref != typeMap {
+				tc.errorf(prefix, "cannot access key %q of %v", access.Key, ref)
+			}
+		case *ast.DataRefIndexNode:
+			if ref != typeUnknown && ref != typeList {
+				tc.errorf(prefix, "cannot access index %d of %v", access.Index, ref)
+			}
+		case *ast.DataRefExprNode:
+			var key = tc.check(access.Arg)
+			switch {
+			case ref == typeUnknown || key == typeUnknown:
+			case ref == typeList && key != typeInt:
+				tc.errorf(prefix, "cannot index list by %v", key)
+			case ref == typeMap && key == typeInt:
+				tc.errorf(prefix, "cannot index map by %v", key)
+			case ref != typeList && ref != typeMap:
+				tc.errorf(prefix, "cannot index %v", ref)
+			}
+		}
+		ref = typeUnknown
+	}
+	return ref
+}
+
+// nullSafe returns true if the given access is null-safe, e.g. $a?.b.
+func nullSafe(access ast.Node) bool {
+	switch access := access.(type) {
+	case *ast.DataRefKeyNode:
+		return access.NullSafe
+	case *ast.DataRefIndexNode:
+		return access.NullSafe
+	case *ast.DataRefExprNode:
+		return access.NullSafe
+	}
+	return false
+}
+
+// assignable returns true if a value of type t may be passed where the given
+// type is expected.
+func assignable(t, expected exprType) bool {
+	return t == typeUnknown || expected == typeUnknown || t == expected ||
+		expected == typeNumber && t.isNumber() ||
+		t == typeNumber && expected.isNumber()
+}
+
+// arithmetic returns the type of the result of arithmetic on the given types.
+func arithmetic(arg1, arg2 exprType) exprType {
+	if arg1 == typeInt && arg2 == typeInt {
+		return typeInt
+	}
+	if arg1 == typeFloat || arg2 == typeFloat {
+		return typeFloat
+	}
+	return typeNumber
+}
+
+// numberOr returns the given type if it is a number, or else typeNumber.
+func numberOr(t exprType) exprType {
+	if t.isNumber() {
+		return t
+	}
+	return typeNumber
+}
+
+// unify returns the type of a value of one of the given types.
+func unify(t1, t2 exprType) exprType {
+	switch {
+	case t1 == t2:
+		return t1
+	case t1.isNumber() && t2.isNumber():
+		return typeNumber
+	}
+	return typeUnknown
+}
+
+// valueType returns the type of the given value.
+func valueType(value data.Value) exprType {
+	switch value.(type) {
+	case data.Null:
+		return typeNull
+	case data.Bool:
+		return typeBool
+	case data.Int:
+		return typeInt
+	case data.Float:
+		return typeFloat
+	case data.String:
+		return typeString
+	case data.List:
+		return typeList
+	case data.Map:
+		return typeMap
+	}
+	return typeUnknown
+}
+
+func copyTypes(vars map[string]exprType) map[string]exprType {
+	var result = make(map[string]exprType, len(vars))
+	for k, v := range vars {
+		result[k] = v
+	}
+	return result
+}
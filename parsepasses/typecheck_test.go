@@ -0,0 +1,59 @@
+package parsepasses
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/template"
+)
+
+func TestCheckTypes(t *testing.T) {
+	var tests = []struct {
+		body string
+		err  string // expected error, or "" if the template should pass
+	}{
+		{`{1 + 2.5}{'a' + [1]}{$x + [1]}{$x - $y}`, ""},
+		{`{let $list: [1, 2] /}{$list[0]}{length($list)}{foreach $i in $list}{$i}{/foreach}`, ""},
+		{`{let $map: ['a': 1] /}{$map.a}{$map['a']}{keys($map)}`, ""},
+		{`{for $i in range(3)}{$i % 2}{/for}`, ""},
+		{`{let $n: null /}{$n?.a}`, ""},
+		{`{[1] + 2}`, "[1]+2: cannot add list and int"},
+		{`{'a' - 1}`, "'a'-1: cannot subtract string and int"},
+		{`{-[1]}`, "-[1]: cannot negate list"},
+		{`{1.5 % 2}`, "1.5%2: cannot take the modulus of float and int"},
+		{`{if ['a': 1] < 2}{/if}`, "cannot compare map and int"},
+		{`{let $list: [1] /}{$list.a}`, `$list: cannot access key "a" of list`},
+		{`{let $list: [1] /}{$list['a']}`, "$list: cannot index list by string"},
+		{`{let $map: ['a': 1] /}{$map.0}`, "$map: cannot access index 0 of map"},
+		{`{let $s: 'abc' /}{$s.a}`, `$s: cannot access key "a" of string`},
+		{`{let $s kind="text"}a{/let}{$s[0]}`, "$s: cannot index string"},
+		{`{foreach $i in 3}{$i}{/foreach}`, "3: cannot iterate over int"},
+		{`{length(['a': 1])}`, "argument 1 of length() must be list, not map"},
+		{`{let $n: length([1]) /}{$n.a}`, `$n: cannot access key "a" of int`},
+		{`{if true}{let $x: 1 /}{/if}{let $y: 'a' /}{$y - 1}`, "cannot subtract string and int"},
+	}
+
+	for _, test := range tests {
+		var reg template.Registry
+		var tree, err = parse.SoyFile("", "{namespace test}\n/** @param? x\n @param? y */\n{template .test}"+test.body+"{/template}", nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.body, err)
+			continue
+		}
+		if err = reg.Add(tree); err != nil {
+			t.Error(err)
+			continue
+		}
+
+		err = CheckTypes(reg)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", test.body, err)
+		case test.err != "" && err == nil:
+			t.Errorf("%s: expected error %q", test.body, test.err)
+		case test.err != "" && !strings.HasSuffix(err.Error(), test.err):
+			t.Errorf("%s: expected error %q, got %q", test.body, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Package soydoc generates an HTML catalog of a registry's templates, for
+// browsing the templates available to an application.
+//
+// The catalog is a single page listing the templates grouped by namespace,
+// with their documentation, params, the templates they call and are called
+// by (linked to each other), and example renders of those templates for which
+// fixture data is provided.
+package soydoc
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"sort"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/soyhtml"
+	soyt "github.com/harrisonzhao/soy/template"
+)
+
+// Options configures the catalog.
+type Options struct {
+	Title    string              // title of the page; "Templates" if empty
+	Tofu     *soyhtml.Tofu       // renders the examples, if set
+	Fixtures map[string]data.Map // data for example renders, by template name
+}
+
+// Write writes the catalog of the given registry's templates to w.
+func Write(w io.Writer, reg *soyt.Registry, opts Options) error {
+	var page = catalog{Title: opts.Title}
+	if page.Title == "" {
+		page.Title = "Templates"
+	}
+
+	if err := reg.LoadAll(); err != nil {
+		return err
+	}
+	var calledBy = make(map[string][]link)
+	var entries = make(map[string][]*entry)
+	for _, t := range reg.Templates {
+		var e = &entry{
+			Template: t,
+			ID:       t.Node.ID(),
+			Line:     reg.LineNumber(t.Node.ID(), t.Node),
+		}
+		e.Deprecated, e.DeprecationNote = t.Deprecated()
+		var self = link{e.ID, e.ID}
+		ast.Walk(t.Node, func(node ast.Node) bool {
+			var call, ok = node.(*ast.CallNode)
+			if !ok {
+				return true
+			}
+			for _, callee := range callees(reg, call) {
+				e.Calls = append(e.Calls, callee)
+				calledBy[callee.ID] = append(calledBy[callee.ID], self)
+			}
+			return true
+		})
+		if fixture, ok := opts.Fixtures[t.Node.Name]; ok && opts.Tofu != nil && !t.Node.Delegate {
+			e.Example, e.ExampleErr = render(opts.Tofu, t.Node.Name, fixture)
+		}
+		entries[t.Namespace.Name] = append(entries[t.Namespace.Name], e)
+	}
+
+	for name, templates := range entries {
+		for _, e := range templates {
+			e.CalledBy = dedupe(calledBy[e.ID])
+			e.Calls = dedupe(e.Calls)
+		}
+		page.Namespaces = append(page.Namespaces, namespace{name, templates})
+	}
+	sort.Slice(page.Namespaces, func(i, j int) bool {
+		return page.Namespaces[i].Name < page.Namespaces[j].Name
+	})
+	return catalogTemplate.Execute(w, page)
+}
+
+type catalog struct {
+	Title      string
+	Namespaces []namespace
+}
+
+type namespace struct {
+	Name      string
+	Templates []*entry
+}
+
+// entry describes a template in the catalog.
+type entry struct {
+	soyt.Template
+	ID              string // anchor of the template's entry (see ast.TemplateNode.ID)
+	Line            int
+	Deprecated      bool
+	DeprecationNote string
+	Calls           []link
+	CalledBy        []link
+	Example         string // output of the example render, if any
+	ExampleErr      string // error of the example render, if it failed
+}
+
+// link refers to the entry of a template.
+type link struct {
+	ID    string // anchor of the template's entry
+	Label string
+}
+
+// callees returns links to the templates that the given call may render: the
+// template it names, or each variant of the delegate template it names.
+func callees(reg *soyt.Registry, call *ast.CallNode) []link {
+	if !call.Delegate {
+		return []link{{call.Name, call.Name}}
+	}
+	var result []link
+	for _, t := range reg.Templates {
+		if !t.Node.Delegate || t.Node.Name != call.Name {
+			continue
+		}
+		var label = t.Node.Name
+		if t.Node.Variant != "" {
+			label += " (" + t.Node.Variant + ")"
+		}
+		result = append(result, link{t.Node.ID(), label})
+	}
+	return result
+}
+
+// dedupe returns the given links without repeats, preserving their order.
+func dedupe(links []link) []link {
+	var seen = make(map[link]bool)
+	var result []link
+	for _, l := range links {
+		if !seen[l] {
+			seen[l] = true
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+// render returns the output of rendering the named template with the given
+// data, or the error that the render failed with.
+func render(tofu *soyhtml.Tofu, name string, fixture data.Map) (output, errMsg string) {
+	var buf bytes.Buffer
+	if err := tofu.NewRenderer(name).Execute(&buf, fixture); err != nil {
+		return "", err.Error()
+	}
+	return buf.String(), ""
+}
+
+var catalogTemplate = template.Must(template.New("catalog").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 0; display: flex; }
+nav { width: 18em; height: 100vh; overflow: auto; position: sticky; top: 0; padding: 1em; background: #f4f4f4; }
+nav ul { list-style: none; padding-left: 1em; }
+main { flex: 1; padding: 1em 2em; }
+section { border-bottom: 1px solid #ddd; padding-bottom: 1em; }
+code, .meta { color: #555; }
+.deprecated { color: #a00; }
+iframe { width: 100%; border: 1px solid #ccc; }
+</style>
+</head>
+<body>
+<nav>
+<h1>{{.Title}}</h1>
+{{range .Namespaces}}<h2>{{.Name}}</h2>
+<ul>
+{{range .Templates}}<li><a href="#{{.ID}}">{{.ID}}</a></li>
+{{end}}</ul>
+{{end}}</nav>
+<main>
+{{range .Namespaces}}<h2 id="{{.Name}}">{{.Name}}</h2>
+{{range .Templates}}<section id="{{.ID}}">
+<h3>{{if .Node.Delegate}}deltemplate {{end}}<code>{{.ID}}</code></h3>
+<p class="meta">{{if .Node.Private}}private, {{end}}line {{.Line}}</p>
+{{if .Deprecated}}<p class="deprecated">Deprecated. {{.DeprecationNote}}</p>
+{{end}}{{if .Doc.Desc}}<p>{{.Doc.Desc}}</p>
+{{end}}{{if .Doc.Params}}<h4>Params</h4>
+<dl>
+{{range .Doc.Params}}<dt><code>{{.Name}}</code>{{if .Optional}} (optional){{end}}</dt><dd>{{.Desc}}</dd>
+{{end}}</dl>
+{{end}}{{if .Calls}}<h4>Calls</h4>
+<ul>
+{{range .Calls}}<li><a href="#{{.ID}}">{{.Label}}</a></li>
+{{end}}</ul>
+{{end}}{{if .CalledBy}}<h4>Called by</h4>
+<ul>
+{{range .CalledBy}}<li><a href="#{{.ID}}">{{.Label}}</a></li>
+{{end}}</ul>
+{{end}}{{if .ExampleErr}}<h4>Example</h4>
+<pre class="deprecated">{{.ExampleErr}}</pre>
+{{else if .Example}}<h4>Example</h4>
+<iframe sandbox srcdoc="{{.Example}}"></iframe>
+{{end}}</section>
+{{end}}{{end}}</main>
+</body>
+</html>
+`))
@@ -0,0 +1,68 @@
+package soydoc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/soyhtml"
+	"github.com/harrisonzhao/soy/template"
+)
+
+func TestWrite(t *testing.T) {
+	var tree, err = parse.SoyFile("greet.soy", `{namespace greet}
+
+/**
+ * Greets the user.
+ * @param name The user's name.
+ */
+{template .hello}
+  <b>Hello {$name}</b>{delcall greet.extra /}
+{/template}
+
+/** @deprecated Use .hello. */
+{template .old}{call .hello}{param name: 'x' /}{/call}{/template}
+
+{deltemplate greet.extra}!{/deltemplate}
+{deltemplate greet.extra variant="'x'"}?{/deltemplate}
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reg template.Registry
+	if err = reg.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = Write(&buf, &reg, Options{
+		Tofu: soyhtml.NewTofu(&reg),
+		Fixtures: map[string]data.Map{
+			"greet.hello": {"name": data.String("Rob")},
+			"greet.old":   {},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`<title>Templates</title>`,
+		`<h2 id="greet">greet</h2>`,
+		`<section id="greet.hello">`,
+		`<p>Greets the user.</p>`,
+		`<dt><code>name</code></dt><dd>The user&#39;s name.</dd>`,
+		`<li><a href="#greet.extra%3a%3a0">greet.extra</a></li>`,
+		`<li><a href="#greet.extra%3ax%3a0">greet.extra (x)</a></li>`,
+		`<iframe sandbox srcdoc="&lt;b&gt;Hello Rob&lt;/b&gt;!"></iframe>`,
+		`<p class="deprecated">Deprecated. Use .hello.</p>`,
+		`<h4>Called by</h4>
+<ul>
+<li><a href="#greet.old">greet.old</a></li>`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected output to contain %q, got:\n%s", expected, buf.String())
+		}
+	}
+}
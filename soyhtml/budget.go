@@ -0,0 +1,98 @@
+package soyhtml
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Budgets configures time budgets for individual {call}s, to isolate which
+// templates blow the latency budget of a complex page.
+type Budgets struct {
+	Default   time.Duration            // budget of templates not listed in Templates, if positive
+	Templates map[string]time.Duration // budgets by fully-qualified template name
+
+	// OnExceeded is called once a call has taken longer than its budget, as
+	// soon as the render of the call moves on to its next node, so that a
+	// slow call may be aborted before it completes.  If it returns an error,
+	// the render is aborted with that error, e.g. it may log the slow call and
+	// return nil to continue rendering.  If OnExceeded is nil, the render is
+	// aborted with the *SlowCall.
+	OnExceeded func(*SlowCall) error
+}
+
+// budget returns the time budget of the named template, or zero if it has
+// none.
+func (b *Budgets) budget(name string) time.Duration {
+	if budget, ok := b.Templates[name]; ok {
+		return budget
+	}
+	return b.Default
+}
+
+// SlowCall describes a {call} that exceeded its time budget.
+type SlowCall struct {
+	Template string        // fully-qualified name of the called template
+	Elapsed  time.Duration // time spent rendering the call when it was found to exceed its budget
+	Budget   time.Duration // time budget of the called template
+	Stack    []string      // templates being rendered, outermost first, ending in Template
+}
+
+func (c *SlowCall) Error() string {
+	return fmt.Sprintf("call to %s took %v, exceeding its budget of %v, in %s",
+		c.Template, c.Elapsed, c.Budget, strings.Join(c.Stack, " > "))
+}
+
+// callBudget is the time budget of a call being rendered.
+type callBudget struct {
+	template string
+	start    time.Time
+	budget   time.Duration
+	stack    []string
+	exceeded int32       // set once the call is reported, atomically, since calls may be rendered concurrently
+	outer    *callBudget // budget of the call containing it, or nil
+}
+
+// startBudget begins the time budget of the call to the current template, if
+// it has one.
+func (s *state) startBudget() {
+	var budget = s.budgets.budget(s.tmpl.Node.Name)
+	if budget <= 0 {
+		return
+	}
+	s.budget = &callBudget{
+		template: s.tmpl.Node.Name,
+		start:    time.Now(),
+		budget:   budget,
+		stack:    append([]string(nil), *s.stack...),
+		outer:    s.budget,
+	}
+}
+
+// checkBudgets reports the calls being rendered that have exceeded their
+// budgets, innermost first.  It is called at node boundaries, so that a call
+// is reported (and the render aborted) as soon as possible, rather than once
+// it completes.  Each call is reported once.
+func (s *state) checkBudgets() {
+	var now = time.Now()
+	for b := s.budget; b != nil; b = b.outer {
+		var elapsed = now.Sub(b.start)
+		if elapsed <= b.budget || !atomic.CompareAndSwapInt32(&b.exceeded, 0, 1) {
+			continue
+		}
+		var slow = &SlowCall{
+			Template: b.template,
+			Elapsed:  elapsed,
+			Budget:   b.budget,
+			Stack:    b.stack,
+		}
+		var err error = slow
+		if s.budgets.OnExceeded != nil {
+			err = s.budgets.OnExceeded(slow)
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package soyhtml
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/harrisonzhao/soy/data"
+)
+
+// RenderCache stores the output of templates declared cacheable="true", so
+// that subsequent renders with the same params may reuse it.  Output is keyed
+// by the template, the render's locale, and the values of the params declared
+// in the template's soydoc, including the kinds of sanitized content.
+// Cacheable templates should therefore not depend on $ij or other undeclared
+// data.
+//
+// A RenderCache is unbounded unless MaxEntries is set, in which case the least
+// recently used entries are evicted.  It is safe for concurrent use, and may
+// be shared by many Tofus.
+type RenderCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *cacheEntry, by key
+	lru     *list.List               // of *cacheEntry, most recently used first
+	max     int
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	key     string
+	output  []byte
+	expires time.Time // zero if the entry does not expire
+}
+
+// NewRenderCache returns an empty render cache.
+func NewRenderCache() *RenderCache {
+	return &RenderCache{entries: make(map[string]*list.Element), lru: list.New(), now: time.Now}
+}
+
+// MaxEntries bounds the number of entries in the cache, evicting the least
+// recently used entries to make room for new ones.  Zero means no bound.
+func (c *RenderCache) MaxEntries(max int) *RenderCache {
+	c.mu.Lock()
+	c.max = max
+	c.evict()
+	c.mu.Unlock()
+	return c
+}
+
+// Len returns the number of entries in the cache, including expired ones that
+// have not yet been evicted.
+func (c *RenderCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Clear removes all entries from the cache.
+func (c *RenderCache) Clear() {
+	c.mu.Lock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.mu.Unlock()
+}
+
+func (c *RenderCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var elem, ok = c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	var entry = elem.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && !c.now().Before(entry.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.output, true
+}
+
+func (c *RenderCache) put(key string, output []byte, ttl time.Duration) {
+	var entry = &cacheEntry{key, output, time.Time{}}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ttl > 0 {
+		entry.expires = c.now().Add(ttl)
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.evict()
+}
+
+// evict removes the least recently used entries beyond the maximum.  The
+// cache must be locked.
+func (c *RenderCache) evict() {
+	for c.max > 0 && c.lru.Len() > c.max {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes the given entry.  The cache must be locked.
+func (c *RenderCache) remove(elem *list.Element) {
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+	c.lru.Remove(elem)
+}
+
+// cacheKey returns the key under which the output of the current template is
+// cached, or false if the params can not be encoded.
+func (s *state) cacheKey() (string, bool) {
+	var params = make(map[string]interface{})
+	for _, param := range s.tmpl.Doc.Params {
+		params[param.Name] = s.cacheValue(s.context.lookup(param.Name))
+	}
+	var j, err = json.Marshal(params)
+	if err != nil {
+		return "", false
+	}
+	return s.tmpl.Node.ID() + "\x00" + s.locale + "\x00" + string(j), true
+}
+
+// cacheValue returns the given value as encoded in cache keys, in which
+// sanitized content is distinguished by its kind from strings and content of
+// other kinds, since they are printed differently.
+func (s *state) cacheValue(val data.Value) interface{} {
+	switch val := s.resolve(val).(type) {
+	case data.SanitizedContent:
+		return map[string]string{"\x00kind": string(val.Kind), "\x00content": val.Content}
+	case data.List:
+		var items = make([]interface{}, len(val))
+		for i, item := range val {
+			items[i] = s.cacheValue(item)
+		}
+		return items
+	case data.Map:
+		var m = make(map[string]interface{}, len(val))
+		for key, item := range val {
+			m[key] = s.cacheValue(item)
+		}
+		return m
+	default:
+		return val
+	}
+}
+
+// walkCached writes the body of the current (cacheable) template, reusing
+// previously cached output if available.
+func (s *state) walkCached() {
+	var key, ok = s.cacheKey()
+	if !ok {
+		s.walk(s.tmpl.Node.Body)
+		return
+	}
+	if output, ok := s.cache.get(key); ok {
+		if s.result != nil {
+			s.result.CacheHits++
+		}
+		if _, err := s.wr.Write(withNonce(output, s.nonce)); err != nil {
+			s.errorf("%s", err)
+		}
+		return
+	}
+	var nonce, nonces = s.nonce, s.nonces
+	if s.contexts != nil {
+		s.nonce, s.nonces = cachedNonce, s.contexts.get(s.tmpl.Node).nonces
+	}
+	var output = s.renderBlock(s.tmpl.Node.Body)
+	s.nonce, s.nonces = nonce, nonces
+	s.cache.put(key, output, s.tmpl.Node.TTL)
+	if _, err := s.wr.Write(withNonce(output, s.nonce)); err != nil {
+		s.errorf("%s", err)
+	}
+}
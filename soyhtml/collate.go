@@ -0,0 +1,161 @@
+package soyhtml
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/harrisonzhao/soy/data"
+)
+
+// Collator compares strings in the collation order of a locale, e.g. with
+// golang.org/x/text/collate.  It is consulted by the sort() and sortDesc()
+// functions:
+//
+//	{foreach $product in sort($products, 'name')}
+//	  <li>{$product.name}</li>
+//	{/foreach}
+//
+// which sort a list by the given field of its items, or by the items
+// themselves.  Compare returns a negative number if a sorts before b, a
+// positive number if after, and zero if they are equivalent.
+type Collator interface {
+	Compare(ctx context.Context, locale, a, b string) int
+}
+
+// CollatorFunc adapts an ordinary function to a Collator.
+type CollatorFunc func(ctx context.Context, locale, a, b string) int
+
+// Compare calls f(ctx, locale, a, b).
+func (f CollatorFunc) Compare(ctx context.Context, locale, a, b string) int {
+	return f(ctx, locale, a, b)
+}
+
+// Collator sets the collator used to compare strings by the sort() and
+// sortDesc() functions, for the locale of the render.  Without a collator,
+// strings are compared case-insensitively, and then by their bytes.
+func (tofu *Tofu) Collator(collator Collator) *Tofu {
+	tofu.collator = collator
+	return tofu
+}
+
+// The ranks of the kinds of sort keys, in ascending order.
+const (
+	rankNumber = iota
+	rankString
+	rankOther // compare equal to each other
+	rankNull  // sorted last in either direction
+)
+
+// sortItem is an item of a list being sorted, along with its sort key.
+type sortItem struct {
+	item data.Value
+	key  data.Value
+	rank int
+}
+
+// funcSort returns a copy of the list sorted in ascending order, by the given
+// field of its items or by the items themselves.
+func funcSort(fc FuncContext, v []data.Value) data.Value {
+	return sortList("sort", fc, v, false)
+}
+
+// funcSortDesc returns a copy of the list sorted in descending order, by the
+// given field of its items or by the items themselves.
+func funcSortDesc(fc FuncContext, v []data.Value) data.Value {
+	return sortList("sortDesc", fc, v, true)
+}
+
+// sortList returns a stably sorted copy of the list given by the function's
+// arguments.  Numbers sort before strings, and strings before other values,
+// which are left in their order; nulls (including missing fields) sort last
+// in either direction.
+func sortList(name string, fc FuncContext, v []data.Value, desc bool) data.Value {
+	var list, ok = v[0].(data.List)
+	if !ok {
+		panic(name + ": expected a list")
+	}
+	var items = make([]sortItem, len(list))
+	for i, item := range list {
+		var key = sortResolve(name, item)
+		if len(v) == 2 {
+			if m, ok := key.(data.Map); ok {
+				key = sortResolve(name, m.Key(v[1].String()))
+			} else {
+				key = data.Undefined{}
+			}
+		}
+		items[i] = sortItem{item, key, sortRank(key)}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		var a, b = items[i], items[j]
+		if a.rank == rankNull || b.rank == rankNull {
+			return a.rank < b.rank
+		}
+		if desc {
+			a, b = b, a
+		}
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		switch a.rank {
+		case rankNumber:
+			return sortNumber(a.key) < sortNumber(b.key)
+		case rankString:
+			return compareStrings(fc, string(a.key.(data.String)), string(b.key.(data.String))) < 0
+		}
+		return false
+	})
+
+	var result = make(data.List, len(items))
+	for i, item := range items {
+		result[i] = item.item
+	}
+	return result
+}
+
+// compareStrings compares the given strings with the render's collator, or
+// case-insensitively if it has none.
+func compareStrings(fc FuncContext, a, b string) int {
+	if fc.Collator != nil {
+		return fc.Collator.Compare(fc.Context, fc.Locale, a, b)
+	}
+	if c := strings.Compare(strings.ToLower(a), strings.ToLower(b)); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+func sortRank(key data.Value) int {
+	switch key.(type) {
+	case data.Int, data.Float:
+		return rankNumber
+	case data.String:
+		return rankString
+	case data.Null, data.Undefined:
+		return rankNull
+	}
+	return rankOther
+}
+
+func sortNumber(key data.Value) float64 {
+	if i, ok := key.(data.Int); ok {
+		return float64(i)
+	}
+	return float64(key.(data.Float))
+}
+
+// sortResolve returns the value of the given item or field, resolving it if it
+// is Lazy.
+func sortResolve(name string, val data.Value) data.Value {
+	var lazy, ok = val.(*data.Lazy)
+	if !ok {
+		return val
+	}
+	var result, err = lazy.Resolve()
+	if err != nil {
+		panic(name + ": " + err.Error())
+	}
+	return result
+}
@@ -0,0 +1,140 @@
+package soyhtml
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+
+	"github.com/harrisonzhao/soy/ast"
+)
+
+// ConcurrentCalls enables or disables an experimental mode in which sibling
+// {call}s, i.e. runs of two or more calls separated by nothing but raw text,
+// are rendered concurrently, each to a chunk of output that is written in
+// order once all have completed.  Since templates can not modify the data
+// passed to them, the calls are independent, and the output is the same as
+// that of an ordinary render, but pages composed of many slow partials (e.g.
+// resolving data.Lazy values) are rendered in less time.
+//
+// Functions, print directives, and the other extensions of the Tofu may be
+// called concurrently within a render, so they must be safe for concurrent
+// use, as they are for concurrent renders.  Calls are rendered one at a time
+// as usual within templates escaped by strict or contextual autoescaping,
+// where the output of each depends on the context left by the previous one,
+// and in renders that are traced, simulated, record usage, or render a
+// section.
+func (r *Renderer) ConcurrentCalls(enabled bool) *Renderer {
+	r.parallel = enabled
+	return r
+}
+
+// walkList renders the given nodes of a list, rendering runs of sibling calls
+// concurrently if enabled.
+func (s *state) walkList(nodes []ast.Node) {
+	if !s.concurrent || !s.canRenderConcurrently() {
+		for _, node := range nodes {
+			s.walk(node)
+		}
+		return
+	}
+	for i := 0; i < len(nodes); {
+		var end, calls = i, 0
+		for ; end < len(nodes); end++ {
+			if _, ok := nodes[end].(*ast.CallNode); ok {
+				calls++
+			} else if _, ok := nodes[end].(*ast.RawTextNode); !ok {
+				break
+			}
+		}
+		if calls < 2 {
+			s.walk(nodes[i])
+			i++
+			continue
+		}
+		s.walkCalls(nodes[i:end])
+		i = end
+	}
+}
+
+// canRenderConcurrently returns true if the calls of the current template may
+// be rendered concurrently.
+func (s *state) canRenderConcurrently() bool {
+	if _, ok := s.wr.(*contextWriter); ok || s.escapes != nil {
+		return false
+	}
+	return s.trace == nil && s.usage == nil && !s.simulated && s.section == ""
+}
+
+// callChunk is the output of a call rendered concurrently.
+type callChunk struct {
+	buf    bytes.Buffer
+	result *RenderResult // summary of the call's render, if one is kept
+	err    interface{}   // value with which the render panicked, if any
+}
+
+// walkCalls renders the given run of calls and raw text, rendering the calls
+// concurrently.  As when rendered in turn, the output stops at the first call
+// that fails.
+func (s *state) walkCalls(nodes []ast.Node) {
+	var chunks = make([]callChunk, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		if call, ok := node.(*ast.CallNode); ok {
+			wg.Add(1)
+			go func(chunk *callChunk, call *ast.CallNode) {
+				defer wg.Done()
+				chunk.render(s, call)
+			}(&chunks[i], call)
+		}
+	}
+	wg.Wait()
+
+	for i, node := range nodes {
+		if _, ok := node.(*ast.CallNode); !ok {
+			s.walk(node)
+			continue
+		}
+		var chunk = &chunks[i]
+		if chunk.err != nil {
+			panic(chunk.err)
+		}
+		if s.result != nil {
+			s.result.TemplatesVisited = append(s.result.TemplatesVisited, chunk.result.TemplatesVisited...)
+			s.result.MessagesLookedUp += chunk.result.MessagesLookedUp
+			s.result.CacheHits += chunk.result.CacheHits
+		}
+		if _, err := s.wr.Write(chunk.buf.Bytes()); err != nil {
+			s.errorf("%s", err)
+		}
+	}
+}
+
+// render renders the given call of the given state to the chunk, using a
+// copy of the state, so that it does not share the stack or scope.
+func (chunk *callChunk) render(parent *state, call *ast.CallNode) {
+	var state = *parent
+	var stack = append([]string(nil), *parent.stack...)
+	state.stack = &stack
+	state.context = parent.context[:len(parent.context):len(parent.context)]
+	if parent.written != nil {
+		// The chunk is counted by the caller once it is written.
+		state.written = new(int64)
+	}
+	state.wr = state.contentWriter(&chunk.buf)
+	if parent.result != nil {
+		chunk.result = &RenderResult{}
+		state.result = chunk.result
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			if e, ok := e.(runtime.Error); ok {
+				chunk.err = state.renderError(fmt.Sprintf("%v\n%v", e, string(debug.Stack())))
+				return
+			}
+			chunk.err = e
+		}
+	}()
+	state.walk(call)
+}
@@ -0,0 +1,227 @@
+package soyhtml
+
+import (
+	"bytes"
+	"html"
+	htmltemplate "html/template"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/template"
+)
+
+// The conformance tests compare the escaping decisions of contextual
+// autoescaping with those of html/template, for a corpus of contexts and
+// values.  The outputs are not expected to be identical byte for byte: for
+// example, soy quotes JS strings with ' and percent-encodes in upper case,
+// where html/template uses " and lower case.  Instead, each output is decoded
+// as the browser would decode the context (see conformanceContext.decode), and
+// the decoded outputs are compared.
+//
+// Where the decoded outputs differ, the divergence must be listed in
+// conformanceDivergences along with the reason for it.  A listed divergence
+// that no longer occurs is also reported, so the list stays minimal.
+
+// conformanceContext is a context in which a value is printed.
+type conformanceContext struct {
+	name   string
+	tmpl   string // the html/template source, which prints the value with {{.}}
+	decode func(string) string
+}
+
+// soy returns the soy source of the context's template.
+func (c conformanceContext) soy() string {
+	return "{namespace test autoescape=\"contextual\"}\n/** @param x */\n{template .t}" +
+		strings.NewReplacer("{{.}}", "{$x}", "{{if .}}", "{if $x}", "{{else}}", "{else}", "{{end}}", "{/if}",
+			"{", "{lb}", "}", "{rb}").Replace(c.tmpl) +
+		"{/template}"
+}
+
+var conformanceContexts = []conformanceContext{
+	{"text", `<p>{{.}}</p>`, decodeHTML},
+	{"unclosed text", `<p>{{.}}`, decodeHTML},
+	{"rcdata", `<textarea>{{.}}</textarea>`, decodeHTML},
+	{"title", `<title>{{.}}</title>`, decodeHTML},
+	{"comment", `<!-- {{.}} -->`, decodeHTML},
+	{"attr", `<a title="{{.}}">`, decodeHTML},
+	{"attr single quoted", `<a title='{{.}}'>`, decodeHTML},
+	{"attr unquoted", `<a title={{.}}>`, decodeHTML},
+	{"attr middle", `<div title="a {{.}} b">`, decodeHTML},
+	{"attr value", `<input value="{{.}}">`, decodeHTML},
+	{"attr name", `<p {{.}}>`, decodeHTML},
+	{"uri", `<a href="{{.}}">`, decodeURI},
+	{"uri src", `<img src="{{.}}">`, decodeURI},
+	{"uri path", `<a href="http://x/{{.}}">`, decodeURI},
+	{"uri query", `<a href="/s?q={{.}}">`, decodeURI},
+	{"uri fragment", `<a href="#{{.}}">`, decodeURI},
+	{"js value", `<script>var v = {{.}};</script>`, decodeJS},
+	{"js string", `<script>var v = '{{.}}';</script>`, decodeJS},
+	{"js double quoted string", `<script>var v = "{{.}}";</script>`, decodeJS},
+	{"js attr value", `<a onclick="f({{.}})">`, decodeJS},
+	{"js attr string", `<a onclick="f('{{.}}')">`, decodeJS},
+	{"js after regex", `<script>var r = /'/; var s = {{.}};</script>`, decodeJS},
+	{"js after division", `<script>var r = a / 2, q = '/'; var s = {{.}};</script>`, decodeJS},
+	{"js regex", `<script>var r = /{{.}}/;</script>`, decodeJS},
+	{"js template literal", "<script>var s = `{{.}}`;</script>", decodeJS},
+	{"js template substitution", "<script>var s = `${ {a: '`'}.a } {{.}}`;</script>", decodeJS},
+	{"css", `<style>p { color: {{.}} }</style>`, decodeHTML},
+	{"css attr", `<p style="color: {{.}}">`, decodeHTML},
+	{"after if", `{{if .}}<b>{{else}}<i>{{end}}{{.}}`, decodeHTML},
+	{"attr value after if", `<a {{if .}}title="a {{else}}title="b {{end}}{{.}}">`, decodeHTML},
+	{"uri after if", `<a href="{{if .}}/a{{else}}/b{{end}}?q={{.}}">`, decodeURI},
+	{"uri in if", `<a href="{{if .}}{{.}}{{else}}/b{{end}}">`, decodeURI},
+	{"js after if", `<script>var v = {{if .}}'a'{{else}}'b'{{end}} + {{.}};</script>`, decodeJS},
+}
+
+// conformanceAmbiguous are contexts in which the branches of a conditional
+// end in different contexts, which both soy and html/template reject, rather
+// than guessing at the context of the value printed after them.  (Soy also
+// rejects branches that end within different attribute names, even of the
+// same type, since the name may continue after them.)
+var conformanceAmbiguous = []conformanceContext{
+	{"attr name", `<a {{if .}}title{{else}}href{{end}}="{{.}}">`, nil},
+	{"uri start", `<a href="{{if .}}/x?q={{end}}{{.}}">`, nil},
+	{"script", `{{if .}}<script>{{end}}{{.}}`, nil},
+	{"js string", `<script>var v = {{if .}}'{{end}}{{.}}';</script>`, nil},
+}
+
+var conformanceValues = []string{
+	`plain`,
+	`42`,
+	`red`,
+	`<b>"it's" & co</b>`,
+	`</script><b>`,
+	`javascript:alert(1)`,
+	`/a b?c=d&e`,
+	`1;alert(1)`,
+	`${alert(1)}`,
+}
+
+// conformanceDivergences lists the intentional divergences from html/template,
+// by context and value.  An empty value applies to every value.
+var conformanceDivergences = map[[2]string]string{
+	{"comment", ""}: "html/template strips comments from the output, while soy retains " +
+		"them and escapes values printed within them as HTML",
+	{"attr name", "42"}: "soy rejects attribute names that do not begin with a letter",
+	{"uri", `<b>"it's" & co</b>`}: "soy rejects URIs containing characters that may not appear " +
+		"unencoded in a URI, such as < and \", rather than percent-encoding them",
+	{"uri src", `<b>"it's" & co</b>`}:   "as for uri",
+	{"uri in if", `<b>"it's" & co</b>`}: "as for uri",
+}
+
+func TestConformance(t *testing.T) {
+	var diverged = make(map[[2]string]bool)
+	for _, ctx := range conformanceContexts {
+		var reg template.Registry
+		tree, err := parse.SoyFile("", ctx.soy(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = reg.Add(tree); err != nil {
+			t.Fatal(err)
+		}
+		var tofu = NewTofu(&reg)
+		var tmpl = htmltemplate.Must(htmltemplate.New(ctx.name).Parse(ctx.tmpl))
+
+		for _, value := range conformanceValues {
+			var soyOut, goOut bytes.Buffer
+			if err = tofu.Render(&soyOut, "test.t", data.Map{"x": data.String(value)}); err != nil {
+				t.Errorf("%s %q: %v", ctx.name, value, err)
+				continue
+			}
+			if err = tmpl.Execute(&goOut, value); err != nil {
+				t.Errorf("%s %q: %v", ctx.name, value, err)
+				continue
+			}
+
+			if ctx.decode(soyOut.String()) == ctx.decode(goOut.String()) {
+				continue
+			}
+			var key = [2]string{ctx.name, value}
+			if _, ok := conformanceDivergences[key]; ok {
+				diverged[key] = true
+			} else if _, ok := conformanceDivergences[[2]string{ctx.name, ""}]; !ok {
+				t.Errorf("%s %q: soy output %q diverges from html/template output %q",
+					ctx.name, value, soyOut.String(), goOut.String())
+			}
+		}
+	}
+
+	for key, reason := range conformanceDivergences {
+		if key[1] != "" && !diverged[key] {
+			t.Errorf("%s %q: documented divergence no longer occurs: %s", key[0], key[1], reason)
+		}
+	}
+}
+
+func TestConformanceAmbiguous(t *testing.T) {
+	for _, ctx := range conformanceAmbiguous {
+		var reg template.Registry
+		tree, err := parse.SoyFile("", ctx.soy(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = reg.Add(tree); err != nil {
+			t.Fatal(err)
+		}
+		var tmpl = htmltemplate.Must(htmltemplate.New(ctx.name).Parse(ctx.tmpl))
+		for _, value := range []string{"", "javascript:alert(1)"} {
+			var soyOut, goOut bytes.Buffer
+			if err = NewTofu(&reg).Render(&soyOut, "test.t", data.Map{"x": data.String(value)}); err == nil {
+				t.Errorf("%s %q: soy rendered %q, expected an error", ctx.name, value, soyOut.String())
+			}
+			if err = tmpl.Execute(&goOut, value); err == nil {
+				t.Errorf("%s %q: html/template rendered %q, expected an error", ctx.name, value, goOut.String())
+			}
+		}
+	}
+}
+
+// decodeHTML decodes the character references in the output, and replaces
+// the values substituted for rejected values by a marker.
+func decodeHTML(out string) string {
+	return strings.NewReplacer("zSoyz", "rejected", "ZgotmplZ", "rejected").
+		Replace(html.UnescapeString(out))
+}
+
+// decodeURI is like decodeHTML, but it also decodes the percent-encoding in
+// the output.
+func decodeURI(out string) string {
+	out = decodeHTML(strings.Replace(out, "about:invalid#zSoyz", "#zSoyz", -1))
+	if decoded, err := url.QueryUnescape(out); err == nil {
+		return decoded
+	}
+	return out
+}
+
+// decodeJS decodes the character references in the output and the escape
+// sequences within its JS strings, and replaces ' by ", so that the same
+// string quoted differently decodes the same.
+func decodeJS(out string) string {
+	out = html.UnescapeString(out)
+	var buf bytes.Buffer
+	for i := 0; i < len(out); i++ {
+		if out[i] != '\\' || i+1 == len(out) {
+			buf.WriteByte(out[i])
+			continue
+		}
+		i++
+		var width = map[byte]int{'u': 4, 'x': 2}[out[i]]
+		if width == 0 || i+1+width > len(out) {
+			buf.WriteByte(out[i])
+			continue
+		}
+		var code, err = strconv.ParseUint(out[i+1:i+1+width], 16, 32)
+		if err != nil {
+			buf.WriteByte(out[i])
+			continue
+		}
+		buf.WriteRune(rune(code))
+		i += width
+	}
+	return strings.Replace(buf.String(), "'", `"`, -1)
+}
@@ -0,0 +1,305 @@
+package soyhtml
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/data"
+)
+
+// In contextual autoescaping mode (autoescape="contextual"), the context of
+// each print is inferred from the raw text of the template preceding it, in
+// the manner of html/template, and the value printed is escaped as in strict
+// mode (see strict.go).  Unlike strict mode, the context is inferred once per
+// template rather than tracked through the output as it is written, so:
+//
+//   - the branches of an {if}, {switch}, {plural}, or {select} must end in the
+//     same context, and the body of a loop must end in the context in which
+//     it began, or the template is rejected (see CheckContexts);
+//   - {call}s are assumed to write complete HTML elements, and their callees
+//     begin in the context of their kind, or HTML text if none is given;
+//   - {let} and {param} blocks begin in the context of their kind, or HTML
+//     text if none is given;
+//   - {msg}s within a value, such as an attribute value, are printed as a
+//     single value (see state.evalMsgValue).
+
+// The same inference finds the <script> and <style> tags written in HTML
+// text, to which the CSP nonce is added (see CSPNonceKey), since the context
+// of the raw text is needed to tell them from text that merely looks like a
+// tag, e.g. within a JS string, a comment, or a <textarea>.
+
+// printContexts maps each print of a template, and each message printed as a
+// value, to the state of the output preceding it.
+type printContexts map[ast.Node]escState
+
+// nonceOffsets maps each raw text node of a template to the offsets within
+// its text at which the CSP nonce attribute is inserted, i.e. just after the
+// names of the <script> and <style> tags opened in it.
+type nonceOffsets map[*ast.RawTextNode][]int
+
+// contextError is raised for a template whose contexts can not be inferred,
+// at the node where they become ambiguous.
+type contextError struct {
+	node ast.Node
+	msg  string
+}
+
+func (e *contextError) Error() string {
+	return e.msg
+}
+
+// CheckContexts returns an error if the contexts of the prints of the given
+// template can not be inferred for contextual autoescaping, because the
+// branches of a conditional end in different contexts (e.g. <a {if
+// $c}title{else}href{/if}="{$u}">), or the body of a loop does not end in the
+// context in which it began.  It returns the node at which the contexts
+// diverge, along with the error.  Such templates fail to render.
+func CheckContexts(node *ast.TemplateNode) (ast.Node, error) {
+	if inf := inferContexts(node); inf.err != nil {
+		return inf.err.node, inf.err
+	}
+	return nil, nil
+}
+
+// contextCache holds the contexts inferred for each template rendered, by
+// template.
+type contextCache struct {
+	sync.Map
+}
+
+// get returns the contexts of the given template, inferring them on first use.
+func (c *contextCache) get(node *ast.TemplateNode) *inference {
+	if inf, ok := c.Load(node); ok {
+		return inf.(*inference)
+	}
+	var inf = inferContexts(node)
+	c.Store(node, inf)
+	return inf
+}
+
+// inference is the result of inferring the contexts within a template.
+type inference struct {
+	prints  printContexts
+	nonces  nonceOffsets
+	err     *contextError // the first ambiguity, past which prints may be misplaced
+	pending *nonceTag     // the <script> or <style> tag being written, if any
+}
+
+// nonceTag is the location at which a nonce is inserted into a tag.
+type nonceTag struct {
+	node   *ast.RawTextNode
+	offset int
+}
+
+// inferContexts returns the contexts within the given template.  Past an
+// ambiguity, the contexts are inferred as if the first branch was taken, so
+// that tags may still be found.
+func inferContexts(node *ast.TemplateNode) *inference {
+	var inf = &inference{prints: make(printContexts), nonces: make(nonceOffsets)}
+	inf.inferKind(node.Kind, node.Body)
+	return inf
+}
+
+// inferKind infers the contexts within a block of content of the given kind.
+func (inf *inference) inferKind(kind data.ContentKind, node ast.Node) {
+	var st, pending = newEscState(kind), inf.pending
+	inf.pending = nil
+	inf.infer(&st, node)
+	inf.pending = pending
+}
+
+// ambiguous records an ambiguity at the given node, unless one was found
+// already.
+func (inf *inference) ambiguous(node ast.Node, format string, args ...interface{}) {
+	if inf.err == nil {
+		inf.err = &contextError{node, fmt.Sprintf(format, args...)}
+	}
+}
+
+// infer infers the contexts within the given node, which is written beginning
+// in the given state, and advances the state past it.
+func (inf *inference) infer(st *escState, node ast.Node) {
+	switch node := node.(type) {
+	case *ast.RawTextNode:
+		for i, b := range node.Text {
+			inf.next(st, node, i, b)
+		}
+	case *ast.LiteralNode:
+		for i := 0; i < len(node.Body); i++ {
+			st.next(node.Body[i])
+		}
+	case *ast.PrintNode:
+		inf.print(st, node)
+	case *ast.MsgNode:
+		if !st.inValue() {
+			inf.infer(st, node.Body)
+			break
+		}
+		inf.print(st, node)
+	case *ast.CssNode:
+		st.printed()
+	case *ast.LetContentNode:
+		inf.inferKind(node.Kind, node.Body)
+	case *ast.CallNode:
+		for _, param := range node.Params {
+			if param, ok := param.(*ast.CallParamContentNode); ok {
+				inf.inferKind(param.Kind, param.Content)
+			}
+		}
+	case *ast.IfNode:
+		var bodies []ast.Node
+		var exhaustive bool
+		for _, cond := range node.Conds {
+			bodies = append(bodies, cond.Body)
+			exhaustive = exhaustive || cond.Cond == nil
+		}
+		inf.inferBranches(st, node, "{if}", bodies, exhaustive)
+	case *ast.SwitchNode:
+		var bodies []ast.Node
+		var exhaustive bool
+		for _, switchCase := range node.Cases {
+			bodies = append(bodies, switchCase.Body)
+			exhaustive = exhaustive || len(switchCase.Values) == 0
+		}
+		inf.inferBranches(st, node, "{switch}", bodies, exhaustive)
+	case *ast.PluralNode:
+		var bodies []ast.Node
+		for _, pluralCase := range node.Cases {
+			bodies = append(bodies, pluralCase.Body)
+		}
+		inf.inferBranches(st, node, "{plural}", append(bodies, node.Default), node.Default != nil)
+	case *ast.SelectNode:
+		var bodies []ast.Node
+		for _, selectCase := range node.Cases {
+			bodies = append(bodies, selectCase.Body)
+		}
+		inf.inferBranches(st, node, "{select}", append(bodies, node.Default), node.Default != nil)
+	case *ast.ForNode:
+		inf.inferLoop(st, node)
+	case *ast.LogNode, *ast.DebuggerNode:
+		// no output
+	case ast.ParentNode:
+		for _, child := range node.Children() {
+			if child != nil {
+				inf.infer(st, child)
+			}
+		}
+	}
+}
+
+// print records the state in which the given node prints a value, which must
+// be one in which values may be printed, and advances the state past it.
+func (inf *inference) print(st *escState, node ast.Node) {
+	inf.prints[node] = st.clone()
+	if _, err := st.escape(data.Null{}); err != nil {
+		inf.ambiguous(node, "%s", err)
+	}
+	st.printed()
+}
+
+// next advances the state past the given byte at offset i of the given raw
+// text, noting where a nonce is inserted into <script> and <style> tags.
+func (inf *inference) next(st *escState, node *ast.RawTextNode, i int, b byte) {
+	var prev = st.ctx
+	st.next(b)
+	switch {
+	case prev == ctxTagName && st.ctx != ctxTagName:
+		if tag := st.element(); tag == "script" || tag == "style" {
+			inf.pending = &nonceTag{node, i}
+		}
+	case prev == ctxAttrName && st.ctx != ctxAttrName && string(st.attr) == "nonce":
+		inf.pending = nil // the tag has a nonce already
+	}
+	if inf.pending != nil && !inTag(st) {
+		// A loop body may be inferred twice; each offset is recorded once.
+		var offsets = inf.nonces[inf.pending.node]
+		if len(offsets) == 0 || offsets[len(offsets)-1] < inf.pending.offset {
+			inf.nonces[inf.pending.node] = append(offsets, inf.pending.offset)
+		}
+		inf.pending = nil
+	}
+}
+
+// inTag returns true if the given state is within a tag, past its name.
+func inTag(st *escState) bool {
+	switch st.ctx {
+	case ctxTag, ctxAttrName, ctxAfterAttrName, ctxBeforeValue, ctxAttr:
+		return true
+	}
+	return false
+}
+
+// inferBranches infers the contexts within the given alternative bodies of the
+// given node, each written beginning in the given state, and advances the
+// state past them.  Unless the bodies are exhaustive, none may be written, as
+// if an empty body was.  The bodies must all end in the same context, though
+// a "/" in JS code after them is ambiguous if they disagree on its meaning.
+func (inf *inference) inferBranches(st *escState, node ast.Node, tag string, bodies []ast.Node, exhaustive bool) {
+	var end *escState
+	if !exhaustive {
+		var none = st.clone()
+		end = &none
+	}
+	var pending = inf.pending
+	for _, body := range bodies {
+		if body == nil {
+			continue
+		}
+		var branch = st.clone()
+		inf.pending = pending
+		inf.infer(&branch, body)
+		if end == nil {
+			end = &branch
+		} else if !end.sameContext(&branch) {
+			inf.ambiguous(node, "%s branches end in different contexts: %s and %s",
+				tag, end.describe(), branch.describe())
+		} else {
+			end.joinJS(&branch)
+		}
+	}
+	if end != nil {
+		*st = *end
+	}
+	if !inTag(st) {
+		inf.pending = nil // added to the tag within the branches
+	}
+}
+
+// inferLoop infers the contexts within the given loop, written beginning in
+// the given state.  Since its body may be written any number of times, it
+// must end in the context in which it began, as must its {ifempty} block.  If
+// the body changes the meaning of a "/" in JS code, it is inferred again with
+// the meaning unknown, as it is after the loop.
+func (inf *inference) inferLoop(st *escState, node *ast.ForNode) {
+	var pending = inf.pending
+	var begin = st.clone()
+	for _, body := range []ast.Node{node.Body, node.IfEmpty} {
+		if body == nil {
+			continue
+		}
+		var start = begin.clone()
+		var end = start.clone()
+		inf.pending = pending
+		inf.infer(&end, body)
+		if body == node.Body && start.inJS() && end.sameContext(&start) && end.slash != start.slash {
+			start.joinJS(&end)
+			end = start.clone()
+			inf.pending = pending
+			inf.infer(&end, body)
+		}
+		if !end.sameContext(&start) {
+			var part = "loop body"
+			if body == node.IfEmpty {
+				part = "{ifempty} block"
+			}
+			inf.ambiguous(node, "%s ends in a different context (%s) than it begins (%s)",
+				part, end.describe(), start.describe())
+		} else {
+			st.joinJS(&end)
+		}
+	}
+	if !inTag(st) {
+		inf.pending = nil
+	}
+}
@@ -2,10 +2,12 @@ package soyhtml
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/url"
 	"regexp"
+	"strings"
 	"text/template"
 	"unicode/utf8"
 
@@ -13,26 +15,57 @@ import (
 )
 
 // PrintDirective represents a transformation applied when printing a value.
+//
+// CancelAutoescape indicates that the directive's output is not subject to the
+// autoescaping that would otherwise be applied.  Produces indicates the kind of
+// sanitized content the directive's output represents (e.g. escapeHtml produces
+// HTML), or "" if the output is not sanitized.  It is used to detect directive
+// chains that would double-escape a value.
+//
+// Directives that depend on the render (e.g. to respect its deadline or to
+// carry trace IDs to other services) may provide ApplyContext instead of
+// Apply.  If set, it is called in preference to Apply.
 type PrintDirective struct {
 	Apply            func(value data.Value, args []data.Value) data.Value
 	ValidArgLengths  []int
 	CancelAutoescape bool
+	Produces         data.ContentKind
+	ApplyContext     func(ctx FuncContext, value data.Value, args []data.Value) data.Value
 }
 
 // PrintDirectives are the builtin print directives.
 // Callers may add their own print directives to this map.
 var PrintDirectives = map[string]PrintDirective{
-	"insertWordBreaks":  {directiveInsertWordBreaks, []int{1}, true},
-	"changeNewlineToBr": {directiveChangeNewlineToBr, []int{0}, true},
-	"truncate":          {directiveTruncate, []int{1, 2}, false},
-	"id":                {directiveNoAutoescape, []int{0}, true},
-	"noAutoescape":      {directiveNoAutoescape, []int{0}, true},
-	"escapeHtml":        {directiveEscapeHtml, []int{0}, true},
-	"escapeUri":         {directiveEscapeUri, []int{0}, true},
-	"escapeJsString":    {directiveEscapeJsString, []int{0}, true},
-	"bidiSpanWrap":      {nil, []int{0}, false}, // unimplemented
-	"bidiUnicodeWrap":   {nil, []int{0}, false}, // unimplemented
-	"json":              {directiveJson, []int{0}, true},
+	"insertWordBreaks":         {directiveInsertWordBreaks, []int{1}, true, data.KindHTML, nil},
+	"changeNewlineToBr":        {directiveChangeNewlineToBr, []int{0}, true, data.KindHTML, nil},
+	"truncate":                 {directiveTruncate, []int{1, 2}, false, "", nil},
+	"id":                       {directiveNoAutoescape, []int{0}, true, data.KindHTML, nil},
+	"noAutoescape":             {directiveNoAutoescape, []int{0}, true, data.KindHTML, nil},
+	"escapeHtml":               {directiveEscapeHtml, []int{0}, true, data.KindHTML, nil},
+	"escapeUri":                {directiveEscapeUri, []int{0}, true, data.KindURI, nil},
+	"encodeUriComponent":       {directiveEncodeUriComponent, []int{0}, false, data.KindURI, nil},
+	"base64Encode":             {directiveBase64Encode, []int{0}, false, "", nil},
+	"base64Decode":             {directiveBase64Decode, []int{0}, false, "", nil},
+	"escapeJsString":           {directiveEscapeJsString, []int{0}, true, data.KindJSStrChars, nil},
+	"filterNormalizeUri":       {directiveFilterNormalizeUri, []int{0}, false, "", nil},
+	"filterTrustedResourceUri": {directiveFilterTrustedResourceUri, []int{0}, false, "", nil},
+	"bidiSpanWrap":             {nil, []int{0}, false, "", nil}, // unimplemented
+	"bidiUnicodeWrap":          {nil, []int{0}, false, "", nil}, // unimplemented
+	"json":                     {directiveJson, []int{0}, true, "", nil},
+	"markdown":                 {nil, []int{0}, true, data.KindHTML, directiveMarkdown},
+}
+
+// PureDirectives names the print directives whose output depends only on
+// their input and arguments, so that they may be applied to constants when
+// templates are compiled (see parsepasses.FoldConstants).  Callers may add
+// their own print directives to this map.
+var PureDirectives = map[string]bool{
+	"truncate":                 true,
+	"encodeUriComponent":       true,
+	"base64Encode":             true,
+	"base64Decode":             true,
+	"filterNormalizeUri":       true,
+	"filterTrustedResourceUri": true,
 }
 
 func directiveInsertWordBreaks(value data.Value, args []data.Value) data.Value {
@@ -116,6 +149,9 @@ func directiveNoAutoescape(value data.Value, _ []data.Value) data.Value {
 }
 
 func directiveEscapeHtml(value data.Value, _ []data.Value) data.Value {
+	if sc, ok := value.(data.SanitizedContent); ok && sc.Kind == data.KindHTML {
+		return value
+	}
 	return data.String(template.HTMLEscapeString(value.String()))
 }
 
@@ -123,14 +159,116 @@ func directiveEscapeUri(value data.Value, _ []data.Value) data.Value {
 	return data.String(url.QueryEscape(value.String()))
 }
 
+// directiveEncodeUriComponent percent-encodes the value as javascript's
+// encodeURIComponent does, for use as a URI component, e.g. a query parameter
+// or path segment.  The result is URI content, so it is not escaped again
+// within URIs in strict and contextual templates.
+func directiveEncodeUriComponent(value data.Value, _ []data.Value) data.Value {
+	var str = value.String()
+	var buf bytes.Buffer
+	for i := 0; i < len(str); i++ {
+		var ch = str[i]
+		switch {
+		case 'a' <= ch && ch <= 'z', 'A' <= ch && ch <= 'Z', '0' <= ch && ch <= '9',
+			strings.IndexByte("-_.!~*'()", ch) != -1:
+			buf.WriteByte(ch)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", ch)
+		}
+	}
+	return data.SanitizedContent{data.KindURI, buf.String()}
+}
+
+// directiveBase64Encode encodes the value in standard base64, e.g. for a data
+// URI.  Within URIs in strict and contextual templates, the "+", "/", and "="
+// characters it may produce are percent-encoded.
+func directiveBase64Encode(value data.Value, _ []data.Value) data.Value {
+	return data.String(base64.StdEncoding.EncodeToString([]byte(value.String())))
+}
+
+// directiveBase64Decode decodes the value from standard or URL-safe base64,
+// with or without padding.  The decoded text is escaped as usual.
+func directiveBase64Decode(value data.Value, _ []data.Value) data.Value {
+	var str = strings.TrimRight(value.String(), "=")
+	var encoding = base64.RawStdEncoding
+	if strings.ContainsAny(str, "-_") {
+		encoding = base64.RawURLEncoding
+	}
+	var decoded, err = encoding.DecodeString(str)
+	if err != nil {
+		panic(fmt.Errorf("base64Decode: %v", err))
+	}
+	return data.String(decoded)
+}
+
+// innocuousURI replaces URIs that fail filtering.
+const innocuousURI = "about:invalid#zSoyz"
+
+// safeURIPattern matches URIs with a scheme that is safe to navigate to, or
+// relative URIs.
+var safeURIPattern = regexp.MustCompile(`(?i)^(?:(?:https?|mailto):|[^&:/?#]*(?:[/?#]|$))`)
+
+// directiveFilterNormalizeUri replaces URIs with unsafe schemes (e.g.
+// javascript:) and escapes characters that may not appear in a URI.  URIs
+// known to be safe (see data.SafeURL) are only normalized.
+func directiveFilterNormalizeUri(value data.Value, _ []data.Value) data.Value {
+	var str = value.String()
+	if sc, ok := value.(data.SanitizedContent); !ok ||
+		sc.Kind != data.KindURI && sc.Kind != data.KindTrustedResourceURI {
+		if !safeURIPattern.MatchString(str) {
+			return data.String(innocuousURI)
+		}
+	}
+	return data.String(normalizeURI(str))
+}
+
+// directiveFilterTrustedResourceUri allows only URIs known to refer to
+// resources that may be loaded as code (see data.TrustedResourceURL), e.g. in
+// a script src.  Anything else is replaced.
+func directiveFilterTrustedResourceUri(value data.Value, _ []data.Value) data.Value {
+	if sc, ok := value.(data.SanitizedContent); ok && sc.Kind == data.KindTrustedResourceURI {
+		return data.String(sc.Content)
+	}
+	return data.String(innocuousURI)
+}
+
+// normalizeURI percent-encodes the characters that are not allowed in URIs or
+// that could terminate an attribute or string containing one.
+func normalizeURI(str string) string {
+	var buf bytes.Buffer
+	for _, ch := range str {
+		switch {
+		case ch <= ' ', ch == '"', ch == '\'', ch == '(', ch == ')', ch == '<', ch == '>',
+			ch == '\\', ch == '{', ch == '}', ch == 0x7f, ch == 0x85, ch == 0xa0,
+			ch == 0x2028, ch == 0x2029:
+			for _, b := range []byte(string(ch)) {
+				fmt.Fprintf(&buf, "%%%02X", b)
+			}
+		default:
+			buf.WriteRune(ch)
+		}
+	}
+	return buf.String()
+}
+
+// directiveEscapeJsString escapes the value for use within a JS string
+// literal.  The result is not a JS expression, so it is printed as is only
+// within a string literal when escaping for the context.
 func directiveEscapeJsString(value data.Value, _ []data.Value) data.Value {
-	return data.String(template.JSEscapeString(value.String()))
+	return data.SanitizedContent{data.KindJSStrChars, template.JSEscapeString(value.String())}
 }
 
 func directiveJson(value data.Value, _ []data.Value) data.Value {
+	return toJSON(value)
+}
+
+// toJSON encodes the value as JSON, which may be embedded in a <script>
+// block: "<", ">", "&", U+2028, and U+2029 are escaped, so it can not end
+// the script or open a comment.
+func toJSON(value data.Value) data.SanitizedContent {
 	j, err := json.Marshal(value)
 	if err != nil {
 		panic(fmt.Errorf("Error JSON encoding value: %v", err))
 	}
-	return data.String(j)
+	return data.SanitizedContent{data.KindJS, string(j)}
 }
@@ -0,0 +1,90 @@
+package soyhtml
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// RenderError is returned when a template fails to render.  It includes the
+// source of the failing template, so that it may be displayed without access
+// to the original files, and the {call}s that led to it.
+type RenderError struct {
+	Template   string       // fully-qualified name of the failing template
+	File       string       // name of the soy file declaring the template, if known
+	Line       int          // line number of the failure
+	Col        int          // column number of the failure, or 0 if unknown
+	Message    string       // description of the failure
+	Source     string       // source of the failing template, if available
+	SourceLine int          // line number on which Source begins
+	Stack      []StackFrame // the {call}s leading to the failing template, innermost first
+}
+
+// StackFrame is the location of a {call} (or {delcall}) within a template.
+type StackFrame struct {
+	Template string // fully-qualified name of the calling template
+	File     string // name of the soy file declaring the template, if known
+	Line     int
+	Col      int
+}
+
+func (f StackFrame) String() string {
+	return f.Template + " (" + location(f.File, f.Line, f.Col) + ")"
+}
+
+// location returns the given position as "file:line:col", or "line
+// line:col" if the file is not known.
+func location(file string, line, col int) string {
+	if file == "" {
+		return fmt.Sprintf("line %d:%d", line, col)
+	}
+	return fmt.Sprintf("%s:%d:%d", file, line, col)
+}
+
+// Error describes the failure with its location and the innermost {call}
+// leading to it, e.g.
+//
+//	template test.item:12: message (items.soy:12:9, called from test.list (items.soy:5:5))
+//
+// See StackTrace for the full stack.
+func (e *RenderError) Error() string {
+	var loc = location(e.File, e.Line, e.Col)
+	if len(e.Stack) > 0 {
+		loc += ", called from " + e.Stack[0].String()
+	}
+	return fmt.Sprintf("template %s:%d: %s (%s)", e.Template, e.Line, e.Message, loc)
+}
+
+// StackTrace returns the failing template and the templates that called it,
+// one per line, innermost first, with their locations, e.g.
+//
+//	test.item (items.soy:12:9)
+//	  called from test.list (items.soy:5:5)
+//	  called from test.page (page.soy:20:3)
+func (e *RenderError) StackTrace() string {
+	var buf bytes.Buffer
+	buf.WriteString(StackFrame{e.Template, e.File, e.Line, e.Col}.String() + "\n")
+	for _, frame := range e.Stack {
+		buf.WriteString("  called from " + frame.String() + "\n")
+	}
+	return buf.String()
+}
+
+// SourceExcerpt returns the template's source with line numbers, marking the
+// line on which the failure occurred.  It returns "" if the source is not
+// available.
+func (e *RenderError) SourceExcerpt() string {
+	if e.Source == "" {
+		return ""
+	}
+	var buf bytes.Buffer
+	for i, line := range strings.Split(e.Source, "\n") {
+		var num = e.SourceLine + i
+		var marker = "  "
+		if num == e.Line {
+			marker = "> "
+		}
+		fmt.Fprintf(&buf, "%s%4d  %s\n", marker, num, line)
+	}
+	return buf.String()
+}
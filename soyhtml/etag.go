@@ -0,0 +1,43 @@
+package soyhtml
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/harrisonzhao/soy/data"
+)
+
+// ExecuteETag is like Execute, but it additionally returns a strong ETag for
+// the output, e.g. `"Yt3v..."`, computed from the bytes written to wr as they
+// are written (after any output filters), so the output need not be hashed
+// in a second pass.  Since the ETag header must precede the body, wr is
+// typically a buffer, which is written to the response only if the request
+// does not already have the content (see ETagMatches).  The ETag is empty if
+// the render fails.
+func (t Renderer) ExecuteETag(wr io.Writer, obj data.Map) (string, error) {
+	var h = sha256.New()
+	if err := t.execute(io.MultiWriter(wr, h), obj, nil); err != nil {
+		return "", err
+	}
+	return `"` + base64.RawURLEncoding.EncodeToString(h.Sum(nil)) + `"`, nil
+}
+
+// ETagMatches returns true if the If-None-Match header of the request matches
+// the given ETag, in which case the handler may respond with 304 Not Modified
+// rather than writing the output.
+func ETagMatches(req *http.Request, etag string) bool {
+	var header = req.Header.Get("If-None-Match")
+	if header == "" || etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
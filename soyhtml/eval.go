@@ -1,6 +1,7 @@
 package soyhtml
 
 import (
+	"bytes"
 	"io/ioutil"
 
 	"github.com/harrisonzhao/soy/ast"
@@ -18,3 +19,17 @@ func EvalExpr(node ast.Node) (val data.Value, err error) {
 	state.walk(node)
 	return state.val, nil
 }
+
+// RenderPrint renders the given print command as it would be rendered within
+// a template of the given autoescape mode, which must not be contextual or
+// strict, and returns the output.  Its expressions must not refer to data.
+//
+// This is useful for rendering print commands whose values are constant
+// before the template is rendered (see parsepasses.PrerenderConstants).
+func RenderPrint(node *ast.PrintNode, autoescape ast.AutoescapeType) (text string, err error) {
+	var buf bytes.Buffer
+	state := &state{wr: &buf, autoescape: autoescape}
+	defer state.errRecover(&err)
+	state.evalPrint(node)
+	return buf.String(), nil
+}
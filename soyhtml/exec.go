@@ -2,15 +2,20 @@ package soyhtml
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"runtime"
 	"runtime/debug"
+	"strconv"
 
 	"github.com/harrisonzhao/soy/ast"
 	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/soymsg"
 	soyt "github.com/harrisonzhao/soy/template"
+	"golang.org/x/text/feature/plural"
+	"golang.org/x/text/language"
 )
 
 // Logger collects output from {log} commands.
@@ -27,6 +32,45 @@ type state struct {
 	context    scope              // variable scope
 	autoescape ast.AutoescapeType // escaping mode
 	ij         data.Map           // injected data available to all templates.
+	result     *RenderResult      // render summary to populate, or nil
+	ctx        context.Context    // context of the render
+	locale     string             // locale of the render, if any
+	trace      *Trace             // records expression evaluations, or nil
+	usage      *renderUsage       // records the templates and branches rendered, or nil
+	debug      bool               // true if debugging functions are enabled
+	cache      *RenderCache       // output of cacheable templates, or nil
+	flags      FlagProvider       // feature flags, or nil
+	urls       URLMapper          // maps URLs to their localized variants, or nil
+	markdown   MarkdownRenderer   // renders the |markdown directive, or nil
+	collator   Collator           // compares strings for sort(), or nil
+	messages   soymsg.Provider    // translated messages, or nil
+	stack      *[]string          // names of the templates being rendered, shared with callees
+	maxDepth   int                // maximum length of the stack, if positive
+	maxOutput  int64              // maximum bytes of output and of content blocks, if positive
+	concurrent bool               // true to render sibling calls concurrently
+	metrics    Instrumenter       // notified as templates are rendered, or nil
+	written    *int64             // bytes rendered, if instrumented
+	auditor    UnsafeAuditor      // notified as unsafe content is printed, or nil
+	caller     *callSite          // the call rendering the template, or nil
+	budgets    *Budgets           // time budgets of calls, or nil
+	budget     *callBudget        // budget of the innermost budgeted call being rendered, or nil
+	nonce      string             // CSP nonce to add to script and style tags, or ""
+	resolver   CallResolver       // chooses the templates rendered by calls, or nil
+	delpkgs    []string           // active delegate packages
+	simulated  bool               // true if the render is simulated (see Renderer.Simulate)
+	diags      *[]Diagnostic      // problems found by a simulated render, or nil
+	section    string             // name of the {let} to render alone, or ""
+	sectionWr  io.Writer          // output of the section, when rendering one
+	contexts   *contextCache      // print contexts of contextual templates
+	escapes    printContexts      // print contexts of the current template, if contextual
+	nonces     nonceOffsets       // where the nonce is added to the current template's raw text
+}
+
+// callSite is a {call} being rendered, for reporting the stack of an error.
+type callSite struct {
+	tmpl   soyt.Template // the calling template
+	node   *ast.CallNode
+	caller *callSite // the call rendering the calling template, or nil
 }
 
 // at marks the state to be on node n, for error reporting.
@@ -34,11 +78,20 @@ func (s *state) at(node ast.Node) {
 	s.node = node
 }
 
+// branch records that the branch of the given kind, beginning at the given
+// node, was taken.
+func (s *state) branch(node ast.Node, kind string) {
+	if s.trace != nil {
+		s.trace.branch(s, node, kind)
+	}
+	if s.usage != nil {
+		s.usage.branch(s.tmpl.Node, node, kind)
+	}
+}
+
 // errorf formats the error and terminates processing.
 func (s *state) errorf(format string, args ...interface{}) {
-	format = fmt.Sprintf("template %s:%d: %s", s.tmpl.Node.Name,
-		s.registry.LineNumber(s.tmpl.Node.Name, s.node), format)
-	panic(fmt.Errorf(format, args...))
+	panic(s.renderError(fmt.Sprintf(format, args...)))
 }
 
 // errRecover is the handler that turns panics into returns from the top
@@ -47,46 +100,130 @@ func (s *state) errRecover(errp *error) {
 	if e := recover(); e != nil {
 		switch e := e.(type) {
 		case runtime.Error:
-			*errp = fmt.Errorf("template %s:%d: %v\n%v", s.tmpl.Node.Name,
-				s.registry.LineNumber(s.tmpl.Node.Name, s.node), e, string(debug.Stack()))
+			*errp = s.renderError(fmt.Sprintf("%v\n%v", e, string(debug.Stack())))
 		case error:
 			*errp = e
 		default:
-			*errp = fmt.Errorf("template %s:%d: %v", s.tmpl.Node.Name,
-				s.registry.LineNumber(s.tmpl.Node.Name, s.node), e)
+			*errp = s.renderError(fmt.Sprint(e))
 		}
 	}
 }
 
+// renderError returns an error with the given message, located at the
+// current node, along with the calls being rendered.
+func (s *state) renderError(msg string) *RenderError {
+	if s.tmpl.Node == nil {
+		// An expression is evaluated outside of any template (see EvalExpr).
+		return &RenderError{Message: msg}
+	}
+	var id = s.tmpl.Node.ID()
+	var source, line, _ = s.registry.TemplateSource(id)
+	var err = &RenderError{
+		Template:   s.tmpl.Node.Name,
+		File:       s.registry.FileName(s.tmpl.Node),
+		Line:       s.registry.LineNumber(id, s.node),
+		Col:        s.registry.ColumnNumber(id, s.node),
+		Message:    msg,
+		Source:     source,
+		SourceLine: line,
+	}
+	for call := s.caller; call != nil; call = call.caller {
+		var callerID = call.tmpl.Node.ID()
+		err.Stack = append(err.Stack, StackFrame{
+			Template: call.tmpl.Node.Name,
+			File:     s.registry.FileName(call.tmpl.Node),
+			Line:     s.registry.LineNumber(callerID, call.node),
+			Col:      s.registry.ColumnNumber(callerID, call.node),
+		})
+	}
+	return err
+}
+
 // walk recursively goes through each node and executes the indicated logic and
 // writes the output
 func (s *state) walk(node ast.Node) {
 	s.val = data.Undefined{}
 	s.at(node)
+	if s.budget != nil {
+		s.checkBudgets()
+	}
 	switch node := node.(type) {
 	case *ast.SoyFileNode:
 		for _, node := range node.Body {
 			s.walk(node)
 		}
 	case *ast.TemplateNode:
+		if s.result != nil {
+			s.result.TemplatesVisited = append(s.result.TemplatesVisited, node.Name)
+		}
 		if node.Autoescape != ast.AutoescapeUnspecified {
 			s.autoescape = node.Autoescape
 		}
+		s.escapes, s.nonces = nil, nil
+		if s.autoescape == ast.AutoescapeStrict {
+			s.trackContext(node.Kind)
+		}
+		if s.contexts != nil && (s.autoescape == ast.AutoescapeContextual || s.nonce != "") {
+			var inf = s.contexts.get(node)
+			if s.autoescape == ast.AutoescapeContextual {
+				if inf.err != nil {
+					s.at(inf.err.node)
+					s.errorf("%s", inf.err)
+				}
+				s.escapes = inf.prints
+			}
+			if s.nonce != "" {
+				s.nonces = inf.nonces
+			}
+		}
+		if node.Cacheable && s.cache != nil {
+			s.walkCached()
+			break
+		}
 		s.walk(node.Body)
 	case *ast.ListNode:
-		for _, node := range node.Nodes {
-			s.walk(node)
-		}
+		s.walkList(node.Nodes)
 
 		// Output nodes ----------
 	case *ast.PrintNode:
 		s.evalPrint(node)
 	case *ast.RawTextNode:
-		if _, err := s.wr.Write(node.Text); err != nil {
+		var text = node.Text
+		if offsets, ok := s.nonces[node]; ok {
+			text = insertNonce(text, offsets, s.nonce)
+		}
+		if _, err := s.wr.Write(text); err != nil {
 			s.errorf("%s", err)
 		}
 	case *ast.MsgNode:
+		if s.result != nil {
+			s.result.MessagesLookedUp++
+		}
+		if st, ok := s.msgContext(node); ok {
+			s.evalMsgValue(node, st)
+		} else {
+			s.evalMsg(node)
+		}
+	case *ast.MsgPlaceholderNode:
 		s.walk(node.Body)
+	case *ast.PluralNode:
+		var count = s.pluralCount(node)
+		for _, pluralCase := range node.Cases {
+			if pluralCase.Value == count {
+				s.walk(pluralCase.Body)
+				return
+			}
+		}
+		s.walk(node.Default)
+	case *ast.SelectNode:
+		var value = s.eval(node.Value).String()
+		for _, selectCase := range node.Cases {
+			if selectCase.Value == value {
+				s.walk(selectCase.Body)
+				return
+			}
+		}
+		s.walk(node.Default)
 	case *ast.CssNode:
 		var prefix = ""
 		if node.Expr != nil {
@@ -102,8 +239,9 @@ func (s *state) walk(node ast.Node) {
 
 		// Control flow ----------
 	case *ast.IfNode:
-		for _, cond := range node.Conds {
+		for i, cond := range node.Conds {
 			if cond.Cond == nil || s.eval(cond.Cond).Truthy() {
+				s.branch(cond, ifBranchKind(i, cond))
 				s.walk(cond.Body)
 				break
 			}
@@ -116,15 +254,20 @@ func (s *state) walk(node ast.Node) {
 		}
 		if len(list) == 0 {
 			if node.IfEmpty != nil {
+				s.branch(node, "ifempty")
 				s.walk(node.IfEmpty)
 			}
 			break
 		}
+		s.branch(node, "foreach")
 		s.context.push()
 		for i, item := range list {
 			s.context.set(node.Var, item)
 			s.context.set(node.Var+"__index", data.Int(i))
 			s.context.set(node.Var+"__lastIndex", data.Int(len(list)-1))
+			if node.IndexVar != "" {
+				s.context.set(node.IndexVar, data.Int(i))
+			}
 			s.walk(node.Body)
 		}
 		s.context.pop()
@@ -133,11 +276,13 @@ func (s *state) walk(node ast.Node) {
 		for _, caseNode := range node.Cases {
 			for _, caseValueNode := range caseNode.Values {
 				if switchValue.Equals(s.eval(caseValueNode)) {
+					s.branch(caseNode, "case")
 					s.walk(caseNode.Body)
 					return
 				}
 			}
 			if len(caseNode.Values) == 0 { // default/last case
+				s.branch(caseNode, "default")
 				s.walk(caseNode.Body)
 				return
 			}
@@ -147,7 +292,12 @@ func (s *state) walk(node ast.Node) {
 	case *ast.LetValueNode:
 		s.context.set(node.Name, s.eval(node.Expr))
 	case *ast.LetContentNode:
-		s.context.set(node.Name, data.String(s.renderBlock(node.Body)))
+		if s.section != "" && node.Name == s.section {
+			s.writeSection(node)
+		}
+		// The content is rendered once, here, and the rendered value is reused
+		// wherever the variable is printed or passed.
+		s.context.set(node.Name, s.renderContent(node.Kind, node.Body))
 
 		// Values ----------
 	case *ast.NullNode:
@@ -178,6 +328,9 @@ func (s *state) walk(node ast.Node) {
 		s.val = s.evalFunc(node)
 	case *ast.DataRefNode:
 		s.val = s.evalDataRef(node)
+		if s.simulated {
+			s.checkDefined(node, s.val)
+		}
 
 		// Arithmetic operators ----------
 	case *ast.NegateNode:
@@ -269,8 +422,30 @@ func isInt(v data.Value) bool {
 }
 
 func isString(v data.Value) bool {
-	_, ok := v.(data.String)
-	return ok
+	switch v.(type) {
+	case data.String, data.SanitizedContent:
+		return true
+	}
+	return false
+}
+
+// kindedContent returns the value of a rendered content block of the given
+// kind.  Blocks of a kind other than text are sanitized content.
+func kindedContent(kind data.ContentKind, content []byte) data.Value {
+	if kind == "" || kind == data.KindText {
+		return data.String(content)
+	}
+	return data.SanitizedContent{Kind: kind, Content: string(content)}
+}
+
+// isSafeInHtml returns true if the value may be printed into HTML text
+// without escaping.  Attributes content is only safe within a tag, which is
+// known to strict and contextual autoescaping alone, so it is escaped too.
+func isSafeInHtml(v data.Value) bool {
+	if sc, ok := v.(data.SanitizedContent); ok {
+		return sc.Kind == data.KindHTML
+	}
+	return false
 }
 
 func toFloat(v data.Value) float64 {
@@ -287,12 +462,12 @@ func toFloat(v data.Value) float64 {
 }
 
 func (s *state) evalPrint(node *ast.PrintNode) {
-	s.walk(node.Arg)
+	s.eval(node.Arg)
 	if _, ok := s.val.(data.Undefined); ok {
 		s.errorf("In 'print' tag, expression %q evaluates to undefined.", node.Arg.String())
 	}
 	var escapeHtml = s.autoescape != ast.AutoescapeOff
-	var result = s.val
+	var result = s.unwrapUnsafe(s.val)
 	for _, directiveNode := range node.Directives {
 		var directive, ok = PrintDirectives[directiveNode.Name]
 		if !ok {
@@ -317,15 +492,27 @@ func (s *state) evalPrint(node *ast.PrintNode) {
 						string(debug.Stack()))
 				}
 			}()
-			result = directive.Apply(result, args)
+			if directive.ApplyContext != nil {
+				result = directive.ApplyContext(s.funcContext(), result, args)
+			} else {
+				result = directive.Apply(result, args)
+			}
 		}()
-		if directive.CancelAutoescape {
+		// JS content is printed as-is in JS contexts, but is still escaped
+		// within attributes (and JS string characters outside of strings),
+		// so only contextual escaping handles it safely.
+		var js = contentOfKind(result, data.KindJS) || contentOfKind(result, data.KindJSStrChars)
+		if directive.CancelAutoescape && !(js && s.escapesContextually()) {
 			escapeHtml = false
 		}
 	}
 
 	var resultStr = result.String()
-	if escapeHtml {
+	if cw, ok := s.wr.(*contextWriter); ok && escapeHtml && s.autoescape == ast.AutoescapeStrict {
+		s.writeEscaped(cw.escState, result)
+	} else if st, ok := s.escapes[node]; ok && escapeHtml {
+		s.writeEscaped(st, result)
+	} else if escapeHtml && !isSafeInHtml(result) {
 		htmlEscapeString(s.wr, resultStr)
 	} else {
 		if _, err := io.WriteString(s.wr, resultStr); err != nil {
@@ -337,8 +524,26 @@ func (s *state) evalPrint(node *ast.PrintNode) {
 func (s *state) evalCall(node *ast.CallNode) {
 	// get template node we're calling
 	var calledTmpl, ok = s.registry.Template(node.Name)
-	if !ok {
+	if node.Delegate {
+		var variant string
+		if node.Variant != nil {
+			variant = s.evaldef(node.Variant).String()
+		}
+		var err error
+		calledTmpl, ok, err = s.registry.ActiveDelTemplate(node.Name, variant, s.delpkgs...)
+		if err != nil {
+			s.errorf("%s", err)
+		}
+		if !ok && node.AllowEmptyDefault {
+			return
+		}
+		if !ok {
+			s.errorf("failed to find delegate template: %s (variant %q)", node.Name, variant)
+		}
+	} else if !ok {
 		s.errorf("failed to find template: %s", node.Name)
+	} else if s.resolver != nil {
+		calledTmpl = s.resolveCall(calledTmpl)
 	}
 
 	// sort out the data to pass
@@ -363,23 +568,200 @@ func (s *state) evalCall(node *ast.CallNode) {
 		case *ast.CallParamValueNode:
 			callData.set(param.Key, s.eval(param.Value))
 		case *ast.CallParamContentNode:
-			callData.set(param.Key, data.New(string(s.renderBlock(param.Content))))
+			callData.set(param.Key, s.renderContent(param.Kind, param.Content))
 		default:
 			s.errorf("unexpected call param type: %T", param)
 		}
 	}
 
 	callData.enter()
-	state := &state{
-		tmpl:       calledTmpl,
-		registry:   s.registry,
-		namespace:  calledTmpl.Namespace.Name,
-		autoescape: calledTmpl.Namespace.Autoescape,
-		wr:         s.wr,
-		context:    callData,
-		ij:         s.ij,
+	var state = *s // the callee shares the render-wide settings
+	state.tmpl = calledTmpl
+	state.section = "" // sections are of the rendered template alone
+	state.namespace = calledTmpl.Namespace.Name
+	state.autoescape = calledTmpl.Namespace.Autoescape
+	state.context = callData
+	state.caller = &callSite{s.tmpl, node, s.caller}
+	// The callee is not popped if rendering fails, so that the stack may be
+	// reported as it was at the point of failure.
+	s.checkCallDepth(calledTmpl.Node.Name)
+	*s.stack = append(*s.stack, calledTmpl.Node.Name)
+	if s.usage != nil {
+		s.usage.template(calledTmpl.Node)
+	}
+	// In strict mode, a callee of a given kind renders content of its kind,
+	// which is printed like a value of that kind.
+	var cw, kinded = s.wr.(*contextWriter)
+	kinded = kinded && calledTmpl.Node.Kind != "" && s.autoescape == ast.AutoescapeStrict
+	var buf bytes.Buffer
+	if kinded {
+		state.wr = s.contentWriter(&buf)
+	}
+	if s.budgets != nil {
+		state.startBudget()
+	}
+	state.walkTemplate(s.tmpl.Node.Name)
+	if state.budget != nil {
+		state.checkBudgets()
+	}
+	*s.stack = (*s.stack)[:len(*s.stack)-1]
+	if kinded {
+		var content = kindedContent(calledTmpl.Node.Kind, buf.Bytes())
+		s.writeEscaped(cw.escState, content)
+	}
+}
+
+// evalMsg renders the given message, using its translation if available.  The
+// translation's placeholders are replaced by the content they stand for.
+func (s *state) evalMsg(node *ast.MsgNode) {
+	var msg *soymsg.Message
+	if s.messages != nil {
+		msg = s.messages.Message(node.ID)
+	}
+	if msg == nil {
+		s.walk(node.Body)
+		return
+	}
+	s.evalMsgParts(node, msg.Parts)
+}
+
+// msgContext returns the state of the output preceding the given message, if
+// it is escaped contextually and printed within a value.
+func (s *state) msgContext(node *ast.MsgNode) (escState, bool) {
+	if cw, ok := s.wr.(*contextWriter); ok && s.autoescape == ast.AutoescapeStrict {
+		return cw.clone(), cw.inValue()
 	}
-	state.walk(calledTmpl.Node)
+	var st, ok = s.escapes[node]
+	return st, ok
+}
+
+// evalMsgValue renders the given message as a single value printed in the
+// given state, e.g. within an attribute value.  Its text and placeholders are
+// rendered without escaping, and the message as a whole is escaped for the
+// context, so that neither the text of a translation nor the content of its
+// placeholders may change the context of the output.
+func (s *state) evalMsgValue(node *ast.MsgNode, st escState) {
+	var buf bytes.Buffer
+	var origWriter, origAutoescape, origEscapes = s.wr, s.autoescape, s.escapes
+	s.wr, s.autoescape, s.escapes = &buf, ast.AutoescapeOff, nil
+	s.evalMsg(node)
+	s.wr, s.autoescape, s.escapes = origWriter, origAutoescape, origEscapes
+	s.writeEscaped(st, data.String(buf.String()))
+}
+
+// writeEscaped writes the given value, escaped for the given state of the
+// output.
+func (s *state) writeEscaped(st escState, v data.Value) {
+	var str, err = st.escape(v)
+	if err != nil {
+		s.errorf("%s", err)
+	}
+	if _, err := io.WriteString(s.wr, str); err != nil {
+		s.errorf("%s", err)
+	}
+}
+
+// evalMsgParts renders the given parts of a translation of the given message.
+func (s *state) evalMsgParts(node *ast.MsgNode, parts []soymsg.Part) {
+	for _, part := range parts {
+		switch part := part.(type) {
+		case soymsg.RawTextPart:
+			if _, err := io.WriteString(s.wr, part.Text); err != nil {
+				s.errorf("%s", err)
+			}
+		case soymsg.PlaceholderPart:
+			var ph = soymsg.Placeholder(node, part.Name)
+			if ph == nil {
+				s.errorf("translation of message %d has unknown placeholder %q", node.ID, part.Name)
+			}
+			s.walk(ph.Body)
+		case soymsg.PluralPart:
+			var plural = soymsg.Plural(node, part.VarName)
+			if plural == nil {
+				s.errorf("translation of message %d has unknown plural %q", node.ID, part.VarName)
+			}
+			s.evalMsgParts(node, pluralCaseParts(part, s.pluralCount(plural), s.locale))
+		case soymsg.SelectPart:
+			var sel = soymsg.Select(node, part.VarName)
+			if sel == nil {
+				s.errorf("translation of message %d has unknown select %q", node.ID, part.VarName)
+			}
+			s.evalMsgParts(node, selectCaseParts(part, s.eval(sel.Value).String()))
+		}
+	}
+}
+
+// pluralCount evaluates the count of the given {plural}, which must be an
+// integer.
+func (s *state) pluralCount(node *ast.PluralNode) int64 {
+	var count, ok = s.eval(node.Value).(data.Int)
+	if !ok {
+		s.errorf("In plural %q, %q does not resolve to an int.",
+			node.String(), node.Value.String())
+	}
+	return int64(count)
+}
+
+// selectCaseParts returns the parts of the case of the given select for the
+// given value, or of its "other" case if there is none.
+func selectCaseParts(sel soymsg.SelectPart, value string) []soymsg.Part {
+	var other []soymsg.Part
+	for _, selectCase := range sel.Cases {
+		switch selectCase.Value {
+		case value:
+			return selectCase.Parts
+		case "other":
+			other = selectCase.Parts
+		}
+	}
+	return other
+}
+
+// pluralCaseParts returns the parts of the case of the given plural for the
+// given count: its "=N" case for the count if there is one, or else its case
+// for the count's CLDR plural category in the given locale (e.g. "few" for 3
+// in Polish), or else its "other" case.
+func pluralCaseParts(plural soymsg.PluralPart, count int64, locale string) []soymsg.Part {
+	var exact = "=" + strconv.FormatInt(count, 10)
+	var category = pluralCategory(count, locale)
+	var categoryParts, other []soymsg.Part
+	for _, pluralCase := range plural.Cases {
+		switch pluralCase.Spec {
+		case exact:
+			return pluralCase.Parts
+		case category:
+			categoryParts = pluralCase.Parts
+		case "other":
+			other = pluralCase.Parts
+		}
+	}
+	if categoryParts != nil {
+		return categoryParts
+	}
+	return other
+}
+
+// pluralCategories are the names of the CLDR plural categories.
+var pluralCategories = map[plural.Form]string{
+	plural.Other: "other",
+	plural.Zero:  "zero",
+	plural.One:   "one",
+	plural.Two:   "two",
+	plural.Few:   "few",
+	plural.Many:  "many",
+}
+
+// pluralCategory returns the CLDR plural category of the given count in the
+// given locale, e.g. "one", or "other" if the locale is unknown.
+func pluralCategory(count int64, locale string) string {
+	var tag, err = language.Parse(locale)
+	if err != nil {
+		return "other"
+	}
+	if count < 0 {
+		count = -count
+	}
+	return pluralCategories[plural.Cardinal.MatchPlural(tag, int(count), 0, 0, 0, 0)]
 }
 
 // renderBlock is a helper that renders the given node to a temporary output
@@ -387,12 +769,48 @@ func (s *state) evalCall(node *ast.CallNode) {
 func (s *state) renderBlock(node ast.Node) []byte {
 	var buf bytes.Buffer
 	origWriter := s.wr
-	s.wr = &buf
+	s.wr = s.contentWriter(&buf)
+	if cw, ok := origWriter.(*contextWriter); ok {
+		s.wr = cw.fork(s.wr)
+	}
 	s.walk(node)
 	s.wr = origWriter
 	return buf.Bytes()
 }
 
+// renderContent renders the given content block of the given kind to a value.
+// In strict autoescaping mode, the content is escaped for its kind, which
+// defaults to HTML.
+func (s *state) renderContent(kind data.ContentKind, node ast.Node) data.Value {
+	if s.autoescape != ast.AutoescapeStrict {
+		return kindedContent(kind, s.renderBlock(node))
+	}
+	if kind == "" {
+		kind = data.KindHTML
+	}
+	var buf bytes.Buffer
+	origWriter := s.wr
+	s.wr = newContextWriter(s.contentWriter(&buf), kind)
+	s.walk(node)
+	s.wr = origWriter
+	return kindedContent(kind, buf.Bytes())
+}
+
+// escapesContextually returns true if prints are escaped for their context,
+// in strict or contextual autoescaping mode.
+func (s *state) escapesContextually() bool {
+	return s.autoescape == ast.AutoescapeStrict || s.escapes != nil
+}
+
+// trackContext begins tracking the context of the output for strict
+// autoescaping, as content of the given kind (by default, HTML), unless it is
+// already tracked.
+func (s *state) trackContext(kind data.ContentKind) {
+	if _, ok := s.wr.(*contextWriter); !ok {
+		s.wr = newContextWriter(s.wr, kind)
+	}
+}
+
 func checkNumArgs(allowedNumArgs []int, numArgs int) bool {
 	for _, length := range allowedNumArgs {
 		if numArgs == length {
@@ -404,7 +822,23 @@ func checkNumArgs(allowedNumArgs []int, numArgs int) bool {
 
 func (s *state) evalFunc(node *ast.FunctionNode) data.Value {
 	if fn, ok := loopFuncs[node.Name]; ok {
-		return fn(s, node.Args[0].(*ast.DataRefNode).Key)
+		if len(node.Args) != 1 {
+			s.errorf("Function %q called with %v args, expected: [1]", node.Name, len(node.Args))
+		}
+		var ref, isRef = node.Args[0].(*ast.DataRefNode)
+		if !isRef || s.context.lookup(ref.Key+"__index") == (data.Undefined{}) {
+			s.errorf("Function %q must be called on a loop variable: %v", node.Name, node)
+		}
+		return fn(s, ref.Key)
+	}
+	if fn, ok := debugFuncs[node.Name]; ok {
+		if !s.debug {
+			s.errorf("Function %q is only available in debug mode", node.Name)
+		}
+		if len(node.Args) != 0 {
+			s.errorf("Function %q called with %v args, expected: [0]", node.Name, len(node.Args))
+		}
+		return fn(s)
 	}
 	if fn, ok := Funcs[node.Name]; ok {
 		if !checkNumArgs(fn.ValidArgLengths, len(node.Args)) {
@@ -421,7 +855,12 @@ func (s *state) evalFunc(node *ast.FunctionNode) data.Value {
 				s.errorf("panic in %s(%v): %v\n%v", node.Name, args, err, string(debug.Stack()))
 			}
 		}()
-		r := fn.Apply(args)
+		var r data.Value
+		if fn.ApplyContext != nil {
+			r = fn.ApplyContext(s.funcContext(), args)
+		} else {
+			r = fn.Apply(args)
+		}
 		if r == nil {
 			return data.Null{}
 		}
@@ -431,6 +870,24 @@ func (s *state) evalFunc(node *ast.FunctionNode) data.Value {
 	panic("unreachable")
 }
 
+// funcContext returns the description of the render provided to functions.
+func (s *state) funcContext() FuncContext {
+	var ctx = s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return FuncContext{
+		Context:  ctx,
+		Locale:   s.locale,
+		IJ:       s.ij,
+		Template: s.tmpl.Node.Name,
+		Flags:    s.flags,
+		URLs:     s.urls,
+		Markdown: s.markdown,
+		Collator: s.collator,
+	}
+}
+
 func (s *state) evalDataRef(node *ast.DataRefNode) data.Value {
 	// get the initial value
 	var ref data.Value
@@ -440,7 +897,7 @@ func (s *state) evalDataRef(node *ast.DataRefNode) data.Value {
 		}
 		ref = s.ij
 	} else {
-		ref = s.context.lookup(node.Key)
+		ref = s.resolve(s.context.lookup(node.Key))
 	}
 	if len(node.Access) == 0 {
 		return ref
@@ -482,13 +939,13 @@ func (s *state) evalDataRef(node *ast.DataRefNode) data.Value {
 				s.errorf("%q is a list, but was accessed with a non-integer index",
 					(&ast.DataRefNode{node.Pos, node.Key, node.Access[:i]}).String())
 			}
-			ref = obj.Index(index)
+			ref = s.resolve(obj.Index(index))
 		case data.Map:
 			if key == "" {
 				s.errorf("%q is a map, and requires a string key to access",
 					(&ast.DataRefNode{node.Pos, node.Key, node.Access[:i]}).String())
 			}
-			ref = obj.Key(key)
+			ref = s.resolve(obj.Key(key))
 		default:
 			s.errorf("While evaluating \"%v\", encountered non-collection"+
 				" just before accessing \"%v\".", node, accessNode)
@@ -498,6 +955,20 @@ func (s *state) evalDataRef(node *ast.DataRefNode) data.Value {
 	return ref
 }
 
+// resolve returns the value of the given data, waiting for it to be computed
+// if it is Lazy.
+func (s *state) resolve(val data.Value) data.Value {
+	var lazy, ok = val.(*data.Lazy)
+	if !ok {
+		return val
+	}
+	var result, err = lazy.Resolve()
+	if err != nil {
+		s.errorf("%v", err)
+	}
+	return result
+}
+
 // isNullSafeAccess returns true if the data ref access node is a nullsafe
 // access.
 func isNullSafeAccess(n ast.Node) bool {
@@ -520,8 +991,14 @@ func (s *state) eval2def(n1, n2 ast.Node) (data.Value, data.Value) {
 
 func (s *state) eval(n ast.Node) data.Value {
 	var prev = s.node
+	if s.trace != nil {
+		s.trace.begin()
+	}
 	s.walk(n)
 	s.node = prev
+	if s.trace != nil {
+		s.trace.end(s, n, s.val)
+	}
 	return s.val
 }
 
@@ -2,14 +2,26 @@ package soyhtml
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/harrisonzhao/soy/ast"
 	"github.com/harrisonzhao/soy/data"
 	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/soymsg"
 	"github.com/harrisonzhao/soy/template"
 )
 
@@ -266,6 +278,32 @@ func TestCall(t *testing.T) {
 	})
 }
 
+func TestCallChildren(t *testing.T) {
+	runExecTests(t, []execTest{
+		{"children", "test.page", `{namespace test}
+
+/** @param name */
+{template .page}
+{call .layout}
+  {param title: 'Hi' /}
+  <p>Hello {$name}</p>
+{/call}
+{/template}
+
+/**
+ * @param title
+ * @param children
+ */
+{template .layout}
+<h1>{$title}</h1><main>{$children}</main>
+{/template}`,
+			"<h1>Hi</h1><main><p>Hello &lt;b&gt;</p></main>",
+			d{"name": "<b>"},
+			true,
+		},
+	})
+}
+
 func TestDataRefs(t *testing.T) {
 	runExecTests(t, []execTest{
 		// single key
@@ -385,6 +423,21 @@ func TestPrintDirectives(t *testing.T) {
 		// TODO: test it escapes kind=HTML content
 		// TODO: test it does not escape kind=URI content
 
+		exprtest("encodeUriComponent", "{'a b&c/d?é(!)'|encodeUriComponent}", "a%20b%26c%2Fd%3F%C3%A9(!)"),
+		exprtest("base64Encode", "{'héllo?>'|base64Encode}", "aMOpbGxvPz4="),
+		exprtest("base64Decode", "{'PGI+aGk8L2I+'|base64Decode}", "&lt;b&gt;hi&lt;/b&gt;"),
+		exprtest("base64Decode url", "{'PGI-aGk8L2I-'|base64Decode}", "&lt;b&gt;hi&lt;/b&gt;"),
+		{"base64Decode invalid", "test.main", "{namespace test}{template .main}{'!'|base64Decode}{/template}", "", nil, false},
+
+		exprtest("filterNormalizeUri1", "{'/a b?c=<d>'|filterNormalizeUri}", "/a%20b?c=%3Cd%3E"),
+		exprtest("filterNormalizeUri2", "{'javascript:alert(1)'|filterNormalizeUri}", "about:invalid#zSoyz"),
+		exprtestwdata("filterNormalizeUri3", "{$url|filterNormalizeUri}", "tel:555",
+			d{"url": data.SafeURL("tel:555")}),
+		exprtestwdata("filterTrustedResourceUri1", "{$url|filterTrustedResourceUri}", "about:invalid#zSoyz",
+			d{"url": data.SafeURL("https://example.com/a.js")}),
+		exprtestwdata("filterTrustedResourceUri2", "{$url|filterTrustedResourceUri}", "https://example.com/a.js?a=1&amp;b=2",
+			d{"url": data.TrustedResourceURL("https://example.com/a.js?a=1&b=2")}),
+
 		exprtestwdata("ejs1", "{$var|escapeJsString}", ``, d{"var": ""}),
 		exprtestwdata("ejs2", "{$var|escapeJsString}", `foo`, d{"var": "foo"}),
 		exprtestwdata("ejs3", "{$var|escapeJsString}", `foo\\bar`, d{"var": "foo\\bar"}),
@@ -426,6 +479,15 @@ func TestInjectedData(t *testing.T) {
 	})
 }
 
+func TestFlagEnabledFromIJ(t *testing.T) {
+	ij["flags"] = data.Map{"newHeader": data.Bool(true), "oldFooter": data.Bool(false)}
+	defer delete(ij, "flags")
+	runExecTests(t, []execTest{
+		exprtest("flags", `{if flagEnabled('newHeader')}new{/if} {flagEnabled('oldFooter')} {flagEnabled('other')}`,
+			`new false false`),
+	})
+}
+
 func TestAutoescapeModes(t *testing.T) {
 	runExecTests(t, []execTest{
 		{"template autoescape=false", "test.autoescapeoff", `{namespace test}
@@ -911,3 +973,2351 @@ func runNsExecTests(t *testing.T, tests []nsExecTest) {
 		}
 	}
 }
+
+func TestRenderResult(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+{template .outer}
+  {msg desc="greeting"}Hello{/msg} {call .inner/}
+{/template}
+
+{template .inner}
+  world!
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var buf bytes.Buffer
+	result, err := NewTofu(&registry).RenderResult(&buf, "test.outer", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = RenderResult{
+		BytesWritten:     int64(len("Hello world!")),
+		TemplatesVisited: []string{"test.outer", "test.inner"},
+		MessagesLookedUp: 1,
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("expected %#v, got %#v", expected, result)
+	}
+	if buf.String() != "Hello world!" {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+/** @param n */
+{template .trace}
+{if $n > 5 and $n < 10}big{else}small{/if}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var trace = NewTrace(100)
+	err = NewTofu(&registry).NewRenderer("test.trace").
+		Trace(trace).
+		Execute(new(bytes.Buffer), data.Map{"n": data.Int(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = `test.trace:4: $n => 3
+test.trace:4: $n>5 => false (inputs: 3 5)
+test.trace:4: $n>5and$n<10 => false (inputs: false)
+`
+	if trace.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, trace.String())
+	}
+	if len(trace.Branches) != 1 || trace.Branches[0].String() != "test.trace:4: else" {
+		t.Errorf("expected the else branch, got %v", trace.Branches)
+	}
+
+	trace = NewTrace(1)
+	NewTofu(&registry).NewRenderer("test.trace").
+		Trace(trace).
+		Execute(new(bytes.Buffer), data.Map{"n": data.Int(3)})
+	if len(trace.Events) != 1 || !trace.Truncated {
+		t.Errorf("expected a truncated trace of 1 event, got %v", trace)
+	}
+}
+
+func TestKindedLet(t *testing.T) {
+	runExecTests(t, []execTest{
+		{"let kind attributes", "test.strict", `{namespace test autoescape="strict"}
+{template .strict}
+{let $attrs kind="attributes"}
+  {if $checked}checked {/if}title="{$title}"
+{/let}
+<input {$attrs}>
+{/template}`, `<input checked title="&lt;b&gt;">`, d{"checked": true, "title": "<b>"}, true},
+
+		exprtestwdata("let kind uri", `
+{let $href kind="uri"}/search?q={$q|escapeUri}&amp;s=1{/let}
+<a href="{$href}">`, `<a href="/search?q=a%26b&amp;amp;s=1">`, d{"q": "a&b"}),
+
+		exprtest("let kind html", `{let $b kind="html"}<b>hi</b>{/let}{$b} {$b|escapeHtml}`, `<b>hi</b> <b>hi</b>`),
+		exprtest("let kind text", `{let $b kind="text"}<b>hi</b>{/let}{$b}`, `&lt;b&gt;hi&lt;/b&gt;`),
+		exprtest("let kind concat", `{let $b kind="html"}<b>hi</b>{/let}{$b + '!'}`, `&lt;b&gt;hi&lt;/b&gt;!`),
+	})
+}
+
+// Let content blocks are rendered once, when declared, however many times
+// they are printed or passed, so lets containing expensive calls are cheap to
+// reuse.
+func TestLetContentRenderedOnce(t *testing.T) {
+	var renders int
+	Funcs["expensive"] = Func{func([]data.Value) data.Value {
+		renders++
+		return data.String("x")
+	}, []int{0}, nil}
+	defer delete(Funcs, "expensive")
+
+	runNsExecTests(t, []nsExecTest{
+		{"let content rendered once", "test.main", []string{`{namespace test}
+{template .main}
+  {let $x kind="html"}{call .expensive /}{/let}
+  {$x}{$x}
+  {call .show}{param v: $x /}{/call}
+  {call .show}{param v: $x /}{/call}
+{/template}
+
+{template .expensive}
+  <b>{expensive()}</b>
+{/template}
+
+/** @param v */
+{template .show}
+  {$v}
+{/template}`}, "<b>x</b><b>x</b><b>x</b><b>x</b>", nil, true},
+	})
+	if renders != 1 {
+		t.Errorf("expected the let content to be rendered once, got %d", renders)
+	}
+}
+
+func TestKindedParam(t *testing.T) {
+	runExecTests(t, []execTest{
+		{"param kinds", "test.main", `{namespace test}
+
+{template .main}
+{call .show}
+  {param html kind="html"}<b>{$name}</b>{/param}
+  {param text kind="text"}<i>hi</i>{/param}
+  {param plain}<u>hi</u>{/param}
+{/call}
+{/template}
+
+{template .show}
+{$html} {$text} {$plain}
+{/template}`,
+			"<b>&lt;x&gt;</b> &lt;i&gt;hi&lt;/i&gt; &lt;u&gt;hi&lt;/u&gt;",
+			d{"name": "<x>"},
+			true,
+		},
+	})
+}
+
+func TestForIndexVar(t *testing.T) {
+	runExecTests(t, []execTest{
+		exprtestwdata("foreach index var",
+			`{for $x, $i in $list}{$i}:{$x}{if not isLast($x)}, {/if}{/for}`,
+			`0:a, 1:b, 2:c`, d{"list": []string{"a", "b", "c"}}),
+		exprtestwdata("nested loop funcs",
+			`{foreach $x in $list}{foreach $y in $list}{index($x)}{index($y)} {/foreach}{/foreach}`,
+			`00 01 10 11 `, d{"list": []int{1, 2}}),
+		exprtest("range index var",
+			`{for $n, $i in range(10, 16, 2)}{$i}={$n} {/for}`,
+			`0=10 1=12 2=14 `),
+		{"loop func on non-loop var", "test.loopFunc",
+			"{namespace test}{template .loopFunc}{index($list)}{/template}",
+			"",
+			d{"list": []int{1}}, false},
+	})
+}
+
+func TestRenderCache(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param n */
+{template .outer}
+  {call .inner}{param n: $n /}{/call} {call .forever/}
+{/template}
+
+/** @param n */
+{template .inner cacheable="true" ttl="1m"}
+  n={$n}
+{/template}
+
+/** Never expires. */
+{template .forever cacheable="true"}
+  forever
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var now = time.Unix(0, 0)
+	var cache = NewRenderCache()
+	cache.now = func() time.Time { return now }
+	var tofu = NewTofu(&registry).Cache(cache)
+
+	var tests = []struct {
+		n         int
+		advance   time.Duration
+		cacheHits int
+	}{
+		{1, 0, 0},
+		{1, 0, 2},
+		{2, 0, 1},
+		{1, 30 * time.Second, 2},
+		{1, 31 * time.Second, 1},
+	}
+	for i, test := range tests {
+		now = now.Add(test.advance)
+		var buf bytes.Buffer
+		result, err := tofu.RenderResult(&buf, "test.outer", data.Map{"n": data.Int(test.n)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected = fmt.Sprintf("n=%d forever", test.n)
+		if buf.String() != expected {
+			t.Errorf("%d: expected %q, got %q", i, expected, buf.String())
+		}
+		if result.CacheHits != test.cacheHits {
+			t.Errorf("%d: expected %d cache hits, got %d", i, test.cacheHits, result.CacheHits)
+		}
+	}
+}
+
+func TestRenderCacheBound(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param x */
+{template .cached cacheable="true"}
+  {$x}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var cache = NewRenderCache().MaxEntries(2)
+	var tofu = NewTofu(&registry).Cache(cache)
+	var tests = []struct {
+		x        data.Value
+		output   string
+		cacheHit bool
+	}{
+		{data.String("<b>"), "&lt;b&gt;", false},
+		{data.SanitizedContent{data.KindHTML, "<b>"}, "<b>", false},
+		{data.String("<b>"), "&lt;b&gt;", true},
+		{data.SanitizedContent{data.KindHTML, "<b>"}, "<b>", true},
+		{data.String("<i>"), "&lt;i&gt;", false},
+		{data.String("<b>"), "&lt;b&gt;", false}, // evicted by <i>
+		{data.String("<i>"), "&lt;i&gt;", true},
+	}
+	for i, test := range tests {
+		var buf bytes.Buffer
+		result, err := tofu.RenderResult(&buf, "test.cached", data.Map{"x": test.x})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.output {
+			t.Errorf("%d: expected %q, got %q", i, test.output, buf.String())
+		}
+		if hit := result.CacheHits > 0; hit != test.cacheHit {
+			t.Errorf("%d: expected cache hit %v, got %v", i, test.cacheHit, hit)
+		}
+		if cache.Len() > 2 {
+			t.Errorf("%d: expected at most 2 entries, got %d", i, cache.Len())
+		}
+	}
+}
+
+type testMessages map[uint64]*soymsg.Message
+
+func (m testMessages) Message(id uint64) *soymsg.Message { return m[id] }
+
+func TestTranslatedMsg(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param name */
+{template .greet}
+  {msg desc="greeting"}Hello {$name}, see {call .link/}.{/msg}
+{/template}
+
+{template .link}
+  <a href="/help">help</a>
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var msg = tree.Body[2].(*ast.TemplateNode).Body.Nodes[0].(*ast.MsgNode)
+	var extracted = soymsg.Extract(msg)
+	if actual := extracted.PlaceholderString(); actual != "Hello {NAME}, see {XXX}." {
+		t.Errorf("unexpected extracted message: %q", actual)
+	}
+
+	var translations = testMessages{msg.ID: &soymsg.Message{ID: msg.ID, Parts: []soymsg.Part{
+		soymsg.PlaceholderPart{Name: "XXX"},
+		soymsg.RawTextPart{Text: " : bonjour "},
+		soymsg.PlaceholderPart{Name: "NAME"},
+	}}}
+	var tests = []struct {
+		msgs     soymsg.Provider
+		expected string
+	}{
+		{nil, `Hello Rob, see <a href="/help">help</a>.`},
+		{testMessages{}, `Hello Rob, see <a href="/help">help</a>.`},
+		{translations, `<a href="/help">help</a> : bonjour Rob`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err = NewTofu(&registry).NewRenderer("test.greet").
+			Messages(test.msgs).
+			Execute(&buf, data.Map{"name": data.String("Rob")})
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if buf.String() != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, buf.String())
+		}
+	}
+}
+
+func TestMessageBundles(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param name */
+{template .greet}
+  {msg desc="greeting"}Hello {$name}{/msg}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var msg = tree.Body[2].(*ast.TemplateNode).Body.Nodes[0].(*ast.MsgNode)
+	fr, err := soymsg.ReadXTB(strings.NewReader(fmt.Sprintf(
+		`<translationbundle lang="fr"><translation id="%d">Bonjour <ph name="NAME"/></translation></translationbundle>`,
+		msg.ID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var de = soymsg.NewBundle("de")
+	de.Add(soymsg.Message{ID: msg.ID, Parts: []soymsg.Part{
+		soymsg.RawTextPart{Text: "Hallo "},
+		soymsg.PlaceholderPart{Name: "NAME"},
+	}})
+
+	var tofu = NewTofu(&registry).Messages(fr, de)
+	var tests = []struct {
+		locale   string
+		msgs     soymsg.Provider
+		expected string
+	}{
+		{"", nil, "Hello Rob"},
+		{"fr", nil, "Bonjour Rob"},
+		{"de", nil, "Hallo Rob"},
+		{"es", nil, "Hello Rob"},
+		{"fr", de, "Hallo Rob"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err = tofu.NewRenderer("test.greet").
+			Locale(test.locale).
+			Messages(test.msgs).
+			Execute(&buf, data.Map{"name": data.String("Rob")})
+		if err != nil {
+			t.Error(err)
+		} else if buf.String() != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.locale, test.expected, buf.String())
+		}
+	}
+}
+
+func TestPlural(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param count */
+{template .items}
+  {msg desc="item count"}
+    {plural $count}
+      {case 0}No items
+      {case 1}One item
+      {default}{$count} items
+    {/plural}
+  {/msg}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var msg = tree.Body[2].(*ast.TemplateNode).Body.Nodes[0].(*ast.MsgNode)
+	var translations = testMessages{msg.ID: &soymsg.Message{ID: msg.ID, Parts: []soymsg.Part{
+		soymsg.PluralPart{VarName: "COUNT", Cases: []soymsg.PluralCase{
+			{Spec: "=1", Parts: []soymsg.Part{soymsg.RawTextPart{Text: "un article"}}},
+			{Spec: "other", Parts: []soymsg.Part{
+				soymsg.PlaceholderPart{Name: "COUNT"},
+				soymsg.RawTextPart{Text: " articles"},
+			}},
+		}},
+	}}}
+	var polish = testMessages{msg.ID: &soymsg.Message{ID: msg.ID, Parts: []soymsg.Part{
+		soymsg.PluralPart{VarName: "COUNT", Cases: []soymsg.PluralCase{
+			{Spec: "=0", Parts: []soymsg.Part{soymsg.RawTextPart{Text: "brak"}}},
+			{Spec: "one", Parts: []soymsg.Part{soymsg.RawTextPart{Text: "jeden"}}},
+			{Spec: "few", Parts: []soymsg.Part{soymsg.PlaceholderPart{Name: "COUNT"}, soymsg.RawTextPart{Text: " pliki"}}},
+			{Spec: "many", Parts: []soymsg.Part{soymsg.PlaceholderPart{Name: "COUNT"}, soymsg.RawTextPart{Text: " plików"}}},
+			{Spec: "other", Parts: []soymsg.Part{soymsg.PlaceholderPart{Name: "COUNT"}, soymsg.RawTextPart{Text: " pliku"}}},
+		}},
+	}}}
+	var tests = []struct {
+		msgs     soymsg.Provider
+		locale   string
+		count    data.Value
+		expected string
+	}{
+		{nil, "", data.Int(0), "No items"},
+		{nil, "", data.Int(1), "One item"},
+		{nil, "", data.Int(5), "5 items"},
+		{translations, "fr", data.Int(1), "un article"},
+		{translations, "fr", data.Int(0), "0 articles"},
+		{translations, "fr", data.Int(5), "5 articles"},
+		{polish, "pl", data.Int(0), "brak"},
+		{polish, "pl", data.Int(1), "jeden"},
+		{polish, "pl", data.Int(3), "3 pliki"},
+		{polish, "pl", data.Int(22), "22 pliki"},
+		{polish, "pl", data.Int(5), "5 plików"},
+		{polish, "pl", data.Int(12), "12 plików"},
+		{polish, "", data.Int(3), "3 pliku"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err = NewTofu(&registry).NewRenderer("test.items").
+			Messages(test.msgs).
+			Locale(test.locale).
+			Execute(&buf, data.Map{"count": test.count})
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if buf.String() != test.expected {
+			t.Errorf("%s count %v: expected %q, got %q", test.locale, test.count, test.expected, buf.String())
+		}
+	}
+
+	err = NewTofu(&registry).NewRenderer("test.items").
+		Execute(&bytes.Buffer{}, data.Map{"count": data.String("many")})
+	if err == nil {
+		t.Errorf("expected an error for a non-integer count")
+	}
+}
+
+func TestSelect(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/**
+ * @param gender
+ * @param name
+ */
+{template .sent}
+  {msg desc="gift notice"}
+    {select $gender}
+      {case 'female'}{$name} sent you her gift
+      {case 'male'}{$name} sent you his gift
+      {default}{$name} sent you a gift
+    {/select}
+  {/msg}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var msg = tree.Body[2].(*ast.TemplateNode).Body.Nodes[0].(*ast.MsgNode)
+	var translations = testMessages{msg.ID: &soymsg.Message{ID: msg.ID, Parts: []soymsg.Part{
+		soymsg.SelectPart{VarName: "GENDER", Cases: []soymsg.SelectCase{
+			{Value: "female", Parts: []soymsg.Part{
+				soymsg.PlaceholderPart{Name: "NAME"},
+				soymsg.RawTextPart{Text: " vous a envoyé son cadeau"},
+			}},
+			{Value: "other", Parts: []soymsg.Part{
+				soymsg.PlaceholderPart{Name: "NAME"},
+				soymsg.RawTextPart{Text: " vous a envoyé un cadeau"},
+			}},
+		}},
+	}}}
+	var tests = []struct {
+		msgs     soymsg.Provider
+		gender   string
+		expected string
+	}{
+		{nil, "female", "Ann sent you her gift"},
+		{nil, "male", "Ann sent you his gift"},
+		{nil, "", "Ann sent you a gift"},
+		{translations, "female", "Ann vous a envoyé son cadeau"},
+		{translations, "male", "Ann vous a envoyé un cadeau"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err = NewTofu(&registry).NewRenderer("test.sent").
+			Messages(test.msgs).
+			Execute(&buf, data.Map{"gender": data.String(test.gender), "name": data.String("Ann")})
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if buf.String() != test.expected {
+			t.Errorf("gender %q: expected %q, got %q", test.gender, test.expected, buf.String())
+		}
+	}
+}
+
+func TestExecuteChunk(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param title */
+{template .header}
+  <h1>{$title}</h1>
+{/template}
+
+/**
+ * @param header
+ * @param body
+ */
+{template .page}
+  {$header}<p>{$body}</p>
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var tofu = NewTofu(&registry)
+	header, err := tofu.NewRenderer("test.header").
+		ExecuteChunk(data.Map{"title": data.String("Tom & Jerry")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Kind != data.KindHTML || header.Content != "<h1>Tom &amp; Jerry</h1>" {
+		t.Errorf("unexpected chunk: %#v", header)
+	}
+
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		err = tofu.NewRenderer("test.page").
+			Execute(&buf, data.Map{"header": header, "body": data.String("<b>")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected = "<h1>Tom &amp; Jerry</h1><p>&lt;b&gt;</p>"
+		if buf.String() != expected {
+			t.Errorf("expected %q, got %q", expected, buf.String())
+		}
+	}
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param list */
+{template .outer}
+  <ul>{foreach $item in $list}{call .item}{param item: $item /}{/call}{/foreach}</ul>
+{/template}
+
+/** @param item */
+{template .item}
+  <li>{$item}</li>
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var tests = []struct {
+		limit int64
+		list  []string
+		err   bool
+	}{
+		{0, []string{"a", "b", "c"}, false},
+		{39, []string{"a", "b", "c"}, false},
+		{33, []string{"a", "b", "c"}, true},
+		{100, make([]string, 100), true},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err = NewTofu(&registry).NewRenderer("test.outer").
+			MaxOutputBytes(test.limit).
+			Execute(&buf, data.Map{"list": data.New(test.list)})
+		if !test.err {
+			if err != nil {
+				t.Errorf("limit %d: unexpected error: %v", test.limit, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrOutputTooLarge) {
+			t.Errorf("limit %d: expected ErrOutputTooLarge, got %v", test.limit, err)
+			continue
+		}
+		var tooLarge = err.(*OutputTooLargeError)
+		if !reflect.DeepEqual(tooLarge.Stack, []string{"test.outer", "test.item"}) {
+			t.Errorf("limit %d: unexpected stack: %v", test.limit, tooLarge.Stack)
+		}
+		if int64(buf.Len()) > test.limit {
+			t.Errorf("limit %d: wrote %d bytes", test.limit, buf.Len())
+		}
+	}
+}
+
+func TestMaxCallDepth(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param n */
+{template .outer}
+  {call .even data="all" /}
+{/template}
+
+/** @param n */
+{template .even}
+  {if $n > 0}{call .odd}{param n: $n - 1 /}{/call}{/if}
+{/template}
+
+/** @param n */
+{template .odd}
+  {call .even}{param n: $n - 1 /}{/call}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var tests = []struct {
+		tofuDepth, depth int
+		n                int
+		limit            int // expected limit exceeded, or 0 for success
+	}{
+		{0, 0, 10, 0},
+		{0, 0, 1e6, DefaultMaxCallDepth},
+		{5, 0, 2, 0},
+		{5, 0, 3, 5},
+		{5, 10, 3, 0},
+		{0, -1, 2000, 0},
+	}
+	for _, test := range tests {
+		err = NewTofu(&registry).MaxCallDepth(test.tofuDepth).
+			NewRenderer("test.outer").MaxCallDepth(test.depth).
+			Execute(ioutil.Discard, data.Map{"n": data.Int(test.n)})
+		if test.limit == 0 {
+			if err != nil {
+				t.Errorf("%v: unexpected error: %v", test, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrCallDepthExceeded) {
+			t.Errorf("%v: expected ErrCallDepthExceeded, got %v", test, err)
+			continue
+		}
+		var depthErr = err.(*CallDepthError)
+		if depthErr.Limit != test.limit || len(depthErr.Stack) != test.limit+1 {
+			t.Errorf("%v: unexpected limit %d with stack of %d", test, depthErr.Limit, len(depthErr.Stack))
+		}
+		var cycle = []string{"test.even", "test.odd", "test.even"}
+		if depthErr.Stack[len(depthErr.Stack)-1] == "test.odd" {
+			cycle = []string{"test.odd", "test.even", "test.odd"}
+		}
+		if !reflect.DeepEqual(depthErr.Cycle, cycle) {
+			t.Errorf("%v: expected cycle %v, got %v", test, cycle, depthErr.Cycle)
+		}
+		if !strings.Contains(err.Error(), "recursion through "+strings.Join(cycle, " > ")) {
+			t.Errorf("%v: unexpected message: %v", test, err)
+		}
+	}
+}
+
+func TestMaxOutputBytesContent(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param n */
+{template .page}
+  {let $unused}{foreach $i in range($n)}xxxxxxxxxx{/foreach}{/let}
+  ok
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var tofu = NewTofu(&registry).MaxOutputBytes(100)
+	var buf bytes.Buffer
+	if err = tofu.NewRenderer("test.page").Execute(&buf, data.Map{"n": data.Int(5)}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "ok" {
+		t.Errorf("expected %q, got %q", "ok", buf.String())
+	}
+	err = tofu.NewRenderer("test.page").Execute(ioutil.Discard, data.Map{"n": data.Int(20)})
+	if !errors.Is(err, ErrOutputTooLarge) {
+		t.Errorf("expected ErrOutputTooLarge, got %v", err)
+	}
+	err = tofu.NewRenderer("test.page").MaxOutputBytes(1000).
+		Execute(ioutil.Discard, data.Map{"n": data.Int(20)})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/**
+ * @param first
+ * @param second
+ */
+{template .page}
+  <h1>{call .item}{param value: 'title' /}{/call}</h1>
+  <ul>
+    {call .item}{param value: $first /}{/call}
+    {sp}
+    {call .item}{param value: $second /}{/call}
+    {call .item data="all" /}
+  </ul>
+{/template}
+
+/** @param? value */
+{template .item}
+  <li>{$value ?: 'none'}</li>
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	// The first value can only resolve once resolution of the second is
+	// underway, which requires the calls to be rendered concurrently.
+	var secondStarted = make(chan struct{})
+	var first = data.NewLazy(func() (data.Value, error) {
+		select {
+		case <-secondStarted:
+			return data.String("a"), nil
+		case <-time.After(5 * time.Second):
+			return nil, fmt.Errorf("calls were not rendered concurrently")
+		}
+	})
+	var second = data.NewLazy(func() (data.Value, error) {
+		close(secondStarted)
+		return data.String("b"), nil
+	})
+
+	var buf bytes.Buffer
+	var result RenderResult
+	result, err = NewTofu(&registry).NewRenderer("test.page").
+		ConcurrentCalls(true).
+		ExecuteResult(&buf, data.Map{"first": first, "second": second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = "<h1><li>title</li></h1><ul><li>a</li> <li>b</li><li>none</li></ul>"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+	var visited = []string{"test.page", "test.item", "test.item", "test.item", "test.item"}
+	if !reflect.DeepEqual(result.TemplatesVisited, visited) {
+		t.Errorf("expected %v, got %v", visited, result.TemplatesVisited)
+	}
+
+	// The output stops at the first call that fails.
+	buf.Reset()
+	err = NewTofu(&registry).NewRenderer("test.page").
+		ConcurrentCalls(true).
+		Execute(&buf, data.Map{"first": data.String("a"), "second": data.NewLazy(func() (data.Value, error) {
+			return nil, fmt.Errorf("unavailable")
+		})})
+	if err == nil || !strings.Contains(err.Error(), "unavailable") {
+		t.Errorf("expected the error of the second call, got %v", err)
+	}
+	if expected = "<h1><li>title</li></h1><ul><li>a</li> "; buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+// recordingInstrumenter records the templates started and ended.
+type recordingInstrumenter struct {
+	mu     sync.Mutex
+	starts []string
+	events []TemplateEvent
+}
+
+func (r *recordingInstrumenter) OnTemplateStart(ctx context.Context, template string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.starts = append(r.starts, template)
+}
+
+func (r *recordingInstrumenter) OnTemplateEnd(ctx context.Context, event TemplateEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func TestInstrument(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param? fail */
+{template .page}
+  <ul>{call .item}{param name: 'a' /}{/call}{call .item}{param name: 'bc' /}{/call}</ul>
+  {if $fail}{call .fail /}{/if}
+{/template}
+
+/** @param name */
+{template .item}
+  <li>{$name}</li>
+{/template}
+
+{template .fail}
+  {$ij.missing.key}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	for _, concurrent := range []bool{false, true} {
+		var metrics = &recordingInstrumenter{}
+		var buf bytes.Buffer
+		err = NewTofu(&registry).Instrument(metrics).NewRenderer("test.page").
+			ConcurrentCalls(concurrent).
+			Execute(&buf, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(metrics.starts)
+		if expected := []string{"test.item", "test.item", "test.page"}; !reflect.DeepEqual(metrics.starts, expected) {
+			t.Errorf("concurrent %v: expected starts %v, got %v", concurrent, expected, metrics.starts)
+		}
+		var bytesOf = make(map[string]int64)
+		for _, event := range metrics.events {
+			bytesOf[event.Template+"<"+event.Caller] += event.Bytes
+			if event.Err != nil || event.Elapsed <= 0 {
+				t.Errorf("concurrent %v: unexpected event: %+v", concurrent, event)
+			}
+		}
+		var expected = map[string]int64{
+			"test.item<test.page": int64(len("<li>a</li><li>bc</li>")),
+			"test.page<":          int64(buf.Len()),
+		}
+		if !reflect.DeepEqual(bytesOf, expected) {
+			t.Errorf("concurrent %v: expected bytes %v, got %v", concurrent, expected, bytesOf)
+		}
+	}
+
+	var metrics = &recordingInstrumenter{}
+	err = NewTofu(&registry).Instrument(metrics).NewRenderer("test.page").
+		Execute(ioutil.Discard, data.Map{"fail": data.Bool(true)})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	var last = len(metrics.events) - 1
+	if last != 3 || metrics.events[last-1].Template != "test.fail" ||
+		metrics.events[last-1].Err == nil || metrics.events[last].Err == nil {
+		t.Errorf("expected the failure to be reported, got %+v", metrics.events)
+	}
+}
+
+func TestAuditUnsafe(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test autoescape="strict"}
+
+/**
+ * @param body
+ * @param link
+ * @param text
+ */
+{template .page}
+  <a href="{$link}">{$text}</a>
+  {$body}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var mu sync.Mutex
+	var events []UnsafeEvent
+	var tofu = NewTofu(&registry).AuditUnsafe(UnsafeAuditorFunc(func(ctx context.Context, event UnsafeEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}))
+	var body, link = UnsafeHTML("<b>hi</b>"), UnsafeURL("javascript:go()")
+	var buf bytes.Buffer
+	err = tofu.NewRenderer("test.page").Execute(&buf, data.Map{
+		"body": body,
+		"link": link,
+		"text": data.String("<i>"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The scheme is not filtered, though the URL is still normalized.
+	var expected = `<a href="javascript:go%28%29">&lt;i&gt;</a><b>hi</b>`
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %v", events)
+	}
+	if e := events[0]; e.Template != "test.page" || e.Line != 9 || e.Kind != data.KindURI ||
+		e.Content != "javascript:go()" || !strings.Contains(e.Origin, "exec_test.go:") {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	if e := events[1]; e.Line != 10 || e.Kind != data.KindHTML || e.Origin != body.Origin {
+		t.Errorf("unexpected event: %+v", e)
+	}
+}
+
+func TestResolveAsync(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/**
+ * @param first
+ * @param user
+ */
+{template .page}
+  {$first} {$user.name}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	// The first value can only resolve once resolution of the second is
+	// underway, which requires them to be fetched concurrently.
+	var secondStarted = make(chan struct{})
+	var calls int
+	var first = data.NewLazy(func() (data.Value, error) {
+		select {
+		case <-secondStarted:
+			return data.String("hello"), nil
+		case <-time.After(5 * time.Second):
+			return nil, fmt.Errorf("second value was not resolved concurrently")
+		}
+	})
+	var name = data.NewLazy(func() (data.Value, error) {
+		calls++
+		close(secondStarted)
+		return data.String("Rob"), nil
+	})
+
+	var buf bytes.Buffer
+	err = NewTofu(&registry).NewRenderer("test.page").
+		ResolveAsync().
+		Execute(&buf, data.Map{"first": first, "user": data.Map{"name": name}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "hello Rob" {
+		t.Errorf("expected %q, got %q", "hello Rob", buf.String())
+	}
+	if calls != 1 {
+		t.Errorf("expected the lazy value to be resolved once, got %d", calls)
+	}
+
+	var failing = data.NewLazy(func() (data.Value, error) {
+		return nil, fmt.Errorf("backend unavailable")
+	})
+	err = NewTofu(&registry).NewRenderer("test.page").
+		Execute(&buf, data.Map{"first": failing, "user": data.Map{}})
+	if err == nil || !strings.Contains(err.Error(), "backend unavailable") {
+		t.Errorf("expected resolution error, got %v", err)
+	}
+}
+
+func TestLazyThunks(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+/**
+ * @param name
+ * @param? recommendations
+ */
+{template .page}
+  {if $recommendations}{$recommendations}{/if} {$name} {$name}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var calls = make(map[string]int)
+	var page = struct {
+		Name            func() data.Value
+		Recommendations func() (data.Value, error)
+	}{
+		func() data.Value { calls["name"]++; return data.String("Rob") },
+		func() (data.Value, error) { calls["recommendations"]++; return data.Null{}, nil },
+	}
+
+	var buf bytes.Buffer
+	if err = NewTofu(&registry).Render(&buf, "test.page", page); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != " Rob Rob" {
+		t.Errorf("expected %q, got %q", " Rob Rob", buf.String())
+	}
+	if calls["name"] != 1 || calls["recommendations"] != 1 {
+		t.Errorf("expected each thunk to be called once, got %v", calls)
+	}
+
+	// Thunks that the template does not dereference are never called.
+	var m = data.New(page).(data.Map)
+	delete(calls, "name")
+	if err = NewTofu(&registry).NewRenderer("test.page").Execute(&buf, data.Map{"name": data.String("x"), "unused": m["name"]}); err != nil {
+		t.Fatal(err)
+	}
+	if calls["name"] != 0 {
+		t.Errorf("expected the unused thunk not to be called, got %v", calls)
+	}
+}
+
+func TestBudgets(t *testing.T) {
+	Funcs["sleep"] = Func{func(args []data.Value) data.Value {
+		time.Sleep(time.Duration(args[0].(data.Int)) * time.Millisecond)
+		return data.String("")
+	}, []int{1}, nil}
+	defer delete(Funcs, "sleep")
+
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+{template .page}
+  {call .fast/}{call .slow/}
+{/template}
+
+{template .fast}
+  fast
+{/template}
+
+{template .slow}
+  slow{sleep(20)}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var slowCalls []*SlowCall
+	var budgets = &Budgets{
+		Default:   time.Hour,
+		Templates: map[string]time.Duration{"test.slow": 10 * time.Millisecond},
+		OnExceeded: func(c *SlowCall) error {
+			slowCalls = append(slowCalls, c)
+			return nil
+		},
+	}
+	var buf bytes.Buffer
+	err = NewTofu(&registry).Budgets(budgets).NewRenderer("test.page").Execute(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "fastslow" {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+	if len(slowCalls) != 1 {
+		t.Fatalf("expected 1 slow call, got %v", slowCalls)
+	}
+	var slow = slowCalls[0]
+	if slow.Template != "test.slow" || slow.Elapsed < 20*time.Millisecond ||
+		!reflect.DeepEqual(slow.Stack, []string{"test.page", "test.slow"}) {
+		t.Errorf("unexpected slow call: %#v", slow)
+	}
+
+	budgets.OnExceeded = nil
+	err = NewTofu(&registry).Budgets(budgets).NewRenderer("test.page").Execute(&buf, nil)
+	if !errors.As(err, &slow) || slow.Template != "test.slow" {
+		t.Errorf("expected the render to be aborted by a *SlowCall, got %v", err)
+	}
+
+	// A call is aborted once it exceeds its budget, rather than once it
+	// completes.
+	var sleeps int
+	Funcs["sleep"] = Func{func(args []data.Value) data.Value {
+		sleeps++
+		time.Sleep(time.Duration(args[0].(data.Int)) * time.Millisecond)
+		return data.String("")
+	}, []int{1}, nil}
+	tree, err = parse.SoyFile("", `{namespace loop}
+
+{template .page}
+  {call .slow/}
+{/template}
+
+{template .slow}
+  {foreach $i in range(20)}{sleep(5)}{/foreach}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+	budgets.Templates["loop.slow"] = 10 * time.Millisecond
+	err = NewTofu(&registry).Budgets(budgets).NewRenderer("loop.page").Execute(&buf, nil)
+	if !errors.As(err, &slow) || slow.Template != "loop.slow" ||
+		!reflect.DeepEqual(slow.Stack, []string{"loop.page", "loop.slow"}) {
+		t.Errorf("expected the render to be aborted by a *SlowCall, got %v", err)
+	}
+	if sleeps >= 20 {
+		t.Errorf("expected the slow call to be aborted before it completed, got %d iterations", sleeps)
+	}
+}
+
+func TestRenderErrorSource(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param list */
+{template .first}
+  {foreach $x in $list}
+    {$x.name}
+  {/foreach}
+{/template}
+
+{template .second}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	err = NewTofu(&registry).NewRenderer("test.first").
+		Execute(ioutil.Discard, data.Map{"list": data.New([]int{1})})
+	var renderErr, ok = err.(*RenderError)
+	if !ok {
+		t.Fatalf("expected *RenderError, got %T: %v", err, err)
+	}
+	if renderErr.Template != "test.first" || renderErr.Line != 6 || renderErr.SourceLine != 3 {
+		t.Errorf("unexpected error location: %#v", renderErr)
+	}
+	var expected = `     3  /** @param list */
+     4  {template .first}
+     5    {foreach $x in $list}
+>    6      {$x.name}
+     7    {/foreach}
+     8  {/template}
+`
+	if renderErr.SourceExcerpt() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, renderErr.SourceExcerpt())
+	}
+}
+
+func TestRenderErrorStack(t *testing.T) {
+	var registry = template.Registry{}
+	for name, src := range map[string]string{
+		"page.soy": `{namespace test}
+
+/** @param items */
+{template .page}
+  <ul>
+    {call test.list data="all" /}
+  </ul>
+{/template}`,
+		"items.soy": `{namespace test}
+
+/** @param items */
+{template .list}
+  {foreach $item in $items}
+    {call .item}{param item: $item /}{/call}
+  {/foreach}
+{/template}
+
+/** @param item */
+{template .item}
+  <li>{$item.name}</li>
+{/template}`,
+	} {
+		var tree, err = parse.SoyFile(name, src, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		registry.Add(tree)
+	}
+
+	var err = NewTofu(&registry).NewRenderer("test.page").
+		Execute(ioutil.Discard, data.Map{"items": data.New([]int{1})})
+	var renderErr, ok = err.(*RenderError)
+	if !ok {
+		t.Fatalf("expected *RenderError, got %T: %v", err, err)
+	}
+	var expected = []StackFrame{
+		{"test.list", "items.soy", 6, 10},
+		{"test.page", "page.soy", 6, 10},
+	}
+	if renderErr.Template != "test.item" || renderErr.File != "items.soy" ||
+		renderErr.Line != 12 || renderErr.Col != 13 ||
+		!reflect.DeepEqual(renderErr.Stack, expected) {
+		t.Errorf("unexpected error location: %#v", renderErr)
+	}
+	var trace = `test.item (items.soy:12:13)
+  called from test.list (items.soy:6:10)
+  called from test.page (page.soy:6:10)
+`
+	if renderErr.StackTrace() != trace {
+		t.Errorf("expected:\n%s\ngot:\n%s", trace, renderErr.StackTrace())
+	}
+	var suffix = " (items.soy:12:13, called from test.list (items.soy:6:10))"
+	if !strings.HasPrefix(err.Error(), "template test.item:12: ") || !strings.HasSuffix(err.Error(), suffix) {
+		t.Errorf("expected error at %s, got %q", suffix, err.Error())
+	}
+}
+
+func TestCSPNonce(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param src */
+{template .page}
+  <script src="{$src}"></script>
+  <SCRIPT>var a = '<style>';</SCRIPT>
+  <style nonce="{$ij.csp_nonce}">p {lb}{rb}</style>
+  <scripts></scripts>
+  <!-- <script> --><textarea><script></textarea>
+  {call .cached/}
+{/template}
+
+{template .cached cacheable="true"}
+  <script>b();</script>
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	const page = `<script%[1]s src="a.js"></script><SCRIPT%[1]s>var a = '<style>';</SCRIPT>` +
+		`<style nonce="%[2]s">p {}</style><scripts></scripts>` +
+		`<!-- <script> --><textarea><script></textarea><script%[1]s>b();</script>`
+	var tests = []struct {
+		nonce    data.Value
+		expected string
+		err      bool
+	}{
+		{data.String("abc+/="), fmt.Sprintf(page, ` nonce="abc+/="`, "abc+/="), false},
+		{data.Null{}, fmt.Sprintf(page, "", "null"), false},
+		{data.String("xyz"), fmt.Sprintf(page, ` nonce="xyz"`, "xyz"), false},
+		{data.String(`"><script>`), "", true},
+	}
+	var tofu = NewTofu(&registry).Cache(NewRenderCache())
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err = tofu.NewRenderer("test.page").
+			Inject(data.Map{"csp_nonce": test.nonce}).
+			Execute(&buf, data.Map{"src": data.String("a.js")})
+		if test.err {
+			if err == nil {
+				t.Errorf("%v: expected an error", test.nonce)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.nonce, err)
+			continue
+		}
+		if buf.String() != test.expected {
+			t.Errorf("%v: expected\n%s\ngot\n%s", test.nonce, test.expected, buf.String())
+		}
+	}
+}
+
+func TestOutputFilters(t *testing.T) {
+	var tests = []struct {
+		input    string
+		filters  []OutputFilter
+		expected string
+	}{
+		{"<ul>\n  <li>a  b</li>\n  <li>c</li>\n</ul>", []OutputFilter{CollapseWhitespace},
+			"<ul> <li>a  b</li> <li>c</li> </ul>"},
+		{"<pre>\n  <b>a</b>\n</pre>\n<p>", []OutputFilter{CollapseWhitespace},
+			"<pre>\n  <b>a</b>\n</pre> <p>"},
+		{"<script>\n  a <b;\n  </SCRIPT>\n<p title=\"a > b\">  <i>", []OutputFilter{CollapseWhitespace},
+			"<script>\n  a <b;\n  </SCRIPT> <p title=\"a > b\"> <i>"},
+		{"a<!-- x -->b<!--[if IE]>c<![endif]-->", []OutputFilter{StripComments},
+			"ab<!--[if IE]>c<![endif]-->"},
+		{"<style><!-- a --></style>", []OutputFilter{StripComments}, "<style><!-- a --></style>"},
+		{"<br>  <!-- x -->\n <p>", []OutputFilter{StripComments, CollapseWhitespace}, "<br> <p>"},
+	}
+	for _, test := range tests {
+		// Write the input all at once, and a byte at a time.
+		for _, size := range []int{len(test.input), 1} {
+			var buf bytes.Buffer
+			var wr io.Writer = &buf
+			var closers []io.WriteCloser
+			for i := len(test.filters) - 1; i >= 0; i-- {
+				var filter = test.filters[i](wr)
+				closers = append([]io.WriteCloser{filter}, closers...)
+				wr = filter
+			}
+			for i := 0; i < len(test.input); i += size {
+				var end = i + size
+				if end > len(test.input) {
+					end = len(test.input)
+				}
+				wr.Write([]byte(test.input[i:end]))
+			}
+			for _, closer := range closers {
+				closer.Close()
+			}
+			if buf.String() != test.expected {
+				t.Errorf("%q (writes of %d): expected %q, got %q", test.input, size, test.expected, buf.String())
+			}
+		}
+	}
+
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+/** @param x */
+{template .page}
+<div>{\n}  {$x}  {\n}</div>{\n}<!-- done -->
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+	var buf bytes.Buffer
+	err = NewTofu(&registry).NewRenderer("test.page").
+		Filter(StripComments, CollapseWhitespace).
+		Execute(&buf, data.Map{"x": data.String("<b>")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<div>\n  &lt;b&gt;  \n</div>\n"; buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestKindFilters(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+{template .page}
+<p>{\n}  <b>hi</b></p>
+{/template}
+
+{template .plain autoescape="false"}
+<b>hi</b>
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var written int
+	var counter OutputFilter = func(w io.Writer) io.WriteCloser {
+		return countFilter{w, &written}
+	}
+	var tofu = NewTofu(&registry).
+		Filter(data.KindHTML, CollapseWhitespace, Gzip).
+		Filter(data.KindText, counter)
+
+	// HTML renders are filtered by the renderer's filters, then the kind's.
+	var buf bytes.Buffer
+	err = tofu.NewRenderer("test.page").Filter(counter).Execute(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<p> <b>hi</b></p>"; string(out) != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+	if written != len("<p>\n  <b>hi</b></p>") {
+		t.Errorf("expected the renderer's filter to see the unfiltered output, got %d bytes", written)
+	}
+
+	// Other kinds get their own chain.
+	written = 0
+	buf.Reset()
+	err = tofu.NewRenderer("test.page").Kind(data.KindText).Execute(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<p>\n  <b>hi</b></p>"; buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+	if written != buf.Len() {
+		t.Errorf("expected %d bytes counted, got %d", buf.Len(), written)
+	}
+
+	// Chunks are not filtered, and default to the kind of the template.
+	written = 0
+	chunk, err := tofu.NewRenderer("test.page").Filter(counter).ExecuteChunk(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (data.SanitizedContent{data.KindHTML, "<p>\n  <b>hi</b></p>"}); chunk != expected {
+		t.Errorf("expected %v, got %v", expected, chunk)
+	}
+	if written != 0 {
+		t.Errorf("expected the chunk not to be filtered, got %d bytes counted", written)
+	}
+	chunk, err = tofu.NewRenderer("test.plain").ExecuteChunk(nil)
+	if expected := (data.SanitizedContent{data.KindText, "<b>hi</b>"}); err != nil || chunk != expected {
+		t.Errorf("expected %v, got %v, %v", expected, chunk, err)
+	}
+}
+
+type countFilter struct {
+	w io.Writer
+	n *int
+}
+
+func (f countFilter) Write(p []byte) (int, error) {
+	*f.n += len(p)
+	return f.w.Write(p)
+}
+
+func (f countFilter) Close() error { return nil }
+
+func TestExecuteEmail(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace mail autoescape="strict"}
+/** @param name */
+{template .welcomeHtml}
+<p>{\n}  <b>Welcome</b>, {$name}!</p>
+{/template}
+
+/** @param name */
+{template .welcomeText autoescape="false"}
+Welcome, {$name}!
+{/template}
+
+/** @param name */
+{template .noticeText autoescape="false"}
+Notice for {$name}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var outputs []Output
+	var record = PostRendererFunc(func(ctx context.Context, out Output) error {
+		outputs = append(outputs, out)
+		return nil
+	})
+	var tofu = NewTofu(&registry).
+		Filter(data.KindHTML, CollapseWhitespace).
+		PostRender(data.KindHTML, record).
+		PostRender(data.KindText, record)
+
+	var m = data.Map{"name": data.String("<Rob>")}
+	email, err := tofu.NewRenderer("mail.welcome").Locale("fr").ExecuteEmail(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = Email{"<p> <b>Welcome</b>, &lt;Rob&gt;!</p>", "Welcome, <Rob>!"}
+	if email != expected {
+		t.Errorf("expected %q, got %q", expected, email)
+	}
+	// Post-renderers receive the output before it is filtered.
+	var expectedOutputs = []Output{
+		{"mail.welcomeHtml", data.KindHTML, "fr", []byte("<p>\n  <b>Welcome</b>, &lt;Rob&gt;!</p>")},
+		{"mail.welcomeText", data.KindText, "fr", []byte(expected.Text)},
+	}
+	if !reflect.DeepEqual(outputs, expectedOutputs) {
+		t.Errorf("expected outputs %q, got %q", expectedOutputs, outputs)
+	}
+
+	// Either template may be omitted, but not both.
+	email, err = tofu.NewRenderer("mail.notice").ExecuteEmail(m)
+	if err != nil || email != (Email{"", "Notice for <Rob>"}) {
+		t.Errorf("unexpected email %q, error %v", email, err)
+	}
+	if _, err = tofu.NewRenderer("mail.missing").ExecuteEmail(m); err != ErrTemplateNotFound {
+		t.Errorf("expected ErrTemplateNotFound, got %v", err)
+	}
+
+	// A failing consumer fails the render.
+	var failure = errors.New("archive unavailable")
+	tofu.PostRender(data.KindText, PostRendererFunc(func(context.Context, Output) error {
+		return failure
+	}))
+	if _, err = tofu.NewRenderer("mail.welcome").ExecuteEmail(m); err != failure {
+		t.Errorf("expected the consumer's error, got %v", err)
+	}
+}
+
+func TestCallResolver(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace checkout}
+/**
+ * @param label
+ * @param? size
+ */
+{template .button}
+<button>{$label}</button>
+{/template}
+
+/**
+ * @param label
+ * @param? size
+ * @param? color
+ */
+{template .button_v2}
+<button class="{$color ?: 'blue'}">{$label}</button>
+{/template}
+
+/**
+ * @param label
+ * @param size
+ */
+{template .button_v3}
+<button class="{$size}">{$label}</button>
+{/template}
+
+/**
+ * @param label
+ * @param? size
+ */
+{template .button_text kind="text"}
+{$label}
+{/template}
+
+{template .page}
+{call .button}{param label: 'Buy' /}{/call}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var tests = []struct {
+		substitute string
+		expected   string
+		err        string
+	}{
+		{"checkout.button", "<button>Buy</button>", ""},
+		{"checkout.button_v2", `<button class="blue">Buy</button>`, ""},
+		{"checkout.button_v3", "", "size, which is optional in checkout.button"},
+		{"checkout.button_v4", "", "failed to find template checkout.button_v4"},
+		{"checkout.button_text", "", "checkout.button_text outputs text, but checkout.button outputs html"},
+	}
+	for _, test := range tests {
+		var resolver = CallResolverFunc(func(ctx context.Context, name string) string {
+			if name == "checkout.button" {
+				return test.substitute
+			}
+			return name
+		})
+		var buf bytes.Buffer
+		err = NewTofu(&registry).CallResolver(resolver).NewRenderer("checkout.page").Execute(&buf, nil)
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error containing %q, got %v", test.substitute, test.err, err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", test.substitute, err)
+		case buf.String() != test.expected:
+			t.Errorf("%s: expected %q, got %q", test.substitute, test.expected, buf.String())
+		}
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	var tests = []struct {
+		body string
+		err  string
+	}{
+		{`{call .b /}{delcall x.y allowemptydefault="true" /}{$a |truncate:2}`, ""},
+		{`{call .missing /}`, "failed to find template: test.missing"},
+		{`{delcall x.z /}`, "failed to find delegate template: x.z"},
+		{`{call .b /}{call .c /}`, `template test.c:6: unrecognized function name: nope`},
+		{`{length(1, 2)}`, `Function "length" called with 2 args`},
+		{`{$a |truncate}`, `Print directive "truncate" called with 0 args`},
+		{`{$a |nope}`, `Print directive "nope" does not exist`},
+		{`{dumpScope()}`, `Function "dumpScope" is only available in debug mode`},
+		{`{call .d /}`, `template test.d:7: {if} branches end in different contexts`},
+	}
+	for _, test := range tests {
+		var registry = template.Registry{}
+		var tree, err = parse.SoyFile("", `{namespace test}
+{template .a}
+`+test.body+`
+{/template}
+{template .b}{/template}
+{template .c}{nope()}{/template}
+{template .d autoescape="contextual"}<a {if $x}title{else}href{/if}="{$y}">{/template}`, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		registry.Add(tree)
+		err = NewTofu(&registry).Warmup("test.a")
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.body, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: expected error containing %q, got %v", test.body, test.err, err)
+		}
+	}
+}
+
+func TestEntryPolicy(t *testing.T) {
+	var registry = template.Registry{}
+	for _, src := range []string{`{namespace shop.pages}
+{template .home}
+  home {call .nav/}
+{/template}
+
+{template .nav private="true"}
+  nav
+{/template}`, `{namespace shop.admin}
+{template .users}
+  users
+{/template}`} {
+		var tree, err = parse.SoyFile("", src, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		registry.Add(tree)
+	}
+
+	var tests = []struct {
+		policy   EntryPolicy
+		name     string
+		expected string // output, or "" if denied
+	}{
+		{nil, "shop.pages.nav", "nav"},
+		{nil, "shop.admin.users", "users"},
+		{PublicEntries(), "shop.pages.home", "home nav"},
+		{PublicEntries(), "shop.pages.nav", ""},
+		{PublicEntries(), "shop.admin.users", "users"},
+		{PublicEntries("shop.pages"), "shop.pages.home", "home nav"},
+		{PublicEntries("shop.pages"), "shop.admin.users", ""},
+		{PublicEntries("shop"), "shop.admin.users", "users"},
+		{PublicEntries("sho"), "shop.admin.users", ""},
+		{EntryPolicyFunc(func(tmpl template.Template) bool {
+			return tmpl.Node.Name != "shop.admin.users"
+		}), "shop.admin.users", ""},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		var err = NewTofu(&registry).EntryPolicy(test.policy).NewRenderer(test.name).Execute(&buf, nil)
+		if test.expected == "" {
+			if denied, ok := err.(*EntryDeniedError); !ok || denied.Template != test.name {
+				t.Errorf("%s: expected an EntryDeniedError, got %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if buf.String() != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, buf.String())
+		}
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/**
+ * @param user
+ * @param? note
+ */
+{template .page}
+  {if $user.admin}admin{/if}
+  {$user.name}
+  {if $note}{$note}{/if}
+  {call .items data="$user"/}
+{/template}
+
+/** @param items */
+{template .items}
+  {foreach $item in $items}{$item.title}{sp}{/foreach}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var tests = []struct {
+		data     data.Map
+		expected []string
+		fatal    bool
+	}{
+		{data.Map{"user": data.Map{
+			"admin": data.Bool(true),
+			"name":  data.String("Rob"),
+			"items": data.List{data.Map{"title": data.String("a")}},
+		}}, nil, false},
+		{data.Map{"user": data.Map{
+			"name":  data.String("Rob"),
+			"items": data.List{data.Map{"title": data.String("a")}, data.Map{}},
+		}}, []string{
+			"test.page:8: $user.admin is undefined",
+			"test.items:16: $item.title is undefined",
+			"test.items:16: In 'print' tag, expression \"$item.title\" evaluates to undefined.",
+		}, true},
+		{data.Map{"user": data.Map{"name": data.String("Rob")}}, []string{
+			"test.page:8: $user.admin is undefined",
+			"test.items:16: $items is undefined",
+			"test.items:16: In for loop \"{foreach $item in $items}{$item.title} {/foreach}\", \"$items\" does not resolve to a list.",
+		}, true},
+	}
+	for _, test := range tests {
+		var diags = NewTofu(&registry).NewRenderer("test.page").Simulate(test.data)
+		var actual []string
+		for _, diag := range diags {
+			actual = append(actual, diag.String())
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("expected %q, got %q", test.expected, actual)
+		}
+		if fatal := len(diags) > 0 && diags[len(diags)-1].Fatal; fatal != test.fatal {
+			t.Errorf("expected fatal %v, got %v", test.fatal, fatal)
+		}
+	}
+
+	var filtered bool
+	var written int
+	var tofu = NewTofu(&registry).Filter(data.KindHTML, func(w io.Writer) io.WriteCloser {
+		filtered = true
+		return countFilter{w, &written}
+	})
+	tofu.NewRenderer("test.page").Simulate(data.Map{"user": data.Map{}})
+	if filtered {
+		t.Errorf("expected simulated renders to bypass the output filters")
+	}
+}
+
+func TestSection(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/**
+ * @param items
+ * @param? hidden
+ */
+{template .page}
+  <h1>Shop</h1>
+  {let $count: length($items) /}
+  {let $cart kind="html"}
+    <ul>{foreach $item in $items}<li>{$item}</li>{/foreach}</ul> ({$count})
+  {/let}
+  <div id="cart">{$cart}</div>
+  {if $hidden}
+    {let $secret kind="html"}secret{/let}
+    {$secret}
+  {/if}
+  {foreach $item in $items}
+    {let $row kind="html"}<p>{$item}</p>{/let}
+    {$row}
+  {/foreach}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var items = data.List{data.String("a"), data.String("b")}
+	var tests = []struct {
+		section  string
+		expected string
+		err      string
+	}{
+		{"", `<h1>Shop</h1><div id="cart"><ul><li>a</li><li>b</li></ul> (2)</div><p>a</p><p>b</p>`, ""},
+		{"cart", "<ul><li>a</li><li>b</li></ul> (2)", ""},
+		{"row", "<p>a</p>", ""},
+		{"secret", "", "render of template test.page did not reach section secret"},
+		{"count", "", "template test.page has no {let $count} section"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		err = NewTofu(&registry).NewRenderer("test.page").
+			Section(test.section).
+			Execute(&buf, data.Map{"items": items})
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: expected error %q, got %v", test.section, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.section, err)
+		} else if buf.String() != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.section, test.expected, buf.String())
+		}
+	}
+}
+
+func TestPreloads(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param logo */
+{template .page}
+  <link rel="stylesheet" href="/app.css">
+  <link rel="icon" href="/favicon.ico">
+  <link rel=preload as=font href="/font.woff2">
+  <script src="/app.js?v=1&amp;x=2"></script>
+  <script>var a = 1 < 2;</script>
+  <img alt="the logo" src="{$logo}"/>
+  <img src="data:image/png;base64,AAAA">
+  <script src="/app.js?v=1&amp;x=2"></script>
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var preloads Preloads
+	var buf bytes.Buffer
+	err = NewTofu(&registry).NewRenderer("test.page").
+		Preloads(&preloads).
+		Filter(Gzip).
+		Execute(&buf, data.Map{"logo": data.String("/logo.png")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = []Preload{
+		{"/app.css", "style"},
+		{"/font.woff2", "font"},
+		{"/app.js?v=1&x=2", "script"},
+		{"/logo.png", "image"},
+	}
+	if !reflect.DeepEqual(preloads.List, expected) {
+		t.Errorf("expected %v, got %v", expected, preloads.List)
+	}
+
+	var h = make(http.Header)
+	preloads.SetHeader(h)
+	if link := h["Link"]; len(link) != 4 || link[2] != "</app.js?v=1&x=2>; rel=preload; as=script" {
+		t.Errorf("unexpected Link headers: %q", link)
+	}
+
+	// Tags split across writes are collected.
+	var split Preloads
+	var wr = &preloadWriter{w: ioutil.Discard, preloads: &split}
+	for _, chunk := range []string{"<p>a</p><scr", "ipt sr", `c="/b.js">`, "</script>"} {
+		wr.Write([]byte(chunk))
+	}
+	if len(split.List) != 1 || split.List[0] != (Preload{"/b.js", "script"}) {
+		t.Errorf("expected /b.js to be collected, got %v", split.List)
+	}
+}
+
+func TestExecuteETag(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/** @param name */
+{template .hello}
+  Hello {$name}!
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+	var tofu = NewTofu(&registry)
+
+	var render = func(name string) string {
+		var buf bytes.Buffer
+		var etag, err = tofu.NewRenderer("test.hello").ExecuteETag(&buf, data.Map{"name": data.String(name)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != "Hello "+name+"!" {
+			t.Errorf("unexpected output: %q", buf.String())
+		}
+		return etag
+	}
+	var bob, bob2, alice = render("Bob"), render("Bob"), render("Alice")
+	if bob != bob2 || bob == alice || !strings.HasPrefix(bob, `"`) || !strings.HasSuffix(bob, `"`) {
+		t.Errorf("unexpected etags: %s %s %s", bob, bob2, alice)
+	}
+
+	var tests = []struct {
+		header   string
+		expected bool
+	}{
+		{"", false},
+		{bob, true},
+		{alice, false},
+		{alice + ", W/" + bob, true},
+		{"*", true},
+	}
+	for _, test := range tests {
+		var req, _ = http.NewRequest("GET", "/", nil)
+		if test.header != "" {
+			req.Header.Set("If-None-Match", test.header)
+		}
+		if actual := ETagMatches(req, bob); actual != test.expected {
+			t.Errorf("%q: expected %v, got %v", test.header, test.expected, actual)
+		}
+	}
+
+	if etag, err := tofu.NewRenderer("test.missing").ExecuteETag(ioutil.Discard, nil); err == nil || etag != "" {
+		t.Errorf("expected an error and no etag, got %q, %v", etag, err)
+	}
+}
+
+func TestStrictAutoescape(t *testing.T) {
+	var strict = func(name, body, output string, data d) execTest {
+		return execTest{name, "test.strict",
+			"{namespace test autoescape=\"strict\"}\n{template .strict}\n" + body + "\n{/template}",
+			output, data, true}
+	}
+	var x = d{"x": "<a href='x'>&"}
+	runExecTests(t, []execTest{
+		strict("text", `<p>{$x}</p>`, `<p>&lt;a href=&#39;x&#39;&gt;&amp;</p>`, x),
+		strict("html content", `{let $b kind="html"}<b>{$x}</b>{/let}<p>{$b}</p>`,
+			`<p><b>&lt;a href=&#39;x&#39;&gt;&amp;</b></p>`, x),
+		strict("quoted attr", `<div title="{$x}">`, `<div title="&lt;a href=&#39;x&#39;&gt;&amp;">`, x),
+		strict("unquoted attr", `<div title={$x}>`, `<div title=&lt;a&#32;href&#61;&#39;x&#39;&gt;&amp;>`, x),
+		strict("uri attr start", `<a href="{$u}">`, `<a href="about:invalid#zSoyz">`, d{"u": "javascript:alert(1)"}),
+		strict("uri attr safe", `<a href='{$u}'>`, `<a href='/a%20b?c=d&amp;e'>`, d{"u": "/a b?c=d&e"}),
+		strict("uri attr query", `<a href="/search?q={$q}&amp;s=1">`, `<a href="/search?q=a%26b+c&amp;s=1">`, d{"q": "a&b c"}),
+		strict("js string", `<script>var s = '{$x}';</script>`,
+			`<script>var s = '\u003Ca href\u003D\'x\'\u003E\u0026';</script>`, x),
+		strict("js value", `<script>var n = {$n}, s = {$s}, l = {$l};</script>`,
+			`<script>var n = 42, s = 'it\'s', l = [1,"\u003c/script\u003e"];</script>`,
+			d{"n": 42, "s": "it's", "l": []interface{}{1, "</script>"}}),
+		strict("js after regex", `<script>var r = /'/; var s = {$s};</script>`,
+			`<script>var r = /'/; var s = '1;alert(1)';</script>`, d{"s": "1;alert(1)"}),
+		strict("js after division", `<script>var r = a / 2, q = '/', s = {$s};</script>`,
+			`<script>var r = a / 2, q = '/', s = 'x';</script>`, d{"s": "x"}),
+		strict("js regex", `<script>var r = /^{$s}$/i;</script>`,
+			`<script>var r = /^a\.b\/c\$$/i;</script>`, d{"s": "a.b/c$"}),
+		strict("js template literal", "<script>var s = `{$s}`;</script>",
+			"<script>var s = `\\x24{alert(1)}\\x60`;</script>", d{"s": "${alert(1)}`"}),
+		strict("js template substitution", "<script>var s = `${lb} {lb}a: '`'{rb}.a {rb} {$s}`;</script>",
+			"<script>var s = `${ {a: '`'}.a } \\x24{alert(1)}`;</script>", d{"s": "${alert(1)}"}),
+		{"js comment", "test.strict", "{namespace test autoescape=\"strict\"}\n{template .strict}\n" +
+			"<script>{literal}/* {/literal}{$x}{literal} */{/literal}</script>\n{/template}", "<script>/* ", x, false},
+		strict("after script", `<script>var a = "x";</script><p>{$x}</p>`,
+			`<script>var a = "x";</script><p>&lt;a href=&#39;x&#39;&gt;&amp;</p>`, x),
+		strict("js attr", `<button onclick="go('{$s}')">`, `<button onclick="go('it\&#39;s')">`, d{"s": "it's"}),
+		strict("css", `<div style="color: {$c}"><style>p {lb} color: {$d} {rb}</style>`,
+			`<div style="color: red"><style>p { color: zSoyz }</style>`, d{"c": "red", "d": "red;}*{x:y"}),
+		strict("attr name", `<div {$a}="1">`, `<div zSoyz="1">`, d{"a": "onclick=alert(1)"}),
+		strict("attributes content", `{let $a kind="attributes"}title="{$x}"{/let}<div {$a}>`,
+			`<div title="&lt;a href=&#39;x&#39;&gt;&amp;">`, x),
+		strict("uri content", `{let $u kind="uri"}/search?q={$q}{/let}<a href="{$u}">`,
+			`<a href="/search?q=a%26b">`, d{"q": "a&b"}),
+		strict("text content", `{let $t kind="text"}<i>{$x}</i>{/let}<p>{$t}</p>`,
+			`<p>&lt;i&gt;&lt;a href=&#39;x&#39;&gt;&amp;&lt;/i&gt;</p>`, x),
+		strict("comment", `<!-- <b> --><p title="{$x}">`, `<!-- <b> --><p title="&lt;a href=&#39;x&#39;&gt;&amp;">`, x),
+		strict("noAutoescape", `<p>{$x|noAutoescape}</p>`, `<p><a href='x'>&</p>`, x),
+		{"strict callee", "test.outer", `{namespace test autoescape="strict"}
+{template .outer}
+<script>var s = '{call .inner data="all" /}';</script>
+{/template}
+{template .inner}
+{$x}
+{/template}`, `<script>var s = '\u003Ca href\u003D\'x\'\u003E\u0026';</script>`, x, true},
+		{"kinded callees", "test.outer", `{namespace test autoescape="strict"}
+{template .outer}
+<a href="{call .url data="all" /}">{call .label data="all" /}</a>{call .bold data="all" /}
+{/template}
+{template .url kind="uri"}
+/search?q={$x}
+{/template}
+{template .label kind="text"}
+<i>{$x}</i>
+{/template}
+{template .bold kind="html"}
+<b>{$x}</b>
+{/template}`, `<a href="/search?q=%3Ca+href%3D%27x%27%3E%26">&lt;i&gt;&lt;a href=&#39;x&#39;&gt;&amp;&lt;/i&gt;</a>` +
+			`<b>&lt;a href=&#39;x&#39;&gt;&amp;</b>`, x, true},
+		{"kinded template", "test.js", `{namespace test autoescape="strict"}
+{template .js kind="js"}
+var s = {$x};
+{/template}`, `var s = '\u003Ca href\u003D\'x\'\u003E\u0026';`, x, true},
+	})
+}
+
+func TestContextualAutoescape(t *testing.T) {
+	var contextual = func(name, body, output string, data d) execTest {
+		return execTest{name, "test.contextual",
+			"{namespace test autoescape=\"contextual\"}\n{template .contextual}\n" + body + "\n{/template}",
+			output, data, true}
+	}
+	var x = d{"x": "<a href='x'>&"}
+	runExecTests(t, []execTest{
+		contextual("text", `<p>{$x}</p>`, `<p>&lt;a href=&#39;x&#39;&gt;&amp;</p>`, x),
+		contextual("uri attr", `<a href="{$u}">{$u}</a>`,
+			`<a href="about:invalid#zSoyz">javascript:alert(1)</a>`, d{"u": "javascript:alert(1)"}),
+		contextual("uri query", `<a href="/search?q={$q}">`, `<a href="/search?q=a%26b">`, d{"q": "a&b"}),
+		contextual("script", `<script>var s = '{$s}', n = {$n};</script>{$s}`,
+			`<script>var s = 'it\'s', n = 42;</script>it&#39;s`, d{"s": "it's", "n": 42}),
+		contextual("js after regex", `<script>var r = /'/; var s = {$s};</script>`,
+			`<script>var r = /'/; var s = '1;alert(1)';</script>`, d{"s": "1;alert(1)"}),
+		contextual("js template literal", "<script>var s = `{$s}`;</script>",
+			"<script>var s = `\\x24{alert(1)}`;</script>", d{"s": "${alert(1)}"}),
+		contextual("js division after if", `<script>var v = {if $c}a{else}(b){/if} / 2, s = '{$s}';</script>`,
+			`<script>var v = a / 2, s = 'it\'s';</script>`, d{"c": true, "s": "it's"}),
+		{"ambiguous js slash after if", "test.contextual", `{namespace test autoescape="contextual"}
+{template .contextual}
+<script>var v = {if $c}a{else}({/if}/'/; var s = {$s};</script>
+{/template}`, "", d{"c": true, "s": "1;alert(1)"}, false},
+		{"ambiguous js slash after loop", "test.contextual", `{namespace test autoescape="contextual"}
+{template .contextual}
+<script>f({foreach $i in $l}g({$i}){/foreach}/'/, {$s});</script>
+{/template}`, "", d{"l": []int{1}, "s": "1;alert(1)"}, false},
+		{"js comment", "test.contextual", `{namespace test autoescape="contextual"}
+{template .contextual}
+<script>{literal}// {/literal}{$s}</script>
+{/template}`, "", d{"s": "x"}, false},
+		contextual("style attr", `<div style="color: {$c}">`, `<div style="color: zSoyz">`, d{"c": "red;}"}),
+		contextual("if branches", `{if $b}<a href="/a?q={else}<a href="/b?q={/if}{$q}">`,
+			`<a href="/a?q=a%26b">`, d{"b": true, "q": "a&b"}),
+		{"ambiguous if branches", "test.contextual", `{namespace test autoescape="contextual"}
+{template .contextual}
+<a {if $c}title{else}href{/if}="{$u}">
+{/template}`, "", d{"c": false, "u": "javascript:alert(1)"}, false},
+		{"ambiguous if without else", "test.contextual", `{namespace test autoescape="contextual"}
+{template .contextual}
+<a href="{if $c}/x?q={/if}{$u}">
+{/template}`, "", d{"c": true, "u": "javascript:alert(1)"}, false},
+		{"ambiguous loop", "test.contextual", `{namespace test autoescape="contextual"}
+{template .contextual}
+{foreach $u in $urls}<a href="{/foreach}{$x}">
+{/template}`, "", d{"urls": []string{"/a"}, "x": "javascript:alert(1)"}, false},
+		contextual("loop", `{foreach $u in $urls}<a href="{$u}">{$u}</a>{/foreach}`,
+			`<a href="/a">/a</a><a href="about:invalid#zSoyz">javascript:x</a>`,
+			d{"urls": []string{"/a", "javascript:x"}}),
+		contextual("let", `{let $js kind="js"}alert({$s}){/let}<button onclick="{$js}">`,
+			`<button onclick="alert(&#39;it\&#39;s&#39;)">`, d{"s": "it's"}),
+		contextual("noAutoescape", `<a href="{$u|noAutoescape}">`, `<a href="javascript:x">`, d{"u": "javascript:x"}),
+		{"callee", "test.outer", `{namespace test autoescape="contextual"}
+{template .outer}
+<script>var s = {$s};</script>{call .inner data="all" /}
+{/template}
+{template .inner}
+<a href="{$s}">
+{/template}`, `<script>var s = 'javascript:x';</script><a href="about:invalid#zSoyz">`, d{"s": "javascript:x"}, true},
+	})
+}
+
+func TestMsgInAttributes(t *testing.T) {
+	for _, mode := range []string{"contextual", "strict"} {
+		var registry = template.Registry{}
+		var tree, err = parse.SoyFile("", `{namespace test autoescape="`+mode+`"}
+
+/** @param name */
+{template .save}
+  <button title="{msg desc="title"}Save "{$name}" & close{/msg}" `+
+			`onclick="alert('{msg desc="alert"}Saved {$name}{/msg}')">`+
+			`{msg desc="label"}<b>Save</b> {$name}{/msg}</button>
+{/template}`, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		registry.Add(tree)
+
+		var translations = testMessages{}
+		for _, node := range tree.Body[2].(*ast.TemplateNode).Body.Nodes {
+			if msg, ok := node.(*ast.MsgNode); ok {
+				translations[msg.ID] = &soymsg.Message{ID: msg.ID, Parts: []soymsg.Part{
+					soymsg.RawTextPart{Text: `« '<i>` + msg.Desc + `</i>" »`},
+					soymsg.PlaceholderPart{Name: "NAME"},
+				}}
+			}
+		}
+
+		var tests = []struct {
+			msgs     soymsg.Provider
+			expected string
+		}{
+			{nil, `<button title="Save &#34;&lt;Rob&gt;&#34; &amp; close" ` +
+				`onclick="alert('Saved \u003CRob\u003E')">` +
+				`<b>Save</b> &lt;Rob&gt;</button>`},
+			{translations, `<button title="« &#39;&lt;i&gt;title&lt;/i&gt;&#34; »&lt;Rob&gt;" ` +
+				`onclick="alert('« \&#39;\u003Ci\u003Ealert\u003C/i\u003E\&#34; »\u003CRob\u003E')">` +
+				`« '<i>label</i>" »&lt;Rob&gt;</button>`},
+		}
+		for _, test := range tests {
+			var buf bytes.Buffer
+			err = NewTofu(&registry).NewRenderer("test.save").
+				Messages(test.msgs).
+				Execute(&buf, data.Map{"name": data.String("<Rob>")})
+			if err != nil {
+				t.Error(err)
+			} else if buf.String() != test.expected {
+				t.Errorf("%s:\nexpected %q\ngot      %q", mode, test.expected, buf.String())
+			}
+		}
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	var src = "# Title *here*\n\nSome **bold** and _em_ text,\n`<code>` and [a link](/a_b?c=1&d=2).\n\n" +
+		"- one\n- [bad](javascript:alert)\n\n1. first\n2. <script>\n\n```\n<b> *raw*\n```\n"
+	runExecTests(t, []execTest{
+		exprtestwdata("markdown", "{$md|markdown}", "<h1>Title <em>here</em></h1>\n"+
+			"<p>Some <strong>bold</strong> and <em>em</em> text,\n"+
+			`<code>&lt;code&gt;</code> and <a href="/a_b?c=1&amp;d=2">a link</a>.</p>`+"\n"+
+			`<ul><li>one</li><li><a href="about:invalid#zSoyz">bad</a></li></ul>`+"\n"+
+			"<ol><li>first</li><li>&lt;script&gt;</li></ol>\n"+
+			"<pre><code>&lt;b&gt; *raw*</code></pre>",
+			d{"md": src}),
+	})
+
+	var registry = template.Registry{}
+	tree, err := parse.SoyFile("", `{namespace test}
+/** @param md */
+{template .md}{$md|markdown}{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+	var buf bytes.Buffer
+	var renderer = MarkdownRendererFunc(func(src string) string { return "<p>" + strings.ToUpper(src) + "</p>" })
+	err = NewTofu(&registry).Markdown(renderer).NewRenderer("test.md").
+		Execute(&buf, data.Map{"md": data.String("hi")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "<p>HI</p>" {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var strict = func(name, body, output string, data d) execTest {
+		return execTest{name, "test.strict",
+			"{namespace test autoescape=\"strict\"}\n{template .strict}\n" + body + "\n{/template}",
+			output, data, true}
+	}
+	var x = d{"x": d{"a": "</script>", "b": nil, "c": []interface{}{1, true}}}
+	const encoded = `{"a":"\u003c/script\u003e","b":null,"c":[1,true]}`
+	runExecTests(t, []execTest{
+		exprtestwdata("json directive", `{$x|json}`, encoded, x),
+		exprtestwdata("toJson", `{toJson($x)}`, html.EscapeString(encoded), x),
+		exprtestwdata("toJson undefined", `{toJson($x.missing)}`, `null`, x),
+		strict("json in script", `<script>var x = {$x|json}, y = {toJson($x)};</script>`,
+			`<script>var x = `+encoded+`, y = `+encoded+`;</script>`, x),
+		strict("json in attribute", `<div onclick="init({toJson($x)})" data-x='{$x|json}'>`,
+			`<div onclick="init(`+html.EscapeString(encoded)+`)" data-x='`+html.EscapeString(encoded)+`'>`, x),
+		strict("escapeJsString in script", `<script>var s = '{$s|escapeJsString}';</script>`,
+			`<script>var s = 'it\'s';</script>`, d{"s": "it's"}),
+		strict("escapeJsString outside string", `<script>var s = {$s|escapeJsString};</script>`,
+			`<script>var s = 'alert(1)';</script>`, d{"s": "alert(1)"}),
+		strict("escapeJsString in attribute", `<a onclick="{$s|escapeJsString}" title='{$s|escapeJsString}'>`,
+			`<a onclick="&#39;alert(1)&#39;" title='alert(1)'>`, d{"s": "alert(1)"}),
+		{"escapeJsString contextual", "test.contextual", `{namespace test autoescape="contextual"}
+{template .contextual}
+<script>var s = {$s|escapeJsString}, t = '{$s|escapeJsString}';</script>
+{/template}`, `<script>var s = 'it\\\'s', t = 'it\'s';</script>`, d{"s": "it's"}, true},
+	})
+}
+
+func TestURIDirectivesInContext(t *testing.T) {
+	var strict = func(name, body, output string, data d) execTest {
+		return execTest{name, "test.strict",
+			"{namespace test autoescape=\"strict\"}\n{template .strict}\n" + body + "\n{/template}",
+			output, data, true}
+	}
+	runExecTests(t, []execTest{
+		strict("query param", `<a href="/search?q={$q|encodeUriComponent}&amp;x=1">`,
+			`<a href="/search?q=it%27s%20a%26b&amp;x=1">`, d{"q": "it's a&b"}),
+		strict("path segment", `<a href="{$q|encodeUriComponent}">`, `<a href="a%3Ab">`, d{"q": "a:b"}),
+		strict("data uri", `<img src="data:text/plain;base64,{$s|base64Encode}">`,
+			`<img src="data:text/plain;base64,aGk%2FPg%3D%3D">`, d{"s": "hi?>"}),
+	})
+}
+
+func TestAttributesFunc(t *testing.T) {
+	var m = d{"m": d{
+		"type":        "text",
+		"value":       `"><script>`,
+		"disabled":    true,
+		"required":    false,
+		"placeholder": nil,
+		"onclick":     "alert(1)",
+		"style":       "x",
+		"bad name":    "x",
+		"href":        "javascript:alert(1)",
+		"data-id":     42,
+	}}
+	const expected = `<input data-id="42" disabled href="about:invalid#zSoyz" type="text" value="&#34;&gt;&lt;script&gt;">`
+	runExecTests(t, []execTest{
+		exprtestwdata("attributes not in a known tag", `<input {attributes(['type': 'text'])}>`,
+			`<input type=&#34;text&#34;>`, nil),
+		{"attributes contextual", "test.contextual", `{namespace test autoescape="contextual"}
+{template .contextual}
+<input {attributes($m)}>
+{/template}`, expected, m, true},
+		{"attributes strict", "test.strict", `{namespace test autoescape="strict"}
+{template .strict}
+<input {attributes($m)}>
+{/template}`, expected, m, true},
+		{"attributes not a map", "test.main", "{namespace test}{template .main}{attributes(1)}{/template}", "", nil, false},
+	})
+}
+
+type eventRecorder struct{ events []RenderEvent }
+
+func (r *eventRecorder) ObserveRender(e RenderEvent) { r.events = append(r.events, e) }
+
+func TestRenderGate(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+{template .hello}
+Hello
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var gate = NewRenderGate(1, 10*time.Millisecond)
+	var recorder eventRecorder
+	var tofu = NewTofu(&registry).Gate(gate).Observer(&recorder)
+
+	// Occupy the only slot, so that renders queue and time out.
+	if _, err = gate.enter(nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = tofu.Render(&buf, "test.hello", nil); err != ErrRenderQueueTimeout {
+		t.Errorf("expected ErrRenderQueueTimeout, got %v", err)
+	}
+	var ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err = tofu.NewRenderer("test.hello").WithContext(ctx).Execute(&buf, nil); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if gate.Running() != 1 || gate.Waiting() != 0 {
+		t.Errorf("expected 1 render running and none waiting, got %d and %d", gate.Running(), gate.Waiting())
+	}
+
+	// Once the slot is free, renders proceed.
+	gate.leave()
+	if err = tofu.Render(&buf, "test.hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "Hello" {
+		t.Errorf("expected %q, got %q", "Hello", buf.String())
+	}
+	if gate.Running() != 0 {
+		t.Errorf("expected the render to leave the gate, got %d running", gate.Running())
+	}
+
+	if len(recorder.events) != 3 {
+		t.Fatalf("expected 3 events, got %v", recorder.events)
+	}
+	if e := recorder.events[0]; e.Err != ErrRenderQueueTimeout || e.Queued < 10*time.Millisecond {
+		t.Errorf("expected a queue timeout after at least 10ms, got %+v", e)
+	}
+	if e := recorder.events[2]; e.Err != nil || e.Queued != 0 {
+		t.Errorf("expected a render without queueing, got %+v", e)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+/** @param n */
+{template .page}
+  {if $n > 1}
+    {call .many data="all" /}
+  {else}
+    one
+  {/if}
+{/template}
+/** @param n */
+{template .many}
+  {foreach $i in range($n)}{$i}{/foreach}
+{/template}
+{template .unused}
+  unused
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var usage = NewUsage()
+	var tofu = NewTofu(&registry).Usage(usage)
+	for _, n := range []int{1, 2, 3} {
+		if err = tofu.Render(ioutil.Discard, "test.page", d{"n": n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tofu.NewRenderer("test.page").Simulate(data.Map{"n": data.Int(1)}) // not counted
+
+	var report = usage.Report()
+	var expectedTemplates = []TemplateUsage{{"test.many", 2}, {"test.page", 3}}
+	var expectedBranches = []BranchUsage{
+		{"test.many", 12, "foreach", 2},
+		{"test.page", 4, "else", 1},
+		{"test.page", 4, "if", 2},
+	}
+	if report.Renders != 3 {
+		t.Errorf("expected 3 renders, got %d", report.Renders)
+	}
+	if !reflect.DeepEqual(report.Templates, expectedTemplates) {
+		t.Errorf("expected templates %v, got %v", expectedTemplates, report.Templates)
+	}
+	if !reflect.DeepEqual(report.Branches, expectedBranches) {
+		t.Errorf("expected branches %v, got %v", expectedBranches, report.Branches)
+	}
+	if unused := report.Unused(&registry); !reflect.DeepEqual(unused, []string{"test.unused"}) {
+		t.Errorf("expected test.unused to be unused, got %v", unused)
+	}
+
+	// Reports of separate periods combine.
+	usage.Reset()
+	if err = tofu.Render(ioutil.Discard, "test.page", d{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	var merged = report.Merge(usage.Report())
+	if merged.Renders != 4 || !merged.Since.Equal(report.Since) {
+		t.Errorf("unexpected merged report: %+v", merged)
+	}
+	expectedBranches[1].Count = 2
+	if !reflect.DeepEqual(merged.Branches, expectedBranches) {
+		t.Errorf("expected merged branches %v, got %v", expectedBranches, merged.Branches)
+	}
+
+	// Branches of a reloaded registry are counted with those of the original.
+	var reloaded = template.Registry{}
+	if tree, err = parse.SoyFile("", registry.SoyFiles[0].Text, nil); err != nil {
+		t.Fatal(err)
+	}
+	reloaded.Add(tree)
+	if err = NewTofu(&reloaded).Usage(usage).Render(ioutil.Discard, "test.page", d{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if branches := usage.Report().Branches; len(branches) != 1 || branches[0].Count != 2 {
+		t.Errorf("expected one else branch taken twice, got %v", branches)
+	}
+}
@@ -0,0 +1,47 @@
+package soyhtml
+
+import (
+	"compress/gzip"
+	"io"
+
+	"github.com/harrisonzhao/soy/data"
+)
+
+// OutputFilter wraps the writer that a template is rendered to, transforming
+// the output as it is written.  The returned writer is closed at the end of a
+// successful render, at which point it should write any output it is holding
+// back.  It must not close the writer it wraps.
+type OutputFilter func(io.Writer) io.WriteCloser
+
+// Gzip is an OutputFilter that compresses the output with gzip.  It should be
+// the last filter in a chain.
+func Gzip(w io.Writer) io.WriteCloser {
+	return gzip.NewWriter(w)
+}
+
+// Filter adds the given filters to those applied to the output of every render
+// of the given kind of content (see Renderer.Kind), after any filters
+// installed on the renderer itself.  For example, HTML pages might be
+// minified and compressed while text emails are left alone:
+//
+//	tofu.Filter(data.KindHTML, soyhtml.CollapseWhitespace, soyhtml.Gzip)
+func (tofu *Tofu) Filter(kind data.ContentKind, filters ...OutputFilter) *Tofu {
+	if tofu.filters == nil {
+		tofu.filters = make(map[data.ContentKind][]OutputFilter)
+	}
+	tofu.filters[kind] = append(tofu.filters[kind], filters...)
+	return tofu
+}
+
+// filterChain wraps the given writer in the given filters, so that each
+// receives the output of the one before it.  It returns the writer for the
+// first filter, and the filters in order, to be closed at the end of the
+// render.
+func filterChain(wr io.Writer, filters []OutputFilter) (io.Writer, []io.WriteCloser) {
+	var closers = make([]io.WriteCloser, len(filters))
+	for i := len(filters) - 1; i >= 0; i-- {
+		closers[i] = filters[i](wr)
+		wr = closers[i]
+	}
+	return wr, closers
+}
@@ -0,0 +1,38 @@
+package soyhtml
+
+import (
+	"context"
+
+	"github.com/harrisonzhao/soy/data"
+)
+
+// FlagProvider reports whether feature flags (e.g. experiments) are enabled.
+// It is consulted by the flagEnabled() function, with the context of the
+// render, which may identify the user or request.
+type FlagProvider interface {
+	FlagEnabled(ctx context.Context, name string) bool
+}
+
+// FlagProviderFunc adapts an ordinary function to a FlagProvider.
+type FlagProviderFunc func(ctx context.Context, name string) bool
+
+// FlagEnabled calls f(ctx, name).
+func (f FlagProviderFunc) FlagEnabled(ctx context.Context, name string) bool {
+	return f(ctx, name)
+}
+
+// funcFlagEnabled reports whether the named flag is enabled.  Without a
+// FlagProvider, flags are read from the $ij.flags map, as in javascript.
+func funcFlagEnabled(fc FuncContext, v []data.Value) data.Value {
+	var name, ok = v[0].(data.String)
+	if !ok {
+		panic("flagEnabled: expected a string flag name")
+	}
+	if fc.Flags != nil {
+		return data.Bool(fc.Flags.FlagEnabled(fc.Context, string(name)))
+	}
+	if flags, ok := fc.IJ.Key("flags").(data.Map); ok {
+		return data.Bool(flags.Key(string(name)).Truthy())
+	}
+	return data.Bool(false)
+}
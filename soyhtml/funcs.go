@@ -1,9 +1,14 @@
 package soyhtml
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"math"
 	"math/rand"
+	"sort"
 	"strings"
+	"text/template"
 
 	"github.com/harrisonzhao/soy/data"
 )
@@ -29,28 +34,93 @@ func funcIsLast(s *state, key string) data.Value {
 		s.context.lookup(key+"__index").(data.Int) == s.context.lookup(key+"__lastIndex").(data.Int))
 }
 
+// debugFuncs are functions that inspect the render state, available only when
+// the Tofu is in debug mode.
+var debugFuncs = map[string]func(s *state) data.Value{
+	"dumpScope": funcDumpScope,
+}
+
+// funcDumpScope returns the variables in scope (params, lets, and loop
+// variables) as pretty-printed JSON.
+func funcDumpScope(s *state) data.Value {
+	var vars = make(data.Map)
+	for _, frame := range s.context {
+		for k, v := range frame.vars {
+			if strings.Contains(k, "__") {
+				continue // loop bookkeeping
+			}
+			vars[k] = v
+		}
+	}
+	var j, err = json.MarshalIndent(vars, "", "  ")
+	if err != nil {
+		panic(fmt.Errorf("Error JSON encoding scope: %v", err))
+	}
+	return data.String(j)
+}
+
 // Func represents a soy function that may be invoked within a soy template.
+//
+// Functions that depend on the render (e.g. the locale or $ij) may provide
+// ApplyContext instead of Apply.  If set, it is called in preference to Apply.
 type Func struct {
 	Apply           func([]data.Value) data.Value
 	ValidArgLengths []int
+	ApplyContext    func(FuncContext, []data.Value) data.Value
+}
+
+// FuncContext describes the render in progress to functions and print
+// directives that request it.
+type FuncContext struct {
+	Context  context.Context  // context of the render (never nil)
+	Locale   string           // locale of the render, or "" if unspecified
+	IJ       data.Map         // the $ij injected data
+	Template string           // fully-qualified name of the calling template
+	Flags    FlagProvider     // feature flags of the render, or nil
+	URLs     URLMapper        // maps URLs to their localized variants, or nil
+	Markdown MarkdownRenderer // renders the |markdown directive, or nil for SafeMarkdown
+	Collator Collator         // compares strings for sort(), or nil
 }
 
 // Funcs contains the builtin soy functions.
 // Callers may add their own functions to this map as well.
 var Funcs = map[string]Func{
-	"isNonnull":   {funcIsNonnull, []int{1}},
-	"length":      {funcLength, []int{1}},
-	"keys":        {funcKeys, []int{1}},
-	"augmentMap":  {funcAugmentMap, []int{2}},
-	"round":       {funcRound, []int{1, 2}},
-	"floor":       {funcFloor, []int{1}},
-	"ceiling":     {funcCeiling, []int{1}},
-	"min":         {funcMin, []int{2}},
-	"max":         {funcMax, []int{2}},
-	"randomInt":   {funcRandomInt, []int{1}},
-	"strContains": {funcStrContains, []int{2}},
-	"range":       {funcRange, []int{1, 2, 3}},
-	"hasData":     {funcHasData, []int{0}},
+	"isNonnull":   {funcIsNonnull, []int{1}, nil},
+	"length":      {funcLength, []int{1}, nil},
+	"keys":        {funcKeys, []int{1}, nil},
+	"augmentMap":  {funcAugmentMap, []int{2}, nil},
+	"round":       {funcRound, []int{1, 2}, nil},
+	"floor":       {funcFloor, []int{1}, nil},
+	"ceiling":     {funcCeiling, []int{1}, nil},
+	"min":         {funcMin, []int{2}, nil},
+	"max":         {funcMax, []int{2}, nil},
+	"randomInt":   {funcRandomInt, []int{1}, nil},
+	"strContains": {funcStrContains, []int{2}, nil},
+	"range":       {funcRange, []int{1, 2, 3}, nil},
+	"hasData":     {funcHasData, []int{0}, nil},
+	"flagEnabled": {nil, []int{1}, funcFlagEnabled},
+	"localizeUri": {nil, []int{1, 2}, funcLocalizeUri},
+	"sort":        {nil, []int{1, 2}, funcSort},
+	"sortDesc":    {nil, []int{1, 2}, funcSortDesc},
+	"toJson":      {funcToJson, []int{1}, nil},
+	"attributes":  {funcAttributes, []int{1}, nil},
+}
+
+// PureFuncs names the functions whose result depends only on their arguments,
+// so that calls with constant arguments may be evaluated when templates are
+// compiled (see parsepasses.FoldConstants).  Callers may add their own
+// functions to this map as well.
+var PureFuncs = map[string]bool{
+	"isNonnull":   true,
+	"length":      true,
+	"augmentMap":  true,
+	"round":       true,
+	"floor":       true,
+	"ceiling":     true,
+	"min":         true,
+	"max":         true,
+	"strContains": true,
+	"range":       true,
 }
 
 func funcIsNonnull(v []data.Value) data.Value {
@@ -176,3 +246,53 @@ func funcRange(v []data.Value) data.Value {
 func funcHasData(v []data.Value) data.Value {
 	return data.Bool(true)
 }
+
+// funcToJson encodes the value as JSON, as the |json directive does.  In
+// strict and contextual templates, the result is JS content that may be
+// printed directly in a <script> block or event handler attribute.
+func funcToJson(v []data.Value) data.Value {
+	return toJSON(v[0])
+}
+
+// funcAttributes converts a map of attribute names to values into attributes
+// content, which may be printed within a tag, e.g. <input {attributes($m)}>.
+//
+// Attributes are written in order of name, with their values HTML-escaped and
+// quoted.  A true value writes the attribute without a value, while false,
+// null, and undefined values omit it.  Attributes that can run script (event
+// handlers, style, srcdoc) or that have invalid names are dropped, and URI
+// attribute values (e.g. href) with unsafe schemes are replaced.
+func funcAttributes(v []data.Value) data.Value {
+	var attrs, ok = v[0].(data.Map)
+	if !ok {
+		panic(fmt.Errorf("attributes: expected a map, got %T", v[0]))
+	}
+	var names []string
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		var lower = strings.ToLower(name)
+		if !safeNamePattern.MatchString(name) || strings.HasPrefix(lower, "on") ||
+			lower == "style" || lower == "srcdoc" {
+			continue
+		}
+		switch val := attrs[name].(type) {
+		case data.Undefined, data.Null:
+		case data.Bool:
+			if val {
+				parts = append(parts, name)
+			}
+		default:
+			var str = val.String()
+			if uriAttrs[lower] {
+				str = directiveFilterNormalizeUri(val, nil).String()
+			}
+			parts = append(parts, name+`="`+template.HTMLEscapeString(str)+`"`)
+		}
+	}
+	return data.SanitizedContent{data.KindAttributes, strings.Join(parts, " ")}
+}
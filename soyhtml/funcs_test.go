@@ -1,9 +1,14 @@
 package soyhtml
 
 import (
+	"bytes"
+	"context"
+	"strings"
 	"testing"
 
 	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/template"
 )
 
 var rangeTests = []struct{ args, result []int }{
@@ -272,3 +277,258 @@ func TestKeys(t *testing.T) {
 		}
 	}
 }
+
+func TestFuncContext(t *testing.T) {
+	Funcs["localeOf"] = Func{nil, []int{0}, func(fc FuncContext, _ []data.Value) data.Value {
+		return data.String(fc.Template + " " + fc.Locale + " " + fc.IJ.Key("foo").String())
+	}}
+	defer delete(Funcs, "localeOf")
+
+	var registry = template.Registry{}
+	tree, err := parse.SoyFile("", `{namespace test}
+{template .locale}{localeOf()}{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var buf bytes.Buffer
+	err = NewTofu(&registry).NewRenderer("test.locale").
+		Inject(data.Map{"foo": data.String("bar")}).
+		Locale("fr").
+		Execute(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "test.locale fr bar" {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
+
+type traceKey struct{}
+
+func TestDirectiveContext(t *testing.T) {
+	PrintDirectives["traced"] = PrintDirective{nil, []int{0}, false, "",
+		func(fc FuncContext, value data.Value, _ []data.Value) data.Value {
+			if err := fc.Context.Err(); err != nil {
+				panic(err)
+			}
+			return data.String(fc.Context.Value(traceKey{}).(string) + ":" + value.String())
+		}}
+	defer delete(PrintDirectives, "traced")
+
+	var registry = template.Registry{}
+	tree, err := parse.SoyFile("", `{namespace test}
+{template .traced}{'<a>'|traced}{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var ctx, cancel = context.WithCancel(context.WithValue(context.Background(), traceKey{}, "trace-1"))
+	var buf bytes.Buffer
+	err = NewTofu(&registry).NewRenderer("test.traced").
+		WithContext(ctx).
+		Execute(&buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "trace-1:&lt;a&gt;" {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+
+	cancel()
+	err = NewTofu(&registry).NewRenderer("test.traced").
+		WithContext(ctx).
+		Execute(&buf, nil)
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("expected the directive to observe cancellation, got %v", err)
+	}
+}
+
+func TestFlagProvider(t *testing.T) {
+	var registry = template.Registry{}
+	tree, err := parse.SoyFile("", `{namespace test}
+{template .flags}{flagEnabled('a')} {flagEnabled('b')}{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	type userKey struct{}
+	var provider = FlagProviderFunc(func(ctx context.Context, name string) bool {
+		return name == "a" && ctx.Value(userKey{}) == "beta"
+	})
+	var tofu = NewTofu(&registry).Flags(provider)
+	for user, expected := range map[string]string{"beta": "true false", "other": "false false"} {
+		var buf bytes.Buffer
+		var ctx = context.WithValue(context.Background(), userKey{}, user)
+		err = tofu.NewRenderer("test.flags").WithContext(ctx).Execute(&buf, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expected {
+			t.Errorf("%s: expected %q, got %q", user, expected, buf.String())
+		}
+	}
+}
+
+func TestURLMapper(t *testing.T) {
+	var registry = template.Registry{}
+	tree, err := parse.SoyFile("", `{namespace test}
+/** @param path */
+{template .links}
+<a href="{localizeUri($path)}">
+{foreach $locale in ['en', 'fr']}
+  <link rel="alternate" hreflang="{$locale}" href="{localizeUri($path, $locale)}">
+{/foreach}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var render = func(tofu *Tofu) string {
+		var buf bytes.Buffer
+		err = tofu.NewRenderer("test.links").Locale("de").
+			Execute(&buf, data.Map{"path": data.String("/about?a=1&b=2")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	var mapper = URLMapperFunc(func(ctx context.Context, locale, url string) string {
+		if locale == "en" {
+			return url
+		}
+		return "/" + locale + url
+	})
+	var expected = `<a href="/de/about?a=1&amp;b=2">` +
+		`<link rel="alternate" hreflang="en" href="/about?a=1&amp;b=2">` +
+		`<link rel="alternate" hreflang="fr" href="/fr/about?a=1&amp;b=2">`
+	if actual := render(NewTofu(&registry).URLMapper(mapper)); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+
+	expected = `<a href="/about?a=1&amp;b=2">` +
+		`<link rel="alternate" hreflang="en" href="/about?a=1&amp;b=2">` +
+		`<link rel="alternate" hreflang="fr" href="/about?a=1&amp;b=2">`
+	if actual := render(NewTofu(&registry)); actual != expected {
+		t.Errorf("without a mapper: expected %q, got %q", expected, actual)
+	}
+
+	// Mapped strings are filtered as URLs, while URI content stays trusted.
+	tree, err = parse.SoyFile("", `{namespace strict autoescape="strict"}
+/** @param path @param safe */
+{template .links}
+<a href="{localizeUri($path)}"><a href="{localizeUri($safe)}">
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+	var scheme = URLMapperFunc(func(ctx context.Context, locale, url string) string {
+		return "javascript:" + url
+	})
+	var buf bytes.Buffer
+	err = NewTofu(&registry).URLMapper(scheme).NewRenderer("strict.links").
+		Execute(&buf, data.Map{"path": data.String("go()"), "safe": data.SafeURL("go()")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected = `<a href="about:invalid#zSoyz"><a href="javascript:go%28%29">`; buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestSort(t *testing.T) {
+	var registry = template.Registry{}
+	tree, err := parse.SoyFile("", `{namespace test}
+/** @param names @param people */
+{template .sorted}
+{foreach $n in sort($names)}{$n} {/foreach}|
+{foreach $n in sortDesc($names)}{$n} {/foreach}|
+{foreach $p in sort($people, 'name')}{$p.id} {/foreach}|
+{foreach $p in sortDesc($people, 'name')}{$p.id} {/foreach}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var render = func(tofu *Tofu) string {
+		var buf bytes.Buffer
+		err = tofu.NewRenderer("test.sorted").Locale("sv").Execute(&buf, data.Map{
+			"names": data.New([]interface{}{"b", nil, "Ä", 10, "a", "B", 9.5}),
+			"people": data.New([]map[string]interface{}{
+				{"id": 1, "name": "bo"},
+				{"id": 2},
+				{"id": 3, "name": "Al"},
+				{"id": 4, "name": "al"},
+				{"id": 5, "name": "Al"},
+			}),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	// Without a collator, strings are compared case-insensitively and then by
+	// their bytes, and nulls (including missing fields) sort last.
+	var expected = "9.5 10 a B b Ä null |Ä b B a 10 9.5 null |3 5 4 1 2 |1 4 3 5 2 "
+	if actual := render(NewTofu(&registry)); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+
+	// In Swedish, Ä sorts after Z.
+	var collator = CollatorFunc(func(ctx context.Context, locale, a, b string) int {
+		if locale != "sv" {
+			t.Errorf("unexpected locale %q", locale)
+		}
+		var key = func(s string) string {
+			return strings.Replace(strings.ToLower(s), "ä", "{", -1)
+		}
+		return strings.Compare(key(a), key(b))
+	})
+	expected = "9.5 10 a b B Ä null |Ä b B a 10 9.5 null |3 4 5 1 2 |1 3 4 5 2 "
+	if actual := render(NewTofu(&registry).Collator(collator)); actual != expected {
+		t.Errorf("with a collator: expected %q, got %q", expected, actual)
+	}
+}
+
+func TestDumpScope(t *testing.T) {
+	var registry = template.Registry{}
+	tree, err := parse.SoyFile("", `{namespace test}
+/** @param name */
+{template .dump}
+{let $greeting: 'Hi' /}
+{foreach $x in [1]}{$greeting}{dumpScope()|noAutoescape}{/foreach}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var buf bytes.Buffer
+	var tofu = NewTofu(&registry)
+	err = tofu.NewRenderer("test.dump").Execute(&buf, data.Map{"name": data.String("Rob")})
+	if err == nil {
+		t.Error("expected dumpScope() to fail outside of debug mode")
+	}
+
+	buf.Reset()
+	err = tofu.Debug(true).NewRenderer("test.dump").Execute(&buf, data.Map{"name": data.String("Rob")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = `Hi{
+  "greeting": "Hi",
+  "name": "Rob",
+  "x": 1
+}`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,90 @@
+package soyhtml
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRenderQueueTimeout is returned by renders that waited longer than the
+// render gate's timeout for their turn to render.
+var ErrRenderQueueTimeout = errors.New("timed out waiting to render")
+
+// RenderGate limits the number of renders that run at once, for services where
+// rendering competes with latency-critical work.  Renders beyond the limit wait
+// in a queue for their turn, until the gate's timeout or the cancellation of
+// the render's context (see Renderer.WithContext).
+//
+// The time each render spent queued is reported to the observer (see
+// RenderEvent.Queued).  Note that a render which renders another template of
+// the same Tofu, e.g. from a function, may deadlock once the gate is full.
+type RenderGate struct {
+	slots   chan struct{} // holds a value for each render running
+	timeout time.Duration
+	waiting int64 // number of renders queued, accessed atomically
+}
+
+// NewRenderGate returns a gate allowing up to maxConcurrent renders to run at
+// once, queueing the rest for up to the given timeout, or indefinitely if the
+// timeout is not positive.
+func NewRenderGate(maxConcurrent int, timeout time.Duration) *RenderGate {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return &RenderGate{slots: make(chan struct{}, maxConcurrent), timeout: timeout}
+}
+
+// Gate sets the gate that limits the renders running at once, or nil for no
+// limit.  A gate may be shared between Tofus to limit their renders together.
+func (tofu *Tofu) Gate(gate *RenderGate) *Tofu {
+	tofu.gate = gate
+	return tofu
+}
+
+// Running returns the number of renders running through the gate.
+func (g *RenderGate) Running() int {
+	return len(g.slots)
+}
+
+// Waiting returns the number of renders queued at the gate.
+func (g *RenderGate) Waiting() int {
+	return int(atomic.LoadInt64(&g.waiting))
+}
+
+// enter waits for a turn to render, returning the time spent queued.  If it
+// returns no error, the caller must call leave once the render completes.
+func (g *RenderGate) enter(ctx context.Context) (time.Duration, error) {
+	select {
+	case g.slots <- struct{}{}:
+		return 0, nil
+	default:
+	}
+
+	var start = time.Now()
+	atomic.AddInt64(&g.waiting, 1)
+	defer atomic.AddInt64(&g.waiting, -1)
+	var timeout <-chan time.Time
+	if g.timeout > 0 {
+		var timer = time.NewTimer(g.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	select {
+	case g.slots <- struct{}{}:
+		return time.Since(start), nil
+	case <-timeout:
+		return time.Since(start), ErrRenderQueueTimeout
+	case <-done:
+		return time.Since(start), ctx.Err()
+	}
+}
+
+// leave ends a render that entered the gate.
+func (g *RenderGate) leave() {
+	<-g.slots
+}
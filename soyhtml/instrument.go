@@ -0,0 +1,84 @@
+package soyhtml
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Instrumenter is notified as each template is rendered, including the
+// templates called by others, e.g. to export the latency of individual
+// templates to a metrics system.  It must be safe for concurrent use.
+type Instrumenter interface {
+	// OnTemplateStart is called as the template of the given name begins to
+	// render, with the context of the render.
+	OnTemplateStart(ctx context.Context, template string)
+
+	// OnTemplateEnd is called once the template has rendered, or failed to.
+	OnTemplateEnd(ctx context.Context, event TemplateEvent)
+}
+
+// TemplateEvent describes the render of a template.
+type TemplateEvent struct {
+	Template string        // fully-qualified name of the template
+	Caller   string        // the template that called it, or "" if it was rendered directly
+	Elapsed  time.Duration // time spent rendering it, including the templates it called
+	Bytes    int64         // bytes rendered by it and the templates it called (see Tofu.Instrument)
+	Err      error         // the error that the render failed with, or nil
+}
+
+// Instrument sets the instrumenter notified as each template is rendered.
+// Simulated renders (see Renderer.Simulate) are not instrumented.
+//
+// The bytes of a template are counted as rendered, before any filters, and
+// include content rendered to values (e.g. by {let} or {param}), so content
+// that is later printed is counted twice.
+func (tofu *Tofu) Instrument(metrics Instrumenter) *Tofu {
+	tofu.metrics = metrics
+	return tofu
+}
+
+// walkTemplate renders the current template, called by the given template (or
+// "" if it is rendered directly), notifying the instrumenter, if any.
+func (s *state) walkTemplate(caller string) {
+	if s.metrics == nil {
+		s.walk(s.tmpl.Node)
+		return
+	}
+	var ctx = s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var event = TemplateEvent{Template: s.tmpl.Node.Name, Caller: caller}
+	s.metrics.OnTemplateStart(ctx, event.Template)
+	var start, written = time.Now(), *s.written
+	var end = func() {
+		event.Elapsed = time.Since(start)
+		event.Bytes = *s.written - written
+		s.metrics.OnTemplateEnd(ctx, event)
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			if err, ok := e.(error); ok {
+				event.Err = err
+			} else {
+				event.Err = fmt.Errorf("%v", e)
+			}
+			end()
+			panic(e)
+		}
+	}()
+	s.walk(s.tmpl.Node)
+	end()
+}
+
+// countContent returns the writer of content rendered to the given buffer,
+// counting its bytes if the render is instrumented.
+func (s *state) countContent(buf *bytes.Buffer) io.Writer {
+	if s.written == nil {
+		return buf
+	}
+	return countingWriter{buf, s.written}
+}
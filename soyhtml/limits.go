@@ -0,0 +1,115 @@
+package soyhtml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DefaultMaxCallDepth is the maximum depth of calls of renders that do not
+// set one, which is far deeper than templates nest in practice, but stops
+// runaway recursion well before it would overflow the stack.
+const DefaultMaxCallDepth = 1000
+
+// MaxCallDepth sets the maximum depth of calls of the renders of the Tofu's
+// templates, i.e. the number of templates being rendered at once, including
+// the template rendered by the Renderer.  Renders exceeding it fail with a
+// *CallDepthError.  Zero selects DefaultMaxCallDepth, and a negative depth
+// disables the limit.  Renderers may override it (see Renderer.MaxCallDepth).
+func (tofu *Tofu) MaxCallDepth(n int) *Tofu {
+	tofu.depth = n
+	return tofu
+}
+
+// MaxOutputBytes sets the limit on the output of the renders of the Tofu's
+// templates, for renderers that do not set one (see Renderer.MaxOutputBytes).
+func (tofu *Tofu) MaxOutputBytes(n int64) *Tofu {
+	tofu.maxBytes = n
+	return tofu
+}
+
+// MaxCallDepth sets the maximum depth of calls of the render, overriding that
+// of the Tofu (see Tofu.MaxCallDepth).
+func (r *Renderer) MaxCallDepth(n int) *Renderer {
+	r.depth = n
+	return r
+}
+
+// maxCallDepth returns the maximum depth of calls of the render, or 0 if it
+// is unlimited.
+func (t Renderer) maxCallDepth() int {
+	var depth = t.depth
+	if depth == 0 {
+		depth = t.tofu.depth
+	}
+	switch {
+	case depth == 0:
+		return DefaultMaxCallDepth
+	case depth < 0:
+		return 0
+	}
+	return depth
+}
+
+// maxOutputBytes returns the limit on the output of the render, if positive.
+func (t Renderer) maxOutputBytes() int64 {
+	if t.limit > 0 {
+		return t.limit
+	}
+	return t.tofu.maxBytes
+}
+
+// ErrCallDepthExceeded matches (via errors.Is) the error returned when a
+// render exceeds its maximum call depth.
+var ErrCallDepthExceeded = errors.New("template call depth exceeded")
+
+// CallDepthError is returned when a render exceeds its maximum call depth,
+// typically because a template calls itself without end.
+type CallDepthError struct {
+	Limit int      // the depth that was exceeded
+	Stack []string // templates being rendered, outermost first, including the call that failed
+	Cycle []string // the innermost cycle of calls, from a template back to itself, if any
+}
+
+func (e *CallDepthError) Error() string {
+	if len(e.Cycle) > 0 {
+		return fmt.Sprintf("template call depth exceeded %d, by recursion through %s",
+			e.Limit, strings.Join(e.Cycle, " > "))
+	}
+	return fmt.Sprintf("template call depth exceeded %d, in %s",
+		e.Limit, strings.Join(e.Stack, " > "))
+}
+
+// Is reports whether target is ErrCallDepthExceeded.
+func (e *CallDepthError) Is(target error) bool {
+	return target == ErrCallDepthExceeded
+}
+
+// checkCallDepth fails the render if calling the template of the given name
+// would exceed its maximum call depth.
+func (s *state) checkCallDepth(name string) {
+	if s.maxDepth <= 0 || len(*s.stack) < s.maxDepth {
+		return
+	}
+	var stack = append(append([]string(nil), *s.stack...), name)
+	var err = &CallDepthError{Limit: s.maxDepth, Stack: stack}
+	for i := len(stack) - 2; i >= 0; i-- {
+		if stack[i] == name {
+			err.Cycle = stack[i:]
+			break
+		}
+	}
+	panic(err)
+}
+
+// contentWriter returns the writer of content rendered to the given buffer
+// (e.g. of a {let} or {param}), which is subject to the limit on output, so
+// that content that is not printed may not exhaust memory either.
+func (s *state) contentWriter(buf *bytes.Buffer) io.Writer {
+	if s.maxOutput <= 0 {
+		return s.countContent(buf)
+	}
+	return &limitWriter{s.countContent(buf), s.maxOutput, s.maxOutput, s.stack}
+}
@@ -0,0 +1,56 @@
+package soyhtml
+
+import (
+	"context"
+
+	"github.com/harrisonzhao/soy/data"
+)
+
+// URLMapper maps URLs to their variants for a locale, e.g. "/about" to
+// "/fr/about" or "https://example.fr/about".  It is consulted by the
+// localizeUri() function:
+//
+//	<a href="{localizeUri('/about')}">
+//	{foreach $locale in $ij.locales}
+//	  <link rel="alternate" hreflang="{$locale}" href="{localizeUri($path, $locale)}">
+//	{/foreach}
+//
+// which maps the URL for the locale of the render, or the locale given.
+type URLMapper interface {
+	LocalizeURL(ctx context.Context, locale, url string) string
+}
+
+// URLMapperFunc adapts an ordinary function to a URLMapper.
+type URLMapperFunc func(ctx context.Context, locale, url string) string
+
+// LocalizeURL calls f(ctx, locale, url).
+func (f URLMapperFunc) LocalizeURL(ctx context.Context, locale, url string) string {
+	return f(ctx, locale, url)
+}
+
+// URLMapper sets the mapper consulted by the localizeUri() function.  Without
+// a mapper, URLs are unchanged.
+func (tofu *Tofu) URLMapper(mapper URLMapper) *Tofu {
+	tofu.urls = mapper
+	return tofu
+}
+
+// funcLocalizeUri returns the variant of a URL for the locale of the render,
+// or the given locale.  A mapped URL is only as trusted as the URL mapped:
+// URI content (e.g. data.SafeURL) remains content of its kind, and any other
+// value is mapped to a string, which is filtered where it is printed.
+func funcLocalizeUri(fc FuncContext, v []data.Value) data.Value {
+	var locale = fc.Locale
+	if len(v) == 2 {
+		locale = v[1].String()
+	}
+	if fc.URLs == nil {
+		return v[0]
+	}
+	var url = fc.URLs.LocalizeURL(fc.Context, locale, v[0].String())
+	if content, ok := v[0].(data.SanitizedContent); ok &&
+		(content.Kind == data.KindURI || content.Kind == data.KindTrustedResourceURI) {
+		return data.SanitizedContent{content.Kind, url}
+	}
+	return data.String(url)
+}
@@ -0,0 +1,159 @@
+package soyhtml
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/harrisonzhao/soy/data"
+)
+
+// MarkdownRenderer renders markdown to HTML for the |markdown print directive.
+// The HTML it returns is printed without escaping, so it must not pass
+// through markup or unsafe URLs from the source, which is typically user
+// generated.
+type MarkdownRenderer interface {
+	RenderMarkdown(src string) string
+}
+
+// MarkdownRendererFunc adapts an ordinary function to a MarkdownRenderer.
+type MarkdownRendererFunc func(src string) string
+
+// RenderMarkdown calls f(src).
+func (f MarkdownRendererFunc) RenderMarkdown(src string) string {
+	return f(src)
+}
+
+// Markdown sets the renderer used by the |markdown print directive.  Without
+// a renderer, SafeMarkdown is used.
+func (tofu *Tofu) Markdown(renderer MarkdownRenderer) *Tofu {
+	tofu.markdown = renderer
+	return tofu
+}
+
+// directiveMarkdown renders the value as markdown, producing HTML.
+func directiveMarkdown(fc FuncContext, value data.Value, _ []data.Value) data.Value {
+	if fc.Markdown != nil {
+		return data.SanitizedContent{data.KindHTML, fc.Markdown.RenderMarkdown(value.String())}
+	}
+	return data.SanitizedContent{data.KindHTML, SafeMarkdown(value.String())}
+}
+
+var (
+	markdownHeadingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	markdownULPattern      = regexp.MustCompile(`^[-*+]\s+(.*)$`)
+	markdownOLPattern      = regexp.MustCompile(`^\d+[.)]\s+(.*)$`)
+	markdownSpanPattern    = regexp.MustCompile("`([^`]+)`|\\[([^\\]]+)\\]\\(([^)\\s]+)\\)")
+	markdownStrongPattern  = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	markdownEmPattern      = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+)
+
+// SafeMarkdown renders a safe subset of markdown to HTML: paragraphs,
+// headings, unordered and ordered lists, fenced code blocks, and inline
+// emphasis, code, and links.  All other text, including any HTML, is escaped,
+// and links with unsafe schemes (e.g. javascript:) are replaced.
+func SafeMarkdown(src string) string {
+	var md markdown
+	var lines = strings.Split(strings.Replace(src, "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		var line = strings.TrimSpace(lines[i])
+		var match []string
+		switch {
+		case strings.HasPrefix(line, "```"):
+			md.endBlock()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			md.block("<pre><code>" + template.HTMLEscapeString(strings.Join(code, "\n")) + "</code></pre>")
+		case line == "":
+			md.endBlock()
+		case markdownHeadingPattern.MatchString(line):
+			md.endBlock()
+			match = markdownHeadingPattern.FindStringSubmatch(line)
+			var tag = "h" + strconv.Itoa(len(match[1]))
+			md.block("<" + tag + ">" + markdownInline(match[2]) + "</" + tag + ">")
+		case markdownULPattern.MatchString(line):
+			md.item("ul", markdownULPattern.FindStringSubmatch(line)[1])
+		case markdownOLPattern.MatchString(line):
+			md.item("ol", markdownOLPattern.FindStringSubmatch(line)[1])
+		default:
+			if md.list != "" {
+				md.endBlock()
+			}
+			md.para = append(md.para, line)
+		}
+	}
+	md.endBlock()
+	return strings.Join(md.blocks, "\n")
+}
+
+// markdown accumulates the blocks of HTML rendered from markdown.
+type markdown struct {
+	blocks []string
+	para   []string // lines of the current paragraph
+	list   string   // tag of the current list, or "" if none
+	items  []string // items of the current list, rendered
+}
+
+// block adds a complete block.
+func (md *markdown) block(html string) {
+	md.blocks = append(md.blocks, html)
+}
+
+// item adds an item to the current list of the given tag, ending the current
+// block if it is not such a list.
+func (md *markdown) item(list, text string) {
+	if md.list != list {
+		md.endBlock()
+		md.list = list
+	}
+	md.items = append(md.items, "<li>"+markdownInline(text)+"</li>")
+}
+
+// endBlock ends the current paragraph or list, if any.
+func (md *markdown) endBlock() {
+	if len(md.para) > 0 {
+		md.block("<p>" + markdownInline(strings.Join(md.para, "\n")) + "</p>")
+		md.para = nil
+	}
+	if md.list != "" {
+		md.block("<" + md.list + ">" + strings.Join(md.items, "") + "</" + md.list + ">")
+		md.list, md.items = "", nil
+	}
+}
+
+// markdownInline renders the inline code, links, and emphasis within text.
+func markdownInline(text string) string {
+	var buf bytes.Buffer
+	for text != "" {
+		var loc = markdownSpanPattern.FindStringSubmatchIndex(text)
+		if loc == nil {
+			break
+		}
+		buf.WriteString(markdownEmphasis(text[:loc[0]]))
+		if loc[2] != -1 {
+			buf.WriteString("<code>" + template.HTMLEscapeString(text[loc[2]:loc[3]]) + "</code>")
+		} else {
+			var url = text[loc[6]:loc[7]]
+			if !safeURIPattern.MatchString(url) {
+				url = innocuousURI
+			}
+			buf.WriteString(`<a href="` + template.HTMLEscapeString(normalizeURI(html.UnescapeString(url))) + `">` +
+				markdownEmphasis(text[loc[4]:loc[5]]) + "</a>")
+		}
+		text = text[loc[1]:]
+	}
+	buf.WriteString(markdownEmphasis(text))
+	return buf.String()
+}
+
+// markdownEmphasis escapes text, rendering its strong and emphasized spans.
+func markdownEmphasis(text string) string {
+	var escaped = template.HTMLEscapeString(text)
+	escaped = markdownStrongPattern.ReplaceAllString(escaped, "<strong>$1$2</strong>")
+	return markdownEmPattern.ReplaceAllString(escaped, "<em>$1$2</em>")
+}
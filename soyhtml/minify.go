@@ -0,0 +1,206 @@
+package soyhtml
+
+import (
+	"bytes"
+	"io"
+)
+
+// CollapseWhitespace is an OutputFilter that replaces each run of whitespace
+// between two tags with a single space.  Whitespace within <pre>, <textarea>,
+// <script>, and <style> elements is left alone.
+func CollapseWhitespace(w io.Writer) io.WriteCloser {
+	return &minifier{w: w, collapse: true}
+}
+
+// StripComments is an OutputFilter that removes HTML comments, except for
+// conditional comments (<!--[if ...]>).  Comments within <textarea>,
+// <script>, and <style> elements are left alone.
+func StripComments(w io.Writer) io.WriteCloser {
+	return &minifier{w: w, strip: true}
+}
+
+// minifier states.
+const (
+	minText       = iota // text content
+	minTag               // within a tag
+	minComment           // within a comment that is kept
+	minStripped          // within a comment that is removed
+	minRawElement        // within the text of a raw text element, e.g. <script>
+)
+
+// rawElements are the elements whose content is not markup.
+var rawElements = map[string]bool{"script": true, "style": true, "textarea": true}
+
+// minifier implements the HTML minifying filters.  Input that can not be
+// handled until more is written (e.g. a partial "<!--") is held back.
+type minifier struct {
+	w               io.Writer
+	collapse, strip bool
+
+	state    int
+	pending  []byte // input held back until more is written
+	afterTag bool   // the last output was the end of a tag
+	spaced   bool   // the last output was whitespace collapsed by the filter
+	tagName  []byte // name of the current tag, lower case, with a leading '/' if closing
+	inName   bool   // still reading the current tag's name
+	quote    byte   // quote character of the attribute value being read, or 0
+	raw      string // name of the raw text element being read
+	pre      int    // number of open <pre> elements
+}
+
+func (m *minifier) Write(p []byte) (int, error) {
+	var input = append(m.pending, p...)
+	var out bytes.Buffer
+	var i = m.process(input, &out)
+	m.pending = append([]byte(nil), input[i:]...)
+	if _, err := m.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes any input that was held back.
+func (m *minifier) Close() error {
+	if m.state == minStripped {
+		return nil
+	}
+	_, err := m.w.Write(m.pending)
+	m.pending = nil
+	return err
+}
+
+// process writes the output for the given input, returning the offset of the
+// first byte that can not be handled until more input is written.
+func (m *minifier) process(input []byte, out *bytes.Buffer) int {
+	var i = 0
+	for i < len(input) {
+		var ch = input[i]
+		switch m.state {
+		case minText:
+			var comment, partialComment = matchPrefix(input[i:], "<!--")
+			switch {
+			case comment:
+				var conditional, partial = matchPrefix(input[i:], "<!--[if")
+				if partial {
+					return i
+				}
+				if m.strip && !conditional {
+					m.state = minStripped
+					i += len("<!--")
+					continue
+				}
+				m.state = minComment
+				out.WriteString("<!--")
+				m.afterTag, m.spaced = false, false
+				i += len("<!--")
+				continue
+			case partialComment:
+				return i
+			case ch == '<':
+				m.state, m.tagName, m.inName, m.quote = minTag, m.tagName[:0], true, 0
+			case isHTMLSpace(ch) && m.collapse && m.afterTag && m.pre == 0:
+				var j = i
+				for j < len(input) && isHTMLSpace(input[j]) {
+					j++
+				}
+				if j == len(input) {
+					return i
+				}
+				if input[j] != '<' {
+					out.Write(input[i:j])
+					m.afterTag = false
+				} else if !m.spaced {
+					out.WriteByte(' ')
+					m.spaced = true
+				}
+				i = j
+				continue
+			}
+			out.WriteByte(ch)
+			m.spaced = false
+			if m.state == minText {
+				m.afterTag = false
+			}
+
+		case minTag:
+			out.WriteByte(ch)
+			switch {
+			case m.quote != 0:
+				if ch == m.quote {
+					m.quote = 0
+				}
+			case ch == '"' || ch == '\'':
+				m.quote = ch
+			case ch == '>':
+				m.endTag()
+			case m.inName && (isHTMLSpace(ch) || ch == '/' && len(m.tagName) > 0):
+				m.inName = false
+			case m.inName:
+				m.tagName = append(m.tagName, toLower(ch))
+			}
+
+		case minComment, minStripped:
+			var end, partial = matchPrefix(input[i:], "-->")
+			if partial {
+				return i
+			}
+			if end {
+				if m.state == minComment {
+					out.WriteString("-->")
+				}
+				m.state = minText
+				i += len("-->")
+				continue
+			}
+			if m.state == minComment {
+				out.WriteByte(ch)
+			}
+
+		case minRawElement:
+			var end, partial = matchPrefix(input[i:], "</"+m.raw)
+			if partial {
+				return i
+			}
+			if end {
+				m.state, m.tagName, m.inName, m.quote = minTag, m.tagName[:0], true, 0
+			}
+			out.WriteByte(ch)
+		}
+		i++
+	}
+	return i
+}
+
+// endTag updates the state at the end of a tag.
+func (m *minifier) endTag() {
+	m.state, m.afterTag = minText, true
+	var name = string(m.tagName)
+	switch {
+	case rawElements[name]:
+		m.state, m.raw = minRawElement, name
+	case name == "pre":
+		m.pre++
+	case name == "/pre" && m.pre > 0:
+		m.pre--
+	}
+}
+
+// matchPrefix reports whether the input begins with the given prefix, ignoring
+// case, or whether it might once more input is written.
+func matchPrefix(input []byte, prefix string) (match, partial bool) {
+	if len(input) < len(prefix) {
+		return false, bytes.EqualFold(input, []byte(prefix[:len(input)]))
+	}
+	return bytes.EqualFold(input[:len(prefix)], []byte(prefix)), false
+}
+
+func isHTMLSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f'
+}
+
+func toLower(ch byte) byte {
+	if 'A' <= ch && ch <= 'Z' {
+		return ch + 'a' - 'A'
+	}
+	return ch
+}
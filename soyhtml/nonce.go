@@ -0,0 +1,78 @@
+package soyhtml
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/harrisonzhao/soy/data"
+)
+
+// CSPNonceKey is the $ij key of the nonce that is added to the <script> and
+// <style> tags written by templates, so that pages satisfy a Content Security
+// Policy that requires one.  Tags that already have a nonce attribute are left
+// alone.
+//
+// The tags are found once per template, by the context of its raw text (see
+// contextual.go), so text that merely looks like a tag, e.g. within a JS
+// string, an HTML comment, or a <textarea>, is left alone.  Since the nonce
+// should differ on every request, cacheable templates are cached without it,
+// and it is added to their output as it is written.
+const CSPNonceKey = "csp_nonce"
+
+// cspNonce returns the nonce given in the injected data, or "" if there is
+// none.
+func cspNonce(ij data.Map) (string, error) {
+	var val, ok = ij[CSPNonceKey]
+	if !ok {
+		return "", nil
+	}
+	if _, isNull := val.(data.Null); isNull {
+		return "", nil
+	}
+	var nonce = val.String()
+	for _, ch := range nonce {
+		if !isNonceChar(ch) {
+			return "", fmt.Errorf("invalid %s %q: expected base64", CSPNonceKey, nonce)
+		}
+	}
+	return nonce, nil
+}
+
+func isNonceChar(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' ||
+		ch == '+' || ch == '/' || ch == '=' || ch == '-' || ch == '_'
+}
+
+// insertNonce returns the given raw text with a nonce attribute inserted at
+// each of the given offsets, just after the name of a <script> or <style> tag.
+func insertNonce(text []byte, offsets []int, nonce string) []byte {
+	var buf bytes.Buffer
+	var prev = 0
+	for _, offset := range offsets {
+		buf.Write(text[prev:offset])
+		buf.WriteString(` nonce="` + nonce + `"`)
+		prev = offset
+	}
+	buf.Write(text[prev:])
+	return buf.Bytes()
+}
+
+// cachedNonce is the nonce with which cacheable templates are rendered, so
+// that the same output may be cached for all nonces, and replaced by the
+// nonce of each render as it is written (see withNonce).
+const cachedNonce = "\x00soy-csp-nonce\x00"
+
+var cachedNonceAttr = []byte(` nonce="` + cachedNonce + `"`)
+
+// withNonce returns the given cached output with the nonce of the render in
+// place of cachedNonce, or with no nonce attribute if there is none.
+func withNonce(output []byte, nonce string) []byte {
+	if !bytes.Contains(output, cachedNonceAttr) {
+		return output
+	}
+	var attr []byte
+	if nonce != "" {
+		attr = []byte(` nonce="` + nonce + `"`)
+	}
+	return bytes.Replace(output, cachedNonceAttr, attr, -1)
+}
@@ -0,0 +1,23 @@
+package soyhtml
+
+import "time"
+
+// RenderObserver is notified of each render, e.g. to record metrics for
+// monitoring.  It must be safe for concurrent use.
+type RenderObserver interface {
+	ObserveRender(RenderEvent)
+}
+
+// RenderEvent describes a completed render.
+type RenderEvent struct {
+	Template string        // fully-qualified name of the rendered template
+	Elapsed  time.Duration // time spent rendering, excluding Queued
+	Queued   time.Duration // time spent waiting for the render gate (see Tofu.Gate)
+	Err      error         // the error that the render failed with, or nil
+}
+
+// Observer sets the observer notified of each render.
+func (tofu *Tofu) Observer(observer RenderObserver) *Tofu {
+	tofu.observer = observer
+	return tofu
+}
@@ -0,0 +1,62 @@
+package soyhtml
+
+import (
+	"fmt"
+	"strings"
+
+	soyt "github.com/harrisonzhao/soy/template"
+)
+
+// EntryPolicy decides which templates may be rendered as entry points, i.e.
+// named to NewRenderer or Render, as opposed to called by other templates.
+// It prevents partials from being exposed when the name of the template to
+// render is controlled by users.  It must be safe for concurrent use.
+type EntryPolicy interface {
+	AllowEntry(tmpl soyt.Template) bool
+}
+
+// EntryPolicyFunc adapts an ordinary function to an EntryPolicy.
+type EntryPolicyFunc func(tmpl soyt.Template) bool
+
+// AllowEntry calls f(tmpl).
+func (f EntryPolicyFunc) AllowEntry(tmpl soyt.Template) bool {
+	return f(tmpl)
+}
+
+// PublicEntries returns a policy allowing only templates that are not declared
+// private="true", within the given namespaces or namespaces beneath them.  If
+// no namespaces are given, public templates of any namespace are allowed.
+func PublicEntries(namespaces ...string) EntryPolicy {
+	return EntryPolicyFunc(func(tmpl soyt.Template) bool {
+		if tmpl.Node.Private {
+			return false
+		}
+		if len(namespaces) == 0 {
+			return true
+		}
+		for _, ns := range namespaces {
+			if tmpl.Namespace.Name == ns || strings.HasPrefix(tmpl.Namespace.Name, ns+".") {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// EntryPolicy sets the policy deciding which templates may be rendered as
+// entry points.  Renders of other templates fail with an *EntryDeniedError.
+// Without a policy, any template may be rendered.
+func (tofu *Tofu) EntryPolicy(policy EntryPolicy) *Tofu {
+	tofu.entries = policy
+	return tofu
+}
+
+// EntryDeniedError is returned when the entry policy does not allow the
+// template to be rendered as an entry point.
+type EntryDeniedError struct {
+	Template string // fully-qualified name of the denied template
+}
+
+func (e *EntryDeniedError) Error() string {
+	return fmt.Sprintf("template %s may not be rendered as an entry point", e.Template)
+}
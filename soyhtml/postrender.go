@@ -0,0 +1,116 @@
+package soyhtml
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/harrisonzhao/soy/data"
+)
+
+// PostRenderer consumes the complete output of each successful render of a
+// kind of content, e.g. to archive sent emails, or to hand HTML to a PDF
+// converter.  Output filters (see Tofu.Filter) are better suited to
+// transforming the output as it is written.
+//
+// PostRenderers are called in the order installed, after the output has been
+// written.  If one returns an error, the render fails with it, and the
+// remaining PostRenderers are not called.  They must be safe for concurrent
+// use.
+type PostRenderer interface {
+	PostRender(ctx context.Context, out Output) error
+}
+
+// PostRendererFunc adapts an ordinary function to a PostRenderer.
+type PostRendererFunc func(ctx context.Context, out Output) error
+
+// PostRender calls f(ctx, out).
+func (f PostRendererFunc) PostRender(ctx context.Context, out Output) error {
+	return f(ctx, out)
+}
+
+// Output is the complete output of a render.
+type Output struct {
+	Template string           // fully-qualified name of the rendered template
+	Kind     data.ContentKind // kind of content rendered (see Renderer.Kind)
+	Locale   string           // locale of the render, or "" if unspecified
+	Content  []byte           // the output, as rendered (i.e. before any filters)
+}
+
+// PostRender adds the given consumers of the output of every successful render
+// of the given kind of content (see Renderer.Kind).  For example, HTML and
+// text emails might be recorded separately:
+//
+//	tofu.PostRender(data.KindHTML, archiveHTML).
+//		PostRender(data.KindText, archiveText)
+//
+// Simulated renders (see Renderer.Simulate) and chunks (see
+// Renderer.ExecuteChunk) are not consumed.
+func (tofu *Tofu) PostRender(kind data.ContentKind, consumers ...PostRenderer) *Tofu {
+	if tofu.post == nil {
+		tofu.post = make(map[data.ContentKind][]PostRenderer)
+	}
+	tofu.post[kind] = append(tofu.post[kind], consumers...)
+	return tofu
+}
+
+// postRender passes the given output of a successful render to the consumers
+// of its kind.
+func (t Renderer) postRender(consumers []PostRenderer, kind data.ContentKind, content []byte) error {
+	var ctx = t.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var out = Output{t.name, kind, t.locale, content}
+	for _, consumer := range consumers {
+		if err := consumer.PostRender(ctx, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Email is the pair of bodies of a transactional email.
+type Email struct {
+	HTML string // the text/html body, or "" if there is no HTML template
+	Text string // the text/plain body, or "" if there is no text template
+}
+
+// ExecuteEmail renders the pair of templates named by the renderer's name
+// followed by "Html" and "Text", e.g. "mail.welcomeHtml" and
+// "mail.welcomeText" for the renderer of "mail.welcome", as the bodies of an
+// email.  They are rendered with the same data and settings, as content of
+// kind data.KindHTML and data.KindText respectively, so that the filters and
+// PostRenderers installed for each kind apply.
+//
+// Either template may be omitted, in which case its body is empty, but
+// ErrTemplateNotFound is returned if both are.  The text template should be
+// declared autoescape="false", so that its output is not escaped as HTML.
+func (t Renderer) ExecuteEmail(obj data.Map) (Email, error) {
+	var email Email
+	var found bool
+	for _, part := range []struct {
+		suffix string
+		kind   data.ContentKind
+		body   *string
+	}{
+		{"Html", data.KindHTML, &email.HTML},
+		{"Text", data.KindText, &email.Text},
+	} {
+		var r = t
+		r.name = t.name + part.suffix
+		r.kind = part.kind
+		if _, ok := t.tofu.registry.Template(r.name); !ok {
+			continue
+		}
+		found = true
+		var buf bytes.Buffer
+		if err := r.execute(&buf, obj, nil); err != nil {
+			return Email{}, err
+		}
+		*part.body = buf.String()
+	}
+	if !found {
+		return Email{}, ErrTemplateNotFound
+	}
+	return email, nil
+}
@@ -0,0 +1,157 @@
+package soyhtml
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Preload is a resource referenced by rendered HTML, which the browser may
+// fetch early.
+type Preload struct {
+	URL string
+	As  string // destination of the resource: "script", "style", or "image"
+}
+
+// Link returns the value of a Link header asking the browser to preload the
+// resource, e.g. "</app.js>; rel=preload; as=script".
+func (p Preload) Link() string {
+	return "<" + linkURLEscaper.Replace(p.URL) + ">; rel=preload; as=" + p.As
+}
+
+var linkURLEscaper = strings.NewReplacer("<", "%3C", ">", "%3E", " ", "%20")
+
+// Preloads collects the resources referenced by the rendered HTML: the src of
+// each <script> and <img>, and the href of each <link rel="stylesheet"> (or
+// rel="preload" with an "as" attribute).  They may then be sent in Link
+// headers (e.g. with a 103 Early Hints response), so that the browser begins
+// fetching them before it parses the page.
+//
+// Inline data: URLs are ignored, and each resource is collected once.
+//
+// The output is scanned as text, without regard to the templates' escaping.
+// Preloads are therefore only trustworthy for strict or contextual templates:
+// elsewhere, data printed without escaping (e.g. with |noAutoescape or within
+// autoescape="false" templates) may contain tags that are collected too, and
+// tag-like text within scripts or comments may also be taken for a resource.
+type Preloads struct {
+	List []Preload // in the order they were rendered
+	seen map[Preload]bool
+}
+
+// Preloads sets the collector of the resources referenced by the output.
+func (r *Renderer) Preloads(preloads *Preloads) *Renderer {
+	r.preloads = preloads
+	return r
+}
+
+// SetHeader adds a Link header to h for each resource collected.
+func (p *Preloads) SetHeader(h http.Header) {
+	for _, preload := range p.List {
+		h.Add("Link", preload.Link())
+	}
+}
+
+func (p *Preloads) add(url, as string) {
+	url = strings.TrimSpace(url)
+	if url == "" || strings.HasPrefix(strings.ToLower(url), "data:") {
+		return
+	}
+	var preload = Preload{url, as}
+	if p.seen == nil {
+		p.seen = make(map[Preload]bool)
+	}
+	if !p.seen[preload] {
+		p.seen[preload] = true
+		p.List = append(p.List, preload)
+	}
+}
+
+// maxPartialTag is the length beyond which the start of a tag that has not
+// been closed is abandoned, e.g. for a "<" within a script.
+const maxPartialTag = 4096
+
+// preloadWriter passes output through to w, collecting the resources
+// referenced by the tags within it.  Tags may be split across writes.
+type preloadWriter struct {
+	w        io.Writer
+	preloads *Preloads
+	partial  []byte // the start of a tag that has not yet been closed
+}
+
+func (w *preloadWriter) Write(p []byte) (int, error) {
+	var text = p
+	if len(w.partial) > 0 {
+		text = append(w.partial, p...)
+		w.partial = nil
+	}
+	for {
+		var lt = bytes.IndexByte(text, '<')
+		if lt == -1 {
+			break
+		}
+		text = text[lt:]
+		var gt = bytes.IndexByte(text, '>')
+		if gt == -1 {
+			if len(text) <= maxPartialTag {
+				w.partial = append([]byte(nil), text...)
+			}
+			break
+		}
+		w.scanTag(text[1:gt])
+		text = text[gt+1:]
+	}
+	return w.w.Write(p)
+}
+
+// scanTag collects the resource referenced by the given tag, from within its
+// angle brackets, if any.
+func (w *preloadWriter) scanTag(tag []byte) {
+	var fields = strings.Fields(strings.TrimSuffix(string(tag), "/"))
+	if len(fields) == 0 {
+		return
+	}
+	var attrs = tagAttrs(fields[1:])
+	switch strings.ToLower(fields[0]) {
+	case "script":
+		w.preloads.add(attrs["src"], "script")
+	case "img":
+		w.preloads.add(attrs["src"], "image")
+	case "link":
+		switch strings.ToLower(attrs["rel"]) {
+		case "stylesheet":
+			w.preloads.add(attrs["href"], "style")
+		case "preload":
+			if attrs["as"] != "" {
+				w.preloads.add(attrs["href"], strings.ToLower(attrs["as"]))
+			}
+		}
+	}
+}
+
+// tagAttrs returns the values of the attributes given by the whitespace
+// separated fields of a tag, by lower case name.  Quoted values containing
+// spaces are rejoined.
+func tagAttrs(fields []string) map[string]string {
+	var attrs = make(map[string]string)
+	for i := 0; i < len(fields); i++ {
+		var eq = strings.IndexByte(fields[i], '=')
+		if eq == -1 {
+			attrs[strings.ToLower(fields[i])] = ""
+			continue
+		}
+		var name, val = strings.ToLower(fields[i][:eq]), fields[i][eq+1:]
+		if len(val) > 0 && (val[0] == '"' || val[0] == '\'') {
+			var quote = val[:1]
+			for !strings.HasSuffix(val[1:], quote) && i+1 < len(fields) {
+				i++
+				val += " " + fields[i]
+			}
+			val = strings.TrimSuffix(val[1:], quote)
+		}
+		attrs[name] = html.UnescapeString(val)
+	}
+	return attrs
+}
@@ -2,11 +2,18 @@
 package soyhtml
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"strings"
+	"time"
 
 	"github.com/harrisonzhao/soy/ast"
 	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/soymsg"
+	"github.com/harrisonzhao/soy/template"
 )
 
 var ErrTemplateNotFound = errors.New("template not found")
@@ -14,9 +21,25 @@ var ErrTemplateNotFound = errors.New("template not found")
 // Renderer provides parameters to template execution.
 // At minimum, Registry and Template are required to render a template..
 type Renderer struct {
-	tofu *Tofu    // a registry of all templates in a bundle
-	name string   // fully-qualified name of the template to render
-	ij   data.Map // data for the $ij map
+	tofu      *Tofu            // a registry of all templates in a bundle
+	name      string           // fully-qualified name of the template to render
+	ij        data.Map         // data for the $ij map
+	ctx       context.Context  // context of the render, made available to functions
+	locale    string           // locale of the render, made available to functions
+	trace     *Trace           // records expression evaluations, if set
+	msgs      soymsg.Provider  // translated messages, if set
+	limit     int64            // maximum number of bytes to output, if positive
+	depth     int              // maximum depth of calls, see Tofu.MaxCallDepth
+	parallel  bool             // true to render sibling calls concurrently
+	async     bool             // true to start resolving lazy data at the start of the render
+	filters   []OutputFilter   // filters applied to the output, in order
+	kind      data.ContentKind // kind of content rendered, if not the template's
+	chunk     bool             // true if rendering a chunk, whose output is not filtered or consumed
+	delpkgs   []string         // active delegate packages
+	simulated bool             // true to simulate the render, without its effects (see Simulate)
+	diags     *[]Diagnostic    // collects problems found, when simulated
+	section   string           // name of the {let} to render alone, if set
+	preloads  *Preloads        // collects the resources referenced by the output, if set
 }
 
 // Inject sets the given data map as the $ij injected data.
@@ -25,29 +48,252 @@ func (r *Renderer) Inject(ij data.Map) *Renderer {
 	return r
 }
 
+// WithContext sets the context of the render.  It is provided to functions
+// that request it (see FuncContext).
+func (r *Renderer) WithContext(ctx context.Context) *Renderer {
+	r.ctx = ctx
+	return r
+}
+
+// Locale sets the locale of the render.  It is provided to functions that
+// request it (see FuncContext).
+func (r *Renderer) Locale(locale string) *Renderer {
+	r.locale = locale
+	return r
+}
+
+// Messages sets the provider of translated messages.  Messages without a
+// translation are rendered from the template source.
+func (r *Renderer) Messages(msgs soymsg.Provider) *Renderer {
+	r.msgs = msgs
+	return r
+}
+
+// messages returns the provider of translated messages for the render: those
+// set by Messages, or else the Tofu's bundle for the render's locale.
+func (r Renderer) messages() soymsg.Provider {
+	if r.msgs != nil {
+		return r.msgs
+	}
+	if bundle, ok := r.tofu.bundles[r.locale]; ok {
+		return bundle
+	}
+	return nil
+}
+
+// ResolveAsync causes the render to begin resolving all data.Lazy values in
+// the data and injected data concurrently as it starts, rather than one at a
+// time as each is used.  Rendering blocks only when it reaches a value that
+// has not yet been resolved.
+func (r *Renderer) ResolveAsync() *Renderer {
+	r.async = true
+	return r
+}
+
+// MaxOutputBytes limits the output of the render to the given number of bytes.
+// Rendering stops with an *OutputTooLargeError once the limit is exceeded,
+// which protects against templates that accidentally loop over enormous lists.
+// Content rendered to a value (e.g. by {let} or {param}) is limited likewise.
+// It overrides the limit of the Tofu (see Tofu.MaxOutputBytes).
+func (r *Renderer) MaxOutputBytes(n int64) *Renderer {
+	r.limit = n
+	return r
+}
+
+// Filter adds the given filters to those applied to the output of the render
+// as it is written, e.g. CollapseWhitespace.  Each filter receives the output
+// of the one before it.
+func (r *Renderer) Filter(filters ...OutputFilter) *Renderer {
+	r.filters = append(r.filters, filters...)
+	return r
+}
+
+// Kind sets the kind of content that the template renders, which selects the
+// filters installed with Tofu.Filter.  The default is the kind of the template
+// (see template.Template.Kind).
+func (r *Renderer) Kind(kind data.ContentKind) *Renderer {
+	r.kind = kind
+	return r
+}
+
+// DelPackages activates the given delegate packages for the render, so that
+// {delcall}s render the delegate templates declared within them, which take
+// priority over delegates declared outside of any {delpackage}.  Delegates
+// of inactive packages are never rendered.
+func (r *Renderer) DelPackages(names ...string) *Renderer {
+	r.delpkgs = append(r.delpkgs, names...)
+	return r
+}
+
+// ErrOutputTooLarge matches (via errors.Is) the error returned when a render
+// exceeds its MaxOutputBytes.
+var ErrOutputTooLarge = errors.New("template output too large")
+
+// OutputTooLargeError is returned when a render exceeds its MaxOutputBytes.
+type OutputTooLargeError struct {
+	Limit int64    // the limit that was exceeded
+	Stack []string // templates being rendered when it was exceeded, outermost first
+}
+
+func (e *OutputTooLargeError) Error() string {
+	return fmt.Sprintf("template output exceeded %d bytes, in %s",
+		e.Limit, strings.Join(e.Stack, " > "))
+}
+
+// Is reports whether target is ErrOutputTooLarge.
+func (e *OutputTooLargeError) Is(target error) bool {
+	return target == ErrOutputTooLarge
+}
+
+// RenderResult summarizes a completed render, for logging and monitoring.
+type RenderResult struct {
+	BytesWritten     int64    // number of bytes written to the output
+	TemplatesVisited []string // fully-qualified names of templates rendered, in order
+	MessagesLookedUp int      // number of {msg} blocks rendered
+	CacheHits        int      // number of values reused from a render cache
+}
+
+// Trace sets the given trace to record the expressions evaluated during the
+// render.  Retrieve the results from the trace after executing.
+func (r *Renderer) Trace(trace *Trace) *Renderer {
+	r.trace = trace
+	return r
+}
+
 // Execute applies a parsed template to the specified data object,
 // and writes the output to wr.
-func (t Renderer) Execute(wr io.Writer, obj data.Map) (err error) {
+func (t Renderer) Execute(wr io.Writer, obj data.Map) error {
+	return t.execute(wr, obj, nil)
+}
+
+// ExecuteResult is like Execute, but it additionally returns a summary of the
+// render.  The result is populated as far as rendering got, even on error.
+func (t Renderer) ExecuteResult(wr io.Writer, obj data.Map) (RenderResult, error) {
+	var result RenderResult
+	var err = t.execute(countingWriter{wr, &result.BytesWritten}, obj, &result)
+	return result, err
+}
+
+// ExecuteChunk renders the template to an immutable chunk of content of the
+// renderer's Kind.  The chunk may be passed as a param to subsequent renders,
+// where it is printed as-is rather than being escaped again, so that cached
+// fragments may be cheaply composed into full pages.
+//
+// Output filters and post-renderers are not applied to chunks, but to the
+// pages into which they are composed.
+func (t Renderer) ExecuteChunk(obj data.Map) (data.SanitizedContent, error) {
+	var buf bytes.Buffer
+	t.chunk = true
+	if err := t.execute(&buf, obj, nil); err != nil {
+		return data.SanitizedContent{}, err
+	}
+	var tmpl, _ = t.tofu.registry.Template(t.name)
+	return data.SanitizedContent{t.contentKind(tmpl), buf.String()}, nil
+}
+
+// contentKind returns the kind of content rendered by the given template.
+func (t Renderer) contentKind(tmpl template.Template) data.ContentKind {
+	if t.kind == "" {
+		return tmpl.Kind()
+	}
+	return t.kind
+}
+
+func (t Renderer) execute(wr io.Writer, obj data.Map, result *RenderResult) (err error) {
 	if t.tofu == nil || t.tofu.registry == nil {
 		return errors.New("Template Registry required")
 	}
 	if t.name == "" {
 		return errors.New("Template name required")
 	}
+	var queued time.Duration
+	if t.tofu.observer != nil && !t.simulated {
+		var start = time.Now()
+		defer func() {
+			t.tofu.observer.ObserveRender(RenderEvent{t.name, time.Since(start) - queued, queued, err})
+		}()
+	}
+	if t.tofu.gate != nil && !t.simulated {
+		if queued, err = t.tofu.gate.enter(t.ctx); err != nil {
+			return err
+		}
+		defer t.tofu.gate.leave()
+	}
 
 	var tmpl, ok = t.tofu.registry.Template(t.name)
 	if !ok {
 		return ErrTemplateNotFound
 	}
+	if t.tofu.entries != nil && !t.tofu.entries.AllowEntry(tmpl) {
+		return &EntryDeniedError{t.name}
+	}
+
+	var usage *renderUsage
+	if t.tofu.usage != nil && !t.simulated {
+		usage = newRenderUsage()
+		usage.template(tmpl.Node)
+		defer func() { t.tofu.usage.add(usage, t.tofu.registry) }()
+	}
 
 	var autoescapeMode = tmpl.Namespace.Autoescape
 	if autoescapeMode == ast.AutoescapeUnspecified {
 		autoescapeMode = ast.AutoescapeOn
 	}
 
+	if t.async {
+		data.StartAll(obj)
+		data.StartAll(t.ij)
+	}
+
+	var stack = []string{tmpl.Node.Name}
+	var maxOutput = t.maxOutputBytes()
+	if maxOutput > 0 {
+		wr = &limitWriter{wr, maxOutput, maxOutput, &stack}
+	}
+
+	// Output filters and post-renderers apply to the output of real renders,
+	// the latter receiving it as rendered, before it is filtered.
+	var kind = t.contentKind(tmpl)
+	var filters []OutputFilter
+	var consumers []PostRenderer
+	var postBuf bytes.Buffer
+	if !t.simulated && !t.chunk {
+		filters = append(filters, t.filters...)
+		filters = append(filters, t.tofu.filters[kind]...)
+		consumers = t.tofu.post[kind]
+	}
+	wr, closers := filterChain(wr, filters)
+	if len(consumers) > 0 {
+		wr = io.MultiWriter(wr, &postBuf)
+	}
+	if t.preloads != nil {
+		// Collects from all of the output, whatever the escaping mode; see
+		// Preloads.
+		wr = &preloadWriter{w: wr, preloads: t.preloads}
+	}
+	var auditor UnsafeAuditor
+	if !t.simulated {
+		auditor = t.tofu.auditor
+	}
+	var metrics Instrumenter
+	var written *int64
+	if t.tofu.metrics != nil && !t.simulated {
+		metrics, written = t.tofu.metrics, new(int64)
+		wr = countingWriter{wr, written}
+	}
+
 	var initialScope = newScope(obj)
 	initialScope.enter()
 
+	nonce, err := cspNonce(t.ij)
+	if err != nil {
+		return err
+	}
+	var cache = t.tofu.cache
+	if t.simulated {
+		cache = nil
+	}
+
 	state := &state{
 		tmpl:       tmpl,
 		registry:   *t.tofu.registry,
@@ -56,8 +302,77 @@ func (t Renderer) Execute(wr io.Writer, obj data.Map) (err error) {
 		wr:         wr,
 		context:    initialScope,
 		ij:         t.ij,
+		result:     result,
+		ctx:        t.ctx,
+		locale:     t.locale,
+		trace:      t.trace,
+		usage:      usage,
+		debug:      t.tofu.debug,
+		cache:      cache,
+		flags:      t.tofu.flags,
+		urls:       t.tofu.urls,
+		markdown:   t.tofu.markdown,
+		collator:   t.tofu.collator,
+		budgets:    t.tofu.budgets,
+		messages:   t.messages(),
+		stack:      &stack,
+		maxDepth:   t.maxCallDepth(),
+		maxOutput:  maxOutput,
+		concurrent: t.parallel,
+		metrics:    metrics,
+		written:    written,
+		auditor:    auditor,
+		nonce:      nonce,
+		resolver:   t.tofu.resolver,
+		delpkgs:    t.delpkgs,
+		simulated:  t.simulated,
+		diags:      t.diags,
+		section:    t.section,
+		contexts:   t.tofu.contexts,
 	}
 	defer state.errRecover(&err)
-	state.walk(tmpl.Node)
+	if t.section != "" {
+		if err = state.executeSection(tmpl.Node, t.section, wr); err != nil {
+			return err
+		}
+	} else {
+		state.walkTemplate("")
+	}
+	for _, closer := range closers {
+		if err = closer.Close(); err != nil {
+			return err
+		}
+	}
+	if len(consumers) > 0 {
+		return t.postRender(consumers, kind, postBuf.Bytes())
+	}
 	return
 }
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	var n, err = w.w.Write(p)
+	*w.n += int64(n)
+	return n, err
+}
+
+// limitWriter fails the render once more than limit bytes are written.
+type limitWriter struct {
+	w         io.Writer
+	limit     int64
+	remaining int64
+	stack     *[]string
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.remaining {
+		panic(&OutputTooLargeError{w.limit, append([]string(nil), *w.stack...)})
+	}
+	w.remaining -= int64(len(p))
+	return w.w.Write(p)
+}
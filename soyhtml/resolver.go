@@ -0,0 +1,58 @@
+package soyhtml
+
+import (
+	"context"
+
+	soyt "github.com/harrisonzhao/soy/template"
+)
+
+// CallResolver chooses the template rendered by each {call} to a basic
+// (non-delegate) template, e.g. to substitute checkout.button_v2 for
+// checkout.button for users assigned to an experiment.  It is given the
+// context of the render and the fully-qualified name of the called template,
+// and returns the name of the template to render instead, or the same name to
+// leave the call alone.
+//
+// A substitute must exist, must output the same kind of content as the
+// original template, and must not require any param that the original template
+// does not also require (see template.CheckSubstitute); otherwise the render
+// fails.
+type CallResolver interface {
+	ResolveCall(ctx context.Context, name string) string
+}
+
+// CallResolverFunc adapts an ordinary function to a CallResolver.
+type CallResolverFunc func(ctx context.Context, name string) string
+
+// ResolveCall calls f(ctx, name).
+func (f CallResolverFunc) ResolveCall(ctx context.Context, name string) string {
+	return f(ctx, name)
+}
+
+// CallResolver sets the resolver consulted by each {call}.  Without a
+// resolver, calls render the template they name.
+func (tofu *Tofu) CallResolver(resolver CallResolver) *Tofu {
+	tofu.resolver = resolver
+	return tofu
+}
+
+// resolveCall returns the template to render in place of the given template,
+// called by name.
+func (s *state) resolveCall(called soyt.Template) soyt.Template {
+	var ctx = s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var name = s.resolver.ResolveCall(ctx, called.Node.Name)
+	if name == called.Node.Name {
+		return called
+	}
+	var sub, ok = s.registry.Template(name)
+	if !ok {
+		s.errorf("failed to find template %s, substituted for %s", name, called.Node.Name)
+	}
+	if err := soyt.CheckSubstitute(called, sub); err != nil {
+		s.errorf("can not substitute %s for %s: %s", name, called.Node.Name, err)
+	}
+	return sub
+}
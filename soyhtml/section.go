@@ -0,0 +1,89 @@
+package soyhtml
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/data"
+)
+
+// Section restricts the render to the content of the {let} of the given name
+// (without the "$") within the template, e.g. to re-render one region of a
+// page for a partial update, without moving it into a template of its own:
+//
+//	{template .page}
+//	  {let $cart kind="html"}<ul>...</ul>{/let}
+//	  <div id="cart">{$cart}</div>
+//	{/template}
+//
+// The template is rendered with the same data as usual, but nothing is
+// written until the render reaches the {let}, whose content is written
+// instead, so that the section may use the loop variables and {let}s
+// preceding it.  The render stops once the section is written; if the {let}
+// is within a loop, the section is written for its first iteration.  The
+// render fails if the template has no such {let}, or the render does not
+// reach it.
+func (r *Renderer) Section(name string) *Renderer {
+	r.section = name
+	return r
+}
+
+// sectionDone is panicked to stop the render once the section is written.
+type sectionDone struct{}
+
+// hasSection returns true if the given template has a {let} of the given name.
+func hasSection(tmpl *ast.TemplateNode, name string) bool {
+	var found bool
+	ast.Walk(tmpl, func(node ast.Node) bool {
+		if let, ok := node.(*ast.LetContentNode); ok && let.Name == name {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// walkSection renders the section of the given template to wr, discarding the
+// rest of its output.  It returns false if the render did not reach the
+// section.
+func (s *state) walkSection(node *ast.TemplateNode, wr io.Writer) (found bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(sectionDone); !ok {
+				panic(e)
+			}
+			found = true
+		}
+	}()
+	s.sectionWr = wr
+	s.wr = ioutil.Discard
+	s.walk(node)
+	return false
+}
+
+// writeSection writes the content of the given {let}, and stops the render.
+func (s *state) writeSection(node *ast.LetContentNode) {
+	s.wr = s.sectionWr
+	if s.autoescape == ast.AutoescapeStrict {
+		var kind = node.Kind
+		if kind == "" {
+			kind = data.KindHTML
+		}
+		s.wr = newContextWriter(s.wr, kind)
+	}
+	s.walk(node.Body)
+	panic(sectionDone{})
+}
+
+// executeSection renders the section of the given template to wr.
+func (s *state) executeSection(node *ast.TemplateNode, name string, wr io.Writer) error {
+	if !hasSection(node, name) {
+		return fmt.Errorf("template %s has no {let $%s} section", node.Name, name)
+	}
+	if !s.walkSection(node, wr) {
+		return fmt.Errorf("render of template %s did not reach section %s", node.Name, name)
+	}
+	return nil
+}
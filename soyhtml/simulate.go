@@ -0,0 +1,71 @@
+package soyhtml
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/data"
+)
+
+// Diagnostic is a problem found by a simulated render.
+type Diagnostic struct {
+	Template string // fully-qualified name of the template in which it was found
+	Line     int    // line number of the problem, or 0 if unknown
+	Message  string
+	Fatal    bool // true if the render would fail
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d: %s", d.Template, d.Line, d.Message)
+}
+
+// Simulate renders the template with the given data, discarding the output,
+// and returns the problems found: data refs that evaluate to undefined (other
+// than optional params), and finally the error that the render would fail
+// with, if any.  It evaluates the same expressions and takes the same
+// branches as a real render, so it may be used to cheaply validate data
+// payloads, e.g. in request middleware.
+//
+// Simulated renders bypass the render cache, output filters and post-renderers,
+// observer, gate, usage report, metrics, and unsafe auditor.
+func (r *Renderer) Simulate(obj data.Map) []Diagnostic {
+	var diags []Diagnostic
+	var sim = *r
+	sim.simulated, sim.diags = true, &diags
+	if err := sim.execute(ioutil.Discard, obj, nil); err != nil {
+		var diag = Diagnostic{Template: r.name, Message: err.Error(), Fatal: true}
+		if renderErr, ok := err.(*RenderError); ok {
+			diag.Template, diag.Line, diag.Message = renderErr.Template, renderErr.Line, renderErr.Message
+		}
+		diags = append(diags, diag)
+	}
+	return diags
+}
+
+// checkDefined records a diagnostic if the given data ref evaluated to
+// undefined, unless it refers to an optional param.  Each problem is recorded
+// once.
+func (s *state) checkDefined(node *ast.DataRefNode, val data.Value) {
+	if _, ok := val.(data.Undefined); !ok {
+		return
+	}
+	if len(node.Access) == 0 {
+		for _, param := range s.tmpl.Doc.Params {
+			if param.Name == node.Key && param.Optional {
+				return
+			}
+		}
+	}
+	var diag = Diagnostic{
+		Template: s.tmpl.Node.Name,
+		Line:     s.registry.LineNumber(s.tmpl.Node.ID(), node),
+		Message:  fmt.Sprintf("%v is undefined", node),
+	}
+	for _, existing := range *s.diags {
+		if existing == diag {
+			return
+		}
+	}
+	*s.diags = append(*s.diags, diag)
+}
@@ -0,0 +1,847 @@
+package soyhtml
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/harrisonzhao/soy/data"
+)
+
+// In strict autoescaping mode (autoescape="strict"), the context of the output
+// is tracked as it is written, and each value printed is escaped as
+// appropriate for its context, rather than always being HTML-escaped:
+//
+//	HTML text                  HTML-escaped, unless it is HTML content
+//	within a tag               attributes content, or a filtered attribute name
+//	URI attribute (href, src)  filtered and normalized at the start of the
+//	                           value, or URI-escaped within it
+//	JS (<script>, onclick)     JS-string-escaped within a string or template
+//	                           literal, regex-escaped within a regex literal,
+//	                           or printed as a JS value, and rejected within
+//	                           a JS comment or after an ambiguous "/"
+//	CSS (<style>, style)       CSS content, or a filtered CSS token
+//	<textarea>, <title>        HTML-escaped, even if it is HTML content
+//
+// and attribute values are additionally HTML-escaped.  Content blocks ({let}
+// and {param} with a kind) begin in the context of their kind, and default to
+// kind="html".
+
+// escContext is the context of the output at some point.
+type escContext uint8
+
+const (
+	ctxText          escContext = iota // HTML text
+	ctxTagOpen                         // after "<"
+	ctxTagName                         // within the name of a tag
+	ctxTag                             // within a tag, between attributes
+	ctxAttrName                        // within the name of an attribute
+	ctxAfterAttrName                   // after the name of an attribute
+	ctxBeforeValue                     // after "=" in a tag
+	ctxAttr                            // within an attribute value
+	ctxComment                         // within an HTML comment or declaration
+	ctxScript                          // within the body of a <script>, or JS content
+	ctxStyle                           // within the body of a <style>, or CSS content
+	ctxURI                             // within URI content
+	ctxRaw                             // within text content, which is escaped when printed
+	ctxRCDATA                          // within the body of a <textarea> or <title>
+)
+
+// attrType is the type of content expected in an attribute value.
+type attrType uint8
+
+const (
+	attrNormal attrType = iota
+	attrURI
+	attrJS
+	attrCSS
+)
+
+// uriAttrs are the attributes whose values are URIs.
+var uriAttrs = map[string]bool{
+	"action":     true,
+	"background": true,
+	"cite":       true,
+	"data":       true,
+	"formaction": true,
+	"href":       true,
+	"manifest":   true,
+	"poster":     true,
+	"src":        true,
+	"xlink:href": true,
+}
+
+// jsState is the state of the JS tokenizer, within JS.
+type jsState uint8
+
+const (
+	jsCode         jsState = iota // JS code
+	jsString                      // within a '...' or "..." string literal
+	jsTemplate                    // within a `...` template literal, outside ${...}
+	jsRegex                       // within a /.../ regex literal
+	jsRegexClass                  // within a [...] class of a regex literal
+	jsLineComment                 // within a // comment
+	jsBlockComment                // within a /* */ comment
+	jsAfterSlash                  // after a "/" in code, which may begin a comment
+	jsAmbiguous                   // after a "/" that may begin a regex or divide
+)
+
+// jsSlash is the meaning of a "/" in JS code, which depends on the previous
+// token, as in html/template.
+type jsSlash uint8
+
+const (
+	slashRegex   jsSlash = iota // begins a regex literal
+	slashDiv                    // is a division operator
+	slashUnknown                // may be either, after branches that disagree
+)
+
+// escState is the state of the output at some point, from which its context
+// is known.
+type escState struct {
+	ctx       escContext
+	tag       []byte   // name of the current tag, lower case
+	closing   bool     // true if the current tag is an end tag
+	attr      []byte   // name of the current attribute, lower case
+	quote     byte     // delimiter of the attribute value, or 0 if unquoted
+	js        jsState  // state of the JS tokenizer, within JS
+	jsQuote   byte     // delimiter of the JS string literal, if js is jsString
+	escaped   bool     // true if the previous byte escapes this one in a JS literal, or is "*" in a JS comment
+	slash     jsSlash  // meaning of a "/" at this point in JS code
+	jsPrev    byte     // the last significant byte of JS code, or 0
+	jsRun     int      // the number of consecutive jsPrev bytes, for "++" and "--"
+	jsWord    []byte   // the identifier or keyword ending at this point in JS code
+	braces    int      // depth of "{" within JS code, since the innermost "${"
+	templates []int    // brace depths of the enclosing "${" substitutions
+	start     bool     // true if no part of the attribute value or URI was written
+	comment   bool     // true if within "<!--", which ends at "-->"
+	tail      [10]byte // the last bytes written, lower case, to find end tags
+}
+
+// newEscState returns the state at the start of content of the given kind.
+func newEscState(kind data.ContentKind) escState {
+	var st = escState{start: true}
+	switch kind {
+	case data.KindText:
+		st.ctx = ctxRaw
+	case data.KindAttributes:
+		st.ctx = ctxTag
+	case data.KindURI, data.KindTrustedResourceURI:
+		st.ctx = ctxURI
+	case data.KindJS:
+		st.ctx = ctxScript
+	case data.KindCSS:
+		st.ctx = ctxStyle
+	}
+	return st
+}
+
+// clone returns a copy of the state that may be advanced independently.
+func (st *escState) clone() escState {
+	var clone = *st
+	clone.tag = append([]byte(nil), st.tag...)
+	clone.attr = append([]byte(nil), st.attr...)
+	clone.jsWord = append([]byte(nil), st.jsWord...)
+	clone.templates = append([]int(nil), st.templates...)
+	return clone
+}
+
+// sameContext returns true if values printed, and text written, after either
+// state are treated the same, i.e. they differ only in details that do not
+// affect escaping, such as the names of ordinary tags.
+func (st *escState) sameContext(other *escState) bool {
+	if st.ctx != other.ctx {
+		return false
+	}
+	switch st.ctx {
+	case ctxTagName, ctxTag, ctxAttrName, ctxAfterAttrName, ctxBeforeValue, ctxAttr:
+		if st.element() != other.element() {
+			return false
+		}
+	}
+	switch st.ctx {
+	case ctxAttrName:
+		return bytes.Equal(st.attr, other.attr)
+	case ctxAfterAttrName, ctxBeforeValue:
+		return st.attrType() == other.attrType()
+	case ctxAttr:
+		return st.attrType() == other.attrType() && st.quote == other.quote &&
+			st.start == other.start && st.sameJS(other)
+	case ctxScript:
+		return st.sameJS(other)
+	case ctxURI:
+		return st.start == other.start
+	case ctxComment:
+		return st.comment == other.comment
+	case ctxRCDATA:
+		return bytes.Equal(st.tag, other.tag)
+	}
+	return true
+}
+
+// sameJS returns true if the JS tokenizer is in the same state after either
+// state.  The meaning of a "/" in code may differ, and is joined by joinJS.
+func (st *escState) sameJS(other *escState) bool {
+	if st.js != other.js || st.jsQuote != other.jsQuote || st.escaped != other.escaped ||
+		st.braces != other.braces || len(st.templates) != len(other.templates) {
+		return false
+	}
+	for i := range st.templates {
+		if st.templates[i] != other.templates[i] {
+			return false
+		}
+	}
+	return st.js != jsAfterSlash || st.slash == other.slash
+}
+
+// inJS returns true if the state is within JS, in a script or an attribute.
+func (st *escState) inJS() bool {
+	return st.ctx == ctxScript || st.ctx == ctxAttr && st.attrType() == attrJS
+}
+
+// joinJS joins the meaning of a "/" in JS code after the state and another in
+// the same context, which is unknown if they differ.
+func (st *escState) joinJS(other *escState) {
+	if st.inJS() && st.slash != other.slash {
+		st.slash, st.jsPrev, st.jsRun, st.jsWord = slashUnknown, 0, 0, st.jsWord[:0]
+	}
+}
+
+// element returns the name of the current tag if it opens an element whose
+// body is not HTML, e.g. "script", and "" otherwise.
+func (st *escState) element() string {
+	switch string(st.tag) {
+	case "script", "style", "textarea", "title":
+		if !st.closing {
+			return string(st.tag)
+		}
+	}
+	return ""
+}
+
+// ctxNames describe the contexts, for errors.
+var ctxNames = []string{
+	ctxText:          "HTML text",
+	ctxTagOpen:       "tag",
+	ctxTagName:       "tag name",
+	ctxTag:           "tag",
+	ctxAttrName:      "attribute name",
+	ctxAfterAttrName: "attribute name",
+	ctxBeforeValue:   "attribute value",
+	ctxAttr:          "attribute value",
+	ctxComment:       "HTML comment",
+	ctxScript:        "JS",
+	ctxStyle:         "CSS",
+	ctxURI:           "URI",
+	ctxRaw:           "text",
+	ctxRCDATA:        "element text",
+}
+
+// jsNames describe the states of the JS tokenizer, for errors.
+var jsNames = []string{
+	jsCode:         "",
+	jsString:       " within a JS string",
+	jsTemplate:     " within a JS template literal",
+	jsRegex:        " within a JS regex",
+	jsRegexClass:   " within a JS regex",
+	jsLineComment:  " within a JS comment",
+	jsBlockComment: " within a JS comment",
+	jsAfterSlash:   "",
+	jsAmbiguous:    ` after a "/" that may begin a regex or divide`,
+}
+
+// describe returns a description of the context of the state, e.g. `start of
+// "href" attribute value`, for errors.
+func (st *escState) describe() string {
+	var desc = ctxNames[st.ctx]
+	switch st.ctx {
+	case ctxAttrName, ctxAfterAttrName, ctxBeforeValue, ctxAttr:
+		desc = `"` + string(st.attr) + `" ` + desc
+	}
+	if st.inJS() {
+		desc += jsNames[st.js]
+	}
+	if st.start && (st.ctx == ctxURI || st.ctx == ctxAttr && st.attrType() == attrURI) {
+		desc = "start of " + desc
+	}
+	return desc
+}
+
+// contextWriter passes output through to w, tracking its context.
+type contextWriter struct {
+	w io.Writer
+	escState
+}
+
+// newContextWriter returns a writer tracking content of the given kind.
+func newContextWriter(w io.Writer, kind data.ContentKind) *contextWriter {
+	return &contextWriter{w, newEscState(kind)}
+}
+
+// fork returns a writer to w that continues from the current context.
+func (cw *contextWriter) fork(w io.Writer) *contextWriter {
+	return &contextWriter{w, cw.clone()}
+}
+
+func (cw *contextWriter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		cw.next(c)
+	}
+	return cw.w.Write(p)
+}
+
+// next advances the context past the given byte of output.
+func (st *escState) next(c byte) {
+	var lower = toLower(c)
+	copy(st.tail[:], st.tail[1:])
+	st.tail[len(st.tail)-1] = lower
+
+	switch st.ctx {
+	case ctxText:
+		if c == '<' {
+			st.ctx = ctxTagOpen
+		}
+	case ctxTagOpen:
+		st.tag, st.closing = st.tag[:0], false
+		switch {
+		case isLetter(c):
+			st.tag = append(st.tag, lower)
+			st.ctx = ctxTagName
+		case c == '/':
+			st.closing = true
+			st.ctx = ctxTagName
+		case c == '!':
+			st.ctx = ctxComment
+		default:
+			st.ctx = ctxText
+		}
+	case ctxTagName:
+		switch {
+		case c == '>':
+			st.endTag()
+		case isHTMLSpace(c) || c == '/':
+			st.ctx = ctxTag
+		default:
+			st.tag = append(st.tag, lower)
+		}
+	case ctxTag, ctxAfterAttrName:
+		switch {
+		case c == '>':
+			st.endTag()
+		case c == '=' && st.ctx == ctxAfterAttrName:
+			st.ctx = ctxBeforeValue
+		case isHTMLSpace(c) || c == '/':
+		default:
+			st.attr = append(st.attr[:0], lower)
+			st.ctx = ctxAttrName
+		}
+	case ctxAttrName:
+		switch {
+		case c == '>':
+			st.endTag()
+		case c == '=':
+			st.ctx = ctxBeforeValue
+		case isHTMLSpace(c):
+			st.ctx = ctxAfterAttrName
+		default:
+			st.attr = append(st.attr, lower)
+		}
+	case ctxBeforeValue:
+		switch {
+		case c == '>':
+			st.endTag()
+		case isHTMLSpace(c):
+		case c == '"' || c == '\'':
+			st.beginValue(c)
+		default:
+			st.beginValue(0)
+			st.valueByte(c)
+		}
+	case ctxAttr:
+		switch {
+		case st.quote != 0 && c == st.quote, st.quote == 0 && isHTMLSpace(c):
+			st.ctx = ctxTag
+		case st.quote == 0 && c == '>':
+			st.endTag()
+		default:
+			st.valueByte(c)
+		}
+	case ctxComment:
+		if st.hasTail("<!--") {
+			st.comment = true
+		} else if c == '>' && (!st.comment || st.hasTail("-->")) {
+			st.ctx, st.comment = ctxText, false
+		}
+	case ctxScript:
+		st.nextJS(c)
+		st.endElement("</script")
+	case ctxStyle:
+		st.endElement("</style")
+	case ctxRCDATA:
+		if string(st.tag) == "title" {
+			st.endElement("</title")
+		} else {
+			st.endElement("</textarea")
+		}
+	case ctxURI:
+		st.start = false
+	}
+}
+
+// valueByte advances past the given byte of an attribute value.
+func (st *escState) valueByte(c byte) {
+	st.start = false
+	if st.attrType() == attrJS {
+		st.nextJS(c)
+	}
+}
+
+// nextJS advances the state of the JS tokenizer past the given byte.
+func (st *escState) nextJS(c byte) {
+	switch st.js {
+	case jsCode:
+		st.nextJSCode(c)
+	case jsString:
+		switch {
+		case st.escaped:
+			st.escaped = false
+		case c == '\\':
+			st.escaped = true
+		case c == st.jsQuote:
+			st.js, st.jsQuote = jsCode, 0
+			st.operand()
+		}
+	case jsTemplate:
+		switch {
+		case st.escaped:
+			st.escaped, st.jsPrev = false, 0
+		case c == '\\':
+			st.escaped, st.jsPrev = true, 0
+		case c == '`':
+			st.js = jsCode
+			st.operand()
+		case c == '{' && st.jsPrev == '$':
+			st.templates = append(st.templates, st.braces)
+			st.js, st.braces = jsCode, 0
+			st.expression()
+		default:
+			st.jsPrev = c
+		}
+	case jsRegex, jsRegexClass:
+		switch {
+		case st.escaped:
+			st.escaped = false
+		case c == '\\':
+			st.escaped = true
+		case c == '[':
+			st.js = jsRegexClass
+		case c == ']' && st.js == jsRegexClass:
+			st.js = jsRegex
+		case c == '/' && st.js == jsRegex:
+			st.js = jsCode
+			st.operand()
+		}
+	case jsLineComment:
+		if c == '\n' || c == '\r' {
+			st.js = jsCode
+		}
+	case jsBlockComment:
+		if st.escaped && c == '/' {
+			st.js, st.escaped = jsCode, false
+		} else {
+			st.escaped = c == '*'
+		}
+	case jsAfterSlash:
+		switch {
+		case c == '/':
+			st.js = jsLineComment
+		case c == '*':
+			st.js, st.escaped = jsBlockComment, false
+		case st.slash == slashRegex:
+			st.js = jsRegex
+			st.nextJS(c)
+		case st.slash == slashDiv:
+			st.js = jsCode
+			st.jsPrev, st.jsRun, st.slash = '/', 1, slashRegex
+			st.nextJS(c)
+		default:
+			st.js = jsAmbiguous
+		}
+	}
+}
+
+// regexKeywords are the keywords after which a "/" begins a regex literal.
+var regexKeywords = map[string]bool{
+	"break":      true,
+	"case":       true,
+	"continue":   true,
+	"delete":     true,
+	"do":         true,
+	"else":       true,
+	"finally":    true,
+	"in":         true,
+	"instanceof": true,
+	"return":     true,
+	"throw":      true,
+	"try":        true,
+	"typeof":     true,
+	"void":       true,
+}
+
+// nextJSCode advances past the given byte of JS code, tracking the meaning of
+// a following "/" from the previous token as html/template does.
+func (st *escState) nextJSCode(c byte) {
+	switch {
+	case isJSSpace(c):
+		st.jsWord = st.jsWord[:0]
+		return
+	case c == '"' || c == '\'':
+		st.js, st.jsQuote = jsString, c
+		return
+	case c == '`':
+		st.js, st.jsPrev = jsTemplate, 0
+		return
+	case c == '/':
+		st.js = jsAfterSlash
+		return
+	case c == '}' && st.braces == 0 && len(st.templates) > 0:
+		st.braces = st.templates[len(st.templates)-1]
+		st.templates = st.templates[:len(st.templates)-1]
+		st.js, st.jsPrev = jsTemplate, 0
+		return
+	case c == '{':
+		st.braces++
+	case c == '}' && st.braces > 0:
+		st.braces--
+	}
+
+	if isJSIdent(c) {
+		st.jsWord = append(st.jsWord, c)
+		st.slash = slashDiv
+		if regexKeywords[string(st.jsWord)] {
+			st.slash = slashRegex
+		}
+	} else {
+		st.jsWord = st.jsWord[:0]
+		switch c {
+		case '+', '-':
+			if st.jsPrev == c {
+				st.jsRun++
+			} else {
+				st.jsRun = 1
+			}
+			st.slash = slashRegex
+			if st.jsRun%2 == 0 {
+				st.slash = slashDiv
+			}
+		case '.':
+			st.slash = slashRegex
+			if '0' <= st.jsPrev && st.jsPrev <= '9' {
+				st.slash = slashDiv
+			}
+		case ')', ']':
+			st.slash = slashDiv
+		default:
+			st.slash = slashRegex
+		}
+	}
+	st.jsPrev = c
+}
+
+// operand advances past the end of an operand in JS code, such as a literal
+// or a printed value, after which a "/" divides.
+func (st *escState) operand() {
+	st.slash, st.jsPrev, st.jsRun, st.jsWord = slashDiv, 0, 0, st.jsWord[:0]
+}
+
+// expression advances to the start of an expression in JS code, at which a
+// "/" begins a regex literal.
+func (st *escState) expression() {
+	st.slash, st.jsPrev, st.jsRun, st.jsWord = slashRegex, 0, 0, st.jsWord[:0]
+}
+
+// resetJS returns the JS tokenizer to the start of a script.
+func (st *escState) resetJS() {
+	st.js, st.jsQuote, st.escaped, st.braces, st.templates = jsCode, 0, false, 0, nil
+	st.expression()
+}
+
+// endElement returns to the end tag context if the output ends with the
+// given start of the end tag of an element whose body is not HTML, e.g.
+// "</script".
+func (st *escState) endElement(end string) {
+	if st.hasTail(end) {
+		st.tag, st.closing = append(st.tag[:0], end[2:]...), true
+		st.resetJS()
+		st.ctx = ctxTag
+	}
+}
+
+// hasTail returns true if the output written so far ends with the given lower
+// case string.
+func (st *escState) hasTail(s string) bool {
+	return strings.HasSuffix(string(st.tail[:]), s)
+}
+
+// beginValue enters an attribute value, delimited by the given quote.
+func (st *escState) beginValue(quote byte) {
+	st.ctx, st.quote, st.start = ctxAttr, quote, true
+	st.resetJS()
+}
+
+// printed advances the state past a printed value.
+func (st *escState) printed() {
+	switch st.ctx {
+	case ctxBeforeValue:
+		st.beginValue(0)
+		st.start = false
+	case ctxAttr, ctxURI:
+		st.start = false
+	}
+	if st.inJS() {
+		switch {
+		case st.js == jsCode, st.js == jsAfterSlash && st.slash == slashDiv:
+			st.js = jsCode
+			st.operand()
+		case st.js == jsAfterSlash:
+			st.js = jsRegex
+		}
+	}
+}
+
+// inValue returns true if the state is within a value, such as an attribute
+// value, JS, or a URI, rather than HTML text or tags.
+func (st *escState) inValue() bool {
+	switch st.ctx {
+	case ctxBeforeValue, ctxAttr, ctxScript, ctxStyle, ctxURI, ctxRaw:
+		return true
+	}
+	return false
+}
+
+// endTag leaves a tag, entering the body of the element.
+func (st *escState) endTag() {
+	st.ctx = ctxText
+	if st.closing {
+		return
+	}
+	switch string(st.tag) {
+	case "script":
+		st.ctx = ctxScript
+		st.resetJS()
+	case "style":
+		st.ctx = ctxStyle
+	case "textarea", "title":
+		st.ctx = ctxRCDATA
+	}
+}
+
+// attrType returns the type of the current attribute.
+func (st *escState) attrType() attrType {
+	var name = string(st.attr)
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return attrJS
+	case name == "style":
+		return attrCSS
+	case uriAttrs[name]:
+		return attrURI
+	}
+	return attrNormal
+}
+
+// escape returns the given value, escaped for the current context, or an
+// error if no value may be printed in it.
+func (st escState) escape(v data.Value) (string, error) {
+	switch st.ctx {
+	case ctxRaw:
+		return v.String(), nil
+	case ctxTagName, ctxTag, ctxAttrName, ctxAfterAttrName:
+		if contentOfKind(v, data.KindAttributes) {
+			return v.String(), nil
+		}
+		return filterName(v.String()), nil
+	case ctxBeforeValue:
+		var unquoted = st.clone()
+		unquoted.beginValue(0)
+		return unquoted.escape(v)
+	case ctxAttr:
+		var str string
+		switch st.attrType() {
+		case attrURI:
+			str = escapeURI(v, st.start)
+		case attrJS:
+			var err error
+			if str, err = st.escapeJS(v); err != nil {
+				return "", err
+			}
+		case attrCSS:
+			str = escapeCSS(v)
+		default:
+			str = v.String()
+		}
+		if st.quote == 0 {
+			return escapeUnquotedAttr(str), nil
+		}
+		return template.HTMLEscapeString(str), nil
+	case ctxScript:
+		return st.escapeJS(v)
+	case ctxStyle:
+		return escapeCSS(v), nil
+	case ctxURI:
+		return escapeURI(v, st.start), nil
+	case ctxRCDATA:
+		// Markup is not interpreted here, and could end the element.
+		return template.HTMLEscapeString(v.String()), nil
+	}
+	if contentOfKind(v, data.KindHTML) {
+		return v.String(), nil
+	}
+	return template.HTMLEscapeString(v.String()), nil
+}
+
+// contentOfKind returns true if the value is sanitized content of the given
+// kind.
+func contentOfKind(v data.Value, kind data.ContentKind) bool {
+	var sc, ok = v.(data.SanitizedContent)
+	return ok && sc.Kind == kind
+}
+
+// innocuousName replaces tag and attribute names, and CSS tokens, that fail
+// filtering.
+const innocuousName = "zSoyz"
+
+var (
+	safeNamePattern     = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_:-]*$`)
+	safeCSSTokenPattern = regexp.MustCompile(`^[-#%.,\w ]+$`)
+)
+
+// filterName allows only plain tag and attribute names.
+func filterName(str string) string {
+	if !safeNamePattern.MatchString(str) {
+		return innocuousName
+	}
+	return str
+}
+
+// escapeURI filters and normalizes the value at the start of a URI, and
+// escapes it as a URI component within one.
+func escapeURI(v data.Value, start bool) string {
+	if start {
+		return directiveFilterNormalizeUri(v, nil).String()
+	}
+	if contentOfKind(v, data.KindURI) || contentOfKind(v, data.KindTrustedResourceURI) {
+		return normalizeURI(v.String())
+	}
+	return url.QueryEscape(v.String())
+}
+
+// escapeJS escapes the value within a JS string or template literal, or a
+// regex literal, or otherwise prints it as a JS value: numbers, booleans, and
+// null as themselves, JSON for lists and maps, and quoted strings for anything
+// else.  JS content is printed as is, as are JS string characters within a
+// string literal.  Values may not be printed within a JS comment, or after a
+// "/" that may either begin a regex literal or divide.
+func (st *escState) escapeJS(v data.Value) (string, error) {
+	if contentOfKind(v, data.KindJS) {
+		return v.String(), nil
+	}
+	switch {
+	case st.js == jsString, st.js == jsTemplate:
+		if contentOfKind(v, data.KindJSStrChars) {
+			if st.js == jsTemplate {
+				return jsTemplateEscaper.Replace(v.String()), nil
+			}
+			return v.String(), nil
+		}
+		return escapeJSString(v.String()), nil
+	case st.js == jsRegex, st.js == jsRegexClass, st.js == jsAfterSlash && st.slash == slashRegex:
+		return escapeJSRegex(v.String()), nil
+	case st.js == jsLineComment, st.js == jsBlockComment,
+		st.js == jsAmbiguous, st.js == jsAfterSlash && st.slash == slashUnknown:
+		var desc = jsNames[st.js]
+		if st.js == jsAfterSlash {
+			desc = jsNames[jsAmbiguous]
+		}
+		return "", fmt.Errorf("a value can not be printed%s", desc)
+	}
+	switch v.(type) {
+	case data.Int, data.Float, data.Bool, data.Null:
+		return v.String(), nil
+	case data.List, data.Map:
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(v); err == nil {
+			return strings.TrimSuffix(buf.String(), "\n"), nil
+		}
+	}
+	return "'" + escapeJSString(v.String()) + "'", nil
+}
+
+// jsTemplateEscaper escapes the characters that end or substitute within a JS
+// template literal, which JSEscapeString does not.
+var jsTemplateEscaper = strings.NewReplacer("`", `\x60`, "$", `\x24`)
+
+// escapeJSString escapes the string within a JS string or template literal.
+func escapeJSString(str string) string {
+	return jsTemplateEscaper.Replace(template.JSEscapeString(str))
+}
+
+// escapeJSRegex escapes the string within a JS regex literal, so that it
+// matches itself.
+func escapeJSRegex(str string) string {
+	var buf bytes.Buffer
+	var from = 0
+	for i := 0; i < len(str); i++ {
+		if strings.IndexByte(`\^$*+?.()[]{}|/-`, str[i]) >= 0 {
+			buf.WriteString(escapeJSString(str[from:i]))
+			buf.WriteByte('\\')
+			buf.WriteByte(str[i])
+			from = i + 1
+		}
+	}
+	buf.WriteString(escapeJSString(str[from:]))
+	return buf.String()
+}
+
+// escapeCSS allows only CSS content, or plain CSS tokens such as colors,
+// lengths, and names.
+func escapeCSS(v data.Value) string {
+	if contentOfKind(v, data.KindCSS) {
+		return v.String()
+	}
+	var str = v.String()
+	if !safeCSSTokenPattern.MatchString(str) {
+		return innocuousName
+	}
+	return str
+}
+
+var unquotedAttrEscaper = strings.NewReplacer(
+	"&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&#34;", "'", "&#39;",
+	" ", "&#32;", "\t", "&#9;", "\n", "&#10;", "\f", "&#12;", "\r", "&#13;",
+	"=", "&#61;", "`", "&#96;")
+
+// escapeUnquotedAttr escapes the value of an unquoted attribute, including
+// the whitespace that would end it.
+func escapeUnquotedAttr(str string) string {
+	return unquotedAttrEscaper.Replace(str)
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isJSSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+// isJSIdent returns true if the byte may be part of an identifier, keyword,
+// or number, counting all non-ASCII bytes.
+func isJSIdent(c byte) bool {
+	return isLetter(c) || '0' <= c && c <= '9' || c == '_' || c == '$' || c >= 0x80
+}
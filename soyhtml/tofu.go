@@ -5,19 +5,87 @@ import (
 	"io"
 
 	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/soymsg"
 	"github.com/harrisonzhao/soy/template"
 )
 
 // Tofu is a bundle of compiled soy, ready to render to HTML.
 type Tofu struct {
 	registry *template.Registry
+	debug    bool
+	cache    *RenderCache
+	flags    FlagProvider
+	budgets  *Budgets
+	filters  map[data.ContentKind][]OutputFilter
+	post     map[data.ContentKind][]PostRenderer
+	resolver CallResolver
+	observer RenderObserver
+	entries  EntryPolicy
+	urls     URLMapper
+	markdown MarkdownRenderer
+	collator Collator
+	contexts *contextCache // print contexts of contextual templates
+	bundles  map[string]soymsg.Provider
+	gate     *RenderGate
+	usage    *Usage
+	metrics  Instrumenter
+	auditor  UnsafeAuditor
+	depth    int   // maximum depth of calls, see MaxCallDepth
+	maxBytes int64 // default limit on the output of renders, if positive
 }
 
 // NewTofu returns a new instance that is ready to provide HTML rendering
 // services for the given templates, with the default functions and print
 // directives.
 func NewTofu(registry *template.Registry) *Tofu {
-	return &Tofu{registry}
+	return &Tofu{registry: registry, contexts: &contextCache{}}
+}
+
+// Debug enables or disables debug mode, which makes debugging functions like
+// dumpScope() available to templates.  It should not be enabled in production.
+func (tofu *Tofu) Debug(enabled bool) *Tofu {
+	tofu.debug = enabled
+	return tofu
+}
+
+// Cache sets the cache used to store the output of templates declared
+// cacheable="true".  Without a cache, those templates are always rendered.
+//
+// A cache hit writes the stored output without walking the template, so the
+// templates it calls are missing from RenderResult.TemplatesVisited and the
+// Instrumenter's events for that render, and neither they nor the branches it
+// takes are recorded in Usage.  The cacheable template itself is still
+// reported.
+func (tofu *Tofu) Cache(cache *RenderCache) *Tofu {
+	tofu.cache = cache
+	return tofu
+}
+
+// Flags sets the provider consulted by the flagEnabled() function.  Without a
+// provider, flags are read from the $ij.flags map.
+func (tofu *Tofu) Flags(flags FlagProvider) *Tofu {
+	tofu.flags = flags
+	return tofu
+}
+
+// Budgets sets time budgets for {call}s to individual templates.  Calls that
+// exceed their budget are reported as configured.
+func (tofu *Tofu) Budgets(budgets *Budgets) *Tofu {
+	tofu.budgets = budgets
+	return tofu
+}
+
+// Messages adds the given bundles of translated messages.  Each render uses
+// the bundle for its locale (see Renderer.Locale), unless the renderer is
+// given messages of its own (see Renderer.Messages).
+func (tofu *Tofu) Messages(bundles ...*soymsg.Bundle) *Tofu {
+	if tofu.bundles == nil {
+		tofu.bundles = make(map[string]soymsg.Provider)
+	}
+	for _, bundle := range bundles {
+		tofu.bundles[bundle.Locale] = bundle
+	}
+	return tofu
 }
 
 // Render is a convenience function that executes the soy template of the given
@@ -29,17 +97,35 @@ func NewTofu(registry *template.Registry) *Tofu {
 // by default, since that is the Soy naming convention. The caller may update
 // those options to change the behavior of this function.
 func (tofu Tofu) Render(wr io.Writer, name string, obj interface{}) error {
-	var m data.Map
-	if obj != nil {
-		var ok bool
-		m, ok = data.New(obj).(data.Map)
-		if !ok {
-			return fmt.Errorf("invalid data type. expected map/struct, got %T", obj)
-		}
+	var m, err = toDataMap(obj)
+	if err != nil {
+		return err
 	}
 	return tofu.NewRenderer(name).Execute(wr, m)
 }
 
+// RenderResult is like Render, but additionally returns a summary of the
+// render (bytes written, templates visited, etc) for logging and monitoring.
+func (tofu Tofu) RenderResult(wr io.Writer, name string, obj interface{}) (RenderResult, error) {
+	var m, err = toDataMap(obj)
+	if err != nil {
+		return RenderResult{}, err
+	}
+	return tofu.NewRenderer(name).ExecuteResult(wr, m)
+}
+
+// toDataMap converts the given object to a data.Map for use as template data.
+func toDataMap(obj interface{}) (data.Map, error) {
+	if obj == nil {
+		return nil, nil
+	}
+	var m, ok = data.New(obj).(data.Map)
+	if !ok {
+		return nil, fmt.Errorf("invalid data type. expected map/struct, got %T", obj)
+	}
+	return m, nil
+}
+
 // NewRenderer returns a new instance of a soy html renderer, given the
 // fully-qualified name of the template to render.
 func (tofu *Tofu) NewRenderer(name string) *Renderer {
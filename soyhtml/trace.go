@@ -0,0 +1,146 @@
+package soyhtml
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/data"
+)
+
+// Trace records the expressions evaluated during a single render, along with
+// their inputs and results, and the branches taken by {if}, {switch}, and
+// {foreach} commands.  It is intended to answer questions like "why did this
+// {if} go the wrong way" without adding {log} statements.
+//
+// A Trace holds at most a fixed number of events and of branches; once full,
+// further evaluations or branches are not recorded and Truncated is set.
+type Trace struct {
+	Events    []TraceEvent
+	Branches  []TraceBranch
+	Truncated bool // true if events were dropped because the limit was reached
+
+	max   int            // maximum number of events to record
+	stack [][]data.Value // operand values collected for expressions in progress
+}
+
+// TraceEvent is the evaluation of a single expression.
+type TraceEvent struct {
+	Template string       // fully-qualified name of the template
+	Line     int          // line number of the expression within the soy file
+	Expr     string       // the soy source of the expression
+	Inputs   []data.Value // the values of the expression's operands, in order
+	Result   data.Value   // the value the expression evaluated to
+}
+
+// TraceBranch is a branch taken by an {if}, {switch}, or {foreach} command.
+type TraceBranch struct {
+	Template string // fully-qualified name of the template
+	Line     int    // line number of the command beginning the branch ({foreach} for ifempty)
+	Kind     string // "if", "elseif", "else", "case", "default", "foreach", or "ifempty"
+}
+
+// String formats the branch as e.g. "test.page:4: elseif".
+func (b TraceBranch) String() string {
+	return fmt.Sprintf("%s:%d: %s", b.Template, b.Line, b.Kind)
+}
+
+// NewTrace returns a trace that records up to maxEvents evaluations.  It may
+// be provided to a Renderer to be populated.
+func NewTrace(maxEvents int) *Trace {
+	return &Trace{max: maxEvents}
+}
+
+// String formats the trace as one event per line.
+func (t *Trace) String() string {
+	var buf bytes.Buffer
+	for _, ev := range t.Events {
+		buf.WriteString(ev.String())
+		buf.WriteByte('\n')
+	}
+	if t.Truncated {
+		buf.WriteString("(trace truncated)\n")
+	}
+	return buf.String()
+}
+
+func (ev TraceEvent) String() string {
+	var str = fmt.Sprintf("%s:%d: %s => %s", ev.Template, ev.Line, ev.Expr, traceValue(ev.Result))
+	if len(ev.Inputs) > 0 {
+		str += " (inputs:"
+		for _, input := range ev.Inputs {
+			str += " " + traceValue(input)
+		}
+		str += ")"
+	}
+	return str
+}
+
+// traceValue formats a value for display, allowing for undefined.
+func traceValue(v data.Value) string {
+	switch v := v.(type) {
+	case nil, data.Undefined:
+		return "undefined"
+	case data.String:
+		return fmt.Sprintf("%q", string(v))
+	}
+	return v.String()
+}
+
+// begin is called before evaluating an expression.
+func (t *Trace) begin() {
+	t.stack = append(t.stack, nil)
+}
+
+// end is called after evaluating the given expression to the given result.
+// It records the event and provides the result as an input to the enclosing
+// expression, if any.
+func (t *Trace) end(s *state, node ast.Node, result data.Value) {
+	var inputs = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+	if len(t.stack) > 0 {
+		t.stack[len(t.stack)-1] = append(t.stack[len(t.stack)-1], result)
+	}
+
+	switch node.(type) {
+	case *ast.NullNode, *ast.BoolNode, *ast.IntNode, *ast.FloatNode, *ast.StringNode:
+		return // literals are uninteresting on their own
+	}
+	if len(t.Events) >= t.max {
+		t.Truncated = true
+		return
+	}
+	t.Events = append(t.Events, TraceEvent{
+		Template: s.tmpl.Node.Name,
+		Line:     s.registry.LineNumber(s.tmpl.Node.ID(), node),
+		Expr:     node.String(),
+		Inputs:   inputs,
+		Result:   result,
+	})
+}
+
+// branch records that the branch of the given kind, beginning at the given
+// node, was taken.
+func (t *Trace) branch(s *state, node ast.Node, kind string) {
+	if len(t.Branches) >= t.max {
+		t.Truncated = true
+		return
+	}
+	t.Branches = append(t.Branches, TraceBranch{
+		Template: s.tmpl.Node.Name,
+		Line:     s.registry.LineNumber(s.tmpl.Node.ID(), node),
+		Kind:     kind,
+	})
+}
+
+// ifBranchKind returns the kind of branch of the given condition, the i'th of
+// its {if} command.
+func ifBranchKind(i int, cond *ast.IfCondNode) string {
+	switch {
+	case cond.Cond == nil:
+		return "else"
+	case i == 0:
+		return "if"
+	}
+	return "elseif"
+}
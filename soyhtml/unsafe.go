@@ -0,0 +1,121 @@
+package soyhtml
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	"github.com/harrisonzhao/soy/data"
+)
+
+// UnsafeContent is content that the application asserts is safe to include
+// in a context of the given kind, without it having been sanitized, e.g. HTML
+// from a trusted CMS.  It is printed without escaping, like the
+// data.SanitizedContent it embeds, but each print is reported to the Tofu's
+// UnsafeAuditor, so that every bypass of escaping may be audited centrally.
+//
+// Construct it with UnsafeHTML and the like, which record their caller.
+type UnsafeContent struct {
+	data.SanitizedContent
+	Origin string // file and line of the Go code that constructed it
+}
+
+// UnsafeHTML returns the given HTML as content that is printed without
+// escaping.  It must not contain untrusted input.
+func UnsafeHTML(html string) UnsafeContent {
+	return unsafeContent(data.KindHTML, html)
+}
+
+// UnsafeAttributes returns the given attribute name/value pairs as content
+// that is printed within a tag without escaping.
+func UnsafeAttributes(attrs string) UnsafeContent {
+	return unsafeContent(data.KindAttributes, attrs)
+}
+
+// UnsafeURL returns the given URL as content that is printed without
+// filtering its scheme.
+func UnsafeURL(url string) UnsafeContent {
+	return unsafeContent(data.KindURI, url)
+}
+
+// UnsafeTrustedResourceURL returns the given URL as content that may be
+// loaded as code, e.g. in a script src.
+func UnsafeTrustedResourceURL(url string) UnsafeContent {
+	return unsafeContent(data.KindTrustedResourceURI, url)
+}
+
+// UnsafeJS returns the given javascript as content that is printed in
+// scripts without escaping.
+func UnsafeJS(js string) UnsafeContent {
+	return unsafeContent(data.KindJS, js)
+}
+
+// UnsafeCSS returns the given CSS as content that is printed in styles
+// without filtering.
+func UnsafeCSS(css string) UnsafeContent {
+	return unsafeContent(data.KindCSS, css)
+}
+
+// unsafeContent returns unsafe content of the given kind, constructed by the
+// caller of its caller.
+func unsafeContent(kind data.ContentKind, content string) UnsafeContent {
+	var origin string
+	if _, file, line, ok := runtime.Caller(2); ok {
+		origin = fmt.Sprintf("%s:%d", file, line)
+	}
+	return UnsafeContent{data.SanitizedContent{kind, content}, origin}
+}
+
+// UnsafeAuditor is notified each time UnsafeContent is printed.  It must be
+// safe for concurrent use.
+type UnsafeAuditor interface {
+	AuditUnsafe(ctx context.Context, event UnsafeEvent)
+}
+
+// UnsafeAuditorFunc adapts an ordinary function to an UnsafeAuditor.
+type UnsafeAuditorFunc func(ctx context.Context, event UnsafeEvent)
+
+// AuditUnsafe calls f(ctx, event).
+func (f UnsafeAuditorFunc) AuditUnsafe(ctx context.Context, event UnsafeEvent) {
+	f(ctx, event)
+}
+
+// UnsafeEvent describes a print of UnsafeContent.
+type UnsafeEvent struct {
+	Template string           // fully-qualified name of the template printing it
+	Line     int              // line number of the print within the template's file
+	Kind     data.ContentKind // kind of content asserted
+	Content  string           // the content printed
+	Origin   string           // file and line of the Go code that constructed it
+}
+
+// AuditUnsafe sets the auditor notified each time UnsafeContent is printed.
+// Simulated renders (see Renderer.Simulate) are not audited.
+func (tofu *Tofu) AuditUnsafe(auditor UnsafeAuditor) *Tofu {
+	tofu.auditor = auditor
+	return tofu
+}
+
+// unwrapUnsafe returns the sanitized content of the given value, if it is
+// UnsafeContent, reporting its print to the auditor.  Other values are
+// returned as is.
+func (s *state) unwrapUnsafe(value data.Value) data.Value {
+	var unsafe, ok = value.(UnsafeContent)
+	if !ok {
+		return value
+	}
+	if s.auditor != nil {
+		var ctx = s.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		s.auditor.AuditUnsafe(ctx, UnsafeEvent{
+			Template: s.tmpl.Node.Name,
+			Line:     s.registry.LineNumber(s.tmpl.Node.ID(), s.node),
+			Kind:     unsafe.Kind,
+			Content:  unsafe.Content,
+			Origin:   unsafe.Origin,
+		})
+	}
+	return unsafe.SanitizedContent
+}
@@ -0,0 +1,226 @@
+package soyhtml
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/template"
+)
+
+// Usage accumulates the templates rendered and the branches taken by renders
+// over time, e.g. in production, where exercised code is known for certain
+// rather than estimated by static reachability.  Its report may be exported
+// periodically, merged with those of other instances, and used to find
+// templates that are never rendered (see UsageReport.Unused).
+//
+// Each render collects its usage privately and adds it to the Usage once it
+// completes, so renders contend for its lock only once each.  The templates
+// within cached output (see Tofu.Cache) are not counted when the output is
+// served from the cache.
+type Usage struct {
+	mu        sync.Mutex
+	since     time.Time
+	renders   int64
+	templates map[string]int64                // renders of each template, by ID
+	branches  map[branchLocation]*BranchUsage // times each branch was taken
+}
+
+// usageBranch identifies a branch of a template within a render.
+type usageBranch struct {
+	node ast.Node // the node beginning the branch
+	kind string   // as TraceBranch.Kind
+}
+
+// branchLocation identifies a branch of a template by its location, which,
+// unlike its node, is the same in the trees of a registry that is reloaded.
+type branchLocation struct {
+	id   string // ID of the template (see ast.TemplateNode.ID)
+	line int    // line number of the command beginning the branch
+	kind string // as TraceBranch.Kind
+}
+
+// renderUsage is the usage of a single render.
+type renderUsage struct {
+	templates map[*ast.TemplateNode]int64
+	branches  map[usageBranch]*branchTaken
+}
+
+type branchTaken struct {
+	tmpl  *ast.TemplateNode
+	count int64
+}
+
+// UsageReport is the usage accumulated since a point in time.
+type UsageReport struct {
+	Since     time.Time       `json:"since"`
+	Renders   int64           `json:"renders"`   // number of renders
+	Templates []TemplateUsage `json:"templates"` // ordered by ID
+	Branches  []BranchUsage   `json:"branches"`  // ordered by template and line
+}
+
+// TemplateUsage is the number of times a template was rendered, either
+// directly or by a {call}.
+type TemplateUsage struct {
+	Template string `json:"template"` // ID of the template (see ast.TemplateNode.ID)
+	Count    int64  `json:"count"`
+}
+
+// BranchUsage is the number of times a branch was taken, as identified by
+// TraceBranch.
+type BranchUsage struct {
+	Template string `json:"template"` // fully-qualified name of the template
+	Line     int    `json:"line"`     // line number of the command beginning the branch
+	Kind     string `json:"kind"`     // as TraceBranch.Kind
+	Count    int64  `json:"count"`
+}
+
+// NewUsage returns an empty Usage.
+func NewUsage() *Usage {
+	var u = &Usage{}
+	u.Reset()
+	return u
+}
+
+// Usage sets the accumulator of the templates rendered and branches taken by
+// each render, or nil to not accumulate them.  It may be shared between Tofus
+// rendering the same registry.
+func (tofu *Tofu) Usage(usage *Usage) *Tofu {
+	tofu.usage = usage
+	return tofu
+}
+
+// Reset discards the usage accumulated so far.
+func (u *Usage) Reset() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.since = time.Now()
+	u.renders = 0
+	u.templates = make(map[string]int64)
+	u.branches = make(map[branchLocation]*BranchUsage)
+}
+
+// Report returns the usage accumulated since the Usage was created or last
+// reset.
+func (u *Usage) Report() UsageReport {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var report = UsageReport{Since: u.since, Renders: u.renders}
+	for id, count := range u.templates {
+		report.Templates = append(report.Templates, TemplateUsage{id, count})
+	}
+	for _, branch := range u.branches {
+		report.Branches = append(report.Branches, *branch)
+	}
+	report.sort()
+	return report
+}
+
+// add adds the usage of a completed render of the given registry.
+func (u *Usage) add(render *renderUsage, registry *template.Registry) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.renders++
+	for tmpl, count := range render.templates {
+		u.templates[tmpl.ID()] += count
+	}
+	for key, taken := range render.branches {
+		var loc = branchLocation{
+			taken.tmpl.ID(), registry.LineNumber(taken.tmpl.ID(), key.node), key.kind}
+		var branch, ok = u.branches[loc]
+		if !ok {
+			branch = &BranchUsage{Template: taken.tmpl.Name, Line: loc.line, Kind: loc.kind}
+			u.branches[loc] = branch
+		}
+		branch.Count += taken.count
+	}
+}
+
+// template records that the given template was rendered.
+func (r *renderUsage) template(tmpl *ast.TemplateNode) {
+	r.templates[tmpl]++
+}
+
+// branch records that the branch of the given kind, beginning at the given
+// node of the given template, was taken.
+func (r *renderUsage) branch(tmpl *ast.TemplateNode, node ast.Node, kind string) {
+	var key = usageBranch{node, kind}
+	var taken, ok = r.branches[key]
+	if !ok {
+		taken = &branchTaken{tmpl: tmpl}
+		r.branches[key] = taken
+	}
+	taken.count++
+}
+
+func newRenderUsage() *renderUsage {
+	return &renderUsage{
+		templates: make(map[*ast.TemplateNode]int64),
+		branches:  make(map[usageBranch]*branchTaken),
+	}
+}
+
+// Merge returns the combined usage of the two reports, e.g. of consecutive
+// periods or of several instances.  It is since the earlier of the two.
+func (r UsageReport) Merge(other UsageReport) UsageReport {
+	var result = UsageReport{Since: r.Since, Renders: r.Renders + other.Renders}
+	if result.Since.IsZero() || !other.Since.IsZero() && other.Since.Before(result.Since) {
+		result.Since = other.Since
+	}
+	var templates = make(map[string]int64)
+	var branches = make(map[BranchUsage]int64)
+	for _, report := range []UsageReport{r, other} {
+		for _, t := range report.Templates {
+			templates[t.Template] += t.Count
+		}
+		for _, b := range report.Branches {
+			var count = b.Count
+			b.Count = 0
+			branches[b] += count
+		}
+	}
+	for id, count := range templates {
+		result.Templates = append(result.Templates, TemplateUsage{id, count})
+	}
+	for b, count := range branches {
+		b.Count = count
+		result.Branches = append(result.Branches, b)
+	}
+	result.sort()
+	return result
+}
+
+// Unused returns the IDs (see ast.TemplateNode.ID) of the templates of the
+// registry that were never rendered, in the order of the registry.  The
+// longer the report's period, the more confidently they may be deleted.
+func (r UsageReport) Unused(registry *template.Registry) []string {
+	var used = make(map[string]bool)
+	for _, t := range r.Templates {
+		used[t.Template] = true
+	}
+	var result []string
+	_ = registry.LoadAll() // files that fail to load have no templates
+	for _, t := range registry.Templates {
+		if id := t.Node.ID(); !used[id] {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func (r *UsageReport) sort() {
+	sort.Slice(r.Templates, func(i, j int) bool {
+		return r.Templates[i].Template < r.Templates[j].Template
+	})
+	sort.Slice(r.Branches, func(i, j int) bool {
+		var a, b = r.Branches[i], r.Branches[j]
+		if a.Template != b.Template {
+			return a.Template < b.Template
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Kind < b.Kind
+	})
+}
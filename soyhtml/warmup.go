@@ -0,0 +1,141 @@
+package soyhtml
+
+import (
+	"fmt"
+
+	"github.com/harrisonzhao/soy/ast"
+	soyt "github.com/harrisonzhao/soy/template"
+)
+
+// Warmup prepares the named templates, and every template they call, to be
+// rendered, so that problems that would fail the first requests after a
+// deploy are reported at startup instead.  With no names, all templates are
+// prepared.  It returns a *RenderError for the first problem found.
+//
+// Templates are rendered directly from their parse trees, so there is nothing
+// to compile; preparing a template resolves each template, function, and
+// print directive that it uses, and checks the number of arguments passed to
+// each.  The contexts of contextually autoescaped templates are inferred as
+// well, and those with ambiguous contexts fail.
+func (tofu *Tofu) Warmup(names ...string) error {
+	var w = warmer{tofu, make(map[string]bool)}
+	if len(names) == 0 {
+		if err := tofu.registry.LoadAll(); err != nil {
+			return err
+		}
+		for _, t := range tofu.registry.Templates {
+			if err := w.warm(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, name := range names {
+		var t, ok = tofu.registry.Template(name)
+		if !ok {
+			return fmt.Errorf("%s: %v", name, ErrTemplateNotFound)
+		}
+		if err := w.warm(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type warmer struct {
+	tofu   *Tofu
+	warmed map[string]bool // IDs of the templates prepared so far
+}
+
+// warm prepares the given template and those it calls.
+func (w warmer) warm(t soyt.Template) (err error) {
+	var id = t.Node.ID()
+	if w.warmed[id] {
+		return nil
+	}
+	w.warmed[id] = true
+
+	var s = &state{tmpl: t, registry: *w.tofu.registry, debug: w.tofu.debug}
+	defer s.errRecover(&err)
+	var callees []soyt.Template
+	ast.Walk(t.Node, func(node ast.Node) bool {
+		s.at(node)
+		switch node := node.(type) {
+		case *ast.CallNode:
+			callees = append(callees, s.callees(node)...)
+		case *ast.FunctionNode:
+			s.checkFunc(node)
+		case *ast.PrintDirectiveNode:
+			s.checkDirective(node)
+		}
+		return true
+	})
+
+	// The contexts are inferred for every template, which may be rendered
+	// with a CSP nonce, but only fail contextually autoescaped ones.
+	var inf = w.tofu.contexts.get(t.Node)
+	var mode = t.Node.Autoescape
+	if mode == ast.AutoescapeUnspecified {
+		mode = t.Namespace.Autoescape
+	}
+	if mode == ast.AutoescapeContextual && inf.err != nil {
+		s.at(inf.err.node)
+		s.errorf("%s", inf.err)
+	}
+	for _, callee := range callees {
+		if err = w.warm(callee); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// callees returns the templates that may be rendered by the given call.
+func (s *state) callees(node *ast.CallNode) []soyt.Template {
+	if !node.Delegate {
+		var t, ok = s.registry.Template(node.Name)
+		if !ok {
+			s.errorf("failed to find template: %s", node.Name)
+		}
+		return []soyt.Template{t}
+	}
+	var result = s.registry.Delegates(node.Name)
+	if len(result) == 0 && !node.AllowEmptyDefault {
+		s.errorf("failed to find delegate template: %s", node.Name)
+	}
+	return result
+}
+
+// checkFunc fails if the given function does not exist or is called with the
+// wrong number of arguments.
+func (s *state) checkFunc(node *ast.FunctionNode) {
+	var lengths []int
+	if _, ok := loopFuncs[node.Name]; ok {
+		lengths = []int{1}
+	} else if _, ok := debugFuncs[node.Name]; ok {
+		if !s.debug {
+			s.errorf("Function %q is only available in debug mode", node.Name)
+		}
+		lengths = []int{0}
+	} else if fn, ok := Funcs[node.Name]; ok {
+		lengths = fn.ValidArgLengths
+	} else {
+		s.errorf("unrecognized function name: %s", node.Name)
+	}
+	if !checkNumArgs(lengths, len(node.Args)) {
+		s.errorf("Function %q called with %v args, expected: %v", node.Name, len(node.Args), lengths)
+	}
+}
+
+// checkDirective fails if the given print directive does not exist or is
+// called with the wrong number of arguments.
+func (s *state) checkDirective(node *ast.PrintDirectiveNode) {
+	var directive, ok = PrintDirectives[node.Name]
+	if !ok {
+		s.errorf("Print directive %q does not exist", node.Name)
+	}
+	if !checkNumArgs(directive.ValidArgLengths, len(node.Args)) {
+		s.errorf("Print directive %q called with %v args, expected one of: %v",
+			node.Name, len(node.Args), directive.ValidArgLengths)
+	}
+}
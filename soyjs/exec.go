@@ -11,6 +11,7 @@ import (
 	"github.com/harrisonzhao/soy/ast"
 	"github.com/harrisonzhao/soy/data"
 	"github.com/harrisonzhao/soy/soyhtml"
+	"github.com/harrisonzhao/soy/soymsg"
 )
 
 type state struct {
@@ -24,6 +25,7 @@ type state struct {
 	autoescape   ast.AutoescapeType
 	lastNode     ast.Node
 	options      Options
+	positions    *positionWriter // records the positions of the output, if requested
 }
 
 // Write writes the javascript represented by the given node to the given
@@ -31,6 +33,10 @@ type state struct {
 func Write(out io.Writer, node ast.Node, options Options) (err error) {
 	defer errRecover(&err)
 	var s = &state{wr: out, options: options}
+	if options.Positions != nil {
+		s.positions = newPositionWriter(out, options.Positions, node)
+		s.wr = s.positions
+	}
 	s.scope.push()
 	s.walk(node)
 	return nil
@@ -40,6 +46,9 @@ func Write(out io.Writer, node ast.Node, options Options) (err error) {
 func (s *state) at(node ast.Node) {
 	s.lastNode = s.node
 	s.node = node
+	if s.positions != nil {
+		s.positions.mark(node)
+	}
 }
 
 // errorf formats the error and terminates processing.
@@ -65,7 +74,7 @@ func (s *state) walk(node ast.Node) {
 		s.visitSoyFile(node)
 	case *ast.NamespaceNode:
 		s.visitNamespace(node)
-	case *ast.SoyDocNode:
+	case *ast.SoyDocNode, *ast.DelPackageNode:
 		return
 	case *ast.TemplateNode:
 		s.visitTemplate(node)
@@ -78,6 +87,8 @@ func (s *state) walk(node ast.Node) {
 	case *ast.PrintNode:
 		s.visitPrint(node)
 	case *ast.MsgNode:
+		s.visitMsg(node)
+	case *ast.MsgPlaceholderNode:
 		s.walk(node.Body)
 	case *ast.CssNode:
 		if node.Expr != nil {
@@ -100,6 +111,10 @@ func (s *state) walk(node ast.Node) {
 		s.visitFor(node)
 	case *ast.SwitchNode:
 		s.visitSwitch(node)
+	case *ast.PluralNode:
+		s.visitPlural(node)
+	case *ast.SelectNode:
+		s.visitSelect(node)
 	case *ast.CallNode:
 		s.visitCall(node)
 	case *ast.LetValueNode:
@@ -109,6 +124,10 @@ func (s *state) walk(node ast.Node) {
 		s.bufferName = s.scope.makevar(node.Name)
 		s.jsln("var ", s.bufferName, " = '';")
 		s.walk(node.Body)
+		if ordainer, ok := ordainers[node.Kind]; ok {
+			s.jsln(s.bufferName, " = soydata.VERY_UNSAFE.", ordainer, "(", s.bufferName, ");")
+			s.scope.setkind(node.Name, node.Kind)
+		}
 		s.bufferName = oldBufferName
 
 	// Values ----------
@@ -137,14 +156,12 @@ func (s *state) walk(node ast.Node) {
 		s.js("]")
 	case *ast.MapLiteralNode:
 		s.js("{")
-		var first = true
-		for k, v := range node.Items {
-			if !first {
+		for i, k := range node.Keys() {
+			if i > 0 {
 				s.js(",")
 			}
-			first = false
 			s.js(k, ":")
-			s.walk(v)
+			s.walk(node.Items[k])
 		}
 		s.js("}")
 	case *ast.FunctionNode:
@@ -250,8 +267,16 @@ func (s *state) visitTemplate(node *ast.TemplateNode) {
 		}
 	}
 
+	var funcName = node.Name
+	if node.Delegate {
+		funcName = delTemplateFuncName(s.namespace, node)
+	}
+
 	s.jsln("")
-	s.jsln(node.Name, " = function(opt_data, opt_sb, opt_ijData) {")
+	if soydoc, ok := s.lastNode.(*ast.SoyDocNode); ok {
+		s.writeJSDoc(soydoc, allOptionalParams)
+	}
+	s.jsln(funcName, " = function(opt_data, opt_sb, opt_ijData) {")
 	s.indentLevels++
 	if allOptionalParams {
 		s.jsln("opt_data = opt_data || {};")
@@ -262,9 +287,180 @@ func (s *state) visitTemplate(node *ast.TemplateNode) {
 	s.jsln("return output;")
 	s.indentLevels--
 	s.jsln("};")
+	if node.Delegate {
+		s.jsln("soy.$$registerDelegateFn(soy.$$getDelTemplateId(", jsString(node.Name), "), ",
+			jsString(node.Variant), ", ", node.Priority, ", ", funcName, ");")
+	}
 	s.autoescape = oldAutoescape
 }
 
+// jsString returns the given string as a javascript string literal.
+func jsString(str string) string {
+	return "'" + template.JSEscapeString(str) + "'"
+}
+
+// delTemplateFuncName returns the name of the function implementing the given
+// delegate template, which is defined within the file's namespace.
+func delTemplateFuncName(namespace string, node *ast.TemplateNode) string {
+	var sanitize = func(r rune) rune {
+		if r == '_' || '0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}
+	var name = namespace + ".__deltemplate_" + strings.Map(sanitize, node.Name)
+	if node.Variant != "" {
+		name += "_" + strings.Map(sanitize, node.Variant)
+	}
+	return name
+}
+
+// visitMsg writes the message, substituting its translation if one was
+// provided in the options.
+func (s *state) visitMsg(node *ast.MsgNode) {
+	var msg *soymsg.Message
+	if s.options.Messages != nil {
+		msg = s.options.Messages.Message(node.ID)
+	}
+	if msg == nil {
+		s.walk(node.Body)
+		return
+	}
+	s.visitMsgParts(node, msg.Parts)
+}
+
+// visitMsgParts writes the given parts of a translation of the given message.
+func (s *state) visitMsgParts(node *ast.MsgNode, parts []soymsg.Part) {
+	for _, part := range parts {
+		switch part := part.(type) {
+		case soymsg.RawTextPart:
+			s.writeRawText([]byte(part.Text))
+		case soymsg.PlaceholderPart:
+			var ph = soymsg.Placeholder(node, part.Name)
+			if ph == nil {
+				s.errorf("translation of message %d has unknown placeholder %q", node.ID, part.Name)
+			}
+			s.walk(ph.Body)
+		case soymsg.PluralPart:
+			var plural = soymsg.Plural(node, part.VarName)
+			if plural == nil {
+				s.errorf("translation of message %d has unknown plural %q", node.ID, part.VarName)
+			}
+			// Exact cases, e.g. "=1", take precedence over the CLDR plural
+			// category of the count in the locale, e.g. "one".
+			var count = s.scope.tempvar("pluralCount")
+			s.jsln("var ", count, " = ", plural.Value, ";")
+			s.indent()
+			s.js("switch (")
+			for _, pluralCase := range part.Cases {
+				if strings.HasPrefix(pluralCase.Spec, "=") {
+					s.js(count, " == ", strings.TrimPrefix(pluralCase.Spec, "="), " ? ",
+						&ast.StringNode{plural.Pos, "", pluralCase.Spec}, " : ")
+				}
+			}
+			var locale interface{} = "undefined"
+			if s.options.Locale != "" {
+				locale = &ast.StringNode{plural.Pos, "", s.options.Locale}
+			}
+			s.js("soy.$$pluralCategory(", count, ", ", locale, ")) {\n")
+			s.indentLevels++
+			for _, pluralCase := range part.Cases {
+				if pluralCase.Spec == "other" {
+					s.jsln("default:")
+				} else {
+					s.jsln("case ", &ast.StringNode{plural.Pos, "", pluralCase.Spec}, ":")
+				}
+				s.indentLevels++
+				s.visitMsgParts(node, pluralCase.Parts)
+				s.jsln("break;")
+				s.indentLevels--
+			}
+			s.indentLevels--
+			s.jsln("}")
+		case soymsg.SelectPart:
+			var sel = soymsg.Select(node, part.VarName)
+			if sel == nil {
+				s.errorf("translation of message %d has unknown select %q", node.ID, part.VarName)
+			}
+			s.jsln("switch (", sel.Value, ") {")
+			s.indentLevels++
+			for _, selectCase := range part.Cases {
+				if selectCase.Value == "other" {
+					s.jsln("default:")
+				} else {
+					s.jsln("case ", &ast.StringNode{sel.Pos, "", selectCase.Value}, ":")
+				}
+				s.indentLevels++
+				s.visitMsgParts(node, selectCase.Parts)
+				s.jsln("break;")
+				s.indentLevels--
+			}
+			s.indentLevels--
+			s.jsln("}")
+		}
+	}
+}
+
+// writeJSDoc writes a JSDoc comment describing the template and its params.
+func (s *state) writeJSDoc(soydoc *ast.SoyDocNode, allOptionalParams bool) {
+	s.jsln("/**")
+	for _, line := range strings.Split(soydoc.Desc, "\n") {
+		if line != "" {
+			s.jsln(" * ", jsdocEscape(line))
+		}
+	}
+	if len(soydoc.Params) == 0 {
+		s.jsln(" * @param {Object<string, *>=} opt_data")
+	} else {
+		var fields []string
+		for _, param := range soydoc.Params {
+			var typ = "*"
+			if param.Optional {
+				typ = "(*|undefined)"
+			}
+			fields = append(fields, param.Name+": "+typ)
+		}
+		var optional = ""
+		if allOptionalParams {
+			optional = "="
+		}
+		s.jsln(" * @param {{", strings.Join(fields, ", "), "}", optional, "} opt_data")
+		for _, param := range soydoc.Params {
+			if param.Desc != "" {
+				s.jsln(" *     ", param.Name, ": ", jsdocEscape(param.Desc))
+			}
+		}
+	}
+	s.jsln(" * @param {*=} opt_sb")
+	s.jsln(" * @param {Object<string, *>=} opt_ijData")
+	s.jsln(" * @return {string}")
+	if soydoc.Deprecated {
+		s.jsln(strings.TrimRight(" * @deprecated "+jsdocEscape(soydoc.DeprecationNote), " "))
+	}
+	s.jsln(" */")
+}
+
+// jsdocEscape prevents the given text from terminating a doc comment.
+func jsdocEscape(text string) string {
+	return strings.Replace(text, "*/", "*&#47;", -1)
+}
+
+// ordainers maps a let block's content kind to the soydata function that marks
+// the rendered block as sanitized content of that kind.
+var ordainers = map[data.ContentKind]string{
+	data.KindHTML:       "ordainSanitizedHtml",
+	data.KindAttributes: "ordainSanitizedHtmlAttribute",
+	data.KindURI:        "ordainSanitizedUri",
+	data.KindJS:         "ordainSanitizedJs",
+	data.KindCSS:        "ordainSanitizedCss",
+}
+
+// nativeDirectives are the print directives implemented by javascript
+// functions, rather than by soyutils.
+var nativeDirectives = map[string]string{
+	"encodeUriComponent": "encodeURIComponent",
+}
+
 // TODO: unify print directives
 func (s *state) visitPrint(node *ast.PrintNode) {
 	var escape = s.autoescape
@@ -280,18 +476,32 @@ func (s *state) visitPrint(node *ast.PrintNode) {
 		switch dir.Name {
 		case "id", "noAutoescape":
 			// no implementation, they just serve as a marker to cancel autoescape.
+		case "filterTrustedResourceUri", "markdown", "base64Encode", "base64Decode":
+			// soyutils has no trusted resource URI content to allow,
+			// markdown renderer, or base64 codec.
+			s.errorf("Print directive %q is not supported in javascript", dir.Name)
 		default:
 			directives = append(directives, dir)
 		}
 	}
 	if escape != ast.AutoescapeOff {
-		directives = append([]*ast.PrintDirectiveNode{{0, "escapeHtml", nil}}, directives...)
+		// Only strict autoescaping prints attributes content within tags.
+		var escaper = "escapeHtml"
+		if ref, ok := node.Arg.(*ast.DataRefNode); ok && len(ref.Access) == 0 &&
+			escape == ast.AutoescapeStrict && s.scope.kind(ref.Key) == data.KindAttributes {
+			escaper = "filterHtmlAttributes"
+		}
+		directives = append([]*ast.PrintDirectiveNode{{0, escaper, nil}}, directives...)
 	}
 
 	s.indent()
 	s.js(s.bufferName, " += ")
 	for _, dir := range directives {
-		s.js("soy.$$", dir.Name, "(")
+		if fn, ok := nativeDirectives[dir.Name]; ok {
+			s.js(fn, "(")
+		} else {
+			s.js("soy.$$", dir.Name, "(")
+		}
 	}
 	s.walk(node.Arg)
 	for i := range directives {
@@ -317,18 +527,35 @@ func (s *state) visitFunction(node *ast.FunctionNode) {
 	}
 
 	switch node.Name {
-	case "isFirst":
-		// TODO: Add compile-time check that this is only called on loop variable.
-		s.js("(", s.scope.loopindex(), " == 0)")
-	case "isLast":
-		s.js("(", s.scope.loopindex(), " == ", s.scope.looplimit(), " - 1)")
-	case "index":
-		s.js(s.scope.loopindex())
+	case "isFirst", "isLast", "index":
+		var loopIndex, loopLimit = s.loopVarOf(node)
+		switch node.Name {
+		case "isFirst":
+			s.js("(", loopIndex, " == 0)")
+		case "isLast":
+			s.js("(", loopIndex, " == ", loopLimit, " - 1)")
+		case "index":
+			s.js(loopIndex)
+		}
 	default:
 		s.errorf("unimplemented function: %v", node.Name)
 	}
 }
 
+// loopVarOf returns the JS names of the index and limit of the loop binding the
+// variable passed to the given loop function.
+func (s *state) loopVarOf(node *ast.FunctionNode) (index, limit string) {
+	if len(node.Args) == 1 {
+		if ref, ok := node.Args[0].(*ast.DataRefNode); ok && len(ref.Access) == 0 {
+			index, limit = s.scope.loopindexof(ref.Key), s.scope.looplimitof(ref.Key)
+		}
+	}
+	if index == "" {
+		s.errorf("Function %q must be called on a loop variable: %v", node.Name, node)
+	}
+	return index, limit
+}
+
 func (s *state) visitDataRef(node *ast.DataRefNode) {
 	var expr string
 	if node.Key == "ij" {
@@ -385,12 +612,24 @@ func (s *state) visitCall(node *ast.CallNode) {
 				s.bufferName = s.scope.makevar("param")
 				s.jsln("var ", s.bufferName, " = '';")
 				s.walk(param.Content)
+				if ordainer, ok := ordainers[param.Kind]; ok {
+					s.jsln(s.bufferName, " = soydata.VERY_UNSAFE.", ordainer, "(", s.bufferName, ");")
+				}
 				dataExpr += param.Key + ": " + s.bufferName
 				s.bufferName = oldBufferName
 			}
 		}
 		dataExpr += "})"
 	}
+	if node.Delegate {
+		var variant interface{} = "''"
+		if node.Variant != nil {
+			variant = node.Variant
+		}
+		s.jsln(s.bufferName, " += soy.$$getDelegateFn(soy.$$getDelTemplateId(", jsString(node.Name), "), ",
+			variant, ", ", node.AllowEmptyDefault, ")(", dataExpr, ", opt_sb, opt_ijData);")
+		return
+	}
 	s.jsln(s.bufferName, " += ", node.Name, "(", dataExpr, ", opt_sb, opt_ijData);")
 }
 
@@ -414,10 +653,10 @@ func (s *state) visitIf(node *ast.IfNode) {
 }
 
 func (s *state) visitFor(node *ast.ForNode) {
-	if _, isForeach := node.List.(*ast.DataRefNode); isForeach {
-		s.visitForeach(node)
-	} else {
+	if node.IsRange() {
 		s.visitForRange(node)
+	} else {
+		s.visitForeach(node)
 	}
 }
 
@@ -443,9 +682,16 @@ func (s *state) visitForRange(node *ast.ForNode) {
 		varLimit = s.scope.pushForRange(node.Var)
 	defer s.scope.pop()
 	s.jsln("var ", varLimit, " = ", limit, ";")
-	s.jsln("for (var ", varIndex, " = ", init, "; ",
-		varIndex, " < ", varLimit, "; ",
-		varIndex, " += ", increment, ") {")
+	if node.IndexVar != "" {
+		var varCount = s.scope.makevar(node.IndexVar)
+		s.jsln("for (var ", varIndex, " = ", init, ", ", varCount, " = 0; ",
+			varIndex, " < ", varLimit, "; ",
+			varIndex, " += ", increment, ", ", varCount, "++) {")
+	} else {
+		s.jsln("for (var ", varIndex, " = ", init, "; ",
+			varIndex, " < ", varLimit, "; ",
+			varIndex, " += ", increment, ") {")
+	}
 	s.indentLevels++
 	s.walk(node.Body)
 	s.indentLevels--
@@ -458,6 +704,9 @@ func (s *state) visitForeach(node *ast.ForNode) {
 		itemListLen,
 		itemIndex = s.scope.pushForEach(node.Var)
 	defer s.scope.pop()
+	if node.IndexVar != "" {
+		s.scope.setvar(node.IndexVar, itemIndex)
+	}
 	s.jsln("var ", itemList, " = ", node.List, ";")
 	s.jsln("var ", itemListLen, " = ", itemList, ".length;")
 	if node.IfEmpty != nil {
@@ -499,6 +748,44 @@ func (s *state) visitSwitch(node *ast.SwitchNode) {
 	s.jsln("}")
 }
 
+func (s *state) visitPlural(node *ast.PluralNode) {
+	s.jsln("switch (", node.Value, ") {")
+	s.indentLevels++
+	for _, pluralCase := range node.Cases {
+		s.jsln("case ", pluralCase.Value, ":")
+		s.indentLevels++
+		s.walk(pluralCase.Body)
+		s.jsln("break;")
+		s.indentLevels--
+	}
+	s.jsln("default:")
+	s.indentLevels++
+	s.walk(node.Default)
+	s.jsln("break;")
+	s.indentLevels--
+	s.indentLevels--
+	s.jsln("}")
+}
+
+func (s *state) visitSelect(node *ast.SelectNode) {
+	s.jsln("switch (", node.Value, ") {")
+	s.indentLevels++
+	for _, selectCase := range node.Cases {
+		s.jsln("case ", &ast.StringNode{selectCase.Pos, "", selectCase.Value}, ":")
+		s.indentLevels++
+		s.walk(selectCase.Body)
+		s.jsln("break;")
+		s.indentLevels--
+	}
+	s.jsln("default:")
+	s.indentLevels++
+	s.walk(node.Default)
+	s.jsln("break;")
+	s.indentLevels--
+	s.indentLevels--
+	s.jsln("}")
+}
+
 // visitGlobal constructs a primitive node from its value and uses walk to
 // render the right thing.
 func (s *state) visitGlobal(node *ast.GlobalNode) {
@@ -276,6 +276,32 @@ func TestCall(t *testing.T) {
 	})
 }
 
+func TestCallChildren(t *testing.T) {
+	runExecTests(t, []execTest{
+		{"children", "test.page", `{namespace test}
+
+/** @param name */
+{template .page}
+{call .layout}
+  {param title: 'Hi' /}
+  <p>Hello {$name}</p>
+{/call}
+{/template}
+
+/**
+ * @param title
+ * @param children
+ */
+{template .layout}
+<h1>{$title}</h1><main>{$children}</main>
+{/template}`,
+			"<h1>Hi</h1><main><p>Hello &lt;b&gt;</p></main>",
+			d{"name": "<b>"},
+			true,
+		},
+	})
+}
+
 func TestDataRefs(t *testing.T) {
 	runExecTests(t, []execTest{
 		// single key
@@ -434,6 +460,8 @@ func TestPrintDirectives(t *testing.T) {
 		exprtestwdata("ejs5", "{$var|escapeJsString}", `\x22foo\x22`, d{"var": `"foo"`}),
 		exprtestwdata("ejs5", "{$var|escapeJsString}", `42`, d{"var": 42}),
 
+		exprtest("encodeUriComponent", "{'a b&c/d?(!)'|encodeUriComponent}", "a%20b%26c%2Fd%3F(!)"),
+
 		exprtest("truncate", "{'Lorem Ipsum' |truncate:8}", "Lorem..."),
 		exprtest("truncate w arg", "{'Lorem Ipsum' |truncate:8,false}", "Lorem Ip"),
 		exprtest("truncate w expr", "{'Lorem Ipsum' |truncate:5+3,not true}", "Lorem Ip"),
@@ -465,6 +493,49 @@ func TestInjectedData(t *testing.T) {
 	})
 }
 
+func TestFlagEnabledFromIJ(t *testing.T) {
+	ij["flags"] = data.Map{"newHeader": data.Bool(true), "oldFooter": data.Bool(false)}
+	defer delete(ij, "flags")
+	runExecTests(t, []execTest{
+		exprtest("flags", `{if flagEnabled('newHeader')}new{/if} {flagEnabled('oldFooter')} {flagEnabled('other')}`,
+			`new false false`),
+	})
+}
+
+func TestLocalizeUriWithoutMapper(t *testing.T) {
+	runExecTests(t, []execTest{
+		exprtest("localizeUri", `{localizeUri('/about')} {localizeUri('/about', 'fr')}`, `/about /about`),
+	})
+}
+
+func TestSort(t *testing.T) {
+	var people = []interface{}{
+		map[string]interface{}{"id": 1, "name": "bo"},
+		map[string]interface{}{"id": 2},
+		map[string]interface{}{"id": 3, "name": "Al"},
+		map[string]interface{}{"id": 4, "name": "al"},
+		map[string]interface{}{"id": 5, "name": "Al"},
+	}
+	runExecTests(t, []execTest{
+		exprtestwdata("sort", `{foreach $n in sort($names)}{$n} {/foreach}`, `9.5 10 a B b Ä null `,
+			d{"names": []interface{}{"b", nil, "Ä", 10, "a", "B", 9.5}}),
+		exprtestwdata("sortDesc", `{foreach $n in sortDesc($names)}{$n} {/foreach}`, `Ä b B a 10 9.5 null `,
+			d{"names": []interface{}{"b", nil, "Ä", 10, "a", "B", 9.5}}),
+		exprtestwdata("sort field", `{foreach $p in sort($people, 'name')}{$p.id} {/foreach}`, `3 5 4 1 2 `,
+			d{"people": people}),
+		exprtestwdata("sortDesc field", `{foreach $p in sortDesc($people, 'name')}{$p.id} {/foreach}`, `1 4 3 5 2 `,
+			d{"people": people}),
+	})
+}
+
+func TestToJson(t *testing.T) {
+	runExecTests(t, []execTest{
+		exprtestwdata("toJson", `{toJson($x)}`, `{&quot;a&quot;:&quot;\u003c/script\u003e&quot;}`,
+			d{"x": d{"a": "</script>"}}),
+		exprtestwdata("toJson undefined", `{toJson($x.missing)}`, `null`, d{"x": d{}}),
+	})
+}
+
 func TestAutoescapeModes(t *testing.T) {
 	runExecTests(t, []execTest{
 		{"template autoescape=false", "test.autoescapeoff", `{namespace test}
@@ -821,7 +892,7 @@ func initJs(t *testing.T) *otto.Otto {
 	var i = 1
 	for scanner.Scan() {
 		switch i {
-		case 2565, 2579, 2586:
+		case 2644, 2658, 2665:
 			// skip these regexes
 			// soy.esc.$$FILTER_FOR_FILTER_CSS_VALUE_
 			// soy.esc.$$FILTER_FOR_FILTER_HTML_ATTRIBUTES_
@@ -853,3 +924,43 @@ func numberLines(soyfile io.Reader) string {
 	}
 	return buf.String()
 }
+
+func TestKindedLet(t *testing.T) {
+	runExecTests(t, []execTest{
+		exprtestwdata("let kind attributes", `
+{let $attrs kind="attributes"}
+  {if $checked}checked {/if}title="{$title}"
+{/let}
+<input {$attrs}>`, `<input checked title=&quot;&amp;lt;b&amp;gt;&quot;>`, d{"checked": true, "title": "<b>"}),
+
+		{"let kind attributes strict", "test.strict", `{namespace test autoescape="strict"}
+{template .strict}
+{let $attrs kind="attributes"}
+  {if $checked}checked {/if}title="{$title}"
+{/let}
+<input {$attrs}>
+{/template}`, `<input checked title="&lt;b&gt;">`, d{"checked": true, "title": "<b>"}, true},
+
+		exprtestwdata("let kind uri", `
+{let $href kind="uri"}/search?q={$q}&amp;s=1{/let}
+<a href="{$href}">`, `<a href="/search?q=a&amp;amp;s=1">`, d{"q": "a"}),
+
+		exprtest("let kind html", `{let $b kind="html"}<b>hi</b>{/let}{$b} {$b|escapeHtml}`, `<b>hi</b> <b>hi</b>`),
+		exprtest("let kind text", `{let $b kind="text"}<b>hi</b>{/let}{$b}`, `&lt;b&gt;hi&lt;/b&gt;`),
+		exprtest("let kind concat", `{let $b kind="html"}<b>hi</b>{/let}{$b + '!'}`, `&lt;b&gt;hi&lt;/b&gt;!`),
+	})
+}
+
+func TestForIndexVar(t *testing.T) {
+	runExecTests(t, []execTest{
+		exprtestwdata("foreach index var",
+			`{for $x, $i in $list}{$i}:{$x}{if not isLast($x)}, {/if}{/for}`,
+			`0:a, 1:b, 2:c`, d{"list": []string{"a", "b", "c"}}),
+		exprtestwdata("nested loop funcs",
+			`{foreach $x in $list}{foreach $y in $list}{index($x)}{index($y)} {/foreach}{/foreach}`,
+			`00 01 10 11 `, d{"list": []int{1, 2}}),
+		exprtest("range index var",
+			`{for $n, $i in range(10, 16, 2)}{$i}={$n} {/for}`,
+			`0=10 1=12 2=14 `),
+	})
+}
@@ -39,6 +39,11 @@ var Funcs = map[string]Func{
 	"bidiDirAttr":   {funcBidiDirAttr, []int{0}},
 	"bidiStartEdge": {funcBidiStartEdge, []int{0}},
 	"bidiEndEdge":   {funcBidiEndEdge, []int{0}},
+	"flagEnabled":   {funcFlagEnabled, []int{1}},
+	"localizeUri":   {funcLocalizeUri, []int{1, 2}},
+	"sort":          {funcSort, []int{1, 2}},
+	"sortDesc":      {funcSortDesc, []int{1, 2}},
+	"toJson":        {funcToJson, []int{1}},
 }
 
 // builtinFunc returns a function that writes a call to a soy.$$ builtin func.
@@ -102,6 +107,49 @@ func funcHasData(js JSWriter, args []ast.Node) {
 	js.Write("true")
 }
 
+// funcFlagEnabled reads the named flag from the $ij.flags map.
+func funcFlagEnabled(js JSWriter, args []ast.Node) {
+	js.Write("!!(opt_ijData && opt_ijData.flags && opt_ijData.flags[", args[0], "])")
+}
+
+// funcLocalizeUri maps the URL with the $ij.localizeUri(url, locale) function,
+// if provided, for the given locale or $ij.locale.
+func funcLocalizeUri(js JSWriter, args []ast.Node) {
+	var locale interface{} = "opt_ijData.locale"
+	if len(args) == 2 {
+		locale = args[1]
+	}
+	js.Write("(opt_ijData && opt_ijData.localizeUri ? opt_ijData.localizeUri(", args[0], ", ", locale, ") : ", args[0], ")")
+}
+
+// funcSort sorts a copy of the list in ascending order with soy.$$sortList,
+// comparing strings with the $ij.collate(a, b, locale) function, if provided.
+func funcSort(js JSWriter, args []ast.Node) {
+	writeSortList(js, args, "false")
+}
+
+// funcSortDesc sorts a copy of the list in descending order, as funcSort.
+func funcSortDesc(js JSWriter, args []ast.Node) {
+	writeSortList(js, args, "true")
+}
+
+func writeSortList(js JSWriter, args []ast.Node, desc string) {
+	var field interface{} = "null"
+	if len(args) == 2 {
+		field = args[1]
+	}
+	js.Write("soy.$$sortList(", args[0], ", ", field, ", ", desc,
+		", opt_ijData && opt_ijData.collate, opt_ijData && opt_ijData.locale)")
+}
+
+// funcToJson encodes the value as JSON, escaping the characters that could
+// end a <script> block.  Undefined values, which JSON.stringify does not
+// encode, are encoded as null, as in soyhtml.
+func funcToJson(js JSWriter, args []ast.Node) {
+	js.Write("(JSON.stringify(", args[0], ") || 'null').replace(/[<>&\\u2028\\u2029]/g, ",
+		"function(c) { return '\\\\u' + ('000' + c.charCodeAt(0).toString(16)).slice(-4); })")
+}
+
 func funcBidiGlobalDir(js JSWriter, args []ast.Node) {
 	js.Write("1")
 }
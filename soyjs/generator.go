@@ -3,12 +3,28 @@ package soyjs
 import (
 	"errors"
 	"io"
+	"sort"
 
+	"github.com/harrisonzhao/soy/soymsg"
 	"github.com/harrisonzhao/soy/template"
 )
 
 // Options for js source generation.
-type Options struct{}
+type Options struct {
+	// Messages, if set, provides the translations that are baked into the
+	// generated javascript.  Messages without a translation are written in the
+	// source language.
+	Messages soymsg.Provider
+
+	// Locale, if set, is the locale of the messages, whose CLDR plural
+	// categories select the cases of translated plurals.  Otherwise, the
+	// default locale of the browser is used.
+	Locale string
+
+	// Positions, if set, is filled in with the positions in the soy source of
+	// the generated javascript.
+	Positions *PositionMap
+}
 
 // Generator provides an interface to a template registry capable of generating
 // javascript to execute the embodied templates.
@@ -34,3 +50,75 @@ func (gen *Generator) WriteFile(out io.Writer, filename string) error {
 	}
 	return ErrNotFound
 }
+
+// WriteLocalizedFile generates javascript corresponding to the soy file of the
+// given name, with the messages translated by the given provider.
+func (gen *Generator) WriteLocalizedFile(out io.Writer, filename string, messages soymsg.Provider) error {
+	return gen.write(out, filename, Options{Messages: messages})
+}
+
+// write generates javascript corresponding to the soy file of the given name,
+// with the given options.
+func (gen *Generator) write(out io.Writer, filename string, options Options) error {
+	for _, soyfile := range gen.registry.SoyFiles {
+		if soyfile.Name == filename {
+			return Write(out, soyfile, options)
+		}
+	}
+	return ErrNotFound
+}
+
+// WriteFileWithPositions is like WriteFile, but it additionally returns the
+// map from positions in the generated javascript to the soy source, which
+// allows errors raised by the javascript to be reported against the templates.
+func (gen *Generator) WriteFileWithPositions(out io.Writer, filename string) (*PositionMap, error) {
+	for _, soyfile := range gen.registry.SoyFiles {
+		if soyfile.Name == filename {
+			var positions PositionMap
+			if err := Write(out, soyfile, Options{Positions: &positions}); err != nil {
+				return nil, err
+			}
+			return &positions, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// WriteLocales generates one pre-translated javascript file per locale for the
+// soy file of the given name.  For each entry in bundles, create is called to
+// open the output for that locale, which is closed once written.  Locales are
+// written in sorted order.
+func (gen *Generator) WriteLocales(filename string, bundles map[string]soymsg.Provider,
+	create func(locale string) (io.WriteCloser, error)) error {
+	var locales []string
+	for locale := range bundles {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	for _, locale := range locales {
+		var messages = bundles[locale]
+		var out, err = create(locale)
+		if err != nil {
+			return err
+		}
+		err = gen.write(out, filename, Options{Messages: messages, Locale: locale})
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTypeScriptFile generates TypeScript declarations for the javascript
+// generated from the soy file of the given name.
+func (gen *Generator) WriteTypeScriptFile(out io.Writer, filename string) error {
+	for _, soyfile := range gen.registry.SoyFiles {
+		if soyfile.Name == filename {
+			return WriteTypeScript(out, soyfile)
+		}
+	}
+	return ErrNotFound
+}
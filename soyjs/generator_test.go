@@ -2,11 +2,15 @@ package soyjs
 
 import (
 	"bytes"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/robertkrimen/otto"
 	"github.com/harrisonzhao/soy/ast"
 	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/soymsg"
 	"github.com/harrisonzhao/soy/template"
 )
 
@@ -66,3 +70,282 @@ soy.$$escapeHtml = function(arg) { return arg; };
 		t.Errorf("Got %q, expected Hello, World", output.String())
 	}
 }
+
+func TestGeneratorDocs(t *testing.T) {
+	soyfile, err := parse.SoyFile("docs.soy", `
+{namespace test.docs}
+
+/**
+ * Greets a user.
+ * @param name the user's name,
+ *     as shown on their profile
+ * @param? greeting the greeting
+ */
+{template .hello}
+{$greeting ?: 'Hello'} {$name}
+{/template}
+
+/** Has no params. */
+{template .empty}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registry = template.Registry{}
+	if err = registry.Add(soyfile); err != nil {
+		t.Fatal(err)
+	}
+	var gen = NewGenerator(&registry)
+
+	var js bytes.Buffer
+	if err = gen.WriteFile(&js, "docs.soy"); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`/**
+ * Greets a user.
+ * @param {{name: *, greeting: (*|undefined)}} opt_data
+ *     name: the user's name, as shown on their profile
+ *     greeting: the greeting
+ * @param {*=} opt_sb
+ * @param {Object<string, *>=} opt_ijData
+ * @return {string}
+ */
+test.docs.hello = function(`, `/**
+ * Has no params.
+ * @param {Object<string, *>=} opt_data
+`} {
+		if !strings.Contains(js.String(), expected) {
+			t.Errorf("expected JS to contain:\n%s\ngot:\n%s", expected, js.String())
+		}
+	}
+
+	var ts bytes.Buffer
+	if err = gen.WriteTypeScriptFile(&ts, "docs.soy"); err != nil {
+		t.Fatal(err)
+	}
+	var expected = `// This file was automatically generated from docs.soy.
+// Please don't edit this file by hand.
+
+declare namespace test.docs {
+
+  /**
+   * Greets a user.
+   */
+  function hello(opt_data: {
+    /** the user's name, as shown on their profile */
+    name: any;
+    /** the greeting */
+    greeting?: any;
+  }, opt_sb?: any, opt_ijData?: {[key: string]: any}): string;
+
+  /**
+   * Has no params.
+   */
+  function empty(opt_data?: {[key: string]: any}, opt_sb?: any, opt_ijData?: {[key: string]: any}): string;
+}
+`
+	if ts.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, ts.String())
+	}
+}
+
+type testMessages map[uint64]*soymsg.Message
+
+func (m testMessages) Message(id uint64) *soymsg.Message { return m[id] }
+
+type closingBuffer struct{ bytes.Buffer }
+
+func (closingBuffer) Close() error { return nil }
+
+func TestWriteLocales(t *testing.T) {
+	soyfile, err := parse.SoyFile("greet.soy", `
+{namespace test}
+/** @param name */
+{template .greet}
+  {msg desc="greeting"}Hello {$name}!{/msg}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registry = template.Registry{}
+	if err = registry.Add(soyfile); err != nil {
+		t.Fatal(err)
+	}
+
+	var id = soyfile.Body[2].(*ast.TemplateNode).Body.Nodes[0].(*ast.MsgNode).ID
+	var bundles = map[string]soymsg.Provider{
+		"en": testMessages{},
+		"fr": testMessages{id: &soymsg.Message{ID: id, Parts: []soymsg.Part{
+			soymsg.RawTextPart{Text: "Bonjour "},
+			soymsg.PlaceholderPart{Name: "NAME"},
+			soymsg.RawTextPart{Text: " !"},
+		}}},
+	}
+	var files = make(map[string]*closingBuffer)
+	err = NewGenerator(&registry).WriteLocales("greet.soy", bundles,
+		func(locale string) (io.WriteCloser, error) {
+			files[locale] = &closingBuffer{}
+			return files[locale], nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for locale, expected := range map[string]string{"en": "Hello Rob!", "fr": "Bonjour Rob !"} {
+		var js = initJs(t)
+		if _, err = js.Run(files[locale].String()); err != nil {
+			t.Errorf("%s: %v", locale, err)
+			continue
+		}
+		actual, err := js.Run(`test.greet({name: "Rob"});`)
+		if err != nil {
+			t.Errorf("%s: %v", locale, err)
+			continue
+		}
+		if actual.String() != expected {
+			t.Errorf("%s: expected %q, got %q", locale, expected, actual.String())
+		}
+	}
+}
+
+func TestWriteLocalesPlural(t *testing.T) {
+	soyfile, err := parse.SoyFile("items.soy", `
+{namespace test}
+/** @param count */
+{template .items}
+  {msg desc="item count"}
+    {plural $count}
+      {case 1}One item
+      {default}{$count} items
+    {/plural}
+  {/msg}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registry = template.Registry{}
+	if err = registry.Add(soyfile); err != nil {
+		t.Fatal(err)
+	}
+
+	var id = soyfile.Body[2].(*ast.TemplateNode).Body.Nodes[0].(*ast.MsgNode).ID
+	var bundles = map[string]soymsg.Provider{
+		"pl": testMessages{id: &soymsg.Message{ID: id, Parts: []soymsg.Part{
+			soymsg.PluralPart{VarName: "COUNT", Cases: []soymsg.PluralCase{
+				{Spec: "=0", Parts: []soymsg.Part{soymsg.RawTextPart{Text: "brak"}}},
+				{Spec: "one", Parts: []soymsg.Part{soymsg.RawTextPart{Text: "jeden"}}},
+				{Spec: "few", Parts: []soymsg.Part{soymsg.PlaceholderPart{Name: "COUNT"}, soymsg.RawTextPart{Text: " pliki"}}},
+				{Spec: "many", Parts: []soymsg.Part{soymsg.PlaceholderPart{Name: "COUNT"}, soymsg.RawTextPart{Text: " plików"}}},
+				{Spec: "other", Parts: []soymsg.Part{soymsg.PlaceholderPart{Name: "COUNT"}, soymsg.RawTextPart{Text: " pliku"}}},
+			}},
+		}}},
+	}
+	var out closingBuffer
+	err = NewGenerator(&registry).WriteLocales("items.soy", bundles,
+		func(locale string) (io.WriteCloser, error) { return &out, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// otto has no Intl, so the Polish plural rules are stubbed, and used only
+	// for the locale of the translation.
+	const intl = `var Intl = {PluralRules: function(locale) {
+  this.select = function(n) {
+    if (locale != 'pl') return 'other';
+    if (n == 1) return 'one';
+    var m10 = n % 10, m100 = n % 100;
+    return m10 >= 2 && m10 <= 4 && (m100 < 12 || m100 > 14) ? 'few' : 'many';
+  };
+}};`
+	for _, test := range []struct {
+		intl     bool
+		count    int
+		expected string
+	}{
+		{true, 0, "brak"},
+		{true, 1, "jeden"},
+		{true, 3, "3 pliki"},
+		{true, 22, "22 pliki"},
+		{true, 5, "5 plików"},
+		{true, 12, "12 plików"},
+		{false, 3, "3 pliku"},
+	} {
+		var js = initJs(t)
+		if test.intl {
+			if _, err = js.Run(intl); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err = js.Run(out.String()); err != nil {
+			t.Fatal(err)
+		}
+		actual, err := js.Run(fmt.Sprintf("test.items({count: %d});", test.count))
+		if err != nil {
+			t.Errorf("%d: %v", test.count, err)
+			continue
+		}
+		if actual.String() != test.expected {
+			t.Errorf("%d: expected %q, got %q", test.count, test.expected, actual.String())
+		}
+	}
+}
+
+func TestWriteFileWithPositions(t *testing.T) {
+	soyfile, err := parse.SoyFile("page.soy", `{namespace test}
+
+/** @param user */
+{template .greet}
+  Hello {$user.name}!
+{/template}
+
+/** @param items */
+{template .first}
+  {if $items}
+    {$items[0].title}
+  {/if}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var registry = template.Registry{}
+	if err = registry.Add(soyfile); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	positions, err := NewGenerator(&registry).WriteFileWithPositions(&buf, "page.soy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines = strings.Split(buf.String(), "\n")
+	for _, test := range []struct {
+		js       string // generated code to look up
+		template string
+		line     int
+	}{
+		{"test.greet = function", "test.greet", 4},
+		{"opt_data.user.name", "test.greet", 5},
+		{"if (opt_data.items)", "test.first", 10},
+		{"opt_data.items[0].title", "test.first", 11},
+	} {
+		var found bool
+		for i, line := range lines {
+			var col = strings.Index(line, test.js)
+			if col == -1 {
+				continue
+			}
+			found = true
+			var pos, ok = positions.Lookup(i+1, col+1)
+			if !ok || pos.File != "page.soy" || pos.Template != test.template || pos.Line != test.line {
+				t.Errorf("%s: expected %s at page.soy:%d, got %v", test.js, test.template, test.line, pos)
+			}
+			break
+		}
+		if !found {
+			t.Errorf("%s: not found in the generated javascript", test.js)
+		}
+	}
+	if _, ok := positions.Lookup(len(lines)+1, 1); ok {
+		t.Errorf("expected no position beyond the generated javascript")
+	}
+}
@@ -493,6 +493,85 @@ soy.$$getMapKeys = function(map) {
 };
 
 
+/**
+ * Returns a copy of the list, stably sorted by the given field of its items
+ * or by the items themselves, for the sort() and sortDesc() functions.
+ * Numbers sort before strings, and strings before other values, which are
+ * left in their order; null and undefined sort last in either direction.
+ * @param {Array} list The list to sort.
+ * @param {?string} field The field of the items to sort by, or null.
+ * @param {boolean} desc Whether to sort in descending order.
+ * @param {?function(string, string, string): number|undefined} collate
+ *     Compares two strings for a locale, or else they are compared
+ *     case-insensitively.
+ * @param {?string|undefined} locale The locale passed to collate.
+ * @return {!Array} The sorted copy of the list.
+ */
+soy.$$sortList = function(list, field, desc, collate, locale) {
+  var rank = function(key) {
+    if (key == null) {
+      return 3;
+    }
+    var type = typeof key;
+    return type == 'number' ? 0 : type == 'string' ? 1 : 2;
+  };
+  var compareStrings = function(a, b) {
+    if (collate) {
+      return collate(a, b, locale);
+    }
+    var la = a.toLowerCase(), lb = b.toLowerCase();
+    return la < lb ? -1 : la > lb ? 1 : a < b ? -1 : a > b ? 1 : 0;
+  };
+  var items = [];
+  for (var i = 0; i < list.length; i++) {
+    var key = list[i];
+    if (field != null) {
+      key = key != null && typeof key == 'object' ? key[field] : undefined;
+    }
+    items.push({index: i, item: list[i], key: key, rank: rank(key)});
+  }
+  items.sort(function(a, b) {
+    var c = 0;
+    if (a.rank == 3 || b.rank == 3) {
+      c = a.rank - b.rank;
+    } else {
+      if (a.rank != b.rank) {
+        c = a.rank - b.rank;
+      } else if (a.rank == 0) {
+        c = a.key - b.key;
+      } else if (a.rank == 1) {
+        c = compareStrings(a.key, b.key);
+      }
+      if (desc) {
+        c = -c;
+      }
+    }
+    return c || a.index - b.index;
+  });
+  var result = [];
+  for (var i = 0; i < items.length; i++) {
+    result.push(items[i].item);
+  }
+  return result;
+};
+
+/**
+ * Returns the CLDR plural category of the count in the locale, e.g. 'one',
+ * to select the case of a translated plural.  The categories come from
+ * Intl.PluralRules, or are all 'other' where it is not available.
+ * @param {number} count The count.
+ * @param {string|undefined} locale The locale of the translation, or
+ *     undefined for the default locale.
+ * @return {string} The plural category.
+ */
+soy.$$pluralCategory = function(count, locale) {
+  if (typeof Intl == 'undefined' || !Intl.PluralRules) {
+    return 'other';
+  }
+  return new Intl.PluralRules(locale).select(Math.abs(count));
+};
+
+
 /**
  * Gets a consistent unique id for the given delegate template name. Two calls
  * to this function will return the same id if and only if the input names are
@@ -0,0 +1,58 @@
+package soyjs
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var outputPathPlaceholder = regexp.MustCompile(`{[A-Z_]+}`)
+
+// OutputPath returns the path to write the javascript generated from the given
+// soy file to, according to a format string compatible with the official
+// compiler's --outputPathFormat flag, e.g.
+//
+//	{INPUT_DIRECTORY}/{INPUT_FILE_NAME_NO_EXT}.js
+//
+// As with the official compiler's --inputPrefix flag, the input file is read
+// from inputPrefix + inputFile, where inputPrefix is a literal string prefix
+// (including any trailing slash), or "" if there is none.  The supported
+// placeholders are:
+//
+//	{INPUT_PREFIX}            the given input prefix, e.g. "src/"
+//	{INPUT_DIRECTORY}         directory of the input file, with a trailing slash,
+//	                          not including the input prefix
+//	{INPUT_FILE_NAME}         base name of the input file, e.g. "foo.soy"
+//	{INPUT_FILE_NAME_NO_EXT}  base name of the input file without its extension
+//	{LOCALE}                  the given locale, e.g. "pt-BR"
+//	{LOCALE_LOWER_CASE}       the given locale in lower case, e.g. "pt_br"
+//
+// The locale placeholders are an error if locale is empty.  The resulting path
+// is cleaned, so redundant separators are removed.
+func OutputPath(format, inputPrefix, inputFile, locale string) (string, error) {
+	var dir, file = filepath.Split(inputFile)
+	var values = map[string]string{
+		"{INPUT_PREFIX}":           inputPrefix,
+		"{INPUT_DIRECTORY}":        dir,
+		"{INPUT_FILE_NAME}":        file,
+		"{INPUT_FILE_NAME_NO_EXT}": strings.TrimSuffix(file, filepath.Ext(file)),
+	}
+	if locale != "" {
+		values["{LOCALE}"] = locale
+		values["{LOCALE_LOWER_CASE}"] = strings.Replace(strings.ToLower(locale), "-", "_", -1)
+	}
+
+	var err error
+	var path = outputPathPlaceholder.ReplaceAllStringFunc(format, func(placeholder string) string {
+		var value, ok = values[placeholder]
+		if !ok && err == nil {
+			err = fmt.Errorf("output path format %q: unsupported placeholder %s", format, placeholder)
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	return filepath.Clean(path), nil
+}
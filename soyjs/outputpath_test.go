@@ -0,0 +1,33 @@
+package soyjs
+
+import "testing"
+
+func TestOutputPath(t *testing.T) {
+	var tests = []struct {
+		format, prefix, input, locale string
+		output                        string
+		ok                            bool
+	}{
+		{"{INPUT_DIRECTORY}/{INPUT_FILE_NAME_NO_EXT}.js", "", "src/views/foo.soy", "", "src/views/foo.js", true},
+		{"{INPUT_DIRECTORY}{INPUT_FILE_NAME}.js", "", "src/foo.soy", "", "src/foo.soy.js", true},
+		{"gen/{INPUT_FILE_NAME_NO_EXT}.js", "", "foo.soy", "", "gen/foo.js", true},
+		{"gen/{LOCALE}/{INPUT_FILE_NAME_NO_EXT}.js", "", "a/foo.soy", "pt-BR", "gen/pt-BR/foo.js", true},
+		{"gen/{INPUT_FILE_NAME_NO_EXT}_{LOCALE_LOWER_CASE}.js", "", "foo.soy", "pt-BR", "gen/foo_pt_br.js", true},
+		{"gen/{LOCALE}/foo.js", "", "foo.soy", "", "", false},
+		{"{INPUT_PREFIX}{INPUT_DIRECTORY}{INPUT_FILE_NAME_NO_EXT}.js", "src/", "views/foo.soy", "", "src/views/foo.js", true},
+		{"gen/{INPUT_DIRECTORY}{INPUT_FILE_NAME_NO_EXT}.js", "src/", "views/foo.soy", "", "gen/views/foo.js", true},
+		{"{INPUT_PREFIX}foo.js", "", "foo.soy", "", "foo.js", true},
+		{"{OUTPUT_DIRECTORY}/foo.js", "", "foo.soy", "", "", false},
+	}
+	for _, test := range tests {
+		var output, err = OutputPath(test.format, test.prefix, test.input, test.locale)
+		switch {
+		case !test.ok && err == nil:
+			t.Errorf("%s: expected error, got %q", test.format, output)
+		case test.ok && err != nil:
+			t.Errorf("%s: %v", test.format, err)
+		case output != test.output:
+			t.Errorf("%s: expected %q, got %q", test.format, test.output, output)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package soyjs
+
+import (
+	"io"
+	"sort"
+
+	"github.com/harrisonzhao/soy/ast"
+)
+
+// PositionMap maps positions within generated javascript back to the soy
+// source that generated them, so that errors raised by the javascript at
+// runtime (e.g. in a browser's stack trace) may be reported against the
+// templates rather than the generated file.  It is filled in by Write when
+// set in the Options.
+type PositionMap struct {
+	File     string   // name of the soy file
+	text     string   // source of the soy file
+	newlines []int    // offsets of the newlines in text, found on first lookup
+	spans    [][]span // spans of output beginning on each generated line
+}
+
+// Position is a position within the soy source.
+type Position struct {
+	File     string // name of the soy file
+	Template string // name of the template, or "" if outside of any
+	Line     int    // line number, starting at 1
+	Col      int    // column, in bytes, starting at 1
+}
+
+// span is a section of generated javascript translated from a node.
+type span struct {
+	col      int // column of the generated line at which the span begins
+	pos      ast.Pos
+	template string
+}
+
+// Lookup returns the position in the soy source of the node that generated
+// the javascript at the given line and column (both starting at 1), or false
+// if the position is outside of the generated javascript.
+func (m *PositionMap) Lookup(line, col int) (Position, bool) {
+	if line < 1 || line > len(m.spans) {
+		return Position{}, false
+	}
+	var spans = m.spans[line-1]
+	var i = sort.Search(len(spans), func(i int) bool { return spans[i].col > col }) - 1
+	if i < 0 {
+		return Position{}, false
+	}
+	var soyLine, soyCol = m.lineCol(int(spans[i].pos))
+	return Position{m.File, spans[i].template, soyLine, soyCol}, true
+}
+
+// lineCol returns the line and column of the given offset in the soy source.
+func (m *PositionMap) lineCol(offset int) (int, int) {
+	if m.newlines == nil {
+		m.newlines = []int{}
+		for i := 0; i < len(m.text); i++ {
+			if m.text[i] == '\n' {
+				m.newlines = append(m.newlines, i)
+			}
+		}
+	}
+	var line = sort.SearchInts(m.newlines, offset)
+	var lineStart = 0
+	if line > 0 {
+		lineStart = m.newlines[line-1] + 1
+	}
+	return line + 1, offset - lineStart + 1
+}
+
+// positionWriter passes the generated javascript through to w, recording the
+// node that generated each section of it.
+type positionWriter struct {
+	w         io.Writer
+	m         *PositionMap
+	col       int    // column of the next byte written, starting at 1
+	template  string // name of the template being written
+	continued bool   // true if the current line's only span continues the last line's
+}
+
+func newPositionWriter(w io.Writer, m *PositionMap, node ast.Node) *positionWriter {
+	if file, ok := node.(*ast.SoyFileNode); ok {
+		m.File, m.text = file.Name, file.Text
+	}
+	m.newlines, m.spans = nil, [][]span{nil}
+	return &positionWriter{w: w, m: m, col: 1}
+}
+
+// mark records that the following output is generated from the given node.
+// Where nested nodes begin at the same column, the outermost is recorded,
+// except that a template replaces whatever precedes it (e.g. its soydoc).
+func (pw *positionWriter) mark(node ast.Node) {
+	var tmpl, isTemplate = node.(*ast.TemplateNode)
+	if isTemplate {
+		pw.template = tmpl.Name
+	}
+	var line = &pw.m.spans[len(pw.m.spans)-1]
+	var s = span{pw.col, node.Position(), pw.template}
+	if n := len(*line); n > 0 && (*line)[n-1].col == pw.col {
+		if pw.continued || isTemplate {
+			(*line)[n-1], pw.continued = s, false
+		}
+		return
+	}
+	*line, pw.continued = append(*line, s), false
+}
+
+func (pw *positionWriter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		if c != '\n' {
+			pw.col++
+			continue
+		}
+		// The output on the new line continues from the last node marked.
+		var line = pw.m.spans[len(pw.m.spans)-1]
+		var next []span
+		if len(line) > 0 {
+			var last = line[len(line)-1]
+			next = []span{{1, last.pos, last.template}}
+		}
+		pw.m.spans = append(pw.m.spans, next)
+		pw.col, pw.continued = 1, len(next) > 0
+	}
+	return pw.w.Write(p)
+}
@@ -1,6 +1,10 @@
 package soyjs
 
-import "strconv"
+import (
+	"strconv"
+
+	"github.com/harrisonzhao/soy/data"
+)
 
 // scope provides a lookup from soy variable name to the JS name.
 // it is pushed and popped upon entering and leaving loop scopes.
@@ -26,6 +30,13 @@ func (s *scope) makevar(varname string) string {
 	return genName
 }
 
+// tempvar generates and returns a new JS name for a temporary value, which is
+// not mapped from any variable name.
+func (s *scope) tempvar(prefix string) string {
+	s.n++
+	return prefix + strconv.Itoa(s.n)
+}
+
 func (s *scope) lookup(varname string) string {
 	for i := range s.stack {
 		val, ok := s.stack[len(s.stack)-i-1][varname]
@@ -36,13 +47,30 @@ func (s *scope) lookup(varname string) string {
 	return ""
 }
 
+// setkind records the content kind of the given let variable in this scope.
+func (s *scope) setkind(varname string, kind data.ContentKind) {
+	s.stack[len(s.stack)-1]["__kind."+varname] = string(kind)
+}
+
+// kind returns the content kind of the given variable, or "" if it is not a
+// kinded let variable.
+func (s *scope) kind(varname string) data.ContentKind {
+	for i := range s.stack {
+		var frame = s.stack[len(s.stack)-i-1]
+		if _, ok := frame[varname]; ok {
+			return data.ContentKind(frame["__kind."+varname])
+		}
+	}
+	return ""
+}
+
 func (s *scope) pushForRange(loopVar string) (lVar, lLimit string) {
 	s.n++
 	n := strconv.Itoa(s.n)
 	s.stack = append(s.stack, map[string]string{
-		loopVar:   loopVar + n,
-		"__limit": loopVar + "Limit" + n,
-		"__index": loopVar + n,
+		loopVar:             loopVar + n,
+		loopVar + "__limit": loopVar + "Limit" + n,
+		loopVar + "__index": loopVar + n,
 	})
 	return loopVar + n,
 		loopVar + "Limit" + n
@@ -52,9 +80,9 @@ func (s *scope) pushForEach(loopVar string) (lVar, lList, lLen, lIndex string) {
 	s.n++
 	n := strconv.Itoa(s.n)
 	s.stack = append(s.stack, map[string]string{
-		loopVar:   loopVar + n,
-		"__limit": loopVar + "Limit" + n,
-		"__index": loopVar + "Index" + n,
+		loopVar:             loopVar + n,
+		loopVar + "__limit": loopVar + "Limit" + n,
+		loopVar + "__index": loopVar + "Index" + n,
 	})
 	return loopVar + n,
 		loopVar + "List" + n,
@@ -62,12 +90,19 @@ func (s *scope) pushForEach(loopVar string) (lVar, lList, lLen, lIndex string) {
 		loopVar + "Index" + n
 }
 
-// looplimit returns the JS variable name for the innermost loop limit.
-func (s *scope) looplimit() string {
-	return s.lookup("__limit")
+// setvar maps the given variable name to an existing JS name in this scope.
+func (s *scope) setvar(varname, jsName string) {
+	s.stack[len(s.stack)-1][varname] = jsName
+}
+
+// looplimitof returns the JS variable name for the limit of the loop that
+// binds the given variable, or "" if it is not a loop variable.
+func (s *scope) looplimitof(loopVar string) string {
+	return s.lookup(loopVar + "__limit")
 }
 
-// looplimit returns the JS variable name for the innermost loop index.
-func (s *scope) loopindex() string {
-	return s.lookup("__index")
+// loopindexof returns the JS variable name for the index of the loop that
+// binds the given variable, or "" if it is not a loop variable.
+func (s *scope) loopindexof(loopVar string) string {
+	return s.lookup(loopVar + "__index")
 }
@@ -0,0 +1,96 @@
+package soyjs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/harrisonzhao/soy/ast"
+)
+
+// WriteTypeScript writes TypeScript declarations (a .d.ts file) for the
+// javascript generated from the given soy file.  The template and param
+// descriptions from SoyDoc are carried over as doc comments.
+func WriteTypeScript(out io.Writer, node *ast.SoyFileNode) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// This file was automatically generated from %s.\n", node.Name)
+	buf.WriteString("// Please don't edit this file by hand.\n")
+
+	var namespace string
+	var soydoc *ast.SoyDocNode
+	for _, child := range node.Body {
+		switch child := child.(type) {
+		case *ast.NamespaceNode:
+			namespace = child.Name
+			fmt.Fprintf(&buf, "\ndeclare namespace %s {\n", namespace)
+		case *ast.SoyDocNode:
+			soydoc = child
+		case *ast.TemplateNode:
+			if child.Delegate {
+				// delegates are invoked through the delegate registry.
+				soydoc = nil
+				continue
+			}
+			if namespace == "" {
+				return fmt.Errorf("%s: template %s declared before namespace", node.Name, child.Name)
+			}
+			writeTSTemplate(&buf, strings.TrimPrefix(child.Name, namespace+"."), soydoc)
+			soydoc = nil
+		}
+	}
+	if namespace != "" {
+		buf.WriteString("}\n")
+	}
+	_, err := buf.WriteTo(out)
+	return err
+}
+
+// writeTSTemplate writes the declaration of a template function.
+func writeTSTemplate(buf *bytes.Buffer, name string, soydoc *ast.SoyDocNode) {
+	if soydoc == nil {
+		soydoc = &ast.SoyDocNode{}
+	}
+	buf.WriteString("\n")
+	if soydoc.Desc != "" || soydoc.Deprecated {
+		buf.WriteString("  /**\n")
+		for _, line := range strings.Split(soydoc.Desc, "\n") {
+			if line != "" {
+				fmt.Fprintf(buf, "   * %s\n", jsdocEscape(line))
+			}
+		}
+		if soydoc.Deprecated {
+			buf.WriteString(strings.TrimRight("   * @deprecated "+jsdocEscape(soydoc.DeprecationNote), " ") + "\n")
+		}
+		buf.WriteString("   */\n")
+	}
+
+	var allOptional = true
+	for _, param := range soydoc.Params {
+		if !param.Optional {
+			allOptional = false
+		}
+	}
+	var dataOptional = ""
+	if allOptional {
+		dataOptional = "?"
+	}
+	fmt.Fprintf(buf, "  function %s(opt_data%s: {", name, dataOptional)
+	if len(soydoc.Params) == 0 {
+		buf.WriteString("[key: string]: any")
+	} else {
+		buf.WriteString("\n")
+		for _, param := range soydoc.Params {
+			if param.Desc != "" {
+				fmt.Fprintf(buf, "    /** %s */\n", jsdocEscape(param.Desc))
+			}
+			var optional = ""
+			if param.Optional {
+				optional = "?"
+			}
+			fmt.Fprintf(buf, "    %s%s: any;\n", param.Name, optional)
+		}
+		buf.WriteString("  ")
+	}
+	buf.WriteString("}, opt_sb?: any, opt_ijData?: {[key: string]: any}): string;\n")
+}
@@ -0,0 +1,174 @@
+// Package soymetrics records metrics on template renders and exports them in
+// the Prometheus text format, so that renders may be graphed and alerted on
+// without writing an adapter.
+//
+// Install a Metrics as the observer of a Tofu, and serve it on the path
+// scraped by Prometheus:
+//
+//	var metrics = soymetrics.New()
+//	tofu.Observer(metrics)
+//	http.Handle("/metrics", metrics)
+//
+// The following metrics are exported, each labeled by template:
+//
+//	soy_renders_total               counter of renders
+//	soy_render_errors_total         counter of renders that failed
+//	soy_render_duration_seconds     histogram of the time spent rendering
+//	soy_render_queue_seconds_total  counter of the time spent waiting for the render gate
+//	soy_render_queue_timeouts_total counter of renders that timed out waiting for the render gate
+package soymetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/harrisonzhao/soy/soyhtml"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the render duration
+// histogram buckets used when none are given to New.
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// Metrics records metrics on template renders.  It implements
+// soyhtml.RenderObserver to record them, and http.Handler to export them.
+type Metrics struct {
+	buckets []float64
+
+	mu        sync.Mutex
+	templates map[string]*templateMetrics
+}
+
+// templateMetrics are the metrics of renders of a single template.
+type templateMetrics struct {
+	renders, errors uint64
+	counts          []uint64 // number of renders within each bucket (not cumulative)
+	seconds         float64  // total time spent rendering
+	queueSeconds    float64  // total time spent waiting for the render gate
+	queueTimeouts   uint64   // number of renders that timed out waiting
+}
+
+// New returns a Metrics recording render durations in histogram buckets with
+// the given upper bounds, in seconds, or DefaultBuckets if none are given.
+func New(buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Metrics{buckets: buckets, templates: make(map[string]*templateMetrics)}
+}
+
+// ObserveRender records the given render.
+func (m *Metrics) ObserveRender(e soyhtml.RenderEvent) {
+	var seconds = e.Elapsed.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var t, ok = m.templates[e.Template]
+	if !ok {
+		t = &templateMetrics{counts: make([]uint64, len(m.buckets))}
+		m.templates[e.Template] = t
+	}
+	t.renders++
+	if e.Err != nil {
+		t.errors++
+	}
+	t.seconds += seconds
+	t.queueSeconds += e.Queued.Seconds()
+	if e.Err == soyhtml.ErrRenderQueueTimeout {
+		t.queueTimeouts++
+	}
+	if i := sort.SearchFloat64s(m.buckets, seconds); i < len(m.buckets) {
+		t.counts[i]++
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format, ordered by
+// template name.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	var names = make([]string, 0, len(m.templates))
+	var templates = make(map[string]templateMetrics, len(m.templates))
+	for name, t := range m.templates {
+		names = append(names, name)
+		var copied = *t
+		copied.counts = append([]uint64(nil), t.counts...)
+		templates[name] = copied
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+
+	var cw = &countingWriter{w: w}
+	var buf = bufio.NewWriter(cw)
+	fmt.Fprintln(buf, "# HELP soy_renders_total Number of template renders.")
+	fmt.Fprintln(buf, "# TYPE soy_renders_total counter")
+	for _, name := range names {
+		fmt.Fprintf(buf, "soy_renders_total{template=%s} %d\n", quote(name), templates[name].renders)
+	}
+	fmt.Fprintln(buf, "# HELP soy_render_errors_total Number of template renders that failed.")
+	fmt.Fprintln(buf, "# TYPE soy_render_errors_total counter")
+	for _, name := range names {
+		fmt.Fprintf(buf, "soy_render_errors_total{template=%s} %d\n", quote(name), templates[name].errors)
+	}
+	fmt.Fprintln(buf, "# HELP soy_render_duration_seconds Time spent rendering templates.")
+	fmt.Fprintln(buf, "# TYPE soy_render_duration_seconds histogram")
+	for _, name := range names {
+		var t = templates[name]
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += t.counts[i]
+			fmt.Fprintf(buf, "soy_render_duration_seconds_bucket{template=%s,le=%q} %d\n",
+				quote(name), formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(buf, "soy_render_duration_seconds_bucket{template=%s,le=\"+Inf\"} %d\n", quote(name), t.renders)
+		fmt.Fprintf(buf, "soy_render_duration_seconds_sum{template=%s} %s\n", quote(name), formatFloat(t.seconds))
+		fmt.Fprintf(buf, "soy_render_duration_seconds_count{template=%s} %d\n", quote(name), t.renders)
+	}
+	fmt.Fprintln(buf, "# HELP soy_render_queue_seconds_total Time spent waiting for the render gate.")
+	fmt.Fprintln(buf, "# TYPE soy_render_queue_seconds_total counter")
+	for _, name := range names {
+		fmt.Fprintf(buf, "soy_render_queue_seconds_total{template=%s} %s\n", quote(name), formatFloat(templates[name].queueSeconds))
+	}
+	fmt.Fprintln(buf, "# HELP soy_render_queue_timeouts_total Number of renders that timed out waiting for the render gate.")
+	fmt.Fprintln(buf, "# TYPE soy_render_queue_timeouts_total counter")
+	for _, name := range names {
+		fmt.Fprintf(buf, "soy_render_queue_timeouts_total{template=%s} %d\n", quote(name), templates[name].queueTimeouts)
+	}
+	var err = buf.Flush()
+	return cw.n, err
+}
+
+// labelEscaper escapes label values, as required by the text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quote returns the given label value, escaped and in double quotes.
+func quote(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	var n, err = w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,61 @@
+package soymetrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/soyhtml"
+	"github.com/harrisonzhao/soy/template"
+)
+
+func TestMetrics(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace test}
+/** @param x */
+{template .hello}
+Hello {$x.y}
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+
+	var metrics = New(1, 60)
+	var tofu = soyhtml.NewTofu(&registry).Observer(metrics)
+	var buf bytes.Buffer
+	if err = tofu.Render(&buf, "test.hello", map[string]interface{}{"x": map[string]string{"y": "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = tofu.Render(&buf, "test.hello", map[string]interface{}{"x": 1}); err == nil {
+		t.Fatal("expected the render to fail")
+	}
+
+	buf.Reset()
+	if _, err = metrics.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"# TYPE soy_renders_total counter\n" +
+			`soy_renders_total{template="test.hello"} 2` + "\n",
+		`soy_render_errors_total{template="test.hello"} 1` + "\n",
+		"# TYPE soy_render_duration_seconds histogram\n" +
+			`soy_render_duration_seconds_bucket{template="test.hello",le="1"} 2` + "\n" +
+			`soy_render_duration_seconds_bucket{template="test.hello",le="60"} 2` + "\n" +
+			`soy_render_duration_seconds_bucket{template="test.hello",le="+Inf"} 2` + "\n",
+		`soy_render_duration_seconds_count{template="test.hello"} 2` + "\n",
+		"# TYPE soy_render_queue_timeouts_total counter\n" +
+			`soy_render_queue_timeouts_total{template="test.hello"} 0` + "\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected output to contain %q, got:\n%s", expected, buf.String())
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if actual, expected := quote("a\"b\\c\nd"), `"a\"b\\c\nd"`; actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+}
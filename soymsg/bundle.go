@@ -0,0 +1,283 @@
+package soymsg
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Bundle is a set of messages translated into a locale, keyed by message ID.
+type Bundle struct {
+	Locale   string
+	messages map[uint64]*Message
+}
+
+// NewBundle returns an empty bundle of messages for the given locale.
+func NewBundle(locale string) *Bundle {
+	return &Bundle{locale, make(map[uint64]*Message)}
+}
+
+// Add adds the given translated message to the bundle, replacing any message
+// with the same ID.
+func (b *Bundle) Add(msg Message) {
+	b.messages[msg.ID] = &msg
+}
+
+// Message returns the translation of the message with the given ID, or nil if
+// there is none.
+func (b *Bundle) Message(id uint64) *Message {
+	return b.messages[id]
+}
+
+// Len returns the number of messages in the bundle.
+func (b *Bundle) Len() int {
+	return len(b.messages)
+}
+
+// ReadXTB reads a bundle of translated messages in the XTB format:
+//
+//	<translationbundle lang="fr">
+//	  <translation id="1234" desc="greeting">Bonjour <ph name="NAME"/></translation>
+//	</translationbundle>
+//
+// Plurals and selects are given in ICU syntax within the message text (see
+// Message.PlaceholderString), e.g.
+//
+//	{NUM,plural,=1{un article}other{<ph name="NUM"/> articles}}
+func ReadXTB(r io.Reader) (*Bundle, error) {
+	return readBundle(r, bundleFormat{
+		localeElem: "translationbundle",
+		localeAttr: "lang",
+		unitElem:   "translation",
+		textElem:   "translation",
+		phElem:     "ph",
+		phAttr:     "name",
+	})
+}
+
+// ReadXLIFF reads a bundle of translated messages in the XLIFF 1.2 format, as
+// written by the Closure Templates message extractor:
+//
+//	<xliff version="1.2" xmlns="urn:oasis:names:tc:xliff:document:1.2">
+//	  <file target-language="fr">
+//	    <body>
+//	      <trans-unit id="1234">
+//	        <source>Hello <x id="NAME"/></source>
+//	        <target>Bonjour <x id="NAME"/></target>
+//	      </trans-unit>
+//	    </body>
+//	  </file>
+//	</xliff>
+//
+// Plurals and selects are given as for ReadXTB.
+func ReadXLIFF(r io.Reader) (*Bundle, error) {
+	return readBundle(r, bundleFormat{
+		localeElem: "file",
+		localeAttr: "target-language",
+		unitElem:   "trans-unit",
+		textElem:   "target",
+		phElem:     "x",
+		phAttr:     "id",
+	})
+}
+
+// bundleFormat describes the elements of an XML message bundle format.
+type bundleFormat struct {
+	localeElem, localeAttr string // element and attribute giving the locale
+	unitElem               string // element of each message, with its id
+	textElem               string // element of the translated text
+	phElem, phAttr         string // element and attribute of a placeholder
+}
+
+// readBundle reads a bundle of translated messages in the given format.
+func readBundle(r io.Reader, format bundleFormat) (*Bundle, error) {
+	var bundle = NewBundle("")
+	var dec = xml.NewDecoder(r)
+	var msg *Message // the message being read, if any
+	var parts []Part // the text of the message read so far
+	var inText bool
+	for {
+		var tok, err = dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			var name = tok.Name.Local
+			if name == format.localeElem && bundle.Locale == "" {
+				bundle.Locale = xmlAttr(tok, format.localeAttr)
+			}
+			if name == format.unitElem {
+				var id, err = strconv.ParseUint(xmlAttr(tok, "id"), 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid message id: %v", lineOf(dec), err)
+				}
+				msg, parts = &Message{ID: id, Desc: xmlAttr(tok, "desc")}, nil
+			}
+			switch {
+			case msg != nil && name == format.textElem:
+				inText = true
+			case inText && name == format.phElem:
+				parts = append(parts, PlaceholderPart{xmlAttr(tok, format.phAttr)})
+			}
+		case xml.CharData:
+			if inText {
+				parts = appendText(parts, string(tok))
+			}
+		case xml.EndElement:
+			if msg == nil || tok.Name.Local != format.textElem {
+				continue
+			}
+			if msg.Parts, err = parseICU(parts); err != nil {
+				return nil, fmt.Errorf("line %d: message %d: %v", lineOf(dec), msg.ID, err)
+			}
+			bundle.Add(*msg)
+			msg, inText = nil, false
+		}
+	}
+	return bundle, nil
+}
+
+// xmlAttr returns the value of the attribute of the given element with the
+// given local name, or "" if it has none.
+func xmlAttr(elem xml.StartElement, name string) string {
+	for _, attr := range elem.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// lineOf returns the line number of the decoder's position, for errors.
+func lineOf(dec *xml.Decoder) int {
+	var line, _ = dec.InputPos()
+	return line
+}
+
+// appendText appends the given text to parts, merging it into the last part
+// if that is also text.
+func appendText(parts []Part, text string) []Part {
+	if text == "" {
+		return parts
+	}
+	if len(parts) > 0 {
+		if last, ok := parts[len(parts)-1].(RawTextPart); ok {
+			parts[len(parts)-1] = RawTextPart{last.Text + text}
+			return parts
+		}
+	}
+	return append(parts, RawTextPart{text})
+}
+
+// icuStart matches the beginning of a plural or select in ICU syntax.
+var icuStart = regexp.MustCompile(`^\{\s*(\w+)\s*,\s*(plural|select)\s*,`)
+
+// parseICU returns the given text and placeholder parts with the plurals and
+// selects written within the text in ICU syntax parsed into PluralParts and
+// SelectParts.  Braces that do not begin a plural or select are text.
+func parseICU(parts []Part) ([]Part, error) {
+	var p = icuParser{parts: parts}
+	var result, _ = p.parse(false)
+	return result, p.err
+}
+
+// icuParser parses plurals and selects within message parts.
+type icuParser struct {
+	parts []Part
+	text  string // the remaining text of the part being parsed, if it is text
+	err   error
+}
+
+// parse parses parts until the end of a case, if inCase is set, or the end of
+// the input.  It returns true if it stopped at the closing brace of a case.
+func (p *icuParser) parse(inCase bool) (result []Part, closed bool) {
+	for p.err == nil {
+		if p.text == "" {
+			if len(p.parts) == 0 {
+				if inCase {
+					p.err = fmt.Errorf("unterminated plural or select")
+				}
+				return result, false
+			}
+			var part = p.parts[0]
+			p.parts = p.parts[1:]
+			if text, ok := part.(RawTextPart); ok {
+				p.text = text.Text
+			} else {
+				result = append(result, part)
+			}
+			continue
+		}
+
+		var i = strings.IndexAny(p.text, "{}")
+		if i == -1 {
+			result = appendText(result, p.text)
+			p.text = ""
+			continue
+		}
+		result = appendText(result, p.text[:i])
+		p.text = p.text[i:]
+		if p.text[0] == '}' {
+			p.text = p.text[1:]
+			if inCase {
+				return result, true
+			}
+			result = appendText(result, "}")
+			continue
+		}
+		var m = icuStart.FindStringSubmatch(p.text)
+		if m == nil {
+			result = appendText(result, "{")
+			p.text = p.text[1:]
+			continue
+		}
+		p.text = p.text[len(m[0]):]
+		if m[2] == "plural" {
+			var plural = PluralPart{VarName: m[1]}
+			for spec, parts, ok := p.parseCase(); ok; spec, parts, ok = p.parseCase() {
+				plural.Cases = append(plural.Cases, PluralCase{spec, parts})
+			}
+			result = append(result, plural)
+		} else {
+			var sel = SelectPart{VarName: m[1]}
+			for value, parts, ok := p.parseCase(); ok; value, parts, ok = p.parseCase() {
+				sel.Cases = append(sel.Cases, SelectCase{value, parts})
+			}
+			result = append(result, sel)
+		}
+	}
+	return result, false
+}
+
+// parseCase parses the next case of a plural or select, e.g. "=1{one item}",
+// returning false at the closing brace of the plural or select.
+func (p *icuParser) parseCase() (spec string, parts []Part, ok bool) {
+	if p.text == "" && len(p.parts) > 0 {
+		if text, ok := p.parts[0].(RawTextPart); ok {
+			p.text, p.parts = text.Text, p.parts[1:]
+		}
+	}
+	p.text = strings.TrimLeft(p.text, " \t\r\n")
+	switch {
+	case p.err != nil:
+		return "", nil, false
+	case strings.HasPrefix(p.text, "}"):
+		p.text = p.text[1:]
+		return "", nil, false
+	}
+	var i = strings.IndexAny(p.text, "{}")
+	if i <= 0 || p.text[i] != '{' {
+		p.err = fmt.Errorf("expected a case of a plural or select at %q", p.text)
+		return "", nil, false
+	}
+	spec, p.text = strings.TrimSpace(p.text[:i]), p.text[i+1:]
+	parts, _ = p.parse(true)
+	return spec, parts, p.err == nil
+}
@@ -0,0 +1,149 @@
+package soymsg
+
+import "bytes"
+
+// calcID computes the ID of the message with the given parts and meaning, in
+// the same way as the official Closure Templates compiler, so that message IDs
+// match those found in translation files produced by the official tools.
+func calcID(parts []Part, meaning string) uint64 {
+	var buf bytes.Buffer
+	writeIDString(&buf, parts)
+	var fp = fingerprint(buf.Bytes())
+	if meaning != "" {
+		var topBit uint64
+		if fp&(1<<63) != 0 {
+			topBit = 1
+		}
+		fp = (fp << 1) + topBit + fingerprint([]byte(meaning))
+	}
+	return fp & 0x7fffffffffffffff
+}
+
+// writeIDString writes the text from which the ID of the message with the
+// given parts is computed: its text, with placeholders given by name, and
+// plurals and selects in ICU syntax.
+func writeIDString(buf *bytes.Buffer, parts []Part) {
+	for _, part := range parts {
+		switch part := part.(type) {
+		case RawTextPart:
+			buf.WriteString(part.Text)
+		case PlaceholderPart:
+			buf.WriteString(part.Name)
+		case PluralPart:
+			buf.WriteString("{" + part.VarName + ",plural,")
+			for _, pluralCase := range part.Cases {
+				buf.WriteString(pluralCase.Spec + "{")
+				writeIDString(buf, pluralCase.Parts)
+				buf.WriteString("}")
+			}
+			buf.WriteString("}")
+		case SelectPart:
+			buf.WriteString("{" + part.VarName + ",select,")
+			for _, selectCase := range part.Cases {
+				buf.WriteString(selectCase.Value + "{")
+				writeIDString(buf, selectCase.Parts)
+				buf.WriteString("}")
+			}
+			buf.WriteString("}")
+		}
+	}
+}
+
+// fingerprint returns the 64-bit fingerprint of the given bytes.
+func fingerprint(str []byte) uint64 {
+	var hi = hash32(str, 0)
+	var lo = hash32(str, 102072)
+	if hi == 0 && (lo == 0 || lo == 1) {
+		// Turn 0/1 into another fingerprint
+		hi ^= 0x130f9bef
+		lo ^= 0x94a0a928
+	}
+	return uint64(hi)<<32 | uint64(lo)
+}
+
+// hash32 is Bob Jenkins' lookup2 hash of the given bytes, with initial value c.
+func hash32(str []byte, c uint32) uint32 {
+	var a, b uint32 = 0x9e3779b9, 0x9e3779b9
+	var i int
+	for ; i+12 <= len(str); i += 12 {
+		a += word32(str[i:])
+		b += word32(str[i+4:])
+		c += word32(str[i+8:])
+		a, b, c = mix(a, b, c)
+	}
+
+	c += uint32(len(str))
+	var rest = str[i:]
+	// All the cases fall through; the lowest byte of c is reserved for the length.
+	switch len(rest) {
+	case 11:
+		c += uint32(rest[10]) << 24
+		fallthrough
+	case 10:
+		c += uint32(rest[9]) << 16
+		fallthrough
+	case 9:
+		c += uint32(rest[8]) << 8
+		fallthrough
+	case 8:
+		b += uint32(rest[7]) << 24
+		fallthrough
+	case 7:
+		b += uint32(rest[6]) << 16
+		fallthrough
+	case 6:
+		b += uint32(rest[5]) << 8
+		fallthrough
+	case 5:
+		b += uint32(rest[4])
+		fallthrough
+	case 4:
+		a += uint32(rest[3]) << 24
+		fallthrough
+	case 3:
+		a += uint32(rest[2]) << 16
+		fallthrough
+	case 2:
+		a += uint32(rest[1]) << 8
+		fallthrough
+	case 1:
+		a += uint32(rest[0])
+	}
+	_, _, c = mix(a, b, c)
+	return c
+}
+
+func word32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+func mix(a, b, c uint32) (uint32, uint32, uint32) {
+	a -= b
+	a -= c
+	a ^= c >> 13
+	b -= c
+	b -= a
+	b ^= a << 8
+	c -= a
+	c -= b
+	c ^= b >> 13
+	a -= b
+	a -= c
+	a ^= c >> 12
+	b -= c
+	b -= a
+	b ^= a << 16
+	c -= a
+	c -= b
+	c ^= b >> 5
+	a -= b
+	a -= c
+	a ^= c >> 3
+	b -= c
+	b -= a
+	b ^= a << 10
+	c -= a
+	c -= b
+	c ^= b >> 15
+	return a, b, c
+}
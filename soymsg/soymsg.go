@@ -0,0 +1,358 @@
+// Package soymsg provides the translatable representation of {msg} blocks.
+//
+// Within a message, content other than raw text (e.g. {print} and {call}) is
+// replaced by a named placeholder, so that translators see "Hello {NAME}"
+// rather than the template code.  At render time, the translated message's
+// placeholders are substituted with the rendered content they stand for.
+package soymsg
+
+import (
+	"bytes"
+	"strconv"
+	"unicode"
+
+	"github.com/harrisonzhao/soy/ast"
+)
+
+// Message is a translatable message.
+type Message struct {
+	ID    uint64
+	Desc  string
+	Parts []Part
+}
+
+// Part is an element of a Message: a RawTextPart, PlaceholderPart,
+// PluralPart, or SelectPart.
+type Part interface{}
+
+// RawTextPart is a section of message text.
+type RawTextPart struct {
+	Text string
+}
+
+// PlaceholderPart is a placeholder for content that is not translated.
+type PlaceholderPart struct {
+	Name string
+}
+
+// PluralPart is a section of message text that varies with a count, e.g.
+// "{NUM,plural,=1{1 item}other{{NUM} items}}".
+type PluralPart struct {
+	VarName string // placeholder name of the count
+	Cases   []PluralCase
+}
+
+// PluralCase is a form of a PluralPart, used for the counts matching its
+// Spec: "=N" for a count of exactly N, a CLDR plural category of the locale
+// ("zero", "one", "two", "few", or "many"), or "other" for any other count.
+type PluralCase struct {
+	Spec  string
+	Parts []Part
+}
+
+// SelectPart is a section of message text that varies with a string value,
+// e.g. "{GENDER,select,female{her}male{his}other{their}}".
+type SelectPart struct {
+	VarName string // placeholder name of the value
+	Cases   []SelectCase
+}
+
+// SelectCase is a form of a SelectPart, used when the value equals its Value,
+// or for any other value if its Value is "other".
+type SelectCase struct {
+	Value string
+	Parts []Part
+}
+
+// Provider provides translated messages.
+type Provider interface {
+	// Message returns the translation of the message with the given ID, or nil
+	// if there is none.
+	Message(id uint64) *Message
+}
+
+// PlaceholderString returns the message text with placeholders shown in
+// braces, e.g. "Hello {NAME}", and plurals and selects in ICU syntax, e.g.
+// "{NUM,plural,=1{1 item}other{{NUM} items}}".
+func (m Message) PlaceholderString() string {
+	var buf bytes.Buffer
+	writePlaceholderString(&buf, m.Parts)
+	return buf.String()
+}
+
+func writePlaceholderString(buf *bytes.Buffer, parts []Part) {
+	for _, part := range parts {
+		switch part := part.(type) {
+		case RawTextPart:
+			buf.WriteString(part.Text)
+		case PlaceholderPart:
+			buf.WriteString("{" + part.Name + "}")
+		case PluralPart:
+			buf.WriteString("{" + part.VarName + ",plural,")
+			for _, pluralCase := range part.Cases {
+				buf.WriteString(pluralCase.Spec + "{")
+				writePlaceholderString(buf, pluralCase.Parts)
+				buf.WriteString("}")
+			}
+			buf.WriteString("}")
+		case SelectPart:
+			buf.WriteString("{" + part.VarName + ",select,")
+			for _, selectCase := range part.Cases {
+				buf.WriteString(selectCase.Value + "{")
+				writePlaceholderString(buf, selectCase.Parts)
+				buf.WriteString("}")
+			}
+			buf.WriteString("}")
+		}
+	}
+}
+
+// Extract returns the message represented by the given node, which must have
+// had its placeholders set by SetPlaceholdersAndID.
+func Extract(n *ast.MsgNode) Message {
+	return Message{n.ID, n.Desc, parts(n.Body.(*ast.ListNode))}
+}
+
+// SetPlaceholdersAndID wraps the non-text content of the given message in
+// placeholder nodes and computes the message's ID.  The cases of {plural} and
+// {select} commands are translated, so their content is wrapped instead.
+func SetPlaceholdersAndID(n *ast.MsgNode) {
+	var list, ok = n.Body.(*ast.ListNode)
+	if !ok {
+		return
+	}
+	var placeholders []*ast.MsgPlaceholderNode
+	setPlaceholders(list, &placeholders)
+	disambiguate(placeholders)
+	n.ID = calcID(parts(list), n.Meaning)
+}
+
+// setPlaceholders wraps the non-text content of the given list in placeholder
+// nodes, adding them to placeholders.
+func setPlaceholders(list *ast.ListNode, placeholders *[]*ast.MsgPlaceholderNode) {
+	for i, child := range list.Nodes {
+		switch child := child.(type) {
+		case *ast.RawTextNode:
+			continue
+		case *ast.PluralNode:
+			child.VarName = varName(child.Value, "NUM")
+			for _, body := range caseBodies(child) {
+				setPlaceholders(body, placeholders)
+			}
+			continue
+		case *ast.SelectNode:
+			child.VarName = varName(child.Value, "STATUS")
+			for _, body := range caseBodies(child) {
+				setPlaceholders(body, placeholders)
+			}
+			continue
+		}
+		var ph = &ast.MsgPlaceholderNode{child.Position(), basePlaceholderName(child), child}
+		*placeholders = append(*placeholders, ph)
+		list.Nodes[i] = ph
+	}
+}
+
+// Placeholder returns the placeholder of the given name within the message,
+// or nil if there is none.
+func Placeholder(n *ast.MsgNode, name string) *ast.MsgPlaceholderNode {
+	var result *ast.MsgPlaceholderNode
+	forEachInMsg(n.Body.(*ast.ListNode), func(node ast.Node) bool {
+		if ph, ok := node.(*ast.MsgPlaceholderNode); ok && ph.Name == name {
+			result = ph
+		}
+		return result == nil
+	})
+	return result
+}
+
+// Plural returns the {plural} of the given count variable name within the
+// message, or nil if there is none.
+func Plural(n *ast.MsgNode, varName string) *ast.PluralNode {
+	var result *ast.PluralNode
+	forEachInMsg(n.Body.(*ast.ListNode), func(node ast.Node) bool {
+		if plural, ok := node.(*ast.PluralNode); ok && plural.VarName == varName {
+			result = plural
+		}
+		return result == nil
+	})
+	return result
+}
+
+// Select returns the {select} of the given variable name within the message,
+// or nil if there is none.
+func Select(n *ast.MsgNode, varName string) *ast.SelectNode {
+	var result *ast.SelectNode
+	forEachInMsg(n.Body.(*ast.ListNode), func(node ast.Node) bool {
+		if sel, ok := node.(*ast.SelectNode); ok && sel.VarName == varName {
+			result = sel
+		}
+		return result == nil
+	})
+	return result
+}
+
+// forEachInMsg calls fn for each node of the given message body, including
+// those within the cases of {plural} and {select} commands, until it returns
+// false.
+func forEachInMsg(list *ast.ListNode, fn func(ast.Node) bool) bool {
+	for _, child := range list.Nodes {
+		if !fn(child) {
+			return false
+		}
+		for _, body := range caseBodies(child) {
+			if !forEachInMsg(body, fn) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// caseBodies returns the bodies of the cases of the given {plural} or
+// {select}, the default last, or nil for any other node.
+func caseBodies(node ast.Node) []*ast.ListNode {
+	var result []*ast.ListNode
+	switch node := node.(type) {
+	case *ast.PluralNode:
+		for _, pluralCase := range node.Cases {
+			result = append(result, pluralCase.Body.(*ast.ListNode))
+		}
+		result = append(result, node.Default.(*ast.ListNode))
+	case *ast.SelectNode:
+		for _, selectCase := range node.Cases {
+			result = append(result, selectCase.Body.(*ast.ListNode))
+		}
+		result = append(result, node.Default.(*ast.ListNode))
+	}
+	return result
+}
+
+// parts returns the message parts of the given message body, merging adjacent
+// text.
+func parts(list *ast.ListNode) []Part {
+	var result []Part
+	var text bytes.Buffer
+	var flush = func() {
+		if text.Len() > 0 {
+			result = append(result, RawTextPart{text.String()})
+			text.Reset()
+		}
+	}
+	for _, child := range list.Nodes {
+		switch child := child.(type) {
+		case *ast.RawTextNode:
+			text.Write(child.Text)
+		case *ast.MsgPlaceholderNode:
+			flush()
+			result = append(result, PlaceholderPart{child.Name})
+		case *ast.PluralNode:
+			flush()
+			var plural = PluralPart{VarName: child.VarName}
+			for _, pluralCase := range child.Cases {
+				plural.Cases = append(plural.Cases, PluralCase{
+					"=" + strconv.FormatInt(pluralCase.Value, 10),
+					parts(pluralCase.Body.(*ast.ListNode)),
+				})
+			}
+			plural.Cases = append(plural.Cases, PluralCase{"other", parts(child.Default.(*ast.ListNode))})
+			result = append(result, plural)
+		case *ast.SelectNode:
+			flush()
+			var sel = SelectPart{VarName: child.VarName}
+			for _, selectCase := range child.Cases {
+				sel.Cases = append(sel.Cases, SelectCase{selectCase.Value, parts(selectCase.Body.(*ast.ListNode))})
+			}
+			sel.Cases = append(sel.Cases, SelectCase{"other", parts(child.Default.(*ast.ListNode))})
+			result = append(result, sel)
+		}
+	}
+	flush()
+	return result
+}
+
+// varName returns the placeholder name for the value of a {plural} or
+// {select}: named after the data ref, like printed data refs, or the given
+// fallback otherwise.
+func varName(value ast.Node, fallback string) string {
+	var name = basePlaceholderName(&ast.PrintNode{value.Position(), value, nil})
+	if name == "XXX" {
+		return fallback
+	}
+	return name
+}
+
+// basePlaceholderName returns the placeholder name for the given node, before
+// disambiguation.  Printed data refs are named after their last key, e.g.
+// {$user.firstName} becomes FIRST_NAME, and calls with a phname attribute are
+// named after it.  Everything else is XXX.
+func basePlaceholderName(n ast.Node) string {
+	if call, ok := n.(*ast.CallNode); ok && call.PhName != "" {
+		return toUpperUnderscore(call.PhName)
+	}
+	var print, ok = n.(*ast.PrintNode)
+	if !ok {
+		return "XXX"
+	}
+	var ref, isRef = print.Arg.(*ast.DataRefNode)
+	if !isRef {
+		return "XXX"
+	}
+	if len(ref.Access) == 0 {
+		return toUpperUnderscore(ref.Key)
+	}
+	if key, ok := ref.Access[len(ref.Access)-1].(*ast.DataRefKeyNode); ok {
+		return toUpperUnderscore(key.Key)
+	}
+	return "XXX"
+}
+
+// disambiguate renames placeholders that share a base name but stand for
+// different content to NAME_1, NAME_2, etc.  Placeholders for identical
+// content keep sharing a name.
+func disambiguate(placeholders []*ast.MsgPlaceholderNode) {
+	var contentByName = make(map[string][]string)
+	for _, ph := range placeholders {
+		var content = ph.Body.String()
+		if !contains(contentByName[ph.Name], content) {
+			contentByName[ph.Name] = append(contentByName[ph.Name], content)
+		}
+	}
+	for _, ph := range placeholders {
+		var contents = contentByName[ph.Name]
+		if len(contents) == 1 {
+			continue
+		}
+		var content = ph.Body.String()
+		for i, c := range contents {
+			if c == content {
+				ph.Name += "_" + strconv.Itoa(i+1)
+				break
+			}
+		}
+	}
+}
+
+// toUpperUnderscore converts a lowerCamel identifier to UPPER_UNDERSCORE.
+func toUpperUnderscore(ident string) string {
+	var buf bytes.Buffer
+	var prev rune
+	for i, r := range ident {
+		if i > 0 && (unicode.IsUpper(r) && !unicode.IsUpper(prev) && prev != '_' ||
+			unicode.IsDigit(r) && unicode.IsLetter(prev)) {
+			buf.WriteByte('_')
+		}
+		buf.WriteRune(unicode.ToUpper(r))
+		prev = r
+	}
+	return buf.String()
+}
+
+func contains(slice []string, item string) bool {
+	for _, candidate := range slice {
+		if candidate == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package soymsg_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/soymsg"
+)
+
+func TestExtract(t *testing.T) {
+	var tests = []struct {
+		msg      string
+		expected string
+	}{
+		{`{msg desc=""}Hello world{/msg}`, "Hello world"},
+		{`{msg desc=""}Hello {$name}!{/msg}`, "Hello {NAME}!"},
+		{`{msg desc=""}Hello {$user.firstName}{/msg}`, "Hello {FIRST_NAME}"},
+		{`{msg desc=""}See {call .link/} for {$page2}{/msg}`, "See {XXX} for {PAGE_2}"},
+		{`{msg desc=""}See {call .link phname="helpLink"/}{/msg}`, "See {HELP_LINK}"},
+		{`{msg desc=""}{$a.name} and {$b.name} and {$a.name}{/msg}`, "{NAME_1} and {NAME_2} and {NAME_1}"},
+		{`{msg desc=""}{$list[0]} {1 + 2}{/msg}`, "{XXX_1} {XXX_2}"},
+		{`{msg desc=""}{plural $count}{case 1}one {$item.name}{default}{$count} {$other.name}s{/plural}{/msg}`,
+			"{COUNT,plural,=1{one {NAME_1}}other{{COUNT} {NAME_2}s}}"},
+		{`{msg desc=""}Found {plural length($items)}{case 0}nothing{default}some{/plural}.{/msg}`,
+			"Found {NUM,plural,=0{nothing}other{some}}."},
+		{`{msg desc=""}{select $user.gender}{case 'female'}{$user.name} sent her {plural $n}{case 1}gift{default}{$n} gifts{/plural}{default}{$user.name} sent a gift{/select}{/msg}`,
+			"{GENDER,select,female{{NAME} sent her {N,plural,=1{gift}other{{N} gifts}}}other{{NAME} sent a gift}}"},
+		{`{msg desc=""}{select 'x'}{case 'x'}x{default}y{/select}{/msg}`, "{STATUS,select,x{x}other{y}}"},
+	}
+	for _, test := range tests {
+		var msg = parseMsg(t, test.msg)
+		if msg == nil {
+			continue
+		}
+		var actual = soymsg.Extract(msg).PlaceholderString()
+		if actual != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.msg, test.expected, actual)
+		}
+	}
+}
+
+func TestID(t *testing.T) {
+	var hello = parseMsg(t, `{msg desc="a"}Hello {$name}{/msg}`)
+	var helloOtherDesc = parseMsg(t, `{msg desc="b"}Hello {$name}{/msg}`)
+	var helloOtherVar = parseMsg(t, `{msg desc="a"}Hello {$user.name}{/msg}`)
+	var helloMeaning = parseMsg(t, `{msg desc="a" meaning="greeting"}Hello {$name}{/msg}`)
+	if hello == nil || helloOtherDesc == nil || helloOtherVar == nil || helloMeaning == nil {
+		return
+	}
+	if hello.ID == 0 || hello.ID>>63 != 0 {
+		t.Errorf("invalid id: %d", hello.ID)
+	}
+	if hello.ID != helloOtherDesc.ID || hello.ID != helloOtherVar.ID {
+		t.Errorf("expected the description and placeholder content not to affect the id")
+	}
+	if hello.ID == helloMeaning.ID {
+		t.Errorf("expected the meaning to affect the id")
+	}
+}
+
+func TestReadXTB(t *testing.T) {
+	var bundle, err = soymsg.ReadXTB(strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE translationbundle>
+<translationbundle lang="fr">
+  <translation id="1" desc="greeting">Bonjour <ph name="NAME"/> {et} &lt;b&gt;</translation>
+  <translation id="2">{COUNT,plural,=1{un article}other{<ph name="COUNT"/> articles}}.</translation>
+  <translation id="3">{GENDER,select,female{<ph name="NAME"/> a envoyé {N,plural,=1{un cadeau}other{<ph name="N"/> cadeaux}}}other{<ph name="NAME"/> a envoyé un cadeau}}</translation>
+</translationbundle>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Locale != "fr" || bundle.Len() != 3 {
+		t.Errorf("expected 3 messages for fr, got %d for %q", bundle.Len(), bundle.Locale)
+	}
+	if msg := bundle.Message(1); msg == nil || msg.Desc != "greeting" {
+		t.Errorf("expected message 1 with its description, got %v", msg)
+	}
+	for id, expected := range map[uint64]string{
+		1: "Bonjour {NAME} {et} <b>",
+		2: "{COUNT,plural,=1{un article}other{{COUNT} articles}}.",
+		3: "{GENDER,select,female{{NAME} a envoyé {N,plural,=1{un cadeau}other{{N} cadeaux}}}other{{NAME} a envoyé un cadeau}}",
+	} {
+		var msg = bundle.Message(id)
+		if msg == nil {
+			t.Errorf("message %d: not found", id)
+		} else if actual := msg.PlaceholderString(); actual != expected {
+			t.Errorf("message %d: expected %q, got %q", id, expected, actual)
+		}
+	}
+	if bundle.Message(4) != nil {
+		t.Errorf("expected no message 4")
+	}
+
+	for _, src := range []string{
+		`<translationbundle><translation id="x">a</translation></translationbundle>`,
+		`<translationbundle><translation id="1">{N,plural,=1{one}</translation></translationbundle>`,
+		`<translationbundle><translation id="1">{N,plural,one}</translation></translationbundle>`,
+		`<translationbundle><translation id="1">a</translationbundle>`,
+	} {
+		if _, err = soymsg.ReadXTB(strings.NewReader(src)); err == nil {
+			t.Errorf("expected an error reading %s", src)
+		}
+	}
+}
+
+func TestReadXLIFF(t *testing.T) {
+	var bundle, err = soymsg.ReadXLIFF(strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?>
+<xliff version="1.2" xmlns="urn:oasis:names:tc:xliff:document:1.2">
+  <file original="SoyMsgBundle" datatype="x-soy-msg-bundle" xml:space="preserve" source-language="en" target-language="de">
+    <body>
+      <trans-unit id="1" datatype="html">
+        <source>Hello <x id="NAME"/></source>
+        <target>Hallo <x id="NAME"/></target>
+        <note priority="1" from="description">greeting</note>
+      </trans-unit>
+      <trans-unit id="2" datatype="html">
+        <source>Untranslated</source>
+      </trans-unit>
+    </body>
+  </file>
+</xliff>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Locale != "de" || bundle.Len() != 1 {
+		t.Errorf("expected 1 message for de, got %d for %q", bundle.Len(), bundle.Locale)
+	}
+	if msg := bundle.Message(1); msg == nil || msg.PlaceholderString() != "Hallo {NAME}" {
+		t.Errorf("expected the translation of message 1, got %v", msg)
+	}
+}
+
+func parseMsg(t *testing.T, msg string) *ast.MsgNode {
+	var f, err = parse.SoyFile("", "{namespace test}{template .foo}"+msg+"{/template}", nil)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	return f.Body[1].(*ast.TemplateNode).Body.Nodes[0].(*ast.MsgNode)
+}
@@ -0,0 +1,73 @@
+/*
+Package soyrename is a command that renames a template or namespace across a
+set of soy files, updating its declaration and all calls and aliases that
+refer to it.  Formatting is otherwise preserved.
+
+Invoke it like so:
+
+	go get github.com/harrisonzhao/soy/soyrename
+	soyrename [-w] [-globals file] from to path...
+
+Paths may be soy files or directories, which are searched for soy files.  By
+default, the files that would change are listed.  With -w, they are rewritten
+in place.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/harrisonzhao/soy"
+)
+
+var (
+	write   = flag.Bool("w", false, "write the result to the files instead of listing them")
+	globals = flag.String("globals", "", "file of globals used by the templates")
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() < 3 {
+		fmt.Fprintln(os.Stderr, "usage: soyrename [-w] [-globals file] from to path...")
+		os.Exit(2)
+	}
+
+	var bundle = soy.NewBundle()
+	if *globals != "" {
+		bundle.AddGlobalsFile(*globals)
+	}
+	for _, path := range flag.Args()[2:] {
+		var info, err = os.Stat(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if info.IsDir() {
+			bundle.AddTemplateDir(path)
+		} else {
+			bundle.AddTemplateFile(path)
+		}
+	}
+
+	var changed, err = bundle.Rename(flag.Arg(0), flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var filenames []string
+	for filename := range changed {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		fmt.Println(filename)
+		if *write {
+			if err = ioutil.WriteFile(filename, []byte(changed[filename]), 0644); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
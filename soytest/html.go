@@ -0,0 +1,321 @@
+package soytest
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Element is an HTML element of rendered output.
+type Element struct {
+	Tag      string            // lower case tag name
+	Attrs    map[string]string // attribute values, by lower case name
+	Children []*Element
+	parent   *Element
+	content  []interface{} // child elements and text, in order
+}
+
+// Attr returns the value of the named attribute, and whether it is present.
+func (e *Element) Attr(name string) (string, bool) {
+	var val, ok = e.Attrs[name]
+	return val, ok
+}
+
+// Text returns the text within the element, with entities decoded.
+func (e *Element) Text() string {
+	var buf strings.Builder
+	e.writeText(&buf)
+	return buf.String()
+}
+
+func (e *Element) writeText(buf *strings.Builder) {
+	for _, c := range e.content {
+		switch c := c.(type) {
+		case string:
+			buf.WriteString(c)
+		case *Element:
+			c.writeText(buf)
+		}
+	}
+}
+
+// parseHTML parses the given output as HTML5 browsers do, returning a root
+// element (with no tag) containing the top-level elements.  Output beginning
+// with a doctype or <html> tag is parsed as a document; other output is parsed
+// as a fragment of the body of one.
+func parseHTML(src string) *Element {
+	var root = &Element{Attrs: map[string]string{}}
+	var start = strings.ToLower(strings.TrimSpace(src))
+	if strings.HasPrefix(start, "<!doctype") || strings.HasPrefix(start, "<html") {
+		if doc, err := html.Parse(strings.NewReader(src)); err == nil {
+			root.addChildren(doc)
+		}
+		return root
+	}
+	var body = &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	var nodes, err = html.ParseFragment(strings.NewReader(src), body)
+	if err != nil {
+		return root
+	}
+	for _, node := range nodes {
+		root.add(node)
+	}
+	return root
+}
+
+// addChildren adds the children of the given node to the element.
+func (e *Element) addChildren(node *html.Node) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		e.add(child)
+	}
+}
+
+// add adds the given node to the element's content, if it is an element or
+// text.
+func (e *Element) add(node *html.Node) {
+	switch node.Type {
+	case html.TextNode:
+		if node.Data != "" {
+			e.content = append(e.content, node.Data)
+		}
+	case html.ElementNode:
+		var child = &Element{Tag: node.Data, Attrs: make(map[string]string, len(node.Attr)), parent: e}
+		for _, attr := range node.Attr {
+			if _, ok := child.Attrs[attr.Key]; !ok {
+				child.Attrs[attr.Key] = attr.Val
+			}
+		}
+		e.Children = append(e.Children, child)
+		e.content = append(e.content, child)
+		child.addChildren(node)
+	}
+}
+
+// selector is a parsed CSS selector: a comma-separated group of complex
+// selectors.
+type selector [][]compound
+
+// compound is a compound selector, e.g. "a.external[href]", along with the
+// combinator relating it to the compound before it.
+type compound struct {
+	child   bool // true if it must be a child (>) of the previous compound, rather than a descendant
+	tag     string
+	id      string
+	classes []string
+	attrs   []attrSelector
+}
+
+type attrSelector struct {
+	name, value string
+	hasValue    bool
+}
+
+// parseSelector parses a CSS selector.  Type, universal, ID, class, and
+// attribute ([attr] and [attr=value]) selectors are supported, combined by the
+// descendant and child (>) combinators, in comma-separated groups.
+func parseSelector(src string) (selector, error) {
+	var result selector
+	var complex []compound
+	var child bool
+	var tokens, err = selectorTokens(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %v", src, err)
+	}
+	for _, token := range append(tokens, ",") {
+		switch token {
+		case ",":
+			if len(complex) == 0 || child {
+				return nil, fmt.Errorf("invalid selector %q", src)
+			}
+			result, complex = append(result, complex), nil
+		case ">":
+			if len(complex) == 0 || child {
+				return nil, fmt.Errorf("invalid selector %q", src)
+			}
+			child = true
+		default:
+			var c, err = parseCompound(token)
+			if err != nil {
+				return nil, fmt.Errorf("invalid selector %q: %v", src, err)
+			}
+			c.child = child
+			child = false
+			complex = append(complex, c)
+		}
+	}
+	return result, nil
+}
+
+// selectorTokens splits the given selector into compound selectors and the
+// combinators and commas between them, ignoring those within the brackets of
+// attribute selectors.
+func selectorTokens(src string) ([]string, error) {
+	var tokens []string
+	var start = -1
+	for i := 0; i < len(src); i++ {
+		switch ch := src[i]; {
+		case ch == '[':
+			var end = attrEnd(src[i:])
+			if end == -1 {
+				return nil, fmt.Errorf("unclosed [")
+			}
+			if start == -1 {
+				start = i
+			}
+			i += end
+		case ch == '>' || ch == ',' || isSpace(ch):
+			if start != -1 {
+				tokens, start = append(tokens, src[start:i]), -1
+			}
+			if !isSpace(ch) {
+				tokens = append(tokens, string(ch))
+			}
+		default:
+			if start == -1 {
+				start = i
+			}
+		}
+	}
+	if start != -1 {
+		tokens = append(tokens, src[start:])
+	}
+	return tokens, nil
+}
+
+// attrEnd returns the offset of the ] closing the attribute selector at the
+// beginning of src, skipping quoted values, or -1 if it is not closed.
+func attrEnd(src string) int {
+	var quote byte
+	for i := 1; i < len(src); i++ {
+		switch ch := src[i]; {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func parseCompound(src string) (compound, error) {
+	var c compound
+	var i = strings.IndexAny(src, "#.[")
+	if i == -1 {
+		i = len(src)
+	}
+	if tag := src[:i]; tag != "*" {
+		c.tag = strings.ToLower(tag)
+	}
+	for src = src[i:]; src != ""; {
+		var kind = src[0]
+		if kind == '[' {
+			var end = attrEnd(src)
+			if end == -1 {
+				return c, fmt.Errorf("unclosed [")
+			}
+			var attr attrSelector
+			attr.name = strings.ToLower(src[1:end])
+			if eq := strings.IndexByte(src[:end], '='); eq != -1 {
+				attr.name = strings.ToLower(src[1:eq])
+				attr.value = strings.Trim(src[eq+1:end], `"'`)
+				attr.hasValue = true
+			}
+			c.attrs = append(c.attrs, attr)
+			src = src[end+1:]
+			continue
+		}
+		src = src[1:]
+		var end = strings.IndexAny(src, "#.[")
+		if end == -1 {
+			end = len(src)
+		}
+		if end == 0 {
+			return c, fmt.Errorf("empty name after %q", kind)
+		}
+		if kind == '#' {
+			c.id = src[:end]
+		} else {
+			c.classes = append(c.classes, src[:end])
+		}
+		src = src[end:]
+	}
+	return c, nil
+}
+
+// find returns the elements within root that match the selector, in document
+// order.
+func (sel selector) find(root *Element) []*Element {
+	var result []*Element
+	var visit func(e *Element)
+	visit = func(e *Element) {
+		for _, child := range e.Children {
+			for _, complex := range sel {
+				if matches(child, complex) {
+					result = append(result, child)
+					break
+				}
+			}
+			visit(child)
+		}
+	}
+	visit(root)
+	return result
+}
+
+// matches reports whether e matches the last compound of the given complex
+// selector, and its ancestors match those before it.
+func matches(e *Element, complex []compound) bool {
+	var last = complex[len(complex)-1]
+	if !last.matches(e) {
+		return false
+	}
+	if len(complex) == 1 {
+		return true
+	}
+	for ancestor := e.parent; ancestor != nil && ancestor.parent != nil; ancestor = ancestor.parent {
+		if matches(ancestor, complex[:len(complex)-1]) {
+			return true
+		}
+		if last.child {
+			break
+		}
+	}
+	return false
+}
+
+func (c compound) matches(e *Element) bool {
+	if c.tag != "" && c.tag != e.Tag {
+		return false
+	}
+	if c.id != "" && e.Attrs["id"] != c.id {
+		return false
+	}
+	var classes = strings.Fields(e.Attrs["class"])
+	for _, class := range c.classes {
+		if !contains(classes, class) {
+			return false
+		}
+	}
+	for _, attr := range c.attrs {
+		var val, ok = e.Attrs[attr.name]
+		if !ok || attr.hasValue && val != attr.value {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+// Package soytest provides assertions on the output of templates, so that
+// their behavior may be unit tested without a server.
+//
+// A test renders a template and makes assertions on the result, which report
+// failures to the test:
+//
+//	var r = soytest.Render(t, tofu, "shop.cart", data.Map{"items": items})
+//	r.Valid().
+//		Contains("Your cart").
+//		Count("ul.items > li", 3).
+//		Text("#total", "$12.00").
+//		Executed("shop.cart:14: ifempty")
+//
+// Elements of the output are selected by CSS selectors; see Result.Find for
+// the selectors supported.
+package soytest
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/soyhtml"
+)
+
+// maxTrace is the number of branches recorded for Executed and NotExecuted.
+const maxTrace = 10000
+
+// Result is the output of a render, on which assertions may be made.  Each
+// assertion reports a failure to the test (continuing it), and returns the
+// result so that further assertions may be chained.
+type Result struct {
+	Output string         // the rendered output
+	Trace  *soyhtml.Trace // the expressions evaluated and branches taken by the render
+
+	t   testing.TB
+	doc *Element // the parsed output, once needed
+}
+
+// Render renders the named template with the given data, failing the test
+// immediately if the render fails.
+func Render(t testing.TB, tofu *soyhtml.Tofu, name string, obj data.Map) *Result {
+	t.Helper()
+	return RenderWith(t, tofu.NewRenderer(name), obj)
+}
+
+// RenderWith is like Render, but renders with the given renderer, which may
+// be configured with injected data, messages, etc.  Its trace is replaced.
+func RenderWith(t testing.TB, renderer *soyhtml.Renderer, obj data.Map) *Result {
+	t.Helper()
+	var trace = soyhtml.NewTrace(maxTrace)
+	var buf bytes.Buffer
+	if err := renderer.Trace(trace).Execute(&buf, obj); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	return &Result{Output: buf.String(), Trace: trace, t: t}
+}
+
+// Contains asserts that the output contains the given text.
+func (r *Result) Contains(text string) *Result {
+	r.t.Helper()
+	if !strings.Contains(r.Output, text) {
+		r.t.Errorf("expected output to contain %q, got:\n%s", text, r.Output)
+	}
+	return r
+}
+
+// NotContains asserts that the output does not contain the given text.
+func (r *Result) NotContains(text string) *Result {
+	r.t.Helper()
+	if strings.Contains(r.Output, text) {
+		r.t.Errorf("expected output not to contain %q, got:\n%s", text, r.Output)
+	}
+	return r
+}
+
+// Matches asserts that the output matches the given regular expression.
+func (r *Result) Matches(pattern string) *Result {
+	r.t.Helper()
+	var re, err = regexp.Compile(pattern)
+	if err != nil {
+		r.t.Errorf("invalid pattern %q: %v", pattern, err)
+	} else if !re.MatchString(r.Output) {
+		r.t.Errorf("expected output to match %q, got:\n%s", pattern, r.Output)
+	}
+	return r
+}
+
+// Find returns the elements of the output that match the given CSS selector,
+// in document order.  Type, universal (*), ID, class, and attribute ([attr],
+// [attr=value]) selectors are supported, combined by the descendant and child
+// (>) combinators, in comma-separated groups.  An invalid selector fails the
+// test.
+func (r *Result) Find(sel string) []*Element {
+	r.t.Helper()
+	var parsed, err = parseSelector(sel)
+	if err != nil {
+		r.t.Errorf("%v", err)
+		return nil
+	}
+	if r.doc == nil {
+		r.doc = parseHTML(r.Output)
+	}
+	return parsed.find(r.doc)
+}
+
+// Count asserts that n elements of the output match the given selector.
+func (r *Result) Count(sel string, n int) *Result {
+	r.t.Helper()
+	if found := r.Find(sel); len(found) != n {
+		r.t.Errorf("expected %d elements matching %q, found %d in:\n%s", n, sel, len(found), r.Output)
+	}
+	return r
+}
+
+// Text asserts that the first element matching the given selector contains
+// the given text, ignoring leading and trailing whitespace.
+func (r *Result) Text(sel, text string) *Result {
+	r.t.Helper()
+	var e = r.first(sel)
+	if e != nil && strings.TrimSpace(e.Text()) != text {
+		r.t.Errorf("expected %q to have text %q, got %q", sel, text, strings.TrimSpace(e.Text()))
+	}
+	return r
+}
+
+// Attr asserts that the first element matching the given selector has the
+// given attribute value.
+func (r *Result) Attr(sel, name, value string) *Result {
+	r.t.Helper()
+	var e = r.first(sel)
+	if e == nil {
+		return r
+	}
+	if actual, ok := e.Attr(name); !ok {
+		r.t.Errorf("expected %q to have attribute %s", sel, name)
+	} else if actual != value {
+		r.t.Errorf("expected %q to have %s=%q, got %q", sel, name, value, actual)
+	}
+	return r
+}
+
+// first returns the first element matching the given selector, failing the
+// test if there is none.
+func (r *Result) first(sel string) *Element {
+	r.t.Helper()
+	var found = r.Find(sel)
+	if len(found) == 0 {
+		r.t.Errorf("expected an element matching %q in:\n%s", sel, r.Output)
+		return nil
+	}
+	return found[0]
+}
+
+// Executed asserts that the render took the given branch of an {if},
+// {switch}, or {foreach} command, identified as by soyhtml.TraceBranch's
+// String, e.g. "shop.cart:14: elseif".
+func (r *Result) Executed(branch string) *Result {
+	r.t.Helper()
+	if !r.executed(branch) {
+		r.t.Errorf("expected branch %q to be taken; took:\n%s", branch, r.branches())
+	}
+	return r
+}
+
+// NotExecuted asserts that the render did not take the given branch.  It
+// fails if the trace was truncated, since the branch may have been taken after
+// the trace stopped recording.
+func (r *Result) NotExecuted(branch string) *Result {
+	r.t.Helper()
+	if r.executed(branch) {
+		r.t.Errorf("expected branch %q not to be taken", branch)
+	} else if r.Trace.Truncated {
+		r.t.Errorf("expected branch %q not to be taken, but the trace was truncated after %d branches",
+			branch, len(r.Trace.Branches))
+	}
+	return r
+}
+
+func (r *Result) executed(branch string) bool {
+	for _, b := range r.Trace.Branches {
+		if b.String() == branch {
+			return true
+		}
+	}
+	return false
+}
+
+// branches returns the branches taken, one per line.
+func (r *Result) branches() string {
+	var lines []string
+	for _, b := range r.Trace.Branches {
+		lines = append(lines, b.String())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,105 @@
+package soytest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/parse"
+	"github.com/harrisonzhao/soy/soyhtml"
+	"github.com/harrisonzhao/soy/template"
+)
+
+// recorder records the failures reported by assertions.
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+}
+
+func TestAssertions(t *testing.T) {
+	var registry = template.Registry{}
+	var tree, err = parse.SoyFile("", `{namespace shop}
+/** @param items */
+{template .cart}
+<h1 class="title main">Your cart</h1>
+<ul class="items">
+  {foreach $item in $items}
+    <li data-sku="{$item.sku}" title="{$item.name} > more">{$item.name} <b>x{$item.qty}</b></li>
+  {ifempty}
+    <li class="empty">Nothing here</li>
+  {/foreach}
+</ul>
+{/template}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Add(tree)
+	var tofu = soyhtml.NewTofu(&registry)
+
+	var rec = &recorder{TB: t}
+	Render(rec, tofu, "shop.cart", data.Map{"items": data.New([]map[string]interface{}{
+		{"sku": "a1", "name": "Fish & chips", "qty": 2},
+		{"sku": "b2", "name": "Tea", "qty": 1},
+	})}).
+		Contains("Your cart").
+		NotContains("Nothing here").
+		Matches(`x\d</b>`).
+		Count("ul.items > li", 2).
+		Count("li b, h1", 3).
+		Count(".title.main", 1).
+		Count("ul > b", 0).
+		Text("li[data-sku=a1]", "Fish & chips x2").
+		Count(`li[title="Tea > more"]`, 1).
+		Count(`ul > li[title='Tea > more'], h1`, 2).
+		Attr("ul li", "data-sku", "a1").
+		Executed("shop.cart:6: foreach").
+		NotExecuted("shop.cart:6: ifempty")
+	if len(rec.failures) > 0 {
+		t.Errorf("unexpected failures: %q", rec.failures)
+	}
+
+	rec = &recorder{TB: t}
+	Render(rec, tofu, "shop.cart", data.Map{"items": data.List{}}).
+		Contains("Your cart!").
+		Count("li", 2).
+		Text("li.empty", "Nothing").
+		Attr("li", "class", "full").
+		Executed("shop.cart:6: foreach").
+		Count("li >", 1)
+	var output = `<h1 class="title main">Your cart</h1><ul class="items"><li class="empty">Nothing here</li></ul>`
+	var expected = []string{
+		"expected output to contain \"Your cart!\", got:\n" + output,
+		"expected 2 elements matching \"li\", found 1 in:\n" + output,
+		`expected "li.empty" to have text "Nothing", got "Nothing here"`,
+		`expected "li" to have class="full", got "empty"`,
+		"expected branch \"shop.cart:6: foreach\" to be taken; took:\nshop.cart:6: ifempty",
+		`invalid selector "li >"`,
+		"expected 1 elements matching \"li >\", found 0 in:\n" + output,
+	}
+	if !reflect.DeepEqual(rec.failures, expected) {
+		t.Errorf("expected failures:\n%q\ngot:\n%q", expected, rec.failures)
+	}
+}
+
+func TestNotExecutedTruncated(t *testing.T) {
+	var rec = &recorder{TB: t}
+	var r = &Result{Trace: &soyhtml.Trace{Truncated: true}, t: rec}
+	r.NotExecuted("shop.cart:6: ifempty")
+	var expected = []string{
+		`expected branch "shop.cart:6: ifempty" not to be taken, but the trace was truncated after 0 branches`,
+	}
+	if !reflect.DeepEqual(rec.failures, expected) {
+		t.Errorf("expected failures:\n%q\ngot:\n%q", expected, rec.failures)
+	}
+}
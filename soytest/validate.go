@@ -0,0 +1,305 @@
+package soytest
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Problem is a way in which rendered HTML is invalid.
+type Problem struct {
+	Line    int // line number of the problem within the output
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// optionalEndTags are the elements whose end tags may be omitted.
+var optionalEndTags = map[string]bool{
+	"html": true, "head": true, "body": true, "p": true, "li": true, "dt": true,
+	"dd": true, "option": true, "optgroup": true, "tr": true, "td": true, "th": true,
+	"thead": true, "tbody": true, "tfoot": true, "colgroup": true, "rt": true, "rp": true,
+}
+
+// Validate checks that the given HTML is well-formed, returning the problems
+// found: unterminated tags and comments, duplicate attributes, end tags that
+// do not match the open element, elements left open (other than those whose
+// end tags are optional, like <li>), and IDs used by more than one element.
+//
+// It checks only the structure of the document, not whether elements are
+// permitted where they appear.
+func Validate(html string) []Problem {
+	var v = validator{src: html, ids: make(map[string]int)}
+	v.validate()
+	return v.problems
+}
+
+type validator struct {
+	src      string
+	pos      int            // offset of the input being validated
+	open     []openTag      // elements opened and not yet closed, outermost first
+	ids      map[string]int // line numbers of the elements of each ID
+	problems []Problem
+}
+
+type openTag struct {
+	name string
+	line int
+}
+
+func (v *validator) validate() {
+	for {
+		var lt = strings.IndexByte(v.src[v.pos:], '<')
+		if lt == -1 {
+			break
+		}
+		v.pos += lt
+		var rest = v.src[v.pos:]
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			v.skipPast("-->", "unterminated comment")
+		case strings.HasPrefix(rest, "<!"), strings.HasPrefix(rest, "<?"):
+			v.skipPast(">", "unterminated declaration")
+		case strings.HasPrefix(rest, "</"):
+			v.endTag()
+		case len(rest) > 1 && isLetter(rest[1]):
+			v.startTag()
+		default:
+			v.pos++
+		}
+	}
+	for i := len(v.open) - 1; i >= 0; i-- {
+		if !optionalEndTags[v.open[i].name] {
+			v.report(v.open[i].line, "<%s> is not closed", v.open[i].name)
+		}
+	}
+}
+
+// skipPast moves past the given end, reporting the given problem if it is not
+// found.
+func (v *validator) skipPast(end, problem string) {
+	var i = strings.Index(v.src[v.pos:], end)
+	if i == -1 {
+		v.report(v.line(v.pos), "%s", problem)
+		v.pos = len(v.src)
+		return
+	}
+	v.pos += i + len(end)
+}
+
+func (v *validator) startTag() {
+	var line = v.line(v.pos)
+	var tagEnd = tagEnd(v.src[v.pos:])
+	if tagEnd == -1 {
+		v.report(line, "unterminated tag")
+		v.pos = len(v.src)
+		return
+	}
+	var tag = v.src[v.pos : v.pos+tagEnd+1]
+	v.pos += tagEnd + 1
+	var e, _, selfClosing = parseStartTag(tag)
+	for _, name := range duplicateAttrs(tag) {
+		v.report(line, "<%s> has duplicate attribute %s", e.Tag, name)
+	}
+	if id, ok := e.Attrs["id"]; ok {
+		if prev, dup := v.ids[id]; dup {
+			v.report(line, "id %q is already used on line %d", id, prev)
+		} else {
+			v.ids[id] = line
+		}
+	}
+	switch {
+	case voidElements[e.Tag] || selfClosing:
+		return
+	case rawTextElements[e.Tag]:
+		var end = indexFold(v.src[v.pos:], "</"+e.Tag)
+		if end == -1 {
+			v.report(line, "<%s> is not closed", e.Tag)
+			v.pos = len(v.src)
+			return
+		}
+		v.pos += end
+		v.open = append(v.open, openTag{e.Tag, line})
+	default:
+		v.open = append(v.open, openTag{e.Tag, line})
+	}
+}
+
+func (v *validator) endTag() {
+	var line = v.line(v.pos)
+	var end = strings.IndexByte(v.src[v.pos:], '>')
+	if end == -1 {
+		v.report(line, "unterminated end tag")
+		v.pos = len(v.src)
+		return
+	}
+	var name = strings.ToLower(strings.TrimSpace(v.src[v.pos+2 : v.pos+end]))
+	v.pos += end + 1
+	for i := len(v.open) - 1; i >= 0; i-- {
+		if v.open[i].name != name {
+			continue
+		}
+		for _, unclosed := range v.open[i+1:] {
+			if !optionalEndTags[unclosed.name] {
+				v.report(unclosed.line, "<%s> is not closed before </%s> on line %d",
+					unclosed.name, name, line)
+			}
+		}
+		v.open = v.open[:i]
+		return
+	}
+	v.report(line, "</%s> does not match an open element", name)
+}
+
+func (v *validator) line(pos int) int {
+	return 1 + strings.Count(v.src[:pos], "\n")
+}
+
+func (v *validator) report(line int, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{line, fmt.Sprintf(format, args...)})
+}
+
+// tagEnd returns the offset of the '>' ending the tag at the start of src,
+// allowing for quoted attribute values, or -1 if there is none.
+func tagEnd(src string) int {
+	var quote byte
+	for i := 1; i < len(src); i++ {
+		switch ch := src[i]; {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			if src[i-1] == '=' || isSpace(src[i-1]) {
+				quote = ch
+			}
+		case ch == '>':
+			return i
+		case ch == '<':
+			return -1
+		}
+	}
+	return -1
+}
+
+// duplicateAttrs returns the names of the attributes that appear more than
+// once in the given start tag.
+func duplicateAttrs(tag string) []string {
+	var seen = make(map[string]bool)
+	var result []string
+	var i = 1
+	for i < len(tag) && !isSpace(tag[i]) && tag[i] != '>' && tag[i] != '/' {
+		i++
+	}
+	for i < len(tag) {
+		switch {
+		case isSpace(tag[i]) || tag[i] == '/' || tag[i] == '>':
+			i++
+		default:
+			var start = i
+			for i < len(tag) && !isSpace(tag[i]) && tag[i] != '>' && tag[i] != '=' && tag[i] != '/' {
+				i++
+			}
+			var name = strings.ToLower(tag[start:i])
+			if seen[name] {
+				result = append(result, name)
+			}
+			seen[name] = true
+			if i < len(tag) && tag[i] == '=' {
+				_, i = parseAttrValue(tag, i+1)
+			}
+		}
+	}
+	return result
+}
+
+// Valid asserts that the output is well-formed HTML (see Validate).
+func (r *Result) Valid() *Result {
+	r.t.Helper()
+	for _, problem := range Validate(r.Output) {
+		r.t.Errorf("invalid HTML: %v", problem)
+	}
+	return r
+}
+
+// voidElements have no content or end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true,
+	"img": true, "input": true, "link": true, "meta": true, "param": true,
+	"source": true, "track": true, "wbr": true,
+}
+
+// rawTextElements contain text that is not parsed as markup.
+var rawTextElements = map[string]bool{
+	"script": true, "style": true, "textarea": true, "title": true,
+}
+
+// parseStartTag parses the start tag at the beginning of src, returning the
+// element and the rest of src.
+func parseStartTag(src string) (e *Element, rest string, selfClosing bool) {
+	e = &Element{Attrs: map[string]string{}}
+	var i = 1
+	for i < len(src) && !isSpace(src[i]) && src[i] != '>' && src[i] != '/' {
+		i++
+	}
+	e.Tag = strings.ToLower(src[1:i])
+	for i < len(src) {
+		switch {
+		case isSpace(src[i]):
+			i++
+		case src[i] == '>':
+			return e, src[i+1:], selfClosing
+		case src[i] == '/':
+			selfClosing = true
+			i++
+		default:
+			selfClosing = false
+			var start = i
+			for i < len(src) && !isSpace(src[i]) && src[i] != '>' && src[i] != '=' &&
+				!(src[i] == '/' && i+1 < len(src) && src[i+1] == '>') {
+				i++
+			}
+			var name = strings.ToLower(src[start:i])
+			var value string
+			if i < len(src) && src[i] == '=' {
+				value, i = parseAttrValue(src, i+1)
+			}
+			if _, ok := e.Attrs[name]; !ok {
+				e.Attrs[name] = html.UnescapeString(value)
+			}
+		}
+	}
+	return e, "", selfClosing
+}
+
+// parseAttrValue parses the attribute value beginning at src[i], returning it
+// and the offset following it.
+func parseAttrValue(src string, i int) (string, int) {
+	if i < len(src) && (src[i] == '"' || src[i] == '\'') {
+		var end = strings.IndexByte(src[i+1:], src[i])
+		if end == -1 {
+			return src[i+1:], len(src)
+		}
+		return src[i+1 : i+1+end], i + end + 2
+	}
+	var start = i
+	for i < len(src) && !isSpace(src[i]) && src[i] != '>' {
+		i++
+	}
+	return src[start:i], i
+}
+
+// indexFold is like strings.Index, but ignores case.
+func indexFold(s, substr string) int {
+	return strings.Index(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func isLetter(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z'
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f'
+}
@@ -0,0 +1,32 @@
+package soytest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	var tests = []struct {
+		html     string
+		problems []string
+	}{
+		{`<!DOCTYPE html><ul><li>a<li>b</ul><br><img src="a>b"/><script>if (a<b) {}</script>`, nil},
+		{`<div><b>a</div>`, []string{"line 1: <b> is not closed before </div> on line 1"}},
+		{"<div>\n<span>", []string{"line 2: <span> is not closed", "line 1: <div> is not closed"}},
+		{`<p>a</p></p>`, []string{"line 1: </p> does not match an open element"}},
+		{"<a id=x></a>\n<b id=\"x\"></b>", []string{`line 2: id "x" is already used on line 1`}},
+		{`<a href="a" HREF=b></a>`, []string{"line 1: <a> has duplicate attribute href"}},
+		{`<a href="x"`, []string{"line 1: unterminated tag"}},
+		{`a<!-- b`, []string{"line 1: unterminated comment"}},
+		{`<style>a {}`, []string{"line 1: <style> is not closed"}},
+	}
+	for _, test := range tests {
+		var problems []string
+		for _, p := range Validate(test.html) {
+			problems = append(problems, p.String())
+		}
+		if !reflect.DeepEqual(problems, test.problems) {
+			t.Errorf("%s: expected %q, got %q", test.html, test.problems, problems)
+		}
+	}
+}
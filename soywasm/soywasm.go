@@ -0,0 +1,144 @@
+//go:build js && wasm
+
+/*
+Package soywasm is a WebAssembly build of the template renderer with thin
+JavaScript bindings, so that browsers and edge runtimes may render the same
+templates as the server, with the same implementation.
+
+Build it like so:
+
+	GOOS=js GOARCH=wasm go build -o soy.wasm github.com/harrisonzhao/soy/soywasm
+
+and run it with the wasm_exec.js support file distributed with Go:
+
+	const go = new Go();
+	const wasm = await WebAssembly.instantiateStreaming(fetch("soy.wasm"), go.importObject);
+	go.run(wasm.instance);
+
+It defines a global soy object, with which templates are compiled from their
+source and rendered:
+
+	const {templates, error} = soy.compile({"greeting.soy": source}, globals);
+	const {output, error} = templates.render("app.greeting", {name: "Rob"}, {ij: {}, locale: "fr"});
+
+The globals, and the options of a render, are optional.  Data is converted
+through JSON, so it must be representable in JSON.  Errors are returned as
+strings rather than thrown.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"syscall/js"
+
+	"github.com/harrisonzhao/soy"
+	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/soyhtml"
+)
+
+func main() {
+	js.Global().Set("soy", js.ValueOf(map[string]interface{}{
+		"compile": js.FuncOf(compile),
+	}))
+	select {} // the bindings are called for the life of the page
+}
+
+// compile implements soy.compile(files, globals).
+func compile(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || args[0].Type() != js.TypeObject {
+		return result("templates", nil, fmt.Errorf("compile: expected an object of soy files by name"))
+	}
+	var bundle = soy.NewBundle()
+	var names = keys(args[0])
+	sort.Strings(names)
+	for _, name := range names {
+		bundle.AddTemplateString(name, args[0].Get(name).String())
+	}
+	if len(args) > 1 && args[1].Truthy() {
+		var globals, err = toDataMap(args[1])
+		if err != nil {
+			return result("templates", nil, fmt.Errorf("compile: globals: %v", err))
+		}
+		bundle.AddGlobalsMap(globals)
+	}
+
+	var tofu, err = bundle.CompileToTofu()
+	if err != nil {
+		return result("templates", nil, err)
+	}
+	return result("templates", map[string]interface{}{
+		"render": js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			return render(tofu, args)
+		}),
+	}, nil)
+}
+
+// render implements templates.render(name, data, options).
+func render(tofu *soyhtml.Tofu, args []js.Value) interface{} {
+	if len(args) < 1 || args[0].Type() != js.TypeString {
+		return result("output", nil, fmt.Errorf("render: expected a template name"))
+	}
+	var renderer = tofu.NewRenderer(args[0].String())
+	var obj data.Map
+	if len(args) > 1 && args[1].Truthy() {
+		var err error
+		if obj, err = toDataMap(args[1]); err != nil {
+			return result("output", nil, fmt.Errorf("render: data: %v", err))
+		}
+	}
+	if len(args) > 2 && args[2].Truthy() {
+		var opts = args[2]
+		if ij := opts.Get("ij"); ij.Truthy() {
+			var m, err = toDataMap(ij)
+			if err != nil {
+				return result("output", nil, fmt.Errorf("render: ij: %v", err))
+			}
+			renderer.Inject(m)
+		}
+		if locale := opts.Get("locale"); locale.Type() == js.TypeString {
+			renderer.Locale(locale.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := renderer.Execute(&buf, obj); err != nil {
+		return result("output", nil, err)
+	}
+	return result("output", buf.String(), nil)
+}
+
+// result returns the object returned by a binding: the value under the given
+// key, or the error.
+func result(key string, value interface{}, err error) interface{} {
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+	return map[string]interface{}{key: value}
+}
+
+// toDataMap converts the given JavaScript object to a data.Map, through JSON.
+func toDataMap(v js.Value) (data.Map, error) {
+	var raw interface{}
+	var text = js.Global().Get("JSON").Call("stringify", v).String()
+	if err := json.Unmarshal([]byte(text), &raw); err != nil {
+		return nil, err
+	}
+	var m, ok = data.New(raw).(data.Map)
+	if !ok {
+		return nil, fmt.Errorf("expected an object, got %s", text)
+	}
+	return m, nil
+}
+
+// keys returns the names of the given object's own enumerable properties.
+func keys(obj js.Value) []string {
+	var list = js.Global().Get("Object").Call("keys", obj)
+	var result = make([]string, list.Length())
+	for i := range result {
+		result[i] = list.Index(i).String()
+	}
+	return result
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/harrisonzhao/soy"
+	"github.com/harrisonzhao/soy/diag"
+	"github.com/harrisonzhao/soy/soyhtml"
+)
+
+var (
+	mu       sync.Mutex
+	lastGood *soyhtml.Tofu // the templates last compiled without error
+)
+
+// compile compiles the templates in the file.  If they fail to compile, it
+// returns the templates last compiled without error, if any, along with the
+// compile error, so that pages may continue to be served while the error is
+// fixed.
+func compile(filename string) (*soyhtml.Tofu, error) {
+	var tofu, err = soy.NewBundle().
+		AddTemplateFile(filename).
+		CompileToTofu()
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil {
+		return lastGood, err
+	}
+	lastGood = tofu
+	return tofu, nil
+}
+
+// excerptLines is the number of lines of source shown either side of the line
+// of a compile error.
+const excerptLines = 3
+
+var overlayTemplate = template.Must(template.New("overlay").Parse(`
+<div id="soyweb-error" style="position:fixed;top:0;left:0;right:0;z-index:2147483647;` +
+	`max-height:50%;overflow:auto;margin:0;padding:1em;background:#300;color:#fdd;` +
+	`font:13px/1.4 monospace;white-space:pre-wrap;border-bottom:3px solid #f44">` +
+	`<strong>Compile error{{if .File}} in {{.File}}{{if .Line}}:{{.Line}}{{end}}{{end}}` +
+	` (showing the last successful compile)</strong>
+{{.Message}}
+{{if .Excerpt}}
+{{.Excerpt}}{{end}}</div>
+`))
+
+// writeOverlay writes the given output with a visible overlay describing the
+// given compile error injected before the end of its body.
+func writeOverlay(out *bytes.Buffer, compileErr error) error {
+	var overlay = struct {
+		File, Message, Excerpt string
+		Line                   int
+	}{Message: compileErr.Error()}
+	if diags := diag.Errors(compileErr); len(diags) == 1 && diags[0].File != "" {
+		overlay.File, overlay.Line = diags[0].File, diags[0].Range.Start.Line
+		overlay.Message = diags[0].Message
+		if src, err := ioutil.ReadFile(overlay.File); err == nil {
+			overlay.Excerpt = excerpt(string(src), overlay.Line)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := overlayTemplate.Execute(&buf, overlay); err != nil {
+		return err
+	}
+	var page = out.String()
+	var i = strings.LastIndex(strings.ToLower(page), "</body>")
+	if i == -1 {
+		i = len(page)
+	}
+	out.Reset()
+	out.WriteString(page[:i])
+	out.Write(buf.Bytes())
+	out.WriteString(page[i:])
+	return nil
+}
+
+// excerpt returns the lines of src surrounding the given line, numbered, and
+// with the given line marked.
+func excerpt(src string, line int) string {
+	var lines = strings.Split(src, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	var buf bytes.Buffer
+	for num := line - excerptLines; num <= line+excerptLines; num++ {
+		if num < 1 || num > len(lines) {
+			continue
+		}
+		var marker = "  "
+		if num == line {
+			marker = "> "
+		}
+		fmt.Fprintf(&buf, "%s%4d  %s\n", marker, num, lines[num-1])
+	}
+	return buf.String()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompileErrorOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "soyweb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var filename = filepath.Join(dir, "test.soy")
+
+	var write = func(src string) {
+		if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("{namespace soyweb}\n{template .soyweb}\n<html><body>Hello</body></html>\n{/template}\n")
+	good, err := compile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("{namespace soyweb}\n{template .soyweb}\n<html><body>Hello {if}</body></html>\n{/template}\n")
+	tofu, compileErr := compile(filename)
+	if compileErr == nil {
+		t.Fatal("expected a compile error")
+	}
+	if tofu != good {
+		t.Fatal("expected the last good templates to be served")
+	}
+
+	var buf bytes.Buffer
+	if err = tofu.Render(&buf, "soyweb.soyweb", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = writeOverlay(&buf, compileErr); err != nil {
+		t.Fatal(err)
+	}
+	var page = buf.String()
+	for _, expected := range []string{
+		`<html><body>Hello` + "\n" + `<div id="soyweb-error"`,
+		"Compile error in " + filename + ":3",
+		"&gt;    3  &lt;html&gt;&lt;body&gt;Hello {if}&lt;/body&gt;&lt;/html&gt;",
+		"</div>\n</body></html>",
+	} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("expected the page to contain %q, got:\n%s", expected, page)
+		}
+	}
+
+	write("{namespace soyweb}\n{template .soyweb}\nFixed\n{/template}\n")
+	if tofu, err = compile(filename); err != nil || tofu == good {
+		t.Errorf("expected the fixed templates to be served, got error %v", err)
+	}
+}
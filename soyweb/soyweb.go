@@ -3,46 +3,129 @@ Package soyweb is a simple development server that serves the given template.
 
 Invoke it like so:
 
-  go get github.com/harrisonzhao/soy/soyweb
-  soyweb test.soy
+	go get github.com/harrisonzhao/soy/soyweb
+	soyweb test.soy
 
 It will attempt to execute the "soyweb.soyweb" template found in the given file.
 
 Parameters may be provided to the template in the URL query string.
 
+The file is compiled for each request, so changes are picked up on reload.  If
+it fails to compile, the page is rendered from the last version that compiled,
+with an overlay showing the error and the source surrounding it.
+
+A catalog of the templates in the file is served at /docs.  It includes example
+renders of the templates given data in a JSON file of fixtures, which maps
+template names to their data.  Each fixture is checked against the schema of
+its template's params:
+
+	soyweb -fixtures fixtures.json test.soy
+
+To write the catalog to disk instead of serving it:
+
+	soyweb -docs catalog.html -fixtures fixtures.json test.soy
 */
 package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
 
 	"github.com/harrisonzhao/soy"
 	"github.com/harrisonzhao/soy/data"
+	"github.com/harrisonzhao/soy/soydoc"
+	"github.com/harrisonzhao/soy/soyhtml"
 )
 
-var port = flag.Int("port", 9812, "port on which to listen")
+var (
+	port     = flag.Int("port", 9812, "port on which to listen")
+	docs     = flag.String("docs", "", "write the catalog of templates to this file and exit")
+	fixtures = flag.String("fixtures", "", "JSON file of example data for the catalog, by template name")
+)
 
 func main() {
+	flag.Parse()
+	if *docs != "" {
+		var buf bytes.Buffer
+		if err := writeDocs(&buf); err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(*docs, buf.Bytes(), 0644); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	http.HandleFunc("/docs", docsHandler)
+	http.HandleFunc("/", handler)
 	fmt.Print("Listening on :", *port, "...")
-	log.Fatal(http.ListenAndServe(
-		fmt.Sprintf(":%d", *port),
-		http.HandlerFunc(handler)))
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *port), nil))
 }
 
-func handler(res http.ResponseWriter, req *http.Request) {
-	var tofu, err = soy.NewBundle().
-		AddTemplateFile(os.Args[1]).
-		CompileToTofu()
-	if err != nil {
+func docsHandler(res http.ResponseWriter, req *http.Request) {
+	var buf bytes.Buffer
+	if err := writeDocs(&buf); err != nil {
 		http.Error(res, err.Error(), 500)
 		return
 	}
+	io.Copy(res, &buf)
+}
+
+// writeDocs writes the catalog of the templates in the file to w.
+func writeDocs(w io.Writer) error {
+	var bundle = soy.NewBundle().AddTemplateFile(flag.Arg(0))
+	var registry, err = bundle.Compile()
+	if err != nil {
+		return err
+	}
+	var opts = soydoc.Options{Title: flag.Arg(0), Tofu: soyhtml.NewTofu(registry)}
+	if *fixtures != "" {
+		if opts.Fixtures, err = readFixtures(*fixtures); err != nil {
+			return err
+		}
+	}
+	for name, fixture := range opts.Fixtures {
+		var t, ok = registry.Template(name)
+		if !ok {
+			return fmt.Errorf("%s: fixture for unknown template %s", *fixtures, name)
+		}
+		if err = t.Schema().Validate(fixture); err != nil {
+			return fmt.Errorf("%s: fixture for %s: %v", *fixtures, name, err)
+		}
+	}
+	return soydoc.Write(w, registry, opts)
+}
+
+// readFixtures reads the example data in the given JSON file, which maps
+// template names to their data.
+func readFixtures(filename string) (map[string]data.Map, error) {
+	var content, err = ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]map[string]interface{}
+	if err = json.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	var result = make(map[string]data.Map)
+	for name, fixture := range raw {
+		result[name] = data.New(fixture).(data.Map)
+	}
+	return result, nil
+}
+
+func handler(res http.ResponseWriter, req *http.Request) {
+	var tofu, compileErr = compile(flag.Arg(0))
+	if tofu == nil {
+		http.Error(res, compileErr.Error(), 500)
+		return
+	}
 
 	var m = make(data.Map)
 	for k, v := range req.URL.Query() {
@@ -50,11 +133,21 @@ func handler(res http.ResponseWriter, req *http.Request) {
 	}
 
 	var buf bytes.Buffer
-	err = tofu.Render(&buf, "soyweb.soyweb", m)
+	var err = tofu.Render(&buf, "soyweb.soyweb", m)
 	if err != nil {
-		http.Error(res, err.Error(), 500)
+		var msg = err.Error()
+		if renderErr, ok := err.(*soyhtml.RenderError); ok {
+			msg += "\n\n" + renderErr.StackTrace() + "\n" + renderErr.SourceExcerpt()
+		}
+		http.Error(res, msg, 500)
 		return
 	}
 
+	if compileErr != nil {
+		if err = writeOverlay(&buf, compileErr); err != nil {
+			http.Error(res, err.Error(), 500)
+			return
+		}
+	}
 	io.Copy(res, &buf)
 }
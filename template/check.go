@@ -0,0 +1,85 @@
+package template
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/harrisonzhao/soy/data"
+)
+
+// ParamCheck reports the ways in which some data does not match the params
+// declared by a template.
+//
+// SoyDoc params are untyped, so the values themselves are not checked.
+type ParamCheck struct {
+	Missing []string // required params that are not provided, in declaration order
+	Extra   []string // provided keys that are not declared as params, sorted
+}
+
+// OK returns true if the data matched the template's params.
+func (c ParamCheck) OK() bool {
+	return len(c.Missing) == 0 && len(c.Extra) == 0
+}
+
+func (c ParamCheck) String() string {
+	var problems []string
+	if len(c.Missing) > 0 {
+		problems = append(problems, "missing required params: "+strings.Join(c.Missing, ", "))
+	}
+	if len(c.Extra) > 0 {
+		problems = append(problems, "undeclared params: "+strings.Join(c.Extra, ", "))
+	}
+	return strings.Join(problems, "; ")
+}
+
+// CheckData validates the given data against the template's declared params,
+// so that callers outside of soy (e.g. handler tests) can verify that they
+// provide the right data without rendering.  Keys holding Undefined are
+// considered to be missing.
+func (t Template) CheckData(m data.Map) ParamCheck {
+	var keys []string
+	for key, val := range m {
+		if _, undefined := val.(data.Undefined); !undefined {
+			keys = append(keys, key)
+		}
+	}
+	return t.checkKeys(keys)
+}
+
+// CheckStruct validates the fields of the given struct type against the
+// template's declared params, as if values of the type were converted to a
+// data.Map using the given options.
+func (t Template) CheckStruct(typ reflect.Type, opts data.StructOptions) ParamCheck {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	var keys []string
+	for i := 0; i < typ.NumField(); i++ {
+		var field = typ.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+		keys = append(keys, opts.Key(field.Name))
+	}
+	return t.checkKeys(keys)
+}
+
+func (t Template) checkKeys(keys []string) ParamCheck {
+	var provided = make(map[string]bool)
+	for _, key := range keys {
+		provided[key] = true
+	}
+	var result ParamCheck
+	for _, param := range t.Doc.Params {
+		if !param.Optional && !provided[param.Name] {
+			result.Missing = append(result.Missing, param.Name)
+		}
+		delete(provided, param.Name)
+	}
+	for key := range provided {
+		result.Extra = append(result.Extra, key)
+	}
+	sort.Strings(result.Extra)
+	return result
+}
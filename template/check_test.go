@@ -0,0 +1,57 @@
+package template
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/harrisonzhao/soy/data"
+)
+
+func TestCheckParams(t *testing.T) {
+	var reg = mustRegistry(t, `{namespace test}
+
+/**
+ * @param name
+ * @param count
+ * @param? title
+ */
+{template .t}{$name}{$count}{$title}{/template}
+`)
+	var tmpl, _ = reg.Template("test.t")
+
+	var mapTests = []struct {
+		data     data.Map
+		expected ParamCheck
+	}{
+		{data.Map{"name": data.String("a"), "count": data.Int(1)}, ParamCheck{}},
+		{data.Map{"name": data.String("a"), "count": data.Int(1), "title": data.Null{}}, ParamCheck{}},
+		{data.Map{"name": data.String("a"), "count": data.Undefined{}},
+			ParamCheck{Missing: []string{"count"}}},
+		{data.Map{"nmae": data.String("a"), "count": data.Int(1), "titel": data.String("b")},
+			ParamCheck{Missing: []string{"name"}, Extra: []string{"nmae", "titel"}}},
+	}
+	for _, test := range mapTests {
+		var actual = tmpl.CheckData(test.data)
+		if !reflect.DeepEqual(test.expected, actual) {
+			t.Errorf("%v: expected %v, got %v", test.data, test.expected, actual)
+		}
+		if actual.OK() != (len(test.expected.Missing)+len(test.expected.Extra) == 0) {
+			t.Errorf("%v: unexpected OK() for %v", test.data, actual)
+		}
+	}
+
+	type page struct {
+		Name    string
+		Title   string
+		Extra   int
+		private bool
+	}
+	var expected = ParamCheck{Missing: []string{"count"}, Extra: []string{"extra"}}
+	var actual = tmpl.CheckStruct(reflect.TypeOf(&page{}), data.DefaultStructOptions)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if actual.String() != "missing required params: count; undeclared params: extra" {
+		t.Errorf("unexpected description: %v", actual)
+	}
+}
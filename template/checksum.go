@@ -0,0 +1,84 @@
+package template
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"unicode"
+
+	"github.com/harrisonzhao/soy/ast"
+)
+
+// checksum returns the checksum of the given template source, from its SoyDoc
+// through its closing tag.  Templates without source (e.g. built rather than
+// parsed) are summed by their soy representation instead.
+func checksum(src string, tn *ast.TemplateNode) string {
+	src = strings.TrimRightFunc(src, unicode.IsSpace)
+	if src == "" {
+		src = tn.String()
+	}
+	var sum = sha256.Sum256([]byte(src))
+	return hex.EncodeToString(sum[:])
+}
+
+// Checksum returns the checksum of the source of the template with the given
+// ID (see ast.TemplateNode.ID), from its SoyDoc through its closing tag, as a
+// hex-encoded SHA-256 hash.  It is computed when the template is added, so it
+// remains available after StripSource.
+func (r *Registry) Checksum(id string) (string, bool) {
+	defer r.guard()()
+	var sum, ok = r.checksumByID[id]
+	return sum, ok
+}
+
+// Drift describes a template of a serialized registry that does not match
+// its source.
+type Drift struct {
+	Template string // ID of the template (see ast.TemplateNode.ID)
+	Detail   string // human-readable description of the difference
+}
+
+func (d Drift) String() string {
+	return d.Template + ": " + d.Detail
+}
+
+// VerifyJSON verifies a registry serialized by MarshalJSON (e.g. alongside a
+// build's precompiled templates) against r, compiled from the source files, by
+// comparing the templates' checksums.  It reports the templates whose source
+// was modified, and those present in only one of the two, ordered by the
+// templates' order in r followed by the serialized registry's order.  It
+// allows production to detect template artifacts that have drifted from
+// source control.
+func (r *Registry) VerifyJSON(serialized []byte) ([]Drift, error) {
+	var artifact jsonRegistry
+	if err := json.Unmarshal(serialized, &artifact); err != nil {
+		return nil, err
+	}
+	if err := r.LoadAll(); err != nil {
+		return nil, err
+	}
+
+	var sums = make(map[string]string) // checksum of each serialized template, by ID
+	for _, t := range artifact.Templates {
+		sums[t.ID] = t.Checksum
+	}
+	var drift []Drift
+	for _, t := range r.Templates {
+		var id = t.Node.ID()
+		var sum, ok = sums[id]
+		switch {
+		case !ok:
+			drift = append(drift, Drift{id, "missing from the serialized registry"})
+		case sum != r.checksumByID[id]:
+			drift = append(drift, Drift{id, "modified since it was serialized"})
+		}
+		delete(sums, id)
+	}
+	for _, t := range artifact.Templates {
+		if _, ok := sums[t.ID]; ok {
+			drift = append(drift, Drift{t.ID, "not found in the source"})
+		}
+	}
+	return drift, nil
+}
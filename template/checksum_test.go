@@ -0,0 +1,64 @@
+package template
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/harrisonzhao/soy/parse"
+)
+
+func TestVerifyJSON(t *testing.T) {
+	var compile = func(src string) *Registry {
+		var tree, err = parse.SoyFile("test.soy", src, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var reg Registry
+		if err = reg.Add(tree); err != nil {
+			t.Fatal(err)
+		}
+		return &reg
+	}
+
+	var built = compile(`{namespace test}
+{template .same}same{/template}
+{template .changed}old{/template}
+{template .removed}gone{/template}
+`)
+	built.StripSource()
+	artifact, err := json.Marshal(built)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var source = compile(`{namespace test}
+
+{template .same}same{/template}
+{template .changed}new{/template}
+{template .added}added{/template}
+`)
+	if sum, ok := source.Checksum("test.same"); !ok || sum != built.checksumByID["test.same"] {
+		t.Errorf("expected checksum of unchanged template to match: %v, %v", sum, ok)
+	}
+	drift, err := source.VerifyJSON(artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = []Drift{
+		{"test.changed", "modified since it was serialized"},
+		{"test.added", "missing from the serialized registry"},
+		{"test.removed", "not found in the source"},
+	}
+	if !reflect.DeepEqual(drift, expected) {
+		t.Errorf("expected %v, got %v", expected, drift)
+	}
+
+	drift, err = built.VerifyJSON(artifact)
+	if err != nil || len(drift) != 0 {
+		t.Errorf("expected no drift, got %v, %v", drift, err)
+	}
+	if _, err = source.VerifyJSON([]byte("{")); err == nil {
+		t.Error("expected an error for malformed JSON")
+	}
+}
@@ -0,0 +1,164 @@
+package template
+
+import (
+	"fmt"
+
+	"github.com/harrisonzhao/soy/ast"
+)
+
+// ChangeType identifies a kind of change to a template's API.
+type ChangeType int
+
+const (
+	TemplateAdded     ChangeType = iota // a template was added
+	TemplateRemoved                     // a template was removed
+	ParamAdded                          // a param was added to a template
+	ParamRemoved                        // a param was removed from a template
+	ParamOptionality                    // a param became optional or required
+	AutoescapeChanged                   // a template's autoescape mode changed
+)
+
+// Change describes a difference between two versions of a template registry.
+type Change struct {
+	Type     ChangeType
+	Template string // ID of the changed template (see ast.TemplateNode.ID)
+	Param    string // name of the changed param, if applicable
+	Detail   string // human-readable description of the change
+	Breaking bool   // true if existing callers or outputs may be broken
+}
+
+func (c Change) String() string {
+	if c.Breaking {
+		return c.Template + ": " + c.Detail + " (breaking)"
+	}
+	return c.Template + ": " + c.Detail
+}
+
+var autoescapeNames = map[ast.AutoescapeType]string{
+	ast.AutoescapeOn:         "true",
+	ast.AutoescapeOff:        "false",
+	ast.AutoescapeContextual: "contextual",
+	ast.AutoescapeStrict:     "strict",
+}
+
+// Diff compares the templates of two registries and reports the changes from
+// old to new: templates added and removed, params added, removed, or changed
+// in optionality, and changes in autoescape mode.  Changes that may break
+// existing callers or outputs are marked as Breaking, so that deploy pipelines
+// can gate on them.
+//
+// Changes are ordered by the templates' order in new, followed by the removed
+// templates in their order in old.
+func Diff(old, new *Registry) []Change {
+	old.loadAll()
+	new.loadAll()
+	var changes []Change
+	for _, t := range new.Templates {
+		var prev, ok = findTemplate(old, t.Node.ID())
+		if !ok {
+			changes = append(changes, Change{TemplateAdded, t.Node.ID(), "", "template added", false})
+			continue
+		}
+		changes = append(changes, diffParams(prev, t)...)
+		if before, after := autoescapeMode(prev), autoescapeMode(t); before != after {
+			changes = append(changes, Change{AutoescapeChanged, t.Node.ID(), "",
+				fmt.Sprintf("autoescape changed from %s to %s", autoescapeNames[before], autoescapeNames[after]),
+				true})
+		}
+	}
+	for _, t := range old.Templates {
+		if _, ok := findTemplate(new, t.Node.ID()); !ok {
+			changes = append(changes, Change{TemplateRemoved, t.Node.ID(), "", "template removed", true})
+		}
+	}
+	return changes
+}
+
+// diffParams reports the changes to the params declared by a template.
+func diffParams(old, new Template) []Change {
+	var name = new.Node.ID()
+	var changes []Change
+	for _, param := range new.Doc.Params {
+		var prev = findParam(old.Doc.Params, param.Name)
+		switch {
+		case prev == nil:
+			var detail = "required param " + param.Name + " added"
+			if param.Optional {
+				detail = "optional param " + param.Name + " added"
+			}
+			changes = append(changes, Change{ParamAdded, name, param.Name, detail, !param.Optional})
+		case prev.Optional && !param.Optional:
+			changes = append(changes, Change{ParamOptionality, name, param.Name,
+				"param " + param.Name + " changed from optional to required", true})
+		case !prev.Optional && param.Optional:
+			changes = append(changes, Change{ParamOptionality, name, param.Name,
+				"param " + param.Name + " changed from required to optional", false})
+		}
+	}
+	for _, param := range old.Doc.Params {
+		if findParam(new.Doc.Params, param.Name) == nil {
+			changes = append(changes, Change{ParamRemoved, name, param.Name,
+				"param " + param.Name + " removed", true})
+		}
+	}
+	return changes
+}
+
+// CheckSubstitute returns an error if the template sub may not be rendered in
+// place of orig, i.e. if its output is of a different kind (see
+// Template.Kind), or if sub requires a param that callers of orig need not
+// provide.  Params of orig that sub does not declare are ignored by it.
+func CheckSubstitute(orig, sub Template) error {
+	if sub.Kind() != orig.Kind() {
+		return fmt.Errorf("%s outputs %s, but %s outputs %s",
+			sub.Node.Name, sub.Kind(), orig.Node.Name, orig.Kind())
+	}
+	for _, param := range sub.Doc.Params {
+		if param.Optional {
+			continue
+		}
+		var prev = findParam(orig.Doc.Params, param.Name)
+		switch {
+		case prev == nil:
+			return fmt.Errorf("%s requires param %s, which %s does not declare",
+				sub.Node.Name, param.Name, orig.Node.Name)
+		case prev.Optional:
+			return fmt.Errorf("%s requires param %s, which is optional in %s",
+				sub.Node.Name, param.Name, orig.Node.Name)
+		}
+	}
+	return nil
+}
+
+// findTemplate returns the template with the given ID (see
+// ast.TemplateNode.ID), which distinguishes delegate templates that share a
+// name.
+func findTemplate(reg *Registry, id string) (Template, bool) {
+	for _, t := range reg.Templates {
+		if t.Node.ID() == id {
+			return t, true
+		}
+	}
+	return Template{}, false
+}
+
+func findParam(params []*ast.SoyDocParamNode, name string) *ast.SoyDocParamNode {
+	for _, param := range params {
+		if param.Name == name {
+			return param
+		}
+	}
+	return nil
+}
+
+// autoescapeMode returns the effective autoescape mode of the template.
+func autoescapeMode(t Template) ast.AutoescapeType {
+	var mode = t.Node.Autoescape
+	if mode == ast.AutoescapeUnspecified {
+		mode = t.Namespace.Autoescape
+	}
+	if mode == ast.AutoescapeUnspecified {
+		mode = ast.AutoescapeOn
+	}
+	return mode
+}
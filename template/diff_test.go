@@ -0,0 +1,76 @@
+package template
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/harrisonzhao/soy/parse"
+)
+
+func TestDiff(t *testing.T) {
+	var old = mustRegistry(t, `{namespace test}
+
+/** @param a */
+{template .unchanged}{$a}{/template}
+
+/**
+ * @param a
+ * @param? b
+ * @param c
+ * @param removed
+ */
+{template .params}{$a}{$b}{$c}{$removed}{/template}
+
+{template .escaping}{/template}
+
+{template .removed}{/template}
+`)
+	var new = mustRegistry(t, `{namespace test}
+
+/** @param a */
+{template .unchanged}{$a}{/template}
+
+/**
+ * @param a
+ * @param b
+ * @param? c
+ * @param required
+ * @param? optional
+ */
+{template .params}{$a}{$b}{$c}{$required}{$optional}{/template}
+
+{template .escaping autoescape="contextual"}{/template}
+
+{template .added}{/template}
+`)
+
+	var expected = []Change{
+		{ParamOptionality, "test.params", "b", "param b changed from optional to required", true},
+		{ParamOptionality, "test.params", "c", "param c changed from required to optional", false},
+		{ParamAdded, "test.params", "required", "required param required added", true},
+		{ParamAdded, "test.params", "optional", "optional param optional added", false},
+		{ParamRemoved, "test.params", "removed", "param removed removed", true},
+		{AutoescapeChanged, "test.escaping", "", "autoescape changed from true to contextual", true},
+		{TemplateAdded, "test.added", "", "template added", false},
+		{TemplateRemoved, "test.removed", "", "template removed", true},
+	}
+	var actual = Diff(old, new)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, actual)
+	}
+	if changes := Diff(old, old); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+}
+
+func mustRegistry(t *testing.T, src string) *Registry {
+	var tree, err = parse.SoyFile("", src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reg Registry
+	if err = reg.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+	return &reg
+}
@@ -0,0 +1,139 @@
+package template
+
+import (
+	"encoding/json"
+
+	"github.com/harrisonzhao/soy/ast"
+)
+
+// MarshalJSON describes the registry's templates in JSON, for documentation
+// generators, dashboards, and other tools, which need not be written in Go.
+// The schema is:
+//
+//	{"templates": [{
+//	  "name":        fully-qualified name of the template
+//	  "id":          unique ID of the template (see ast.TemplateNode.ID)
+//	  "namespace":   namespace declaring the template
+//	  "file":        name of the file declaring the template
+//	  "layer":       overlay layer that provided the template, omitted if none
+//	  "line":        line number of the template tag within the file
+//	  "checksum":    checksum of the template's source (see Checksum)
+//	  "delegate":    true if declared by {deltemplate}
+//	  "variant":     variant of a delegate template, omitted if none
+//	  "priority":    priority of a delegate template, omitted if zero
+//	  "package":     delegate package of a delegate template, omitted if none
+//	  "private":     true if declared private="true"
+//	  "autoescape":  effective autoescape mode: "true", "false", "contextual",
+//	                 or "strict"
+//	  "doc":         description from the template's SoyDoc, omitted if none
+//	  "deprecated":  deprecation note, omitted if not @deprecated (may be "")
+//	  "params":      [{"name": ..., "optional": bool, "doc": ...}]
+//	  "calls":       [{"template": ..., "delegate": bool, "line": ...}]
+//	}, ...]}
+//
+// Templates are listed in the order they were added.  Calls are listed in the
+// order they appear, naming the called template as written in the {call} or
+// {delcall}; delegate calls may render any variant.  Soy params are untyped,
+// so params carry no type.  Docs are omitted once the source is stripped.
+func (r *Registry) MarshalJSON() ([]byte, error) {
+	if err := r.LoadAll(); err != nil {
+		return nil, err
+	}
+	var result = jsonRegistry{Templates: []jsonTemplate{}}
+	for _, t := range r.Templates {
+		result.Templates = append(result.Templates, r.jsonTemplate(t))
+	}
+	return json.Marshal(result)
+}
+
+type jsonRegistry struct {
+	Templates []jsonTemplate `json:"templates"`
+}
+
+type jsonTemplate struct {
+	Name       string      `json:"name"`
+	ID         string      `json:"id"`
+	Namespace  string      `json:"namespace"`
+	File       string      `json:"file"`
+	Layer      string      `json:"layer,omitempty"`
+	Line       int         `json:"line"`
+	Checksum   string      `json:"checksum"`
+	Delegate   bool        `json:"delegate"`
+	Variant    string      `json:"variant,omitempty"`
+	Priority   int         `json:"priority,omitempty"`
+	Package    string      `json:"package,omitempty"`
+	Private    bool        `json:"private"`
+	Autoescape string      `json:"autoescape"`
+	Doc        string      `json:"doc,omitempty"`
+	Deprecated *string     `json:"deprecated,omitempty"`
+	Params     []jsonParam `json:"params"`
+	Calls      []jsonCall  `json:"calls"`
+}
+
+type jsonParam struct {
+	Name     string `json:"name"`
+	Optional bool   `json:"optional"`
+	Doc      string `json:"doc,omitempty"`
+}
+
+type jsonCall struct {
+	Template string `json:"template"`
+	Delegate bool   `json:"delegate"`
+	Line     int    `json:"line"`
+}
+
+func (r *Registry) jsonTemplate(t Template) jsonTemplate {
+	var id = t.Node.ID()
+	var result = jsonTemplate{
+		Name:       t.Node.Name,
+		ID:         id,
+		Namespace:  t.Namespace.Name,
+		File:       r.fileName(t.Node),
+		Layer:      r.Layer(id),
+		Line:       r.LineNumber(id, t.Node),
+		Checksum:   r.checksumByID[id],
+		Delegate:   t.Node.Delegate,
+		Variant:    t.Node.Variant,
+		Priority:   t.Node.Priority,
+		Package:    t.Node.Package,
+		Private:    t.Node.Private,
+		Autoescape: autoescapeNames[autoescapeMode(t)],
+		Doc:        t.Doc.Desc,
+		Params:     []jsonParam{},
+		Calls:      []jsonCall{},
+	}
+	if t.Doc.Deprecated {
+		var note = t.Doc.DeprecationNote
+		result.Deprecated = &note
+	}
+	for _, param := range t.Doc.Params {
+		result.Params = append(result.Params, jsonParam{param.Name, param.Optional, param.Desc})
+	}
+	ast.Walk(t.Node, func(node ast.Node) bool {
+		if call, ok := node.(*ast.CallNode); ok {
+			result.Calls = append(result.Calls,
+				jsonCall{call.Name, call.Delegate, r.LineNumber(id, call)})
+		}
+		return true
+	})
+	return result
+}
+
+// FileName returns the name of the soy file declaring the given template, or
+// "" if it is not found.
+func (r *Registry) FileName(tn *ast.TemplateNode) string {
+	defer r.guard()()
+	return r.fileName(tn)
+}
+
+// fileName returns the name of the file declaring the given template.
+func (r *Registry) fileName(tn *ast.TemplateNode) string {
+	for _, file := range r.SoyFiles {
+		for _, node := range file.Body {
+			if node == tn {
+				return file.Name
+			}
+		}
+	}
+	return ""
+}
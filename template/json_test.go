@@ -0,0 +1,55 @@
+package template
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/harrisonzhao/soy/parse"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	var tree, err = parse.SoyFile("greet.soy", `{namespace greet autoescape="contextual"}
+
+/**
+ * Greets the user.
+ * @deprecated Use .welcome.
+ * @param name The user's name.
+ * @param? title
+ */
+{template .hello private="true"}
+  {call .name data="all" /}
+  {delcall greet.extra allowemptydefault="true" /}
+{/template}
+
+{template .name}{$name}{/template}
+
+{deltemplate greet.extra variant="'x'"}!{/deltemplate}
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reg Registry
+	if err = reg.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := json.Marshal(&reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = `{"templates":[` +
+		`{"name":"greet.hello","id":"greet.hello","namespace":"greet","file":"greet.soy","line":9,` +
+		`"checksum":"3853a90b1db407b747fbcd16b59348b92d0675b78bb49f2ab8ce67d83122e2c1",` +
+		`"delegate":false,"private":true,"autoescape":"contextual","doc":"Greets the user.",` +
+		`"deprecated":"Use .welcome.","params":[{"name":"name","optional":false,"doc":"The user's name."},` +
+		`{"name":"title","optional":true}],"calls":[{"template":"greet.name","delegate":false,"line":10},` +
+		`{"template":"greet.extra","delegate":true,"line":11}]},` +
+		`{"name":"greet.name","id":"greet.name","namespace":"greet","file":"greet.soy","line":14,` +
+		`"checksum":"b2511bacc25aff60a737906c97a2a148b5f6c1b88ac8e3023f038029c7e7065f",` +
+		`"delegate":false,"private":false,"autoescape":"contextual","params":[],"calls":[]},` +
+		`{"name":"greet.extra","id":"greet.extra:x:0","namespace":"greet","file":"greet.soy","line":16,` +
+		`"checksum":"3ef55f74574ea0e8ec826f82315b78b3938d0d0f145acfde7353816f8bb3c194",` +
+		`"delegate":true,"variant":"x","private":false,"autoescape":"contextual","params":[],"calls":[]}]}`
+	if string(actual) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
@@ -0,0 +1,178 @@
+package template
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/harrisonzhao/soy/ast"
+)
+
+// lazyFiles are the soy files of a registry whose parsing is deferred until
+// their templates are first used.
+type lazyFiles struct {
+	mu          sync.Mutex
+	pending     int64 // number of files not yet loaded, accessed atomically
+	files       []*lazyFile
+	byNamespace map[string][]*lazyFile
+	byDelegate  map[string][]*lazyFile // by the names of the delegates declared
+}
+
+// lazyFile is a soy file whose parsing is deferred.
+type lazyFile struct {
+	parse  func() (*ast.SoyFileNode, error)
+	loaded bool
+	err    error // the error that loading the file failed with, if any
+}
+
+var (
+	lazyNamespace = regexp.MustCompile(`\{namespace\s+([\w.]+)`)
+	lazyDelegate  = regexp.MustCompile(`\{deltemplate\s+([\w.]+)`)
+)
+
+// AddLazy adds a soy file with the given source, to be parsed by the
+// given function when one of its templates is first looked up, instead of now.
+// This trades the latency of the first render of each namespace for the time
+// and memory taken to parse every file at startup, for very large bundles.
+//
+// The source is only scanned for the names of the namespace and delegate
+// templates that it declares.  Errors in the file are not reported until it is
+// loaded; use LoadAll to find them up front (e.g. in tests).  Lookups by
+// Template, DelTemplate, and ActiveDelTemplate are safe for concurrent use while files remain to
+// be loaded, but Templates and SoyFiles hold only the files loaded so far,
+// and may not be read while others are loaded (e.g. by Prefetch): call
+// LoadAll first.  The methods of the registry that list its templates, such
+// as MarshalJSON and Stats, load every file first themselves.
+func (r *Registry) AddLazy(text string, parse func() (*ast.SoyFileNode, error)) {
+	if r.lazy == nil {
+		r.lazy = &lazyFiles{
+			byNamespace: make(map[string][]*lazyFile),
+			byDelegate:  make(map[string][]*lazyFile),
+		}
+	}
+	var file = &lazyFile{parse: parse}
+	r.lazy.files = append(r.lazy.files, file)
+	atomic.AddInt64(&r.lazy.pending, 1)
+
+	var namespace string
+	if m := lazyNamespace.FindStringSubmatch(text); m != nil {
+		namespace = m[1]
+	}
+	r.lazy.byNamespace[namespace] = append(r.lazy.byNamespace[namespace], file)
+	var seen = make(map[string]bool)
+	for _, m := range lazyDelegate.FindAllStringSubmatch(text, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			r.lazy.byDelegate[m[1]] = append(r.lazy.byDelegate[m[1]], file)
+		}
+	}
+}
+
+// LoadAll parses the files added by AddLazy that have not been loaded yet,
+// returning the first error encountered by any of them.
+func (r *Registry) LoadAll() error {
+	if r.lazy == nil {
+		return nil
+	}
+	r.lazy.mu.Lock()
+	defer r.lazy.mu.Unlock()
+	var first error
+	for _, file := range r.lazy.files {
+		if err := r.load(file); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// loadAll loads the files added by AddLazy that have not been loaded yet,
+// logging any errors, so that Templates is complete and no longer modified.
+func (r *Registry) loadAll() {
+	if r.lazy == nil {
+		return
+	}
+	r.lazy.mu.Lock()
+	defer r.lazy.mu.Unlock()
+	r.loadLogged(r.lazy.files)
+}
+
+// Prefetch loads the files added by AddLazy in the background, one at a time,
+// so that they are ready before their first use.  Lookups wait for at most the
+// file being loaded.  Errors are logged.
+func (r *Registry) Prefetch() {
+	if r.lazy == nil {
+		return
+	}
+	go func() {
+		for _, file := range r.lazy.files {
+			r.lazy.mu.Lock()
+			if err := r.load(file); err != nil {
+				log.Println(err)
+			}
+			r.lazy.mu.Unlock()
+		}
+	}()
+}
+
+// guard locks the registry against concurrent loading of lazy files, if any
+// remain to be loaded, and returns the function that unlocks it.  Once every
+// file is loaded, the registry is no longer modified, and no lock is needed.
+func (r *Registry) guard() func() {
+	if r.lazy == nil || atomic.LoadInt64(&r.lazy.pending) == 0 {
+		return func() {}
+	}
+	r.lazy.mu.Lock()
+	return r.lazy.mu.Unlock
+}
+
+// loadNamespace loads the lazy files declaring the namespace of the given
+// fully-qualified template name.  The registry must be guarded.
+func (r *Registry) loadNamespace(templateName string) {
+	if r.lazy == nil {
+		return
+	}
+	var namespace string
+	if i := strings.LastIndex(templateName, "."); i != -1 {
+		namespace = templateName[:i]
+	}
+	r.loadLogged(r.lazy.byNamespace[namespace])
+}
+
+// loadDelegate loads the lazy files declaring delegates of the given name.
+// The registry must be guarded.
+func (r *Registry) loadDelegate(name string) {
+	if r.lazy == nil {
+		return
+	}
+	r.loadLogged(r.lazy.byDelegate[name])
+}
+
+// loadLogged loads the given files, logging any errors, since the lookups
+// that load them can only report that the template was not found.
+func (r *Registry) loadLogged(files []*lazyFile) {
+	for _, file := range files {
+		if file.loaded {
+			continue
+		}
+		if err := r.load(file); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// load parses the given file and adds it to the registry, if it has not
+// already been loaded.  The lazy files must be locked.
+func (r *Registry) load(file *lazyFile) error {
+	if file.loaded {
+		return file.err
+	}
+	var tree, err = file.parse()
+	if err == nil {
+		err = r.Add(tree)
+	}
+	file.loaded, file.err = true, err
+	atomic.AddInt64(&r.lazy.pending, -1)
+	return err
+}
@@ -0,0 +1,109 @@
+package template
+
+import "github.com/harrisonzhao/soy/data"
+
+// OpenAPIVersion is the version of the OpenAPI Specification used by OpenAPI.
+const OpenAPIVersion = "3.0.3"
+
+// OpenAPI is an OpenAPI document describing the rendering of a registry's
+// templates as an API.  It marshals to JSON as the document itself.
+type OpenAPI struct {
+	Version string             `json:"openapi"` // OpenAPIVersion
+	Info    APIInfo            `json:"info"`
+	Paths   map[string]APIPath `json:"paths"` // by "/" and the fully-qualified template name
+}
+
+// APIInfo describes the API of an OpenAPI document.
+type APIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// APIPath is the path of an OpenAPI document to which a template's data is
+// posted to render it.
+type APIPath struct {
+	Post *APIOperation `json:"post"`
+}
+
+// APIOperation is the render of a template, given its data as the body of
+// the request, in response to which its output is returned.
+type APIOperation struct {
+	OperationID string                 `json:"operationId"` // fully-qualified name of the template
+	Description string                 `json:"description,omitempty"`
+	Deprecated  bool                   `json:"deprecated,omitempty"`
+	RequestBody APIBody                `json:"requestBody"`
+	Responses   map[string]APIResponse `json:"responses"`
+	Kind        data.ContentKind       `json:"x-soy-kind"` // kind of the template's output
+}
+
+// APIBody is the body of a request: the template's data as JSON.
+type APIBody struct {
+	Required bool                    `json:"required,omitempty"` // the template has required params
+	Content  map[string]APIMediaType `json:"content"`
+}
+
+// APIResponse is a response of an operation.
+type APIResponse struct {
+	Description string                  `json:"description"`
+	Content     map[string]APIMediaType `json:"content,omitempty"`
+}
+
+// APIMediaType is the schema of a body of a given media type.
+type APIMediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// mediaTypes are the media types of the output of templates, by content kind.
+var mediaTypes = map[data.ContentKind]string{
+	data.KindText:               "text/plain",
+	data.KindHTML:               "text/html",
+	data.KindAttributes:         "text/html",
+	data.KindURI:                "text/uri-list",
+	data.KindTrustedResourceURI: "text/uri-list",
+	data.KindJS:                 "application/javascript",
+	data.KindCSS:                "text/css",
+}
+
+// OpenAPI returns an OpenAPI document with the given title and version,
+// describing the rendering of the registry's entry points, i.e. its public
+// templates other than delegates (which are only rendered by {delcall}), so
+// that API gateways that proxy requests to render templates may validate
+// their payloads and document them.
+//
+// Each template is rendered by posting its data, as a JSON object matching
+// its schema (see Template.Schema), to its fully-qualified name, e.g.
+// "/ns.page", which gateways may prefix as they see fit.  The response is
+// the template's output, of its content kind (see Template.Kind).
+func (r *Registry) OpenAPI(title, version string) *OpenAPI {
+	r.loadAll()
+	var result = &OpenAPI{
+		Version: OpenAPIVersion,
+		Info:    APIInfo{title, version},
+		Paths:   make(map[string]APIPath),
+	}
+	for _, t := range r.Templates {
+		if t.Node.Private || t.Node.Delegate {
+			continue
+		}
+		var schema = t.Schema()
+		schema.Dialect = "" // OpenAPI 3.0 schemas are a dialect of their own
+		var kind = t.Kind()
+		result.Paths["/"+t.Node.Name] = APIPath{&APIOperation{
+			OperationID: t.Node.Name,
+			Description: t.Doc.Desc,
+			Deprecated:  t.Doc.Deprecated,
+			RequestBody: APIBody{
+				Required: len(schema.Required) > 0,
+				Content:  map[string]APIMediaType{"application/json": {schema}},
+			},
+			Responses: map[string]APIResponse{
+				"200": {
+					Description: "the output of the template",
+					Content:     map[string]APIMediaType{mediaTypes[kind]: {&Schema{Type: "string"}}},
+				},
+			},
+			Kind: kind,
+		}}
+	}
+	return result
+}
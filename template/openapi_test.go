@@ -0,0 +1,66 @@
+package template
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/harrisonzhao/soy/parse"
+)
+
+func TestOpenAPI(t *testing.T) {
+	var tree, err = parse.SoyFile("", `{namespace test}
+
+/**
+ * Greets the user.
+ * @param user
+ */
+{template .greet}
+  Hello {$user.name}
+{/template}
+
+/** @deprecated */
+{template .plain autoescape="false"}
+  Hello
+{/template}
+
+{template .style kind="css"}
+  a {lb}color: red{rb}
+{/template}
+
+{template .helper private="true"}
+{/template}
+
+{deltemplate test.del}
+{/deltemplate}
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reg Registry
+	if err = reg.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := json.Marshal(reg.OpenAPI("Templates", "1.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = `{"openapi":"3.0.3","info":{"title":"Templates","version":"1.0"},"paths":{` +
+		`"/test.greet":{"post":{"operationId":"test.greet","description":"Greets the user.",` +
+		`"requestBody":{"required":true,"content":{"application/json":{"schema":` +
+		`{"type":"object","description":"Greets the user.","properties":{` +
+		`"user":{"type":"object","properties":{"name":{}}}},"required":["user"]}}}},` +
+		`"responses":{"200":{"description":"the output of the template",` +
+		`"content":{"text/html":{"schema":{"type":"string"}}}}},"x-soy-kind":"html"}},` +
+		`"/test.plain":{"post":{"operationId":"test.plain","deprecated":true,` +
+		`"requestBody":{"content":{"application/json":{"schema":{"type":"object"}}}},` +
+		`"responses":{"200":{"description":"the output of the template",` +
+		`"content":{"text/plain":{"schema":{"type":"string"}}}}},"x-soy-kind":"text"}},` +
+		`"/test.style":{"post":{"operationId":"test.style",` +
+		`"requestBody":{"content":{"application/json":{"schema":{"type":"object"}}}},` +
+		`"responses":{"200":{"description":"the output of the template",` +
+		`"content":{"text/css":{"schema":{"type":"string"}}}}},"x-soy-kind":"css"}}}}`
+	if string(actual) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
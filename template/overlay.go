@@ -0,0 +1,76 @@
+package template
+
+import "github.com/harrisonzhao/soy/ast"
+
+// Overlay returns a registry of the templates of base, overridden by those of
+// over, a layer of templates with the given name.  A template of over
+// replaces the template of base with the same fully-qualified name (or, for
+// delegate templates, the same name, variant, and priority), and templates
+// new to over are added.  The result shares the parse trees of both
+// registries, which are not modified other than to load their pending files.
+//
+// This allows a bundle of overrides (e.g. per customer) to replace individual
+// templates of a shared base, without the base declaring delegates for them.
+// Overlays may be stacked by overlaying the result again; the last layer
+// wins.  The overridden templates' files remain in SoyFiles, so templates of
+// the layer may call templates of the base.  Files of either registry that
+// remain to be parsed (see AddLazy) are first loaded into that registry, which
+// modifies it, logging any errors; call LoadAll beforehand to handle them
+// instead.
+func Overlay(base *Registry, layer string, over *Registry) *Registry {
+	base.loadAll()
+	over.loadAll()
+	var result = &Registry{
+		SoyFiles:               append(append([]*ast.SoyFileNode(nil), base.SoyFiles...), over.SoyFiles...),
+		sourceByTemplateName:   make(map[string]string),
+		rangeByTemplateName:    make(map[string][2]int),
+		newlinesByTemplateName: make(map[string][]int),
+		layerByTemplateName:    make(map[string]string),
+		checksumByID:           make(map[string]string),
+	}
+	var index = make(map[string]int) // index of each template within result.Templates, by ID
+	for _, reg := range []*Registry{base, over} {
+		for _, t := range reg.Templates {
+			var id = t.Node.ID()
+			if i, ok := index[id]; ok {
+				result.Templates[i] = t
+			} else {
+				index[id] = len(result.Templates)
+				result.Templates = append(result.Templates, t)
+				result.indexDelegate(index[id])
+			}
+			result.copySource(reg, id)
+			if reg == over {
+				result.layerByTemplateName[id] = layer
+			} else {
+				result.layerByTemplateName[id] = base.layerByTemplateName[id]
+			}
+		}
+	}
+	return result
+}
+
+// copySource copies the source of the template with the given ID from reg.
+func (r *Registry) copySource(reg *Registry, id string) {
+	delete(r.sourceByTemplateName, id)
+	delete(r.rangeByTemplateName, id)
+	delete(r.newlinesByTemplateName, id)
+	delete(r.checksumByID, id)
+	if src, ok := reg.sourceByTemplateName[id]; ok {
+		r.sourceByTemplateName[id] = src
+		r.rangeByTemplateName[id] = reg.rangeByTemplateName[id]
+	}
+	if newlines, ok := reg.newlinesByTemplateName[id]; ok {
+		r.newlinesByTemplateName[id] = newlines
+	}
+	if sum, ok := reg.checksumByID[id]; ok {
+		r.checksumByID[id] = sum
+	}
+}
+
+// Layer returns the name of the layer that provided the template with the
+// given ID (see ast.TemplateNode.ID and Overlay), or "" if it was added to a
+// base registry directly.
+func (r *Registry) Layer(templateName string) string {
+	return r.layerByTemplateName[templateName]
+}
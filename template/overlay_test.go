@@ -0,0 +1,82 @@
+package template
+
+import "testing"
+
+func TestOverlay(t *testing.T) {
+	var base = mustRegistry(t, `{namespace shop}
+
+{template .header}
+  Shop
+{/template}
+
+{template .footer}
+  Thanks
+{/template}
+
+{deltemplate shop.badge}
+  default badge
+{/deltemplate}
+`)
+	var acme = mustRegistry(t, `{namespace shop}
+
+{template .header}
+  ACME Shop
+{/template}
+
+{template .promo}
+  Sale
+{/template}
+
+{deltemplate shop.badge}
+  acme badge
+{/deltemplate}
+`)
+	var overlay = Overlay(base, "acme", acme)
+
+	var tests = []struct {
+		name, text, layer string
+	}{
+		{"shop.header", "ACME Shop", "acme"},
+		{"shop.footer", "Thanks", ""},
+		{"shop.promo", "Sale", "acme"},
+	}
+	for _, test := range tests {
+		var tmpl, ok = overlay.Template(test.name)
+		if !ok {
+			t.Errorf("%s: not found", test.name)
+			continue
+		}
+		if text := tmpl.Node.Body.String(); text != test.text {
+			t.Errorf("%s: expected %q, got %q", test.name, test.text, text)
+		}
+		if layer := overlay.Layer(test.name); layer != test.layer {
+			t.Errorf("%s: expected layer %q, got %q", test.name, test.layer, layer)
+		}
+	}
+	if del, ok := overlay.DelTemplate("shop.badge", ""); !ok || del.Node.Body.String() != "acme badge" {
+		t.Errorf("expected the delegate to be overridden")
+	}
+	if len(overlay.Templates) != 4 {
+		t.Errorf("expected 4 templates, got %d", len(overlay.Templates))
+	}
+	if src, line, ok := overlay.TemplateSource("shop.header"); !ok || line != 3 || src != "{template .header}\n  ACME Shop\n{/template}" {
+		t.Errorf("unexpected source (line %d): %q", line, src)
+	}
+
+	// The base is unchanged, and layers stack.
+	if tmpl, _ := base.Template("shop.header"); tmpl.Node.Body.String() != "Shop" {
+		t.Errorf("expected the base to be unchanged")
+	}
+	var store = mustRegistry(t, `{namespace shop}
+
+{template .footer}
+  Store 42
+{/template}
+`)
+	var stacked = Overlay(overlay, "store42", store)
+	for name, layer := range map[string]string{"shop.header": "acme", "shop.footer": "store42"} {
+		if actual := stacked.Layer(name); actual != layer {
+			t.Errorf("%s: expected layer %q, got %q", name, layer, actual)
+		}
+	}
+}
@@ -4,9 +4,12 @@ package template
 import (
 	"fmt"
 	"log"
+	"sort"
 	"strings"
+	"unicode"
 
 	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/diag"
 )
 
 // Registry provides convenient access to a collection of parsed Soy templates.
@@ -14,29 +17,55 @@ type Registry struct {
 	SoyFiles  []*ast.SoyFileNode
 	Templates []Template
 
-	// sourceByTemplateName maps FQ template name to the input source it came from.
+	// sourceByTemplateName maps template ID to the input source it came from.
 	sourceByTemplateName map[string]string
+
+	// rangeByTemplateName maps template ID to its [start, end) offsets
+	// within the input source.
+	rangeByTemplateName map[string][2]int
+
+	// newlinesByTemplateName maps template ID to the offsets of the
+	// newlines in the input source it came from, once the source is stripped.
+	newlinesByTemplateName map[string][]int
+
+	// layerByTemplateName maps template ID to the name of the overlay layer
+	// that provided it, if any.
+	layerByTemplateName map[string]string
+
+	// checksumByID maps template ID to the checksum of its source.
+	checksumByID map[string]string
+
+	// delegatesByName maps the name of delegate templates to their indexes
+	// within Templates, in the order they were added.
+	delegatesByName map[string][]int
+
+	// lazy holds the files added by AddLazy, if any.
+	lazy *lazyFiles
 }
 
 // Add the given soy file node (and all contained templates) to this registry.
+// The errors returned are *diag.Diagnostic.
 func (r *Registry) Add(soyfile *ast.SoyFileNode) error {
 	if r.sourceByTemplateName == nil {
 		r.sourceByTemplateName = make(map[string]string)
+		r.rangeByTemplateName = make(map[string][2]int)
+		r.checksumByID = make(map[string]string)
 	}
 	var ns *ast.NamespaceNode
 	for _, node := range soyfile.Body {
 		switch node := node.(type) {
-		case *ast.SoyDocNode:
+		case *ast.SoyDocNode, *ast.DelPackageNode:
 			continue
 		case *ast.NamespaceNode:
 			ns = node
 		default:
-			return fmt.Errorf("expected namespace, found %v", node)
+			return registryError(soyfile.Name, textPosition(soyfile.Text, node.Position()),
+				"expected namespace, found %v", node)
 		}
 		break
 	}
 	if ns == nil {
-		return fmt.Errorf("namespace required")
+		return registryError(soyfile.Name, diag.Position{}, "namespace required")
 	}
 
 	r.SoyFiles = append(r.SoyFiles, soyfile)
@@ -52,31 +81,317 @@ func (r *Registry) Add(soyfile *ast.SoyFileNode) error {
 		// params, anyway).
 		sdn, ok := soyfile.Body[i-1].(*ast.SoyDocNode)
 		if !ok {
-			sdn = &ast.SoyDocNode{tn.Pos, nil}
+			sdn = &ast.SoyDocNode{tn.Pos, nil, "", false, ""}
+		}
+		if tn.Delegate {
+			if existing, ok := r.delTemplate(tn.Name, tn.Variant, tn.Priority, tn.Package); ok {
+				return registryError(soyfile.Name, textPosition(soyfile.Text, tn.Pos),
+					"delegate template %s (variant %q) already defined in %s",
+					tn.Name, tn.Variant, existing.Namespace.Name)
+			}
 		}
 		r.Templates = append(r.Templates, Template{sdn, tn, ns})
-		r.sourceByTemplateName[tn.Name] = soyfile.Text
+		r.indexDelegate(len(r.Templates) - 1)
+		r.sourceByTemplateName[tn.ID()] = soyfile.Text
+
+		// The template's source runs from the line of its SoyDoc to the line
+		// of the next node (or to the next node, if they share a line).
+		var start, end = lineStart(soyfile.Text, int(sdn.Pos)), len(soyfile.Text)
+		if i+1 < len(soyfile.Body) {
+			var next = int(soyfile.Body[i+1].Position())
+			if end = lineStart(soyfile.Text, next); end <= start {
+				end = next
+			}
+		}
+		r.rangeByTemplateName[tn.ID()] = [2]int{start, end}
+		r.checksumByID[tn.ID()] = checksum(soyfile.Text[start:end], tn)
 	}
 	return nil
 }
 
+// registryError returns an error diagnostic at the given position of the given
+// soy file.
+func registryError(file string, pos diag.Position, format string, args ...interface{}) *diag.Diagnostic {
+	return &diag.Diagnostic{
+		Severity: diag.Error,
+		Code:     "registry",
+		Message:  fmt.Sprintf(format, args...),
+		File:     file,
+		Range:    diag.Range{Start: pos},
+	}
+}
+
+// textPosition returns the line and column of the given offset of src.
+func textPosition(src string, pos ast.Pos) diag.Position {
+	if int(pos) > len(src) {
+		return diag.Position{}
+	}
+	return diag.Position{
+		Line: 1 + strings.Count(src[:pos], "\n"),
+		Col:  int(pos) - strings.LastIndex(src[:pos], "\n"),
+	}
+}
+
+// lineStart returns the offset of the start of the line containing pos.
+func lineStart(src string, pos int) int {
+	return strings.LastIndex(src[:pos], "\n") + 1
+}
+
 // Template allows lookup by (fully-qualified) template name.
 // The resulting template is returned and a boolean indicating if it was found.
+// Delegate templates are not found; see DelTemplate.
 func (r *Registry) Template(name string) (Template, bool) {
+	defer r.guard()()
+	r.loadNamespace(name)
 	for _, t := range r.Templates {
-		if t.Node.Name == name {
+		if t.Node.Name == name && !t.Node.Delegate {
+			return t, true
+		}
+	}
+	return Template{}, false
+}
+
+// indexDelegate indexes the template at the given index within Templates by
+// name, if it is a delegate.
+func (r *Registry) indexDelegate(i int) {
+	var tn = r.Templates[i].Node
+	if !tn.Delegate {
+		return
+	}
+	if r.delegatesByName == nil {
+		r.delegatesByName = make(map[string][]int)
+	}
+	r.delegatesByName[tn.Name] = append(r.delegatesByName[tn.Name], i)
+}
+
+// DelTemplate returns the delegate template of the given name to render for
+// the given variant, considering only those declared outside of any
+// {delpackage}.  If there are several, the one with the highest priority is
+// chosen.  If there is none for the variant, the default (no-variant) delegate
+// is returned instead.
+func (r *Registry) DelTemplate(name, variant string) (Template, bool) {
+	var t, ok, _ = r.ActiveDelTemplate(name, variant)
+	return t, ok
+}
+
+// ActiveDelTemplate is like DelTemplate, but also considers the delegates of
+// the given active delegate packages, which take priority over those declared
+// outside of any package.  An error is returned if delegates of several active
+// packages tie for the highest priority, since either may be rendered; it is a
+// *diag.Diagnostic at the first of them.
+func (r *Registry) ActiveDelTemplate(name, variant string, packages ...string) (Template, bool, error) {
+	defer r.guard()()
+	r.loadDelegate(name)
+	var active = func(pkg string) bool {
+		if pkg == "" {
+			return true
+		}
+		for _, p := range packages {
+			if p == pkg {
+				return true
+			}
+		}
+		return false
+	}
+	if t, ok, err := r.activeDelTemplate(name, variant, active); ok || err != nil || variant == "" {
+		return t, ok, err
+	}
+	return r.activeDelTemplate(name, "", active)
+}
+
+// activeDelTemplate returns the highest priority delegate template of the
+// given name and variant within an active package.
+func (r *Registry) activeDelTemplate(name, variant string, active func(pkg string) bool) (Template, bool, error) {
+	var result Template
+	var found, tied bool
+	for _, i := range r.delegatesByName[name] {
+		var t = r.Templates[i]
+		if t.Node.Variant != variant || !active(t.Node.Package) {
+			continue
+		}
+		switch {
+		case !found || t.Node.Priority > result.Node.Priority:
+			result, found, tied = t, true, false
+		case t.Node.Priority == result.Node.Priority:
+			tied = true
+		}
+	}
+	if tied {
+		var packages []string
+		for _, i := range r.delegatesByName[name] {
+			var t = r.Templates[i]
+			if t.Node.Variant == variant && t.Node.Priority == result.Node.Priority && active(t.Node.Package) {
+				packages = append(packages, t.Node.Package)
+			}
+		}
+		var d = registryError(r.fileName(result.Node), diag.Position{
+			Line: r.lineNumber(result.Node.ID(), result.Node),
+			Col:  r.columnNumber(result.Node.ID(), result.Node),
+		}, "delegate template %s (variant %q) is defined by several active packages: %s",
+			name, variant, strings.Join(packages, ", "))
+		d.Template = name
+		return Template{}, false, d
+	}
+	return result, found, nil
+}
+
+// Delegates returns the delegate templates of the given name, of every
+// variant and package.
+func (r *Registry) Delegates(name string) []Template {
+	defer r.guard()()
+	r.loadDelegate(name)
+	var result []Template
+	for _, i := range r.delegatesByName[name] {
+		result = append(result, r.Templates[i])
+	}
+	return result
+}
+
+// delTemplate returns the delegate template of the given name, variant,
+// priority, and package.
+func (r *Registry) delTemplate(name, variant string, priority int, pkg string) (Template, bool) {
+	for _, i := range r.delegatesByName[name] {
+		var t = r.Templates[i]
+		if t.Node.Variant == variant && t.Node.Priority == priority && t.Node.Package == pkg {
 			return t, true
 		}
 	}
 	return Template{}, false
 }
 
+// CacheableTemplates returns the templates that declared cacheable="true".
+func (r *Registry) CacheableTemplates() []Template {
+	r.loadAll()
+	var result []Template
+	for _, t := range r.Templates {
+		if t.Node.Cacheable {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// TemplateSource returns the source text of the template with the given ID
+// (see ast.TemplateNode.ID), from its SoyDoc through its closing tag, along
+// with the line number on which it begins.  It allows the template to be
+// displayed (e.g. in error pages) without access to the original files.
+func (r *Registry) TemplateSource(id string) (text string, line int, ok bool) {
+	defer r.guard()()
+	src, ok := r.sourceByTemplateName[id]
+	if !ok {
+		return "", 0, false
+	}
+	var rng = r.rangeByTemplateName[id]
+	text = strings.TrimRightFunc(src[rng[0]:rng[1]], unicode.IsSpace)
+	return text, 1 + strings.Count(src[:rng[0]], "\n"), true
+}
+
+// StripSource discards the source text and documentation of the templates
+// added so far, keeping only what is needed to render them.  This reduces the
+// memory used by services that load very many templates.
+//
+// Line numbers remain available for error messages, but TemplateSource no
+// longer finds the stripped templates, and generated javascript omits their
+// documentation.
+func (r *Registry) StripSource() {
+	if r.newlinesByTemplateName == nil {
+		r.newlinesByTemplateName = make(map[string][]int)
+	}
+	var newlinesBySource = make(map[string][]int)
+	for name, src := range r.sourceByTemplateName {
+		var newlines, ok = newlinesBySource[src]
+		if !ok {
+			for i := 0; i < len(src); i++ {
+				if src[i] == '\n' {
+					newlines = append(newlines, i)
+				}
+			}
+			newlinesBySource[src] = newlines
+		}
+		r.newlinesByTemplateName[name] = newlines
+		delete(r.sourceByTemplateName, name)
+		delete(r.rangeByTemplateName, name)
+	}
+	for _, soyfile := range r.SoyFiles {
+		soyfile.Text = ""
+		for _, node := range soyfile.Body {
+			if soydoc, ok := node.(*ast.SoyDocNode); ok {
+				soydoc.Desc = ""
+				soydoc.DeprecationNote = ""
+				for _, param := range soydoc.Params {
+					param.Desc = ""
+				}
+			}
+		}
+	}
+}
+
+// InternText deduplicates the raw text of the templates added so far, so
+// that nodes of identical text (e.g. shared headers and footers, or runs of
+// whitespace) share a single copy of it.  This reduces the memory used by
+// large bundles, and improves cache locality when rendering.  It returns the
+// number of bytes that are no longer referenced.
+func (r *Registry) InternText() int {
+	r.loadAll()
+	var saved int
+	var texts = make(map[string][]byte)
+	for _, t := range r.Templates {
+		ast.Walk(t.Node, func(node ast.Node) bool {
+			var text, ok = node.(*ast.RawTextNode)
+			if !ok || len(text.Text) == 0 {
+				return true
+			}
+			if existing, ok := texts[string(text.Text)]; !ok {
+				texts[string(text.Text)] = text.Text
+			} else if &existing[0] != &text.Text[0] {
+				saved += len(text.Text)
+				text.Text = existing
+			}
+			return true
+		})
+	}
+	return saved
+}
+
+// ColumnNumber computes the column number in the input source at which the
+// first token of the given node ends, within the template with the given ID
+// (see ast.TemplateNode.ID).  Columns are numbered from 1, in bytes.
+func (r *Registry) ColumnNumber(id string, node ast.Node) int {
+	defer r.guard()()
+	return r.columnNumber(id, node)
+}
+
+// columnNumber is ColumnNumber, for a guarded registry.
+func (r *Registry) columnNumber(id string, node ast.Node) int {
+	var pos = int(node.Position())
+	if newlines, ok := r.newlinesByTemplateName[id]; ok {
+		if i := sort.SearchInts(newlines, pos); i > 0 {
+			return pos - newlines[i-1]
+		}
+		return pos + 1
+	}
+	var src, ok = r.sourceByTemplateName[id]
+	if !ok {
+		return 0
+	}
+	return pos - strings.LastIndex(src[:pos], "\n")
+}
+
 // LineNumber computes the line number in the input source for the given node
-// within the given template.
-func (r *Registry) LineNumber(templateName string, node ast.Node) int {
-	var src, ok = r.sourceByTemplateName[templateName]
+// within the template with the given ID (see ast.TemplateNode.ID).
+func (r *Registry) LineNumber(id string, node ast.Node) int {
+	defer r.guard()()
+	return r.lineNumber(id, node)
+}
+
+// lineNumber is LineNumber, for a guarded registry.
+func (r *Registry) lineNumber(id string, node ast.Node) int {
+	if newlines, ok := r.newlinesByTemplateName[id]; ok {
+		return 1 + sort.SearchInts(newlines, int(node.Position()))
+	}
+	var src, ok = r.sourceByTemplateName[id]
 	if !ok {
-		log.Println("template not found:", templateName)
+		log.Println("template not found:", id)
 		return 0
 	}
 	return 1 + strings.Count(src[:node.Position()], "\n")
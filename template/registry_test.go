@@ -0,0 +1,183 @@
+package template
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/diag"
+	"github.com/harrisonzhao/soy/parse"
+)
+
+func TestStripSource(t *testing.T) {
+	var reg = mustRegistry(t, `{namespace test}
+
+/**
+ * Greets someone.
+ * @param name the name
+ */
+{template .hello}
+  Hello {$name}
+{/template}
+`)
+	var tmpl, _ = reg.Template("test.hello")
+	var print = tmpl.Node.Body.Nodes[1]
+	if _, ok := print.(*ast.PrintNode); !ok {
+		t.Fatalf("expected print node, got %T", print)
+	}
+
+	var text, line, ok = reg.TemplateSource("test.hello")
+	if !ok || line != 3 || text != `/**
+ * Greets someone.
+ * @param name the name
+ */
+{template .hello}
+  Hello {$name}
+{/template}` {
+		t.Errorf("unexpected source (line %d): %q", line, text)
+	}
+	if n := reg.LineNumber("test.hello", print); n != 8 {
+		t.Errorf("expected line 8, got %d", n)
+	}
+	if n := reg.ColumnNumber("test.hello", print); n != 15 {
+		t.Errorf("expected column 15, got %d", n)
+	}
+
+	reg.StripSource()
+	if _, _, ok = reg.TemplateSource("test.hello"); ok {
+		t.Error("expected source to be stripped")
+	}
+	if n := reg.LineNumber("test.hello", print); n != 8 {
+		t.Errorf("expected line 8 after stripping, got %d", n)
+	}
+	if n := reg.ColumnNumber("test.hello", print); n != 15 {
+		t.Errorf("expected column 15 after stripping, got %d", n)
+	}
+	if reg.SoyFiles[0].Text != "" || tmpl.Doc.Desc != "" || tmpl.Doc.Params[0].Desc != "" {
+		t.Error("expected source text and documentation to be stripped")
+	}
+	if tmpl.Doc.Params[0].Name != "name" {
+		t.Error("expected params to be retained")
+	}
+}
+
+func TestRegistryDiagnostics(t *testing.T) {
+	var reg = Registry{}
+	var tree, err = parse.SoyFile("a.soy", "{namespace a}\n{deltemplate x.y}a{/deltemplate}\n", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = reg.Add(tree); err != nil {
+		t.Fatal(err)
+	}
+	if tree, err = parse.SoyFile("c.soy", "{namespace c}\n\n  {deltemplate x.y}c{/deltemplate}\n", nil); err != nil {
+		t.Fatal(err)
+	}
+	err = reg.Add(tree)
+	var d, ok = err.(*diag.Diagnostic)
+	if !ok || d.File != "c.soy" || d.Range.Start.Line != 3 || d.Range.Start.Col == 0 {
+		t.Errorf("expected a diagnostic at c.soy:3, got %#v", err)
+	}
+
+	reg = Registry{}
+	for _, src := range []struct{ name, text string }{
+		{"p.soy", "{delpackage p}\n{namespace p}\n{deltemplate x.y}p{/deltemplate}\n"},
+		{"q.soy", "{delpackage q}\n{namespace q}\n{deltemplate x.y}q{/deltemplate}\n"},
+	} {
+		if tree, err = parse.SoyFile(src.name, src.text, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err = reg.Add(tree); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, _, err = reg.ActiveDelTemplate("x.y", "", "p", "q")
+	d, ok = err.(*diag.Diagnostic)
+	if !ok || d.File != "p.soy" || d.Range.Start.Line != 3 {
+		t.Errorf("expected a diagnostic at p.soy:3, got %#v", err)
+	}
+}
+
+func TestAddLazy(t *testing.T) {
+	var files = map[string]string{
+		"a": `{namespace a}
+{template .one}1{/template}
+{deltemplate shared}a{/deltemplate}`,
+		"b": `{namespace b}
+{template .two}2{/template}`,
+		"c": `{namespace c}
+{deltemplate shared variant="'c'"}c{/deltemplate}`,
+	}
+	var reg Registry
+	var parsed []string
+	for _, name := range []string{"a", "b", "c"} {
+		var name = name
+		reg.AddLazy(files[name], func() (*ast.SoyFileNode, error) {
+			parsed = append(parsed, name)
+			return parse.SoyFile(name, files[name], nil)
+		})
+	}
+
+	if _, ok := reg.Template("b.two"); !ok {
+		t.Error("expected to find b.two")
+	}
+	if _, ok := reg.Template("b.missing"); ok {
+		t.Error("expected not to find b.missing")
+	}
+	if tmpl, ok := reg.DelTemplate("shared", "c"); !ok || tmpl.Node.Variant != "c" {
+		t.Errorf("expected to find the c variant of shared, got %v", tmpl.Node)
+	}
+	if !reflect.DeepEqual(parsed, []string{"b", "a", "c"}) {
+		t.Errorf("expected files to be parsed on first use, got %v", parsed)
+	}
+	if err := reg.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 3 || len(reg.Templates) != 4 {
+		t.Errorf("expected each file to be parsed once, got %v and %d templates", parsed, len(reg.Templates))
+	}
+}
+
+func TestInternText(t *testing.T) {
+	var reg = mustRegistry(t, `{namespace test}
+
+{template .a}
+  <header>Welcome</header>{sp}{$x}{sp}<footer>Bye</footer>
+{/template}
+
+{template .b}
+  <header>Welcome</header>{sp}<footer>Bye</footer>
+{/template}
+`)
+	var texts = func(name string) []*ast.RawTextNode {
+		var tmpl, _ = reg.Template(name)
+		var result []*ast.RawTextNode
+		ast.Walk(tmpl.Node, func(node ast.Node) bool {
+			if text, ok := node.(*ast.RawTextNode); ok {
+				result = append(result, text)
+			}
+			return true
+		})
+		return result
+	}
+	var a, b = texts("test.a"), texts("test.b")
+	if len(a) != 4 || len(b) != 3 {
+		t.Fatalf("unexpected text nodes: %v, %v", a, b)
+	}
+
+	var expected = len("<header>Welcome</header>") + len(" ")*2 + len("<footer>Bye</footer>")
+	if saved := reg.InternText(); saved != expected {
+		t.Errorf("expected %d bytes saved, got %d", expected, saved)
+	}
+	for i, pair := range [][2]*ast.RawTextNode{{a[0], b[0]}, {a[1], a[2]}, {a[1], b[1]}, {a[3], b[2]}} {
+		if &pair[0].Text[0] != &pair[1].Text[0] {
+			t.Errorf("%d: expected %q to be shared", i, pair[0].Text)
+		}
+	}
+	if string(a[0].Text) != "<header>Welcome</header>" || string(b[2].Text) != "<footer>Bye</footer>" {
+		t.Errorf("unexpected text: %q, %q", a[0].Text, b[2].Text)
+	}
+	if saved := reg.InternText(); saved != 0 {
+		t.Errorf("expected nothing more to be saved, got %d", saved)
+	}
+}
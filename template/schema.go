@@ -0,0 +1,226 @@
+package template
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/data"
+)
+
+// SchemaDialect identifies the version of JSON Schema used by Schema.
+const SchemaDialect = "http://json-schema.org/draft-07/schema#"
+
+// Schema is a JSON Schema describing the data expected by a template, or a
+// part of it.  It marshals to JSON as the schema itself.
+type Schema struct {
+	Dialect     string             `json:"$schema,omitempty"`     // SchemaDialect, in the outermost schema
+	Type        string             `json:"type,omitempty"`        // "object", "array", or "" for any value
+	Description string             `json:"description,omitempty"` // documentation from the SoyDoc
+	Properties  map[string]*Schema `json:"properties,omitempty"`  // schemas of an object's keys
+	Required    []string           `json:"required,omitempty"`    // keys that an object must have
+	Items       *Schema            `json:"items,omitempty"`       // schema of an array's elements
+}
+
+// Schema returns a schema for the data expected by the template: an object
+// with a property for each declared param, of which the required params are
+// required, so that teams may agree on the payloads of templates, and check
+// data against them.
+//
+// Soy params are untyped, so their types are inferred from their use within
+// the template: a param whose keys are accessed must be an object with those
+// keys (at least), and a param iterated by {foreach} must be an array.  Params
+// that are only printed or passed to functions may be any value, as may those
+// passed to other templates.
+func (t Template) Schema() *Schema {
+	var schema = &Schema{
+		Dialect:     SchemaDialect,
+		Type:        "object",
+		Description: t.Doc.Desc,
+		Properties:  make(map[string]*Schema),
+	}
+	var env = make(map[string]*Schema)
+	for _, param := range t.Doc.Params {
+		var prop = &Schema{Description: param.Desc}
+		schema.Properties[param.Name] = prop
+		env[param.Name] = prop
+		if !param.Optional {
+			schema.Required = append(schema.Required, param.Name)
+		}
+	}
+	inferSchema(t.Node.Body, env)
+	return schema
+}
+
+// inferSchema refines the schemas of the variables in scope according to
+// their use within the given node.  Variables that are in scope but whose
+// schema is unknown (e.g. loop indices) map to nil.
+func inferSchema(node ast.Node, env map[string]*Schema) {
+	switch node := node.(type) {
+	case *ast.ListNode:
+		env = copyEnv(env) // variables declared by {let} are scoped to the block
+		for _, child := range node.Nodes {
+			inferSchema(child, env)
+		}
+		return
+	case *ast.LetValueNode:
+		inferSchema(node.Expr, env)
+		env[node.Name] = refSchema(node.Expr, env)
+		return
+	case *ast.LetContentNode:
+		inferSchema(node.Body, env)
+		env[node.Name] = nil
+		return
+	case *ast.ForNode:
+		inferSchema(node.List, env)
+		var inner = copyEnv(env)
+		inner[node.Var] = nil
+		if list := refSchema(node.List, env); list != nil && setType(list, "array") {
+			if list.Items == nil {
+				list.Items = &Schema{}
+			}
+			inner[node.Var] = list.Items
+		}
+		if node.IndexVar != "" {
+			inner[node.IndexVar] = nil
+		}
+		inferSchema(node.Body, inner)
+		if node.IfEmpty != nil {
+			inferSchema(node.IfEmpty, env)
+		}
+		return
+	case *ast.DataRefNode:
+		refSchema(node, env)
+	}
+	if parent, ok := node.(ast.ParentNode); ok {
+		for _, child := range parent.Children() {
+			if child != nil {
+				inferSchema(child, env)
+			}
+		}
+	}
+}
+
+// refSchema returns the schema of the value referred to by the given
+// expression, if it is a reference to a variable in scope, refining the
+// schemas along the way according to the keys and indices accessed.  It
+// returns nil if the schema is unknown.
+func refSchema(expr ast.Node, env map[string]*Schema) *Schema {
+	var ref, ok = expr.(*ast.DataRefNode)
+	if !ok {
+		return nil
+	}
+	var schema = env[ref.Key]
+	for _, access := range ref.Access {
+		if schema == nil {
+			return nil
+		}
+		switch access := access.(type) {
+		case *ast.DataRefKeyNode:
+			if !setType(schema, "object") {
+				return nil
+			}
+			if schema.Properties == nil {
+				schema.Properties = make(map[string]*Schema)
+			}
+			if schema.Properties[access.Key] == nil {
+				schema.Properties[access.Key] = &Schema{}
+			}
+			schema = schema.Properties[access.Key]
+		case *ast.DataRefIndexNode:
+			if !setType(schema, "array") {
+				return nil
+			}
+			if schema.Items == nil {
+				schema.Items = &Schema{}
+			}
+			schema = schema.Items
+		default:
+			// An expression may index either an object or an array.
+			return nil
+		}
+	}
+	return schema
+}
+
+// setType sets the type of the given schema, returning false if it already
+// has a different type.
+func setType(schema *Schema, typ string) bool {
+	if schema.Type == "" {
+		schema.Type = typ
+	}
+	return schema.Type == typ
+}
+
+func copyEnv(env map[string]*Schema) map[string]*Schema {
+	var result = make(map[string]*Schema, len(env))
+	for k, v := range env {
+		result[k] = v
+	}
+	return result
+}
+
+// Validate returns an error describing the first way in which the given value
+// does not conform to the schema, or nil if it conforms.  As in templates,
+// null is accepted in place of any value.
+func (s *Schema) Validate(value data.Value) error {
+	return s.validate("", value)
+}
+
+func (s *Schema) validate(path string, value data.Value) error {
+	if _, ok := value.(data.Null); ok {
+		return nil
+	}
+	switch s.Type {
+	case "object":
+		var m, ok = value.(data.Map)
+		if !ok {
+			return schemaError(path, "expected an object, got %T", value)
+		}
+		for _, key := range s.Required {
+			if _, ok := m[key]; !ok {
+				return schemaError(path, "missing required key %q", key)
+			}
+		}
+		var keys = make([]string, 0, len(s.Properties))
+		for key := range s.Properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if val, ok := m[key]; ok {
+				if err := s.Properties[key].validate(joinPath(path, key), val); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		var list, ok = value.(data.List)
+		if !ok {
+			return schemaError(path, "expected an array, got %T", value)
+		}
+		if s.Items != nil {
+			for i, item := range list {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func schemaError(path, format string, args ...interface{}) error {
+	var msg = fmt.Sprintf(format, args...)
+	if path == "" {
+		return fmt.Errorf("%s", msg)
+	}
+	return fmt.Errorf("%s: %s", path, msg)
+}
@@ -0,0 +1,68 @@
+package template
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/harrisonzhao/soy/data"
+)
+
+func TestSchema(t *testing.T) {
+	var reg = mustRegistry(t, `{namespace test}
+
+/**
+ * Lists orders.
+ * @param user The signed-in user.
+ * @param orders
+ * @param? note
+ */
+{template .orders}
+  Hello {$user.name.first}
+  {foreach $order in $orders}
+    {let $items: $order.items /}
+    {$order.id}: {$items.0.sku}
+  {/foreach}
+  {$note}
+{/template}
+`)
+	var tmpl, _ = reg.Template("test.orders")
+	var schema = tmpl.Schema()
+	actual, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected = `{"$schema":"http://json-schema.org/draft-07/schema#","type":"object",` +
+		`"description":"Lists orders.","properties":{` +
+		`"note":{},` +
+		`"orders":{"type":"array","items":{"type":"object","properties":{` +
+		`"id":{},"items":{"type":"array","items":{"type":"object","properties":{"sku":{}}}}}}},` +
+		`"user":{"type":"object","description":"The signed-in user.",` +
+		`"properties":{"name":{"type":"object","properties":{"first":{}}}}}},` +
+		`"required":["user","orders"]}`
+	if string(actual) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+
+	var tests = []struct {
+		data interface{}
+		err  string
+	}{
+		{map[string]interface{}{
+			"user":   map[string]interface{}{"name": map[string]string{"first": "Rob"}},
+			"orders": []interface{}{map[string]interface{}{"id": 1, "items": nil}},
+		}, ""},
+		{map[string]interface{}{"user": nil}, `missing required key "orders"`},
+		{map[string]interface{}{"user": nil, "orders": []int{1}}, "orders[0]: expected an object, got data.Int"},
+		{map[string]interface{}{"user": map[string]string{"name": "Rob"}, "orders": nil},
+			"user.name: expected an object, got data.String"},
+	}
+	for _, test := range tests {
+		var err = schema.Validate(data.New(test.data))
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%v: %v", test.data, err)
+		case test.err != "" && (err == nil || err.Error() != test.err):
+			t.Errorf("%v: expected error %q, got %v", test.data, test.err, err)
+		}
+	}
+}
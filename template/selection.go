@@ -0,0 +1,362 @@
+package template
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/harrisonzhao/soy/ast"
+)
+
+// Selection is the set of fields of a template's data that may be read by
+// rendering it, as a tree of the keys accessed.  A field without Fields is
+// read entirely (e.g. printed, or passed to a function).  Lists are
+// transparent, as in GraphQL: the selection of a list is that of its items.
+type Selection struct {
+	Fields map[string]*Selection // the fields read, by key
+
+	whole bool // the value is read entirely, regardless of Fields
+	read  bool // the value is read at all
+}
+
+// Selection returns the fields of the data of the template with the given
+// name that may be read by rendering it, including those read by the
+// templates it calls, so that backends may fetch only those fields from a
+// JSON or GraphQL API (see Selection.Paths and Selection.GraphQL).  It
+// returns false if the template is not found.
+//
+// The selection is conservative: a value is read entirely if it is used as a
+// whole (e.g. printed, or passed to a function), accessed by a computed key,
+// or passed to a template that is not found or is called recursively.
+func (r *Registry) Selection(templateName string) (*Selection, bool) {
+	var t, ok = r.Template(templateName)
+	if !ok {
+		return nil, false
+	}
+	var s = selector{r, make(map[*ast.TemplateNode]*Selection)}
+	return s.template(t), true
+}
+
+// Paths returns the dotted paths of the fields read entirely, in sorted
+// order, e.g. "user.name.first" and "orders.items.sku".  Their ancestors are
+// implied.
+func (s *Selection) Paths() []string {
+	var paths []string
+	s.paths("", &paths)
+	sort.Strings(paths)
+	return paths
+}
+
+func (s *Selection) paths(prefix string, paths *[]string) {
+	for key, field := range s.Fields {
+		if len(field.Fields) == 0 {
+			*paths = append(*paths, prefix+key)
+		} else {
+			field.paths(prefix+key+".", paths)
+		}
+	}
+}
+
+// GraphQL returns the selection as a GraphQL selection set, e.g.
+//
+//	{ orders { id items { sku } } user { name { first } } }
+//
+// with the fields in sorted order.  It returns "" if no fields are read.
+func (s *Selection) GraphQL() string {
+	if len(s.Fields) == 0 {
+		return ""
+	}
+	var buf strings.Builder
+	buf.WriteString("{")
+	for _, key := range s.keys() {
+		buf.WriteString(" " + key)
+		if sub := s.Fields[key].GraphQL(); sub != "" {
+			buf.WriteString(" " + sub)
+		}
+	}
+	buf.WriteString(" }")
+	return buf.String()
+}
+
+func (s *Selection) keys() []string {
+	var keys = make([]string, 0, len(s.Fields))
+	for key := range s.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// field returns the selection of the given field, which is read, adding it
+// if necessary.
+func (s *Selection) field(key string) *Selection {
+	if s.Fields == nil {
+		s.Fields = make(map[string]*Selection)
+	}
+	var field = s.Fields[key]
+	if field == nil {
+		field = &Selection{}
+		s.Fields[key] = field
+	}
+	field.read = true
+	return field
+}
+
+// merge adds the fields of the given selection, of a value passed to another
+// template, to s.  A nil selection (of an unknown template) reads the value
+// entirely.
+func (s *Selection) merge(other *Selection) {
+	s.read = true
+	if other == nil || other.whole || len(other.Fields) == 0 {
+		s.whole = true
+		return
+	}
+	for key, field := range other.Fields {
+		s.field(key).merge(field)
+	}
+}
+
+// prune removes the fields of the values read entirely, and the params
+// that are not read.
+func (s *Selection) prune() {
+	if s.whole {
+		s.Fields = nil
+		return
+	}
+	for key, field := range s.Fields {
+		if !field.read {
+			delete(s.Fields, key)
+			continue
+		}
+		field.prune()
+	}
+}
+
+// selector computes the selections of templates, following calls.
+type selector struct {
+	reg  *Registry
+	done map[*ast.TemplateNode]*Selection // nil while in progress
+}
+
+// template returns the selection of the given template, or nil if it is
+// being computed (i.e. it is called recursively).
+func (s selector) template(t Template) *Selection {
+	if sel, ok := s.done[t.Node]; ok {
+		return sel
+	}
+	s.done[t.Node] = nil
+	var root = &Selection{Fields: make(map[string]*Selection)}
+	var env = make(map[string]*Selection)
+	for _, param := range t.Doc.Params {
+		var sel = &Selection{}
+		root.Fields[param.Name] = sel
+		env[param.Name] = sel
+	}
+	s.walk(t.Node.Body, root, env)
+	root.prune()
+	s.done[t.Node] = root
+	return root
+}
+
+// walk adds the fields read by the given node to the selections of the
+// variables in scope, which map to nil if they are not part of the data
+// (e.g. loop indices).  Values used by expressions are read entirely.
+func (s selector) walk(node ast.Node, root *Selection, env map[string]*Selection) {
+	switch node := node.(type) {
+	case nil:
+		return
+	case *ast.ListNode:
+		env = copySelectionEnv(env) // variables declared by {let} are scoped to the block
+		for _, child := range node.Nodes {
+			s.walk(child, root, env)
+		}
+		return
+	case *ast.LetValueNode:
+		env[node.Name] = s.alias(node.Expr, root, env)
+		return
+	case *ast.LetContentNode:
+		s.walk(node.Body, root, env)
+		env[node.Name] = nil
+		return
+	case *ast.ForNode:
+		var inner = copySelectionEnv(env)
+		inner[node.Var] = s.alias(node.List, root, env)
+		if node.IndexVar != "" {
+			inner[node.IndexVar] = nil
+		}
+		s.walk(node.Body, root, inner)
+		s.walk(node.IfEmpty, root, env)
+		return
+	case *ast.IfCondNode:
+		s.test(node.Cond, root, env)
+		s.walk(node.Body, root, env)
+		return
+	case *ast.CallNode:
+		s.call(node, root, env)
+		return
+	case *ast.DataRefNode:
+		if sel := s.ref(node, root, env); sel != nil {
+			sel.whole = true
+		}
+		return
+	case *ast.NotNode:
+		s.test(node.Arg, root, env)
+		return
+	case *ast.AndNode:
+		s.test(node.Arg1, root, env)
+		s.test(node.Arg2, root, env)
+		return
+	case *ast.OrNode:
+		s.test(node.Arg1, root, env)
+		s.test(node.Arg2, root, env)
+		return
+	case *ast.TernNode:
+		s.test(node.Arg1, root, env)
+		s.walk(node.Arg2, root, env)
+		s.walk(node.Arg3, root, env)
+		return
+	case *ast.FunctionNode:
+		if node.Name == "isNonnull" || node.Name == "length" {
+			for _, arg := range node.Args {
+				s.test(arg, root, env)
+			}
+			return
+		}
+	}
+	if parent, ok := node.(ast.ParentNode); ok {
+		for _, child := range parent.Children() {
+			s.walk(child, root, env)
+		}
+	}
+}
+
+// test adds the fields read by the given expression, whose value is only
+// tested (e.g. for truthiness), so a data reference reads only its presence.
+func (s selector) test(expr ast.Node, root *Selection, env map[string]*Selection) {
+	switch expr := expr.(type) {
+	case *ast.DataRefNode:
+		s.ref(expr, root, env)
+	case *ast.NotNode:
+		s.test(expr.Arg, root, env)
+	default:
+		s.walk(expr, root, env)
+	}
+}
+
+// alias returns the selection of the value of the given expression, if it is
+// a data reference, to bind to a variable.  Otherwise, the expression's
+// value is not part of the data, and alias returns nil.
+func (s selector) alias(expr ast.Node, root *Selection, env map[string]*Selection) *Selection {
+	if ref, ok := expr.(*ast.DataRefNode); ok {
+		return s.ref(ref, root, env)
+	}
+	s.walk(expr, root, env)
+	return nil
+}
+
+// ref returns the selection of the value referred to by the given data
+// reference, adding the keys accessed along the way.  It returns nil if the
+// value is not part of the data, or is accessed by a computed key (in which
+// case the value accessed is read entirely).
+func (s selector) ref(ref *ast.DataRefNode, root *Selection, env map[string]*Selection) *Selection {
+	var sel = env[ref.Key]
+	if sel != nil {
+		sel.read = true
+	}
+	for _, access := range ref.Access {
+		if sel == nil {
+			s.walk(access, root, env)
+			continue
+		}
+		switch access := access.(type) {
+		case *ast.DataRefKeyNode:
+			sel = sel.field(access.Key)
+		case *ast.DataRefExprNode:
+			switch arg := access.Arg.(type) {
+			case *ast.IntNode:
+				// Lists are transparent.
+			case *ast.StringNode:
+				sel = sel.field(arg.Value)
+			default:
+				s.walk(arg, root, env)
+				sel.whole = true
+				sel = nil
+			}
+		}
+	}
+	return sel
+}
+
+// call adds the fields read by the templates called by the given call from
+// the data passed to them.
+func (s selector) call(node *ast.CallNode, root *Selection, env map[string]*Selection) {
+	var callees []*Selection
+	if node.Delegate {
+		for _, t := range s.reg.Delegates(node.Name) {
+			callees = append(callees, s.template(t))
+		}
+		if len(callees) == 0 {
+			callees = append(callees, nil)
+		}
+	} else {
+		var t, ok = s.reg.Template(node.Name)
+		if ok {
+			callees = append(callees, s.template(t))
+		} else {
+			callees = append(callees, nil)
+		}
+	}
+	s.walk(node.Variant, root, env)
+	s.walk(node.Key, root, env)
+
+	// The data passed to the callees, other than the explicit params.
+	var data *Selection
+	switch {
+	case node.AllData:
+		data = root
+	case node.Data != nil:
+		data = s.alias(node.Data, root, env)
+	}
+	var params = make(map[string]bool)
+	for _, param := range node.Params {
+		switch param := param.(type) {
+		case *ast.CallParamValueNode:
+			params[param.Key] = true
+			var value = s.alias(param.Value, root, env)
+			if value == nil {
+				continue
+			}
+			for _, callee := range callees {
+				if callee == nil {
+					value.whole = true
+				} else if field, ok := callee.Fields[param.Key]; ok {
+					value.merge(field)
+				}
+			}
+		case *ast.CallParamContentNode:
+			params[param.Key] = true
+			s.walk(param.Content, root, env)
+		}
+	}
+	if data == nil {
+		return
+	}
+	for _, callee := range callees {
+		if callee == nil {
+			data.whole = true
+			continue
+		}
+		for key, field := range callee.Fields {
+			if !params[key] {
+				data.field(key).merge(field)
+			}
+		}
+	}
+}
+
+func copySelectionEnv(env map[string]*Selection) map[string]*Selection {
+	var result = make(map[string]*Selection, len(env))
+	for k, v := range env {
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,98 @@
+package template
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelection(t *testing.T) {
+	var reg = mustRegistry(t, `{namespace test}
+
+/**
+ * @param user
+ * @param orders
+ * @param? note
+ * @param? unused
+ */
+{template .page}
+  {if $note}<p>{call .note data="all" /}</p>{/if}
+  Hello {$user.name.first}
+  {foreach $order in $orders}
+    {let $items: $order.items /}
+    {$order.id}: {length($items)}
+    {call .item}{param item: $items[0] /}{param note kind="text"}{$user.name.last}{/param}{/call}
+  {/foreach}
+  {call .address data="$user.address" /}
+  {$user.tags[$note]}
+{/template}
+
+/** @param note */
+{template .note}
+  {$note}
+{/template}
+
+/**
+ * @param item
+ * @param note
+ */
+{template .item}
+  {$item.sku} {$item.price.amount} {$note}
+{/template}
+
+/**
+ * @param street
+ * @param? children
+ */
+{template .address}
+  {$street}
+  {foreach $child in $children}{call .address data="$child" /}{/foreach}
+{/template}
+`)
+	var sel, ok = reg.Selection("test.page")
+	if !ok {
+		t.Fatal("template not found")
+	}
+	var expected = []string{
+		"note",
+		"orders.id",
+		"orders.items.price.amount",
+		"orders.items.sku",
+		"user.address.children",
+		"user.address.street",
+		"user.name.first",
+		"user.name.last",
+		"user.tags",
+	}
+	if actual := sel.Paths(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected paths:\n%q\ngot:\n%q", expected, actual)
+	}
+
+	var graphql = "{ note orders { id items { price { amount } sku } }" +
+		" user { address { children street } name { first last } tags } }"
+	if actual := sel.GraphQL(); actual != graphql {
+		t.Errorf("expected GraphQL:\n%s\ngot:\n%s", graphql, actual)
+	}
+
+	if _, ok = reg.Selection("test.missing"); ok {
+		t.Error("expected a missing template to be reported")
+	}
+}
+
+func TestSelectionWhole(t *testing.T) {
+	var reg = mustRegistry(t, `{namespace test}
+
+/**
+ * @param user
+ * @param items
+ */
+{template .whole}
+  {if $user.admin and not $user.banned}{toJson($user)}{/if}
+  {call test.unknown}{param items: $items /}{/call}
+  {$user.name.first}
+{/template}
+`)
+	var sel, _ = reg.Selection("test.whole")
+	if actual, expected := sel.Paths(), []string{"items", "user"}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
@@ -0,0 +1,70 @@
+package template
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/harrisonzhao/soy/ast"
+)
+
+// Stats describes the size of a template's parse tree.
+type Stats struct {
+	Template string // the template's ID (see ast.TemplateNode.ID)
+	Nodes    int    // number of nodes, including its SoyDoc
+	Bytes    int    // approximate memory used by the nodes and their text
+}
+
+// Stats returns the size of each template's parse tree, largest first.  It
+// helps to find generated templates that bloat memory use and startup time.
+//
+// Memory use is estimated from the size of each node and the strings and
+// slices it refers to; allocator overhead is not counted.
+func (r *Registry) Stats() []Stats {
+	r.loadAll()
+	var result []Stats
+	for _, t := range r.Templates {
+		var stats = Stats{Template: t.Node.ID()}
+		for _, root := range []ast.Node{t.Doc, t.Node} {
+			ast.Walk(root, func(node ast.Node) bool {
+				stats.Nodes++
+				stats.Bytes += nodeBytes(node)
+				return true
+			})
+		}
+		result = append(result, stats)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Bytes > result[j].Bytes
+	})
+	return result
+}
+
+// nodeBytes estimates the memory used by the given node itself: its struct,
+// and the contents of the strings, slices, and maps held in its fields.  Nodes
+// referred to by its fields are not included.
+func nodeBytes(node ast.Node) int {
+	var val = reflect.ValueOf(node)
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	var size = int(val.Type().Size())
+	if val.Kind() != reflect.Struct {
+		return size
+	}
+	for i := 0; i < val.NumField(); i++ {
+		switch field := val.Field(i); field.Kind() {
+		case reflect.String:
+			size += field.Len()
+		case reflect.Slice:
+			size += field.Cap() * int(field.Type().Elem().Size())
+		case reflect.Map:
+			for _, key := range field.MapKeys() {
+				size += int(key.Type().Size()) + int(field.Type().Elem().Size())
+				if key.Kind() == reflect.String {
+					size += key.Len()
+				}
+			}
+		}
+	}
+	return size
+}
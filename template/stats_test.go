@@ -0,0 +1,37 @@
+package template
+
+import "testing"
+
+func TestStats(t *testing.T) {
+	var reg = mustRegistry(t, `{namespace test}
+
+/** @param x */
+{template .small}
+  {$x}
+{/template}
+
+/** @param x A long description of the parameter, which takes up some space. */
+{template .large}
+  {if $x}
+    Some text that takes up a good deal more space than the other template.
+  {else}
+    {$x + 1}
+  {/if}
+{/template}`)
+
+	var stats = reg.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("expected stats for 2 templates, got %v", stats)
+	}
+	var large, small = stats[0], stats[1]
+	if large.Template != "test.large" || small.Template != "test.small" {
+		t.Fatalf("expected the largest template first, got %v", stats)
+	}
+	// small: soydoc, param, template, list, print, dataref
+	if small.Nodes != 6 {
+		t.Errorf("expected 6 nodes, got %v", small)
+	}
+	if large.Nodes <= small.Nodes || large.Bytes <= small.Bytes || small.Bytes <= 0 {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+}
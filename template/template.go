@@ -1,6 +1,11 @@
 package template
 
-import "github.com/harrisonzhao/soy/ast"
+import (
+	"time"
+
+	"github.com/harrisonzhao/soy/ast"
+	"github.com/harrisonzhao/soy/data"
+)
 
 // Template is a Soy template's parse tree, including the relevant context
 // (preceeding soydoc and namespace).
@@ -9,3 +14,28 @@ type Template struct {
 	Node      *ast.TemplateNode  // this template's node
 	Namespace *ast.NamespaceNode // this template's namespace
 }
+
+// Cacheable reports whether the template declared cacheable="true", and for
+// how long its output may be cached (zero if unbounded).
+func (t Template) Cacheable() (cacheable bool, ttl time.Duration) {
+	return t.Node.Cacheable, t.Node.TTL
+}
+
+// Deprecated reports whether the template's SoyDoc marked it @deprecated,
+// along with the accompanying note (e.g. what to use instead), if any.
+func (t Template) Deprecated() (deprecated bool, note string) {
+	return t.Doc.Deprecated, t.Doc.DeprecationNote
+}
+
+// Kind returns the kind of the template's output: the kind given by its
+// "kind" attribute, or else text if autoescaping is off for the template, and
+// HTML otherwise.
+func (t Template) Kind() data.ContentKind {
+	if t.Node.Kind != "" {
+		return t.Node.Kind
+	}
+	if autoescapeMode(t) == ast.AutoescapeOff {
+		return data.KindText
+	}
+	return data.KindHTML
+}